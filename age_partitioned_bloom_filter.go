@@ -0,0 +1,709 @@
+/*
+Implements the age-partitioned Bloom filter, answering "was this element seen recently"
+queries over a sliding window with a bounded memory.
+
+Age-partitioned Bloom filter: k+l slices of m bits each, ordered from the newest to the
+oldest. An element sets one bit in each of the k newest slices, with a hash function per
+slice, and it's found if it has its bit set in k consecutive slices. After each
+generation, i.e. g insertions or a fixed duration, the oldest slice is cleared and reused
+as the newest one, so an element is remembered for l full generations, after which its
+bits leave the filter one slice per generation. Refer: https://arxiv.org/abs/2001.03147
+*/
+package gostatix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// apbfInsertScript inserts an element in the age-partitioned Bloom filter whose slices
+// are held by the string at KEYS[1] and whose generation state is held by the hash at
+// KEYS[2]. ARGV holds the number of slices, the number of hashes, the number of bytes of
+// a slice, the number of insertions of a generation, 1 if the generations are timed, the
+// generation of the current time and the offsets of the bit of the element in each slice.
+var apbfInsertScript = redis.NewScript(`
+	local n = tonumber(ARGV[1])
+	local k = tonumber(ARGV[2])
+	local size = tonumber(ARGV[3])
+	local generationSize = tonumber(ARGV[4])
+	local target = tonumber(ARGV[6])
+	local state = redis.call('HMGET', KEYS[2], 'base', 'count', 'generation')
+	local base = tonumber(state[1] or 0)
+	local count = tonumber(state[2] or 0)
+	local generation = tonumber(state[3] or target)
+	local function shift(s)
+		base = (base + n - s % n) % n
+		local zeros = string.rep('\0', size)
+		for i = 0, math.min(s, n) - 1 do
+			redis.call('SETRANGE', KEYS[1], ((base + i) % n) * size, zeros)
+		end
+		count = 0
+	end
+	if ARGV[5] == '1' and target > generation then
+		shift(target - generation)
+		generation = target
+	end
+	if count >= generationSize then
+		shift(1)
+	end
+	for i = 0, k - 1 do
+		local slice = (base + i) % n
+		redis.call('SETBIT', KEYS[1], slice * size * 8 + tonumber(ARGV[7 + slice]), 1)
+	end
+	redis.call('HSET', KEYS[2], 'base', base, 'count', count + 1, 'generation', generation)
+	return true
+`)
+
+// apbfLookupScript returns 1 if an element is found in the age-partitioned Bloom filter
+// whose slices and generation state are held by KEYS[1] and KEYS[2], with the same ARGV as
+// apbfInsertScript. The slices which the generation of the current time has expired are
+// skipped without being cleared.
+var apbfLookupScript = redis.NewScript(`
+	local n = tonumber(ARGV[1])
+	local k = tonumber(ARGV[2])
+	local size = tonumber(ARGV[3])
+	local target = tonumber(ARGV[6])
+	local state = redis.call('HMGET', KEYS[2], 'base', 'generation')
+	local base = tonumber(state[1] or 0)
+	local generation = tonumber(state[2] or target)
+	local expired = 0
+	if ARGV[5] == '1' and target > generation then
+		expired = math.min(target - generation, n)
+	end
+	local run = 0
+	for age = expired, n - 1 do
+		local slice = (base + age - expired) % n
+		if redis.call('GETBIT', KEYS[1], slice * size * 8 + tonumber(ARGV[7 + slice])) == 1 then
+			run = run + 1
+			if run >= k then
+				return 1
+			end
+		else
+			run = 0
+		end
+	end
+	return 0
+`)
+
+// AgePartitionedBloomFilter is an age-partitioned Bloom filter, in memory or in Redis,
+// remembering the elements inserted over a sliding window of generations
+// _numHashes_ is the number of slices set by an insertion, k
+// _numGenerations_ is the number of full generations an element is remembered for, l
+// _sliceSize_ is the number of bits of a slice, a multiple of 64
+// _generationSize_ is the number of insertions of a generation, after which the slices are
+// shifted
+// _window_ is the duration of the window of a timed filter, whose generations last
+// window/l and start on multiples of it since the Unix epoch. It's 0 if the generations
+// are only counted in insertions.
+// _slices_ holds the slices and the generation state
+// _metadataKey_ is the Redis key to the metadata of a Redis backed filter, empty otherwise
+type AgePartitionedBloomFilter struct {
+	numHashes      uint
+	numGenerations uint
+	sliceSize      uint
+	generationSize uint64
+	window         time.Duration
+	slices         apbfSlices
+	metadataKey    string
+	lock           sync.RWMutex
+}
+
+// apbfState is the generation state of an age-partitioned Bloom filter
+// _base_ is the position of the newest slice, the slice of age _i_ is at (_base_ + _i_)
+// modulo the number of slices
+// _count_ is the number of insertions of the current generation
+// _generation_ is the current generation of a timed filter
+type apbfState struct {
+	Base       uint   `json:"b"`
+	Count      uint64 `json:"c"`
+	Generation int64  `json:"e"`
+}
+
+// apbfSlices is the storage of the slices and the generation state of an
+// AgePartitionedBloomFilter. _offsets_ holds the offset of the bit of an element in each
+// slice and _generation_ is the generation of the current time for a timed filter.
+type apbfSlices interface {
+	insert(filter *AgePartitionedBloomFilter, offsets []uint, generation int64) error
+	lookup(filter *AgePartitionedBloomFilter, offsets []uint, generation int64) (bool, error)
+	snapshot() (apbfState, []byte, error)
+	restore(state apbfState, bits []byte) error
+}
+
+type ageBloomFilterJSON struct {
+	NumHashes      uint      `json:"k"`
+	NumGenerations uint      `json:"l"`
+	SliceSize      uint      `json:"m"`
+	GenerationSize uint64    `json:"g"`
+	Window         int64     `json:"w"`
+	State          apbfState `json:"s"`
+	Bits           []byte    `json:"d"`
+}
+
+// NewAgePartitionedBloomFilter creates an in-memory AgePartitionedBloomFilter with
+// _numHashes_ slices set by each insertion and remembering the elements for
+// _numGenerations_ generations of _generationSize_ insertions. The slices are sized so
+// that they end half full, about 1.44 x _numHashes_ x _generationSize_ bits each.
+// A false positive rate of about 1% is reached with 7 hashes and 5 generations, about 0.1%
+// with 10 hashes and 7 generations.
+// _window_ makes the filter timed: the generations last _window_ / _numGenerations_, so
+// the elements are remembered for at least _window_, unless a generation sees more than
+// _generationSize_ insertions, which starts the next generation early. It's 0 for a filter
+// whose generations are only counted in insertions.
+func NewAgePartitionedBloomFilter(numHashes, numGenerations uint, generationSize uint64, window time.Duration) (*AgePartitionedBloomFilter, error) {
+	filter, err := newAgePartitionedBloomFilter(numHashes, numGenerations, generationSize, window)
+	if err != nil {
+		return nil, err
+	}
+	filter.slices = &apbfSlicesMem{
+		bits:  make([]byte, filter.numSlices()*filter.sliceBytes()),
+		state: apbfState{Generation: filter.generationAt(time.Now())},
+	}
+	return filter, nil
+}
+
+// NewRedisAgePartitionedBloomFilter creates a Redis backed AgePartitionedBloomFilter, with
+// the same parameters as NewAgePartitionedBloomFilter. The slices are stored in a Redis
+// string and the generation state in a Redis hash, which are updated atomically.
+// _opts_ can name the Redis keys of the filter with WithName and WithKeyPrefix
+func NewRedisAgePartitionedBloomFilter(numHashes, numGenerations uint, generationSize uint64, window time.Duration, opts ...Option) (*AgePartitionedBloomFilter, error) {
+	filter, err := newAgePartitionedBloomFilter(numHashes, numGenerations, generationSize, window)
+	if err != nil {
+		return nil, err
+	}
+	o, err := redisOptions("age-partitioned bloom filter", opts)
+	if err != nil {
+		return nil, err
+	}
+	filter.metadataKey, err = o.metadataKey()
+	if err != nil {
+		return nil, err
+	}
+	bitsKey, err := o.dataKey(":slices")
+	if err != nil {
+		return nil, err
+	}
+	stateKey, err := o.dataKey(":state")
+	if err != nil {
+		return nil, err
+	}
+	slices := &apbfSlicesRedis{bitsKey, stateKey}
+	err = allocateRedisBits(o.ctx, bitsKey, uint64(filter.numSlices()*filter.sliceBytes()))
+	if err == nil {
+		err = slices.saveState(o.ctx, getRedisClient(), apbfState{Generation: filter.generationAt(time.Now())})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while creating age-partitioned bloom filter redis, error: %v", err)
+	}
+	filter.slices = slices
+	err = saveMetadataContext(o.ctx, filter.metadataKey, "apbf", filter.metadata())
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while creating age-partitioned bloom filter redis, error: %v", err)
+	}
+	return filter, nil
+}
+
+// NewRedisAgePartitionedBloomFilterFromKey is used to create a Redis backed
+// AgePartitionedBloomFilter from the _metadataKey_ (the Redis key used to store the
+// metadata about the filter) passed
+func NewRedisAgePartitionedBloomFilterFromKey(metadataKey string) (*AgePartitionedBloomFilter, error) {
+	metadata, err := loadMetadata(metadataKey, "apbf")
+	if err != nil {
+		return nil, err
+	}
+	var values [5]uint64
+	for i, field := range []string{"numHashes", "numGenerations", "sliceSize", "generationSize", "window"} {
+		values[i], err = metadata.uint(field)
+		if err != nil {
+			return nil, err
+		}
+	}
+	bitsKey, err := metadata.string("key")
+	if err != nil {
+		return nil, err
+	}
+	stateKey, err := metadata.string("stateKey")
+	if err != nil {
+		return nil, err
+	}
+	filter, err := newAgePartitionedBloomFilter(uint(values[0]), uint(values[1]), values[3], time.Duration(values[4]))
+	if err == nil && uint64(filter.sliceSize) != values[2] {
+		err = fmt.Errorf("gostatix: slice size %d doesn't match the %d bits of the parameters", values[2], filter.sliceSize)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: invalid age-partitioned bloom filter metadata at key %s, error: %v", metadataKey, err)
+	}
+	filter.slices = &apbfSlicesRedis{bitsKey, stateKey}
+	filter.metadataKey = metadataKey
+	return filter, nil
+}
+
+// newAgePartitionedBloomFilter returns an AgePartitionedBloomFilter without slices
+func newAgePartitionedBloomFilter(numHashes, numGenerations uint, generationSize uint64, window time.Duration) (*AgePartitionedBloomFilter, error) {
+	sliceSize, err := apbfSliceSize(numHashes, numGenerations, generationSize, window)
+	if err != nil {
+		return nil, err
+	}
+	return &AgePartitionedBloomFilter{
+		numHashes:      numHashes,
+		numGenerations: numGenerations,
+		sliceSize:      sliceSize,
+		generationSize: generationSize,
+		window:         window,
+	}, nil
+}
+
+// apbfSliceSize checks the parameters of an age-partitioned Bloom filter and returns the
+// number of bits of its slices
+func apbfSliceSize(numHashes, numGenerations uint, generationSize uint64, window time.Duration) (uint, error) {
+	if numHashes == 0 || numGenerations == 0 || generationSize == 0 {
+		return 0, fmt.Errorf("gostatix: age-partitioned bloom filter number of hashes %d, number of generations %d and generation size %d should be greater than 0", numHashes, numGenerations, generationSize)
+	}
+	if window < 0 || (window > 0 && window < time.Duration(numGenerations)) {
+		return 0, fmt.Errorf("gostatix: age-partitioned bloom filter window %v should be 0 or at least a nanosecond per generation", window)
+	}
+	numSlices := uint64(numHashes) + uint64(numGenerations)
+	bitsPerSlice := math.Ceil(float64(numHashes) * float64(generationSize) / math.Ln2)
+	if bitsPerSlice > float64(MaxSnapshotSize)*8/float64(numSlices) {
+		return 0, fmt.Errorf("gostatix: age-partitioned bloom filter of %d slices of %.0f bits exceeds the maximum snapshot size of %d bytes", numSlices, bitsPerSlice, MaxSnapshotSize)
+	}
+	words := (uint64(bitsPerSlice) + uint64(wordSize) - 1) / uint64(wordSize)
+	return uint(words) * uint(wordSize), nil
+}
+
+// NumHashes returns the number of slices set by an insertion
+func (filter *AgePartitionedBloomFilter) NumHashes() uint {
+	return filter.numHashes
+}
+
+// NumGenerations returns the number of full generations an element is remembered for
+func (filter *AgePartitionedBloomFilter) NumGenerations() uint {
+	return filter.numGenerations
+}
+
+// SliceSize returns the number of bits of a slice
+func (filter *AgePartitionedBloomFilter) SliceSize() uint {
+	return filter.sliceSize
+}
+
+// GenerationSize returns the number of insertions of a generation
+func (filter *AgePartitionedBloomFilter) GenerationSize() uint64 {
+	return filter.generationSize
+}
+
+// Window returns the duration of the window of a timed filter, 0 for a filter whose
+// generations are only counted in insertions
+func (filter *AgePartitionedBloomFilter) Window() time.Duration {
+	return filter.window
+}
+
+// MetadataKey returns the Redis key to the metadata of a Redis backed filter, it's empty for
+// an in-memory filter
+func (filter *AgePartitionedBloomFilter) MetadataKey() string {
+	return filter.metadataKey
+}
+
+// numSlices returns the number of slices of the filter, k+l
+func (filter *AgePartitionedBloomFilter) numSlices() uint {
+	return filter.numHashes + filter.numGenerations
+}
+
+// sliceBytes returns the number of bytes of a slice
+func (filter *AgePartitionedBloomFilter) sliceBytes() uint {
+	return filter.sliceSize / 8
+}
+
+// generationAt returns the generation of _t_ for a timed filter, 0 otherwise
+func (filter *AgePartitionedBloomFilter) generationAt(t time.Time) int64 {
+	if filter.window == 0 {
+		return 0
+	}
+	duration := int64(filter.window) / int64(filter.numGenerations)
+	nanos := t.UnixNano()
+	generation := nanos / duration
+	if nanos < 0 && nanos%duration != 0 {
+		generation--
+	}
+	return generation
+}
+
+// offsets returns the offset of the bit of _data_ in each slice
+func (filter *AgePartitionedBloomFilter) offsets(data []byte) []uint {
+	hashes := getHashes(data)
+	offsets := make([]uint, filter.numSlices())
+	for i := range offsets {
+		offsets[i] = doubleHashIndex(hashes, uint(i), filter.sliceSize)
+	}
+	return offsets
+}
+
+// Insert adds _data_ to the current generation of the filter
+func (filter *AgePartitionedBloomFilter) Insert(data []byte) error {
+	return filter.InsertAt(time.Now(), data)
+}
+
+// InsertString adds _data_ (string) to the current generation of the filter
+func (filter *AgePartitionedBloomFilter) InsertString(data string) error {
+	return filter.Insert([]byte(data))
+}
+
+// InsertAt adds _data_ at the time _t_, e.g. to insert the elements of a stream by their own
+// timestamps. The generations up to the one of _t_ are started first for a timed filter.
+// Elements older than the current generation are added to it, since the generations don't
+// go back in time.
+func (filter *AgePartitionedBloomFilter) InsertAt(t time.Time, data []byte) error {
+	offsets := filter.offsets(data)
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	return filter.slices.insert(filter, offsets, filter.generationAt(t))
+}
+
+// Lookup returns true if _data_ may have been inserted in the last generations. A false
+// answer is always right for the elements of the last _numGenerations_ generations.
+func (filter *AgePartitionedBloomFilter) Lookup(data []byte) (bool, error) {
+	return filter.LookupAt(time.Now(), data)
+}
+
+// LookupString returns true if _data_ (string) may have been inserted in the last
+// generations
+func (filter *AgePartitionedBloomFilter) LookupString(data string) (bool, error) {
+	return filter.Lookup([]byte(data))
+}
+
+// LookupAt returns true if _data_ may have been inserted in the last generations at the
+// time _t_. The generations expired by _t_ in a timed filter are skipped, without being
+// cleared until the next insertion.
+func (filter *AgePartitionedBloomFilter) LookupAt(t time.Time, data []byte) (bool, error) {
+	offsets := filter.offsets(data)
+	filter.lock.RLock()
+	defer filter.lock.RUnlock()
+
+	return filter.slices.lookup(filter, offsets, filter.generationAt(t))
+}
+
+// Reset clears all the slices of the filter and starts a new generation
+func (filter *AgePartitionedBloomFilter) Reset() error {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	return filter.slices.restore(apbfState{Generation: filter.generationAt(time.Now())}, make([]byte, filter.numSlices()*filter.sliceBytes()))
+}
+
+// FalsePositiveRate returns the probability that an element which wasn't inserted in the
+// last generations is found in the filter, estimated from the bits set in each slice
+func (filter *AgePartitionedBloomFilter) FalsePositiveRate() (float64, error) {
+	counts, err := filter.sliceCounts()
+	if err != nil {
+		return 0, err
+	}
+	return filter.falsePositiveRate(counts), nil
+}
+
+// sliceCounts returns the number of bits set in each slice, from the newest to the oldest
+func (filter *AgePartitionedBloomFilter) sliceCounts() ([]uint64, error) {
+	filter.lock.RLock()
+	defer filter.lock.RUnlock()
+
+	state, data, err := filter.slices.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	n := filter.numSlices()
+	size := filter.sliceBytes()
+	counts := make([]uint64, n)
+	for age := range counts {
+		slice := (state.Base + uint(age)) % n
+		for _, b := range data[slice*size : (slice+1)*size] {
+			counts[age] += uint64(bits.OnesCount8(b))
+		}
+	}
+	return counts, nil
+}
+
+// falsePositiveRate returns the probability of finding a bit set in _numHashes_
+// consecutive slices, whose numbers of bits set are _counts_
+func (filter *AgePartitionedBloomFilter) falsePositiveRate(counts []uint64) float64 {
+	// runs[i] is the probability of a run of i bits set ending at the current slice
+	runs := make([]float64, filter.numHashes)
+	runs[0] = 1
+	found := 0.0
+	for _, count := range counts {
+		fill := float64(count) / float64(filter.sliceSize)
+		missed := 0.0
+		for i := len(runs) - 1; i >= 0; i-- {
+			missed += runs[i] * (1 - fill)
+			if i == len(runs)-1 {
+				found += runs[i] * fill
+			} else {
+				runs[i+1] = runs[i] * fill
+			}
+		}
+		runs[0] = missed
+	}
+	return found
+}
+
+// MemoryUsage returns the estimated number of bytes used in-process by an in-memory
+// filter, or by the keys of a Redis backed filter in Redis
+func (filter *AgePartitionedBloomFilter) MemoryUsage() (uint64, error) {
+	filter.lock.RLock()
+	defer filter.lock.RUnlock()
+
+	switch slices := filter.slices.(type) {
+	case *apbfSlicesMem:
+		return uint64(unsafe.Sizeof(*filter)) + uint64(unsafe.Sizeof(*slices)) + uint64(cap(slices.bits)), nil
+	case *apbfSlicesRedis:
+		return redisMemoryUsage([]string{filter.metadataKey, slices.bitsKey, slices.stateKey})
+	default:
+		return 0, nil
+	}
+}
+
+// Export JSON marshals the AgePartitionedBloomFilter and returns a byte slice containing
+// the data
+func (filter *AgePartitionedBloomFilter) Export() ([]byte, error) {
+	filter.lock.RLock()
+	defer filter.lock.RUnlock()
+
+	state, data, err := filter.slices.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ageBloomFilterJSON{filter.numHashes, filter.numGenerations, filter.sliceSize,
+		filter.generationSize, int64(filter.window), state, data})
+}
+
+// Import JSON unmarshals the _data_ into the AgePartitionedBloomFilter. The slices and the
+// generation state of a Redis backed filter are replaced in Redis and its metadata is
+// updated.
+func (filter *AgePartitionedBloomFilter) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
+	var f ageBloomFilterJSON
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return err
+	}
+	imported, err := newAgePartitionedBloomFilter(f.NumHashes, f.NumGenerations, f.GenerationSize, time.Duration(f.Window))
+	if err != nil {
+		return fmt.Errorf("gostatix: invalid age-partitioned bloom filter snapshot, error: %v", err)
+	}
+	if imported.sliceSize != f.SliceSize {
+		return fmt.Errorf("gostatix: invalid age-partitioned bloom filter snapshot, slice size %d doesn't match the %d bits of the parameters", f.SliceSize, imported.sliceSize)
+	}
+	if uint(len(f.Bits)) != imported.numSlices()*imported.sliceBytes() {
+		return fmt.Errorf("gostatix: invalid age-partitioned bloom filter snapshot, %d bytes of slices found instead of %d", len(f.Bits), imported.numSlices()*imported.sliceBytes())
+	}
+	if f.State.Base >= imported.numSlices() {
+		return fmt.Errorf("gostatix: invalid age-partitioned bloom filter snapshot, newest slice %d out of %d slices", f.State.Base, imported.numSlices())
+	}
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	if filter.slices == nil {
+		filter.slices = new(apbfSlicesMem)
+	}
+	err = filter.slices.restore(f.State, f.Bits)
+	if err != nil {
+		return err
+	}
+	filter.numHashes = imported.numHashes
+	filter.numGenerations = imported.numGenerations
+	filter.sliceSize = imported.sliceSize
+	filter.generationSize = imported.generationSize
+	filter.window = imported.window
+	if filter.metadataKey != "" {
+		err = saveMetadata(filter.metadataKey, "apbf", filter.metadata())
+		if err != nil {
+			return fmt.Errorf("gostatix: error while saving metadata in redis, error: %v", err)
+		}
+	}
+	return nil
+}
+
+// metadata returns the Redis metadata of a Redis backed filter
+func (filter *AgePartitionedBloomFilter) metadata() map[string]interface{} {
+	slices := filter.slices.(*apbfSlicesRedis)
+	metadata := make(map[string]interface{})
+	metadata["numHashes"] = filter.numHashes
+	metadata["numGenerations"] = filter.numGenerations
+	metadata["sliceSize"] = filter.sliceSize
+	metadata["generationSize"] = filter.generationSize
+	metadata["window"] = int64(filter.window)
+	metadata["key"] = slices.bitsKey
+	metadata["stateKey"] = slices.stateKey
+	return metadata
+}
+
+// apbfSlicesMem holds the slices of an in-memory AgePartitionedBloomFilter, in the byte
+// order of a Redis string: bit _i_ is the bit 7 - _i_ % 8 of byte _i_ / 8
+type apbfSlicesMem struct {
+	bits  []byte
+	state apbfState
+}
+
+// shift starts _s_ new generations, clearing the slices reused as the newest ones
+func (slices *apbfSlicesMem) shift(filter *AgePartitionedBloomFilter, s uint64) {
+	n := filter.numSlices()
+	size := filter.sliceBytes()
+	slices.state.Base = (slices.state.Base + n - uint(s%uint64(n))) % n
+	for i := uint(0); i < uint(minUint64(s, uint64(n))); i++ {
+		slice := (slices.state.Base + i) % n
+		for j := slice * size; j < (slice+1)*size; j++ {
+			slices.bits[j] = 0
+		}
+	}
+	slices.state.Count = 0
+}
+
+// has returns true if the bit at _offset_ of _slice_ is set
+func (slices *apbfSlicesMem) has(filter *AgePartitionedBloomFilter, slice, offset uint) bool {
+	index := slice*filter.sliceSize + offset
+	return slices.bits[index/8]&(0x80>>(index%8)) != 0
+}
+
+func (slices *apbfSlicesMem) insert(filter *AgePartitionedBloomFilter, offsets []uint, generation int64) error {
+	if filter.window > 0 && generation > slices.state.Generation {
+		slices.shift(filter, uint64(generation-slices.state.Generation))
+		slices.state.Generation = generation
+	}
+	if slices.state.Count >= filter.generationSize {
+		slices.shift(filter, 1)
+	}
+	n := filter.numSlices()
+	for i := uint(0); i < filter.numHashes; i++ {
+		slice := (slices.state.Base + i) % n
+		index := slice*filter.sliceSize + offsets[slice]
+		slices.bits[index/8] |= 0x80 >> (index % 8)
+	}
+	slices.state.Count++
+	return nil
+}
+
+func (slices *apbfSlicesMem) lookup(filter *AgePartitionedBloomFilter, offsets []uint, generation int64) (bool, error) {
+	n := filter.numSlices()
+	expired := uint(0)
+	if filter.window > 0 && generation > slices.state.Generation {
+		expired = uint(minUint64(uint64(generation-slices.state.Generation), uint64(n)))
+	}
+	run := uint(0)
+	for age := expired; age < n; age++ {
+		slice := (slices.state.Base + age - expired) % n
+		if !slices.has(filter, slice, offsets[slice]) {
+			run = 0
+			continue
+		}
+		run++
+		if run >= filter.numHashes {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (slices *apbfSlicesMem) snapshot() (apbfState, []byte, error) {
+	return slices.state, append([]byte(nil), slices.bits...), nil
+}
+
+func (slices *apbfSlicesMem) restore(state apbfState, bits []byte) error {
+	slices.state = state
+	slices.bits = bits
+	return nil
+}
+
+// apbfSlicesRedis holds the slices of a Redis backed AgePartitionedBloomFilter in the
+// string at _bitsKey_ and its generation state in the hash at _stateKey_
+type apbfSlicesRedis struct {
+	bitsKey  string
+	stateKey string
+}
+
+// scriptArgs returns the ARGV of apbfInsertScript and apbfLookupScript
+func (slices *apbfSlicesRedis) scriptArgs(filter *AgePartitionedBloomFilter, offsets []uint, generation int64) []interface{} {
+	timed := 0
+	if filter.window > 0 {
+		timed = 1
+	}
+	args := make([]interface{}, 0, 6+len(offsets))
+	args = append(args, filter.numSlices(), filter.numHashes, filter.sliceBytes(), filter.generationSize, timed, generation)
+	for _, offset := range offsets {
+		args = append(args, offset)
+	}
+	return args
+}
+
+func (slices *apbfSlicesRedis) insert(filter *AgePartitionedBloomFilter, offsets []uint, generation int64) error {
+	err := apbfInsertScript.Run(context.Background(), getRedisClient(), []string{slices.bitsKey, slices.stateKey}, slices.scriptArgs(filter, offsets, generation)...).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while inserting element in redis, error: %v", err)
+	}
+	return nil
+}
+
+func (slices *apbfSlicesRedis) lookup(filter *AgePartitionedBloomFilter, offsets []uint, generation int64) (bool, error) {
+	found, err := apbfLookupScript.Run(context.Background(), getRedisClient(), []string{slices.bitsKey, slices.stateKey}, slices.scriptArgs(filter, offsets, generation)...).Int()
+	if err != nil {
+		return false, fmt.Errorf("gostatix: error while looking up element in redis, error: %v", err)
+	}
+	return found == 1, nil
+}
+
+func (slices *apbfSlicesRedis) snapshot() (apbfState, []byte, error) {
+	ctx := context.Background()
+	var data *redis.StringCmd
+	var values *redis.SliceCmd
+	_, err := getRedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		data = pipe.Get(ctx, slices.bitsKey)
+		values = pipe.HMGet(ctx, slices.stateKey, "base", "count", "generation")
+		return nil
+	})
+	if err != nil {
+		return apbfState{}, nil, fmt.Errorf("gostatix: error while fetching slices from redis, error: %v", err)
+	}
+	fields := make([]string, len(values.Val()))
+	for i, value := range values.Val() {
+		fields[i], _ = value.(string)
+	}
+	base, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return apbfState{}, nil, fmt.Errorf("gostatix: invalid newest slice %q in redis at key %s", fields[0], slices.stateKey)
+	}
+	count, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return apbfState{}, nil, fmt.Errorf("gostatix: invalid generation count %q in redis at key %s", fields[1], slices.stateKey)
+	}
+	generation, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return apbfState{}, nil, fmt.Errorf("gostatix: invalid generation %q in redis at key %s", fields[2], slices.stateKey)
+	}
+	state := apbfState{uint(base), count, generation}
+	return state, []byte(data.Val()), nil
+}
+
+func (slices *apbfSlicesRedis) restore(state apbfState, bits []byte) error {
+	ctx := context.Background()
+	_, err := getRedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, slices.bitsKey, string(bits), 0)
+		return slices.saveState(ctx, pipe, state)
+	})
+	if err != nil {
+		return fmt.Errorf("gostatix: error while writing slices to redis, error: %v", err)
+	}
+	return nil
+}
+
+// saveState writes the generation _state_ with _cmd_, a client or a pipeline
+func (slices *apbfSlicesRedis) saveState(ctx context.Context, cmd redis.Cmdable, state apbfState) error {
+	return cmd.HSet(ctx, slices.stateKey, "base", state.Base, "count", state.Count, "generation", state.Generation).Err()
+}
@@ -0,0 +1,140 @@
+package gostatix
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAgePartitionedBloomFilter(t *testing.T) {
+	initMockRedis()
+	memFilter, _ := NewAgePartitionedBloomFilter(4, 3, 100, 0)
+	redisFilter, err := NewRedisAgePartitionedBloomFilter(4, 3, 100, 0)
+	if err != nil {
+		t.Fatalf("redis filter creation shouldn't error out, error: %v", err)
+	}
+	for _, filter := range []*AgePartitionedBloomFilter{memFilter, redisFilter} {
+		for i := 0; i < 400; i++ {
+			filter.InsertString(strconv.Itoa(i))
+		}
+		// the first generation is 3 generations old
+		for i := 0; i < 400; i++ {
+			if ok, err := filter.LookupString(strconv.Itoa(i)); !ok || err != nil {
+				t.Fatalf("%d should be found in the filter, error: %v", i, err)
+			}
+		}
+		// the bits of the first generation leave the filter one slice per generation
+		for i := 400; i < 700; i++ {
+			filter.InsertString(strconv.Itoa(i))
+		}
+		found := 0
+		for i := 0; i < 100; i++ {
+			if ok, _ := filter.LookupString(strconv.Itoa(i)); ok {
+				found++
+			}
+		}
+		if found > 20 {
+			t.Errorf("elements of the expired generation should be forgotten, %d of 100 found", found)
+		}
+		for i := 400; i < 700; i++ {
+			if ok, _ := filter.LookupString(strconv.Itoa(i)); !ok {
+				t.Fatalf("%d should be found in the filter", i)
+			}
+		}
+		rate, err := filter.FalsePositiveRate()
+		if err != nil || rate <= 0 || rate > 0.2 {
+			t.Errorf("false positive rate should be positive and below 0.2, got %v, error: %v", rate, err)
+		}
+	}
+
+	data, err := redisFilter.Export()
+	if err != nil {
+		t.Fatalf("export shouldn't error out, error: %v", err)
+	}
+	memData, _ := memFilter.Export()
+	if string(memData) != string(data) {
+		t.Errorf("in-memory and redis filters with the same elements should export the same slices")
+	}
+	imported, _ := NewAgePartitionedBloomFilter(1, 1, 1, 0)
+	err = imported.Import(data)
+	if err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if ok, _ := imported.LookupString("699"); !ok || imported.NumGenerations() != 3 {
+		t.Errorf("imported filter should find 699 and have 3 generations")
+	}
+	if err := imported.Import([]byte(`{"k":4,"l":3,"m":64,"g":100,"s":{},"d":""}`)); err == nil {
+		t.Errorf("snapshot with a wrong slice size should error out")
+	}
+
+	loaded, err := NewRedisAgePartitionedBloomFilterFromKey(redisFilter.MetadataKey())
+	if err != nil {
+		t.Fatalf("filter loading shouldn't error out, error: %v", err)
+	}
+	if ok, _ := loaded.LookupString("699"); !ok {
+		t.Errorf("loaded filter should find 699")
+	}
+	err = loaded.Import(memData)
+	if err != nil {
+		t.Fatalf("import into a redis filter shouldn't error out, error: %v", err)
+	}
+	if err := loaded.Reset(); err != nil {
+		t.Fatalf("reset shouldn't error out, error: %v", err)
+	}
+	if ok, _ := redisFilter.LookupString("699"); ok {
+		t.Errorf("reset filter shouldn't find 699")
+	}
+
+	if _, err := NewAgePartitionedBloomFilter(0, 3, 100, 0); err == nil {
+		t.Errorf("filter without hashes should error out")
+	}
+	if _, err := NewAgePartitionedBloomFilter(4, 3, 100, -time.Second); err == nil {
+		t.Errorf("filter with a negative window should error out")
+	}
+}
+
+func TestAgePartitionedBloomFilterWindow(t *testing.T) {
+	initMockRedis()
+	memFilter, _ := NewAgePartitionedBloomFilter(4, 3, 1000, 3*time.Minute)
+	redisFilter, err := NewRedisAgePartitionedBloomFilter(4, 3, 1000, 3*time.Minute)
+	if err != nil {
+		t.Fatalf("redis filter creation shouldn't error out, error: %v", err)
+	}
+	now := time.Now()
+	for _, filter := range []*AgePartitionedBloomFilter{memFilter, redisFilter} {
+		filter.InsertAt(now, []byte("cat"))
+		if ok, _ := filter.LookupAt(now.Add(3*time.Minute), []byte("cat")); !ok {
+			t.Errorf("cat should be found within the window")
+		}
+		if ok, _ := filter.LookupAt(now.Add(5*time.Minute), []byte("cat")); ok {
+			t.Errorf("cat shouldn't be found after the window")
+		}
+		filter.InsertAt(now.Add(time.Minute), []byte("dog"))
+		filter.InsertAt(now, []byte("fox"))
+		if ok, _ := filter.LookupAt(now.Add(4*time.Minute), []byte("fox")); !ok {
+			t.Errorf("late element should be added to the current generation")
+		}
+		filter.InsertAt(now.Add(time.Hour), []byte("owl"))
+		for _, name := range []string{"cat", "dog", "fox"} {
+			if ok, _ := filter.LookupAt(now.Add(time.Hour), []byte(name)); ok {
+				t.Errorf("%s shouldn't be found an hour later", name)
+			}
+		}
+		if ok, _ := filter.LookupAt(now.Add(time.Hour), []byte("owl")); !ok {
+			t.Errorf("owl should be found")
+		}
+	}
+	memData, _ := memFilter.Export()
+	redisData, _ := redisFilter.Export()
+	if string(memData) != string(redisData) {
+		t.Errorf("in-memory and redis filters with the same elements should export the same slices")
+	}
+	d, err := redisFilter.Describe()
+	if err != nil || d.Type != "apbf" || d.Backend != RedisBackend || d.Count != 4 || d.Parameters["window"] != "3m0s" {
+		t.Errorf("description should be of a redis apbf with 4 bits set, got %+v, error: %v", d, err)
+	}
+	stats, err := memFilter.Stats()
+	if err != nil || stats.ItemsEstimate != 1 {
+		t.Errorf("stats should estimate 1 element, got %+v, error: %v", stats, err)
+	}
+}
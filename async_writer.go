@@ -0,0 +1,175 @@
+/*
+Implements a buffered asynchronous writer for the Redis backed data structures.
+
+Writing every element to Redis in its own round trip is the bottleneck while
+ingesting a stream. AsyncWriter buffers the writes in memory and flushes them
+in batches using Redis pipelines.
+*/
+package gostatix
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// asyncWrite is a single buffered write of _count_ occurrences of _data_
+type asyncWrite struct {
+	data  []byte
+	count uint64
+}
+
+// batchWriter is implemented by the Redis backed data structures which support
+// writing a batch of elements using Redis pipelines
+type batchWriter interface {
+	writeBatch(batch []asyncWrite) error
+}
+
+// AsyncWriter buffers writes to a Redis backed data structure and flushes them in
+// batches using Redis pipelines.
+// _bufSize_ is the number of writes after which the buffer is flushed. A write which
+// fills up the buffer flushes it before returning, which throttles the producers
+// to the speed of Redis.
+// _flushInterval_ is the interval at which the buffer is flushed in the background.
+// _onError_ is called with the errors of the background flushes.
+type AsyncWriter struct {
+	target        batchWriter
+	bufSize       int
+	flushInterval time.Duration
+	onError       func(error)
+	buffer        []asyncWrite
+	closed        bool
+	lock          sync.Mutex
+	flushLock     sync.Mutex
+	done          chan struct{}
+	wg            sync.WaitGroup
+}
+
+// newAsyncWriter creates an AsyncWriter over _target_ and starts the background flush
+// if _flushInterval_ is greater than 0. The writer is attached to _owner_ so that it's
+// closed along with the data structure.
+func newAsyncWriter(owner *resources, target batchWriter, bufSize int, flushInterval time.Duration, onError func(error)) (*AsyncWriter, error) {
+	if bufSize <= 0 {
+		return nil, fmt.Errorf("gostatix: buffer size of async writer should be greater than 0")
+	}
+	writer := &AsyncWriter{
+		target:        target,
+		bufSize:       bufSize,
+		flushInterval: flushInterval,
+		onError:       onError,
+		buffer:        make([]asyncWrite, 0, bufSize),
+		done:          make(chan struct{}),
+	}
+	if flushInterval > 0 {
+		writer.wg.Add(1)
+		go writer.flushPeriodically()
+	}
+	err := owner.attach(writer)
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Insert buffers a single occurrence of _data_
+func (w *AsyncWriter) Insert(data []byte) error {
+	return w.Update(data, 1)
+}
+
+// InsertString buffers a single occurrence of _data_ (string)
+func (w *AsyncWriter) InsertString(data string) error {
+	return w.Update([]byte(data), 1)
+}
+
+// InsertUint64 buffers a single occurrence of _data_ (unsigned integer)
+func (w *AsyncWriter) InsertUint64(data uint64) error {
+	return w.Update(uint64Bytes(data), 1)
+}
+
+// InsertKey buffers a single occurrence of _data_ (Key)
+func (w *AsyncWriter) InsertKey(data Key) error {
+	return w.Update(data.KeyBytes(), 1)
+}
+
+// Update buffers _count_ occurrences of _data_. _count_ is only meaningful for
+// count-min sketches, other data structures treat it as a single occurrence.
+// If the write fills up the buffer, the buffer is flushed before returning and
+// the error of the flush, if any, is returned.
+func (w *AsyncWriter) Update(data []byte, count uint64) error {
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return fmt.Errorf("gostatix: write on a closed async writer")
+	}
+	element := make([]byte, len(data))
+	copy(element, data)
+	w.buffer = append(w.buffer, asyncWrite{element, count})
+	full := len(w.buffer) >= w.bufSize
+	w.lock.Unlock()
+	if full {
+		return w.Flush()
+	}
+	return nil
+}
+
+// UpdateKey buffers _count_ occurrences of _data_ (Key)
+func (w *AsyncWriter) UpdateKey(data Key, count uint64) error {
+	return w.Update(data.KeyBytes(), count)
+}
+
+// Buffered returns the number of writes which haven't been flushed yet
+func (w *AsyncWriter) Buffered() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return len(w.buffer)
+}
+
+// Flush writes all the buffered elements to Redis. Only one flush runs at a time.
+func (w *AsyncWriter) Flush() error {
+	w.flushLock.Lock()
+	defer w.flushLock.Unlock()
+	w.lock.Lock()
+	batch := w.buffer
+	w.buffer = make([]asyncWrite, 0, w.bufSize)
+	w.lock.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	err := w.target.writeBatch(batch)
+	if err != nil {
+		return fmt.Errorf("gostatix: error while flushing %d writes to redis, error: %v", len(batch), err)
+	}
+	return nil
+}
+
+// Close stops the background flush and flushes the remaining buffered elements.
+// Writes after Close return an error.
+func (w *AsyncWriter) Close() error {
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.lock.Unlock()
+	close(w.done)
+	w.wg.Wait()
+	return w.Flush()
+}
+
+func (w *AsyncWriter) flushPeriodically() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			err := w.Flush()
+			if err != nil && w.onError != nil {
+				w.onError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package gostatix
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAsyncWriterBloomFilterRedis(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewRedisBloomFilterWithParameters(1000, 0.001)
+	writer, err := filter.WithAsyncWrites(10, 0, nil)
+	if err != nil {
+		t.Fatalf("async writer shouldn't error out, error: %v", err)
+	}
+	for i := 0; i < 25; i++ {
+		writer.Insert([]byte(strconv.Itoa(i)))
+	}
+	if writer.Buffered() != 5 {
+		t.Errorf("5 inserts should be buffered, found %d", writer.Buffered())
+	}
+	if !filter.Lookup([]byte("19")) {
+		t.Error("19 should be present as the buffer is flushed when full")
+	}
+	if filter.Lookup([]byte("24")) {
+		t.Error("24 shouldn't be present before flush")
+	}
+	err = writer.Flush()
+	if err != nil {
+		t.Errorf("flush shouldn't error out, error: %v", err)
+	}
+	for i := 0; i < 25; i++ {
+		if !filter.Lookup([]byte(strconv.Itoa(i))) {
+			t.Errorf("%d should be present in the filter after flush", i)
+		}
+	}
+}
+
+func TestAsyncWriterBloomFilterMem(t *testing.T) {
+	filter, _ := NewMemBloomFilterWithParameters(1000, 0.001)
+	_, err := filter.WithAsyncWrites(10, 0, nil)
+	if err == nil {
+		t.Error("should error out for in-memory bloom filter")
+	}
+}
+
+func TestAsyncWriterCountMinSketchRedis(t *testing.T) {
+	initMockRedis()
+	cms, _ := NewCountMinSketchRedisFromEstimates(0.001, delta)
+	writer, _ := cms.WithAsyncWrites(100, 0, nil)
+	for i := 0; i < 30; i++ {
+		writer.Update([]byte(strconv.Itoa(i%3)), 2)
+	}
+	writer.InsertString("foo")
+	err := writer.Close()
+	if err != nil {
+		t.Errorf("close shouldn't error out, error: %v", err)
+	}
+	count, _ := cms.Count([]byte("1"))
+	if count != 20 {
+		t.Errorf("count of 1 should be 20, found %d", count)
+	}
+	count, _ = cms.CountString("foo")
+	if count != 1 {
+		t.Errorf("count of foo should be 1, found %d", count)
+	}
+	total, _ := cms.TotalCount()
+	if total != 61 {
+		t.Errorf("total count should be 61, found %d", total)
+	}
+	if writer.Insert([]byte("bar")) == nil {
+		t.Error("write after close should error out")
+	}
+}
+
+func TestAsyncWriterHyperLogLogRedis(t *testing.T) {
+	initMockRedis()
+	h1, _ := NewHyperLogLogRedis(128)
+	h2, _ := NewHyperLogLogRedis(128)
+	writer, _ := h1.WithAsyncWrites(64, 0, nil)
+	for i := 0; i < 1000; i++ {
+		data := []byte(strconv.Itoa(i))
+		writer.Insert(data)
+		h2.Update(data)
+	}
+	writer.Close()
+	equals, _ := h1.Equals(h2)
+	if !equals {
+		t.Error("hyperloglog updated asynchronously should be equal to the one updated synchronously")
+	}
+}
+
+func TestAsyncWriterFlushInterval(t *testing.T) {
+	initMockRedis()
+	cms, _ := NewCountMinSketchRedisFromEstimates(0.001, delta)
+	writer, _ := cms.WithAsyncWrites(1000, 10*time.Millisecond, func(err error) {
+		t.Errorf("background flush shouldn't error out, error: %v", err)
+	})
+	defer writer.Close()
+	writer.InsertString("foo")
+	deadline := time.Now().Add(time.Second)
+	for writer.Buffered() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	count, _ := cms.CountString("foo")
+	if count != 1 {
+		t.Errorf("count of foo should be 1 after the background flush, found %d", count)
+	}
+}
+
+func TestAsyncWriterInvalidBufferSize(t *testing.T) {
+	initMockRedis()
+	cms, _ := NewCountMinSketchRedisFromEstimates(0.001, delta)
+	_, err := cms.WithAsyncWrites(0, 0, nil)
+	if err == nil {
+		t.Error("should error out as buffer size is 0")
+	}
+}
+
+func TestAsyncWriterClosedWithStructure(t *testing.T) {
+	initMockRedis()
+	h, _ := NewHyperLogLogRedis(128)
+	writer, _ := h.WithAsyncWrites(100, time.Hour, nil)
+	writer.InsertString("foo")
+	err := h.Close()
+	if err != nil {
+		t.Errorf("close shouldn't error out, error: %v", err)
+	}
+	if writer.Buffered() != 0 {
+		t.Errorf("writer should be flushed on close, found %d buffered writes", writer.Buffered())
+	}
+	if writer.InsertString("bar") == nil {
+		t.Error("writer should be closed along with the hyperloglog")
+	}
+	_, err = h.WithAsyncWrites(100, 0, nil)
+	if err == nil {
+		t.Error("should error out as the hyperloglog is closed")
+	}
+}
@@ -10,6 +10,8 @@ in-memory data structures are thread-safe.
 package gostatix
 
 import (
+	"math"
+
 	"github.com/dgryski/go-metro"
 )
 
@@ -17,6 +19,10 @@ import (
 type BaseCountMinSketch interface {
 	GetRows() uint
 	GetColumns() uint
+	Rows() uint
+	Columns() uint
+	ErrorRate() float64
+	Delta() float64
 	Update(data []byte, count uint64)
 	UpdateString(data string, count uint64)
 	Count(data []byte) uint64
@@ -49,11 +55,40 @@ func (cms *AbstractCountMinSketch) GetColumns() uint {
 	return cms.columns
 }
 
+// Rows returns the number of rows (hash functions) in the underlying matrix of the Count-Min Sketch
+func (cms *AbstractCountMinSketch) Rows() uint {
+	return cms.rows
+}
+
+// Columns returns the number of columns (counters per row) in the underlying matrix of the Count-Min Sketch
+func (cms *AbstractCountMinSketch) Columns() uint {
+	return cms.columns
+}
+
+// ErrorRate returns the error factor of the estimates, calculated as e / _columns_.
+// An estimated count exceeds the true count by at most ErrorRate() * TotalCount()
+// with probability 1 - Delta()
+func (cms *AbstractCountMinSketch) ErrorRate() float64 {
+	return math.E / float64(cms.columns)
+}
+
+// Delta returns the probability with which an estimate may exceed the error bound
+// given by ErrorRate(), calculated as e ^ -_rows_
+func (cms *AbstractCountMinSketch) Delta() float64 {
+	return math.Exp(-float64(cms.rows))
+}
+
 func (cms *AbstractCountMinSketch) getPositions(data []byte) []uint {
 	positions := make([]uint, cms.rows)
 	hash1, hash2 := metro.Hash128(data, 1373)
-	for c := range positions {
-		positions[c] = uint((hash1 + uint64(c)*hash2) % uint64(cms.columns))
+	for r := range positions {
+		positions[r] = cms.getPosition(hash1, hash2, uint(r))
 	}
 	return positions
 }
+
+// getPosition returns the column of _row_ for the hashes _hash1_ and _hash2_ of an element.
+// It's used in the hot loops instead of getPositions to avoid allocating the positions.
+func (cms *AbstractCountMinSketch) getPosition(hash1, hash2 uint64, row uint) uint {
+	return uint((hash1 + uint64(row)*hash2) % uint64(cms.columns))
+}
@@ -5,9 +5,14 @@ This package provides implementation Cuckoo Filter.
 package gostatix
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"math/rand"
 	"strconv"
+	"sync"
+
+	"github.com/dgryski/go-metro"
 )
 
 type BaseCuckooFilter interface {
@@ -25,21 +30,64 @@ type AbstractCuckooFilter struct {
 	bucketSize        uint64
 	fingerPrintLength uint64
 	retries           uint64
+	hashing           CuckooHashing
+	candidates        uint64
+	legacyAltIndex    bool
+	random            *rand.Rand
+	randomLock        sync.Mutex
 }
 
-type entry struct {
-	fingerPrint string
-	firstIndex  uint64
-	secondIndex uint64
+// CuckooInsertStats describes an insert in a Cuckoo Filter, it's used to tune the
+// bucket size and the number of retries of filters under high load.
+// _Kicks_ is the number of entries kicked out of their buckets to make room for the entry
+// _RolledBack_ is true if the insert failed and the kicked out entries were restored
+// in their buckets
+type CuckooInsertStats struct {
+	Kicks      uint64
+	RolledBack bool
 }
 
-func makeAbstractCuckooFilter(size, bucketSize, fingerPrintLength, retries uint64) *AbstractCuckooFilter {
+// ErrCuckooFilterFull is returned by the inserts in a Cuckoo Filter which can't find room
+// for the element, the errors wrapping it can be checked with errors.Is
+var ErrCuckooFilterFull = errors.New("gostatix: cannot insert element, cuckoofilter is full")
+
+func makeAbstractCuckooFilter(size, bucketSize, fingerPrintLength, retries uint64) (*AbstractCuckooFilter, error) {
+	err := CuckooFilterParams{size, bucketSize, fingerPrintLength, retries}.Validate()
+	if err != nil {
+		return nil, err
+	}
 	baseFilter := &AbstractCuckooFilter{}
 	baseFilter.size = size
 	baseFilter.bucketSize = bucketSize
 	baseFilter.fingerPrintLength = fingerPrintLength
 	baseFilter.retries = retries
-	return baseFilter
+	return baseFilter, nil
+}
+
+// checkCuckooFilterEstimates validates the parameters used to compute the size and
+// fingerprint length of a Cuckoo Filter with a desired error rate
+func checkCuckooFilterEstimates(size, bucketSize uint64, errorRate float64) error {
+	if size == 0 || bucketSize == 0 {
+		return fmt.Errorf("gostatix: cuckoo filter size %d and bucket size %d should be greater than 0", size, bucketSize)
+	}
+	if !(errorRate > 0 && errorRate < 1) {
+		return fmt.Errorf("gostatix: cuckoo filter error rate %v should be between 0 and 1", errorRate)
+	}
+	return nil
+}
+
+// checkCuckooFilterParams validates the _size_, _bucketSize_ and _fingerPrintLength_
+// of a decoded snapshot
+func checkCuckooFilterParams(size, bucketSize, fingerPrintLength uint64) error {
+	err := CuckooFilterParams{Size: size, BucketSize: bucketSize, FingerPrintLength: fingerPrintLength}.Validate()
+	if err != nil {
+		return fmt.Errorf("gostatix: invalid cuckoo filter snapshot, error: %v", err)
+	}
+	err = checkSnapshotSize("cuckoo filter bucket", bucketSize, stringSize)
+	if err != nil {
+		return err
+	}
+	return checkSnapshotSize("cuckoo filter", size, bucketSize*stringSize)
 }
 
 // Size returns the size of the buckets slice of the Cuckoo Filter
@@ -69,26 +117,398 @@ func (cuckooFilter *AbstractCuckooFilter) Retries() uint64 {
 	return cuckooFilter.retries
 }
 
-// CuckooPositiveRate returns the false positive error rate of the filter
+// Hashing returns the hashing deriving the fingerprints and the bucket indexes of the
+// elements of the Cuckoo Filter, MurmurCuckooHashing by default
+func (cuckooFilter *AbstractCuckooFilter) Hashing() CuckooHashing {
+	if cuckooFilter.hashing == nil {
+		return MurmurCuckooHashing
+	}
+	return cuckooFilter.hashing
+}
+
+// Candidates returns the number of candidate buckets of each fingerprint, 2 by default,
+// see NewCuckooFilterWithCandidates
+func (cuckooFilter *AbstractCuckooFilter) Candidates() uint64 {
+	if cuckooFilter.candidates == 0 {
+		return 2
+	}
+	return cuckooFilter.candidates
+}
+
+// hashingName returns the name of the hashing of the filter saved along with it, empty for
+// the default hashing so that the filters using it are saved like before it was pluggable
+func (cuckooFilter *AbstractCuckooFilter) hashingName() string {
+	if name := cuckooFilter.Hashing().Name(); name != MurmurCuckooHashing.Name() {
+		return name
+	}
+	return ""
+}
+
+// compareParams compares the parameters which define the layout of two cuckoo filters
+func (cuckooFilter *AbstractCuckooFilter) compareParams(otherFilter *AbstractCuckooFilter) Comparison {
+	if cuckooFilter.size != otherFilter.size {
+		return parameterMismatch("size", cuckooFilter.size, otherFilter.size)
+	}
+	if cuckooFilter.bucketSize != otherFilter.bucketSize {
+		return parameterMismatch("bucketSize", cuckooFilter.bucketSize, otherFilter.bucketSize)
+	}
+	if cuckooFilter.fingerPrintLength != otherFilter.fingerPrintLength {
+		return parameterMismatch("fingerPrintLength", cuckooFilter.fingerPrintLength, otherFilter.fingerPrintLength)
+	}
+	if cuckooFilter.Hashing().Name() != otherFilter.Hashing().Name() {
+		return parameterMismatch("hashing", cuckooFilter.Hashing().Name(), otherFilter.Hashing().Name())
+	}
+	if cuckooFilter.Candidates() != otherFilter.Candidates() {
+		return parameterMismatch("candidates", cuckooFilter.Candidates(), otherFilter.Candidates())
+	}
+	if cuckooFilter.differenceAltIndex() != otherFilter.differenceAltIndex() {
+		return parameterMismatch("differenceAltIndex", cuckooFilter.differenceAltIndex(), otherFilter.differenceAltIndex())
+	}
+	return equalComparison
+}
+
+// maxKicks returns the number of kicks allowed for an insert: _maxKicks_ if it's not 0,
+// else the number of retries of the filter
+func (cuckooFilter *AbstractCuckooFilter) maxKicks(maxKicks uint64) uint64 {
+	if maxKicks == 0 {
+		return cuckooFilter.retries
+	}
+	return maxKicks
+}
+
+// SetRand sets the source of the random draws of the Cuckoo Filter: the bucket in which
+// the kicks start, the entries kicked out and the candidate buckets they're moved to. Two
+// filters with sources seeded alike make the same kicks for the same inserts, which
+// reproduces the eviction sequence of a failing insert. The draws are serialized on the
+// source, which isn't safe for concurrent use. _random_ nil restores the global source of
+// math/rand, the default.
+func (cuckooFilter *AbstractCuckooFilter) SetRand(random *rand.Rand) {
+	cuckooFilter.randomLock.Lock()
+	defer cuckooFilter.randomLock.Unlock()
+	cuckooFilter.random = random
+}
+
+// SetSeed sets the source of the random draws of the Cuckoo Filter to a source seeded with
+// _seed_, see SetRand
+func (cuckooFilter *AbstractCuckooFilter) SetSeed(seed int64) {
+	cuckooFilter.SetRand(rand.New(rand.NewSource(seed)))
+}
+
+// randInt63n returns a random number in [0, _n_) drawn from the source of the filter
+func (cuckooFilter *AbstractCuckooFilter) randInt63n(n int64) int64 {
+	cuckooFilter.randomLock.Lock()
+	defer cuckooFilter.randomLock.Unlock()
+	if cuckooFilter.random == nil {
+		return rand.Int63n(n)
+	}
+	return cuckooFilter.random.Int63n(n)
+}
+
+// randInt31 returns a random non-negative 31 bits number drawn from the source of the filter
+func (cuckooFilter *AbstractCuckooFilter) randInt31() int32 {
+	cuckooFilter.randomLock.Lock()
+	defer cuckooFilter.randomLock.Unlock()
+	if cuckooFilter.random == nil {
+		return rand.Int31()
+	}
+	return cuckooFilter.random.Int31()
+}
+
+// kickPosition returns a random position, uniformly drawn, of the entry to kick out of a
+// full bucket holding _length_ entries. It errors out if the bucket is empty, which happens
+// on corrupted buckets only, rather than returning a position out of the bucket.
+func (cuckooFilter *AbstractCuckooFilter) kickPosition(length uint64) (uint64, error) {
+	if length == 0 {
+		return 0, fmt.Errorf("gostatix: cannot kick an entry out of an empty bucket")
+	}
+	return uint64(cuckooFilter.randInt63n(int64(length))), nil
+}
+
+// CuckooPositiveRate returns the false positive error rate of the filter when all its
+// cells are occupied, which bounds the rate of the filter as it fills up. The fingerprints
+// are decimal digits, so the rate depends on the number of possible fingerprints of
+// _fingerPrintLength_ digits.
 func (cuckooFilter *AbstractCuckooFilter) CuckooPositiveRate() float64 {
-	return math.Pow(2, math.Log2(float64(2*cuckooFilter.bucketSize))-float64(cuckooFilter.fingerPrintLength))
+	return cuckooFilter.positiveRate(cuckooFilter.CellSize())
+}
+
+// positiveRate returns the probability that an element absent from the filter matches one
+// of the fingerprints of its candidate buckets when the filter holds _length_ entries, i.e.
+// 1 - (1 - q)^(candidates * bucketSize * load) where q is the probability of two fingerprints
+// being equal and load the fraction of the cells of the filter which are occupied
+func (cuckooFilter *AbstractCuckooFilter) positiveRate(length uint64) float64 {
+	load := math.Min(float64(length)/float64(cuckooFilter.CellSize()), 1)
+	collisionRate := fingerPrintCollisionRate(cuckooFilter.fingerPrintLength)
+	if rater, ok := cuckooFilter.Hashing().(interface{ CollisionRate(uint64) float64 }); ok {
+		collisionRate = rater.CollisionRate(cuckooFilter.fingerPrintLength)
+	}
+	return 1 - math.Pow(1-collisionRate, float64(cuckooFilter.Candidates()*cuckooFilter.bucketSize)*load)
+}
+
+// fingerPrintCollisionRate returns the probability of the fingerprints of two elements being
+// equal when they are the first _fingerPrintLength_ decimal digits of a 64-bit hash,
+// which aren't uniform: the hashes of 20 digits (above 10^19) all start with 1, so the
+// fingerprints starting with 1 are more likely than the others.
+func fingerPrintCollisionRate(fingerPrintLength uint64) float64 {
+	minFingerPrint := math.Pow10(int(fingerPrintLength) - 1)
+	// probability of a hash of less than 20 digits, its fingerprints are uniform
+	lowMass := 1e19 / math.Exp2(64)
+	// number of fingerprints of the hashes of 20 digits
+	highWidth := math.Ceil((math.Exp2(64)/1e19 - 1) * minFingerPrint)
+	low := lowMass / (9 * minFingerPrint)
+	high := (1 - lowMass) / highWidth
+	return highWidth*(low+high)*(low+high) + (9*minFingerPrint-highWidth)*low*low
+}
+
+// getHistoryHash returns the hash of _data_ recorded in the insert history of a filter
+// with safe removes, independent from the hash the fingerprints are derived from
+func getHistoryHash(data []byte) uint64 {
+	return metro.Hash64(data, 7919)
+}
+
+// cuckooEntry identifies the entries of the elements of fingerprint _fingerPrint_ and
+// buckets _lowIndex_ and _highIndex_, in increasing order, whatever the bucket they're in.
+// The payloads of the filters are keyed by entry, so that they don't move when the entries
+// are kicked to their other bucket.
+type cuckooEntry struct {
+	fingerPrint uint64
+	lowIndex    uint64
+	highIndex   uint64
+}
+
+// newCuckooEntry returns the cuckooEntry of _fingerPrint_ and the buckets _fIndex_ and _sIndex_
+func newCuckooEntry(fingerPrint, fIndex, sIndex uint64) cuckooEntry {
+	if sIndex < fIndex {
+		fIndex, sIndex = sIndex, fIndex
+	}
+	return cuckooEntry{fingerPrint, fIndex, sIndex}
+}
+
+// field returns the field of the entry in the Redis hash of the payloads of a filter
+func (entry cuckooEntry) field() string {
+	return strconv.FormatUint(entry.fingerPrint, 10) + ":" + strconv.FormatUint(entry.lowIndex, 10) + ":" + strconv.FormatUint(entry.highIndex, 10)
 }
 
 func (cuckooFilter *AbstractCuckooFilter) getPositions(data []byte) (string, uint64, uint64, error) {
-	hash := getHash(data)
-	hashString := strconv.FormatUint(hash, 10)
-	if cuckooFilter.fingerPrintLength > uint64(len(hashString)) {
-		return "", 0, 0, fmt.Errorf("gostatix: the fingerprint length %d is higher than the hash length %d", cuckooFilter.fingerPrintLength, len(hashString))
+	fingerPrint, firstIndex, secondIndex, err := cuckooFilter.getFingerPrintPositions(data)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return strconv.FormatUint(fingerPrint, 10), firstIndex, secondIndex, nil
+}
+
+// getFingerPrintPositions returns the fingerprint of _data_ as an integer along with the
+// indices of its two buckets, as derived by the hashing of the filter. It doesn't allocate
+// with the built-in hashings.
+func (cuckooFilter *AbstractCuckooFilter) getFingerPrintPositions(data []byte) (uint64, uint64, uint64, error) {
+	hashing := cuckooFilter.Hashing()
+	hash := cuckooFilter.hash(data)
+	fingerPrint, err := hashing.FingerPrint(hash, cuckooFilter.fingerPrintLength)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if fingerPrint < pow10[cuckooFilter.fingerPrintLength-1] || decimalDigits(fingerPrint) > cuckooFilter.fingerPrintLength {
+		return 0, 0, 0, fmt.Errorf("gostatix: fingerprint %d of cuckoo hashing %s doesn't have %d digits", fingerPrint, hashing.Name(), cuckooFilter.fingerPrintLength)
 	}
-	fingerPrint := hashString[:cuckooFilter.fingerPrintLength]
 	firstIndex := hash % cuckooFilter.size
-	secondHash := getHash([]byte(fingerPrint))
-	secondIndex := (firstIndex ^ secondHash) % cuckooFilter.size
+	secondIndex := cuckooFilter.getAltIndex(firstIndex, fingerPrint)
 	return fingerPrint, firstIndex, secondIndex, nil
 }
 
+// getAltIndex returns the other bucket index of the _fingerPrint_ stored in the bucket at _index_:
+// (index ^ hash) % size when the size is a power of two, else (hash - index) modulo the size,
+// hash being the hash of the fingerprint. Both are involutions, so that an entry kicked out
+// of its other bucket is moved back to the bucket it came from. The xor modulo the size isn't
+// one when the size isn't a power of two, the entries kicked out of their buckets then being
+// lost, but the filters of these sizes saved by the former versions keep using it (see
+// differenceAltIndex) so that the entries they hold are still found.
+func (cuckooFilter *AbstractCuckooFilter) getAltIndex(index, fingerPrint uint64) uint64 {
+	hash := cuckooFilter.fingerPrintHash(fingerPrint)
+	if !cuckooFilter.differenceAltIndex() {
+		return (index ^ hash) % cuckooFilter.size
+	}
+	hash %= cuckooFilter.size
+	if hash >= index {
+		return hash - index
+	}
+	return hash + (cuckooFilter.size - index)
+}
+
+// differenceAltIndex returns true if the alternate index of the filter is the difference
+// of the hash of the fingerprint and the index, i.e. if its size isn't a power of two and it
+// wasn't saved by a former version. It's saved along with the filter.
+func (cuckooFilter *AbstractCuckooFilter) differenceAltIndex() bool {
+	return !cuckooFilter.legacyAltIndex && cuckooFilter.size&(cuckooFilter.size-1) != 0
+}
+
+// maxCuckooCandidates is the maximum number of candidate buckets of a fingerprint
+const maxCuckooCandidates = 4
+
+// checkCuckooCandidates validates the number of _candidates_ buckets of the fingerprints
+// of a filter of _size_ buckets. With more than 2 candidates, the size should be a power
+// of the number of candidates for the candidate buckets to cycle, see candidateStep.
+func checkCuckooCandidates(size, candidates uint64) error {
+	if candidates < 2 || candidates > maxCuckooCandidates {
+		return fmt.Errorf("gostatix: cuckoo filter candidate buckets %d should be between 2 and %d", candidates, maxCuckooCandidates)
+	}
+	if candidates == 2 {
+		return nil
+	}
+	power := uint64(1)
+	for power < size && power <= math.MaxUint64/candidates {
+		power *= candidates
+	}
+	if power != size {
+		return fmt.Errorf("gostatix: cuckoo filter size %d should be a power of its %d candidate buckets", size, candidates)
+	}
+	return nil
+}
+
+// cuckooCandidates holds the distinct candidate buckets of a fingerprint, the first one
+// being the bucket derived from the hash of its element
+type cuckooCandidates struct {
+	indexes [maxCuckooCandidates]uint64
+	n       int
+}
+
+// list returns the indexes of the candidate buckets
+func (c *cuckooCandidates) list() []uint64 {
+	return c.indexes[:c.n]
+}
+
+// entry returns the cuckooEntry of _fingerPrint_ in the candidate buckets, keyed by the
+// lowest and the highest of them so that it's the same whatever the bucket the entry is in
+func (c *cuckooCandidates) entry(fingerPrint uint64) cuckooEntry {
+	low, high := c.indexes[0], c.indexes[0]
+	for _, index := range c.list() {
+		if index < low {
+			low = index
+		}
+		if index > high {
+			high = index
+		}
+	}
+	return cuckooEntry{fingerPrint, low, high}
+}
+
+// pairCandidates returns the candidates of the two-bucket scheme, the buckets at _fIndex_
+// and _sIndex_
+func pairCandidates(fIndex, sIndex uint64) cuckooCandidates {
+	c := cuckooCandidates{n: 1}
+	c.indexes[0] = fIndex
+	if sIndex != fIndex {
+		c.indexes[1] = sIndex
+		c.n = 2
+	}
+	return c
+}
+
+// getCandidates returns the candidate buckets of the _fingerPrint_ stored in the bucket at
+// _index_. They're the same whatever the candidate bucket _index_ is.
+func (cuckooFilter *AbstractCuckooFilter) getCandidates(index, fingerPrint uint64) cuckooCandidates {
+	if cuckooFilter.Candidates() == 2 {
+		return pairCandidates(index, cuckooFilter.getAltIndex(index, fingerPrint))
+	}
+	c := cuckooCandidates{n: 1}
+	c.indexes[0] = index
+	offset := cuckooFilter.fingerPrintHash(fingerPrint) % cuckooFilter.size
+	for steps := uint64(1); steps < cuckooFilter.candidates; steps++ {
+		next := cuckooFilter.candidateStep(index, offset, steps)
+		seen := false
+		for _, candidate := range c.list() {
+			seen = seen || candidate == next
+		}
+		if !seen {
+			c.indexes[c.n] = next
+			c.n++
+		}
+	}
+	return c
+}
+
+// candidateStep returns the bucket _steps_ away from the bucket at _index_ in the cycle of
+// the candidate buckets of a fingerprint whose hash is _offset_ modulo the size, when there
+// are d > 2 candidates. The base d digits of the index and of the offset are added modulo
+// d, _steps_ times, so that d steps lead back to _index_. It generalizes the xor of the
+// two-bucket scheme, which is the same with d = 2.
+func (cuckooFilter *AbstractCuckooFilter) candidateStep(index, offset, steps uint64) uint64 {
+	d := cuckooFilter.candidates
+	next := uint64(0)
+	for place := uint64(1); place < cuckooFilter.size; place *= d {
+		digit := (index/place%d + steps*(offset/place%d)) % d
+		next += digit * place
+	}
+	return next
+}
+
+// getKickIndex returns the bucket to which the _fingerPrint_ kicked out of the bucket at
+// _index_ is moved: its other bucket, or one of its other candidate buckets drawn at random
+func (cuckooFilter *AbstractCuckooFilter) getKickIndex(index, fingerPrint uint64) uint64 {
+	if cuckooFilter.Candidates() == 2 {
+		return cuckooFilter.getAltIndex(index, fingerPrint)
+	}
+	offset := cuckooFilter.fingerPrintHash(fingerPrint) % cuckooFilter.size
+	return cuckooFilter.candidateStep(index, offset, 1+uint64(cuckooFilter.randInt63n(int64(cuckooFilter.candidates-1))))
+}
+
+// fingerPrintHash returns the hash of _fingerPrint_ returned by the hashing of the filter if
+// it implements FingerPrintHash, else the hash of its decimal string formatted in a buffer
+// on the stack
+func (cuckooFilter *AbstractCuckooFilter) fingerPrintHash(fingerPrint uint64) uint64 {
+	if hasher, ok := cuckooFilter.hashing.(fingerPrintHasher); ok {
+		return hasher.FingerPrintHash(fingerPrint)
+	}
+	var buf [maxFingerPrintLength]byte
+	return cuckooFilter.hash(strconv.AppendUint(buf[:0], fingerPrint, 10))
+}
+
+// fingerPrintStringHash returns the hash of the _fingerPrint_ string, see fingerPrintHash
+func (cuckooFilter *AbstractCuckooFilter) fingerPrintStringHash(fingerPrint string) uint64 {
+	if hasher, ok := cuckooFilter.hashing.(fingerPrintHasher); ok {
+		if value, err := strconv.ParseUint(fingerPrint, 10, 64); err == nil {
+			return hasher.FingerPrintHash(value)
+		}
+	}
+	return cuckooFilter.hash([]byte(fingerPrint))
+}
+
+// fingerPrintHasher is implemented by the hashings which don't hash the fingerprints as
+// their decimal strings
+type fingerPrintHasher interface {
+	FingerPrintHash(fingerPrint uint64) uint64
+}
+
+// hash returns the hash of _data_ with the hashing of the filter. The built-in hashings
+// are called directly and a custom one is passed a copy of _data_, so that _data_ doesn't
+// escape to the heap.
+func (cuckooFilter *AbstractCuckooFilter) hash(data []byte) uint64 {
+	switch hashing := cuckooFilter.hashing.(type) {
+	case nil:
+		return getHash(data)
+	case *cuckooHashing:
+		return hashing.Hash(data)
+	default:
+		return hashing.Hash(append([]byte(nil), data...))
+	}
+}
+
+// pow10 holds the powers of 10 which fit in an uint64
+var pow10 = [maxFingerPrintLength]uint64{
+	1, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9,
+	1e10, 1e11, 1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18, 1e19,
+}
+
+// decimalDigits returns the number of decimal digits of _value_
+func decimalDigits(value uint64) uint64 {
+	numDigits := uint64(1)
+	for numDigits < maxFingerPrintLength && value >= pow10[numDigits] {
+		numDigits++
+	}
+	return numDigits
+}
+
+// getHash returns the murmur3 hash of _data_ used by MurmurCuckooHashing
 func getHash(data []byte) uint64 {
 	hash1, _ := sum128(data)
-	// hash := metro.Hash64(data, 1373)
 	return hash1
 }
@@ -33,37 +33,95 @@ type AbstractHyperLogLog struct {
 	numRegisters    uint64
 	numBytesPerHash uint64
 	correctionBias  float64
+	estimator       HyperLogLogEstimator
 }
 
 type hyperLogLogJSON struct {
-	NumRegisters    uint64  `json:"nr"`
-	NumBytesPerHash uint64  `json:"nbp"`
-	CorrectionBias  float64 `json:"c"`
-	Registers       []uint8 `json:"r"`
-	Key             string  `json:"k"`
+	NumRegisters    uint64               `json:"nr"`
+	NumBytesPerHash uint64               `json:"nbp"`
+	CorrectionBias  float64              `json:"c"`
+	Registers       []uint8              `json:"r"`
+	Key             string               `json:"k"`
+	Estimator       HyperLogLogEstimator `json:"e,omitempty"`
 }
 
-func makeAbstractHyperLogLog(numRegisters uint64) (*AbstractHyperLogLog, error) {
-	if numRegisters == 0 {
-		panic("gostatix: hyperloglog number of registers can't be zero")
+func makeAbstractHyperLogLog(numRegisters uint64, estimator HyperLogLogEstimator) (*AbstractHyperLogLog, error) {
+	err := HyperLogLogParams{numRegisters}.Validate()
+	if err != nil {
+		return nil, err
 	}
-	if numRegisters&(numRegisters-1) != 0 {
-		return nil, fmt.Errorf("gostatix: hyperloglog number of registers %d not a power of two", numRegisters)
+	if estimator > HLLPlusPlusEstimator {
+		return nil, fmt.Errorf("gostatix: unknown hyperloglog estimator %v", estimator)
 	}
 	h := &AbstractHyperLogLog{}
 	h.numRegisters = numRegisters
 	h.numBytesPerHash = uint64(math.Log2(float64(numRegisters)))
-	h.correctionBias = getAlpha(uint(numRegisters))
+	h.correctionBias = defaultCorrectionBias(estimator, numRegisters)
+	h.estimator = estimator
 	return h, nil
 }
 
+// validate checks the parameters and registers of the decoded snapshot
+func (g *hyperLogLogJSON) validate() error {
+	err := checkHyperLogLogParams(g.NumRegisters, g.NumBytesPerHash)
+	if err != nil {
+		return err
+	}
+	if uint64(len(g.Registers)) != g.NumRegisters {
+		return fmt.Errorf("gostatix: invalid hyperloglog snapshot, %d registers found instead of %d", len(g.Registers), g.NumRegisters)
+	}
+	return g.Estimator.validate()
+}
+
+// checkHyperLogLogParams validates the _numRegisters_ and _numBytesPerHash_ of a
+// decoded snapshot against the ones computed by makeAbstractHyperLogLog
+func checkHyperLogLogParams(numRegisters, numBytesPerHash uint64) error {
+	err := HyperLogLogParams{numRegisters}.Validate()
+	if err != nil {
+		return fmt.Errorf("gostatix: invalid hyperloglog snapshot, error: %v", err)
+	}
+	if numBytesPerHash != uint64(bits.TrailingZeros64(numRegisters)) {
+		return fmt.Errorf("gostatix: invalid hyperloglog snapshot, %d bytes per hash don't match %d registers", numBytesPerHash, numRegisters)
+	}
+	return checkSnapshotSize("hyperloglog", numRegisters, 1)
+}
+
 // NumRegisters returns the number of registers in the hyperloglog
 func (h *AbstractHyperLogLog) NumRegisters() uint64 {
 	return h.numRegisters
 }
 
-// Accuracy returns the accuracy of the hyperloglog
+// Precision returns the number of bits of the hashes selecting the registers of the
+// hyperloglog, the base 2 logarithm of its number of registers
+func (h *AbstractHyperLogLog) Precision() uint64 {
+	return h.numBytesPerHash
+}
+
+// Estimator returns the estimator used to count the distinct elements of the hyperloglog
+func (h *AbstractHyperLogLog) Estimator() HyperLogLogEstimator {
+	return h.estimator
+}
+
+// CorrectionBias returns the constant alpha multiplying the raw estimation of the
+// hyperloglog, which corrects the bias of the mean of its registers
+func (h *AbstractHyperLogLog) CorrectionBias() float64 {
+	return h.correctionBias
+}
+
+// checkCorrectionBias returns an error if _correctionBias_ can't be used as the constant
+// alpha of a hyperloglog
+func checkCorrectionBias(correctionBias float64) error {
+	if !(correctionBias > 0) || math.IsInf(correctionBias, 1) {
+		return fmt.Errorf("gostatix: hyperloglog correction bias %v should be a positive number", correctionBias)
+	}
+	return nil
+}
+
+// Accuracy returns the accuracy of the hyperloglog, the standard error of its estimator
 func (h *AbstractHyperLogLog) Accuracy() float64 {
+	if h.estimator == LogLogEstimator {
+		return 1.30 / math.Sqrt(float64(h.numRegisters))
+	}
 	return 1.04 / math.Sqrt(float64(h.numRegisters))
 }
 
@@ -83,6 +141,15 @@ func getAlpha(m uint) (result float64) {
 
 func (h *AbstractHyperLogLog) getRegisterIndexAndCount(data []byte) (uint64, uint64) {
 	hash, _ := metro.Hash128(data, 1373)
+	return h.getRegisterIndexAndCountOfHash(hash)
+}
+
+// getRegisterIndexAndCountOfHash is getRegisterIndexAndCount for an element whose first
+// hash is _hash_
+func (h *AbstractHyperLogLog) getRegisterIndexAndCountOfHash(hash uint64) (uint64, uint64) {
+	if h.estimator != LegacyEstimator {
+		return getRank(hash, h.numBytesPerHash)
+	}
 	k := 32 - h.numBytesPerHash
 	registerIndex := 1 + bits.LeadingZeros64(hash<<h.numBytesPerHash)
 	count := hash >> uint(k)
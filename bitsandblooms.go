@@ -0,0 +1,83 @@
+/*
+Implements loading the Bloom filters serialized by github.com/bits-and-blooms/bloom v3,
+so that persisted filters can be migrated without inserting their elements again.
+
+The filters are loaded with the hashing scheme of bits-and-blooms, the elements are
+looked up and inserted exactly like it does. The scheme is kept by Export and WriteTo,
+so the loaded filters can be persisted in the gostatix formats.
+*/
+package gostatix
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// NewMemBloomFilterFromBitsAndBlooms creates and returns a new in-memory BloomFilter from
+// the _stream_ written by WriteTo of a github.com/bits-and-blooms/bloom v3 BloomFilter.
+// It returns the number of bytes read along with the filter.
+func NewMemBloomFilterFromBitsAndBlooms(stream io.Reader) (*BloomFilter, int64, error) {
+	var size, numHashes uint64
+	err := binary.Read(stream, binary.BigEndian, &size)
+	if err != nil {
+		return nil, 0, err
+	}
+	err = binary.Read(stream, binary.BigEndian, &numHashes)
+	if err != nil {
+		return nil, 0, err
+	}
+	err = checkBloomFilterParams(size, numHashes)
+	if err != nil {
+		return nil, 0, err
+	}
+	set, numBytes, err := readSet(stream)
+	if err != nil {
+		return nil, 0, err
+	}
+	filter, err := newBitsAndBloomsFilter(size, numHashes, &BitSetMem{set, set.Len()})
+	if err != nil {
+		return nil, 0, err
+	}
+	return filter, numBytes + int64(2*binary.Size(uint64(0))), nil
+}
+
+// NewMemBloomFilterFromBitsAndBloomsJSON creates and returns a new in-memory BloomFilter
+// from the _data_ returned by MarshalJSON of a github.com/bits-and-blooms/bloom v3 BloomFilter
+func NewMemBloomFilterFromBitsAndBloomsJSON(data []byte) (*BloomFilter, error) {
+	var f struct {
+		M uint64          `json:"m"`
+		K uint64          `json:"k"`
+		B json.RawMessage `json:"b"`
+	}
+	err := json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, err
+	}
+	err = checkBloomFilterParams(f.M, f.K)
+	if err != nil {
+		return nil, err
+	}
+	bitSet := &BitSetMem{}
+	_, err = bitSet.unmarshal(f.B)
+	if err != nil {
+		return nil, err
+	}
+	return newBitsAndBloomsFilter(f.M, f.K, bitSet)
+}
+
+// newBitsAndBloomsFilter creates a BloomFilter using bits-and-blooms hashing of _size_ bits
+// and _numHashes_ hashes over _bitSet_, which can be longer than _size_
+func newBitsAndBloomsFilter(size, numHashes uint64, bitSet *BitSetMem) (*BloomFilter, error) {
+	if uint64(bitSet.size) < size {
+		return nil, fmt.Errorf("gostatix: invalid bits-and-blooms filter, bitset of %d bits is smaller than the size %d", bitSet.size, size)
+	}
+	bitSet.size = uint(size)
+	filter, err := NewBloomFilterWithBitSet(uint(size), uint(numHashes), bitSet, "")
+	if err != nil {
+		return nil, err
+	}
+	filter.hashing = BitsAndBloomsHashing
+	return filter, nil
+}
@@ -0,0 +1,93 @@
+package gostatix
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+// The files in testdata/bitsandblooms are written by github.com/bits-and-blooms/bloom v3.0.1
+// for a filter created with NewWithEstimates(1000, 0.01) holding "key0" to "key199"
+
+func TestBloomFilterFromBitsAndBlooms(t *testing.T) {
+	file, err := os.Open("testdata/bitsandblooms/bloom.bin")
+	if err != nil {
+		t.Fatalf("error while opening testdata, error: %v", err)
+	}
+	defer file.Close()
+	filter, numBytes, err := NewMemBloomFilterFromBitsAndBlooms(file)
+	if err != nil {
+		t.Fatalf("loading bits-and-blooms filter shouldn't error out, error: %v", err)
+	}
+	if numBytes != 1224 {
+		t.Errorf("1224 bytes should be read, got %d", numBytes)
+	}
+	checkBitsAndBloomsFilter(t, filter)
+}
+
+func TestBloomFilterFromBitsAndBloomsJSON(t *testing.T) {
+	data, err := os.ReadFile("testdata/bitsandblooms/bloom.json")
+	if err != nil {
+		t.Fatalf("error while reading testdata, error: %v", err)
+	}
+	filter, err := NewMemBloomFilterFromBitsAndBloomsJSON(data)
+	if err != nil {
+		t.Fatalf("loading bits-and-blooms filter shouldn't error out, error: %v", err)
+	}
+	checkBitsAndBloomsFilter(t, filter)
+
+	exported, _ := filter.Export()
+	imported := NewMemBloomFilterFromBitSet(nil, 1)
+	err = imported.Import(exported)
+	if err != nil {
+		t.Fatalf("importing exported filter shouldn't error out, error: %v", err)
+	}
+	if ok, _ := filter.Equals(imported); !ok {
+		t.Errorf("imported filter should keep the bits-and-blooms hashing")
+	}
+}
+
+func TestBloomFilterFromBitsAndBloomsInvalid(t *testing.T) {
+	_, err := NewMemBloomFilterFromBitsAndBloomsJSON([]byte(`{"m":10,"k":0,"b":""}`))
+	if err == nil {
+		t.Errorf("loading a filter without hashes should error out")
+	}
+}
+
+func checkBitsAndBloomsFilter(t *testing.T, filter *BloomFilter) {
+	t.Helper()
+	if filter.GetCap() != 9586 || filter.GetNumHashes() != 7 {
+		t.Errorf("filter should have 9586 bits and 7 hashes, got %d and %d", filter.GetCap(), filter.GetNumHashes())
+	}
+	for i := 0; i < 200; i++ {
+		if !filter.LookupString("key" + strconv.Itoa(i)) {
+			t.Errorf("key%d should be found in the filter", i)
+		}
+	}
+	falsePositives := 0
+	for i := 200; i < 1200; i++ {
+		if filter.LookupString("key" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 10 {
+		t.Errorf("filter holding 200 keys should have few false positives, got %d", falsePositives)
+	}
+	before, _ := filter.filter.bitCount()
+	filter.InsertString("key0")
+	if after, _ := filter.filter.bitCount(); after != before {
+		t.Errorf("inserting a key already in the filter shouldn't set any bit")
+	}
+}
+
+func TestSum256(t *testing.T) {
+	data := []byte("abcdefghijklmnopqrstuvwxyz0123456789")
+	for length := 0; length <= len(data); length++ {
+		hashes := sum256(data[:length])
+		h1, h2 := sum128(data[:length])
+		h3, h4 := sum128(append(append([]byte{}, data[:length]...), 1))
+		if hashes != [4]uint64{h1, h2, h3, h4} {
+			t.Errorf("hashes of %d bytes should be the murmur3 hashes of the data with and without a trailing 1", length)
+		}
+	}
+}
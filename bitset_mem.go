@@ -6,9 +6,15 @@ for redis, bitset operations of redis are used.
 package gostatix
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
+	"math/bits"
+	"runtime"
+	"sync"
 
 	"github.com/bits-and-blooms/bitset"
 )
@@ -21,6 +27,17 @@ type BitSetMem struct {
 	size uint
 }
 
+// NewBitSetMem creates a new in-memory BitSet of _size_ bits, all unset
+func NewBitSetMem(size uint) *BitSetMem {
+	return newBitSetMem(size)
+}
+
+// NewBitSetMemFromWords creates a new in-memory BitSet of len(_words_) * 64 bits from
+// _words_, the bit _i_ being the bit i % 64 of the word i / 64. _words_ is used as is.
+func NewBitSetMemFromWords(words []uint64) *BitSetMem {
+	return fromDataMem(words)
+}
+
 // NewBitSetMem creates a new BitSetMem of size _size_
 func newBitSetMem(size uint) *BitSetMem {
 	return &BitSetMem{bitset.New(size), size}
@@ -33,44 +50,369 @@ func fromDataMem(data []uint64) *BitSetMem {
 }
 
 // Size returns the size of the bitset
-func (bitSet BitSetMem) getSize() uint {
+func (bitSet *BitSetMem) getSize() uint {
 	return bitSet.size
 }
 
 // Has checks if the bit at index _index_ is set
-func (bitSet BitSetMem) has(index uint) (bool, error) {
+func (bitSet *BitSetMem) has(index uint) (bool, error) {
 	return bitSet.set.Test(index), nil
 }
 
 // HasMulti checks if the bit at the indices
 // specified by _indexes_ array is set
-func (bitSet BitSetMem) hasMulti(indexes []uint) ([]bool, error) {
-	return nil, nil //not implemented
+func (bitSet *BitSetMem) hasMulti(indexes []uint) ([]bool, error) {
+	result := make([]bool, len(indexes))
+	for i, index := range indexes {
+		result[i] = bitSet.set.Test(index)
+	}
+	return result, nil
 }
 
-func (bitSet BitSetMem) insertMulti(indexes []uint) (bool, error) {
-	return false, nil //not implemented
+// InsertMulti sets the bits at the indices specified by _indexes_ array
+func (bitSet *BitSetMem) insertMulti(indexes []uint) (bool, error) {
+	for _, index := range indexes {
+		bitSet.set.Set(index)
+	}
+	return true, nil
+}
+
+// InsertMultiIfMissing sets the bits at the indices specified by _indexes_ array and
+// returns true if any of them wasn't set
+func (bitSet *BitSetMem) insertMultiIfMissing(indexes []uint) (bool, error) {
+	missing := false
+	for _, index := range indexes {
+		if !bitSet.set.Test(index) {
+			missing = true
+			bitSet.set.Set(index)
+		}
+	}
+	return missing, nil
 }
 
 // Insert sets the bit at index specified by _index_
-func (bitSet BitSetMem) insert(index uint) (bool, error) {
+func (bitSet *BitSetMem) insert(index uint) (bool, error) {
 	bitSet.set.Set(index)
 	return true, nil
 }
 
 // Max returns the first set bit in the bitset starting from index 0
-func (bitSet BitSetMem) max() (uint, bool) {
+func (bitSet *BitSetMem) max() (uint, bool) {
 	index, ok := bitSet.set.NextSet(0)
 	return index, ok
 }
 
 // BitCount returns the total number of set bits in the bitset
-func (bitSet BitSetMem) bitCount() (uint, error) {
-	return bitSet.set.Count(), nil
+func (bitSet *BitSetMem) bitCount() (uint, error) {
+	return bitSet.PopcountRange(0, bitSet.size)
+}
+
+// PopcountRange returns the number of set bits in the range [_start_, _end_) of the bitset.
+// The words of large bitsets are counted in chunks on multiple goroutines.
+func (bitSet *BitSetMem) PopcountRange(start, end uint) (uint, error) {
+	if err := checkBitRange(start, end, bitSet.size); err != nil {
+		return 0, err
+	}
+	if start == end {
+		return 0, nil
+	}
+	words := bitSet.set.Bytes()
+	first, last := start/uint(wordSize), (end-1)/uint(wordSize)
+	firstMask := ^uint64(0) << (start % uint(wordSize))
+	lastMask := ^uint64(0) >> (uint(wordSize) - 1 - (end-1)%uint(wordSize))
+	if first == last {
+		return uint(bits.OnesCount64(words[first] & firstMask & lastMask)), nil
+	}
+	count := uint(bits.OnesCount64(words[first]&firstMask) + bits.OnesCount64(words[last]&lastMask))
+	inner := words[first+1 : last]
+	counts := make([]uint, numWordChunks(len(inner)))
+	forEachWordChunk(len(inner), func(chunk, from, to int) {
+		chunkCount := 0
+		for _, word := range inner[from:to] {
+			chunkCount += bits.OnesCount64(word)
+		}
+		counts[chunk] = uint(chunkCount)
+	})
+	for _, chunkCount := range counts {
+		count += chunkCount
+	}
+	return count, nil
+}
+
+// OrWords sets the bits set in _words_ in the bitset, _words_ being the words of another
+// bitset starting at the word _offset_ of the bitset. It's used to merge bitsets of the
+// same size a word at a time. The words of large bitsets are processed in chunks on
+// multiple goroutines.
+func (bitSet *BitSetMem) OrWords(words []uint64, offset uint) error {
+	setWords := bitSet.set.Bytes()
+	if offset > uint(len(setWords)) || uint(len(words)) > uint(len(setWords))-offset {
+		return fmt.Errorf("gostatix: %d words at offset %d don't fit in the %d words of the bitset", len(words), offset, len(setWords))
+	}
+	target := setWords[offset : offset+uint(len(words))]
+	forEachWordChunk(len(words), func(_, from, to int) {
+		for i := from; i < to; i++ {
+			target[i] |= words[i]
+		}
+	})
+	// the bits past the size of the bitset are never set
+	if tail := bitSet.size % uint(wordSize); tail != 0 && offset+uint(len(words)) == uint(len(setWords)) {
+		setWords[len(setWords)-1] &= ^uint64(0) >> (uint(wordSize) - tail)
+	}
+	return nil
+}
+
+// clear unsets all the bits of the bitset, keeping its size
+func (bitSet *BitSetMem) clear() error {
+	bitSet.set.ClearAll()
+	return nil
+}
+
+// memoryUsage returns the number of bytes used by the words of the bitset
+func (bitSet *BitSetMem) memoryUsage() (uint64, error) {
+	return uint64(cap(bitSet.set.Bytes()) * wordBytes), nil
+}
+
+// union sets the bits set in _otherBitSet_, which should be a BitSetMem of the same size
+func (bitSet *BitSetMem) union(otherBitSet IBitSet) error {
+	secondBitSet, ok := asBitSetMem(otherBitSet)
+	if !ok {
+		return fmt.Errorf("gostatix: invalid bitset type, should be BitSetMem, type: %T", otherBitSet)
+	}
+	if bitSet.size != secondBitSet.size {
+		return fmt.Errorf("gostatix: can't merge bitsets of different sizes %d and %d", bitSet.size, secondBitSet.size)
+	}
+	return bitSet.OrWords(secondBitSet.set.Bytes(), 0)
+}
+
+// Size returns the number of bits of the bitset
+func (bitSet *BitSetMem) Size() uint {
+	return bitSet.size
+}
+
+// Test returns true if the bit at _index_ is set
+func (bitSet *BitSetMem) Test(index uint) (bool, error) {
+	if err := checkBitIndex(index, bitSet.size); err != nil {
+		return false, err
+	}
+	return bitSet.set.Test(index), nil
+}
+
+// Set sets the bit at _index_
+func (bitSet *BitSetMem) Set(index uint) error {
+	if err := checkBitIndex(index, bitSet.size); err != nil {
+		return err
+	}
+	bitSet.set.Set(index)
+	return nil
+}
+
+// Clear unsets the bit at _index_
+func (bitSet *BitSetMem) Clear(index uint) error {
+	if err := checkBitIndex(index, bitSet.size); err != nil {
+		return err
+	}
+	bitSet.set.Clear(index)
+	return nil
+}
+
+// Flip flips the bit at _index_
+func (bitSet *BitSetMem) Flip(index uint) error {
+	if err := checkBitIndex(index, bitSet.size); err != nil {
+		return err
+	}
+	bitSet.set.Flip(index)
+	return nil
+}
+
+// SetRange sets the bits in [_start_, _end_)
+func (bitSet *BitSetMem) SetRange(start, end uint) error {
+	return bitSet.applyRange(start, end, func(word, mask uint64) uint64 { return word | mask })
+}
+
+// ClearRange unsets the bits in [_start_, _end_)
+func (bitSet *BitSetMem) ClearRange(start, end uint) error {
+	return bitSet.applyRange(start, end, func(word, mask uint64) uint64 { return word &^ mask })
+}
+
+// FlipRange flips the bits in [_start_, _end_)
+func (bitSet *BitSetMem) FlipRange(start, end uint) error {
+	return bitSet.applyRange(start, end, func(word, mask uint64) uint64 { return word ^ mask })
+}
+
+// applyRange replaces each word holding bits of [_start_, _end_) by _fn_ of the word and
+// of the mask of its bits in the range
+func (bitSet *BitSetMem) applyRange(start, end uint, fn func(word, mask uint64) uint64) error {
+	if err := checkBitRange(start, end, bitSet.size); err != nil {
+		return err
+	}
+	if start == end {
+		return nil
+	}
+	words := bitSet.set.Bytes()
+	first, last := start/uint(wordSize), (end-1)/uint(wordSize)
+	for i := first; i <= last; i++ {
+		mask := ^uint64(0)
+		if i == first {
+			mask &= ^uint64(0) << (start % uint(wordSize))
+		}
+		if i == last {
+			mask &= ^uint64(0) >> (uint(wordSize) - 1 - (end-1)%uint(wordSize))
+		}
+		words[i] = fn(words[i], mask)
+	}
+	return nil
+}
+
+// Count returns the number of bits set
+func (bitSet *BitSetMem) Count() (uint, error) {
+	return bitSet.bitCount()
+}
+
+// And unsets the bits which aren't set in _other_, an in-memory bitset of the same size
+func (bitSet *BitSetMem) And(other BitSet) error {
+	otherBitSet, err := bitSet.sameSize(other)
+	if err != nil {
+		return err
+	}
+	bitSet.set.InPlaceIntersection(otherBitSet.set)
+	return nil
+}
+
+// Or sets the bits set in _other_, an in-memory bitset of the same size
+func (bitSet *BitSetMem) Or(other BitSet) error {
+	otherBitSet, err := bitSet.sameSize(other)
+	if err != nil {
+		return err
+	}
+	return bitSet.OrWords(otherBitSet.set.Bytes(), 0)
+}
+
+// Xor flips the bits set in _other_, an in-memory bitset of the same size
+func (bitSet *BitSetMem) Xor(other BitSet) error {
+	otherBitSet, err := bitSet.sameSize(other)
+	if err != nil {
+		return err
+	}
+	bitSet.set.InPlaceSymmetricDifference(otherBitSet.set)
+	return nil
+}
+
+// sameSize returns the BitSetMem of _other_ if it's an in-memory bitset of the same size
+func (bitSet *BitSetMem) sameSize(other BitSet) (*BitSetMem, error) {
+	var otherBitSet *BitSetMem
+	switch other := other.(type) {
+	case *BitSetMem:
+		otherBitSet = other
+	case *BitSetMmap:
+		otherBitSet = other.BitSetMem
+	default:
+		return nil, fmt.Errorf("gostatix: invalid bitset type, should be BitSetMem, type: %T", other)
+	}
+	if otherBitSet.size != bitSet.size {
+		return nil, fmt.Errorf("gostatix: can't combine bitsets of different sizes %d and %d", bitSet.size, otherBitSet.size)
+	}
+	return otherBitSet, nil
+}
+
+// Not flips all the bits
+func (bitSet *BitSetMem) Not() error {
+	return bitSet.FlipRange(0, bitSet.size)
+}
+
+// Grow extends the bitset to _size_ bits, the new bits are unset. The words are
+// reallocated if they can't hold _size_ bits.
+func (bitSet *BitSetMem) Grow(size uint) error {
+	if size < bitSet.size {
+		return fmt.Errorf("gostatix: can't grow bitset of size %d to %d bits", bitSet.size, size)
+	}
+	bitSet.resize(size)
+	return nil
+}
+
+// Shrink truncates the bitset to its first _size_ bits and releases the words which
+// aren't needed anymore
+func (bitSet *BitSetMem) Shrink(size uint) error {
+	if size > bitSet.size {
+		return fmt.Errorf("gostatix: can't shrink bitset of size %d to %d bits", bitSet.size, size)
+	}
+	err := bitSet.ClearRange(size, bitSet.size)
+	if err != nil {
+		return err
+	}
+	bitSet.resize(size)
+	return nil
+}
+
+// resize copies the words of the bitset to words holding _size_ bits, the bits past the
+// current size being unset
+func (bitSet *BitSetMem) resize(size uint) {
+	words := make([]uint64, wordsFor(uint64(size)))
+	copy(words, bitSet.set.Bytes())
+	bitSet.set = bitset.FromWithLength(size, words)
+	bitSet.size = size
+}
+
+// NextSet returns the index of the first bit set from _index_ included, false if none
+func (bitSet *BitSetMem) NextSet(index uint) (uint, bool, error) {
+	if index >= bitSet.size {
+		return 0, false, nil
+	}
+	next, ok := bitSet.set.NextSet(index)
+	return next, ok, nil
+}
+
+// ForEach calls _fn_ with the index of each bit set in increasing order, until _fn_
+// returns false
+func (bitSet *BitSetMem) ForEach(fn func(index uint) bool) error {
+	index, ok := bitSet.set.NextSet(0)
+	for ok && fn(index) {
+		index, ok = bitSet.set.NextSet(index + 1)
+	}
+	return nil
+}
+
+// wordChunkSize is the number of words processed by a goroutine in the batch operations
+// of BitSetMem, smaller slices of words are processed on the calling goroutine
+const wordChunkSize = 1 << 16
+
+// numWordChunks returns the number of chunks _forEachWordChunk_ splits _numWords_ words in
+func numWordChunks(numWords int) int {
+	chunks := (numWords + wordChunkSize - 1) / wordChunkSize
+	if procs := runtime.GOMAXPROCS(0); chunks > procs {
+		chunks = procs
+	}
+	if chunks < 1 {
+		chunks = 1
+	}
+	return chunks
+}
+
+// forEachWordChunk splits the range [0, _numWords_) in chunks and calls _fn_ with the
+// index of the chunk and its range, concurrently when there is more than one chunk
+func forEachWordChunk(numWords int, fn func(chunk, from, to int)) {
+	chunks := numWordChunks(numWords)
+	if chunks == 1 {
+		fn(0, 0, numWords)
+		return
+	}
+	chunkLength := (numWords + chunks - 1) / chunks
+	var wg sync.WaitGroup
+	for chunk := 0; chunk < chunks; chunk++ {
+		from, to := chunk*chunkLength, (chunk+1)*chunkLength
+		if to > numWords {
+			to = numWords
+		}
+		wg.Add(1)
+		go func(chunk, from, to int) {
+			defer wg.Done()
+			fn(chunk, from, to)
+		}(chunk, from, to)
+	}
+	wg.Wait()
 }
 
 // Export returns the json marshalling of the bitset
-func (bitSet BitSetMem) marshal() (uint, []byte, error) {
+func (bitSet *BitSetMem) marshal() (uint, []byte, error) {
 	data, err := bitSet.set.MarshalJSON()
 	if err != nil {
 		return 0, nil, err
@@ -79,7 +421,7 @@ func (bitSet BitSetMem) marshal() (uint, []byte, error) {
 }
 
 // ExportBinary returns the binary marshalling of the bitset
-func (bitSet BitSetMem) exportBinary() (uint, []byte, error) {
+func (bitSet *BitSetMem) exportBinary() (uint, []byte, error) {
 	data, err := bitSet.set.MarshalBinary()
 	if err != nil {
 		return 0, nil, err
@@ -89,17 +431,27 @@ func (bitSet BitSetMem) exportBinary() (uint, []byte, error) {
 
 // Import imports the marshalled json in the byte array data into the redis bitset
 func (bitSet *BitSetMem) unmarshal(data []byte) (bool, error) {
-	err := bitSet.set.UnmarshalJSON(data)
-	bitSet.size = bitSet.set.Len()
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return false, err
+	}
+	buf, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return false, err
+	}
+	set, _, err := readSet(bytes.NewReader(buf))
 	if err != nil {
 		return false, err
 	}
+	bitSet.set = set
+	bitSet.size = set.Len()
 	return true, nil
 }
 
 // Equals checks if two BitSetMem are equal or not
 func (firstBitSet *BitSetMem) equals(otherBitSet IBitSet) (bool, error) {
-	secondBitSet, ok := otherBitSet.(*BitSetMem)
+	secondBitSet, ok := asBitSetMem(otherBitSet)
 	if !ok {
 		return false, fmt.Errorf("invalid bitset type, should be BitSetMem, type: %v", secondBitSet)
 	}
@@ -126,8 +478,7 @@ func (bitSet *BitSetMem) readFrom(stream io.Reader) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	set := &bitset.BitSet{}
-	numBytes, err := set.ReadFrom(stream)
+	set, numBytes, err := readSet(stream)
 	if err != nil {
 		return 0, err
 	}
@@ -135,3 +486,19 @@ func (bitSet *BitSetMem) readFrom(stream io.Reader) (int64, error) {
 	bitSet.set = set
 	return numBytes + int64(binary.Size(uint64(0))), nil
 }
+
+// readSet reads a bitset in the format written by bitset.WriteTo, checking its
+// length against MaxSnapshotSize before reading the words
+func readSet(stream io.Reader) (*bitset.BitSet, int64, error) {
+	var length uint64
+	err := binary.Read(stream, binary.BigEndian, &length)
+	if err != nil {
+		return nil, 0, err
+	}
+	numWords := wordsFor(length)
+	words, err := readUint64s(stream, numWords)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bitset.FromWithLength(uint(length), words), int64((numWords + 1) * uint64(wordBytes)), nil
+}
@@ -147,3 +147,180 @@ func TestBitSetMemBinaryReadWrite(t *testing.T) {
 		t.Fatalf("should be true at index 5, got %v", ok)
 	}
 }
+
+func TestBitSetMemPopcountRange(t *testing.T) {
+	// 3 chunks of words so the words are counted concurrently
+	size := uint(3*wordChunkSize*wordSize + 10)
+	bitset := newBitSetMem(size)
+	indexes := []uint{0, 5, 63, 64, 130, wordChunkSize * 64, 2*wordChunkSize*64 + 1, size - 1}
+	bitset.insertMulti(indexes)
+	ranges := [][3]uint{{0, size, 8}, {0, 0, 0}, {1, 63, 1}, {5, 6, 1}, {6, 64, 1}, {63, 131, 3}, {131, size - 1, 2}, {64, size, 5}}
+	for _, r := range ranges {
+		count, err := bitset.PopcountRange(r[0], r[1])
+		if err != nil || count != r[2] {
+			t.Errorf("%d bits should be set in [%d, %d), got %d, error: %v", r[2], r[0], r[1], count, err)
+		}
+	}
+	if count, _ := bitset.bitCount(); count != uint(len(indexes)) {
+		t.Errorf("%d bits should be set, got %d", len(indexes), count)
+	}
+	if _, err := bitset.PopcountRange(10, size+1); err == nil {
+		t.Error("range out of the bitset should error out")
+	}
+	if _, err := bitset.PopcountRange(10, 5); err == nil {
+		t.Error("inverted range should error out")
+	}
+}
+
+func TestBitSetMemOrWords(t *testing.T) {
+	aSet := newBitSetMem(uint(3*wordChunkSize*wordSize + 10))
+	bSet := newBitSetMem(uint(3*wordChunkSize*wordSize + 10))
+	aSet.insertMulti([]uint{1, 1000, 3 * wordChunkSize * 64})
+	bSet.insertMulti([]uint{2, 1000, 2 * wordChunkSize * 64})
+	err := aSet.union(bSet)
+	if err != nil {
+		t.Fatalf("union shouldn't error out, error: %v", err)
+	}
+	has, _ := aSet.hasMulti([]uint{1, 2, 1000, 2 * wordChunkSize * 64, 3 * wordChunkSize * 64, 3})
+	for i, expected := range []bool{true, true, true, true, true, false} {
+		if has[i] != expected {
+			t.Errorf("bit %d of the union should be %v", i, expected)
+		}
+	}
+
+	cSet := newBitSetMem(100)
+	err = cSet.OrWords([]uint64{1, ^uint64(0)}, 0)
+	if err != nil {
+		t.Fatalf("OrWords shouldn't error out, error: %v", err)
+	}
+	if count, _ := cSet.bitCount(); count != 37 || cSet.set.Count() != 37 {
+		t.Errorf("bits past the size shouldn't be set, %d bits set", cSet.set.Count())
+	}
+	if err := cSet.OrWords([]uint64{1}, 2); err == nil {
+		t.Error("words out of the bitset should error out")
+	}
+	if err := cSet.union(newBitSetMem(200)); err == nil {
+		t.Error("union of bitsets of different sizes should error out")
+	}
+}
+
+func BenchmarkBitSetMemPopcount1G(b *testing.B) {
+	bitset := newBitSetMem(1 << 30)
+	for i := uint(0); i < 1<<30; i += 7 {
+		bitset.insert(i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bitset.bitCount()
+	}
+}
+
+func BenchmarkBitSetMemOrWords1G(b *testing.B) {
+	aSet := newBitSetMem(1 << 30)
+	bSet := newBitSetMem(1 << 30)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		aSet.union(bSet)
+	}
+}
+
+// testBitSet checks the BitSet API on the bitsets created by _newBitSet_
+func testBitSet(t *testing.T, newBitSet func(size uint) BitSet) {
+	bitSet := newBitSet(100)
+	if bitSet.Size() != 100 {
+		t.Fatalf("size should be 100, got %d", bitSet.Size())
+	}
+	bitSet.Set(3)
+	bitSet.Set(64)
+	bitSet.Flip(99)
+	bitSet.Flip(64)
+	if ok, _ := bitSet.Test(3); !ok {
+		t.Errorf("bit 3 should be set")
+	}
+	if ok, _ := bitSet.Test(64); ok {
+		t.Errorf("bit 64 should be flipped back")
+	}
+	bitSet.Clear(3)
+	if count, _ := bitSet.Count(); count != 1 {
+		t.Errorf("only bit 99 should be set, got %d bits", count)
+	}
+	if err := bitSet.Set(100); err == nil {
+		t.Errorf("setting a bit out of range should error out")
+	}
+
+	bitSet.SetRange(5, 75)
+	bitSet.ClearRange(10, 20)
+	bitSet.FlipRange(70, 80)
+	var indexes []uint
+	bitSet.ForEach(func(index uint) bool {
+		indexes = append(indexes, index)
+		return true
+	})
+	expected := []uint{}
+	for i := uint(5); i < 80; i++ {
+		if (i < 10 || i >= 20) && i < 70 || i >= 75 {
+			expected = append(expected, i)
+		}
+	}
+	expected = append(expected, 99)
+	if fmt.Sprint(indexes) != fmt.Sprint(expected) {
+		t.Errorf("bits set should be %v, got %v", expected, indexes)
+	}
+	if next, ok, _ := bitSet.NextSet(10); !ok || next != 20 {
+		t.Errorf("next bit set from 10 should be 20, got %d", next)
+	}
+	if _, ok, _ := bitSet.NextSet(100); ok {
+		t.Errorf("no bit should be set past the size")
+	}
+	if err := bitSet.SetRange(90, 101); err == nil {
+		t.Errorf("setting a range out of the bitset should error out")
+	}
+
+	bitSet.Not()
+	if count, _ := bitSet.Count(); count != 100-uint(len(expected)) {
+		t.Errorf("%d bits should be set after not, got %d", 100-len(expected), count)
+	}
+	bitSet.Not()
+
+	other := newBitSet(100)
+	other.SetRange(0, 50)
+	and := newBitSet(100)
+	and.Or(bitSet)
+	and.And(other)
+	if count, _ := and.Count(); count != 35 {
+		t.Errorf("35 bits should be set in both bitsets, got %d", count)
+	}
+	other.Xor(bitSet)
+	if ok, _ := other.Test(5); ok {
+		t.Errorf("bit 5 set in both bitsets should be unset by xor")
+	}
+	if ok, _ := other.Test(0); !ok {
+		t.Errorf("bit 0 set in one bitset should be set by xor")
+	}
+	if err := other.Or(newBitSet(10)); err == nil {
+		t.Errorf("combining bitsets of different sizes should error out")
+	}
+
+	bitSet.Shrink(72)
+	if count, _ := bitSet.Count(); bitSet.Size() != 72 || count != 55 {
+		t.Errorf("shrunk bitset should have 72 bits with 55 set, got %d with %d set", bitSet.Size(), count)
+	}
+	bitSet.Grow(200)
+	if ok, _ := bitSet.Test(99); ok || bitSet.Size() != 200 {
+		t.Errorf("bits past a shrink should be unset after growing the bitset")
+	}
+	bitSet.Set(199)
+	if next, ok, _ := bitSet.NextSet(72); !ok || next != 199 {
+		t.Errorf("next bit set from 72 should be 199, got %d", next)
+	}
+	if err := bitSet.Shrink(300); err == nil {
+		t.Errorf("shrinking to a larger size should error out")
+	}
+}
+
+func TestBitSetMemAPI(t *testing.T) {
+	testBitSet(t, func(size uint) BitSet { return NewBitSetMem(size) })
+	if err := NewBitSetMem(10).Or(&BitSetRedis{10, "bits"}); err == nil {
+		t.Errorf("combining in-memory and redis bitsets should error out")
+	}
+}
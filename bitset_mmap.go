@@ -0,0 +1,169 @@
+package gostatix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+
+	"github.com/bits-and-blooms/bitset"
+)
+
+// mmapMagic identifies the files of BitSetMmap
+const mmapMagic = "GSXBITS1"
+
+// mmapHeaderSize is the size of the header of the files of BitSetMmap: the magic and
+// the size of the bitset in bits
+const mmapHeaderSize = 16
+
+// BitSetMmap is an implementation of IBitSet backed by a memory mapped file, so that the
+// bitset survives restarts without Redis and is loaded without reading it. It's a
+// BitSetMem whose words are the file past its header, in the native byte order of the
+// machine. The writes reach the file when the pages are flushed by the OS or on Close.
+// _path_ is the path of the file
+// _file_ and _data_ are the open file and its mapping
+type BitSetMmap struct {
+	*BitSetMem
+	path string
+	file *os.File
+	data []byte
+}
+
+// newBitSetMmap maps the file at _path_ holding a bitset of _size_ bits. The file is
+// created if it doesn't exist, otherwise it must hold a bitset of the same size.
+func newBitSetMmap(path string, size uint) (*BitSetMmap, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while opening bitset file %s, error: %v", path, err)
+	}
+	bitSet, err := mapBitSetFile(file, path, size)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return bitSet, nil
+}
+
+func mapBitSetFile(file *os.File, path string, size uint) (*BitSetMmap, error) {
+	numWords := wordsFor(uint64(size))
+	length := int64(mmapHeaderSize + numWords*uint64(wordBytes))
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while reading bitset file %s, error: %v", path, err)
+	}
+	if info.Size() == 0 {
+		header := make([]byte, mmapHeaderSize)
+		copy(header, mmapMagic)
+		binary.LittleEndian.PutUint64(header[len(mmapMagic):], uint64(size))
+		_, err = file.WriteAt(header, 0)
+		if err == nil {
+			err = file.Truncate(length)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("gostatix: error while creating bitset file %s, error: %v", path, err)
+		}
+	} else {
+		header := make([]byte, mmapHeaderSize)
+		_, err = file.ReadAt(header, 0)
+		if err != nil || !bytes.Equal(header[:len(mmapMagic)], []byte(mmapMagic)) {
+			return nil, fmt.Errorf("gostatix: file %s isn't a bitset file", path)
+		}
+		fileSize := binary.LittleEndian.Uint64(header[len(mmapMagic):])
+		if fileSize != uint64(size) {
+			return nil, fmt.Errorf("gostatix: file %s holds a bitset of %d bits, not %d", path, fileSize, size)
+		}
+		if info.Size() != length {
+			return nil, fmt.Errorf("gostatix: bitset file %s is %d bytes long instead of %d", path, info.Size(), length)
+		}
+	}
+	data, err := mmapFile(file, int(length))
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while mapping bitset file %s, error: %v", path, err)
+	}
+	words := unsafe.Slice((*uint64)(unsafe.Pointer(&data[mmapHeaderSize])), numWords)
+	bitSet := &BitSetMmap{&BitSetMem{bitset.FromWithLength(size, words), size}, path, file, data}
+	return bitSet, nil
+}
+
+// Path returns the path of the file backing the bitset
+func (bitSet *BitSetMmap) Path() string {
+	return bitSet.path
+}
+
+// copyFrom copies the bits of _other_, which should have the same size, in the file
+func (bitSet *BitSetMmap) copyFrom(other *BitSetMem) error {
+	if other.size != bitSet.size {
+		return fmt.Errorf("gostatix: can't load a bitset of %d bits in a bitset file of %d bits", other.size, bitSet.size)
+	}
+	copy(bitSet.set.Bytes(), other.set.Bytes())
+	return nil
+}
+
+// unmarshal imports the marshalled json in _data_ into the file, keeping its size
+func (bitSet *BitSetMmap) unmarshal(data []byte) (bool, error) {
+	other := &BitSetMem{}
+	_, err := other.unmarshal(data)
+	if err != nil {
+		return false, err
+	}
+	return true, bitSet.copyFrom(other)
+}
+
+// readFrom reads the bitset from _stream_ into the file, keeping its size
+func (bitSet *BitSetMmap) readFrom(stream io.Reader) (int64, error) {
+	other := &BitSetMem{}
+	numBytes, err := other.readFrom(stream)
+	if err != nil {
+		return 0, err
+	}
+	return numBytes, bitSet.copyFrom(other)
+}
+
+// Grow errors out as the size of a bitset file is fixed
+func (bitSet *BitSetMmap) Grow(size uint) error {
+	return fmt.Errorf("gostatix: bitset file %s of %d bits can't be resized", bitSet.path, bitSet.size)
+}
+
+// Shrink errors out as the size of a bitset file is fixed
+func (bitSet *BitSetMmap) Shrink(size uint) error {
+	return fmt.Errorf("gostatix: bitset file %s of %d bits can't be resized", bitSet.path, bitSet.size)
+}
+
+// memoryUsage returns the number of bytes of the mapping
+func (bitSet *BitSetMmap) memoryUsage() (uint64, error) {
+	return uint64(len(bitSet.data)), nil
+}
+
+// Flush writes the modified pages of the mapping to the file
+func (bitSet *BitSetMmap) Flush() error {
+	if bitSet.data == nil {
+		return nil
+	}
+	err := msyncFile(bitSet.data)
+	if err != nil {
+		return fmt.Errorf("gostatix: error while flushing bitset file %s, error: %v", bitSet.path, err)
+	}
+	return nil
+}
+
+// Close flushes the mapping, unmaps it and closes the file. The bitset is empty
+// afterwards. It's safe to call Close multiple times.
+func (bitSet *BitSetMmap) Close() error {
+	if bitSet.data == nil {
+		return nil
+	}
+	err := bitSet.Flush()
+	if err != nil {
+		return err
+	}
+	bitSet.BitSetMem = newBitSetMem(0)
+	err = munmapFile(bitSet.data)
+	bitSet.data = nil
+	if err != nil {
+		bitSet.file.Close()
+		return fmt.Errorf("gostatix: error while unmapping bitset file %s, error: %v", bitSet.path, err)
+	}
+	return bitSet.file.Close()
+}
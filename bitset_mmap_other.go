@@ -0,0 +1,20 @@
+//go:build !(linux || darwin || freebsd)
+
+package gostatix
+
+import (
+	"fmt"
+	"os"
+)
+
+func mmapFile(file *os.File, length int) ([]byte, error) {
+	return nil, fmt.Errorf("memory mapped bitsets aren't supported on this platform")
+}
+
+func msyncFile(data []byte) error {
+	return nil
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
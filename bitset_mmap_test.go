@@ -0,0 +1,149 @@
+//go:build linux || darwin || freebsd
+
+package gostatix
+
+import (
+	"bytes"
+	"math/bits"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestBitSetMmapReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bits")
+	bitSet, err := newBitSetMmap(path, 1000)
+	if err != nil {
+		t.Fatalf("creating the bitset file shouldn't error out, error: %v", err)
+	}
+	bitSet.insert(3)
+	bitSet.insert(999)
+	err = bitSet.Close()
+	if err != nil {
+		t.Fatalf("closing the bitset file shouldn't error out, error: %v", err)
+	}
+	err = bitSet.Close()
+	if err != nil {
+		t.Errorf("closing the bitset file twice shouldn't error out, error: %v", err)
+	}
+
+	bitSet, err = newBitSetMmap(path, 1000)
+	if err != nil {
+		t.Fatalf("reopening the bitset file shouldn't error out, error: %v", err)
+	}
+	defer bitSet.Close()
+	for _, index := range []uint{3, 999} {
+		if ok, _ := bitSet.has(index); !ok {
+			t.Errorf("bit %d should be set after reopening the file", index)
+		}
+	}
+	if ok, _ := bitSet.has(4); ok {
+		t.Errorf("bit 4 shouldn't be set after reopening the file")
+	}
+	_, err = newBitSetMmap(path, 2000)
+	if err == nil {
+		t.Errorf("reopening the bitset file with another size should error out")
+	}
+}
+
+func TestMmapBloomFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bloom")
+	filter, err := NewMmapBloomFilter(path, 1000, 0.01)
+	if err != nil {
+		t.Fatalf("creating the filter shouldn't error out, error: %v", err)
+	}
+	filter.InsertString("john").InsertString("jane")
+	memFilter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	memFilter.InsertString("alice")
+	err = filter.Merge(memFilter)
+	if err != nil {
+		t.Fatalf("merging an in-memory filter shouldn't error out, error: %v", err)
+	}
+	var buf bytes.Buffer
+	_, err = filter.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("writing the filter shouldn't error out, error: %v", err)
+	}
+	err = filter.Close()
+	if err != nil {
+		t.Fatalf("closing the filter shouldn't error out, error: %v", err)
+	}
+
+	filter, err = NewMmapBloomFilter(path, 1000, 0.01)
+	if err != nil {
+		t.Fatalf("reopening the filter shouldn't error out, error: %v", err)
+	}
+	defer filter.Close()
+	for _, name := range []string{"john", "jane", "alice"} {
+		if !filter.LookupString(name) {
+			t.Errorf("%s should be in the filter after reopening it", name)
+		}
+	}
+	if filter.LookupString("bob") {
+		t.Errorf("bob shouldn't be in the filter")
+	}
+
+	err = filter.Reset()
+	if err != nil {
+		t.Fatalf("resetting the filter shouldn't error out, error: %v", err)
+	}
+	_, err = filter.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("reading the filter shouldn't error out, error: %v", err)
+	}
+	if !filter.LookupString("alice") {
+		t.Errorf("alice should be in the filter read back from the stream")
+	}
+	if _, ok := filter.filter.(*BitSetMmap); !ok {
+		t.Errorf("reading the filter should keep the memory mapped bitset, got %T", filter.filter)
+	}
+}
+
+func TestMmapBloomFilterLarge(t *testing.T) {
+	if bits.UintSize < 64 {
+		t.Skip("bloom filters of more than 2^32 bits need 64-bit sizes")
+	}
+	// the file is sparse, only the pages of the bits set are written
+	large := uint64(1<<33 + 7)
+	size := uint(large)
+	bitSet, err := newBitSetMmap(filepath.Join(t.TempDir(), "large"), size)
+	if err != nil {
+		t.Fatalf("creating the bitset file shouldn't error out, error: %v", err)
+	}
+	defer bitSet.Close()
+	filter, err := NewBloomFilterWithBitSet(size, 7, bitSet, "")
+	if err != nil {
+		t.Fatalf("creating the filter shouldn't error out, error: %v", err)
+	}
+	above := 0
+	for i := 0; i < 1000; i++ {
+		data := []byte(strconv.Itoa(i))
+		filter.Insert(data)
+		hashes := filter.getHashes(data)
+		for j := uint(0); j < filter.numHashes; j++ {
+			index := filter.getIndex(hashes, j)
+			if index >= size {
+				t.Fatalf("index %d should be lower than the size", index)
+			}
+			if uint64(index) >= 1<<32 {
+				above++
+				if ok, _ := bitSet.has(index); !ok {
+					t.Errorf("bit %d above 2^32 should be set", index)
+				}
+			}
+		}
+	}
+	if above < 3000 {
+		t.Errorf("half of the indexes should be above 2^32, got %d of 7000", above)
+	}
+	for i := 0; i < 1000; i++ {
+		if !filter.Lookup([]byte(strconv.Itoa(i))) {
+			t.Errorf("%d should be in the filter", i)
+		}
+	}
+	for i := 1000; i < 2000; i++ {
+		if filter.Lookup([]byte(strconv.Itoa(i))) {
+			t.Errorf("%d shouldn't be in the filter", i)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd
+
+package gostatix
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func mmapFile(file *os.File, length int) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, length, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
+
+func msyncFile(data []byte) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
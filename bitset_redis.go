@@ -10,6 +10,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math/bits"
 
 	"encoding/base64"
 	"encoding/binary"
@@ -19,68 +20,395 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// redisChunkSize is the number of bytes of a bitset transferred in a single
+// GETRANGE/SETRANGE call while streaming it. It's a multiple of _wordBytes_.
+const redisChunkSize = 1 << 20
+
 // BitSetRedis is an implementation of IBitSet.
 // size is the number of bits in the bitset
 // key is the redis key to the bitset data structure in redis
 // Bitsets or Bitmaps are implemented in Redis using string.
-// All bit operations are done on the string stored at _key_.
+// All bit operations are done on the string stored at _key_, which holds the words of the
+// bitset, so a BitSetRedis is limited to the 2^32 bits (512MB) of a Redis string. Larger
+// bitsets are split across several keys by ShardedBitSetRedis.
 // For more details, please refer https://redis.io/docs/data-types/bitmaps/
 type BitSetRedis struct {
 	size uint
 	key  string
 }
 
+// NewBitSetRedis creates a new BitSet of _size_ bits, all unset, in a Redis string at a
+// random key which can be retrieved using Key()
+func NewBitSetRedis(size uint) (*BitSetRedis, error) {
+	key, err := newRedisKey(context.Background(), "")
+	if err != nil {
+		return nil, err
+	}
+	return newBitSetRedisWithKey(context.Background(), size, key)
+}
+
+// NewBitSetRedisFromKey opens the BitSet of _size_ bits saved in the Redis string at _key_
+func NewBitSetRedisFromKey(key string, size uint) (*BitSetRedis, error) {
+	_, err := redisBitsBytes(uint64(size))
+	if err != nil {
+		return nil, err
+	}
+	exists, err := getRedisClient().Exists(context.Background(), key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("gostatix: no bitset found at redis key %s", key)
+	}
+	return &BitSetRedis{size, key}, nil
+}
+
 // NewBitSetRedis creates a new BitSetRedis of size _size_
 func newBitSetRedis(size uint) *BitSetRedis {
-	bytes := make([]byte, size)
-	for i := range bytes {
-		bytes[i] = 0x00
+	bitSet, _ := newBitSetRedisWithKey(context.Background(), size, util.GenerateRandomString(16))
+	return bitSet
+}
+
+// newBitSetRedisWithKey creates a new BitSetRedis of size _size_ at the redis key _key_
+func newBitSetRedisWithKey(ctx context.Context, size uint, key string) (*BitSetRedis, error) {
+	numBytes, err := redisBitsBytes(uint64(size))
+	if err != nil {
+		return nil, err
+	}
+	err = allocateRedisBits(ctx, key, numBytes)
+	return &BitSetRedis{size, key}, err
+}
+
+// redisBitsBytes returns the number of bytes of the Redis string holding the words of a
+// bitset of _size_ bits. It errors out if the bitset doesn't fit in a Redis string.
+func redisBitsBytes(size uint64) (uint64, error) {
+	if size > maxShardSize {
+		return 0, fmt.Errorf("gostatix: bitset of %d bits is larger than the maximum of %d bits of a redis string, it should be sharded", size, maxShardSize)
 	}
-	key := util.GenerateRandomString(16)
-	_ = getRedisClient().Set(context.Background(), key, string(bytes), 0).Err()
-	return &BitSetRedis{size, key}
+	return wordsFor(size) * uint64(wordBytes), nil
 }
 
 // FromDataRedis creates an instance of BitSetRedis after
 // inserting the data passed in a redis bitset
 func fromDataRedis(data []uint64) (*BitSetRedis, error) {
-	bitSetRedis := newBitSetRedis(uint(len(data) * wordSize))
-	bytes, err := uint64ArrayToByteArray(data)
+	key, err := newRedisKey(context.Background(), "")
 	if err != nil {
 		return nil, err
 	}
-	err = getRedisClient().Set(context.Background(), bitSetRedis.key, string(bytes), 0).Err()
+	bitSetRedis, err := newBitSetRedisWithKey(context.Background(), uint(len(data)*wordSize), key)
 	if err != nil {
 		return nil, err
 	}
-	return bitSetRedis, nil
-}
-
-// FromRedisKey creates an instance of BitSetRedis from the
-// bitset data structure saved at redis key _key_
-func fromRedisKey(key string) (*BitSetRedis, error) {
-	setVal, err := getRedisClient().Get(context.Background(), key).Result()
+	bytes, err := uint64ArrayToByteArray(data)
+	if err != nil {
+		return nil, err
+	}
+	err = getRedisClient().Set(context.Background(), bitSetRedis.key, string(bytes), 0).Err()
 	if err != nil {
 		return nil, err
 	}
-	setValBytes := []byte(setVal)
-	bitSetRedis := newBitSetRedis(uint(len(setValBytes) * wordBytes))
-	bitSetRedis.key = key
 	return bitSetRedis, nil
 }
 
 // Size returns the size of the bitset saved in redis
-func (bitSet BitSetRedis) getSize() uint {
+func (bitSet *BitSetRedis) getSize() uint {
 	return bitSet.size
 }
 
 // Key gives the key at which the bitset is saved in redis
-func (bitSet BitSetRedis) getKey() string {
+func (bitSet *BitSetRedis) getKey() string {
 	return bitSet.key
 }
 
+// Size returns the number of bits of the bitset
+func (bitSet *BitSetRedis) Size() uint {
+	return bitSet.size
+}
+
+// Key returns the Redis key of the string holding the bitset
+func (bitSet *BitSetRedis) Key() string {
+	return bitSet.key
+}
+
+// Test returns true if the bit at _index_ is set
+func (bitSet *BitSetRedis) Test(index uint) (bool, error) {
+	if err := checkBitIndex(index, bitSet.size); err != nil {
+		return false, err
+	}
+	return bitSet.has(index)
+}
+
+// Set sets the bit at _index_
+func (bitSet *BitSetRedis) Set(index uint) error {
+	if err := checkBitIndex(index, bitSet.size); err != nil {
+		return err
+	}
+	return getRedisClient().SetBit(context.Background(), bitSet.key, int64(index), 1).Err()
+}
+
+// Clear unsets the bit at _index_
+func (bitSet *BitSetRedis) Clear(index uint) error {
+	if err := checkBitIndex(index, bitSet.size); err != nil {
+		return err
+	}
+	return getRedisClient().SetBit(context.Background(), bitSet.key, int64(index), 0).Err()
+}
+
+// Flip flips the bit at _index_ atomically
+func (bitSet *BitSetRedis) Flip(index uint) error {
+	if err := checkBitIndex(index, bitSet.size); err != nil {
+		return err
+	}
+	return bitSet.FlipRange(index, index+1)
+}
+
+// the operations applied to the bits of a range by bitRangeScript
+const (
+	bitRangeClear = iota
+	bitRangeSet
+	bitRangeFlip
+)
+
+// bitRangeScript applies the operation ARGV[3] to the bits [ARGV[1], ARGV[2]) of the
+// string at KEYS[1]. The bits of the whole bytes of the range are written at once.
+var bitRangeScript = redis.NewScript(`
+	local key = KEYS[1]
+	local first, last, op = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+	local function apply(i)
+		local bit = op
+		if op == 2 then
+			bit = 1 - redis.call('GETBIT', key, i)
+		end
+		redis.call('SETBIT', key, i, bit)
+	end
+	local fromByte = math.floor((first + 7) / 8)
+	local toByte = math.floor(last / 8)
+	if fromByte >= toByte then
+		for i = first, last - 1 do
+			apply(i)
+		end
+		return true
+	end
+	for i = first, fromByte * 8 - 1 do
+		apply(i)
+	end
+	for i = toByte * 8, last - 1 do
+		apply(i)
+	end
+	local numBytes = toByte - fromByte
+	local bytes
+	if op == 2 then
+		local current = redis.call('GETRANGE', key, fromByte, toByte - 1)
+		local flipped = {}
+		for i = 1, numBytes do
+			flipped[i] = string.char(255 - (string.byte(current, i) or 0))
+		end
+		bytes = table.concat(flipped)
+	elseif op == 1 then
+		bytes = string.rep('\255', numBytes)
+	else
+		bytes = string.rep('\0', numBytes)
+	end
+	redis.call('SETRANGE', key, fromByte, bytes)
+	return true
+`)
+
+// SetRange sets the bits in [_start_, _end_). Each chunk of _redisChunkSize_ bytes of
+// the range is set atomically.
+func (bitSet *BitSetRedis) SetRange(start, end uint) error {
+	return bitSet.applyRange(start, end, bitRangeSet)
+}
+
+// ClearRange unsets the bits in [_start_, _end_). Each chunk of _redisChunkSize_ bytes
+// of the range is unset atomically.
+func (bitSet *BitSetRedis) ClearRange(start, end uint) error {
+	return bitSet.applyRange(start, end, bitRangeClear)
+}
+
+// FlipRange flips the bits in [_start_, _end_). Each chunk of _redisChunkSize_ bytes of
+// the range is flipped atomically.
+func (bitSet *BitSetRedis) FlipRange(start, end uint) error {
+	return bitSet.applyRange(start, end, bitRangeFlip)
+}
+
+// applyRange runs bitRangeScript with _op_ on the bits in [_start_, _end_), in chunks of
+// _redisChunkSize_ bytes
+func (bitSet *BitSetRedis) applyRange(start, end uint, op int) error {
+	if err := checkBitRange(start, end, bitSet.size); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	for from := start; from < end; {
+		to := (from/8 + redisChunkSize) * 8
+		if to > end {
+			to = end
+		}
+		err := bitRangeScript.Run(ctx, getRedisClient(), []string{bitSet.key}, from, to, op).Err()
+		if err != nil {
+			return err
+		}
+		from = to
+	}
+	return nil
+}
+
+// Count returns the number of bits set
+func (bitSet *BitSetRedis) Count() (uint, error) {
+	return bitSet.bitCount()
+}
+
+// And unsets the bits which aren't set in _other_, a BitSetRedis of the same size, using
+// BITOP AND in redis
+func (bitSet *BitSetRedis) And(other BitSet) error {
+	otherBitSet, err := bitSet.sameSize(other)
+	if err != nil {
+		return err
+	}
+	return getRedisClient().BitOpAnd(context.Background(), bitSet.key, bitSet.key, otherBitSet.key).Err()
+}
+
+// Or sets the bits set in _other_, a BitSetRedis of the same size, using BITOP OR in
+// redis
+func (bitSet *BitSetRedis) Or(other BitSet) error {
+	otherBitSet, err := bitSet.sameSize(other)
+	if err != nil {
+		return err
+	}
+	return bitSet.union(otherBitSet)
+}
+
+// Xor flips the bits set in _other_, a BitSetRedis of the same size, using BITOP XOR in
+// redis
+func (bitSet *BitSetRedis) Xor(other BitSet) error {
+	otherBitSet, err := bitSet.sameSize(other)
+	if err != nil {
+		return err
+	}
+	return getRedisClient().BitOpXor(context.Background(), bitSet.key, bitSet.key, otherBitSet.key).Err()
+}
+
+// sameSize returns _other_ if it's a BitSetRedis of the same size
+func (bitSet *BitSetRedis) sameSize(other BitSet) (*BitSetRedis, error) {
+	otherBitSet, ok := other.(*BitSetRedis)
+	if !ok {
+		return nil, fmt.Errorf("gostatix: invalid bitset type, should be BitSetRedis, type: %T", other)
+	}
+	if otherBitSet.size != bitSet.size {
+		return nil, fmt.Errorf("gostatix: can't combine bitsets of different sizes %d and %d", bitSet.size, otherBitSet.size)
+	}
+	return otherBitSet, nil
+}
+
+// Not flips all the bits. Unlike BITOP NOT, it leaves the bits past the size unset.
+func (bitSet *BitSetRedis) Not() error {
+	return bitSet.FlipRange(0, bitSet.size)
+}
+
+// Grow extends the bitset to _size_ bits, the new bits are unset. The Redis string is
+// zero padded to the words of the bitset if it's shorter.
+func (bitSet *BitSetRedis) Grow(size uint) error {
+	if size < bitSet.size {
+		return fmt.Errorf("gostatix: can't grow bitset of size %d to %d bits", bitSet.size, size)
+	}
+	numBytes, err := redisBitsBytes(uint64(size))
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	length, err := getRedisClient().StrLen(ctx, bitSet.key).Result()
+	if err != nil {
+		return err
+	}
+	if uint64(length) < numBytes {
+		err = getRedisClient().SetRange(ctx, bitSet.key, int64(numBytes-1), "\x00").Err()
+		if err != nil {
+			return err
+		}
+	}
+	bitSet.size = size
+	return nil
+}
+
+// bitTruncateScript truncates the string at KEYS[1] to its first ARGV[1] bytes, keeping
+// its ttl
+var bitTruncateScript = redis.NewScript(`
+	local key = KEYS[1]
+	local numBytes = tonumber(ARGV[1])
+	local bytes = ''
+	if numBytes > 0 then
+		bytes = redis.call('GETRANGE', key, 0, numBytes - 1)
+	end
+	redis.call('SET', key, bytes, 'KEEPTTL')
+	return true
+`)
+
+// Shrink truncates the bitset to its first _size_ bits. The Redis string is truncated to
+// the words of the bitset.
+func (bitSet *BitSetRedis) Shrink(size uint) error {
+	if size > bitSet.size {
+		return fmt.Errorf("gostatix: can't shrink bitset of size %d to %d bits", bitSet.size, size)
+	}
+	err := bitSet.ClearRange(size, bitSet.size)
+	if err != nil {
+		return err
+	}
+	numBytes, _ := redisBitsBytes(uint64(size))
+	err = bitTruncateScript.Run(context.Background(), getRedisClient(), []string{bitSet.key}, numBytes).Err()
+	if err != nil {
+		return err
+	}
+	bitSet.size = size
+	return nil
+}
+
+// NextSet returns the index of the first bit set from _index_ included, false if none.
+// The string is read in chunks of _redisChunkSize_ bytes using GETRANGE.
+func (bitSet *BitSetRedis) NextSet(index uint) (uint, bool, error) {
+	next, found := uint(0), false
+	err := bitSet.scan(index, func(index uint) bool {
+		next, found = index, true
+		return false
+	})
+	return next, found, err
+}
+
+// ForEach calls _fn_ with the index of each bit set in increasing order, until _fn_
+// returns false. The string is read in chunks of _redisChunkSize_ bytes using GETRANGE.
+func (bitSet *BitSetRedis) ForEach(fn func(index uint) bool) error {
+	return bitSet.scan(0, fn)
+}
+
+// scan calls _fn_ with the index of each bit set from _index_ included, until _fn_ returns
+// false. The bit at offset _i_ of a byte of a Redis string is its i-th most significant bit.
+func (bitSet *BitSetRedis) scan(index uint, fn func(index uint) bool) error {
+	ctx := context.Background()
+	for start := index / 8 * 8; start < bitSet.size; start += redisChunkSize * 8 {
+		chunk, err := getRedisClient().GetRange(ctx, bitSet.key, int64(start/8), int64(start/8+redisChunkSize-1)).Bytes()
+		if err != nil {
+			return err
+		}
+		for i, b := range chunk {
+			for b != 0 {
+				offset := uint(bits.LeadingZeros8(b))
+				b &^= 0x80 >> offset
+				next := start + uint(i)*8 + offset
+				if next >= bitSet.size {
+					return nil
+				}
+				if next >= index && !fn(next) {
+					return nil
+				}
+			}
+		}
+		if len(chunk) < redisChunkSize {
+			return nil
+		}
+	}
+	return nil
+}
+
 // Has checks if the bit at index _index_ is set
-func (bitSet BitSetRedis) has(index uint) (bool, error) {
+func (bitSet *BitSetRedis) has(index uint) (bool, error) {
 	val, err := getRedisClient().GetBit(context.Background(), bitSet.key, int64(index)).Result()
 	if err != nil {
 		return false, err
@@ -90,7 +418,7 @@ func (bitSet BitSetRedis) has(index uint) (bool, error) {
 
 // HasMulti checks if the bit at the indices
 // specified by _indexes_ array is set
-func (bitSet BitSetRedis) hasMulti(indexes []uint) ([]bool, error) {
+func (bitSet *BitSetRedis) hasMulti(indexes []uint) ([]bool, error) {
 	if len(indexes) == 0 {
 		return nil, fmt.Errorf("gostatix: at least 1 index is required")
 	}
@@ -112,7 +440,7 @@ func (bitSet BitSetRedis) hasMulti(indexes []uint) ([]bool, error) {
 }
 
 // Insert sets the bit at index specified by _index_
-func (bitSet BitSetRedis) insert(index uint) (bool, error) {
+func (bitSet *BitSetRedis) insert(index uint) (bool, error) {
 	err := getRedisClient().SetBit(context.Background(), bitSet.key, int64(index), 1).Err()
 	if err != nil {
 		return false, err
@@ -121,7 +449,7 @@ func (bitSet BitSetRedis) insert(index uint) (bool, error) {
 }
 
 // Insert sets the bits at indices specified by array _indexes_
-func (bitSet BitSetRedis) insertMulti(indexes []uint) (bool, error) {
+func (bitSet *BitSetRedis) insertMulti(indexes []uint) (bool, error) {
 	if len(indexes) == 0 {
 		return false, fmt.Errorf("gostatix: at least 1 index is required")
 	}
@@ -137,8 +465,38 @@ func (bitSet BitSetRedis) insertMulti(indexes []uint) (bool, error) {
 	return true, nil
 }
 
+// InsertMultiIfMissing sets the bits at indices specified by array _indexes_ in a
+// transaction and returns true if any of them wasn't set
+func (bitSet *BitSetRedis) insertMultiIfMissing(indexes []uint) (bool, error) {
+	if len(indexes) == 0 {
+		return false, fmt.Errorf("gostatix: at least 1 index is required")
+	}
+	ctx := context.Background()
+	previous := make([]*redis.IntCmd, len(indexes))
+	_, err := getRedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range indexes {
+			previous[i] = pipe.SetBit(ctx, bitSet.key, int64(indexes[i]), 1)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return anyUnset(previous), nil
+}
+
+// anyUnset returns true if any of the SETBIT commands _previous_ returned an unset bit
+func anyUnset(previous []*redis.IntCmd) bool {
+	for i := range previous {
+		if previous[i].Val() == 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // Equals checks if two BitSetRedis are equal or not
-func (aSet BitSetRedis) equals(otherBitSet IBitSet) (bool, error) {
+func (aSet *BitSetRedis) equals(otherBitSet IBitSet) (bool, error) {
 	bSet, ok := otherBitSet.(*BitSetRedis)
 	if !ok {
 		return false, fmt.Errorf("invalid bitset type, should be BitSetRedis")
@@ -155,7 +513,7 @@ func (aSet BitSetRedis) equals(otherBitSet IBitSet) (bool, error) {
 }
 
 // Max returns the first set bit in the bitset starting from index 0
-func (bitSet BitSetRedis) max() (uint, bool) {
+func (bitSet *BitSetRedis) max() (uint, bool) {
 	index, err := getRedisClient().BitPos(context.Background(), bitSet.key, 1).Result()
 	if err != nil || index == -1 {
 		return 0, false
@@ -164,7 +522,7 @@ func (bitSet BitSetRedis) max() (uint, bool) {
 }
 
 // BitCount returns the total number of set bits in the bitset saved in redis
-func (bitSet BitSetRedis) bitCount() (uint, error) {
+func (bitSet *BitSetRedis) bitCount() (uint, error) {
 	bitRange := &redis.BitCount{Start: 0, End: -1}
 	val, err := getRedisClient().BitCount(context.Background(), bitSet.key, bitRange).Result()
 	if err != nil {
@@ -173,21 +531,49 @@ func (bitSet BitSetRedis) bitCount() (uint, error) {
 	return uint(val), nil
 }
 
+// Union sets the bits set in _otherBitSet_, which should be a BitSetRedis of the same
+// size, using BITOP OR in redis
+func (bitSet *BitSetRedis) union(otherBitSet IBitSet) error {
+	secondBitSet, ok := otherBitSet.(*BitSetRedis)
+	if !ok {
+		return fmt.Errorf("gostatix: invalid bitset type, should be BitSetRedis, type: %T", otherBitSet)
+	}
+	if bitSet.size != secondBitSet.size {
+		return fmt.Errorf("gostatix: can't merge bitsets of different sizes %d and %d", bitSet.size, secondBitSet.size)
+	}
+	return getRedisClient().BitOpOr(context.Background(), bitSet.key, bitSet.key, secondBitSet.key).Err()
+}
+
+// Clear unsets all the bits of the bitset by overwriting the string at _key_ with zeroed
+// bytes of the same length
+func (bitSet *BitSetRedis) clear() error {
+	ctx := context.Background()
+	length, err := getRedisClient().StrLen(ctx, bitSet.key).Result()
+	if err != nil {
+		return err
+	}
+	_, err = getRedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, bitSet.key, "", 0)
+		if length > 0 {
+			pipe.SetRange(ctx, bitSet.key, length-1, "\x00")
+		}
+		return nil
+	})
+	return err
+}
+
+// memoryUsage returns the number of bytes used by the string at _key_ in Redis
+func (bitSet *BitSetRedis) memoryUsage() (uint64, error) {
+	return redisMemoryUsage([]string{bitSet.key})
+}
+
 // Export returns the json marshalling of the bitset saved in redis
-func (bitSet BitSetRedis) marshal() (uint, []byte, error) {
+func (bitSet *BitSetRedis) marshal() (uint, []byte, error) {
 	val, err := getRedisClient().Get(context.Background(), bitSet.key).Result()
 	if err != nil {
 		return 0, nil, err
 	}
-	bytes := []byte(val)
-	for i := range bytes {
-		bytes[i] = util.ConvertByteToLittleEndianByte(bytes[i])
-	}
-	util.ReverseBytes(bytes)
-	buf := make([]byte, wordBytes)
-	binary.BigEndian.PutUint64(buf, uint64(bitSet.size))
-	bytes = append(buf, bytes...)
-	data, err := json.Marshal(base64.URLEncoding.EncodeToString([]byte(bytes)))
+	data, err := marshalRedisBits(bitSet.size, []byte(val))
 	if err != nil {
 		return 0, nil, err
 	}
@@ -196,25 +582,61 @@ func (bitSet BitSetRedis) marshal() (uint, []byte, error) {
 
 // Import imports the marshalled json in the byte array data into the redis bitset
 func (bitSet *BitSetRedis) unmarshal(data []byte) (bool, error) {
+	size, bytes, err := unmarshalRedisBits(data)
+	if err != nil {
+		return false, err
+	}
+	_, err = redisBitsBytes(size)
+	if err != nil {
+		return false, err
+	}
+	bitSet.size = uint(size)
+	err = getRedisClient().Set(context.Background(), bitSet.key, string(bytes), 0).Err()
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// marshalRedisBits returns the json marshalling of the _bytes_ of a Redis string holding
+// a bitset of _size_ bits. _bytes_ is modified in place.
+func marshalRedisBits(size uint, bytes []byte) ([]byte, error) {
+	for i := range bytes {
+		bytes[i] = util.ConvertByteToLittleEndianByte(bytes[i])
+	}
+	util.ReverseBytes(bytes)
+	buf := make([]byte, wordBytes)
+	binary.BigEndian.PutUint64(buf, uint64(size))
+	bytes = append(buf, bytes...)
+	return json.Marshal(base64.URLEncoding.EncodeToString([]byte(bytes)))
+}
+
+// unmarshalRedisBits decodes the json marshalling of a bitset saved in Redis and returns
+// its size and the bytes of the Redis string
+func unmarshalRedisBits(data []byte) (uint64, []byte, error) {
 	var s string
 	err := json.Unmarshal(data, &s)
 	if err != nil {
-		return false, err
+		return 0, nil, err
+	}
+	bytes, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(bytes) < wordBytes {
+		return 0, nil, fmt.Errorf("gostatix: invalid bitset data of %d bytes", len(bytes))
 	}
-	bytes, _ := base64.URLEncoding.DecodeString(s)
 	lenBytes := bytes[:8]
 	bytes = bytes[8:]
 	size := binary.BigEndian.Uint64(lenBytes)
-	bitSet.size = uint(size)
+	if size > uint64(len(bytes)*8) {
+		return 0, nil, fmt.Errorf("gostatix: bitset length %d is greater than the %d bits of data", size, len(bytes)*8)
+	}
 	util.ReverseBytes(bytes)
 	for i := range bytes {
 		bytes[i] = util.ConvertByteToLittleEndianByte(bytes[i])
 	}
-	err = getRedisClient().Set(context.Background(), bitSet.key, string(bytes), 0).Err()
-	if err != nil {
-		return false, err
-	}
-	return true, nil
+	return size, bytes, nil
 }
 
 func uint64ArrayToByteArray(data []uint64) ([]byte, error) {
@@ -235,10 +657,174 @@ func uint64ArrayToByteArray(data []uint64) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// WriteTo writes the bitset to a stream in the same format as BitSetMem and
+// returns the number of bytes written onto the stream.
+// The Redis string is read in chunks of _redisChunkSize_ bytes using GETRANGE
 func (bitSet *BitSetRedis) writeTo(stream io.Writer) (int64, error) {
-	return 0, nil //bitsetredis doesn't implement WriteTo function
+	return writeRedisBits(stream, bitSet.size, func(ctx context.Context, start, end uint64) ([]byte, error) {
+		return getRedisClient().GetRange(ctx, bitSet.key, int64(start), int64(end-1)).Bytes()
+	})
+}
+
+// writeRedisBits writes the header and the words of a bitset of _size_ bits saved in Redis
+// to _stream_ in the same format as BitSetMem. _getRange_ returns the bytes [start, end) of
+// the bitset, missing bytes at the end of the range are treated as zeroes.
+func writeRedisBits(stream io.Writer, size uint, getRange func(ctx context.Context, start, end uint64) ([]byte, error)) (int64, error) {
+	numWords := (uint64(size) + uint64(wordSize) - 1) / uint64(wordSize)
+	header := make([]byte, 2*wordBytes)
+	binary.BigEndian.PutUint64(header[:wordBytes], uint64(size))
+	binary.BigEndian.PutUint64(header[wordBytes:], uint64(size))
+	n, err := stream.Write(header)
+	numBytes := int64(n)
+	if err != nil {
+		return numBytes, err
+	}
+	ctx := context.Background()
+	totalBytes := numWords * uint64(wordBytes)
+	buf := make([]byte, 0, redisChunkSize)
+	for start := uint64(0); start < totalBytes; start += redisChunkSize {
+		end := start + redisChunkSize
+		if end > totalBytes {
+			end = totalBytes
+		}
+		chunk, err := getRange(ctx, start, end)
+		if err != nil {
+			return numBytes, fmt.Errorf("gostatix: error while reading bitset from redis, error: %v", err)
+		}
+		buf = buf[:end-start]
+		for i := range buf {
+			if i < len(chunk) {
+				buf[i] = util.ConvertByteToLittleEndianByte(chunk[i])
+			} else {
+				buf[i] = 0
+			}
+		}
+		for i := 0; i < len(buf); i += wordBytes {
+			word := binary.LittleEndian.Uint64(buf[i : i+wordBytes])
+			binary.BigEndian.PutUint64(buf[i:i+wordBytes], word)
+		}
+		n, err = stream.Write(buf)
+		numBytes += int64(n)
+		if err != nil {
+			return numBytes, err
+		}
+	}
+	return numBytes, nil
 }
 
+// ReadFrom reads the stream written by BitSetMem or BitSetRedis and imports it into
+// the bitset and returns the number of bytes read.
+// The data is written to a temporary key in chunks of _redisChunkSize_ bytes using
+// SETRANGE and then renamed to _key_ so that the bitset is replaced atomically
 func (bitSet *BitSetRedis) readFrom(stream io.Reader) (int64, error) {
-	return 0, nil //bitsetredis doesn't implement ReadFrom function
+	size, length, numBytes, err := readRedisBitsHeader(stream)
+	if err != nil {
+		return numBytes, err
+	}
+	allocated, err := redisBitsBytes(size)
+	if err != nil {
+		return numBytes, err
+	}
+	ctx := context.Background()
+	tmpKey, err := newRedisKey(ctx, "")
+	if err != nil {
+		return numBytes, err
+	}
+	// the string is zero padded to the words of the bitset like the one created by
+	// newBitSetRedis
+	err = allocateRedisBits(ctx, tmpKey, allocated)
+	if err != nil {
+		return numBytes, fmt.Errorf("gostatix: error while writing bitset to redis, error: %v", err)
+	}
+	n, err := readRedisBits(stream, length, func(ctx context.Context, start uint64, chunk []byte) error {
+		return getRedisClient().SetRange(ctx, tmpKey, int64(start), string(chunk)).Err()
+	})
+	numBytes += n
+	if err != nil {
+		getRedisClient().Del(ctx, tmpKey)
+		return numBytes, err
+	}
+	if bitSet.key == "" {
+		bitSet.key, err = newRedisKey(ctx, "")
+		if err != nil {
+			getRedisClient().Del(ctx, tmpKey)
+			return numBytes, err
+		}
+	}
+	err = getRedisClient().Rename(ctx, tmpKey, bitSet.key).Err()
+	if err != nil {
+		getRedisClient().Del(ctx, tmpKey)
+		return numBytes, fmt.Errorf("gostatix: error while writing bitset to redis, error: %v", err)
+	}
+	bitSet.size = uint(size)
+	return numBytes, nil
+}
+
+// readRedisBitsHeader reads and validates the size and the length of a bitset written by
+// BitSetMem or BitSetRedis from _stream_
+func readRedisBitsHeader(stream io.Reader) (uint64, uint64, int64, error) {
+	header := make([]byte, 2*wordBytes)
+	n, err := io.ReadFull(stream, header)
+	numBytes := int64(n)
+	if err != nil {
+		return 0, 0, numBytes, err
+	}
+	size := binary.BigEndian.Uint64(header[:wordBytes])
+	length := binary.BigEndian.Uint64(header[wordBytes:])
+	err = checkSnapshotSize("bitset", size, 1)
+	if err != nil {
+		return 0, 0, numBytes, err
+	}
+	err = checkSnapshotSize("bitset", length/uint64(wordSize), uint64(wordBytes))
+	if err != nil {
+		return 0, 0, numBytes, err
+	}
+	return size, length, numBytes, nil
+}
+
+// readRedisBits reads the words of a bitset of _length_ bits following its header from
+// _stream_ and passes them to _setRange_ in chunks of _redisChunkSize_ bytes converted
+// to the bit order of Redis. _start_ is the offset of the chunk in the bitset.
+func readRedisBits(stream io.Reader, length uint64, setRange func(ctx context.Context, start uint64, chunk []byte) error) (int64, error) {
+	var numBytes int64
+	ctx := context.Background()
+	numWords := (length + uint64(wordSize) - 1) / uint64(wordSize)
+	totalBytes := numWords * uint64(wordBytes)
+	buf := make([]byte, redisChunkSize)
+	for start := uint64(0); start < totalBytes; start += redisChunkSize {
+		end := start + redisChunkSize
+		if end > totalBytes {
+			end = totalBytes
+		}
+		chunk := buf[:end-start]
+		n, err := io.ReadFull(stream, chunk)
+		numBytes += int64(n)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return numBytes, err
+		}
+		for i := 0; i < len(chunk); i += wordBytes {
+			word := binary.BigEndian.Uint64(chunk[i : i+wordBytes])
+			binary.LittleEndian.PutUint64(chunk[i:i+wordBytes], word)
+		}
+		for i := range chunk {
+			chunk[i] = util.ConvertByteToLittleEndianByte(chunk[i])
+		}
+		err = setRange(ctx, start, chunk)
+		if err != nil {
+			return numBytes, fmt.Errorf("gostatix: error while writing bitset to redis, error: %v", err)
+		}
+	}
+	return numBytes, nil
+}
+
+// allocateRedisBits sets the string at _key_ to _numBytes_ zero bytes
+func allocateRedisBits(ctx context.Context, key string, numBytes uint64) error {
+	err := getRedisClient().Set(ctx, key, "", 0).Err()
+	if err == nil && numBytes > 0 {
+		err = getRedisClient().SetRange(ctx, key, int64(numBytes-1), "\x00").Err()
+	}
+	return err
 }
@@ -0,0 +1,459 @@
+package gostatix
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maxShardSize is the maximum number of bits of a shard of ShardedBitSetRedis,
+// Redis strings are limited to 512MB
+const maxShardSize uint64 = 1 << 32
+
+// ShardedBitSetRedis is an implementation of IBitSet which splits the bits of the
+// bitset across multiple Redis strings (shards) so that huge bitsets aren't limited
+// by the maximum size of a Redis string and the load is spread across keys.
+// size is the number of bits in the bitset
+// shardSize is the number of bits in each shard, the bit at index _i_ is saved in
+// the shard i / shardSize
+// keys are the redis keys of the shards
+// The bit operations on multiple indices are pipelined across the shards.
+type ShardedBitSetRedis struct {
+	size      uint
+	shardSize uint
+	keys      []string
+}
+
+// newShardedBitSetRedis creates a new ShardedBitSetRedis of size _size_ split across
+// _numShards_ Redis keys
+func newShardedBitSetRedis(size, numShards uint) (*ShardedBitSetRedis, error) {
+	keys := make([]string, numShards)
+	for i := range keys {
+		key, err := newRedisKey(context.Background(), "")
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return newShardedBitSetRedisWithKeys(context.Background(), size, keys)
+}
+
+// newShardedBitSetRedisWithKeys creates a new ShardedBitSetRedis of size _size_ split
+// across the redis keys _keys_
+func newShardedBitSetRedisWithKeys(ctx context.Context, size uint, keys []string) (*ShardedBitSetRedis, error) {
+	bitSet, err := fromRedisShardKeys(keys, size)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range bitSet.keys {
+		err = allocateRedisBits(ctx, key, uint64(bitSet.shardSize/8))
+		if err != nil {
+			return nil, fmt.Errorf("gostatix: error while creating sharded bitset in redis, error: %v", err)
+		}
+	}
+	return bitSet, nil
+}
+
+// fromRedisShardKeys creates an instance of ShardedBitSetRedis of size _size_ from the
+// shards saved at the redis keys _keys_
+func fromRedisShardKeys(keys []string, size uint) (*ShardedBitSetRedis, error) {
+	shardSize, err := shardSizeFor(uint64(size), uint(len(keys)))
+	if err != nil {
+		return nil, err
+	}
+	return &ShardedBitSetRedis{size, shardSize, keys}, nil
+}
+
+// shardSizeFor returns the number of bits of each of the _numShards_ shards of a bitset
+// of _size_ bits. It's rounded up to a multiple of _wordSize_.
+func shardSizeFor(size uint64, numShards uint) (uint, error) {
+	if numShards == 0 {
+		return 0, fmt.Errorf("gostatix: number of shards should be greater than 0")
+	}
+	shardSize := (size + uint64(numShards) - 1) / uint64(numShards)
+	shardSize = (shardSize + uint64(wordSize) - 1) / uint64(wordSize) * uint64(wordSize)
+	if shardSize == 0 {
+		shardSize = uint64(wordSize)
+	}
+	if shardSize > maxShardSize {
+		return 0, fmt.Errorf("gostatix: shards of %d bits are larger than the maximum of %d bits, use more than %d shards", shardSize, maxShardSize, numShards)
+	}
+	if uint64(uint(shardSize)) != shardSize {
+		return 0, fmt.Errorf("gostatix: shards of %d bits don't fit in a uint on this platform, use more than %d shards", shardSize, numShards)
+	}
+	return uint(shardSize), nil
+}
+
+// Size returns the size of the bitset saved in redis
+func (bitSet *ShardedBitSetRedis) getSize() uint {
+	return bitSet.size
+}
+
+// Keys gives the keys at which the shards of the bitset are saved in redis
+func (bitSet *ShardedBitSetRedis) getKeys() []string {
+	return bitSet.keys
+}
+
+// locate returns the key of the shard and the offset in the shard of the bit at _index_
+func (bitSet *ShardedBitSetRedis) locate(index uint) (string, int64) {
+	return bitSet.keys[index/bitSet.shardSize], int64(index % bitSet.shardSize)
+}
+
+// Has checks if the bit at index _index_ is set
+func (bitSet *ShardedBitSetRedis) has(index uint) (bool, error) {
+	if index >= bitSet.size {
+		return false, fmt.Errorf("gostatix: index %d is out of range of bitset of size %d", index, bitSet.size)
+	}
+	key, offset := bitSet.locate(index)
+	val, err := getRedisClient().GetBit(context.Background(), key, offset).Result()
+	if err != nil {
+		return false, err
+	}
+	return val != 0, nil
+}
+
+// HasMulti checks if the bit at the indices specified by _indexes_ array is set.
+// The lookups are pipelined across the shards.
+func (bitSet *ShardedBitSetRedis) hasMulti(indexes []uint) ([]bool, error) {
+	if len(indexes) == 0 {
+		return nil, fmt.Errorf("gostatix: at least 1 index is required")
+	}
+	err := bitSet.checkIndexes(indexes)
+	if err != nil {
+		return nil, err
+	}
+	pipe := getRedisClient().Pipeline()
+	ctx := context.Background()
+	values := make([]*redis.IntCmd, len(indexes))
+	for i := range indexes {
+		key, offset := bitSet.locate(indexes[i])
+		values[i] = pipe.GetBit(ctx, key, offset)
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]bool, len(values))
+	for i := range values {
+		result[i] = values[i].Val() != 0
+	}
+	return result, nil
+}
+
+// Insert sets the bit at index specified by _index_
+func (bitSet *ShardedBitSetRedis) insert(index uint) (bool, error) {
+	if index >= bitSet.size {
+		return false, fmt.Errorf("gostatix: index %d is out of range of bitset of size %d", index, bitSet.size)
+	}
+	key, offset := bitSet.locate(index)
+	err := getRedisClient().SetBit(context.Background(), key, offset, 1).Err()
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Insert sets the bits at indices specified by array _indexes_.
+// The writes are pipelined across the shards.
+func (bitSet *ShardedBitSetRedis) insertMulti(indexes []uint) (bool, error) {
+	if len(indexes) == 0 {
+		return false, fmt.Errorf("gostatix: at least 1 index is required")
+	}
+	err := bitSet.checkIndexes(indexes)
+	if err != nil {
+		return false, err
+	}
+	pipe := getRedisClient().Pipeline()
+	ctx := context.Background()
+	for i := range indexes {
+		key, offset := bitSet.locate(indexes[i])
+		pipe.SetBit(ctx, key, offset, 1)
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// InsertMultiIfMissing sets the bits at indices specified by array _indexes_ in a
+// transaction across the shards and returns true if any of them wasn't set
+func (bitSet *ShardedBitSetRedis) insertMultiIfMissing(indexes []uint) (bool, error) {
+	if len(indexes) == 0 {
+		return false, fmt.Errorf("gostatix: at least 1 index is required")
+	}
+	err := bitSet.checkIndexes(indexes)
+	if err != nil {
+		return false, err
+	}
+	ctx := context.Background()
+	previous := make([]*redis.IntCmd, len(indexes))
+	_, err = getRedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range indexes {
+			key, offset := bitSet.locate(indexes[i])
+			previous[i] = pipe.SetBit(ctx, key, offset, 1)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return anyUnset(previous), nil
+}
+
+func (bitSet *ShardedBitSetRedis) checkIndexes(indexes []uint) error {
+	for _, index := range indexes {
+		if index >= bitSet.size {
+			return fmt.Errorf("gostatix: index %d is out of range of bitset of size %d", index, bitSet.size)
+		}
+	}
+	return nil
+}
+
+// Equals checks if two ShardedBitSetRedis are equal or not
+func (aSet *ShardedBitSetRedis) equals(otherBitSet IBitSet) (bool, error) {
+	bSet, ok := otherBitSet.(*ShardedBitSetRedis)
+	if !ok {
+		return false, fmt.Errorf("invalid bitset type, should be ShardedBitSetRedis")
+	}
+	if aSet.size != bSet.size || len(aSet.keys) != len(bSet.keys) {
+		return false, nil
+	}
+	for i := range aSet.keys {
+		aSetVal, err := getRedisClient().Get(context.Background(), aSet.keys[i]).Result()
+		if err != nil {
+			return false, err
+		}
+		bSetVal, err := getRedisClient().Get(context.Background(), bSet.keys[i]).Result()
+		if err != nil {
+			return false, err
+		}
+		if aSetVal != bSetVal {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Max returns the first set bit in the bitset starting from index 0
+func (bitSet *ShardedBitSetRedis) max() (uint, bool) {
+	for i, key := range bitSet.keys {
+		index, err := getRedisClient().BitPos(context.Background(), key, 1).Result()
+		if err != nil {
+			return 0, false
+		}
+		if index != -1 {
+			return uint(i)*bitSet.shardSize + uint(index), true
+		}
+	}
+	return 0, false
+}
+
+// BitCount returns the total number of set bits in all the shards of the bitset
+func (bitSet *ShardedBitSetRedis) bitCount() (uint, error) {
+	pipe := getRedisClient().Pipeline()
+	ctx := context.Background()
+	counts := make([]*redis.IntCmd, len(bitSet.keys))
+	for i, key := range bitSet.keys {
+		counts[i] = pipe.BitCount(ctx, key, &redis.BitCount{Start: 0, End: -1})
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var total uint
+	for _, count := range counts {
+		total += uint(count.Val())
+	}
+	return total, nil
+}
+
+// Union sets the bits set in _otherBitSet_, which should be a ShardedBitSetRedis of the
+// same size and number of shards, using a BITOP OR per shard pipelined
+func (bitSet *ShardedBitSetRedis) union(otherBitSet IBitSet) error {
+	secondBitSet, ok := otherBitSet.(*ShardedBitSetRedis)
+	if !ok {
+		return fmt.Errorf("gostatix: invalid bitset type, should be ShardedBitSetRedis, type: %T", otherBitSet)
+	}
+	if bitSet.size != secondBitSet.size || len(bitSet.keys) != len(secondBitSet.keys) {
+		return fmt.Errorf("gostatix: can't merge sharded bitsets of different sizes or number of shards")
+	}
+	ctx := context.Background()
+	_, err := getRedisClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range bitSet.keys {
+			pipe.BitOpOr(ctx, key, key, secondBitSet.keys[i])
+		}
+		return nil
+	})
+	return err
+}
+
+// Clear unsets all the bits of the bitset by overwriting all the shards with zeroed bytes
+// in a transaction
+func (bitSet *ShardedBitSetRedis) clear() error {
+	ctx := context.Background()
+	_, err := getRedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range bitSet.keys {
+			pipe.Set(ctx, key, "", 0)
+			pipe.SetRange(ctx, key, int64(bitSet.shardSize/8)-1, "\x00")
+		}
+		return nil
+	})
+	return err
+}
+
+// memoryUsage returns the number of bytes used by the shards in Redis
+func (bitSet *ShardedBitSetRedis) memoryUsage() (uint64, error) {
+	return redisMemoryUsage(bitSet.keys)
+}
+
+// Export returns the json marshalling of the bitset. The shards are concatenated, so
+// the format is the same as the one of BitSetRedis.
+func (bitSet *ShardedBitSetRedis) marshal() (uint, []byte, error) {
+	bytes, err := bitSet.getRange(context.Background(), 0, bitSet.numBytes())
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := marshalRedisBits(bitSet.size, bytes)
+	if err != nil {
+		return 0, nil, err
+	}
+	return bitSet.size, data, nil
+}
+
+// Import imports the marshalled json in the byte array data into the shards of the bitset.
+// The shards are resized for the size of the imported bitset.
+func (bitSet *ShardedBitSetRedis) unmarshal(data []byte) (bool, error) {
+	size, bytes, err := unmarshalRedisBits(data)
+	if err != nil {
+		return false, err
+	}
+	shardSize, err := shardSizeFor(size, uint(len(bitSet.keys)))
+	if err != nil {
+		return false, err
+	}
+	shardBytes := uint64(shardSize / 8)
+	ctx := context.Background()
+	_, err = getRedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range bitSet.keys {
+			start := minUint64(uint64(i)*shardBytes, uint64(len(bytes)))
+			end := minUint64(start+shardBytes, uint64(len(bytes)))
+			pipe.Set(ctx, key, string(bytes[start:end]), 0)
+			if end-start < shardBytes {
+				pipe.SetRange(ctx, key, int64(shardBytes-1), "\x00")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	bitSet.size = uint(size)
+	bitSet.shardSize = shardSize
+	return true, nil
+}
+
+// WriteTo writes the bitset to a stream in the same format as BitSetMem and
+// returns the number of bytes written onto the stream.
+// The shards are read in chunks of _redisChunkSize_ bytes using GETRANGE
+func (bitSet *ShardedBitSetRedis) writeTo(stream io.Writer) (int64, error) {
+	return writeRedisBits(stream, bitSet.size, bitSet.getRange)
+}
+
+// ReadFrom reads the stream written by BitSetMem, BitSetRedis or ShardedBitSetRedis and
+// imports it into the bitset and returns the number of bytes read.
+// The data is written to temporary keys in chunks of _redisChunkSize_ bytes using
+// SETRANGE and then renamed to the keys of the shards in a transaction so that the
+// bitset is replaced atomically. The shards are resized for the size of the read bitset.
+func (bitSet *ShardedBitSetRedis) readFrom(stream io.Reader) (int64, error) {
+	size, length, numBytes, err := readRedisBitsHeader(stream)
+	if err != nil {
+		return numBytes, err
+	}
+	shardSize, err := shardSizeFor(size, uint(len(bitSet.keys)))
+	if err != nil {
+		return numBytes, err
+	}
+	if length > uint64(shardSize)*uint64(len(bitSet.keys)) {
+		return numBytes, fmt.Errorf("gostatix: bitset length %d is greater than its size %d", length, size)
+	}
+	ctx := context.Background()
+	tmpSet := &ShardedBitSetRedis{uint(size), shardSize, make([]string, len(bitSet.keys))}
+	for i := range tmpSet.keys {
+		tmpSet.keys[i], err = newRedisKey(ctx, "")
+		if err == nil {
+			err = allocateRedisBits(ctx, tmpSet.keys[i], uint64(shardSize/8))
+		}
+		if err != nil {
+			getRedisClient().Del(ctx, tmpSet.keys[:i+1]...)
+			return numBytes, fmt.Errorf("gostatix: error while writing bitset to redis, error: %v", err)
+		}
+	}
+	n, err := readRedisBits(stream, length, tmpSet.setRange)
+	numBytes += n
+	if err != nil {
+		getRedisClient().Del(ctx, tmpSet.keys...)
+		return numBytes, err
+	}
+	_, err = getRedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range tmpSet.keys {
+			pipe.Rename(ctx, tmpSet.keys[i], bitSet.keys[i])
+		}
+		return nil
+	})
+	if err != nil {
+		getRedisClient().Del(ctx, tmpSet.keys...)
+		return numBytes, fmt.Errorf("gostatix: error while writing bitset to redis, error: %v", err)
+	}
+	bitSet.size = uint(size)
+	bitSet.shardSize = shardSize
+	return numBytes, nil
+}
+
+// numBytes returns the total number of bytes of the shards
+func (bitSet *ShardedBitSetRedis) numBytes() uint64 {
+	return uint64(bitSet.shardSize/8) * uint64(len(bitSet.keys))
+}
+
+// getRange returns the bytes [start, end) of the concatenated shards. The bytes missing
+// in Redis are zeroes.
+func (bitSet *ShardedBitSetRedis) getRange(ctx context.Context, start, end uint64) ([]byte, error) {
+	shardBytes := uint64(bitSet.shardSize / 8)
+	end = minUint64(end, bitSet.numBytes())
+	result := make([]byte, 0, end-minUint64(start, end))
+	for start < end {
+		shard, offset := start/shardBytes, start%shardBytes
+		n := minUint64(end-start, shardBytes-offset)
+		chunk, err := getRedisClient().GetRange(ctx, bitSet.keys[shard], int64(offset), int64(offset+n-1)).Bytes()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, chunk...)
+		for i := uint64(len(chunk)); i < n; i++ {
+			result = append(result, 0)
+		}
+		start += n
+	}
+	return result, nil
+}
+
+// setRange writes _chunk_ at the offset _start_ of the concatenated shards
+func (bitSet *ShardedBitSetRedis) setRange(ctx context.Context, start uint64, chunk []byte) error {
+	shardBytes := uint64(bitSet.shardSize / 8)
+	for len(chunk) > 0 {
+		shard, offset := start/shardBytes, start%shardBytes
+		if shard >= uint64(len(bitSet.keys)) {
+			return fmt.Errorf("gostatix: offset %d is out of range of the shards", start)
+		}
+		n := minUint64(uint64(len(chunk)), shardBytes-offset)
+		err := getRedisClient().SetRange(ctx, bitSet.keys[shard], int64(offset), string(chunk[:n])).Err()
+		if err != nil {
+			return err
+		}
+		chunk = chunk[n:]
+		start += n
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package gostatix
+
+import (
+	"bytes"
+	"math/bits"
+	"strconv"
+	"testing"
+)
+
+func TestShardedBitSetRedisInsertHas(t *testing.T) {
+	initMockRedis()
+	bitset, err := newShardedBitSetRedis(1000, 4)
+	if err != nil {
+		t.Fatalf("sharded bitset shouldn't error out, error: %v", err)
+	}
+	if bitset.shardSize != 256 {
+		t.Errorf("shard size should be 256, got %d", bitset.shardSize)
+	}
+	indexes := []uint{0, 255, 256, 600, 999}
+	bitset.insertMulti(indexes)
+	bitset.insert(500)
+	has, err := bitset.hasMulti([]uint{0, 255, 256, 500, 600, 999, 1, 257})
+	if err != nil {
+		t.Fatalf("hasMulti shouldn't error out, error: %v", err)
+	}
+	for i, expected := range []bool{true, true, true, true, true, true, false, false} {
+		if has[i] != expected {
+			t.Errorf("bit %d should be %v", i, expected)
+		}
+	}
+	if count, _ := bitset.bitCount(); count != 6 {
+		t.Errorf("6 bits should be set, found %d", count)
+	}
+	if ok, _ := bitset.has(1000); ok {
+		t.Error("bit out of range shouldn't be set")
+	}
+	if _, err := bitset.insertMulti([]uint{1, 1000}); err == nil {
+		t.Error("insert out of range should error out")
+	}
+}
+
+func TestShardedBitSetRedisMax(t *testing.T) {
+	initMockRedis()
+	bitset, _ := newShardedBitSetRedis(1000, 4)
+	if _, ok := bitset.max(); ok {
+		t.Error("empty bitset shouldn't have a set bit")
+	}
+	bitset.insert(700)
+	bitset.insert(900)
+	if index, ok := bitset.max(); !ok || index != 700 {
+		t.Errorf("first set bit should be 700, got %d", index)
+	}
+}
+
+func TestShardedBitSetRedisShardSize(t *testing.T) {
+	if _, err := shardSizeFor(100, 0); err == nil {
+		t.Error("0 shards should error out")
+	}
+	if _, err := shardSizeFor(1<<34, 2); err == nil {
+		t.Error("shards larger than 512MB should error out")
+	}
+	if size, _ := shardSizeFor(1<<34, 4); bits.UintSize == 64 && uint64(size) != 1<<32 {
+		t.Errorf("shard size should be 2^32, got %d", size)
+	}
+}
+
+func TestShardedBitSetRedisExportImport(t *testing.T) {
+	initMockRedis()
+	aSet, _ := newShardedBitSetRedis(300, 3)
+	aSet.insertMulti([]uint{0, 99, 128, 299})
+	_, data, err := aSet.marshal()
+	if err != nil {
+		t.Fatalf("marshal shouldn't error out, error: %v", err)
+	}
+	bSet, _ := newShardedBitSetRedis(64, 3)
+	_, err = bSet.unmarshal(data)
+	if err != nil {
+		t.Fatalf("unmarshal shouldn't error out, error: %v", err)
+	}
+	if ok, _ := aSet.equals(bSet); !ok {
+		t.Error("bitsets should be equal after unmarshal")
+	}
+}
+
+func TestShardedBitSetRedisBinaryReadWrite(t *testing.T) {
+	initMockRedis()
+	indexes := []uint{0, 1, 63, 64, 100, 199, 450}
+	memSet := newBitSetMem(500)
+	for _, index := range indexes {
+		memSet.insert(index)
+	}
+	var buff bytes.Buffer
+	memSet.writeTo(&buff)
+
+	aSet, _ := newShardedBitSetRedis(10, 3)
+	_, err := aSet.readFrom(bytes.NewReader(buff.Bytes()))
+	if err != nil {
+		t.Fatalf("readFrom shouldn't error out, error: %v", err)
+	}
+	if aSet.getSize() != 500 {
+		t.Errorf("size should be 500, found %d", aSet.getSize())
+	}
+	for _, index := range indexes {
+		if ok, _ := aSet.has(index); !ok {
+			t.Errorf("bit %d should be set after readFrom", index)
+		}
+	}
+
+	buff.Reset()
+	numBytes, err := aSet.writeTo(&buff)
+	if err != nil {
+		t.Fatalf("writeTo shouldn't error out, error: %v", err)
+	}
+	if numBytes != int64(buff.Len()) {
+		t.Errorf("bytes written %d should match the length of the stream %d", numBytes, buff.Len())
+	}
+	bSet := &BitSetMem{}
+	_, err = bSet.readFrom(&buff)
+	if err != nil {
+		t.Fatalf("readFrom of BitSetMem shouldn't error out, error: %v", err)
+	}
+	if ok, _ := memSet.equals(bSet); !ok {
+		t.Error("bitsets should be equal after a round trip through the shards")
+	}
+}
+
+func TestBloomFilterWithShards(t *testing.T) {
+	initMockRedis()
+	filter, err := NewRedisBloomFilterWithShards(1000, 0.01, 4)
+	if err != nil {
+		t.Fatalf("sharded filter shouldn't error out, error: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		filter.InsertString(strconv.Itoa(i))
+	}
+	loaded, err := NewRedisBloomFilterFromKey(filter.GetMetadataKey())
+	if err != nil {
+		t.Fatalf("sharded filter should be loaded from key, error: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if !loaded.LookupString(strconv.Itoa(i)) {
+			t.Errorf("%d should be present in the loaded filter", i)
+		}
+	}
+	var buff bytes.Buffer
+	filter.WriteTo(&buff)
+	other, _ := NewRedisBloomFilterWithShards(10, 0.1, 2)
+	_, err = other.ReadFrom(&buff)
+	if err != nil {
+		t.Fatalf("sharded filter should be read from stream, error: %v", err)
+	}
+	if !other.LookupString("42") {
+		t.Error("42 should be present in the filter read from stream")
+	}
+	if _, err := NewRedisBloomFilterWithShards(1000, 0.01, 0); err == nil {
+		t.Error("filter with 0 shards should error out")
+	}
+}
@@ -1,7 +1,10 @@
 package gostatix
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"math/bits"
 	"testing"
 
 	"github.com/alicebob/miniredis/v2"
@@ -178,3 +181,108 @@ func TestBitSetRedisEqual(t *testing.T) {
 		t.Fatal("aBitset and bBitset should be equal")
 	}
 }
+
+func TestBitSetRedisBinaryReadWrite(t *testing.T) {
+	initMockRedis()
+	indexes := []uint{0, 1, 63, 64, 100, 199}
+	aSet := newBitSetRedis(200)
+	aSet.insertMulti(indexes)
+	var buff bytes.Buffer
+	numBytes, err := aSet.writeTo(&buff)
+	if err != nil {
+		t.Fatalf("writeTo shouldn't error out, error: %v", err)
+	}
+	if numBytes != int64(buff.Len()) {
+		t.Errorf("bytes written %d should match the length of the stream %d", numBytes, buff.Len())
+	}
+
+	memSet := &BitSetMem{}
+	_, err = memSet.readFrom(bytes.NewReader(buff.Bytes()))
+	if err != nil {
+		t.Fatalf("readFrom of BitSetMem shouldn't error out, error: %v", err)
+	}
+	for _, index := range indexes {
+		if ok, _ := memSet.has(index); !ok {
+			t.Errorf("bit %d should be set in BitSetMem", index)
+		}
+	}
+	if count, _ := memSet.bitCount(); count != uint(len(indexes)) {
+		t.Errorf("%d bits should be set in BitSetMem, found %d", len(indexes), count)
+	}
+
+	bSet := newBitSetRedis(10)
+	_, err = bSet.readFrom(&buff)
+	if err != nil {
+		t.Fatalf("readFrom shouldn't error out, error: %v", err)
+	}
+	if bSet.getSize() != 200 {
+		t.Errorf("size should be 200, found %d", bSet.getSize())
+	}
+	if ok, _ := aSet.equals(bSet); !ok {
+		t.Error("bitsets should be equal after readFrom")
+	}
+}
+
+func TestBitSetRedisReadFromTruncated(t *testing.T) {
+	initMockRedis()
+	memSet := newBitSetMem(1000)
+	memSet.insert(999)
+	var buff bytes.Buffer
+	memSet.writeTo(&buff)
+	bSet := newBitSetRedis(10)
+	_, err := bSet.readFrom(bytes.NewReader(buff.Bytes()[:buff.Len()-4]))
+	if err == nil {
+		t.Error("should error out as the stream is truncated")
+	}
+	if bSet.getSize() != 10 {
+		t.Errorf("size shouldn't change on error, found %d", bSet.getSize())
+	}
+}
+
+func TestBitSetRedisAllocatedBytes(t *testing.T) {
+	initMockRedis()
+	bitset, err := newBitSetRedisWithKey(context.Background(), 1000, "bits")
+	if err != nil {
+		t.Fatalf("creating the bitset shouldn't error out, error: %v", err)
+	}
+	// 1000 bits are held by 16 words
+	if length, _ := getRedisClient().StrLen(context.Background(), bitset.getKey()).Result(); length != 128 {
+		t.Errorf("bitset of 1000 bits should allocate 128 bytes, got %d", length)
+	}
+	if _, err := redisBitsBytes(maxShardSize + 1); err == nil {
+		t.Errorf("bitset larger than a redis string should error out")
+	}
+	if bits.UintSize == 64 {
+		huge := maxShardSize + 1
+		_, err = newBitSetRedisWithKey(context.Background(), uint(huge), "huge")
+		if err == nil {
+			t.Errorf("bitset larger than a redis string should error out")
+		}
+	}
+	if exists, _ := getRedisClient().Exists(context.Background(), "huge").Result(); exists != 0 {
+		t.Errorf("bitset larger than a redis string shouldn't be allocated")
+	}
+}
+
+func TestBitSetRedisAPI(t *testing.T) {
+	initMockRedis()
+	testBitSet(t, func(size uint) BitSet {
+		bitSet, err := NewBitSetRedis(size)
+		if err != nil {
+			t.Fatalf("creating the bitset shouldn't error out, error: %v", err)
+		}
+		return bitSet
+	})
+	bitSet, _ := NewBitSetRedis(100)
+	bitSet.Set(42)
+	opened, err := NewBitSetRedisFromKey(bitSet.Key(), 100)
+	if err != nil {
+		t.Fatalf("opening the bitset shouldn't error out, error: %v", err)
+	}
+	if ok, _ := opened.Test(42); !ok {
+		t.Errorf("bit 42 should be set in the opened bitset")
+	}
+	if _, err := NewBitSetRedisFromKey("missing", 100); err == nil {
+		t.Errorf("opening a missing bitset should error out")
+	}
+}
@@ -0,0 +1,410 @@
+package gostatix
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"unsafe"
+)
+
+// BitSetRoaring is an in-memory implementation of IBitSet holding the bits set in a
+// Roaring bitmap, for sparse filters of huge sizes with few bits set. Its memory grows
+// with the number of bits set rather than with its size: 2 bytes per bit in the chunks of
+// 2^16 bits with at most 4096 bits set, 8 KB per chunk with more.
+// It holds at most 2^32 bits. It's marshalled and written like BitSetMem, so that its
+// snapshots can be loaded by any bitset.
+// _bitmap_ holds the indexes of the bits set
+// _size_ is the number of bits in the bitset
+type BitSetRoaring struct {
+	bitmap *roaringBitmap
+	size   uint
+}
+
+// NewBitSetRoaring creates a new in-memory Roaring BitSet of _size_ bits, all unset. It
+// errors out if _size_ is greater than 2^32.
+func NewBitSetRoaring(size uint) (*BitSetRoaring, error) {
+	if uint64(size) > math.MaxUint32+1 {
+		return nil, fmt.Errorf("gostatix: roaring bitset of %d bits is larger than the maximum of %d bits", size, uint64(math.MaxUint32+1))
+	}
+	return &BitSetRoaring{&roaringBitmap{}, size}, nil
+}
+
+// search returns the index of the container of _key_, or the index it would be inserted at
+func (rb *roaringBitmap) search(key uint16) int {
+	return sort.Search(len(rb.containers), func(i int) bool { return rb.containers[i].key >= key })
+}
+
+// contains returns true if _value_ is in the bitmap
+func (rb *roaringBitmap) contains(value uint32) bool {
+	key := uint16(value >> 16)
+	i := rb.search(key)
+	return i < len(rb.containers) && rb.containers[i].key == key && rb.containers[i].contains(uint16(value))
+}
+
+// add adds _value_ to the bitmap and returns true if it wasn't in it
+func (rb *roaringBitmap) add(value uint32) bool {
+	key := uint16(value >> 16)
+	i := rb.search(key)
+	if i == len(rb.containers) || rb.containers[i].key != key {
+		rb.containers = append(rb.containers, roaringContainer{})
+		copy(rb.containers[i+1:], rb.containers[i:])
+		rb.containers[i] = roaringContainer{key: key, array: []uint16{uint16(value)}}
+		return true
+	}
+	return rb.containers[i].add(uint16(value))
+}
+
+// union adds the values of _other_ to the bitmap
+func (rb *roaringBitmap) union(other *roaringBitmap) {
+	for _, c := range other.containers {
+		i := rb.search(c.key)
+		if i == len(rb.containers) || rb.containers[i].key != c.key {
+			rb.containers = append(rb.containers, roaringContainer{})
+			copy(rb.containers[i+1:], rb.containers[i:])
+			rb.containers[i] = c.clone()
+			continue
+		}
+		rb.containers[i].union(&c)
+	}
+}
+
+// equals returns true if the bitmap and _other_ hold the same values
+func (rb *roaringBitmap) equals(other *roaringBitmap) bool {
+	if len(rb.containers) != len(other.containers) {
+		return false
+	}
+	for i := range rb.containers {
+		if !rb.containers[i].equals(&other.containers[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// contains returns true if the value of low 16 bits _low_ is in the container
+func (c *roaringContainer) contains(low uint16) bool {
+	if c.bitmap != nil {
+		return c.bitmap[low/uint16(wordSize)]&(1<<(low%uint16(wordSize))) != 0
+	}
+	j := sort.Search(len(c.array), func(j int) bool { return c.array[j] >= low })
+	return j < len(c.array) && c.array[j] == low
+}
+
+// add adds the value of low 16 bits _low_ to the container and returns true if it wasn't
+// in it. The array is converted to a bitmap once it holds more than roaringArrayMax values.
+func (c *roaringContainer) add(low uint16) bool {
+	if c.bitmap == nil {
+		j := sort.Search(len(c.array), func(j int) bool { return c.array[j] >= low })
+		if j < len(c.array) && c.array[j] == low {
+			return false
+		}
+		if len(c.array) < roaringArrayMax {
+			c.array = append(c.array, 0)
+			copy(c.array[j+1:], c.array[j:])
+			c.array[j] = low
+			return true
+		}
+		c.bitmap = c.words()
+		c.array = nil
+	}
+	word, mask := &c.bitmap[low/uint16(wordSize)], uint64(1)<<(low%uint16(wordSize))
+	if *word&mask != 0 {
+		return false
+	}
+	*word |= mask
+	return true
+}
+
+// words returns the bitmap of the values of the container, a copy if it's a bitmap
+func (c *roaringContainer) words() []uint64 {
+	words := make([]uint64, roaringBitmapWords)
+	if c.bitmap != nil {
+		copy(words, c.bitmap)
+		return words
+	}
+	for _, low := range c.array {
+		words[low/uint16(wordSize)] |= 1 << (low % uint16(wordSize))
+	}
+	return words
+}
+
+// clone returns a deep copy of the container
+func (c *roaringContainer) clone() roaringContainer {
+	if c.bitmap != nil {
+		return roaringContainer{key: c.key, bitmap: c.words()}
+	}
+	return roaringContainer{key: c.key, array: append([]uint16(nil), c.array...)}
+}
+
+// union adds the values of _other_, of the same key, to the container
+func (c *roaringContainer) union(other *roaringContainer) {
+	if c.bitmap == nil && other.bitmap == nil && len(c.array)+len(other.array) <= roaringArrayMax {
+		merged := make([]uint16, 0, len(c.array)+len(other.array))
+		i, j := 0, 0
+		for i < len(c.array) || j < len(other.array) {
+			switch {
+			case j == len(other.array) || (i < len(c.array) && c.array[i] < other.array[j]):
+				merged = append(merged, c.array[i])
+				i++
+			case i == len(c.array) || other.array[j] < c.array[i]:
+				merged = append(merged, other.array[j])
+				j++
+			default:
+				merged = append(merged, c.array[i])
+				i++
+				j++
+			}
+		}
+		c.array = merged
+		return
+	}
+	if c.bitmap == nil {
+		c.bitmap = c.words()
+		c.array = nil
+	}
+	if other.bitmap != nil {
+		for i, word := range other.bitmap {
+			c.bitmap[i] |= word
+		}
+		return
+	}
+	for _, low := range other.array {
+		c.bitmap[low/uint16(wordSize)] |= 1 << (low % uint16(wordSize))
+	}
+}
+
+// equals returns true if the container and _other_ hold the same values, whatever their
+// representations
+func (c *roaringContainer) equals(other *roaringContainer) bool {
+	if c.key != other.key {
+		return false
+	}
+	if c.bitmap == nil && other.bitmap == nil {
+		if len(c.array) != len(other.array) {
+			return false
+		}
+		for i := range c.array {
+			if c.array[i] != other.array[i] {
+				return false
+			}
+		}
+		return true
+	}
+	a, b := c.words(), other.words()
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Size returns the number of bits of the bitset
+func (bitSet *BitSetRoaring) Size() uint {
+	return bitSet.size
+}
+
+// getSize returns the size of the bitset
+func (bitSet *BitSetRoaring) getSize() uint {
+	return bitSet.size
+}
+
+// has checks if the bit at index _index_ is set
+func (bitSet *BitSetRoaring) has(index uint) (bool, error) {
+	if index >= bitSet.size {
+		return false, nil
+	}
+	return bitSet.bitmap.contains(uint32(index)), nil
+}
+
+// hasMulti checks if the bits at the indices specified by _indexes_ array are set
+func (bitSet *BitSetRoaring) hasMulti(indexes []uint) ([]bool, error) {
+	result := make([]bool, len(indexes))
+	for i, index := range indexes {
+		result[i], _ = bitSet.has(index)
+	}
+	return result, nil
+}
+
+// insert sets the bit at index _index_
+func (bitSet *BitSetRoaring) insert(index uint) (bool, error) {
+	err := checkBitIndex(index, bitSet.size)
+	if err != nil {
+		return false, err
+	}
+	bitSet.bitmap.add(uint32(index))
+	return true, nil
+}
+
+// insertMulti sets the bits at the indices specified by _indexes_ array
+func (bitSet *BitSetRoaring) insertMulti(indexes []uint) (bool, error) {
+	for _, index := range indexes {
+		_, err := bitSet.insert(index)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// insertMultiIfMissing sets the bits at the indices specified by _indexes_ array and
+// returns true if any of them wasn't set
+func (bitSet *BitSetRoaring) insertMultiIfMissing(indexes []uint) (bool, error) {
+	missing := false
+	for _, index := range indexes {
+		err := checkBitIndex(index, bitSet.size)
+		if err != nil {
+			return false, err
+		}
+		if bitSet.bitmap.add(uint32(index)) {
+			missing = true
+		}
+	}
+	return missing, nil
+}
+
+// equals checks if two BitSetRoaring have the same size and bits set
+func (bitSet *BitSetRoaring) equals(otherBitSet IBitSet) (bool, error) {
+	other, ok := otherBitSet.(*BitSetRoaring)
+	if !ok {
+		return false, fmt.Errorf("invalid bitset type, should be BitSetRoaring, type: %T", otherBitSet)
+	}
+	return bitSet.size == other.size && bitSet.bitmap.equals(other.bitmap), nil
+}
+
+// max returns the index of the first bit set, false if none
+func (bitSet *BitSetRoaring) max() (uint, bool) {
+	var first uint
+	found := false
+	bitSet.bitmap.forEach(func(value uint32) bool {
+		first, found = uint(value), true
+		return false
+	})
+	return first, found
+}
+
+// bitCount returns the total number of set bits in the bitset
+func (bitSet *BitSetRoaring) bitCount() (uint, error) {
+	count := uint(0)
+	for i := range bitSet.bitmap.containers {
+		count += uint(bitSet.bitmap.containers[i].cardinality())
+	}
+	return count, nil
+}
+
+// union sets the bits set in _otherBitSet_, a BitSetRoaring of the same size
+func (bitSet *BitSetRoaring) union(otherBitSet IBitSet) error {
+	other, ok := otherBitSet.(*BitSetRoaring)
+	if !ok {
+		return fmt.Errorf("gostatix: invalid bitset type, should be BitSetRoaring, type: %T", otherBitSet)
+	}
+	if other.size != bitSet.size {
+		return fmt.Errorf("gostatix: can't merge bitsets of sizes %d and %d", bitSet.size, other.size)
+	}
+	if other != bitSet {
+		bitSet.bitmap.union(other.bitmap)
+	}
+	return nil
+}
+
+// clear unsets all the bits of the bitset, keeping its size
+func (bitSet *BitSetRoaring) clear() error {
+	bitSet.bitmap = &roaringBitmap{}
+	return nil
+}
+
+// memoryUsage returns the number of bytes used by the containers of the bitset
+func (bitSet *BitSetRoaring) memoryUsage() (uint64, error) {
+	containers := bitSet.bitmap.containers
+	usage := uint64(unsafe.Sizeof(roaringContainer{})) * uint64(cap(containers))
+	for i := range containers {
+		usage += 2*uint64(cap(containers[i].array)) + uint64(wordBytes*len(containers[i].bitmap))
+	}
+	return usage, nil
+}
+
+// toBitSetMem returns the BitSetMem holding the bits of the bitset
+func (bitSet *BitSetRoaring) toBitSetMem() (*BitSetMem, error) {
+	words, err := bitSet.bitmap.words(uint64(bitSet.size))
+	if err != nil {
+		return nil, err
+	}
+	bitSetMem := newBitSetMem(bitSet.size)
+	return bitSetMem, bitSetMem.OrWords(words, 0)
+}
+
+// fromBitSetMem replaces the bits and the size of the bitset with the ones of _other_
+func (bitSet *BitSetRoaring) fromBitSetMem(other *BitSetMem) error {
+	if uint64(other.size) > math.MaxUint32+1 {
+		return fmt.Errorf("gostatix: roaring bitset of %d bits is larger than the maximum of %d bits", other.size, uint64(math.MaxUint32+1))
+	}
+	words := other.set.Bytes()
+	if wordsFor(uint64(other.size)) < uint64(len(words)) {
+		words = words[:wordsFor(uint64(other.size))]
+	}
+	bitSet.bitmap = newRoaringFromWords(words)
+	bitSet.size = other.size
+	return nil
+}
+
+// marshal returns the json marshalling of the bitset, the one of BitSetMem
+func (bitSet *BitSetRoaring) marshal() (uint, []byte, error) {
+	bitSetMem, err := bitSet.toBitSetMem()
+	if err != nil {
+		return 0, nil, err
+	}
+	return bitSetMem.marshal()
+}
+
+// unmarshal imports the marshalled json of a BitSetMem in _data_ into the bitset
+func (bitSet *BitSetRoaring) unmarshal(data []byte) (bool, error) {
+	other := &BitSetMem{}
+	_, err := other.unmarshal(data)
+	if err != nil {
+		return false, err
+	}
+	return true, bitSet.fromBitSetMem(other)
+}
+
+// writeTo writes the bitset to a stream in the format of BitSetMem and returns the number
+// of bytes written onto the stream. The words are produced a container at a time.
+func (bitSet *BitSetRoaring) writeTo(stream io.Writer) (int64, error) {
+	writer := bufio.NewWriter(stream)
+	numWords := wordsFor(uint64(bitSet.size))
+	buf := make([]byte, wordBytes)
+	binary.BigEndian.PutUint64(buf, uint64(bitSet.size))
+	writer.Write(buf)
+	writer.Write(buf)
+	empty := make([]uint64, roaringBitmapWords)
+	next := 0
+	for from := uint64(0); from < numWords; from += uint64(roaringBitmapWords) {
+		words := empty
+		if next < len(bitSet.bitmap.containers) && uint64(bitSet.bitmap.containers[next].key) == from/uint64(roaringBitmapWords) {
+			words = bitSet.bitmap.containers[next].words()
+			next++
+		}
+		for i := 0; i < roaringBitmapWords && from+uint64(i) < numWords; i++ {
+			binary.BigEndian.PutUint64(buf, words[i])
+			writer.Write(buf)
+		}
+	}
+	err := writer.Flush()
+	if err != nil {
+		return 0, err
+	}
+	return int64((numWords + 2) * uint64(wordBytes)), nil
+}
+
+// readFrom reads a bitset written in the format of BitSetMem from _stream_ into the
+// bitset and returns the number of bytes read
+func (bitSet *BitSetRoaring) readFrom(stream io.Reader) (int64, error) {
+	other := &BitSetMem{}
+	numBytes, err := other.readFrom(stream)
+	if err != nil {
+		return 0, err
+	}
+	return numBytes, bitSet.fromBitSetMem(other)
+}
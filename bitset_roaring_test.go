@@ -0,0 +1,153 @@
+package gostatix
+
+import (
+	"bytes"
+	"math/bits"
+	"strconv"
+	"testing"
+)
+
+func TestBitSetRoaringInsert(t *testing.T) {
+	bitSet, _ := NewBitSetRoaring(1 << 20)
+	// the first chunk is converted to a bitmap, the others stay arrays
+	for i := uint(0); i < 5000; i++ {
+		bitSet.insert(2 * i)
+	}
+	bitSet.insert(1<<20 - 1)
+	bitSet.insert(1 << 17)
+	if len(bitSet.bitmap.containers) != 3 || bitSet.bitmap.containers[0].bitmap == nil || bitSet.bitmap.containers[1].array == nil {
+		t.Errorf("dense chunks should be bitmaps and sparse chunks arrays")
+	}
+	for _, index := range []uint{0, 9998, 1 << 17, 1<<20 - 1} {
+		if ok, _ := bitSet.has(index); !ok {
+			t.Errorf("bit %d should be set", index)
+		}
+	}
+	if ok, _ := bitSet.has(9999); ok {
+		t.Errorf("bit 9999 shouldn't be set")
+	}
+	if count, _ := bitSet.bitCount(); count != 5002 {
+		t.Errorf("5002 bits should be set, got %d", count)
+	}
+	if first, ok := bitSet.max(); !ok || first != 0 {
+		t.Errorf("first bit set should be 0, got %d, %v", first, ok)
+	}
+	if added, _ := bitSet.insertMultiIfMissing([]uint{0, 2}); added {
+		t.Errorf("setting bits already set shouldn't add them")
+	}
+	if added, _ := bitSet.insertMultiIfMissing([]uint{0, 3}); !added {
+		t.Errorf("setting a bit not set should add it")
+	}
+	if _, err := bitSet.insert(1 << 20); err == nil {
+		t.Errorf("insert out of the bitset should error out")
+	}
+	if bits.UintSize == 64 {
+		tooLarge := uint64(1<<32 + 1)
+		if _, err := NewBitSetRoaring(uint(tooLarge)); err == nil {
+			t.Errorf("bitset larger than 2^32 bits should error out")
+		}
+	}
+}
+
+func TestBitSetRoaringUnion(t *testing.T) {
+	a, _ := NewBitSetRoaring(1 << 18)
+	b, _ := NewBitSetRoaring(1 << 18)
+	expected, _ := NewBitSetRoaring(1 << 18)
+	for i := uint(0); i < 3000; i++ {
+		a.insert(i)
+		b.insert(i + 2000)
+		b.insert(1<<17 + i)
+		expected.insertMulti([]uint{i, i + 2000, 1<<17 + i})
+	}
+	a.union(b)
+	if equal, _ := a.equals(expected); !equal {
+		t.Errorf("union should hold the bits of both bitsets")
+	}
+	if count, _ := a.bitCount(); count != 8000 {
+		t.Errorf("8000 bits should be set, got %d", count)
+	}
+	b.insert(1)
+	if ok, _ := a.has(1<<17 + 1); !ok {
+		t.Errorf("union should hold the bits of the other bitset")
+	}
+	if equal, _ := a.equals(b); equal {
+		t.Errorf("bitsets with different bits shouldn't be equal")
+	}
+	a.clear()
+	if count, _ := a.bitCount(); count != 0 || a.Size() != 1<<18 {
+		t.Errorf("clear should unset all the bits and keep the size")
+	}
+}
+
+func TestBitSetRoaringSnapshots(t *testing.T) {
+	bitSet, _ := NewBitSetRoaring(200000)
+	dense := newBitSetMem(200000)
+	for i := uint(0); i < 200000; i += 37 {
+		bitSet.insert(i)
+		dense.insert(i)
+	}
+	_, data, _ := bitSet.marshal()
+	_, denseData, _ := dense.marshal()
+	if !bytes.Equal(data, denseData) {
+		t.Errorf("roaring bitset should be marshalled like BitSetMem")
+	}
+	var stream, denseStream bytes.Buffer
+	numBytes, err := bitSet.writeTo(&stream)
+	dense.writeTo(&denseStream)
+	if err != nil || numBytes != int64(stream.Len()) || !bytes.Equal(stream.Bytes(), denseStream.Bytes()) {
+		t.Errorf("roaring bitset should be written like BitSetMem, error: %v", err)
+	}
+	imported, _ := NewBitSetRoaring(1)
+	if _, err := imported.unmarshal(denseData); err != nil {
+		t.Fatalf("unmarshal shouldn't error out, error: %v", err)
+	}
+	if equal, _ := imported.equals(bitSet); !equal {
+		t.Errorf("unmarshalled bitset should equal the marshalled one")
+	}
+	read, _ := NewBitSetRoaring(1)
+	if _, err := read.readFrom(&denseStream); err != nil {
+		t.Fatalf("read shouldn't error out, error: %v", err)
+	}
+	if equal, _ := read.equals(bitSet); !equal || read.Size() != 200000 {
+		t.Errorf("read bitset should equal the written one")
+	}
+}
+
+func TestBloomFilterWithRoaring(t *testing.T) {
+	filter, err := NewBloomFilter(WithCapacity(10000000, 0.001), WithRoaring())
+	if err != nil {
+		t.Fatalf("creation shouldn't error out, error: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		filter.InsertString(strconv.Itoa(i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !filter.LookupString(strconv.Itoa(i)) {
+			t.Errorf("%d should be found", i)
+		}
+	}
+	if filter.LookupString("foo") {
+		t.Errorf("foo shouldn't be found")
+	}
+	dense, _ := NewBloomFilter(WithCapacity(10000000, 0.001))
+	roaringBytes, _ := filter.MemoryUsage()
+	denseBytes, _ := dense.MemoryUsage()
+	if roaringBytes*10 > denseBytes {
+		t.Errorf("sparse roaring filter should use less memory, %d bytes against %d", roaringBytes, denseBytes)
+	}
+	data, _ := filter.Export()
+	if err := dense.Import(data); err != nil {
+		t.Fatalf("import in a dense filter shouldn't error out, error: %v", err)
+	}
+	if !dense.LookupString("42") {
+		t.Errorf("dense filter should hold the elements of the roaring one")
+	}
+	roaringData, _ := filter.ExportRoaring()
+	denseRoaringData, _ := dense.ExportRoaring()
+	if !bytes.Equal(roaringData, denseRoaringData) {
+		t.Errorf("roaring exports of equal filters should be equal")
+	}
+	if _, err := NewBloomFilter(WithCapacity(1000, 0.01), WithBackend(RedisBackend), WithRoaring()); err == nil {
+		t.Errorf("roaring redis filter should error out")
+	}
+}
@@ -11,8 +11,13 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"math/bits"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"github.com/dgryski/go-metro"
 	"github.com/kwertop/gostatix/internal/util"
@@ -23,38 +28,189 @@ import (
 // _numHashes_ denotes the number of hashing functions applied on the entrant element
 // during insertion or lookup.
 // _filter_ is the bitset backing internally the bloom filter. It can either be a type of
-// BitSetMem (in-memory) or BitSetRedis (redis-backed).
+// BitSetMem or BitSetRoaring (in-memory), BitSetMmap (memory mapped file), BitSetRedis or
+// ShardedBitSetRedis (redis-backed).
 // _metadataKey_ saves the information about a Bloom Filter saved on Redis
-// _lock_ is used to synchronize read/write on an in-memory BitSetMem. It's not used for
-// BitSetRedis as Redis is event-driven single threaded
+// _lock_ is used to synchronize read/write on an in-process BitSetMem, BitSetRoaring or
+// BitSetMmap, the lookups only take its read lock so that they don't block each other.
+// It's not used for BitSetRedis as Redis is event-driven single threaded
+// _resources_ keeps track of the async writers to be closed along with the filter
+// _hashing_ is the scheme used to derive the bit indexes of the elements
+// _unsynchronized_ disables _lock_ for filters created WithLocking(false)
+// _watchers_ holds the FillWatchers of the filter, replaced under _watchersLock_
+// _updated_ records the time of the last update made through the filter
 type BloomFilter struct {
-	size        uint
-	numHashes   uint
-	filter      IBitSet
-	metadataKey string
-	lock        sync.RWMutex
+	size           uint
+	numHashes      uint
+	hashing        BloomHashing
+	filter         IBitSet
+	metadataKey    string
+	lock           sync.RWMutex
+	unsynchronized bool
+	resources      resources
+	watchers       atomic.Pointer[[]*FillWatcher]
+	watchersLock   sync.Mutex
+	updated        updateClock
 }
 
 // NewBloomFilterWithBitSet creates and returns a new BloomFilter
 // _size_ is the maximum size of the bloom filter
 // _numHashes_ is the number of hashing functions to be applied on the entrant
-// _filter_ is either BitSetMem or BitSetRedis
+// _filter_ is either BitSetMem, BitSetRoaring, BitSetMmap or BitSetRedis
 // _metadataKey_ is needed if the filter is of type BitSetRedis otherwise it's overlooked
 func NewBloomFilterWithBitSet(size, numHashes uint, filter IBitSet, metadataKey string) (*BloomFilter, error) {
-	if !isBitSetMem(filter) && metadataKey == "" {
+	if filter == nil {
+		return nil, fmt.Errorf("gostatix: error initializing filter as bitset is nil")
+	}
+	if size == 0 || numHashes == 0 {
+		return nil, fmt.Errorf("gostatix: error initializing filter as size %v and number of hashes %v should be greater than 0", size, numHashes)
+	}
+	if !isBitSetInProcess(filter) && metadataKey == "" {
 		return nil, fmt.Errorf("gostatix: error initializing filter as metadataKey is blank for BitSetRedis")
 	}
 	if filter.getSize() != size {
 		return nil, fmt.Errorf("gostatix: error initializing filter as size of bitset %v doesn't match with size %v passed", filter.getSize(), size)
 	}
 	return &BloomFilter{
-		size:        util.Max(size, 1),
-		numHashes:   util.Max(numHashes, 1),
+		size:        size,
+		numHashes:   numHashes,
 		filter:      filter,
 		metadataKey: metadataKey,
 	}, nil
 }
 
+// NewBloomFilter creates and returns a new BloomFilter configured by _opts_. WithCapacity
+// is required, the filter is in-memory unless WithBackend selects Redis. For a Redis backed
+// filter, the metadataKey is created using a random alpha-numeric generator (prefixed by
+// WithKeyPrefix) and can be retrieved using MetadataKey() method.
+func NewBloomFilter(opts ...Option) (*BloomFilter, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	err := o.validate()
+	if err != nil {
+		return nil, err
+	}
+	size, numHashes, err := o.bloomFilterSize()
+	if err != nil {
+		return nil, err
+	}
+	var filter IBitSet
+	metadataKey := ""
+	if o.backend == MemoryBackend && o.roaring {
+		filter, err = NewBitSetRoaring(size)
+		if err != nil {
+			return nil, err
+		}
+	} else if o.backend == MemoryBackend {
+		filter = newBitSetMem(size)
+	} else {
+		metadataKey, err = o.metadataKey()
+		if err != nil {
+			return nil, err
+		}
+		metadata := make(map[string]interface{})
+		metadata["size"] = size
+		metadata["numHashes"] = numHashes
+		metadata["hashing"] = uint8(o.hashing)
+		keys := []string{metadataKey}
+		if o.sharded {
+			shardKeys := make([]string, o.numShards)
+			for i := range shardKeys {
+				shardKeys[i], err = o.dataKey(":bits:" + strconv.Itoa(i))
+				if err != nil {
+					return nil, err
+				}
+			}
+			bitSet, err := newShardedBitSetRedisWithKeys(o.ctx, size, shardKeys)
+			if err != nil {
+				return nil, err
+			}
+			filter = bitSet
+			metadata["bitsetKeys"] = strings.Join(shardKeys, ",")
+			keys = append(keys, shardKeys...)
+		} else {
+			bitSetKey, err := o.dataKey(":bits")
+			if err != nil {
+				return nil, err
+			}
+			bitSet, err := newBitSetRedisWithKey(o.ctx, size, bitSetKey)
+			if err != nil {
+				return nil, fmt.Errorf("gostatix: error while creating bloom filter redis. error: %v", err)
+			}
+			filter = bitSet
+			metadata["bitsetKey"] = bitSet.getKey()
+			keys = append(keys, bitSet.getKey())
+		}
+		err = saveMetadataContext(o.ctx, metadataKey, "bloom", metadata)
+		if err != nil {
+			return nil, fmt.Errorf("gostatix: error while creating bloom filter redis. error: %v", err)
+		}
+		err = o.expire(keys...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	bloomFilter, err := NewBloomFilterWithBitSet(size, numHashes, filter, metadataKey)
+	if err != nil {
+		return nil, err
+	}
+	bloomFilter.hashing = o.hashing
+	bloomFilter.unsynchronized = !o.locking
+	return bloomFilter, nil
+}
+
+// NewOrOpenBloomFilter creates a Redis backed BloomFilter named _name_ (see WithName)
+// configured by _opts_ like NewBloomFilter, or opens it like NewRedisBloomFilterFromKey if
+// it already exists. The existing filter must have been created with the same capacity,
+// hashing and number of shards. WithTTL only applies to a created filter.
+func NewOrOpenBloomFilter(name string, opts ...Option) (*BloomFilter, error) {
+	opts, err := namedOptions(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, WithBackend(RedisBackend))
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	err = o.validate()
+	if err != nil {
+		return nil, err
+	}
+	size, numHashes, err := o.bloomFilterSize()
+	if err != nil {
+		return nil, err
+	}
+	exists, err := o.nameExists()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return NewBloomFilter(opts...)
+	}
+	metadataKey := o.keyPrefix + o.name
+	bloomFilter, err := NewRedisBloomFilterFromKey(metadataKey)
+	if err != nil {
+		return nil, err
+	}
+	var numShards, expectedShards uint
+	if bitSet, ok := bloomFilter.filter.(*ShardedBitSetRedis); ok {
+		numShards = uint(len(bitSet.getKeys()))
+	}
+	if o.sharded {
+		expectedShards = o.numShards
+	}
+	err = checkOpenedParams("bloom filter", metadataKey,
+		[]interface{}{bloomFilter.size, bloomFilter.numHashes, bloomFilter.hashing, numShards},
+		[]interface{}{size, numHashes, o.hashing, expectedShards})
+	if err != nil {
+		return nil, err
+	}
+	return bloomFilter, nil
+}
+
 // NewRedisBloomFilterWithParameters creates and returns a new Redis backed BloomFilter
 // _numItems_ is the number of items for which the bloom filter has to be checked for validation
 // _errorRate_ is the acceptable false positive error rate
@@ -62,19 +218,19 @@ func NewBloomFilterWithBitSet(size, numHashes uint, filter IBitSet, metadataKey
 // metadataKey is created using a random alpha-numeric generator which can be retrieved using
 // MetadataKey() method
 func NewRedisBloomFilterWithParameters(numItems uint, errorRate float64) (*BloomFilter, error) {
-	size := util.CalculateFilterSize(numItems, errorRate)
-	numHashes := util.CalculateNumHashes(size, numItems)
-	filter := newBitSetRedis(size)
-	metadataKey := util.GenerateRandomString(16)
-	metadata := make(map[string]interface{})
-	metadata["size"] = size
-	metadata["numHashes"] = numHashes
-	metadata["bitsetKey"] = filter.getKey()
-	err := getRedisClient().HSet(context.Background(), metadataKey, metadata).Err()
-	if err != nil {
-		return nil, fmt.Errorf("gostatix: error while creating bloom filter redis. error: %v", err)
-	}
-	return NewBloomFilterWithBitSet(size, numHashes, filter, metadataKey)
+	return NewBloomFilter(WithBackend(RedisBackend), WithCapacity(numItems, errorRate))
+}
+
+// NewRedisBloomFilterWithShards creates and returns a new Redis backed BloomFilter whose
+// bitset is split across _numShards_ Redis keys (see ShardedBitSetRedis)
+// _numItems_ is the number of items for which the bloom filter has to be checked for validation
+// _errorRate_ is the acceptable false positive error rate
+// _numShards_ is the number of Redis keys the bits are spread across. Each shard is limited
+// to 2^32 bits (512MB), so huge filters need enough shards.
+// metadataKey is created using a random alpha-numeric generator which can be retrieved using
+// MetadataKey() method
+func NewRedisBloomFilterWithShards(numItems uint, errorRate float64, numShards uint) (*BloomFilter, error) {
+	return NewBloomFilter(WithBackend(RedisBackend), WithCapacity(numItems, errorRate), WithShards(numShards))
 }
 
 // NewRedisBloomFilterWithParameters creates and returns a new in-memory BloomFilter
@@ -82,32 +238,65 @@ func NewRedisBloomFilterWithParameters(numItems uint, errorRate float64) (*Bloom
 // _errorRate_ is the acceptable false positive error rate
 // Based upon the above two parameters passed, the size of the bloom filter is calculated
 func NewMemBloomFilterWithParameters(numItems uint, errorRate float64) (*BloomFilter, error) {
-	size := util.CalculateFilterSize(numItems, errorRate)
-	numHashes := util.CalculateNumHashes(size, numItems)
-	filter := newBitSetMem(size)
-	return NewBloomFilterWithBitSet(util.Max(size, 1), util.Max(numHashes, 1), filter, "")
+	return NewBloomFilter(WithCapacity(numItems, errorRate))
+}
+
+// NewMmapBloomFilter creates and returns a new BloomFilter backed by the memory mapped file
+// at _path_, so that it survives restarts without Redis and is reopened without reading the
+// file. The file is created if it doesn't exist, otherwise it's reopened and should have
+// been created with the same _numItems_ and _errorRate_. Close flushes the file to disk
+// and unmaps it, the filter shouldn't be used afterwards.
+// _numItems_ is the number of items for which the bloom filter has to be checked for validation
+// _errorRate_ is the acceptable false positive error rate
+func NewMmapBloomFilter(path string, numItems uint, errorRate float64) (*BloomFilter, error) {
+	params := BloomFilterParams{numItems, errorRate}
+	err := params.Validate()
+	if err != nil {
+		return nil, err
+	}
+	size := params.Size()
+	numHashes := util.Max(util.CalculateNumHashes(size, numItems), 1)
+	bitSet, err := newBitSetMmap(path, size)
+	if err != nil {
+		return nil, err
+	}
+	bloomFilter, err := NewBloomFilterWithBitSet(size, numHashes, bitSet, "")
+	if err != nil {
+		bitSet.Close()
+		return nil, err
+	}
+	bloomFilter.resources.attach(bitSet)
+	return bloomFilter, nil
 }
 
 // NewRedisBloomFilterFromBitSet creates and returns a new Redis backed BloomFilter from the
 // bitset passed in the parameter _data_
 // _numHashes_ parameter is needed for the number of hashing functions
 func NewRedisBloomFilterFromBitSet(data []uint64, numHashes uint) (*BloomFilter, error) {
-	size := util.Max(uint(len(data)*64), 1)
-	numHashes = util.Max(numHashes, 1)
-	metadataKey := util.GenerateRandomString(16)
-	err := getRedisClient().HSet(context.Background(), metadataKey, map[string]interface{}{"size": size, "numHashes": numHashes}).Err()
-	if err != nil {
-		return nil, fmt.Errorf("gostatix: error while creating bloom filter redis. error: %v", err)
+	if len(data) == 0 || numHashes == 0 {
+		return nil, fmt.Errorf("gostatix: error initializing filter as bitset of %d words and number of hashes %d should be greater than 0", len(data), numHashes)
 	}
+	return newRedisBloomFilterFromWords(data, uint(len(data)*64), numHashes)
+}
+
+// newRedisBloomFilterFromWords creates a Redis backed BloomFilter of _size_ bits and
+// _numHashes_ hashes from the words _data_ of its bitset, which can hold more bits
+func newRedisBloomFilterFromWords(data []uint64, size, numHashes uint) (*BloomFilter, error) {
 	bitSetRedis, err := fromDataRedis(data)
 	if err != nil {
 		return nil, err
 	}
-	return &BloomFilter{
-		size:      size,
-		numHashes: numHashes,
-		filter:    bitSetRedis,
-	}, nil
+	bitSetRedis.size = size
+	metadataKey, err := newRedisKey(context.Background(), "")
+	if err != nil {
+		return nil, err
+	}
+	metadata := map[string]interface{}{"size": size, "numHashes": numHashes, "bitsetKey": bitSetRedis.getKey()}
+	err = saveMetadata(metadataKey, "bloom", metadata)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while creating bloom filter redis. error: %v", err)
+	}
+	return NewBloomFilterWithBitSet(size, numHashes, bitSetRedis, metadataKey)
 }
 
 // NewRedisBloomFilterFromBitSet creates and returns a new in-memory BloomFilter from the
@@ -122,30 +311,77 @@ func NewMemBloomFilterFromBitSet(data []uint64, numHashes uint) *BloomFilter {
 // _metadataKey_ (the Redis key used to store the metadata about the bloom filter) passed
 // For this to work, value should be present in Redis at _key_
 func NewRedisBloomFilterFromKey(metadataKey string) (*BloomFilter, error) {
-	values, err := getRedisClient().HGetAll(context.Background(), metadataKey).Result()
+	metadata, err := loadMetadata(metadataKey, "bloom")
 	if err != nil {
-		return nil, fmt.Errorf("gostatix: error while fetching hash from redis, error: %v", err)
+		return nil, err
 	}
-	bloomFilter := &BloomFilter{}
-	size, _ := strconv.Atoi(values["size"])
-	numHashes, _ := strconv.Atoi(values["numHashes"])
-	bloomFilter.size = uint(size)
-	bloomFilter.numHashes = uint(numHashes)
-	bloomFilter.metadataKey = metadataKey
-	bitsetKey := values["bitsetKey"]
-	filter, _ := fromRedisKey(bitsetKey)
-	bloomFilter.filter = filter
+	size, err := metadata.uint("size")
+	if err != nil {
+		return nil, err
+	}
+	numHashes, err := metadata.uint("numHashes")
+	if err != nil {
+		return nil, err
+	}
+	hashing, err := metadata.optionalUint("hashing")
+	if err != nil {
+		return nil, err
+	}
+	err = checkBloomFilterParams(size, numHashes)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: invalid bloom filter metadata at key %s, error: %v", metadataKey, err)
+	}
+	if hashing > uint64(BlockedHashing) {
+		return nil, fmt.Errorf("gostatix: invalid bloom filter metadata at key %s, unknown hashing scheme %d", metadataKey, hashing)
+	}
+	bloomFilter := &BloomFilter{size: uint(size), numHashes: uint(numHashes), hashing: BloomHashing(hashing), metadataKey: metadataKey}
+	if bitsetKeys := metadata.values["bitsetKeys"]; bitsetKeys != "" {
+		keys := strings.Split(bitsetKeys, ",")
+		err = metadata.checkKeysExist(keys...)
+		if err != nil {
+			return nil, err
+		}
+		filter, err := fromRedisShardKeys(keys, bloomFilter.size)
+		if err != nil {
+			return nil, err
+		}
+		bloomFilter.filter = filter
+		return bloomFilter, nil
+	}
+	bitsetKey, err := metadata.string("bitsetKey")
+	if err != nil {
+		return nil, err
+	}
+	err = metadata.checkKeysExist(bitsetKey)
+	if err != nil {
+		return nil, err
+	}
+	numBytes, err := getRedisClient().StrLen(context.Background(), bitsetKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while fetching bitset length from redis, error: %v", err)
+	}
+	if uint64(numBytes)*8 < size {
+		return nil, fmt.Errorf("gostatix: bitset at key %s of %d bits is smaller than the bloom filter of %d bits", bitsetKey, numBytes*8, size)
+	}
+	bloomFilter.filter = &BitSetRedis{bloomFilter.size, bitsetKey}
 	return bloomFilter, nil
 }
 
+// needsLock returns true if the reads and writes of the bloom filter have to be
+// synchronized with _lock_, i.e. for an in-memory bitset with locking enabled
+func (bloomFilter *BloomFilter) needsLock() bool {
+	return !bloomFilter.unsynchronized && isBitSetInProcess(bloomFilter.filter)
+}
+
 // Insert writes new _data_ in the bloom filter
 func (bloomFilter *BloomFilter) Insert(data []byte) *BloomFilter {
-	if isBitSetMem(bloomFilter.filter) {
+	defer bloomFilter.notifyFillWatchers(1)
+	if bloomFilter.needsLock() {
 		bloomFilter.lock.Lock()
 		defer bloomFilter.lock.Unlock()
 	}
 
-	hashes := getHashes(data)
+	hashes := bloomFilter.getHashes(data)
 	if isBitSetMem(bloomFilter.filter) {
 		for i := uint(0); i < bloomFilter.numHashes; i++ {
 			bloomFilter.filter.insert(bloomFilter.getIndex(hashes, i))
@@ -160,6 +396,132 @@ func (bloomFilter *BloomFilter) Insert(data []byte) *BloomFilter {
 	return bloomFilter
 }
 
+// AddIfNotExists inserts _data_ in the Bloom filter and returns true if it wasn't
+// already present, i.e. if any of its bits wasn't set. The check and the insert are done
+// atomically, under the lock of an in-memory filter or in a Redis transaction, so that
+// concurrent calls with the same _data_ return true only once.
+func (bloomFilter *BloomFilter) AddIfNotExists(data []byte) (bool, error) {
+	defer bloomFilter.notifyFillWatchers(1)
+	if bloomFilter.needsLock() {
+		bloomFilter.lock.Lock()
+		defer bloomFilter.lock.Unlock()
+	}
+
+	hashes := bloomFilter.getHashes(data)
+	indexes := make([]uint, bloomFilter.numHashes)
+	for i := uint(0); i < bloomFilter.numHashes; i++ {
+		indexes[i] = bloomFilter.getIndex(hashes, i)
+	}
+	added, err := bloomFilter.filter.insertMultiIfMissing(indexes)
+	if err != nil {
+		return false, fmt.Errorf("gostatix: error while inserting in bloom filter, error: %v", err)
+	}
+	return added, nil
+}
+
+// InsertFromReader streams keys separated by _delim_ from _stream_ and inserts them
+// in the bloom filter without holding all of them in memory.
+// _progress_ (optional, can be nil) is called periodically with the number of keys inserted.
+// It returns the total number of keys inserted.
+func (bloomFilter *BloomFilter) InsertFromReader(stream io.Reader, delim byte, progress ProgressFunc) (uint64, error) {
+	return util.ReadKeys(stream, delim, progress, func(key []byte) error {
+		bloomFilter.Insert(key)
+		return nil
+	})
+}
+
+// InsertFromReaderParallel is InsertFromReader spreading the keys over _workers_ goroutines,
+// GOMAXPROCS if _workers_ is 0. Each worker inserts its keys in an in-memory filter of its
+// own, which are merged in the BloomFilter once the stream is exhausted, so the load takes
+// _workers_ times the memory of the filter. The keys read before an error are merged too.
+// It errors out for the filters whose bitset isn't a BitSetMem or a BitSetMmap, which
+// can't be merged with in-memory filters.
+func (bloomFilter *BloomFilter) InsertFromReaderParallel(stream io.Reader, delim byte, workers int, progress ProgressFunc) (uint64, error) {
+	if _, ok := asBitSetMem(bloomFilter.filter); !ok {
+		return 0, fmt.Errorf("gostatix: can't load a bloom filter backed by a %T in parallel", bloomFilter.filter)
+	}
+	shards := make([]*BloomFilter, parallelWorkers(workers))
+	for i := range shards {
+		shards[i] = &BloomFilter{
+			size:           bloomFilter.size,
+			numHashes:      bloomFilter.numHashes,
+			hashing:        bloomFilter.hashing,
+			filter:         newBitSetMem(bloomFilter.size),
+			unsynchronized: true,
+		}
+	}
+	count, err := readKeysParallel(stream, delim, len(shards), progress, func(worker int, key []byte) {
+		shards[worker].Insert(key)
+	})
+	mergeErr := MergeBloomFilters(bloomFilter, shards)
+	if err == nil {
+		err = mergeErr
+	}
+	return count, err
+}
+
+// WithAsyncWrites returns an AsyncWriter which buffers the inserts to a Redis backed
+// bloom filter and flushes them in pipelines of _bufSize_ inserts or every _flushInterval_
+// (if greater than 0).
+// _onError_ (optional, can be nil) is called with the errors of the background flushes.
+// It errors out for an in-memory bloom filter.
+func (bloomFilter *BloomFilter) WithAsyncWrites(bufSize int, flushInterval time.Duration, onError func(error)) (*AsyncWriter, error) {
+	if isBitSetInProcess(bloomFilter.filter) {
+		return nil, fmt.Errorf("gostatix: async writes are only supported for redis backed bloom filter")
+	}
+	return newAsyncWriter(&bloomFilter.resources, bloomFilter, bufSize, flushInterval, onError)
+}
+
+func (bloomFilter *BloomFilter) writeBatch(batch []asyncWrite) error {
+	indexes := make([]uint, 0, len(batch)*int(bloomFilter.numHashes))
+	for _, write := range batch {
+		hashes := bloomFilter.getHashes(write.data)
+		for i := uint(0); i < bloomFilter.numHashes; i++ {
+			indexes = append(indexes, bloomFilter.getIndex(hashes, i))
+		}
+	}
+	_, err := bloomFilter.filter.insertMulti(indexes)
+	bloomFilter.notifyFillWatchers(uint64(len(batch)))
+	return err
+}
+
+// insertHashes inserts the elements _data_ whose hashes (see getHashes) are _hashes_ under
+// a single lock, or in a single write for the filters which aren't in memory. The elements
+// are hashed again by the filters whose hashing doesn't derive their indexes from getHashes.
+func (bloomFilter *BloomFilter) insertHashes(data [][]byte, hashes [][4]uint64) error {
+	defer bloomFilter.notifyFillWatchers(uint64(len(data)))
+	if bloomFilter.needsLock() {
+		bloomFilter.lock.Lock()
+		defer bloomFilter.lock.Unlock()
+	}
+
+	if bloomFilter.hashing == RedisBloomHashing || bloomFilter.hashing == BitsAndBloomsHashing {
+		hashes = make([][4]uint64, len(data))
+		for i := range data {
+			hashes[i] = bloomFilter.getHashes(data[i])
+		}
+	}
+	if isBitSetMem(bloomFilter.filter) {
+		for _, hash := range hashes {
+			for i := uint(0); i < bloomFilter.numHashes; i++ {
+				bloomFilter.filter.insert(bloomFilter.getIndex(hash, i))
+			}
+		}
+		return nil
+	}
+	indexes := make([]uint, 0, len(hashes)*int(bloomFilter.numHashes))
+	for _, hash := range hashes {
+		for i := uint(0); i < bloomFilter.numHashes; i++ {
+			indexes = append(indexes, bloomFilter.getIndex(hash, i))
+		}
+	}
+	_, err := bloomFilter.filter.insertMulti(indexes)
+	if err != nil {
+		return fmt.Errorf("gostatix: error while inserting in bloom filter, error: %v", err)
+	}
+	return nil
+}
+
 // GetCap returns the size of the bloom filter
 func (bloomFilter *BloomFilter) GetCap() uint {
 	return bloomFilter.size
@@ -170,9 +532,14 @@ func (bloomFilter *BloomFilter) GetNumHashes() uint {
 	return bloomFilter.numHashes
 }
 
+// Hashing returns the scheme used by the bloom filter to hash the elements
+func (bloomFilter *BloomFilter) Hashing() BloomHashing {
+	return bloomFilter.hashing
+}
+
 // GetBitSet returns the internal bitset. It would be a BitSetMem in case of an
-// in-memory Bloom filter while it would be a BitSetRedis for a Redis backed
-// Bloom filter.
+// in-memory Bloom filter while it would be a BitSetRedis (or ShardedBitSetRedis)
+// for a Redis backed Bloom filter.
 func (bloomFilter *BloomFilter) GetBitSet() *IBitSet {
 	return &bloomFilter.filter
 }
@@ -186,32 +553,63 @@ func (bloomFilter *BloomFilter) GetMetadataKey() string {
 // Lookup returns true if the corresponding bits in the bitset for _data_ is set,
 // otherwise false
 func (bloomFilter *BloomFilter) Lookup(data []byte) bool {
-	if isBitSetMem(bloomFilter.filter) {
-		bloomFilter.lock.Lock()
-		defer bloomFilter.lock.Unlock()
+	if bloomFilter.needsLock() {
+		bloomFilter.lock.RLock()
+		defer bloomFilter.lock.RUnlock()
 	}
 
-	hashes := getHashes(data)
-	// if bitset.IsBitSetMem(bloomFilter.filter) {
+	if !isBitSetMem(bloomFilter.filter) {
+		return bloomFilter.lookupMulti([][]byte{data})[0]
+	}
+	hashes := bloomFilter.getHashes(data)
 	for i := uint(0); i < bloomFilter.numHashes; i++ {
 		if ok, _ := bloomFilter.filter.has(bloomFilter.getIndex(hashes, i)); !ok {
 			return false
 		}
 	}
 	return true
-	// } else {
-	// 	indexes := make([]uint, bloomFilter.numHashes)
-	// 	for i := uint(0); i < bloomFilter.numHashes; i++ {
-	// 		indexes[i] = bloomFilter.getIndex(hashes, i)
-	// 	}
-	// 	result, _ := bloomFilter.filter.HasMulti(indexes)
-	// 	for i := range result {
-	// 		if !result[i] {
-	// 			return false
-	// 		}
-	// 	}
-	// 	return true
-	// }
+}
+
+// LookupMulti looks up all the elements of _data_ and returns a slice with the result of
+// Lookup for each of them. The bits of all the elements are read with a single pipelined
+// call for a Redis backed filter.
+func (bloomFilter *BloomFilter) LookupMulti(data [][]byte) []bool {
+	if bloomFilter.needsLock() {
+		bloomFilter.lock.RLock()
+		defer bloomFilter.lock.RUnlock()
+	}
+	return bloomFilter.lookupMulti(data)
+}
+
+// lookupMulti is LookupMulti without the lock. All the results are false if the bits
+// can't be read.
+func (bloomFilter *BloomFilter) lookupMulti(data [][]byte) []bool {
+	results := make([]bool, len(data))
+	if len(data) == 0 {
+		return results
+	}
+	numHashes := int(bloomFilter.numHashes)
+	indexes := make([]uint, 0, len(data)*numHashes)
+	for _, element := range data {
+		hashes := bloomFilter.getHashes(element)
+		for i := uint(0); i < bloomFilter.numHashes; i++ {
+			indexes = append(indexes, bloomFilter.getIndex(hashes, i))
+		}
+	}
+	bits, err := bloomFilter.filter.hasMulti(indexes)
+	if err != nil {
+		return results
+	}
+	for i := range results {
+		results[i] = true
+		for _, bit := range bits[i*numHashes : (i+1)*numHashes] {
+			if !bit {
+				results[i] = false
+				break
+			}
+		}
+	}
+	return results
 }
 
 // InsertString accepts string value as _data_ for inserting into the Bloom filter
@@ -224,83 +622,265 @@ func (bloomFilter *BloomFilter) LookupString(data string) bool {
 	return bloomFilter.Lookup([]byte(data))
 }
 
+// InsertUint64 accepts unsigned integer value as _data_ for inserting into the Bloom filter.
+// The integer is hashed as its 8 byte big endian encoding.
+func (bloomFilter *BloomFilter) InsertUint64(data uint64) *BloomFilter {
+	return bloomFilter.Insert(uint64Bytes(data))
+}
+
+// LookupUint64 accepts unsigned integer value as _data_ to lookup the Bloom filter
+func (bloomFilter *BloomFilter) LookupUint64(data uint64) bool {
+	return bloomFilter.Lookup(uint64Bytes(data))
+}
+
+// InsertKey accepts a Key as _data_ for inserting into the Bloom filter
+func (bloomFilter *BloomFilter) InsertKey(data Key) *BloomFilter {
+	return bloomFilter.Insert(data.KeyBytes())
+}
+
+// LookupKey accepts a Key as _data_ to lookup the Bloom filter
+func (bloomFilter *BloomFilter) LookupKey(data Key) bool {
+	return bloomFilter.Lookup(data.KeyBytes())
+}
+
 // BloomPositiveRate returns the false positive error rate of the filter
 func (bloomFilter *BloomFilter) BloomPositiveRate() float64 {
 	length, _ := bloomFilter.filter.bitCount()
+	return bloomFilter.positiveRate(length)
+}
+
+// positiveRate returns the estimated false positive rate of the bloom filter with _length_
+// bits set
+func (bloomFilter *BloomFilter) positiveRate(length uint) float64 {
 	return math.Pow(1-math.Exp(-float64(length)/float64(bloomFilter.size)), float64(bloomFilter.numHashes))
 }
 
-// Equals checks if two BloomFilter's are equal
+// FillRatio returns the fraction of bits set in the bitset of the bloom filter
+func (bloomFilter *BloomFilter) FillRatio() float64 {
+	length, _ := bloomFilter.filter.bitCount()
+	return float64(length) / float64(bloomFilter.size)
+}
+
+// Equals checks if two BloomFilter's are equal. Filters with different parameters or
+// bitset types aren't equal.
 func (aFilter *BloomFilter) Equals(bFilter *BloomFilter) (bool, error) {
-	if aFilter.size != bFilter.size || aFilter.numHashes != bFilter.numHashes {
-		return false, nil
+	comparison, err := aFilter.Compare(bFilter)
+	return comparison.Equal(), err
+}
+
+// Compare compares two BloomFilter's and returns why they aren't equal, if they aren't
+func (aFilter *BloomFilter) Compare(bFilter *BloomFilter) (Comparison, error) {
+	if aFilter.size != bFilter.size {
+		return parameterMismatch("size", aFilter.size, bFilter.size), nil
+	}
+	if aFilter.numHashes != bFilter.numHashes {
+		return parameterMismatch("numHashes", aFilter.numHashes, bFilter.numHashes), nil
+	}
+	if aFilter.hashing != bFilter.hashing {
+		return parameterMismatch("hashing", aFilter.hashing, bFilter.hashing), nil
+	}
+	aType, bType := fmt.Sprintf("%T", aFilter.filter), fmt.Sprintf("%T", bFilter.filter)
+	if aType != bType {
+		return parameterMismatch("bitset", aType, bType), nil
+	}
+	if aFilter == bFilter {
+		return equalComparison, nil
+	}
+	if aFilter.needsLock() {
+		aFilter.lock.RLock()
+		defer aFilter.lock.RUnlock()
+	}
+	if bFilter.needsLock() {
+		bFilter.lock.RLock()
+		defer bFilter.lock.RUnlock()
 	}
 	ok, err := aFilter.filter.equals(bFilter.filter)
 	if err != nil {
-		return false, err
+		return Comparison{}, err
+	}
+	return compareResult(ok, "bitsets"), nil
+}
+
+// Reset unsets all the bits of the bloom filter, which then holds no element. The size,
+// the number of hashes, the Redis keys of a Redis backed filter and its metadata are kept.
+func (bloomFilter *BloomFilter) Reset() error {
+	defer bloomFilter.notifyFillWatchers(0)
+	if bloomFilter.needsLock() {
+		bloomFilter.lock.Lock()
+		defer bloomFilter.lock.Unlock()
+	}
+	err := bloomFilter.filter.clear()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while resetting bloom filter, error: %v", err)
 	}
-	return ok, nil
+	return nil
+}
+
+// MemoryUsage returns the estimated number of bytes used by the bloom filter: in-process
+// for an in-memory filter, or in Redis (as reported by MEMORY USAGE) for the bitset and
+// the metadata of a Redis backed filter
+func (bloomFilter *BloomFilter) MemoryUsage() (uint64, error) {
+	if bloomFilter.needsLock() {
+		bloomFilter.lock.RLock()
+		defer bloomFilter.lock.RUnlock()
+		bytes, _ := bloomFilter.filter.memoryUsage()
+		return uint64(unsafe.Sizeof(*bloomFilter)) + bytes, nil
+	}
+	bytes, err := bloomFilter.filter.memoryUsage()
+	if err != nil {
+		return 0, err
+	}
+	metadataBytes, err := redisMemoryUsage([]string{bloomFilter.metadataKey})
+	if err != nil {
+		return 0, err
+	}
+	return bytes + metadataBytes, nil
+}
+
+// Merge merges the BloomFilter _bFilter_ into _aFilter_, which then holds the elements
+// inserted in both filters. The filters should have the same size and number of hashes
+// and the same kind of bitset. In-memory bitsets are merged a word at a time while
+// redis bitsets are merged in redis with BITOP OR.
+func (aFilter *BloomFilter) Merge(bFilter *BloomFilter) error {
+	if aFilter == bFilter {
+		return nil
+	}
+	if aFilter.size != bFilter.size || aFilter.numHashes != bFilter.numHashes {
+		return fmt.Errorf("gostatix: can't merge bloom filters of different sizes or number of hashes")
+	}
+	if aFilter.hashing != bFilter.hashing {
+		return fmt.Errorf("gostatix: can't merge bloom filters using %v and %v hashing", aFilter.hashing, bFilter.hashing)
+	}
+	defer aFilter.notifyFillWatchers(0)
+	if aFilter.needsLock() {
+		aFilter.lock.Lock()
+		defer aFilter.lock.Unlock()
+	}
+	if bFilter.needsLock() {
+		bFilter.lock.RLock()
+		defer bFilter.lock.RUnlock()
+	}
+	return aFilter.filter.union(bFilter.filter)
 }
 
 // internal type used to marshal/unmarshal BloomFilter
 type bloomFilterType struct {
-	M uint   `json:"m"`
-	K uint   `json:"k"`
-	B []byte `json:"b"`
+	M uint         `json:"m"`
+	K uint         `json:"k"`
+	B []byte       `json:"b"`
+	H BloomHashing `json:"h,omitempty"`
+}
+
+// Close releases the resources attached to the bloom filter, flushing and stopping
+// its async writers. The data of a Redis backed bloom filter is kept in Redis, the one
+// of a memory mapped bloom filter is flushed to its file and unmapped.
+// It's safe to call Close multiple times.
+func (bloomFilter *BloomFilter) Close() error {
+	return bloomFilter.resources.close()
 }
 
 // Export JSON marshals the BloomFilter and returns a byte slice containing the data
 func (bloomFilter *BloomFilter) Export() ([]byte, error) {
+	if bloomFilter.needsLock() {
+		bloomFilter.lock.RLock()
+		defer bloomFilter.lock.RUnlock()
+	}
 	_, bitset, err := bloomFilter.filter.marshal()
 	if err != nil {
 		return nil, err
 	}
-	return json.Marshal(bloomFilterType{bloomFilter.size, bloomFilter.numHashes, bitset})
+	return json.Marshal(bloomFilterType{bloomFilter.size, bloomFilter.numHashes, bitset, bloomFilter.hashing})
 }
 
-// Import JSON unmarshals the _data_ into the BloomFilter
+// Import JSON unmarshals the _data_ into the BloomFilter, or decodes it if it is a portable
+// snapshot written by ExportPortable
 func (bloomFilter *BloomFilter) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
+	if isPortable(data) {
+		return bloomFilter.importPortable(data)
+	}
 	var f bloomFilterType
-	err := json.Unmarshal(data, &f)
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return err
+	}
+	err = checkBloomFilterParams(uint64(f.M), uint64(f.K))
+	if err != nil {
+		return err
+	}
+	err = f.H.validate()
+	if err != nil {
+		return err
+	}
+	defer bloomFilter.notifyFillWatchers(0)
+	if bloomFilter.needsLock() {
+		bloomFilter.lock.Lock()
+		defer bloomFilter.lock.Unlock()
+	}
+	_, err = bloomFilter.filter.unmarshal(f.B)
 	if err != nil {
 		return err
 	}
 	bloomFilter.size = f.M
 	bloomFilter.numHashes = f.K
-	_, err = bloomFilter.filter.unmarshal(f.B)
-	return err
+	bloomFilter.hashing = f.H
+	return nil
 }
 
 // WriteTo writes the BloomFilter onto the specified _stream_ and returns the
 // number of bytes written.
 // It can be used to write to disk (using a file stream) or to network.
-// It's not implemented for Redis backed Bloom filter (BitSetRedis) as data for
-// a Redis backed Bloom Filter is already there in Redis.
+// The format is the same for in-memory and Redis backed Bloom filters. The bitset of
+// a Redis backed Bloom filter is streamed from Redis in chunks.
+// The hashing scheme is written in the top byte of the number of hashes, which is 0 for
+// the default scheme so that the format of those filters is unchanged.
+// The stream ends with the checksum of the filter, verified by ReadFrom.
 func (bloomFilter *BloomFilter) WriteTo(stream io.Writer) (int64, error) {
-	if !isBitSetMem(bloomFilter.filter) {
-		return 0, fmt.Errorf("stream write doesn't support bitset redis")
+	if bloomFilter.needsLock() {
+		bloomFilter.lock.RLock()
+		defer bloomFilter.lock.RUnlock()
 	}
+	checksum := newChecksumWriter(stream)
+	stream = checksum
 	err := binary.Write(stream, binary.BigEndian, uint64(bloomFilter.size))
 	if err != nil {
 		return 0, err
 	}
-	err = binary.Write(stream, binary.BigEndian, uint64(bloomFilter.numHashes))
+	err = binary.Write(stream, binary.BigEndian, uint64(bloomFilter.numHashes)|uint64(bloomFilter.hashing)<<hashingShift)
 	if err != nil {
 		return 0, err
 	}
 	numBytes, err := bloomFilter.filter.writeTo(stream)
-	return numBytes + int64(2*binary.Size(uint64(0))), err
+	if err != nil {
+		return 0, err
+	}
+	err = checksum.writeTrailer()
+	if err != nil {
+		return 0, err
+	}
+	return numBytes + int64(2*binary.Size(uint64(0))+checksumTrailerSize), nil
 }
 
 // ReadFrom reads the BloomFilter from the specified _stream_ and returns the
 // number of bytes read.
 // It can be used to read from disk (using a file stream) or from network.
-// It's not implemented for Redis backed Bloom filter (BitSetRedis) as data for
-// a Redis backed Bloom Filter is already there in Redis. NewRedisBloomFilterFromKey
-// method can be used to import or create a BloomFilter instead
+// If the BloomFilter is Redis backed, the bitset in Redis is replaced with the one read
+// from the _stream_ and the metadata is updated, otherwise an in-memory bitset is created.
+// A stream cut short or which doesn't match its checksum errors out with an
+// ErrCorruptSnapshot, after the bitset is replaced if it's Redis backed.
 func (bloomFilter *BloomFilter) ReadFrom(stream io.Reader) (int64, error) {
+	decompressed, err := decompressStream(stream)
+	if err != nil {
+		return 0, err
+	}
+	defer decompressed.Close()
+	checksum := newChecksumReader(decompressed)
+	stream = checksum
 	var size, numHashes uint64
-	err := binary.Read(stream, binary.BigEndian, &size)
+	err = binary.Read(stream, binary.BigEndian, &size)
 	if err != nil {
 		return 0, err
 	}
@@ -308,23 +888,185 @@ func (bloomFilter *BloomFilter) ReadFrom(stream io.Reader) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	bitSet := &BitSetMem{}
+	hashing := BloomHashing(numHashes >> hashingShift)
+	numHashes &= 1<<hashingShift - 1
+	err = checkBloomFilterParams(size, numHashes)
+	if err != nil {
+		return 0, err
+	}
+	err = hashing.validate()
+	if err != nil {
+		return 0, err
+	}
+	defer bloomFilter.notifyFillWatchers(0)
+	if bloomFilter.needsLock() {
+		bloomFilter.lock.Lock()
+		defer bloomFilter.lock.Unlock()
+	}
+	var bitSet IBitSet = &BitSetMem{}
+	if !isBitSetMem(bloomFilter.filter) && bloomFilter.filter != nil {
+		bitSet = bloomFilter.filter
+	}
 	numBytes, err := bitSet.readFrom(stream)
 	if err != nil {
 		return 0, err
 	}
+	trailer, err := checksum.verify()
+	if err != nil {
+		return 0, err
+	}
 	bloomFilter.size = uint(size)
 	bloomFilter.numHashes = uint(numHashes)
+	bloomFilter.hashing = hashing
 	bloomFilter.filter = bitSet
-	return numBytes + int64(2*binary.Size(uint64(0))), nil
+	if !isBitSetMem(bitSet) && bloomFilter.metadataKey != "" {
+		metadata := make(map[string]interface{})
+		metadata["size"] = size
+		metadata["numHashes"] = numHashes
+		metadata["hashing"] = uint8(hashing)
+		switch bitSet := bitSet.(type) {
+		case *BitSetRedis:
+			metadata["bitsetKey"] = bitSet.getKey()
+		case *ShardedBitSetRedis:
+			metadata["bitsetKeys"] = strings.Join(bitSet.getKeys(), ",")
+		}
+		err = saveMetadata(bloomFilter.metadataKey, "bloom", metadata)
+		if err != nil {
+			return 0, fmt.Errorf("gostatix: error while saving metadata in redis, error: %v", err)
+		}
+	}
+	return numBytes + int64(2*binary.Size(uint64(0))) + trailer, nil
+}
+
+// checkBloomFilterParams validates the _size_ and _numHashes_ of a decoded snapshot
+func checkBloomFilterParams(size, numHashes uint64) error {
+	if size == 0 || numHashes == 0 {
+		return fmt.Errorf("gostatix: invalid bloom filter snapshot, size %d and number of hashes %d should be greater than 0", size, numHashes)
+	}
+	if numHashes > size {
+		return fmt.Errorf("gostatix: invalid bloom filter snapshot, number of hashes %d is greater than size %d", numHashes, size)
+	}
+	return checkSnapshotSize("bloom filter", size/8, 1)
 }
 
-func getHashes(data []byte) [2]uint64 {
+func getHashes(data []byte) [4]uint64 {
 	hash1, hash2 := metro.Hash128(data, 1373)
-	return [2]uint64{hash1, hash2}
+	return [4]uint64{hash1, hash2}
+}
+
+// getHashes returns the hashes of _data_ from which the bit indexes are derived, as per
+// the hashing scheme of the bloom filter. Only bits-and-blooms hashing uses four hashes,
+// the other schemes use the first two.
+func (bloomFilter *BloomFilter) getHashes(data []byte) [4]uint64 {
+	switch bloomFilter.hashing {
+	case RedisBloomHashing:
+		return getRedisBloomHashes(data)
+	case BitsAndBloomsHashing:
+		return sum256(data)
+	default:
+		return getHashes(data)
+	}
+}
+
+// getIndex returns the index of the bit of the _i_ th hash function using enhanced double
+// hashing, h1 + i * h2 + (i^3 - i) / 6 modulo the size. The arithmetic is done on integers
+// so that it's exact for filters larger than 2^53 bits and doesn't allocate. The indexes of
+// the filters smaller than 2^53 bits are the ones of the former float64 arithmetic, so the
+// bits set by older versions are still found and the snapshots don't need a version.
+// Filters using RedisBloom hashing use plain double hashing, h1 + i * h2 modulo the size,
+// the ones using bits-and-blooms hashing alternate between the four hashes like
+// github.com/bits-and-blooms/bloom, and the ones using blocked hashing derive all the
+// indexes of an element within a single block, see blockedIndex.
+func (bloomFilter *BloomFilter) getIndex(hashes [4]uint64, i uint) uint {
+	j := uint64(i)
+	switch bloomFilter.hashing {
+	case RedisBloomHashing:
+		return uint((hashes[0] + j*hashes[1]) % uint64(bloomFilter.size))
+	case BitsAndBloomsHashing:
+		return uint((hashes[j%2] + j*hashes[2+((j+j%2)%4)/2]) % uint64(bloomFilter.size))
+	case BlockedHashing:
+		return blockedIndex(hashes, i, bloomFilter.size)
+	default:
+		return doubleHashIndex(hashes, i, bloomFilter.size)
+	}
 }
 
-func (bloomFilter *BloomFilter) getIndex(hashes [2]uint64, i uint) uint {
+// doubleHashIndex returns the index of the _i_ th hash function in a filter of _size_
+// positions using enhanced double hashing of the first two _hashes_
+func doubleHashIndex(hashes [4]uint64, i, size uint) uint {
 	j := uint64(i)
-	return uint(math.Abs(float64((hashes[0] + j*hashes[1] + uint64(math.Floor(float64(math.Pow(float64(j), 3)-float64(j))/6))) % uint64(bloomFilter.size))))
+	return uint((hashes[0] + j*hashes[1] + (j*j*j-j)/6) % uint64(size))
+}
+
+// blockBits is the number of bits of the blocks of the filters using blocked hashing, the
+// size of a 64-byte cache line
+const blockBits = 512
+
+// blockedIndex returns the index of the _i_ th hash function in a filter of _size_
+// positions using blocked hashing: the first hash picks the block of 512 bits of the
+// element, the last block being shorter if the size isn't a multiple of 512, and the bit
+// within the block is derived from the second hash and its rotation with enhanced double
+// hashing. All the bits of an element are then in the same cache line of an in-memory
+// bitset.
+func blockedIndex(hashes [4]uint64, i, size uint) uint {
+	numBlocks := (uint64(size) + blockBits - 1) / blockBits
+	// the block is the high word of hash * numBlocks, which is uniform like the hash
+	// modulo numBlocks without a division
+	block, _ := bits.Mul64(hashes[0], numBlocks)
+	start := block * blockBits
+	j := uint64(i)
+	offset := hashes[1] + j*bits.RotateLeft64(hashes[1], 32) + (j*j*j-j)/6
+	if width := uint64(size) - start; width < blockBits {
+		return uint(start + offset%width)
+	}
+	return uint(start + offset%blockBits)
+}
+
+// BloomHashing is the scheme used by a bloom filter to derive the bit indexes of an element
+type BloomHashing uint8
+
+const (
+	// MetroHashing hashes the elements with metro Hash128 and derives the indexes with
+	// enhanced double hashing. It's the default scheme.
+	MetroHashing BloomHashing = iota
+	// RedisBloomHashing hashes the elements with MurmurHash64A and derives the indexes
+	// with double hashing like RedisBloom, so that filters can be moved between the two
+	RedisBloomHashing
+	// BitsAndBloomsHashing hashes the elements with murmur3 like github.com/bits-and-blooms/bloom,
+	// so that the filters serialized by it can be loaded
+	BitsAndBloomsHashing
+	// BlockedHashing hashes the elements with metro Hash128 and sets all the bits of an
+	// element within a block of 512 bits, the size of a cache line, so that an in-memory
+	// lookup reads a single cache line instead of one per hash. The bits are less evenly
+	// spread, so the false positive rate is a bit higher than the one of the other schemes
+	// for the same size, e.g. about 0.012 instead of 0.01 and 0.0018 instead of 0.001.
+	BlockedHashing
+)
+
+// hashingShift is the position of the hashing scheme in the number of hashes written by
+// WriteTo
+const hashingShift = 56
+
+// String returns the name of the hashing scheme
+func (hashing BloomHashing) String() string {
+	switch hashing {
+	case MetroHashing:
+		return "metro"
+	case RedisBloomHashing:
+		return "redisbloom"
+	case BitsAndBloomsHashing:
+		return "bits-and-blooms"
+	case BlockedHashing:
+		return "blocked"
+	default:
+		return fmt.Sprintf("BloomHashing(%d)", uint8(hashing))
+	}
+}
+
+// validate checks that the hashing scheme of a decoded snapshot is known
+func (hashing BloomHashing) validate() error {
+	if hashing > BlockedHashing {
+		return fmt.Errorf("gostatix: invalid bloom filter snapshot, unknown hashing scheme %d", uint8(hashing))
+	}
+	return nil
 }
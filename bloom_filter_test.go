@@ -3,8 +3,12 @@ package gostatix
 import (
 	"bytes"
 	"encoding/binary"
+	"math"
+	"math/bits"
 	"math/rand"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 )
 
@@ -56,12 +60,19 @@ func TestFilterWithBitSetRedis(t *testing.T) {
 
 func TestFilterZeroSizes(t *testing.T) {
 	bitset := newBitSetMem(0)
-	filter, _ := NewBloomFilterWithBitSet(0, 0, bitset, "")
-	if filter.GetCap() != 1 {
-		t.Errorf("size: %v should be 1", filter.GetCap())
+	_, err := NewBloomFilterWithBitSet(0, 0, bitset, "")
+	if err == nil {
+		t.Error("should error out as size and number of hashes are 0")
+	}
+	_, err = NewMemBloomFilterWithParameters(0, 0.01)
+	if err == nil {
+		t.Error("should error out as number of items is 0")
 	}
-	if filter.GetNumHashes() != 1 {
-		t.Errorf("numHash: %v should be 1", filter.GetNumHashes())
+	for _, errorRate := range []float64{0, 1, 1.5, -0.1, math.NaN()} {
+		_, err = NewMemBloomFilterWithParameters(100, errorRate)
+		if err == nil {
+			t.Errorf("should error out as error rate %v isn't between 0 and 1", errorRate)
+		}
 	}
 }
 
@@ -375,9 +386,67 @@ func BenchmarkBloomLookup10kX001X1k(b *testing.B) {
 	}
 }
 
+// BenchmarkBloomLookupBlocked50MX001 measures the lookups of present elements, which read
+// all their bits, in a filter larger than the CPU caches with BlockedHashing
+func BenchmarkBloomLookupBlocked50MX001(b *testing.B) {
+	benchmarkBloomLookupLarge(b, BlockedHashing)
+}
+
+// BenchmarkBloomLookup50MX001 is BenchmarkBloomLookupBlocked50MX001 with the default hashing
+func BenchmarkBloomLookup50MX001(b *testing.B) {
+	benchmarkBloomLookupLarge(b, MetroHashing)
+}
+
+func benchmarkBloomLookupLarge(b *testing.B, hashing BloomHashing) {
+	filter, _ := NewBloomFilter(WithCapacity(50000000, 0.001), WithHashing(hashing))
+	for i := uint64(0); i < 1000000; i++ {
+		filter.InsertUint64(i * 0x9e3779b97f4a7c15)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		filter.LookupUint64(uint64(i%1000000) * 0x9e3779b97f4a7c15)
+	}
+}
+
+// BenchmarkBloomLookupParallel10kX001X1k measures concurrent lookups, which only take the
+// read lock of the filter and scale with the number of readers
+func BenchmarkBloomLookupParallel10kX001X1k(b *testing.B) {
+	filter, _ := NewMemBloomFilterWithParameters(10000, 0.001)
+	for i := 0; i < 1000; i++ {
+		filter.Insert([]byte(strconv.FormatUint(rand.Uint64(), 10)))
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			filter.Lookup([]byte(strconv.FormatUint(rand.Uint64(), 10)))
+		}
+	})
+}
+
+// BenchmarkBloomReadHeavyParallel10kX001 measures concurrent lookups with an insert every
+// 100 operations
+func BenchmarkBloomReadHeavyParallel10kX001(b *testing.B) {
+	filter, _ := NewMemBloomFilterWithParameters(10000, 0.001)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			data := []byte(strconv.FormatUint(rand.Uint64(), 10))
+			if i%100 == 0 {
+				filter.Insert(data)
+			} else {
+				filter.Lookup(data)
+			}
+		}
+	})
+}
+
 func BenchmarkBloomLookup10BX001X100k(b *testing.B) {
+	if bits.UintSize < 64 {
+		b.Skip("bloom filters of 10B elements need 64-bit sizes")
+	}
 	b.StopTimer()
-	filter, _ := NewMemBloomFilterWithParameters(10*1000*1000*1000, 0.001)
+	numItems := uint64(10 * 1000 * 1000 * 1000)
+	filter, _ := NewMemBloomFilterWithParameters(uint(numItems), 0.001)
 	for i := 0; i < 100000; i++ {
 		filter.Insert([]byte(strconv.FormatUint(rand.Uint64(), 10)))
 	}
@@ -425,3 +494,312 @@ func BenchmarkRedisBloomLookup10BX001X100k(b *testing.B) {
 		filter.Lookup([]byte(strconv.FormatUint(rand.Uint64(), 10)))
 	}
 }
+
+func TestBloomInsertFromReader(t *testing.T) {
+	filter, _ := NewMemBloomFilterWithParameters(1000, 0.001)
+	var reported []uint64
+	count, err := filter.InsertFromReader(strings.NewReader("cat\r\ndog\n\nlion"), '\n', func(processed uint64) {
+		reported = append(reported, processed)
+	})
+	if err != nil {
+		t.Fatalf("insert from reader shouldn't error out, error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("count should be 3, found %d", count)
+	}
+	if len(reported) != 1 || reported[0] != 3 {
+		t.Errorf("progress should be reported once with 3, found %v", reported)
+	}
+	for _, key := range []string{"cat", "dog", "lion"} {
+		if !filter.Lookup([]byte(key)) {
+			t.Errorf("%s should be present in the filter", key)
+		}
+	}
+	if filter.Lookup([]byte("cat\r")) {
+		t.Error("carriage return should be stripped from the keys")
+	}
+}
+
+func TestBloomRedisBinaryReadWrite(t *testing.T) {
+	initMockRedis()
+	aFilter, _ := NewRedisBloomFilterWithParameters(1000, 0.001)
+	for i := 0; i < 100; i++ {
+		aFilter.InsertString(strconv.Itoa(i))
+	}
+	var buff bytes.Buffer
+	_, err := aFilter.WriteTo(&buff)
+	if err != nil {
+		t.Fatalf("redis backed filter should be written to stream, error: %v", err)
+	}
+	data := buff.Bytes()
+
+	memFilter := &BloomFilter{}
+	_, err = memFilter.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("stream should be read into an in-memory filter, error: %v", err)
+	}
+	redisFilter, _ := NewRedisBloomFilterWithParameters(10, 0.1)
+	_, err = redisFilter.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("stream should be read into a redis backed filter, error: %v", err)
+	}
+	if isBitSetMem(redisFilter.filter) {
+		t.Error("filter should stay redis backed after ReadFrom")
+	}
+	for i := 0; i < 100; i++ {
+		if !memFilter.LookupString(strconv.Itoa(i)) || !redisFilter.LookupString(strconv.Itoa(i)) {
+			t.Errorf("%d should be present in the filters read from stream", i)
+		}
+	}
+	loaded, _ := NewRedisBloomFilterFromKey(redisFilter.GetMetadataKey())
+	if loaded.GetCap() != aFilter.GetCap() || loaded.GetNumHashes() != aFilter.GetNumHashes() {
+		t.Error("metadata in redis should be updated after ReadFrom")
+	}
+}
+
+func BenchmarkBloomInsertLookupKeys10kX001(b *testing.B) {
+	filter, _ := NewMemBloomFilterWithParameters(10000, 0.001)
+	keys := make([][]byte, 1024)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := keys[i%len(keys)]
+		filter.Insert(key)
+		filter.Lookup(key)
+	}
+}
+
+func BenchmarkBloomGetIndex(b *testing.B) {
+	filter, _ := NewMemBloomFilterWithParameters(10000, 0.001)
+	hashes := getHashes([]byte("foo"))
+	var sum uint
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sum += filter.getIndex(hashes, uint(i%16))
+	}
+	if sum == 0 {
+		b.Log(sum)
+	}
+}
+
+func TestBloomGetIndex(t *testing.T) {
+	filter := &BloomFilter{size: 1000003}
+	for i := 0; i < 100; i++ {
+		hashes := getHashes([]byte(strconv.Itoa(i)))
+		for j := uint(0); j < 20; j++ {
+			// the indices of the filters smaller than 2^53 bits are the ones of the float64 arithmetic
+			k := uint64(j)
+			expected := uint(math.Abs(float64((hashes[0] + k*hashes[1] + uint64(math.Floor(float64(math.Pow(float64(k), 3)-float64(k))/6))) % uint64(filter.size))))
+			if index := filter.getIndex(hashes, j); index != expected {
+				t.Errorf("index %d of %d should be %d, got %d", j, i, expected, index)
+			}
+		}
+	}
+	if bits.UintSize == 64 {
+		size := uint64(1<<60 + 1)
+		filter.size = uint(size)
+		hashes := [4]uint64{1<<60 - 1, 0}
+		if index := filter.getIndex(hashes, 0); uint64(index) != 1<<60-1 {
+			t.Errorf("index should be exact for filters larger than 2^53 bits, got %d", index)
+		}
+	}
+}
+
+func TestBloomFilterMerge(t *testing.T) {
+	aFilter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	bFilter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	aFilter.InsertString("alice")
+	bFilter.InsertString("bob")
+	err := aFilter.Merge(bFilter)
+	if err != nil {
+		t.Fatalf("merge shouldn't error out, error: %v", err)
+	}
+	if !aFilter.LookupString("alice") || !aFilter.LookupString("bob") {
+		t.Error("merged filter should contain the elements of both filters")
+	}
+	if bFilter.LookupString("alice") {
+		t.Error("merged filter shouldn't be modified")
+	}
+	cFilter, _ := NewMemBloomFilterWithParameters(1000, 0.001)
+	if err := aFilter.Merge(cFilter); err == nil {
+		t.Error("merge of filters with different sizes should error out")
+	}
+}
+
+func TestBloomFilterMergeRedis(t *testing.T) {
+	initMockRedis()
+	aFilter, _ := NewRedisBloomFilterWithParameters(1000, 0.01)
+	bFilter, _ := NewRedisBloomFilterWithParameters(1000, 0.01)
+	aFilter.InsertString("alice")
+	bFilter.InsertString("bob")
+	err := aFilter.Merge(bFilter)
+	if err != nil {
+		t.Fatalf("merge shouldn't error out, error: %v", err)
+	}
+	if !aFilter.LookupString("alice") || !aFilter.LookupString("bob") {
+		t.Error("merged filter should contain the elements of both filters")
+	}
+
+	aSharded, _ := NewRedisBloomFilterWithShards(1000, 0.01, 3)
+	bSharded, _ := NewRedisBloomFilterWithShards(1000, 0.01, 3)
+	aSharded.InsertString("alice")
+	bSharded.InsertString("bob")
+	err = aSharded.Merge(bSharded)
+	if err != nil {
+		t.Fatalf("merge of sharded filters shouldn't error out, error: %v", err)
+	}
+	if !aSharded.LookupString("alice") || !aSharded.LookupString("bob") {
+		t.Error("merged sharded filter should contain the elements of both filters")
+	}
+
+	memFilter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	if err := aFilter.Merge(memFilter); err == nil {
+		t.Error("merge of a redis and an in-memory filter should error out")
+	}
+}
+
+func TestBloomFilterReset(t *testing.T) {
+	initMockRedis()
+	memFilter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	redisFilter, _ := NewRedisBloomFilterWithParameters(1000, 0.01)
+	shardedFilter, _ := NewRedisBloomFilterWithShards(1000, 0.01, 3)
+	for _, filter := range []*BloomFilter{memFilter, redisFilter, shardedFilter} {
+		filter.InsertString("alice")
+		filter.InsertString("bob")
+		size, numHashes := filter.GetCap(), filter.GetNumHashes()
+		err := filter.Reset()
+		if err != nil {
+			t.Fatalf("reset shouldn't error out, error: %v", err)
+		}
+		if filter.LookupString("alice") || filter.LookupString("bob") || filter.FillRatio() != 0 {
+			t.Error("filter should be empty after reset")
+		}
+		if filter.GetCap() != size || filter.GetNumHashes() != numHashes {
+			t.Error("parameters of the filter should be kept after reset")
+		}
+		filter.InsertString("carol")
+		if !filter.LookupString("carol") {
+			t.Error("carol should be inserted after reset")
+		}
+	}
+	loaded, err := NewRedisBloomFilterFromKey(shardedFilter.GetMetadataKey())
+	if err != nil {
+		t.Fatalf("filter should be loaded from key after reset, error: %v", err)
+	}
+	if !loaded.LookupString("carol") || loaded.LookupString("alice") {
+		t.Error("loaded filter should only contain carol")
+	}
+}
+
+func TestBloomFilterAddIfNotExists(t *testing.T) {
+	initMockRedis()
+	memFilter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	redisFilter, _ := NewRedisBloomFilterWithParameters(1000, 0.01)
+	shardedFilter, _ := NewRedisBloomFilterWithShards(1000, 0.01, 3)
+	for _, filter := range []*BloomFilter{memFilter, redisFilter, shardedFilter} {
+		added, err := filter.AddIfNotExists([]byte("foo"))
+		if err != nil || !added {
+			t.Errorf("foo should be added, error: %v", err)
+		}
+		added, err = filter.AddIfNotExists([]byte("foo"))
+		if err != nil || added {
+			t.Errorf("foo shouldn't be added twice, error: %v", err)
+		}
+		if !filter.LookupString("foo") {
+			t.Error("foo should be found after being added")
+		}
+	}
+}
+
+func TestBloomFilterRedisAddIfNotExistsConcurrent(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewRedisBloomFilterWithParameters(1000, 0.01)
+	var added int32
+	done := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		go func() {
+			if ok, _ := filter.AddIfNotExists([]byte("foo")); ok {
+				atomic.AddInt32(&added, 1)
+			}
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+	if added != 1 {
+		t.Errorf("foo should be added once by the concurrent calls, added %d times", added)
+	}
+}
+
+func TestBloomFilterLookupMulti(t *testing.T) {
+	initMockRedis()
+	memFilter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	redisFilter, _ := NewRedisBloomFilterWithParameters(1000, 0.01)
+	shardedFilter, _ := NewRedisBloomFilterWithShards(1000, 0.01, 3)
+	data := [][]byte{[]byte("foo"), []byte("bar"), []byte("baz")}
+	for _, filter := range []*BloomFilter{memFilter, redisFilter, shardedFilter} {
+		filter.InsertString("foo").InsertString("baz")
+		results := filter.LookupMulti(data)
+		if len(results) != 3 || !results[0] || results[1] || !results[2] {
+			t.Errorf("lookup of foo, bar and baz should be [true false true], found %v", results)
+		}
+		if len(filter.LookupMulti(nil)) != 0 {
+			t.Error("lookup of no elements should return no results")
+		}
+	}
+}
+
+func TestBloomFilterBlockedHashing(t *testing.T) {
+	filter, err := NewBloomFilter(WithCapacity(10000, 0.01), WithHashing(BlockedHashing))
+	if err != nil {
+		t.Fatalf("blocked filter shouldn't error out, error: %v", err)
+	}
+	if filter.GetCap()%blockBits != 0 {
+		t.Errorf("size %d should be whole blocks", filter.GetCap())
+	}
+	for i := 0; i < 10000; i++ {
+		filter.InsertString(strconv.Itoa(i))
+	}
+	for i := 0; i < 10000; i++ {
+		if !filter.LookupString(strconv.Itoa(i)) {
+			t.Fatalf("%d should be found", i)
+		}
+	}
+	positives := 0
+	for i := 0; i < 100000; i++ {
+		if filter.LookupString("absent" + strconv.Itoa(i)) {
+			positives++
+		}
+	}
+	if rate := float64(positives) / 100000; rate > 0.02 {
+		t.Errorf("false positive rate %v should be close to 0.01", rate)
+	}
+	hashes := filter.getHashes([]byte("foo"))
+	block := filter.getIndex(hashes, 0) / blockBits
+	for i := uint(1); i < filter.GetNumHashes(); i++ {
+		if index := filter.getIndex(hashes, i); index/blockBits != block {
+			t.Errorf("index %d of hash %d should be in block %d", index, i, block)
+		}
+	}
+	// the last block is shorter if the size isn't a multiple of the block size
+	for i := uint(0); i < 20; i++ {
+		if index := blockedIndex([4]uint64{math.MaxUint64, uint64(i) * 7919}, i, 700); index < blockBits || index >= 700 {
+			t.Errorf("index %d should be in the last block of 188 bits", index)
+		}
+	}
+
+	var buf bytes.Buffer
+	filter.WriteTo(&buf)
+	read, _ := NewMemBloomFilterWithParameters(10, 0.1)
+	if _, err := read.ReadFrom(&buf); err != nil || read.Hashing() != BlockedHashing {
+		t.Fatalf("blocked hashing should be read back, error: %v", err)
+	}
+	if !read.LookupString("42") {
+		t.Errorf("42 should be found in the filter read back")
+	}
+}
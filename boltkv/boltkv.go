@@ -0,0 +1,110 @@
+/*
+Package boltkv implements gostatix.KVStore on top of a bbolt database
+(https://github.com/etcd-io/bbolt), so that CuckooFilterKV and CountMinSketchKV persist
+their state in a file. It's a separate module so that gostatix doesn't depend on bbolt.
+
+All the keys of the data structures are kept in a single bucket of the database, each
+operation of a data structure running in a single bbolt transaction.
+*/
+package boltkv
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kwertop/gostatix"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultBucket is the bucket holding the keys of the data structures when none is given
+var DefaultBucket = []byte("gostatix")
+
+// Store is a gostatix.KVStore backed by the bucket _bucket_ of the bbolt database _db_
+type Store struct {
+	db     *bolt.DB
+	bucket []byte
+	owned  bool
+}
+
+var _ gostatix.KVStore = (*Store)(nil)
+
+// Open opens the bbolt database at _path_, creating it with the permissions _mode_ if it
+// doesn't exist, and returns a Store keeping the data structures in DefaultBucket.
+// _options_ are passed to bbolt, nil using its defaults. Close closes the database.
+func Open(path string, mode os.FileMode, options *bolt.Options) (*Store, error) {
+	db, err := bolt.Open(path, mode, options)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while opening bbolt database %s, error: %v", path, err)
+	}
+	store, err := New(db, DefaultBucket)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	store.owned = true
+	return store, nil
+}
+
+// New returns a Store keeping the data structures in the bucket _bucket_ of the opened
+// database _db_, creating the bucket if it doesn't exist. The database stays owned by the
+// caller, Close doesn't close it.
+func New(db *bolt.DB, bucket []byte) (*Store, error) {
+	if db == nil || len(bucket) == 0 {
+		return nil, fmt.Errorf("gostatix: a database and a bucket name are required for a bbolt store")
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while creating bbolt bucket %s, error: %v", bucket, err)
+	}
+	return &Store{db: db, bucket: append([]byte(nil), bucket...)}, nil
+}
+
+// DB returns the bbolt database of the Store
+func (store *Store) DB() *bolt.DB {
+	return store.db
+}
+
+// View runs _fn_ in a read-only bbolt transaction
+func (store *Store) View(fn func(tx gostatix.KVTx) error) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		return fn(storeTx{tx.Bucket(store.bucket)})
+	})
+}
+
+// Update runs _fn_ in a read-write bbolt transaction, which is committed if _fn_ returns
+// nil and rolled back otherwise
+func (store *Store) Update(fn func(tx gostatix.KVTx) error) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return fn(storeTx{tx.Bucket(store.bucket)})
+	})
+}
+
+// Close closes the database if it was opened by Open, it's a no-op otherwise
+func (store *Store) Close() error {
+	if !store.owned {
+		return nil
+	}
+	return store.db.Close()
+}
+
+// storeTx is a gostatix.KVTx on the bucket of a Store in a bbolt transaction
+type storeTx struct {
+	bucket *bolt.Bucket
+}
+
+func (tx storeTx) Get(key []byte) ([]byte, error) {
+	return tx.bucket.Get(key), nil
+}
+
+// Set copies _value_, as bbolt requires the values to stay unchanged until the
+// transaction is committed
+func (tx storeTx) Set(key, value []byte) error {
+	return tx.bucket.Put(key, append([]byte{}, value...))
+}
+
+func (tx storeTx) Delete(key []byte) error {
+	return tx.bucket.Delete(key)
+}
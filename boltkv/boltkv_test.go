@@ -0,0 +1,80 @@
+package boltkv
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/kwertop/gostatix"
+)
+
+func TestStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sketches.db")
+	store, err := Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("opening the store shouldn't error out, error: %v", err)
+	}
+	filter, _ := gostatix.NewCuckooFilterKV(store, "seen", 100, 4, 5)
+	cms, _ := gostatix.NewCountMinSketchKV(store, "hits", 4, 100)
+	for i := 0; i < 50; i++ {
+		if err := filter.InsertString(strconv.Itoa(i), false); err != nil {
+			t.Fatalf("inserting %d shouldn't error out, error: %v", i, err)
+		}
+		if err := cms.UpdateString("page", 2); err != nil {
+			t.Fatalf("updating the sketch shouldn't error out, error: %v", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("closing the store shouldn't error out, error: %v", err)
+	}
+
+	store, err = Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("reopening the store shouldn't error out, error: %v", err)
+	}
+	defer store.Close()
+	filter, err = gostatix.NewCuckooFilterKV(store, "seen", 100, 4, 5)
+	if err != nil {
+		t.Fatalf("reopening the filter shouldn't error out, error: %v", err)
+	}
+	if length, _ := filter.Length(); length != 50 {
+		t.Errorf("length of the reopened filter should be 50, got %d", length)
+	}
+	for i := 0; i < 50; i++ {
+		if ok, _ := filter.LookupString(strconv.Itoa(i)); !ok {
+			t.Errorf("%d should be found in the reopened filter", i)
+		}
+	}
+	cms, err = gostatix.NewCountMinSketchKV(store, "hits", 4, 100)
+	if err != nil {
+		t.Fatalf("reopening the sketch shouldn't error out, error: %v", err)
+	}
+	if count, _ := cms.CountString("page"); count != 100 {
+		t.Errorf("count of page in the reopened sketch should be 100, got %d", count)
+	}
+}
+
+func TestStoreRollback(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "store.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("opening the store shouldn't error out, error: %v", err)
+	}
+	defer store.Close()
+	err = store.Update(func(tx gostatix.KVTx) error {
+		tx.Set([]byte("key"), []byte("value"))
+		return fmt.Errorf("failed")
+	})
+	if err == nil {
+		t.Errorf("update should return the error of the transaction")
+	}
+	store.View(func(tx gostatix.KVTx) error {
+		if value, _ := tx.Get([]byte("key")); value != nil {
+			t.Errorf("writes of a failed transaction should be rolled back, got %s", value)
+		}
+		if err := tx.Set([]byte("key"), []byte("value")); err == nil {
+			t.Errorf("set shouldn't be allowed in a read-only transaction")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,19 @@
+module github.com/kwertop/gostatix/boltkv
+
+go 1.19
+
+require (
+	github.com/kwertop/gostatix v0.0.0
+	go.etcd.io/bbolt v1.3.7
+)
+
+require (
+	github.com/bits-and-blooms/bitset v1.8.0 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-metro v0.0.0-20211217172704-adc40b04c140 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/redis/go-redis/v9 v9.0.5 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+)
+
+replace github.com/kwertop/gostatix => ../
@@ -151,28 +151,44 @@ func (bucket *BucketMem) readFrom(stream io.Reader) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	bucket.size = size
-	bucket.length = length
-	bucket.elements = make([]string, size)
+	if length > size {
+		return 0, fmt.Errorf("gostatix: bucket length %d is greater than its size %d", length, size)
+	}
+	err = checkSnapshotSize("bucket", size, stringSize)
+	if err != nil {
+		return 0, err
+	}
+	// elements are appended as they are read so that a truncated stream
+	// doesn't allocate the whole bucket up front
+	var elements []string
 	numBytes := 0
-	for i := uint64(0); i < length; i++ {
+	nonEmpty := uint64(0)
+	for i := uint64(0); i < size; i++ {
 		var strLen uint64
 		err := binary.Read(stream, binary.BigEndian, &strLen)
 		if err != nil {
 			return 0, err
 		}
-		b := make([]byte, strLen)
-		bytes, err := io.ReadFull(stream, b)
+		b, err := readBytes(stream, strLen)
 		if err != nil {
 			return 0, err
 		}
-		numBytes += bytes
-		bucket.elements[i] = string(b)
+		numBytes += len(b)
+		if len(b) > 0 {
+			nonEmpty++
+		}
+		elements = append(elements, string(b))
+	}
+	if nonEmpty != length {
+		return 0, fmt.Errorf("gostatix: bucket length %d doesn't match its %d elements", length, nonEmpty)
 	}
+	bucket.size = size
+	bucket.length = length
+	bucket.elements = elements
 	return int64(numBytes) + int64(2*binary.Size(uint64(0))), nil
 }
 
-func (bucket BucketMem) indexOf(element string) int64 {
+func (bucket *BucketMem) indexOf(element string) int64 {
 	for index, val := range bucket.elements {
 		if val == element {
 			return int64(index)
@@ -0,0 +1,235 @@
+/*
+Implements packed buckets - the fingerprints of all the buckets of an
+in-memory cuckoo filter packed in a single array of words.
+*/
+package gostatix
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+)
+
+// packedBuckets holds the fingerprints of the buckets of an in-memory cuckoo filter
+// as fixed width integers packed in a slice of words, instead of one heap allocated
+// string per fingerprint.
+// _size_ is the number of buckets
+// _bucketSize_ is the number of fingerprints held by each bucket
+// _width_ is the number of bits of each fingerprint
+// _words_ holds the fingerprints, the slot _j_ of the bucket _i_ starts at the bit
+// (i * bucketSize + j) * width. An empty slot is 0.
+// The fingerprints are the decimal prefixes of the hashes and never start with 0, so
+// they are stored as the integer they represent.
+type packedBuckets struct {
+	size       uint64
+	bucketSize uint64
+	width      uint64
+	words      []uint64
+}
+
+// packedEntry is an entry kicked out of its slot while inserting in packedBuckets
+type packedEntry struct {
+	fingerPrint uint64
+	firstIndex  uint64
+	secondIndex uint64
+}
+
+// newPackedBuckets creates _size_ empty buckets of _bucketSize_ fingerprints of
+// _fingerPrintLength_ digits
+func newPackedBuckets(size, bucketSize, fingerPrintLength uint64) *packedBuckets {
+	width := fingerPrintWidth(fingerPrintLength)
+	numWords := (size*bucketSize*width + uint64(wordSize) - 1) / uint64(wordSize)
+	return &packedBuckets{size, bucketSize, width, make([]uint64, numWords)}
+}
+
+// fingerPrintWidth returns the number of bits needed for a fingerprint of
+// _fingerPrintLength_ decimal digits
+func fingerPrintWidth(fingerPrintLength uint64) uint64 {
+	if fingerPrintLength >= maxFingerPrintLength {
+		return 64
+	}
+	max := uint64(1)
+	for i := uint64(0); i < fingerPrintLength; i++ {
+		max *= 10
+	}
+	return uint64(bits.Len64(max - 1))
+}
+
+// packFingerPrint returns the integer of the _fingerPrint_ string. Fingerprints with
+// leading zeroes are invalid as they wouldn't be the same string once unpacked.
+func packFingerPrint(fingerPrint string) (uint64, error) {
+	value, err := strconv.ParseUint(fingerPrint, 10, 64)
+	if err != nil || fingerPrint[0] == '0' {
+		return 0, fmt.Errorf("gostatix: invalid fingerprint %q", fingerPrint)
+	}
+	return value, nil
+}
+
+// unpackFingerPrint returns the fingerprint string of the integer _value_, "" for an empty slot
+func unpackFingerPrint(value uint64) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.FormatUint(value, 10)
+}
+
+// grow appends an empty bucket
+func (buckets *packedBuckets) grow() {
+	buckets.size++
+	numWords := (buckets.size*buckets.bucketSize*buckets.width + uint64(wordSize) - 1) / uint64(wordSize)
+	for uint64(len(buckets.words)) < numWords {
+		buckets.words = append(buckets.words, 0)
+	}
+}
+
+// at returns the fingerprint stored at _slot_ of the bucket at _index_
+func (buckets *packedBuckets) at(index, slot uint64) uint64 {
+	pos := (index*buckets.bucketSize + slot) * buckets.width
+	word, offset := pos/uint64(wordSize), pos%uint64(wordSize)
+	value := buckets.words[word] >> offset
+	if offset+buckets.width > uint64(wordSize) {
+		value |= buckets.words[word+1] << (uint64(wordSize) - offset)
+	}
+	return value & buckets.mask()
+}
+
+// set stores the _fingerPrint_ at _slot_ of the bucket at _index_
+func (buckets *packedBuckets) set(index, slot, fingerPrint uint64) {
+	pos := (index*buckets.bucketSize + slot) * buckets.width
+	word, offset := pos/uint64(wordSize), pos%uint64(wordSize)
+	mask := buckets.mask()
+	fingerPrint &= mask
+	buckets.words[word] = buckets.words[word]&^(mask<<offset) | fingerPrint<<offset
+	if offset+buckets.width > uint64(wordSize) {
+		shift := uint64(wordSize) - offset
+		buckets.words[word+1] = buckets.words[word+1]&^(mask>>shift) | fingerPrint>>shift
+	}
+}
+
+func (buckets *packedBuckets) mask() uint64 {
+	if buckets.width >= uint64(wordSize) {
+		return ^uint64(0)
+	}
+	return 1<<buckets.width - 1
+}
+
+// getLength returns the number of fingerprints in the bucket at _index_
+func (buckets *packedBuckets) getLength(index uint64) uint64 {
+	length := uint64(0)
+	for slot := uint64(0); slot < buckets.bucketSize; slot++ {
+		if buckets.at(index, slot) != 0 {
+			length++
+		}
+	}
+	return length
+}
+
+// isFree returns true if there is room for more fingerprints in the bucket at _index_
+func (buckets *packedBuckets) isFree(index uint64) bool {
+	return buckets.indexOf(index, 0) > -1
+}
+
+// add inserts the _fingerPrint_ in the next available slot of the bucket at _index_
+func (buckets *packedBuckets) add(index, fingerPrint uint64) bool {
+	slot := buckets.indexOf(index, 0)
+	if fingerPrint == 0 || slot <= -1 {
+		return false
+	}
+	buckets.set(index, uint64(slot), fingerPrint)
+	return true
+}
+
+// remove deletes the _fingerPrint_ from the bucket at _index_
+func (buckets *packedBuckets) remove(index, fingerPrint uint64) bool {
+	slot := buckets.indexOf(index, fingerPrint)
+	if slot <= -1 {
+		return false
+	}
+	buckets.set(index, uint64(slot), 0)
+	return true
+}
+
+// lookup returns true if the _fingerPrint_ is present in the bucket at _index_
+func (buckets *packedBuckets) lookup(index, fingerPrint uint64) bool {
+	return fingerPrint != 0 && buckets.indexOf(index, fingerPrint) > -1
+}
+
+// count returns the number of slots of the bucket at _index_ holding _fingerPrint_
+func (buckets *packedBuckets) count(index, fingerPrint uint64) uint64 {
+	if fingerPrint == 0 {
+		return 0
+	}
+	count := uint64(0)
+	for slot := uint64(0); slot < buckets.bucketSize; slot++ {
+		if buckets.at(index, slot) == fingerPrint {
+			count++
+		}
+	}
+	return count
+}
+
+// indexOf returns the first slot of the bucket at _index_ holding _fingerPrint_, -1 if none
+func (buckets *packedBuckets) indexOf(index, fingerPrint uint64) int64 {
+	for slot := uint64(0); slot < buckets.bucketSize; slot++ {
+		if buckets.at(index, slot) == fingerPrint {
+			return int64(slot)
+		}
+	}
+	return -1
+}
+
+// equals checks if the fingerprints of two packedBuckets are the same and in the same slots
+func (buckets *packedBuckets) equals(otherBuckets *packedBuckets) bool {
+	if buckets.size != otherBuckets.size || buckets.bucketSize != otherBuckets.bucketSize ||
+		buckets.width != otherBuckets.width {
+		return false
+	}
+	for i := range buckets.words {
+		if buckets.words[i] != otherBuckets.words[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// toBucketMem returns the bucket at _index_ as a BucketMem, used to marshal the filter
+func (buckets *packedBuckets) toBucketMem(index uint64) *BucketMem {
+	bucket := newBucketMem(buckets.bucketSize)
+	for slot := uint64(0); slot < buckets.bucketSize; slot++ {
+		fingerPrint := buckets.at(index, slot)
+		if fingerPrint != 0 {
+			bucket.set(slot, unpackFingerPrint(fingerPrint))
+			bucket.length++
+		}
+	}
+	return bucket
+}
+
+// fromBucketMem packs the fingerprints of _bucket_ in the bucket at _index_ keeping their slots
+func (buckets *packedBuckets) fromBucketMem(index uint64, bucket *BucketMem) error {
+	for slot, element := range bucket.getElements() {
+		if element == "" {
+			buckets.set(index, uint64(slot), 0)
+			continue
+		}
+		fingerPrint, err := buckets.pack(element)
+		if err != nil {
+			return err
+		}
+		buckets.set(index, uint64(slot), fingerPrint)
+	}
+	return nil
+}
+
+// pack returns the integer of the _fingerPrint_ string, checking that it fits the width
+// of the fingerprints
+func (buckets *packedBuckets) pack(fingerPrint string) (uint64, error) {
+	value, err := packFingerPrint(fingerPrint)
+	if err != nil {
+		return 0, err
+	}
+	if value&^buckets.mask() != 0 {
+		return 0, fmt.Errorf("gostatix: fingerprint %q is longer than the fingerprint length", fingerPrint)
+	}
+	return value, nil
+}
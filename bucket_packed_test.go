@@ -0,0 +1,79 @@
+package gostatix
+
+import "testing"
+
+func TestFingerPrintWidth(t *testing.T) {
+	widths := map[uint64]uint64{1: 4, 2: 7, 3: 10, 19: 64, 20: 64}
+	for length, width := range widths {
+		if fingerPrintWidth(length) != width {
+			t.Errorf("width of fingerprints of %d digits should be %d, got %d", length, width, fingerPrintWidth(length))
+		}
+	}
+}
+
+func TestPackedBucketsSetAt(t *testing.T) {
+	buckets := newPackedBuckets(10, 3, 3)
+	// 10 bits wide fingerprints straddle the words
+	for i := uint64(0); i < 10; i++ {
+		for j := uint64(0); j < 3; j++ {
+			buckets.set(i, j, 100+i*10+j)
+		}
+	}
+	for i := uint64(0); i < 10; i++ {
+		for j := uint64(0); j < 3; j++ {
+			if buckets.at(i, j) != 100+i*10+j {
+				t.Errorf("slot %d of bucket %d should be %d, got %d", j, i, 100+i*10+j, buckets.at(i, j))
+			}
+		}
+	}
+	buckets.remove(6, 161)
+	if buckets.getLength(6) != 2 || !buckets.isFree(6) || buckets.at(6, 1) != 0 {
+		t.Error("slot 1 of bucket 6 should be freed")
+	}
+	if buckets.at(6, 0) != 160 || buckets.at(6, 2) != 162 {
+		t.Error("other slots of bucket 6 shouldn't be modified")
+	}
+	if !buckets.add(6, 999) || buckets.at(6, 1) != 999 || buckets.isFree(6) {
+		t.Error("999 should be added in the free slot of bucket 6")
+	}
+	if buckets.add(6, 998) {
+		t.Error("full bucket shouldn't accept more fingerprints")
+	}
+}
+
+func TestPackedBucketsFullWidth(t *testing.T) {
+	buckets := newPackedBuckets(2, 2, 20)
+	buckets.add(1, 18446744073709551615)
+	if !buckets.lookup(1, 18446744073709551615) || buckets.lookup(0, 18446744073709551615) {
+		t.Error("64 bits wide fingerprint should only be present in bucket 1")
+	}
+}
+
+func TestPackedBucketsPack(t *testing.T) {
+	buckets := newPackedBuckets(1, 1, 2)
+	for _, fingerPrint := range []string{"0", "07", "foo", "-1", "128"} {
+		if _, err := buckets.pack(fingerPrint); err == nil {
+			t.Errorf("fingerprint %q should be invalid", fingerPrint)
+		}
+	}
+	if value, err := buckets.pack("99"); err != nil || value != 99 {
+		t.Errorf("fingerprint 99 should be packed, got %d, error: %v", value, err)
+	}
+	bucket := buckets.toBucketMem(0)
+	if bucket.getLength() != 0 {
+		t.Error("empty bucket shouldn't have elements")
+	}
+	bucket.add("42")
+	buckets.fromBucketMem(0, bucket)
+	if !buckets.lookup(0, 42) || buckets.toBucketMem(0).at(0) != "42" {
+		t.Error("42 should round trip through BucketMem")
+	}
+}
+
+func TestCuckooFilterImportInvalidFingerPrint(t *testing.T) {
+	filter, _ := NewCuckooFilter(1, 2, 3)
+	err := filter.Import([]byte(`{"s":1,"bs":2,"fpl":3,"l":1,"r":500,"b":[{"s":2,"l":1,"e":["foo",""]}]}`))
+	if err == nil {
+		t.Error("cuckoo filter with a non numeric fingerprint should error out")
+	}
+}
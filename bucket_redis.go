@@ -16,7 +16,8 @@ import (
 // BucketRedis is implemented using Redis Lists.
 // _key_ is the redis key to the list which holds the actual values
 // _key_len is used to track the number of non-empty/valied entries in the bucket
-// as a key-value pair in Redis.
+// as a key-value pair in Redis. The scripts checking whether the bucket is full recount
+// its entries and fix _key_len if it drifted from them.
 // Lua scripts are used wherever possible to make the read/write operations from Redis atomic.
 type BucketRedis struct {
 	key string
@@ -40,20 +41,38 @@ func (bucket *BucketRedis) getLength() uint64 {
 	return uint64(val)
 }
 
+// bucketRecountLua defines the Lua function recountBucket, returning the number of
+// non-empty entries of the bucket list at _key_ and saving it at _key_ .. '_len' if the
+// length saved there drifted from it, e.g. after a script failed midway or the keys were
+// modified by hand. It's prepended to the scripts which depend on the length of a bucket.
+const bucketRecountLua = `
+	local function recountBucket(key)
+		local count = 0
+		for _, element in ipairs(redis.call('LRANGE', key, 0, -1)) do
+			if element ~= '' then
+				count = count + 1
+			end
+		end
+		local lenKey = key .. '_len'
+		if tonumber(redis.call('GET', lenKey)) ~= count then
+			redis.call('SET', lenKey, count)
+		end
+		return count
+	end
+`
+
+var bucketIsFreeScript = redis.NewScript(bucketRecountLua + `
+	local size = ARGV[1]
+	if recountBucket(KEYS[1]) >= tonumber(size) then
+		return false
+	end
+	return true
+`)
+
 // IsFree returns true if there is room for more entries in the bucket,
 // otherwise false.
 func (bucket *BucketRedis) isFree() bool {
-	isFreeScript := redis.NewScript(`
-		local key = KEYS[1]
-		local lenKey = key .. '_len'
-		local bucketLength = redis.pcall('GET', lenKey)
-		local size = ARGV[1]
-		if tonumber(bucketLength) >= tonumber(size) then
-			return false
-		end
-		return true
-	`)
-	val, _ := isFreeScript.Run(context.Background(), getRedisClient(), []string{bucket.key}, bucket.size).Bool()
+	val, _ := bucketIsFreeScript.Run(context.Background(), getRedisClient(), []string{bucket.key}, bucket.size).Bool()
 	return val
 }
 
@@ -85,30 +104,30 @@ func (bucket *BucketRedis) at(index uint64) (string, error) {
 	return val, nil
 }
 
+var bucketAddScript = redis.NewScript(bucketRecountLua + `
+	local key = KEYS[1]
+	local lenKey = key .. '_len'
+	local size = ARGV[2]
+	if recountBucket(key) >= tonumber(size) then
+		return false
+	end
+	local element = ARGV[1]
+	local pos = redis.pcall('LPOS', key, '')
+	if pos == false then
+		redis.pcall('LPUSH', key, element)
+	else
+		redis.pcall('LSET', key, tonumber(pos), element)
+	end
+	redis.pcall('INCRBY', lenKey, 1)
+	return true
+`)
+
 // Add inserts the _element_ in the bucket at the next available slot
 func (bucket *BucketRedis) add(element string) (bool, error) {
 	if element == "" {
 		return false, nil
 	}
-	addElement := redis.NewScript(`
-		local key = KEYS[1]
-		local lenKey = key .. '_len'
-		local bucketLength = redis.pcall('GET', lenKey)
-		local size = ARGV[2]
-		if tonumber(bucketLength) >= tonumber(size) then
-			return false
-		end
-		local element = ARGV[1]
-		local pos = redis.pcall('LPOS', key, '')
-		if pos == false then
-			redis.pcall('LPUSH', key, element)
-		else
-			redis.pcall('LSET', key, tonumber(pos), element)
-		end
-		redis.pcall('INCRBY', lenKey, 1)
-		return true
-	`)
-	val, err := addElement.Run(context.Background(), getRedisClient(), []string{bucket.key}, element, bucket.size).Bool()
+	val, err := bucketAddScript.Run(context.Background(), getRedisClient(), []string{bucket.key}, element, bucket.size).Bool()
 	if err != nil {
 		return false, fmt.Errorf("gostatix: error while adding element %s, error: %v", element, err)
 	}
@@ -118,40 +137,63 @@ func (bucket *BucketRedis) add(element string) (bool, error) {
 	return true, nil
 }
 
-// Remove deletes the entry _element_ from the bucket
+// bucketRemoveScript empties the slot of ARGV[1] in the bucket at KEYS[1] and recounts
+// the length of the bucket. It returns 1 if the element was removed, 0 if it's missing.
+var bucketRemoveScript = redis.NewScript(bucketRecountLua + `
+	local key = KEYS[1]
+	local element = ARGV[1]
+	local pos = redis.call('LPOS', key, element)
+	if pos == false then
+		recountBucket(key)
+		return 0
+	end
+	redis.call('LSET', key, pos, '')
+	recountBucket(key)
+	return 1
+`)
+
+// Remove deletes the entry _element_ from the bucket. It returns false if the bucket
+// doesn't hold _element_.
 func (bucket *BucketRedis) remove(element string) (bool, error) {
-	removeElement := redis.NewScript(`
-		local key = KEYS[1]
-		local lenKey = key .. '_len'
-		local element = ARGV[1]
-		local pos = redis.call('LPOS', key, element)
-		redis.call('LSET', key, pos, '')
-		redis.pcall('INCRBY', lenKey, -1)
-		return true
-	`)
-	_, err := removeElement.Run(context.Background(), getRedisClient(), []string{bucket.key}, element).Bool()
+	removed, err := bucketRemoveScript.Run(context.Background(), getRedisClient(), []string{bucket.key}, element).Int64()
 	if err != nil {
 		return false, fmt.Errorf("gostatix: error while removing element %s, error: %v", element, err)
 	}
-	return true, nil
+	return removed == 1, nil
+}
+
+var bucketRecountScript = redis.NewScript(bucketRecountLua + `
+	local previous = tonumber(redis.call('GET', KEYS[1] .. '_len')) or 0
+	return {recountBucket(KEYS[1]), previous}
+`)
+
+// recount recounts the non-empty entries of the bucket and fixes its saved length if it
+// drifted from them. It returns the length of the bucket and true if it was fixed.
+func (bucket *BucketRedis) recount() (uint64, bool, error) {
+	result, err := bucketRecountScript.Run(context.Background(), getRedisClient(), []string{bucket.key}).Int64Slice()
+	if err != nil || len(result) != 2 {
+		return 0, false, fmt.Errorf("gostatix: error while recounting bucket %s, error: %v", bucket.key, err)
+	}
+	return uint64(result[0]), result[0] != result[1], nil
 }
 
+var bucketExistsScript = redis.NewScript(`
+	local key = KEYS[1]
+	local element = ARGV[1]
+	local pos = redis.pcall('LPOS', key, element)
+	if pos == false then
+		return -1
+	end
+	return tonumber(pos)
+`)
+
 // Lookup returns true if the _element_ is present in the bucket, otherwise false
 func (bucket *BucketRedis) lookup(element string) (bool, error) {
 	//Redis returns nil if an element doesn't exist in the list
 	//While Golang Redis LPos command returns 0 for non-existent element inside the list with error set as "redis: nil"
 	//This becomes confusing for the index of the first element in the list and non-existent values
 	//below script handles the ambiguity
-	exists := redis.NewScript(`
-		local key = KEYS[1]
-		local element = ARGV[1]
-		local pos = redis.pcall('LPOS', key, element)
-		if pos == false then
-			return -1
-		end
-		return tonumber(pos)
-	`)
-	pos, err := exists.Run(context.Background(), getRedisClient(), []string{bucket.key}, element).Int64()
+	pos, err := bucketExistsScript.Run(context.Background(), getRedisClient(), []string{bucket.key}, element).Int64()
 	if err != nil {
 		return false, fmt.Errorf("gostatix: error while searching for %s, error: %v", element, err)
 	}
@@ -178,25 +220,30 @@ func (bucket *BucketRedis) unSet(index uint64) error {
 	}
 }
 
+var bucketEqualsScript = redis.NewScript(`
+	local key1 = KEYS[1]
+	local key2 = KEYS[2]
+	local size = ARGV[1]
+	local vals1 = redis.pcall('LRANGE', key1, 0, -1)
+	local vals2 = redis.pcall('LRANGE', key2, 0, -1)
+	for i=1, tonumber(size) do
+		if vals1[i] ~= vals2[i] then
+			return false
+		end
+	end
+	return true
+`)
+
 // Equals checks if two BucketRedis are equal
 func (bucket *BucketRedis) equals(otherBucket *BucketRedis) (bool, error) {
 	if bucket.size != otherBucket.size {
 		return false, nil
 	}
-	equals := redis.NewScript(`
-		local key1 = KEYS[1]
-		local key2 = KEYS[2]
-		local size = ARGV[1]
-		local vals1 = redis.pcall('LRANGE', key1, 0, -1)
-		local vals2 = redis.pcall('LRANGE', key2, 0, -1)
-		for i=1, tonumber(size) do
-			if vals1[i] ~= vals2[i] then
-				return false
-			end
-		end
-		return true
-	`)
-	ok, err := equals.Run(context.Background(), getRedisClient(), []string{bucket.key, otherBucket.key}, bucket.size).Bool()
+	ok, err := bucketEqualsScript.Run(context.Background(), getRedisClient(), []string{bucket.key, otherBucket.key}, bucket.size).Bool()
+	if err == redis.Nil {
+		// lua false is returned as a nil reply
+		return false, nil
+	}
 	if err != nil {
 		return false, fmt.Errorf("gostatix: error while comparing list %s with %s, error: %v", bucket.key, otherBucket.key, err)
 	}
@@ -91,6 +91,31 @@ func TestBucketRedisRemove(t *testing.T) {
 	}
 }
 
+func TestBucketRedisRecount(t *testing.T) {
+	initMockRedis()
+	bucket := newBucketRedis("dkey", 3)
+	initBucket("dkey", 3)
+	bucket.add("foo")
+	bucket.add("bar")
+	getRedisClient().Set(context.Background(), "dkey_len", 3, 0)
+	if !bucket.isFree() || bucket.getLength() != 2 {
+		t.Errorf("bucket should be free once its length is recounted, length %d", bucket.getLength())
+	}
+	getRedisClient().Set(context.Background(), "dkey_len", 0, 0)
+	length, fixed, err := bucket.recount()
+	if err != nil || length != 2 || !fixed {
+		t.Errorf("recount should fix the length to 2, got %d, fixed %v, error: %v", length, fixed, err)
+	}
+	if _, fixed, _ := bucket.recount(); fixed {
+		t.Errorf("recount of a consistent bucket shouldn't fix it")
+	}
+	bucket.add("baz")
+	ok, _ := bucket.add("far")
+	if ok || bucket.getLength() != 3 {
+		t.Errorf("far shouldn't be added as bucket is full, length %d", bucket.getLength())
+	}
+}
+
 func TestBucketRedisEquals(t *testing.T) {
 	initMockRedis()
 	b1 := newBucketRedis("key1", 10)
@@ -0,0 +1,254 @@
+/*
+Implements bundles of named data structures exported and restored together, e.g. the bloom
+filter, the hyperloglog and the top-k describing one dataset, in a single archive with a
+table of contents.
+*/
+package gostatix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// The archive of a bundle starts with a header and its manifest, followed by the exports
+// of the data structures in the order of the manifest. All the integers are big endian.
+//
+//	header    magic "GSTB" (4 bytes), version (1 byte), reserved (3 bytes, 0)
+//	manifest  length (uint64), JSON encoded BundleManifest
+//	entries   the Export of each data structure
+//	trailer   the checksum of the archive, like the streams of the other data structures
+
+// bundleMagic starts every bundle archive
+var bundleMagic = []byte("GSTB")
+
+// bundleVersion is the version of the bundle archive layout
+const bundleVersion = 1
+
+// bundleHeaderSize is the size of the header of a bundle archive
+const bundleHeaderSize = 8
+
+// BundleEntry describes a data structure in the manifest of a bundle archive
+// _Name_ is the name of the data structure in the bundle
+// _Type_ is the type of its Description, e.g. bloom or hll, empty if it isn't a Describer
+// _Offset_ is the position of its export after the manifest and _Length_ its length
+// _Checksum_ is the CRC-32 of its export
+type BundleEntry struct {
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"`
+	Offset   uint64 `json:"offset"`
+	Length   uint64 `json:"length"`
+	Checksum uint32 `json:"crc32"`
+}
+
+// BundleManifest is the table of contents of a bundle archive, returned by
+// ReadBundleManifest
+type BundleManifest struct {
+	Entries []BundleEntry `json:"entries"`
+}
+
+// Bundle groups named data structures which are exported and restored together, in the
+// order in which they were added. It's a Snapshottable itself, so it can be saved by a
+// Snapshotter and restored by Restore like a single data structure.
+// Each data structure is exported under its own lock, so the exports aren't a consistent
+// cut if the data structures are updated while the bundle is exported.
+type Bundle struct {
+	names      []string
+	structures map[string]Snapshottable
+}
+
+var _ Snapshottable = (*Bundle)(nil)
+
+// NewBundle creates an empty Bundle
+func NewBundle() *Bundle {
+	return &Bundle{structures: make(map[string]Snapshottable)}
+}
+
+// Add adds _structure_ to the bundle under _name_, which must be unique in the bundle
+func (b *Bundle) Add(name string, structure Snapshottable) error {
+	if name == "" {
+		return fmt.Errorf("gostatix: bundle entry name can't be empty")
+	}
+	if structure == nil {
+		return fmt.Errorf("gostatix: bundle entry %s has no data structure", name)
+	}
+	if _, ok := b.structures[name]; ok {
+		return fmt.Errorf("gostatix: bundle already has an entry named %s", name)
+	}
+	b.names = append(b.names, name)
+	b.structures[name] = structure
+	return nil
+}
+
+// Names returns the names of the data structures of the bundle, in the order in which
+// they were added
+func (b *Bundle) Names() []string {
+	return append([]string(nil), b.names...)
+}
+
+// Get returns the data structure named _name_, or nil if the bundle has none
+func (b *Bundle) Get(name string) Snapshottable {
+	return b.structures[name]
+}
+
+// WriteTo exports all the data structures of the bundle and writes them onto _stream_ as
+// a single archive, after its manifest. It returns the number of bytes written.
+func (b *Bundle) WriteTo(stream io.Writer) (int64, error) {
+	var manifest BundleManifest
+	exports := make([][]byte, len(b.names))
+	offset := uint64(0)
+	for i, name := range b.names {
+		structure := b.structures[name]
+		data, err := structure.Export()
+		if err != nil {
+			return 0, fmt.Errorf("gostatix: error while exporting bundle entry %s, error: %v", name, err)
+		}
+		entry := BundleEntry{name, "", offset, uint64(len(data)), crc32.ChecksumIEEE(data)}
+		if describer, ok := structure.(Describer); ok {
+			if d, err := describer.Describe(); err == nil {
+				entry.Type = d.Type
+			}
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+		exports[i] = data
+		offset += entry.Length
+	}
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		return 0, err
+	}
+	checksum := newChecksumWriter(stream)
+	header := make([]byte, bundleHeaderSize, bundleHeaderSize+8)
+	copy(header, bundleMagic)
+	header[len(bundleMagic)] = bundleVersion
+	header = binary.BigEndian.AppendUint64(header, uint64(len(encoded)))
+	written := int64(0)
+	for _, data := range append([][]byte{header, encoded}, exports...) {
+		n, err := checksum.Write(data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	err = checksum.writeTrailer()
+	if err != nil {
+		return written, err
+	}
+	return written + checksumTrailerSize, nil
+}
+
+// ReadFrom reads an archive written by WriteTo from _stream_ and imports its entries into
+// the data structures of the bundle with the same names. All the entries are read and
+// checked against their checksums before any data structure is imported, every data
+// structure of the bundle must have an entry and the entries of the archive which aren't
+// in the bundle are skipped. If an import fails, the data structures imported before it
+// keep their restored state. It returns the number of bytes read.
+func (b *Bundle) ReadFrom(stream io.Reader) (int64, error) {
+	decompressed, err := decompressStream(stream)
+	if err != nil {
+		return 0, err
+	}
+	defer decompressed.Close()
+	checksum := newChecksumReader(decompressed)
+	counter := &countingReader{reader: checksum}
+	manifest, err := ReadBundleManifest(counter)
+	if err != nil {
+		return counter.n, err
+	}
+	exports := make(map[string][]byte, len(manifest.Entries))
+	for _, entry := range manifest.Entries {
+		data, err := readBytes(counter, entry.Length)
+		if err != nil {
+			return counter.n, fmt.Errorf("gostatix: error while reading bundle entry %s, error: %v", entry.Name, err)
+		}
+		if crc32.ChecksumIEEE(data) != entry.Checksum {
+			return counter.n, fmt.Errorf("gostatix: bundle entry %s doesn't match its checksum, it may be corrupted", entry.Name)
+		}
+		exports[entry.Name] = data
+	}
+	for _, name := range b.names {
+		if _, ok := exports[name]; !ok {
+			return counter.n, fmt.Errorf("gostatix: bundle archive has no entry named %s", name)
+		}
+	}
+	trailer, err := checksum.verify()
+	if err != nil {
+		return counter.n + trailer, err
+	}
+	for _, name := range b.names {
+		err = b.structures[name].Import(exports[name])
+		if err != nil {
+			return counter.n, fmt.Errorf("gostatix: error while importing bundle entry %s, error: %v", name, err)
+		}
+	}
+	return counter.n + trailer, nil
+}
+
+// Export returns the archive of the bundle written by WriteTo
+func (b *Bundle) Export() ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := b.WriteTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Import restores the data structures of the bundle from the archive _data_, like ReadFrom
+func (b *Bundle) Import(data []byte) error {
+	_, err := b.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+// ReadBundleManifest reads the header and the manifest of a bundle archive from _stream_,
+// e.g. to list the data structures of a checkpoint without restoring them. The stream is
+// left at the start of the first entry.
+func ReadBundleManifest(stream io.Reader) (*BundleManifest, error) {
+	header, err := readBytes(stream, bundleHeaderSize+8)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while reading bundle header, error: %v", err)
+	}
+	if !bytes.Equal(header[:len(bundleMagic)], bundleMagic) {
+		return nil, fmt.Errorf("gostatix: not a bundle archive")
+	}
+	if header[len(bundleMagic)] != bundleVersion {
+		return nil, fmt.Errorf("gostatix: unsupported bundle version %d", header[len(bundleMagic)])
+	}
+	encoded, err := readBytes(stream, binary.BigEndian.Uint64(header[bundleHeaderSize:]))
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while reading bundle manifest, error: %v", err)
+	}
+	var manifest BundleManifest
+	err = json.Unmarshal(encoded, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: invalid bundle manifest, error: %v", err)
+	}
+	names := make(map[string]bool, len(manifest.Entries))
+	offset := uint64(0)
+	for _, entry := range manifest.Entries {
+		if names[entry.Name] {
+			return nil, fmt.Errorf("gostatix: invalid bundle manifest, duplicate entry %s", entry.Name)
+		}
+		if entry.Offset != offset {
+			return nil, fmt.Errorf("gostatix: invalid bundle manifest, entry %s at offset %d instead of %d", entry.Name, entry.Offset, offset)
+		}
+		names[entry.Name] = true
+		offset += entry.Length
+	}
+	return &manifest, nil
+}
+
+// countingReader counts the bytes read from _reader_
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
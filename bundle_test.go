@@ -0,0 +1,90 @@
+package gostatix
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	filter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	hll, _ := NewHyperLogLog(64)
+	topk, _ := NewTopK(5, 0.001, 0.99)
+	for i := 0; i < 100; i++ {
+		filter.InsertString(strconv.Itoa(i))
+		hll.UpdateString(strconv.Itoa(i))
+		topk.InsertString(strconv.Itoa(i%7), 1)
+	}
+	bundle := NewBundle()
+	bundle.Add("seen", filter)
+	bundle.Add("uniques", hll)
+	bundle.Add("top", topk)
+	if err := bundle.Add("seen", hll); err == nil {
+		t.Errorf("duplicate names should error out")
+	}
+
+	var buf bytes.Buffer
+	written, err := bundle.WriteTo(&buf)
+	if err != nil || written != int64(buf.Len()) {
+		t.Fatalf("bundle should be written, got %d bytes of %d, error: %v", written, buf.Len(), err)
+	}
+	manifest, err := ReadBundleManifest(bytes.NewReader(buf.Bytes()))
+	if err != nil || len(manifest.Entries) != 3 {
+		t.Fatalf("manifest should have 3 entries, got %+v, error: %v", manifest, err)
+	}
+	if entry := manifest.Entries[1]; entry.Name != "uniques" || entry.Type != "hll" || entry.Offset != manifest.Entries[0].Length {
+		t.Errorf("second entry should be the hyperloglog after the bloom filter, got %+v", entry)
+	}
+
+	restoredFilter, _ := NewMemBloomFilterWithParameters(1, 0.5)
+	restoredHLL, _ := NewHyperLogLog(16)
+	restoredTopK, _ := NewTopK(1, 0.1, 0.9)
+	restored := NewBundle()
+	restored.Add("top", restoredTopK)
+	restored.Add("seen", restoredFilter)
+	restored.Add("uniques", restoredHLL)
+	read, err := restored.ReadFrom(bytes.NewReader(buf.Bytes()))
+	if err != nil || read != written {
+		t.Fatalf("bundle should be read, got %d bytes of %d, error: %v", read, written, err)
+	}
+	if equal, _ := restoredFilter.Equals(filter); !equal {
+		t.Errorf("restored bloom filter should equal the bundled one")
+	}
+	if equal, _ := restoredHLL.Equals(hll); !equal {
+		t.Errorf("restored hyperloglog should equal the bundled one")
+	}
+	if equal, _ := restoredTopK.Equals(topk); !equal {
+		t.Errorf("restored top-k should equal the bundled one")
+	}
+
+	missing := NewBundle()
+	missing.Add("other", restoredHLL)
+	if err := missing.Import(buf.Bytes()); err == nil {
+		t.Errorf("restoring a data structure without entry should error out")
+	}
+	corrupted := append([]byte(nil), buf.Bytes()...)
+	corrupted[len(corrupted)-1] ^= 1
+	if err := restored.Import(corrupted); err == nil {
+		t.Errorf("corrupted entry should error out")
+	}
+	if err := restored.Import(buf.Bytes()[:buf.Len()-1]); err == nil {
+		t.Errorf("truncated archive should error out")
+	}
+	if _, err := ReadBundleManifest(bytes.NewReader([]byte("GSTX\x01\x00\x00\x00"))); err == nil {
+		t.Errorf("archive of another format should error out")
+	}
+
+	// a bundle is snapshotted like a single data structure
+	sink := NewFileSnapshotSink(filepath.Join(t.TempDir(), "bundle.snapshot"))
+	sink.Save(buf.Bytes())
+	again, _ := NewHyperLogLog(16)
+	partial := NewBundle()
+	partial.Add("uniques", again)
+	if ok, err := Restore(partial, sink); !ok || err != nil {
+		t.Fatalf("bundle should be restored from the sink, error: %v", err)
+	}
+	if equal, _ := again.Equals(hll); !equal {
+		t.Errorf("entries not in the bundle should be skipped")
+	}
+}
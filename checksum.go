@@ -0,0 +1,116 @@
+package gostatix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// ErrCorruptSnapshot is the error wrapped by the errors of ReadFrom when a stream is cut
+// short or doesn't match the checksum written after it by WriteTo. It can be checked
+// with errors.Is.
+var ErrCorruptSnapshot = errors.New("gostatix: corrupt snapshot")
+
+// The streams written by WriteTo end with a trailer holding the CRC-32 (IEEE) of all the
+// bytes written before it, big endian:
+//
+//	trailer  magic "GSTC" (4 bytes), checksum (uint32)
+
+// checksumMagic starts the trailer of a stream written by WriteTo
+var checksumMagic = []byte("GSTC")
+
+// checksumTrailerSize is the size of the trailer of a stream written by WriteTo
+const checksumTrailerSize = 8
+
+// checksumWriter computes the checksum of the bytes written to _stream_
+type checksumWriter struct {
+	stream io.Writer
+	hash   hash.Hash32
+}
+
+// newChecksumWriter returns a checksumWriter writing to _stream_
+func newChecksumWriter(stream io.Writer) *checksumWriter {
+	return &checksumWriter{stream, crc32.NewIEEE()}
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	n, err := w.stream.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
+
+// writeTrailer writes the checksum of the bytes written so far to the stream
+func (w *checksumWriter) writeTrailer() error {
+	trailer := binary.BigEndian.AppendUint32(append([]byte(nil), checksumMagic...), w.hash.Sum32())
+	_, err := w.stream.Write(trailer)
+	return err
+}
+
+// checksumReader computes the checksum of the bytes read from _stream_. The end of the
+// stream is reported as an ErrCorruptSnapshot, since a data structure is read from it.
+type checksumReader struct {
+	stream io.Reader
+	hash   hash.Hash32
+}
+
+// newChecksumReader returns a checksumReader reading from _stream_
+func newChecksumReader(stream io.Reader) *checksumReader {
+	return &checksumReader{stream, crc32.NewIEEE()}
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.stream.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		if n > 0 {
+			// the end is reported by the next read
+			return n, nil
+		}
+		err = fmt.Errorf("%w: stream cut short", ErrCorruptSnapshot)
+	}
+	return n, err
+}
+
+// readMagic reads _magic_ if it follows the bytes read so far and returns true, else it
+// returns false and leaves the bytes following them to be read next, e.g. by verify
+func (r *checksumReader) readMagic(magic []byte) (bool, error) {
+	peeked := make([]byte, len(magic))
+	n, err := io.ReadFull(r.stream, peeked)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	if bytes.Equal(peeked[:n], magic) {
+		r.hash.Write(peeked)
+		return true, nil
+	}
+	r.stream = io.MultiReader(bytes.NewReader(peeked[:n]), r.stream)
+	return false, nil
+}
+
+// verify reads the trailer following the bytes read so far and checks their checksum. It
+// returns the number of bytes of the trailer. The streams written by the former versions,
+// which have no trailer, are accepted if they end right away.
+func (r *checksumReader) verify() (int64, error) {
+	trailer := make([]byte, checksumTrailerSize)
+	n, err := io.ReadFull(r.stream, trailer)
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err == io.ErrUnexpectedEOF {
+		return int64(n), fmt.Errorf("%w: checksum cut short", ErrCorruptSnapshot)
+	}
+	if err != nil {
+		return int64(n), err
+	}
+	if !bytes.Equal(trailer[:len(checksumMagic)], checksumMagic) {
+		return int64(n), fmt.Errorf("%w: no checksum after the data", ErrCorruptSnapshot)
+	}
+	if binary.BigEndian.Uint32(trailer[len(checksumMagic):]) != r.hash.Sum32() {
+		return int64(n), fmt.Errorf("%w: data doesn't match its checksum", ErrCorruptSnapshot)
+	}
+	return int64(n), nil
+}
@@ -0,0 +1,70 @@
+package gostatix
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestChecksumReadFrom(t *testing.T) {
+	filter, _ := NewMemBloomFilterWithParameters(100, 0.01)
+	cuckoo, _ := NewCuckooFilter(64, 4, 3)
+	sketch, _ := NewCountMinSketch(3, 20)
+	hll, _ := NewHyperLogLog(64)
+	topk, _ := NewTopK(3, 0.01, 0.99)
+	filter.InsertString("foo")
+	cuckoo.InsertString("foo", false)
+	sketch.UpdateString("foo", 3)
+	hll.UpdateString("foo")
+	topk.InsertString("foo", 3)
+	structures := []struct {
+		name   string
+		writer io.WriterTo
+		reader func() io.ReaderFrom
+	}{
+		{"bloom filter", filter, func() io.ReaderFrom { f, _ := NewMemBloomFilterWithParameters(1, 0.5); return f }},
+		{"cuckoo filter", cuckoo, func() io.ReaderFrom { f, _ := NewCuckooFilter(4, 1, 1); return f }},
+		{"count-min sketch", sketch, func() io.ReaderFrom { s, _ := NewCountMinSketch(1, 1); return s }},
+		{"hyperloglog", hll, func() io.ReaderFrom { h, _ := NewHyperLogLog(16); return h }},
+		{"top-k", topk, func() io.ReaderFrom { k, _ := NewTopK(1, 0.1, 0.9); return k }},
+	}
+	for _, s := range structures {
+		var buf bytes.Buffer
+		written, err := s.writer.WriteTo(&buf)
+		if err != nil {
+			t.Fatalf("%s should be written, error: %v", s.name, err)
+		}
+		data := buf.Bytes()
+		if !bytes.Equal(data[len(data)-checksumTrailerSize:len(data)-4], checksumMagic) {
+			t.Errorf("%s stream should end with its checksum", s.name)
+		}
+		if read, err := s.reader().ReadFrom(bytes.NewReader(data)); err != nil || read != written {
+			t.Errorf("%s should be read, got %d bytes instead of %d, error: %v", s.name, read, written, err)
+		}
+		// the streams of the former versions have no checksum
+		if _, err := s.reader().ReadFrom(bytes.NewReader(data[:len(data)-checksumTrailerSize])); err != nil {
+			t.Errorf("%s without checksum should be read, error: %v", s.name, err)
+		}
+		corrupted := append([]byte(nil), data...)
+		corrupted[len(data)-checksumTrailerSize-1] ^= 0x10
+		if _, err := s.reader().ReadFrom(bytes.NewReader(corrupted)); !errors.Is(err, ErrCorruptSnapshot) {
+			t.Errorf("corrupted %s should error out with ErrCorruptSnapshot, got %v", s.name, err)
+		}
+		for _, cut := range []int{len(data) - 3, len(data) - checksumTrailerSize - 1, 20} {
+			if _, err := s.reader().ReadFrom(bytes.NewReader(data[:cut])); !errors.Is(err, ErrCorruptSnapshot) {
+				t.Errorf("%s cut at %d bytes should error out with ErrCorruptSnapshot, got %v", s.name, cut, err)
+			}
+		}
+	}
+
+	restored, _ := NewHyperLogLog(16)
+	var buf bytes.Buffer
+	hll.WriteTo(&buf)
+	data := buf.Bytes()
+	data[len(data)-1] ^= 1
+	restored.ReadFrom(bytes.NewReader(data))
+	if restored.NumRegisters() != 16 {
+		t.Errorf("hyperloglog should be left unchanged by a corrupted stream")
+	}
+}
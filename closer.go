@@ -0,0 +1,78 @@
+package gostatix
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	_ io.Closer = (*BloomFilter)(nil)
+	_ io.Closer = (*CuckooFilter)(nil)
+	_ io.Closer = (*CuckooFilterRedis)(nil)
+	_ io.Closer = (*CountMinSketch)(nil)
+	_ io.Closer = (*CountMinSketchRedis)(nil)
+	_ io.Closer = (*HyperLogLog)(nil)
+	_ io.Closer = (*HyperLogLogRedis)(nil)
+	_ io.Closer = (*LinearCounting)(nil)
+	_ io.Closer = (*KMinValues)(nil)
+	_ io.Closer = (*TopK)(nil)
+	_ io.Closer = (*TopKRedis)(nil)
+	_ io.Closer = (*AsyncWriter)(nil)
+
+	_ CollectionMember = (*BloomFilter)(nil)
+	_ CollectionMember = (*CuckooFilter)(nil)
+	_ CollectionMember = (*CuckooFilterRedis)(nil)
+	_ CollectionMember = (*CountMinSketch)(nil)
+	_ CollectionMember = (*CountMinSketchRedis)(nil)
+	_ CollectionMember = (*HyperLogLog)(nil)
+	_ CollectionMember = (*HyperLogLogRedis)(nil)
+	_ CollectionMember = (*LinearCounting)(nil)
+	_ CollectionMember = (*KMinValues)(nil)
+	_ CollectionMember = (*TopK)(nil)
+	_ CollectionMember = (*TopKRedis)(nil)
+)
+
+// resources keeps track of the resources attached to a data structure (like async
+// writers flushing in the background) which have to be released when the data
+// structure is closed
+type resources struct {
+	closers []io.Closer
+	closed  bool
+	lock    sync.Mutex
+}
+
+// attach registers _closer_ to be closed along with the data structure. If the data
+// structure is already closed, _closer_ is closed right away and an error is returned.
+func (r *resources) attach(closer io.Closer) error {
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		closer.Close()
+		return fmt.Errorf("gostatix: data structure is already closed")
+	}
+	r.closers = append(r.closers, closer)
+	r.lock.Unlock()
+	return nil
+}
+
+// close closes all the attached resources in the reverse order of attachment and
+// returns the first error encountered. It's safe to call close multiple times.
+func (r *resources) close() error {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	closers := r.closers
+	r.closers = nil
+	r.closed = true
+	r.lock.Unlock()
+	var firstErr error
+	for i := len(closers) - 1; i >= 0; i-- {
+		err := closers[i].Close()
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("gostatix: error while releasing resources, error: %v", err)
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,40 @@
+package gostatix
+
+import (
+	"io"
+	"testing"
+)
+
+func TestCloseAll(t *testing.T) {
+	initMockRedis()
+	bloom, _ := NewMemBloomFilterWithParameters(100, 0.01)
+	bloomRedis, _ := NewRedisBloomFilterWithParameters(100, 0.01)
+	cuckoo, _ := NewCuckooFilter(10, 2, 3)
+	cuckooRedis, _ := NewCuckooFilterRedis(10, 2, 3)
+	cms, _ := NewCountMinSketch(2, 10)
+	cmsRedis, _ := NewCountMinSketchRedis(2, 10)
+	hll, _ := NewHyperLogLog(16)
+	hllRedis, _ := NewHyperLogLogRedis(16)
+	topk, _ := NewTopK(5, 0.01, 0.9)
+	topkRedis, _ := NewTopKRedis(5, 0.01, 0.9)
+	closers := []io.Closer{
+		bloom,
+		bloomRedis,
+		cuckoo,
+		cuckooRedis,
+		cms,
+		cmsRedis,
+		hll,
+		hllRedis,
+		topk,
+		topkRedis,
+	}
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			t.Errorf("%T close shouldn't error out, error: %v", closer, err)
+		}
+		if err := closer.Close(); err != nil {
+			t.Errorf("%T second close shouldn't error out, error: %v", closer, err)
+		}
+	}
+}
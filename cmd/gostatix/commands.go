@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kwertop/gostatix"
+)
+
+func runCreate(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	kind := flags.String("type", "bloom", "structure type: bloom, cuckoo, cms, hll or topk")
+	in := flags.String("in", "-", "file of newline delimited keys, - for stdin")
+	output := flags.String("out", "", "path of the snapshot to write")
+	format := flags.String("format", formatBinary, "snapshot format: json or binary")
+	items := flags.Uint64("items", 1000000, "expected number of items (bloom, cuckoo)")
+	errorRate := flags.Float64("error-rate", 0.001, "acceptable error rate (bloom, cuckoo, cms, topk)")
+	delta := flags.Float64("delta", 0.999, "delta of the error rate (cms, topk)")
+	bucketSize := flags.Uint64("bucket-size", 4, "bucket size (cuckoo)")
+	retries := flags.Uint64("retries", 500, "number of retries on insert (cuckoo)")
+	registers := flags.Uint64("registers", 1024, "number of registers (hll)")
+	k := flags.Uint("k", 10, "number of top elements to track (topk)")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		return fmt.Errorf("create: -out is required")
+	}
+
+	var st snapshotter
+	var insert func(data []byte) error
+	switch *kind {
+	case "bloom":
+		filter, err := gostatix.NewMemBloomFilterWithParameters(uint(*items), *errorRate)
+		if err != nil {
+			return err
+		}
+		st, insert = filter, func(data []byte) error {
+			filter.Insert(data)
+			return nil
+		}
+	case "cuckoo":
+		filter, err := gostatix.NewCuckooFilterWithErrorRate(*items, *bucketSize, *retries, *errorRate)
+		if err != nil {
+			return err
+		}
+		st, insert = filter, func(data []byte) error {
+			_, err := filter.InsertWithStats(data, false, 0)
+			return err
+		}
+	case "cms":
+		sketch, err := gostatix.NewCountMinSketchFromEstimates(*errorRate, *delta)
+		if err != nil {
+			return err
+		}
+		st, insert = sketch, func(data []byte) error {
+			sketch.UpdateOnce(data)
+			return nil
+		}
+	case "hll":
+		hll, err := gostatix.NewHyperLogLog(*registers)
+		if err != nil {
+			return err
+		}
+		st, insert = hll, func(data []byte) error {
+			hll.Update(data)
+			return nil
+		}
+	case "topk":
+		topk, err := gostatix.NewTopK(*k, *errorRate, *delta)
+		if err != nil {
+			return err
+		}
+		st, insert = topk, func(data []byte) error {
+			topk.Insert(data, 1)
+			return nil
+		}
+	default:
+		return fmt.Errorf("unknown structure type %q", *kind)
+	}
+
+	reader := io.Reader(os.Stdin)
+	if *in != "-" {
+		file, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	}
+	scanner := bufio.NewScanner(reader)
+	count := 0
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := insert(scanner.Bytes()); err != nil {
+			return fmt.Errorf("create: error after inserting %d keys: %v", count, err)
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	err = save(st, *output, *format)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "inserted %d keys into %s\n", count, *output)
+	return nil
+}
+
+func runQuery(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	kind := flags.String("type", "bloom", "structure type: bloom, cuckoo, cms, hll or topk")
+	in := flags.String("in", "", "path of the snapshot to query")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	st, _, err := load(*kind, *in)
+	if err != nil {
+		return err
+	}
+	switch v := st.(type) {
+	case *gostatix.BloomFilter:
+		for _, key := range flags.Args() {
+			fmt.Fprintf(out, "%s\t%v\n", key, v.Lookup([]byte(key)))
+		}
+	case *gostatix.CuckooFilter:
+		for _, key := range flags.Args() {
+			fmt.Fprintf(out, "%s\t%v\n", key, v.Lookup([]byte(key)))
+		}
+	case *gostatix.CountMinSketch:
+		for _, key := range flags.Args() {
+			fmt.Fprintf(out, "%s\t%d\n", key, v.Count([]byte(key)))
+		}
+	case *gostatix.HyperLogLog:
+		fmt.Fprintf(out, "%d\n", v.Count(true, true))
+	case *gostatix.TopK:
+		for _, value := range v.Values() {
+			fmt.Fprintf(out, "%s\t%d\n", value.Element(), value.Count())
+		}
+	}
+	return nil
+}
+
+func runMerge(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("merge", flag.ContinueOnError)
+	kind := flags.String("type", "hll", "structure type: bloom, cms or hll")
+	output := flags.String("out", "", "path of the merged snapshot to write")
+	format := flags.String("format", "", "snapshot format: json or binary, defaults to the format of the first input")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *output == "" || flags.NArg() < 2 {
+		return fmt.Errorf("merge: -out and at least two input snapshots are required")
+	}
+	result, inputFormat, err := load(*kind, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	for _, path := range flags.Args()[1:] {
+		st, _, err := load(*kind, path)
+		if err != nil {
+			return err
+		}
+		switch v := result.(type) {
+		case *gostatix.BloomFilter:
+			err = v.Merge(st.(*gostatix.BloomFilter))
+		case *gostatix.CountMinSketch:
+			err = v.Merge(st.(*gostatix.CountMinSketch))
+		case *gostatix.HyperLogLog:
+			err = v.Merge(st.(*gostatix.HyperLogLog))
+		default:
+			return fmt.Errorf("merge not supported on %s", *kind)
+		}
+		if err != nil {
+			return fmt.Errorf("error merging %s: %v", path, err)
+		}
+	}
+	if *format == "" {
+		*format = inputFormat
+	}
+	err = save(result, *output, *format)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "merged %d snapshots into %s\n", flags.NArg(), *output)
+	return nil
+}
+
+func runConvert(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	kind := flags.String("type", "bloom", "structure type: bloom, cuckoo, cms, hll or topk")
+	in := flags.String("in", "", "path of the snapshot to convert")
+	output := flags.String("out", "", "path of the converted snapshot to write")
+	format := flags.String("format", "", "target format: json or binary, defaults to the opposite of the input")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		return fmt.Errorf("convert: -out is required")
+	}
+	st, inputFormat, err := load(*kind, *in)
+	if err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = formatJSON
+		if inputFormat == formatJSON {
+			*format = formatBinary
+		}
+	}
+	err = save(st, *output, *format)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "converted %s (%s) to %s (%s)\n", *in, inputFormat, *output, *format)
+	return nil
+}
+
+func runStats(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	kind := flags.String("type", "bloom", "structure type: bloom, cuckoo, cms, hll or topk")
+	in := flags.String("in", "", "path of the snapshot to inspect")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	st, format, err := load(*kind, *in)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "type\t%s\nformat\t%s\n", *kind, format)
+	switch v := st.(type) {
+	case *gostatix.BloomFilter:
+		fmt.Fprintf(out, "size\t%d\n", v.GetCap())
+		fmt.Fprintf(out, "hashes\t%d\n", v.GetNumHashes())
+		fmt.Fprintf(out, "fill ratio\t%f\n", v.FillRatio())
+		fmt.Fprintf(out, "estimated fpr\t%f\n", v.BloomPositiveRate())
+		usage, _ := v.MemoryUsage()
+		fmt.Fprintf(out, "memory\t%d bytes\n", usage)
+	case *gostatix.CuckooFilter:
+		fmt.Fprintf(out, "buckets\t%d\n", v.Size())
+		fmt.Fprintf(out, "bucket size\t%d\n", v.BucketSize())
+		fmt.Fprintf(out, "fingerprint length\t%d\n", v.FingerPrintLength())
+		fmt.Fprintf(out, "entries\t%d\n", v.Length())
+		fmt.Fprintf(out, "load factor\t%f\n", float64(v.Length())/float64(v.CellSize()))
+		fmt.Fprintf(out, "estimated fpr\t%f\n", v.CuckooPositiveRate())
+		fmt.Fprintf(out, "memory\t%d bytes\n", v.MemoryUsage())
+	case *gostatix.CountMinSketch:
+		fmt.Fprintf(out, "rows\t%d\n", v.Rows())
+		fmt.Fprintf(out, "columns\t%d\n", v.Columns())
+		fmt.Fprintf(out, "error rate\t%f\n", v.ErrorRate())
+		fmt.Fprintf(out, "delta\t%f\n", v.Delta())
+		fmt.Fprintf(out, "total count\t%d\n", v.TotalCount())
+		fmt.Fprintf(out, "memory\t%d bytes\n", v.MemoryUsage())
+	case *gostatix.HyperLogLog:
+		fmt.Fprintf(out, "registers\t%d\n", v.NumRegisters())
+		fmt.Fprintf(out, "accuracy\t%f\n", v.Accuracy())
+		fmt.Fprintf(out, "cardinality\t%d\n", v.Count(true, true))
+		fmt.Fprintf(out, "memory\t%d bytes\n", v.MemoryUsage())
+	case *gostatix.TopK:
+		values := v.Values()
+		fmt.Fprintf(out, "memory\t%d bytes\n", v.MemoryUsage())
+		fmt.Fprintf(out, "tracked elements\t%d\n", len(values))
+		for _, value := range values {
+			fmt.Fprintf(out, "%s\t%d\n", value.Element(), value.Count())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func writeKeys(t *testing.T, dir, name string, keys ...string) string {
+	path := filepath.Join(dir, name)
+	err := os.WriteFile(path, []byte(strings.Join(keys, "\n")), 0o644)
+	if err != nil {
+		t.Fatalf("error writing keys: %v", err)
+	}
+	return path
+}
+
+func TestCreateQueryConvert(t *testing.T) {
+	dir := t.TempDir()
+	keys := writeKeys(t, dir, "keys.txt", "cat", "dog", "lion")
+	snapshot := filepath.Join(dir, "animals.bloom")
+	var out bytes.Buffer
+	err := runCreate([]string{"-type", "bloom", "-items", "100", "-error-rate", "0.01", "-in", keys, "-out", snapshot}, &out)
+	if err != nil {
+		t.Fatalf("create should not error out, error: %v", err)
+	}
+
+	converted := filepath.Join(dir, "animals.json")
+	err = runConvert([]string{"-type", "bloom", "-in", snapshot, "-out", converted}, &out)
+	if err != nil {
+		t.Fatalf("convert should not error out, error: %v", err)
+	}
+	_, format, _ := load("bloom", converted)
+	if format != formatJSON {
+		t.Errorf("converted snapshot should be json, found %s", format)
+	}
+
+	out.Reset()
+	err = runQuery([]string{"-type", "bloom", "-in", converted, "cat", "tiger"}, &out)
+	if err != nil {
+		t.Fatalf("query should not error out, error: %v", err)
+	}
+	if out.String() != "cat\ttrue\ntiger\tfalse\n" {
+		t.Errorf("unexpected query output %q", out.String())
+	}
+}
+
+func TestMergeHyperLogLog(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.hll")
+	second := filepath.Join(dir, "second.hll")
+	merged := filepath.Join(dir, "merged.hll")
+	var out bytes.Buffer
+	runCreate([]string{"-type", "hll", "-registers", "64", "-in", writeKeys(t, dir, "a.txt", "a", "b", "c"), "-out", first}, &out)
+	runCreate([]string{"-type", "hll", "-registers", "64", "-in", writeKeys(t, dir, "b.txt", "c", "d"), "-out", second}, &out)
+	err := runMerge([]string{"-type", "hll", "-out", merged, first, second}, &out)
+	if err != nil {
+		t.Fatalf("merge should not error out, error: %v", err)
+	}
+	combined := filepath.Join(dir, "combined.hll")
+	runCreate([]string{"-type", "hll", "-registers", "64", "-in", writeKeys(t, dir, "c.txt", "a", "b", "c", "d"), "-out", combined}, &out)
+
+	var mergedOut, combinedOut bytes.Buffer
+	runQuery([]string{"-type", "hll", "-in", merged}, &mergedOut)
+	runQuery([]string{"-type", "hll", "-in", combined}, &combinedOut)
+	if mergedOut.String() != combinedOut.String() {
+		t.Errorf("merged cardinality %q should match the cardinality of all keys %q", mergedOut.String(), combinedOut.String())
+	}
+}
+
+func TestStatsTopK(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "words.topk")
+	var out bytes.Buffer
+	err := runCreate([]string{"-type", "topk", "-k", "5", "-in", writeKeys(t, dir, "words.txt", "a", "b", "a"), "-out", snapshot}, &out)
+	if err != nil {
+		t.Fatalf("create should not error out, error: %v", err)
+	}
+	out.Reset()
+	err = runStats([]string{"-type", "topk", "-in", snapshot}, &out)
+	if err != nil {
+		t.Fatalf("stats should not error out, error: %v", err)
+	}
+	if !strings.Contains(out.String(), "a\t2\n") || !strings.Contains(out.String(), "memory\t") {
+		t.Errorf("stats should report the memory and a with count 2, found %q", out.String())
+	}
+}
+
+func TestCreateCuckooFull(t *testing.T) {
+	dir := t.TempDir()
+	keys := make([]string, 5000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	snapshot := filepath.Join(dir, "full.cuckoo")
+	var out bytes.Buffer
+	err := runCreate([]string{"-type", "cuckoo", "-items", "100", "-in", writeKeys(t, dir, "keys.txt", keys...), "-out", snapshot}, &out)
+	if err == nil || !strings.Contains(err.Error(), "full") {
+		t.Errorf("create should error out as the filter is full, error: %v", err)
+	}
+	if _, err := os.Stat(snapshot); !os.IsNotExist(err) {
+		t.Errorf("snapshot of the full filter shouldn't be written, error: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+gostatix is a command line tool to create, query, merge, convert and inspect
+snapshots of the in-memory gostatix data structures.
+
+Snapshots are either JSON (as produced by Export) or binary (as produced by WriteTo).
+The format of an input snapshot is detected automatically.
+
+Usage:
+
+	gostatix create  -type bloom -items 1000000 -error-rate 0.001 -in keys.txt -out users.bloom
+	gostatix query   -type bloom -in users.bloom cat dog
+	gostatix merge   -type hll -out all.hll day1.hll day2.hll
+	gostatix convert -type cms -in words.json -out words.bin -format binary
+	gostatix stats   -type bloom -in users.bloom
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: gostatix <command> [flags]
+
+commands:
+  create   create a structure from a file of newline delimited keys
+  query    query a snapshot for the keys passed as arguments
+  merge    merge snapshots of bloom filters, count-min sketches or hyperloglogs
+  convert  convert a snapshot between json and binary formats
+  stats    print statistics about a snapshot
+
+supported types: bloom, cuckoo, cms, hll, topk
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "create":
+		err = runCreate(os.Args[2:], os.Stdout)
+	case "query":
+		err = runQuery(os.Args[2:], os.Stdout)
+	case "merge":
+		err = runMerge(os.Args[2:], os.Stdout)
+	case "convert":
+		err = runConvert(os.Args[2:], os.Stdout)
+	case "stats":
+		err = runStats(os.Args[2:], os.Stdout)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "gostatix: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gostatix: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kwertop/gostatix"
+)
+
+const (
+	formatJSON   = "json"
+	formatBinary = "binary"
+)
+
+// snapshotter is implemented by every in-memory gostatix structure
+type snapshotter interface {
+	Export() ([]byte, error)
+	Import(data []byte) error
+	io.WriterTo
+	io.ReaderFrom
+}
+
+// emptyStructure creates a minimal structure of _kind_ which is meant to be
+// overwritten by an import
+func emptyStructure(kind string) (snapshotter, error) {
+	switch kind {
+	case "bloom":
+		return gostatix.NewMemBloomFilterWithParameters(1, 0.5)
+	case "cuckoo":
+		return gostatix.NewCuckooFilter(1, 1, 1)
+	case "cms":
+		return gostatix.NewCountMinSketch(1, 1)
+	case "hll":
+		return gostatix.NewHyperLogLog(1)
+	case "topk":
+		return gostatix.NewTopK(1, 0.1, 0.9)
+	}
+	return nil, fmt.Errorf("unknown structure type %q", kind)
+}
+
+// load reads the snapshot at _path_ into a structure of _kind_. The snapshot
+// is treated as JSON if its first non-blank byte is '{', otherwise as binary.
+// The detected format is returned along with the structure.
+func load(kind, path string) (snapshotter, string, error) {
+	st, err := emptyStructure(kind)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		err = st.Import(trimmed)
+		if err != nil {
+			return nil, "", fmt.Errorf("error importing json snapshot %s: %v", path, err)
+		}
+		return st, formatJSON, nil
+	}
+	_, err = st.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading binary snapshot %s: %v", path, err)
+	}
+	return st, formatBinary, nil
+}
+
+// save writes _st_ to _path_ in the specified _format_
+func save(st snapshotter, path, format string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	switch format {
+	case formatJSON:
+		data, err := st.Export()
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(data)
+		if err != nil {
+			return err
+		}
+	case formatBinary:
+		_, err = st.WriteTo(writer)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %q, should be json or binary", format)
+	}
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
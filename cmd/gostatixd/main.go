@@ -0,0 +1,76 @@
+/*
+gostatixd hosts named in-memory gostatix structures and exposes them over HTTP.
+
+It gives the shared, service-like behaviour of the Redis backed structures without
+needing a Redis server. Structures are periodically snapshotted to a data directory
+and restored from it on start-up.
+
+Usage:
+
+	gostatixd -addr :7379 -data-dir /var/lib/gostatixd -snapshot-interval 1m
+
+Endpoints:
+
+	PUT    /structures/{name}?type=bloom&items=1000000&error_rate=0.001
+	PUT    /structures/{name}?type=cuckoo&items=1000000&bucket_size=4&retries=500&error_rate=0.001
+	PUT    /structures/{name}?type=cms&error_rate=0.001&delta=0.999
+	PUT    /structures/{name}?type=hll&registers=1024
+	PUT    /structures/{name}?type=topk&k=10&error_rate=0.001&delta=0.999
+	GET    /structures
+	DELETE /structures/{name}
+	POST   /structures/{name}/insert?key=foo&count=1 (or newline delimited keys in the body)
+	GET    /structures/{name}/lookup?key=foo
+	GET    /structures/{name}/count[?key=foo]
+	GET    /structures/{name}/topk
+	POST   /snapshot
+*/
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", ":7379", "address to listen on")
+	dataDir := flag.String("data-dir", "", "directory used to persist snapshots, snapshots are disabled if blank")
+	snapshotInterval := flag.Duration("snapshot-interval", time.Minute, "interval between two snapshots to disk")
+	flag.Parse()
+
+	server := newServer(*dataDir)
+	if *dataDir != "" {
+		err := server.restore()
+		if err != nil {
+			log.Fatalf("gostatixd: error restoring snapshots from %s: %v", *dataDir, err)
+		}
+		go func() {
+			ticker := time.NewTicker(*snapshotInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				if err := server.snapshot(); err != nil {
+					log.Printf("gostatixd: error while taking snapshot: %v", err)
+				}
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		if *dataDir != "" {
+			if err := server.snapshot(); err != nil {
+				log.Printf("gostatixd: error while taking snapshot: %v", err)
+			}
+		}
+		os.Exit(0)
+	}()
+
+	log.Printf("gostatixd: listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
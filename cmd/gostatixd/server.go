@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kwertop/gostatix"
+)
+
+const (
+	kindBloom  = "bloom"
+	kindCuckoo = "cuckoo"
+	kindCMS    = "cms"
+	kindHLL    = "hll"
+	kindTopK   = "topk"
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// structure is a named gostatix data structure hosted by the server
+// _kind_ is one of bloom, cuckoo, cms, hll or topk
+// _value_ is the underlying in-memory gostatix structure
+// _lock_ serializes access to _value_ as not every structure synchronizes internally
+type structure struct {
+	kind  string
+	value interface{}
+	lock  sync.Mutex
+}
+
+// server holds all the named structures and implements http.Handler
+// _dataDir_ is the directory where snapshots are persisted
+// _lock_ guards the _structures_ map
+type server struct {
+	dataDir    string
+	structures map[string]*structure
+	lock       sync.RWMutex
+}
+
+func newServer(dataDir string) *server {
+	return &server{dataDir: dataDir, structures: make(map[string]*structure)}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("%v", err))
+		}
+	}()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "snapshot" && r.Method == http.MethodPost:
+		s.handleSnapshot(w)
+	case len(parts) == 1 && parts[0] == "structures" && r.Method == http.MethodGet:
+		s.handleList(w)
+	case len(parts) == 2 && parts[0] == "debug" && parts[1] == "sketches" && r.Method == http.MethodGet:
+		s.handleStats(w, r)
+	case len(parts) == 2 && parts[0] == "structures":
+		switch r.Method {
+		case http.MethodPut:
+			s.handleCreate(w, r, parts[1])
+		case http.MethodDelete:
+			s.handleDelete(w, parts[1])
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		}
+	case len(parts) == 3 && parts[0] == "structures":
+		st, ok := s.get(parts[1])
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("structure %s not found", parts[1]))
+			return
+		}
+		switch parts[2] {
+		case "insert":
+			if r.Method != http.MethodPost {
+				writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+				return
+			}
+			s.handleInsert(w, r, st)
+		case "lookup":
+			s.handleLookup(w, r, st)
+		case "count":
+			s.handleCount(w, r, st)
+		case "topk":
+			s.handleTopK(w, st)
+		default:
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown operation %s", parts[2]))
+		}
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path %s", r.URL.Path))
+	}
+}
+
+func (s *server) get(name string) (*structure, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	st, ok := s.structures[name]
+	return st, ok
+}
+
+func (s *server) handleList(w http.ResponseWriter) {
+	s.lock.RLock()
+	result := make(map[string]string, len(s.structures))
+	for name, st := range s.structures {
+		result[name] = st.kind
+	}
+	s.lock.RUnlock()
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleStats writes the stats of all the structures as JSON, or in the Prometheus text
+// exposition format with format=prometheus
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	s.lock.RLock()
+	structures := make(map[string]*structure, len(s.structures))
+	for name, st := range s.structures {
+		structures[name] = st
+	}
+	s.lock.RUnlock()
+	stats := make(map[string]gostatix.Stats, len(structures))
+	for name, st := range structures {
+		stat, err := st.stats()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		stats[name] = stat
+	}
+	if r.URL.Query().Get("format") == "prometheus" {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = gostatix.WriteStatsPrometheus(w, stats)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = gostatix.WriteStatsJSON(w, stats)
+}
+
+func (s *server) handleCreate(w http.ResponseWriter, r *http.Request, name string) {
+	if !validName.MatchString(name) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid structure name %s", name))
+		return
+	}
+	st, err := newStructure(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.structures[name]; ok {
+		writeError(w, http.StatusConflict, fmt.Errorf("structure %s already exists", name))
+		return
+	}
+	s.structures[name] = st
+	writeJSON(w, http.StatusCreated, map[string]string{"name": name, "type": st.kind})
+}
+
+func (s *server) handleDelete(w http.ResponseWriter, name string) {
+	s.lock.Lock()
+	st, ok := s.structures[name]
+	delete(s.structures, name)
+	s.lock.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("structure %s not found", name))
+		return
+	}
+	if s.dataDir != "" {
+		err := os.Remove(s.snapshotPath(name, st.kind))
+		if err != nil && !os.IsNotExist(err) {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) handleInsert(w http.ResponseWriter, r *http.Request, st *structure) {
+	count := uint64(1)
+	if c := r.URL.Query().Get("count"); c != "" {
+		var err error
+		count, err = strconv.ParseUint(c, 10, 64)
+		if err != nil || count == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid count %s", c))
+			return
+		}
+	}
+	inserted := 0
+	// insertFailed writes the number of elements inserted before the failed insert, the
+	// structures only failing when they're full
+	insertFailed := func(err error) {
+		writeJSON(w, http.StatusInsufficientStorage, map[string]interface{}{"inserted": inserted, "error": err.Error()})
+	}
+	if key := r.URL.Query().Get("key"); key != "" {
+		ok, err := st.insert([]byte(key), count)
+		if err != nil {
+			insertFailed(err)
+			return
+		}
+		if ok {
+			inserted++
+		}
+	} else {
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			ok, err := st.insert(scanner.Bytes(), count)
+			if err != nil {
+				insertFailed(err)
+				return
+			}
+			if ok {
+				inserted++
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"inserted": inserted})
+}
+
+func (s *server) handleLookup(w http.ResponseWriter, r *http.Request, st *structure) {
+	found, err := st.lookup([]byte(r.URL.Query().Get("key")))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"found": found})
+}
+
+func (s *server) handleCount(w http.ResponseWriter, r *http.Request, st *structure) {
+	count, err := st.count([]byte(r.URL.Query().Get("key")))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]uint64{"count": count})
+}
+
+func (s *server) handleTopK(w http.ResponseWriter, st *structure) {
+	values, err := st.values()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	type element struct {
+		Element string `json:"element"`
+		Count   uint64 `json:"count"`
+	}
+	result := make([]element, len(values))
+	for i := range values {
+		result[i] = element{values[i].Element(), values[i].Count()}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *server) handleSnapshot(w http.ResponseWriter) {
+	if s.dataDir == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("snapshots are disabled, no data directory configured"))
+		return
+	}
+	err := s.snapshot()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// snapshot writes every structure to _dataDir_ as <name>.<kind>.json
+// Each file is written to a temporary file first and renamed so that a crash
+// during the snapshot never leaves a truncated file behind.
+func (s *server) snapshot() error {
+	s.lock.RLock()
+	names := make([]string, 0, len(s.structures))
+	for name := range s.structures {
+		names = append(names, name)
+	}
+	s.lock.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		st, ok := s.get(name)
+		if !ok {
+			continue
+		}
+		data, err := st.export()
+		if err != nil {
+			return fmt.Errorf("gostatixd: error exporting %s: %v", name, err)
+		}
+		path := s.snapshotPath(name, st.kind)
+		tmp := path + ".tmp"
+		err = os.WriteFile(tmp, data, 0o644)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(tmp, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restore loads all the snapshots present in _dataDir_
+func (s *server) restore() error {
+	err := os.MkdirAll(s.dataDir, 0o755)
+	if err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(s.dataDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, file := range files {
+		parts := strings.Split(strings.TrimSuffix(filepath.Base(file), ".json"), ".")
+		if len(parts) != 2 || !validName.MatchString(parts[0]) {
+			continue
+		}
+		st, err := emptyStructure(parts[1])
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		err = st.importData(data)
+		if err != nil {
+			return fmt.Errorf("gostatixd: error importing %s: %v", file, err)
+		}
+		s.structures[parts[0]] = st
+	}
+	return nil
+}
+
+func (s *server) snapshotPath(name, kind string) string {
+	return filepath.Join(s.dataDir, name+"."+kind+".json")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func doRequest(t *testing.T, s *server, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServerBloomLookup(t *testing.T) {
+	s := newServer("")
+	rec := doRequest(t, s, http.MethodPut, "/structures/users?type=bloom&items=1000&error_rate=0.01", "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create should return 201, found %d", rec.Code)
+	}
+	rec = doRequest(t, s, http.MethodPost, "/structures/users/insert", "alice\nbob\n")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("insert should return 200, found %d", rec.Code)
+	}
+	var result map[string]bool
+	rec = doRequest(t, s, http.MethodGet, "/structures/users/lookup?key=alice", "")
+	json.NewDecoder(rec.Body).Decode(&result)
+	if !result["found"] {
+		t.Errorf("alice should be found")
+	}
+	rec = doRequest(t, s, http.MethodGet, "/structures/users/lookup?key=carol", "")
+	json.NewDecoder(rec.Body).Decode(&result)
+	if result["found"] {
+		t.Errorf("carol should not be found")
+	}
+}
+
+func TestServerSnapshotRestore(t *testing.T) {
+	dir := t.TempDir()
+	s := newServer(dir)
+	doRequest(t, s, http.MethodPut, "/structures/words?type=cms&error_rate=0.01&delta=0.9", "")
+	doRequest(t, s, http.MethodPost, "/structures/words/insert?key=foo&count=3", "")
+	rec := doRequest(t, s, http.MethodPost, "/snapshot", "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("snapshot should return 204, found %d", rec.Code)
+	}
+
+	restored := newServer(dir)
+	err := restored.restore()
+	if err != nil {
+		t.Fatalf("restore should not error out, error: %v", err)
+	}
+	var result map[string]uint64
+	rec = doRequest(t, restored, http.MethodGet, "/structures/words/count?key=foo", "")
+	json.NewDecoder(rec.Body).Decode(&result)
+	if result["count"] != 3 {
+		t.Errorf("count of foo should be 3, found %d", result["count"])
+	}
+}
+
+func TestServerUnsupportedOperation(t *testing.T) {
+	s := newServer("")
+	doRequest(t, s, http.MethodPut, "/structures/uniques?type=hll&registers=64", "")
+	rec := doRequest(t, s, http.MethodGet, "/structures/uniques/lookup?key=foo", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("lookup on hll should return 400, found %d", rec.Code)
+	}
+	rec = doRequest(t, s, http.MethodGet, "/structures/missing/lookup?key=foo", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("lookup on missing structure should return 404, found %d", rec.Code)
+	}
+}
+
+func TestServerStats(t *testing.T) {
+	s := newServer("")
+	doRequest(t, s, http.MethodPut, "/structures/words?type=cms&error_rate=0.01&delta=0.9", "")
+	doRequest(t, s, http.MethodPost, "/structures/words/insert?key=foo&count=3", "")
+	var result map[string]map[string]interface{}
+	rec := doRequest(t, s, http.MethodGet, "/debug/sketches", "")
+	json.NewDecoder(rec.Body).Decode(&result)
+	if result["words"]["type"] != "cms" || result["words"]["itemsEstimate"] != float64(3) {
+		t.Errorf("unexpected stats of words, found %v", result["words"])
+	}
+	rec = doRequest(t, s, http.MethodGet, "/debug/sketches?format=prometheus", "")
+	if !strings.Contains(rec.Body.String(), `gostatix_items_estimate{name="words",type="cms",backend="memory"} 3`) {
+		t.Errorf("prometheus stats should hold the items estimate of words, found %s", rec.Body.String())
+	}
+}
+
+func TestServerInsertMethod(t *testing.T) {
+	s := newServer("")
+	doRequest(t, s, http.MethodPut, "/structures/users?type=bloom&items=1000&error_rate=0.01", "")
+	rec := doRequest(t, s, http.MethodGet, "/structures/users/insert?key=alice", "")
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("insert with GET should return 405, found %d", rec.Code)
+	}
+}
+
+func TestServerCuckooFull(t *testing.T) {
+	s := newServer("")
+	doRequest(t, s, http.MethodPut, "/structures/seen?type=cuckoo&items=4&error_rate=0.01&bucket_size=1&retries=1", "")
+	var body strings.Builder
+	for i := 0; i < 100; i++ {
+		body.WriteString("element" + strconv.Itoa(i) + "\n")
+	}
+	rec := doRequest(t, s, http.MethodPost, "/structures/seen/insert", body.String())
+	if rec.Code != http.StatusInsufficientStorage {
+		t.Fatalf("insert in a full cuckoo filter should return 507, found %d", rec.Code)
+	}
+	var result map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&result)
+	if inserted, _ := result["inserted"].(float64); inserted < 1 || inserted > 4 {
+		t.Errorf("only the elements which fit in the filter should be counted, found %v", result["inserted"])
+	}
+}
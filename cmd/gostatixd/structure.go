@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/kwertop/gostatix"
+)
+
+// newStructure creates a structure from the query parameters of a create request
+func newStructure(params url.Values) (*structure, error) {
+	kind := params.Get("type")
+	var value interface{}
+	var err error
+	switch kind {
+	case kindBloom:
+		items, errorRate, perr := parseItemsAndErrorRate(params)
+		if perr != nil {
+			return nil, perr
+		}
+		value, err = gostatix.NewMemBloomFilterWithParameters(uint(items), errorRate)
+	case kindCuckoo:
+		items, errorRate, perr := parseItemsAndErrorRate(params)
+		if perr != nil {
+			return nil, perr
+		}
+		bucketSize, perr := parseUint(params, "bucket_size", 4)
+		if perr != nil {
+			return nil, perr
+		}
+		retries, perr := parseUint(params, "retries", 500)
+		if perr != nil {
+			return nil, perr
+		}
+		value, err = gostatix.NewCuckooFilterWithErrorRate(items, bucketSize, retries, errorRate)
+	case kindCMS:
+		errorRate, delta, perr := parseErrorRateAndDelta(params)
+		if perr != nil {
+			return nil, perr
+		}
+		value, err = gostatix.NewCountMinSketchFromEstimates(errorRate, delta)
+	case kindHLL:
+		registers, perr := parseUint(params, "registers", 1024)
+		if perr != nil {
+			return nil, perr
+		}
+		value, err = gostatix.NewHyperLogLog(registers)
+	case kindTopK:
+		k, perr := parseUint(params, "k", 10)
+		if perr != nil {
+			return nil, perr
+		}
+		errorRate, delta, perr := parseErrorRateAndDelta(params)
+		if perr != nil {
+			return nil, perr
+		}
+		value, err = gostatix.NewTopK(uint(k), errorRate, delta)
+	default:
+		return nil, fmt.Errorf("unknown structure type %q", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &structure{kind: kind, value: value}, nil
+}
+
+// emptyStructure creates a minimal structure of _kind_ which is meant to be
+// overwritten by an import
+func emptyStructure(kind string) (*structure, error) {
+	var value interface{}
+	var err error
+	switch kind {
+	case kindBloom:
+		value, err = gostatix.NewMemBloomFilterWithParameters(1, 0.5)
+	case kindCuckoo:
+		value, err = gostatix.NewCuckooFilter(1, 1, 1)
+	case kindCMS:
+		value, err = gostatix.NewCountMinSketch(1, 1)
+	case kindHLL:
+		value, err = gostatix.NewHyperLogLog(1)
+	case kindTopK:
+		value, err = gostatix.NewTopK(1, 0.1, 0.9)
+	default:
+		return nil, fmt.Errorf("unknown structure type %q", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &structure{kind: kind, value: value}, nil
+}
+
+// insert adds _data_ to the structure and returns true if it was inserted, or false with
+// the error of the structure, e.g. when a cuckoo filter is full. _count_ is only used by
+// the structures which keep track of frequencies (cms and topk)
+func (st *structure) insert(data []byte, count uint64) (bool, error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	switch v := st.value.(type) {
+	case *gostatix.BloomFilter:
+		v.Insert(data)
+	case *gostatix.CuckooFilter:
+		if _, err := v.InsertWithStats(data, false, 0); err != nil {
+			return false, err
+		}
+	case *gostatix.CountMinSketch:
+		v.Update(data, count)
+	case *gostatix.HyperLogLog:
+		v.Update(data)
+	case *gostatix.TopK:
+		v.Insert(data, count)
+	default:
+		return false, fmt.Errorf("structure of type %s doesn't support inserts", st.kind)
+	}
+	return true, nil
+}
+
+// lookup checks the membership of _data_ in a bloom or cuckoo filter
+func (st *structure) lookup(data []byte) (bool, error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	switch v := st.value.(type) {
+	case *gostatix.BloomFilter:
+		return v.Lookup(data), nil
+	case *gostatix.CuckooFilter:
+		return v.Lookup(data), nil
+	}
+	return false, fmt.Errorf("lookup not supported on %s", st.kind)
+}
+
+// count returns the estimated count of _data_ for a cms, the estimated cardinality
+// for a hll and the number of entries for a cuckoo filter
+func (st *structure) count(data []byte) (uint64, error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	switch v := st.value.(type) {
+	case *gostatix.CountMinSketch:
+		return v.Count(data), nil
+	case *gostatix.HyperLogLog:
+		return v.Count(true, true), nil
+	case *gostatix.CuckooFilter:
+		return v.Length(), nil
+	}
+	return 0, fmt.Errorf("count not supported on %s", st.kind)
+}
+
+// values returns the top-k elements of a topk
+func (st *structure) values() ([]gostatix.TopKElement, error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	topk, ok := st.value.(*gostatix.TopK)
+	if !ok {
+		return nil, fmt.Errorf("topk not supported on %s", st.kind)
+	}
+	return topk.Values(), nil
+}
+
+// stats returns the stats of the structure
+func (st *structure) stats() (gostatix.Stats, error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	reporter, ok := st.value.(gostatix.StatsReporter)
+	if !ok {
+		return gostatix.Stats{}, fmt.Errorf("stats not supported on %s", st.kind)
+	}
+	return reporter.Stats()
+}
+
+func (st *structure) export() ([]byte, error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	switch v := st.value.(type) {
+	case *gostatix.BloomFilter:
+		return v.Export()
+	case *gostatix.CuckooFilter:
+		return v.Export()
+	case *gostatix.CountMinSketch:
+		return v.Export()
+	case *gostatix.HyperLogLog:
+		return v.Export()
+	case *gostatix.TopK:
+		return v.Export()
+	}
+	return nil, fmt.Errorf("unknown structure type %q", st.kind)
+}
+
+func (st *structure) importData(data []byte) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	switch v := st.value.(type) {
+	case *gostatix.BloomFilter:
+		return v.Import(data)
+	case *gostatix.CuckooFilter:
+		return v.Import(data)
+	case *gostatix.CountMinSketch:
+		return v.Import(data)
+	case *gostatix.HyperLogLog:
+		return v.Import(data)
+	case *gostatix.TopK:
+		return v.Import(data)
+	}
+	return fmt.Errorf("unknown structure type %q", st.kind)
+}
+
+func parseUint(params url.Values, name string, defaultValue uint64) (uint64, error) {
+	value := params.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	result, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return result, nil
+}
+
+func parseFloat(params url.Values, name string, defaultValue float64) (float64, error) {
+	value := params.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil || result <= 0 || result >= 1 {
+		return 0, fmt.Errorf("invalid %s %q, should be between 0 and 1", name, value)
+	}
+	return result, nil
+}
+
+func parseItemsAndErrorRate(params url.Values) (uint64, float64, error) {
+	items, err := parseUint(params, "items", 1000000)
+	if err != nil {
+		return 0, 0, err
+	}
+	if items == 0 {
+		return 0, 0, fmt.Errorf("items should be greater than 0")
+	}
+	errorRate, err := parseFloat(params, "error_rate", 0.001)
+	if err != nil {
+		return 0, 0, err
+	}
+	return items, errorRate, nil
+}
+
+func parseErrorRateAndDelta(params url.Values) (float64, float64, error) {
+	errorRate, err := parseFloat(params, "error_rate", 0.001)
+	if err != nil {
+		return 0, 0, err
+	}
+	delta, err := parseFloat(params, "delta", 0.999)
+	if err != nil {
+		return 0, 0, err
+	}
+	return errorRate, delta, nil
+}
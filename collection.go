@@ -0,0 +1,289 @@
+/*
+Implements a collection of named data structures of the same type and configuration,
+like one Bloom filter per customer.
+
+Members are created on first use, evicted after being idle for longer than the TTL
+of the collection and can be snapshotted and restored in bulk.
+*/
+package gostatix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CollectionMember is implemented by all the gostatix data structures which can be
+// managed by a Collection
+type CollectionMember interface {
+	io.Closer
+	Export() ([]byte, error)
+}
+
+// collectionEntry is a member of a Collection along with the time it was last used
+type collectionEntry[T CollectionMember] struct {
+	member   T
+	lastUsed time.Time
+}
+
+// Collection manages many named data structures of the same type and configuration.
+// _create_ is called with the name of a member the first time it's requested.
+// _ttl_ is the time after which a member which hasn't been used is evicted. If _ttl_
+// is greater than 0, idle members are evicted in the background every _ttl_/2.
+// Evicted members are closed and removed from the collection. The data of Redis backed
+// members is kept in Redis, but GetOrCreate creates a new member for the name.
+type Collection[T CollectionMember] struct {
+	create  func(name string) (T, error)
+	ttl     time.Duration
+	onEvict func(name string, member T)
+	members map[string]*collectionEntry[T]
+	closed  bool
+	lock    sync.RWMutex
+	done    chan struct{}
+	wg      sync.WaitGroup
+	now     func() time.Time
+}
+
+// collectionJSON is the snapshot of a Collection holding the exported data of each member
+type collectionJSON struct {
+	Members map[string]json.RawMessage `json:"m"`
+}
+
+// NewCollection creates a Collection whose members are created by _create_ and evicted
+// after being idle for _ttl_. A _ttl_ of 0 disables eviction.
+func NewCollection[T CollectionMember](create func(name string) (T, error), ttl time.Duration) (*Collection[T], error) {
+	if create == nil {
+		return nil, fmt.Errorf("gostatix: create function of collection can't be nil")
+	}
+	if ttl < 0 {
+		return nil, fmt.Errorf("gostatix: ttl of collection can't be negative")
+	}
+	collection := &Collection[T]{
+		create:  create,
+		ttl:     ttl,
+		members: make(map[string]*collectionEntry[T]),
+		done:    make(chan struct{}),
+		now:     time.Now,
+	}
+	if ttl > 0 {
+		collection.wg.Add(1)
+		go collection.evictPeriodically()
+	}
+	return collection, nil
+}
+
+// OnEvict sets _onEvict_ to be called with the members evicted for being idle, before
+// they're closed. It can be used to persist the members, e.g. using Export.
+func (c *Collection[T]) OnEvict(onEvict func(name string, member T)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.onEvict = onEvict
+}
+
+// GetOrCreate returns the member _name_, creating it if it doesn't exist yet, and marks
+// it as used
+func (c *Collection[T]) GetOrCreate(name string) (T, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var member T
+	if c.closed {
+		return member, fmt.Errorf("gostatix: collection is already closed")
+	}
+	entry, ok := c.members[name]
+	if ok {
+		entry.lastUsed = c.now()
+		return entry.member, nil
+	}
+	member, err := c.create(name)
+	if err != nil {
+		return member, fmt.Errorf("gostatix: error while creating member %s of collection, error: %v", name, err)
+	}
+	c.members[name] = &collectionEntry[T]{member, c.now()}
+	return member, nil
+}
+
+// Get returns the member _name_ and marks it as used. The second return value is false
+// if the member doesn't exist.
+func (c *Collection[T]) Get(name string) (T, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.members[name]
+	if !ok {
+		var member T
+		return member, false
+	}
+	entry.lastUsed = c.now()
+	return entry.member, true
+}
+
+// Remove closes the member _name_ and removes it from the collection. It's a no-op if
+// the member doesn't exist.
+func (c *Collection[T]) Remove(name string) error {
+	c.lock.Lock()
+	entry, ok := c.members[name]
+	delete(c.members, name)
+	c.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	return entry.member.Close()
+}
+
+// Names returns the sorted names of the members of the collection
+func (c *Collection[T]) Names() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	names := make([]string, 0, len(c.members))
+	for name := range c.members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Len returns the number of members of the collection
+func (c *Collection[T]) Len() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.members)
+}
+
+// EvictIdle closes and removes the members which haven't been used for longer than the
+// ttl of the collection and returns the number of evicted members. It's a no-op if the
+// ttl is 0.
+func (c *Collection[T]) EvictIdle() (int, error) {
+	if c.ttl == 0 {
+		return 0, nil
+	}
+	c.lock.Lock()
+	deadline := c.now().Add(-c.ttl)
+	evicted := make(map[string]T)
+	for name, entry := range c.members {
+		if entry.lastUsed.Before(deadline) {
+			evicted[name] = entry.member
+			delete(c.members, name)
+		}
+	}
+	onEvict := c.onEvict
+	c.lock.Unlock()
+	var firstErr error
+	for name, member := range evicted {
+		if onEvict != nil {
+			onEvict(name, member)
+		}
+		err := member.Close()
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("gostatix: error while closing member %s of collection, error: %v", name, err)
+		}
+	}
+	return len(evicted), firstErr
+}
+
+// Snapshot exports all the members of the collection and returns a byte slice containing
+// the data, which can be loaded back with Restore
+func (c *Collection[T]) Snapshot() ([]byte, error) {
+	c.lock.RLock()
+	members := make(map[string]T, len(c.members))
+	for name, entry := range c.members {
+		members[name] = entry.member
+	}
+	c.lock.RUnlock()
+	snapshot := collectionJSON{make(map[string]json.RawMessage, len(members))}
+	for name, member := range members {
+		data, err := member.Export()
+		if err != nil {
+			return nil, fmt.Errorf("gostatix: error while exporting member %s of collection, error: %v", name, err)
+		}
+		snapshot.Members[name] = data
+	}
+	return json.Marshal(snapshot)
+}
+
+// Restore loads the members of a snapshot created by Snapshot into the collection.
+// _load_ creates a member from its exported data, e.g. by creating a data structure
+// and calling Import on it. Members already in the collection with the same name are
+// closed and replaced. Nothing is replaced if any of the members fails to load.
+func (c *Collection[T]) Restore(data []byte, load func(data []byte) (T, error)) error {
+	var snapshot collectionJSON
+	err := json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return fmt.Errorf("gostatix: error while reading collection snapshot, error: %v", err)
+	}
+	loaded := make(map[string]T, len(snapshot.Members))
+	for name, memberData := range snapshot.Members {
+		member, err := load(memberData)
+		if err != nil {
+			for _, m := range loaded {
+				m.Close()
+			}
+			return fmt.Errorf("gostatix: error while loading member %s of collection, error: %v", name, err)
+		}
+		loaded[name] = member
+	}
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		for _, m := range loaded {
+			m.Close()
+		}
+		return fmt.Errorf("gostatix: collection is already closed")
+	}
+	var replaced []T
+	now := c.now()
+	for name, member := range loaded {
+		if entry, ok := c.members[name]; ok {
+			replaced = append(replaced, entry.member)
+		}
+		c.members[name] = &collectionEntry[T]{member, now}
+	}
+	c.lock.Unlock()
+	for _, member := range replaced {
+		member.Close()
+	}
+	return nil
+}
+
+// Close stops the background eviction and closes all the members of the collection.
+// It's safe to call Close multiple times.
+func (c *Collection[T]) Close() error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil
+	}
+	c.closed = true
+	members := c.members
+	c.members = make(map[string]*collectionEntry[T])
+	c.lock.Unlock()
+	close(c.done)
+	c.wg.Wait()
+	var firstErr error
+	for name, entry := range members {
+		err := entry.member.Close()
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("gostatix: error while closing member %s of collection, error: %v", name, err)
+		}
+	}
+	return firstErr
+}
+
+func (c *Collection[T]) evictPeriodically() {
+	defer c.wg.Done()
+	interval := c.ttl / 2
+	if interval == 0 {
+		interval = c.ttl
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.EvictIdle()
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package gostatix
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newBloomCollection(t *testing.T, ttl time.Duration) *Collection[*BloomFilter] {
+	collection, err := NewCollection(func(name string) (*BloomFilter, error) {
+		return NewMemBloomFilterWithParameters(1000, 0.01)
+	}, ttl)
+	if err != nil {
+		t.Fatalf("collection creation shouldn't error out, error: %v", err)
+	}
+	return collection
+}
+
+func TestCollectionGetOrCreate(t *testing.T) {
+	collection := newBloomCollection(t, 0)
+	defer collection.Close()
+	alice, _ := collection.GetOrCreate("alice")
+	alice.InsertString("foo")
+	again, _ := collection.GetOrCreate("alice")
+	if again != alice {
+		t.Errorf("GetOrCreate should return the existing member")
+	}
+	bob, _ := collection.GetOrCreate("bob")
+	if bob.LookupString("foo") {
+		t.Errorf("members of a collection shouldn't share data")
+	}
+	if _, ok := collection.Get("carol"); ok {
+		t.Errorf("carol shouldn't be a member of the collection")
+	}
+	if names := collection.Names(); len(names) != 2 || names[0] != "alice" || names[1] != "bob" {
+		t.Errorf("names should be [alice bob], got %v", names)
+	}
+	collection.Remove("alice")
+	if collection.Len() != 1 {
+		t.Errorf("collection should have 1 member after remove, got %d", collection.Len())
+	}
+}
+
+func TestCollectionCreateError(t *testing.T) {
+	collection, _ := NewCollection(func(name string) (*BloomFilter, error) {
+		return nil, fmt.Errorf("no filter for %s", name)
+	}, 0)
+	defer collection.Close()
+	_, err := collection.GetOrCreate("alice")
+	if err == nil {
+		t.Errorf("GetOrCreate should error out when create fails")
+	}
+	if collection.Len() != 0 {
+		t.Errorf("failed members shouldn't be added to the collection")
+	}
+}
+
+func TestCollectionEvictIdle(t *testing.T) {
+	collection := newBloomCollection(t, time.Hour)
+	defer collection.Close()
+	now := time.Now()
+	collection.now = func() time.Time { return now }
+	evictedNames := []string{}
+	collection.OnEvict(func(name string, member *BloomFilter) {
+		evictedNames = append(evictedNames, name)
+	})
+	collection.GetOrCreate("alice")
+	collection.GetOrCreate("bob")
+	now = now.Add(40 * time.Minute)
+	collection.Get("bob")
+	now = now.Add(40 * time.Minute)
+	evicted, err := collection.EvictIdle()
+	if err != nil {
+		t.Fatalf("eviction shouldn't error out, error: %v", err)
+	}
+	if evicted != 1 || len(evictedNames) != 1 || evictedNames[0] != "alice" {
+		t.Errorf("only alice should be evicted, got %d evictions of %v", evicted, evictedNames)
+	}
+	if _, ok := collection.Get("bob"); !ok {
+		t.Errorf("bob should still be a member of the collection")
+	}
+}
+
+func TestCollectionSnapshotRestore(t *testing.T) {
+	collection := newBloomCollection(t, 0)
+	defer collection.Close()
+	alice, _ := collection.GetOrCreate("alice")
+	alice.InsertString("foo")
+	bob, _ := collection.GetOrCreate("bob")
+	bob.InsertString("bar")
+	data, err := collection.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot shouldn't error out, error: %v", err)
+	}
+
+	restored := newBloomCollection(t, 0)
+	defer restored.Close()
+	err = restored.Restore(data, func(data []byte) (*BloomFilter, error) {
+		filter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+		return filter, filter.Import(data)
+	})
+	if err != nil {
+		t.Fatalf("restore shouldn't error out, error: %v", err)
+	}
+	if restored.Len() != 2 {
+		t.Errorf("restored collection should have 2 members, got %d", restored.Len())
+	}
+	restoredAlice, _ := restored.Get("alice")
+	restoredBob, _ := restored.Get("bob")
+	if ok, _ := alice.Equals(restoredAlice); !ok {
+		t.Errorf("alice should be equal after restore")
+	}
+	if ok, _ := bob.Equals(restoredBob); !ok {
+		t.Errorf("bob should be equal after restore")
+	}
+}
+
+func TestCollectionClosed(t *testing.T) {
+	collection := newBloomCollection(t, time.Millisecond)
+	collection.GetOrCreate("alice")
+	if err := collection.Close(); err != nil {
+		t.Errorf("close shouldn't error out, error: %v", err)
+	}
+	if err := collection.Close(); err != nil {
+		t.Errorf("second close shouldn't error out, error: %v", err)
+	}
+	if _, err := collection.GetOrCreate("alice"); err == nil {
+		t.Errorf("GetOrCreate should error out on a closed collection")
+	}
+}
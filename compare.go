@@ -0,0 +1,76 @@
+package gostatix
+
+import "fmt"
+
+// MismatchReason tells why two data structures compared with Compare aren't equal
+type MismatchReason int
+
+const (
+	// NoMismatch is the reason of data structures which are equal
+	NoMismatch MismatchReason = iota
+	// ParameterMismatch is the reason of data structures created with different parameters,
+	// like the size of a filter or the _k_ of a TopK
+	ParameterMismatch
+	// ContentMismatch is the reason of data structures with the same parameters but
+	// different data
+	ContentMismatch
+)
+
+// String returns the name of the MismatchReason
+func (reason MismatchReason) String() string {
+	switch reason {
+	case NoMismatch:
+		return "no mismatch"
+	case ParameterMismatch:
+		return "parameter mismatch"
+	case ContentMismatch:
+		return "content mismatch"
+	default:
+		return fmt.Sprintf("MismatchReason(%d)", int(reason))
+	}
+}
+
+// Comparison is the result of the comparison of two data structures of the same type.
+// _Reason_ is NoMismatch if they are equal
+// _Detail_ describes the mismatch, like the parameter which differs
+// Data structures with different parameters aren't equal, Compare and Equals only return
+// an error when the data structures can't be read, e.g. on a Redis failure.
+type Comparison struct {
+	Reason MismatchReason
+	Detail string
+}
+
+// Equal returns true if the compared data structures are equal
+func (comparison Comparison) Equal() bool {
+	return comparison.Reason == NoMismatch
+}
+
+// String returns the reason and the detail of the Comparison
+func (comparison Comparison) String() string {
+	if comparison.Detail == "" {
+		return comparison.Reason.String()
+	}
+	return comparison.Reason.String() + ": " + comparison.Detail
+}
+
+// equalComparison is the Comparison of equal data structures
+var equalComparison = Comparison{Reason: NoMismatch}
+
+// parameterMismatch returns the Comparison of data structures whose parameter _name_ is
+// _a_ and _b_ respectively
+func parameterMismatch(name string, a, b interface{}) Comparison {
+	return Comparison{ParameterMismatch, fmt.Sprintf("%s %v and %v", name, a, b)}
+}
+
+// contentMismatch returns the Comparison of data structures whose _part_ differs
+func contentMismatch(part string) Comparison {
+	return Comparison{ContentMismatch, part + " differ"}
+}
+
+// compareResult returns the Comparison of data structures whose _part_ is equal if _ok_
+func compareResult(ok bool, part string) Comparison {
+	if ok {
+		return equalComparison
+	}
+	return contentMismatch(part)
+}
@@ -0,0 +1,74 @@
+package gostatix
+
+import "testing"
+
+// equal returns the result of an Equals call which shouldn't error out
+func equal(ok bool, err error) bool {
+	return ok && err == nil
+}
+
+func TestCompareParameterMismatch(t *testing.T) {
+	initMockRedis()
+	aTopK, _ := NewTopK(3, 0.001, 0.999)
+	bTopK, _ := NewTopK(5, 0.001, 0.999)
+	comparison, err := aTopK.Compare(bTopK)
+	if err != nil || comparison.Equal() || comparison.Reason != ParameterMismatch {
+		t.Errorf("topks with different k should have a parameter mismatch, got %v, error: %v", comparison, err)
+	}
+	if comparison.String() != "parameter mismatch: k 3 and 5" {
+		t.Errorf("unexpected comparison %q", comparison.String())
+	}
+	if ok, err := aTopK.Equals(bTopK); ok || err != nil {
+		t.Errorf("topks with different k shouldn't be equal without an error, error: %v", err)
+	}
+
+	aRedisTopK, _ := NewTopKRedis(3, 0.001, 0.999)
+	bRedisTopK, _ := NewTopKRedis(3, 0.01, 0.999)
+	if comparison, err := aRedisTopK.Compare(bRedisTopK); err != nil || comparison.Reason != ParameterMismatch {
+		t.Errorf("redis topks with different error rates should have a parameter mismatch, got %v, error: %v", comparison, err)
+	}
+
+	memFilter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	redisFilter, _ := NewRedisBloomFilterWithParameters(1000, 0.01)
+	if comparison, err := memFilter.Compare(redisFilter); err != nil || comparison.Reason != ParameterMismatch {
+		t.Errorf("bloom filters with different bitsets should have a parameter mismatch, got %v, error: %v", comparison, err)
+	}
+
+	aCMS, _ := NewCountMinSketch(3, 10)
+	bCMS, _ := NewCountMinSketch(4, 10)
+	if comparison, _ := aCMS.Compare(bCMS); comparison.Reason != ParameterMismatch {
+		t.Errorf("sketches with different rows should have a parameter mismatch, got %v", comparison)
+	}
+
+	aCuckoo, _ := NewCuckooFilterRedis(4, 2, 3)
+	bCuckoo, _ := NewCuckooFilterRedis(8, 2, 3)
+	if comparison, err := aCuckoo.Compare(bCuckoo); err != nil || comparison.Reason != ParameterMismatch {
+		t.Errorf("cuckoo filters with different sizes should have a parameter mismatch, got %v, error: %v", comparison, err)
+	}
+}
+
+func TestCompareContentMismatch(t *testing.T) {
+	aCuckoo, _ := NewCuckooFilter(4, 2, 3)
+	bCuckoo, _ := NewCuckooFilter(4, 2, 3)
+	aCuckoo.InsertString("foo", false)
+	if comparison, _ := aCuckoo.Compare(bCuckoo); comparison.Reason != ContentMismatch {
+		t.Errorf("cuckoo filters with different elements should have a content mismatch, got %v", comparison)
+	}
+
+	aHLL, _ := NewHyperLogLog(16)
+	bHLL, _ := NewHyperLogLog(16)
+	bHLL.registers[15] = 1
+	if equal(aHLL.Equals(bHLL)) {
+		t.Error("hyperloglogs with a different last register shouldn't be equal")
+	}
+
+	aTopK, _ := NewTopK(3, 0.001, 0.999)
+	bTopK, _ := NewTopK(3, 0.001, 0.999)
+	aTopK.InsertString("foo", 1)
+	if comparison, _ := aTopK.Compare(bTopK); comparison.Reason != ContentMismatch || comparison.Detail != "sketches differ" {
+		t.Errorf("topks with different elements should have a content mismatch, got %v", comparison)
+	}
+	if comparison, _ := aTopK.Compare(aTopK); !comparison.Equal() || comparison.String() != "no mismatch" {
+		t.Errorf("topk should be equal to itself, got %v", comparison)
+	}
+}
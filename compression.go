@@ -0,0 +1,266 @@
+/*
+Implements the optional compression of the snapshots returned by Export and of the streams
+written by WriteTo, which Import and ReadFrom detect and decompress transparently.
+*/
+package gostatix
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// Compression is the algorithm compressing the snapshots of a Compressed data structure
+// or the streams of a writer returned by NewCompressedWriter
+type Compression uint8
+
+const (
+	// NoCompression leaves the snapshots uncompressed
+	NoCompression Compression = iota
+	// GzipCompression compresses the snapshots with gzip
+	GzipCompression
+	// ZstdCompression compresses the snapshots with Zstandard. gostatix doesn't depend on a
+	// zstd implementation, one has to be registered with RegisterCompressor first.
+	ZstdCompression
+)
+
+// String returns the name of the compression
+func (compression Compression) String() string {
+	switch compression {
+	case NoCompression:
+		return "none"
+	case GzipCompression:
+		return "gzip"
+	case ZstdCompression:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Compression(%d)", uint8(compression))
+	}
+}
+
+// Compressor creates the compressing writers and the decompressing readers of a
+// Compression
+type Compressor interface {
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// gzipCompressor is the Compressor of GzipCompression
+type gzipCompressor struct{}
+
+func (gzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	reader, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	reader.Multistream(false)
+	return reader, nil
+}
+
+var (
+	compressorsLock sync.RWMutex
+	compressors     = map[Compression]Compressor{GzipCompression: gzipCompressor{}}
+)
+
+// compressionMagics are the first bytes of the streams of each compression. None of the
+// snapshots of gostatix start with them: the JSON snapshots start with a brace, the
+// portable snapshots and the bundles with their magic and the binary streams written by
+// WriteTo with a big endian size whose first byte is 0.
+var compressionMagics = map[Compression][]byte{
+	GzipCompression: {0x1f, 0x8b},
+	ZstdCompression: {0x28, 0xb5, 0x2f, 0xfd},
+}
+
+// compressionMagicSize is the length of the longest compression magic
+const compressionMagicSize = 4
+
+// RegisterCompressor sets the Compressor of _compression_, e.g. a zstd implementation for
+// ZstdCompression or a faster gzip implementation for GzipCompression. It should be
+// called at the start of a program, before any snapshot is compressed or decompressed.
+func RegisterCompressor(compression Compression, compressor Compressor) error {
+	if _, ok := compressionMagics[compression]; !ok {
+		return fmt.Errorf("gostatix: compressor can't be registered for compression %v", compression)
+	}
+	if compressor == nil {
+		return fmt.Errorf("gostatix: compressor of %v can't be nil", compression)
+	}
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+
+	compressors[compression] = compressor
+	return nil
+}
+
+// getCompressor returns the registered Compressor of _compression_
+func getCompressor(compression Compression) (Compressor, error) {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+
+	compressor, ok := compressors[compression]
+	if !ok {
+		return nil, fmt.Errorf("gostatix: no compressor registered for compression %v", compression)
+	}
+	return compressor, nil
+}
+
+// detectCompression returns the compression of the stream starting with _prefix_
+func detectCompression(prefix []byte) Compression {
+	for compression, magic := range compressionMagics {
+		if bytes.HasPrefix(prefix, magic) {
+			return compression
+		}
+	}
+	return NoCompression
+}
+
+// nopWriteCloser is an io.WriteCloser whose Close does nothing
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// NewCompressedWriter returns a writer compressing the data written to it with
+// _compression_ onto _w_, e.g. to pass to the WriteTo method of a data structure. It must
+// be closed to flush the compressed stream, which doesn't close _w_. ReadFrom decompresses
+// the stream transparently, provided it holds a single data structure.
+func NewCompressedWriter(w io.Writer, compression Compression) (io.WriteCloser, error) {
+	if compression == NoCompression {
+		return nopWriteCloser{w}, nil
+	}
+	compressor, err := getCompressor(compression)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := compressor.NewWriter(w)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while creating %v writer, error: %v", compression, err)
+	}
+	return writer, nil
+}
+
+// compressSnapshot returns _data_ compressed with _compression_
+func compressSnapshot(data []byte, compression Compression) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := NewCompressedWriter(&buf, compression)
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(data)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while compressing snapshot with %v, error: %v", compression, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressSnapshot returns the snapshot _data_ decompressed if it's compressed, or
+// _data_ itself if it isn't. The decompressed snapshot can't exceed MaxSnapshotSize.
+func decompressSnapshot(data []byte) ([]byte, error) {
+	compression := detectCompression(data)
+	if compression == NoCompression {
+		return data, nil
+	}
+	compressor, err := getCompressor(compression)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := compressor.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while decompressing %v snapshot, error: %v", compression, err)
+	}
+	defer reader.Close()
+	decompressed, err := io.ReadAll(io.LimitReader(reader, int64(minUint64(MaxSnapshotSize, math.MaxInt64-1))+1))
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while decompressing %v snapshot, error: %v", compression, err)
+	}
+	if uint64(len(decompressed)) > MaxSnapshotSize {
+		return nil, fmt.Errorf("gostatix: decompressed snapshot exceeds the maximum snapshot size of %d bytes", MaxSnapshotSize)
+	}
+	return decompressed, nil
+}
+
+// decompressStream returns a reader decompressing _stream_ if it's compressed, or reading
+// _stream_ itself if it isn't. The first bytes of _stream_ are read to detect its
+// compression, a decompressing reader may read past the end of the compressed data.
+func decompressStream(stream io.Reader) (io.ReadCloser, error) {
+	prefix := make([]byte, compressionMagicSize)
+	n, err := io.ReadFull(stream, prefix)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	prefix = prefix[:n]
+	replayed := io.MultiReader(bytes.NewReader(prefix), stream)
+	compression := detectCompression(prefix)
+	if compression == NoCompression {
+		return io.NopCloser(replayed), nil
+	}
+	compressor, err := getCompressor(compression)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := compressor.NewReader(replayed)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while decompressing %v stream, error: %v", compression, err)
+	}
+	return reader, nil
+}
+
+// Compressed wraps a data structure whose snapshots are compressed, e.g. to save it with
+// a Snapshotter or to add it to a Bundle. Import decompresses the snapshots transparently,
+// like the Import methods of the data structures.
+type Compressed struct {
+	structure   Snapshottable
+	compression Compression
+}
+
+var _ Snapshottable = (*Compressed)(nil)
+
+// NewCompressed wraps _structure_ so that its exports are compressed with _compression_
+func NewCompressed(structure Snapshottable, compression Compression) (*Compressed, error) {
+	if structure == nil {
+		return nil, fmt.Errorf("gostatix: compressed snapshots need a data structure")
+	}
+	if compression != NoCompression {
+		_, err := getCompressor(compression)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Compressed{structure, compression}, nil
+}
+
+// Unwrap returns the wrapped data structure
+func (c *Compressed) Unwrap() Snapshottable {
+	return c.structure
+}
+
+// Export exports the data structure and compresses the snapshot
+func (c *Compressed) Export() ([]byte, error) {
+	data, err := c.structure.Export()
+	if err != nil {
+		return nil, err
+	}
+	return compressSnapshot(data, c.compression)
+}
+
+// Import decompresses _data_ if it's compressed, with any of the registered compressions,
+// and imports it into the data structure
+func (c *Compressed) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
+	return c.structure.Import(data)
+}
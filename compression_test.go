@@ -0,0 +1,113 @@
+package gostatix
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strconv"
+	"testing"
+)
+
+// flateCompressor stands in for a zstd Compressor, writing raw deflate streams after the
+// zstd magic
+type flateCompressor struct{}
+
+func (flateCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	w.Write(compressionMagics[ZstdCompression])
+	return flate.NewWriter(w, flate.BestSpeed)
+}
+
+func (flateCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	magic := make([]byte, compressionMagicSize)
+	_, err := io.ReadFull(r, magic)
+	if err != nil {
+		return nil, err
+	}
+	return flate.NewReader(r), nil
+}
+
+func TestCompressedExport(t *testing.T) {
+	sketch, _ := NewCountMinSketch(4, 2000)
+	for i := 0; i < 100; i++ {
+		sketch.UpdateString(strconv.Itoa(i), 1)
+	}
+	plain, _ := sketch.Export()
+	compressed, err := NewCompressed(sketch, GzipCompression)
+	if err != nil {
+		t.Fatalf("gzip compression shouldn't error out, error: %v", err)
+	}
+	data, err := compressed.Export()
+	if err != nil || len(data) >= len(plain)/4 {
+		t.Fatalf("export should compress the sparse sketch, got %d bytes of %d, error: %v", len(data), len(plain), err)
+	}
+	restored, _ := NewCountMinSketch(1, 1)
+	if err := restored.Import(data); err != nil {
+		t.Fatalf("import should detect the compression, error: %v", err)
+	}
+	if equal, _ := restored.Equals(sketch); !equal {
+		t.Errorf("decompressed sketch should equal the exported one")
+	}
+	if err := restored.Import(data[:len(data)/2]); err == nil {
+		t.Errorf("truncated compressed snapshot should error out")
+	}
+	saved := MaxSnapshotSize
+	MaxSnapshotSize = uint64(len(plain) - 1)
+	if err := restored.Import(data); err == nil {
+		t.Errorf("decompressed snapshot larger than the maximum size should error out")
+	}
+	MaxSnapshotSize = saved
+
+	if _, err := NewCompressed(sketch, ZstdCompression); err == nil {
+		t.Errorf("zstd compression without registered compressor should error out")
+	}
+	if err := RegisterCompressor(NoCompression, flateCompressor{}); err == nil {
+		t.Errorf("registering a compressor for no compression should error out")
+	}
+	RegisterCompressor(ZstdCompression, flateCompressor{})
+	defer func() {
+		compressorsLock.Lock()
+		delete(compressors, ZstdCompression)
+		compressorsLock.Unlock()
+	}()
+	compressed, _ = NewCompressed(sketch, ZstdCompression)
+	data, _ = compressed.Export()
+	restored, _ = NewCountMinSketch(1, 1)
+	if err := restored.Import(data); err != nil {
+		t.Fatalf("import should detect the registered compression, error: %v", err)
+	}
+	if equal, _ := restored.Equals(sketch); !equal {
+		t.Errorf("decompressed sketch should equal the exported one")
+	}
+}
+
+func TestCompressedWriteTo(t *testing.T) {
+	filter, _ := NewMemBloomFilterWithParameters(10000, 0.01)
+	filter.InsertString("foo")
+	var plain, buf bytes.Buffer
+	filter.WriteTo(&plain)
+	writer, err := NewCompressedWriter(&buf, GzipCompression)
+	if err != nil {
+		t.Fatalf("gzip writer shouldn't error out, error: %v", err)
+	}
+	filter.WriteTo(writer)
+	writer.Close()
+	if buf.Len() >= plain.Len()/4 {
+		t.Errorf("stream should be compressed, got %d bytes of %d", buf.Len(), plain.Len())
+	}
+	restored, _ := NewMemBloomFilterWithParameters(1, 0.5)
+	if _, err := restored.ReadFrom(&buf); err != nil {
+		t.Fatalf("read should detect the compression, error: %v", err)
+	}
+	if equal, _ := restored.Equals(filter); !equal || !restored.LookupString("foo") {
+		t.Errorf("decompressed filter should equal the written one")
+	}
+
+	// uncompressed streams of several data structures are still read one after the other
+	other, _ := NewMemBloomFilterWithParameters(100, 0.01)
+	other.InsertString("bar")
+	other.WriteTo(&plain)
+	restored.ReadFrom(&plain)
+	if _, err := restored.ReadFrom(&plain); err != nil || !restored.LookupString("bar") {
+		t.Errorf("second filter of the stream should be read, error: %v", err)
+	}
+}
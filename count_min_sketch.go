@@ -14,24 +14,31 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"math"
 	"sync"
+	"unsafe"
+
+	"github.com/dgryski/go-metro"
+	"github.com/kwertop/gostatix/internal/util"
 )
 
 // CountMinSketch struct. This is an in-memory implementation of Count-Min Sketch.
 // It's mainly governed by a 2-d slice _matrix_ which holds the count of hashed items
 // at different hashed locations
 // _lock_ is used to synchronize concurrent read/writes
+// _updated_ records the time of the last update
 type CountMinSketch struct {
 	AbstractCountMinSketch
-	matrix [][]uint64
-	lock   sync.RWMutex
+	matrix    [][]uint64
+	lock      sync.RWMutex
+	resources resources
+	updated   updateClock
 }
 
 // NewCountMinSketch creates CountMinSketch with _rows_ and _columns_
 func NewCountMinSketch(rows, columns uint) (*CountMinSketch, error) {
-	if rows <= 0 || columns <= 0 {
-		return nil, fmt.Errorf("gostatix: rows and columns size should be greater than 0")
+	err := CountMinSketchParams{rows, columns}.Validate()
+	if err != nil {
+		return nil, err
 	}
 	abstractSketch := makeAbstractCountMinSketch(rows, columns, 0)
 	matrix := make([][]uint64, rows)
@@ -46,9 +53,11 @@ func NewCountMinSketch(rows, columns uint) (*CountMinSketch, error) {
 // _errorRate_ and _delta_
 // rows and columns are calculated based upon these supplied values
 func NewCountMinSketchFromEstimates(errorRate, delta float64) (*CountMinSketch, error) {
-	columns := uint(math.Ceil(math.E / errorRate))
-	rows := uint(math.Ceil(math.Log(1 / delta)))
-	return NewCountMinSketch(rows, columns)
+	params, err := CountMinSketchParamsFromEstimates(errorRate, delta)
+	if err != nil {
+		return nil, err
+	}
+	return NewCountMinSketch(params.Rows, params.Columns)
 }
 
 // UpdateOnce increments the count of _data_ in Count-Min Sketch by 1
@@ -60,11 +69,79 @@ func (cms *CountMinSketch) UpdateOnce(data []byte) {
 func (cms *CountMinSketch) Update(data []byte, count uint64) {
 	cms.lock.Lock()
 	defer cms.lock.Unlock()
+	cms.updated.touch()
+
+	hash1, hash2 := metro.Hash128(data, 1373)
+	for r := range cms.matrix {
+		cms.matrix[r][cms.getPosition(hash1, hash2, uint(r))] += count
+	}
+	cms.allSum += count
+}
+
+// updateAndCount increments the count of _data_ by _count_ like Update and returns its
+// estimated count after the increment, hashing _data_ once under a single lock
+func (cms *CountMinSketch) updateAndCount(data []byte, count uint64) uint64 {
+	hash1, hash2 := metro.Hash128(data, 1373)
+	return cms.updateAndCountHashes(hash1, hash2, count)
+}
+
+// updateAndCountHashes is updateAndCount for the element whose hashes are _hash1_ and _hash2_
+func (cms *CountMinSketch) updateAndCountHashes(hash1, hash2, count uint64) uint64 {
+	cms.lock.Lock()
+	defer cms.lock.Unlock()
+	cms.updated.touch()
 
-	for r, c := range cms.getPositions(data) {
+	var min uint64
+	for r := range cms.matrix {
+		c := cms.getPosition(hash1, hash2, uint(r))
 		cms.matrix[r][c] += count
+		if r == 0 || cms.matrix[r][c] < min {
+			min = cms.matrix[r][c]
+		}
 	}
 	cms.allSum += count
+	return min
+}
+
+// updateHashes increments the counts of the elements whose hashes (see getHashes) are
+// _hashes_ by _count_ under a single lock, without hashing them again
+func (cms *CountMinSketch) updateHashes(hashes [][4]uint64, count uint64) {
+	cms.lock.Lock()
+	defer cms.lock.Unlock()
+	cms.updated.touch()
+
+	for _, hash := range hashes {
+		for r := range cms.matrix {
+			cms.matrix[r][cms.getPosition(hash[0], hash[1], uint(r))] += count
+		}
+		cms.allSum += count
+	}
+}
+
+// decrement decreases the count of _data_ (byte slice) in Count-Min Sketch by value _count_
+// passed, or by its estimated count if it's smaller so that no counter goes below zero.
+// It returns the estimated count of _data_ after the decrement.
+func (cms *CountMinSketch) decrement(data []byte, count uint64) uint64 {
+	cms.lock.Lock()
+	defer cms.lock.Unlock()
+	cms.updated.touch()
+
+	hash1, hash2 := metro.Hash128(data, 1373)
+	var estimate uint64
+	for r := range cms.matrix {
+		c := cms.getPosition(hash1, hash2, uint(r))
+		if r == 0 || cms.matrix[r][c] < estimate {
+			estimate = cms.matrix[r][c]
+		}
+	}
+	if count > estimate {
+		count = estimate
+	}
+	for r := range cms.matrix {
+		cms.matrix[r][cms.getPosition(hash1, hash2, uint(r))] -= count
+	}
+	cms.allSum -= count
+	return estimate - count
 }
 
 // UpdateString increments the count of _data_ (string) in Count-Min Sketch by value _count_ passed
@@ -72,13 +149,56 @@ func (cms *CountMinSketch) UpdateString(data string, count uint64) {
 	cms.Update([]byte(data), count)
 }
 
+// UpdateUint64 increments the count of _data_ (unsigned integer) in Count-Min Sketch by value _count_ passed.
+// The integer is hashed as its 8 byte big endian encoding.
+func (cms *CountMinSketch) UpdateUint64(data uint64, count uint64) {
+	cms.Update(uint64Bytes(data), count)
+}
+
+// UpdateKey increments the count of _data_ (Key) in Count-Min Sketch by value _count_ passed
+func (cms *CountMinSketch) UpdateKey(data Key, count uint64) {
+	cms.Update(data.KeyBytes(), count)
+}
+
+// UpdateFromReader streams keys separated by _delim_ from _stream_ and increments the
+// count of each of them by 1 without holding all of them in memory.
+// _progress_ (optional, can be nil) is called periodically with the number of keys processed.
+// It returns the total number of keys processed.
+func (cms *CountMinSketch) UpdateFromReader(stream io.Reader, delim byte, progress ProgressFunc) (uint64, error) {
+	return util.ReadKeys(stream, delim, progress, func(key []byte) error {
+		cms.Update(key, 1)
+		return nil
+	})
+}
+
+// UpdateFromReaderParallel is UpdateFromReader spreading the keys over _workers_ goroutines,
+// GOMAXPROCS if _workers_ is 0. Each worker counts its keys in a sketch of its own, which
+// are merged in the Count-Min Sketch once the stream is exhausted, so the load takes
+// _workers_ times the memory of the sketch. The keys read before an error are merged too.
+func (cms *CountMinSketch) UpdateFromReaderParallel(stream io.Reader, delim byte, workers int, progress ProgressFunc) (uint64, error) {
+	shards := make([]*CountMinSketch, parallelWorkers(workers))
+	for i := range shards {
+		shards[i], _ = NewCountMinSketch(cms.rows, cms.columns)
+	}
+	count, err := readKeysParallel(stream, delim, len(shards), progress, func(worker int, key []byte) {
+		shards[worker].Update(key, 1)
+	})
+	mergeErr := MergeCountMinSketches(cms, shards)
+	if err == nil {
+		err = mergeErr
+	}
+	return count, err
+}
+
 // Count estimates the count of the _data_ (byte slice) in the Count-Min Sketch data structure
 func (cms *CountMinSketch) Count(data []byte) uint64 {
-	cms.lock.Lock()
-	defer cms.lock.Unlock()
+	cms.lock.RLock()
+	defer cms.lock.RUnlock()
 
 	var min uint64
-	for r, c := range cms.getPositions(data) {
+	hash1, hash2 := metro.Hash128(data, 1373)
+	for r := range cms.matrix {
+		c := cms.getPosition(hash1, hash2, uint(r))
 		if r == 0 || cms.matrix[r][c] < min {
 			min = cms.matrix[r][c]
 		}
@@ -91,6 +211,24 @@ func (cms *CountMinSketch) CountString(data string) uint64 {
 	return cms.Count([]byte(data))
 }
 
+// CountUint64 estimates the count of the _data_ (unsigned integer) in the Count-Min Sketch
+func (cms *CountMinSketch) CountUint64(data uint64) uint64 {
+	return cms.Count(uint64Bytes(data))
+}
+
+// CountKey estimates the count of the _data_ (Key) in the Count-Min Sketch
+func (cms *CountMinSketch) CountKey(data Key) uint64 {
+	return cms.Count(data.KeyBytes())
+}
+
+// TotalCount returns the sum of all the counts added to the Count-Min Sketch so far
+func (cms *CountMinSketch) TotalCount() uint64 {
+	cms.lock.RLock()
+	defer cms.lock.RUnlock()
+
+	return cms.allSum
+}
+
 // internal type used to marshal/unmarshal Count-Min Sketch
 type countMinSketchJSON struct {
 	Rows    uint       `json:"r"`
@@ -100,18 +238,70 @@ type countMinSketchJSON struct {
 	Key     string     `json:"k"`
 }
 
+// Reset sets all the counts of the Count-Min Sketch to zero, keeping its dimensions
+// and the memory of its matrix
+func (cms *CountMinSketch) Reset() {
+	cms.lock.Lock()
+	defer cms.lock.Unlock()
+	cms.updated.touch()
+
+	for i := range cms.matrix {
+		for j := range cms.matrix[i] {
+			cms.matrix[i][j] = 0
+		}
+	}
+	cms.allSum = 0
+}
+
+// MemoryUsage returns the estimated number of bytes used in-process by the Count-Min Sketch
+func (cms *CountMinSketch) MemoryUsage() uint64 {
+	cms.lock.RLock()
+	defer cms.lock.RUnlock()
+
+	bytes := uint64(unsafe.Sizeof(*cms)) + uint64(cap(cms.matrix))*sliceSize
+	for i := range cms.matrix {
+		bytes += uint64(cap(cms.matrix[i])) * 8
+	}
+	return bytes
+}
+
+// Close releases the resources attached to the CountMinSketch.
+// It's safe to call Close multiple times.
+func (cms *CountMinSketch) Close() error {
+	return cms.resources.close()
+}
+
 // Export JSON marshals the CountMinSketch and returns a byte slice containing the data
 func (cms *CountMinSketch) Export() ([]byte, error) {
+	cms.lock.RLock()
+	defer cms.lock.RUnlock()
+
 	return json.Marshal(countMinSketchJSON{cms.rows, cms.columns, cms.allSum, cms.matrix, ""})
 }
 
-// Import JSON unmarshals the _data_ into the CountMinSketch
+// Import JSON unmarshals the _data_ into the CountMinSketch, or decodes it if it is a portable
+// snapshot written by ExportPortable
 func (cms *CountMinSketch) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
+	if isPortable(data) {
+		return cms.importPortable(data)
+	}
 	var s countMinSketchJSON
-	err := json.Unmarshal(data, &s)
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	err = s.validate()
 	if err != nil {
 		return err
 	}
+	cms.lock.Lock()
+	defer cms.lock.Unlock()
+	cms.updated.touch()
+
 	cms.rows = s.Rows
 	cms.columns = s.Columns
 	cms.allSum = s.AllSum
@@ -119,22 +309,72 @@ func (cms *CountMinSketch) Import(data []byte) error {
 	return nil
 }
 
-// Equals checks if two CountMinSketch are equal
-func (cms *CountMinSketch) Equals(cms1 *CountMinSketch) bool {
-	if cms.rows != cms1.rows && cms.columns != cms1.columns {
-		return false
+// validate checks that the matrix of the decoded snapshot has _Rows_ rows of
+// _Columns_ columns each
+func (s *countMinSketchJSON) validate() error {
+	err := checkCountMinSketchParams(uint64(s.Rows), uint64(s.Columns))
+	if err != nil {
+		return err
+	}
+	if uint(len(s.Matrix)) != s.Rows {
+		return fmt.Errorf("gostatix: invalid count-min sketch snapshot, %d rows found instead of %d", len(s.Matrix), s.Rows)
+	}
+	for i := range s.Matrix {
+		if uint(len(s.Matrix[i])) != s.Columns {
+			return fmt.Errorf("gostatix: invalid count-min sketch snapshot, %d columns found in row %d instead of %d", len(s.Matrix[i]), i, s.Columns)
+		}
+	}
+	return nil
+}
+
+// checkCountMinSketchParams validates the _rows_ and _columns_ of a decoded snapshot
+func checkCountMinSketchParams(rows, columns uint64) error {
+	err := CountMinSketchParams{uint(rows), uint(columns)}.Validate()
+	if err != nil {
+		return fmt.Errorf("gostatix: invalid count-min sketch snapshot, error: %v", err)
+	}
+	err = checkSnapshotSize("count-min sketch row", columns, 8)
+	if err != nil {
+		return err
+	}
+	return checkSnapshotSize("count-min sketch", rows, columns*8)
+}
+
+// Equals checks if two CountMinSketch are equal. Sketches with different number of rows
+// or columns aren't equal.
+func (cms *CountMinSketch) Equals(cms1 *CountMinSketch) (bool, error) {
+	comparison, err := cms.Compare(cms1)
+	return comparison.Equal(), err
+}
+
+// Compare compares two CountMinSketch and returns why they aren't equal, if they aren't
+func (cms *CountMinSketch) Compare(cms1 *CountMinSketch) (Comparison, error) {
+	if cms.rows != cms1.rows {
+		return parameterMismatch("rows", cms.rows, cms1.rows), nil
 	}
+	if cms.columns != cms1.columns {
+		return parameterMismatch("columns", cms.columns, cms1.columns), nil
+	}
+	if cms == cms1 {
+		return equalComparison, nil
+	}
+	cms.lock.RLock()
+	defer cms.lock.RUnlock()
+	cms1.lock.RLock()
+	defer cms1.lock.RUnlock()
+
 	for i := range cms.matrix {
 		for j := range cms.matrix[i] {
 			if cms.matrix[i][j] != cms1.matrix[i][j] {
-				return false
+				return contentMismatch("matrices"), nil
 			}
 		}
 	}
-	return true
+	return equalComparison, nil
 }
 
-// Merge merges two Count-Min Sketch data structures
+// Merge merges two Count-Min Sketch data structures. The rows of large sketches are split
+// in chunks merged concurrently.
 func (cms *CountMinSketch) Merge(cms1 *CountMinSketch) error {
 	if cms.rows != cms1.rows {
 		return fmt.Errorf("gostatix: can't merge sketches with unequal row counts, %d and %d", cms.rows, cms1.rows)
@@ -142,18 +382,47 @@ func (cms *CountMinSketch) Merge(cms1 *CountMinSketch) error {
 	if cms.columns != cms1.columns {
 		return fmt.Errorf("gostatix: can't merge sketches with unequal column counts, %d and %d", cms.columns, cms1.columns)
 	}
+	cms1.lock.RLock()
+	matrix := make([][]uint64, len(cms1.matrix))
+	for i := range matrix {
+		matrix[i] = append([]uint64(nil), cms1.matrix[i]...)
+	}
+	allSum := cms1.allSum
+	cms1.lock.RUnlock()
+	cms.lock.Lock()
+	defer cms.lock.Unlock()
+	cms.updated.touch()
+
 	for i := range cms.matrix {
-		for j := range cms.matrix[i] {
-			cms.matrix[i][j] += cms1.matrix[i][j]
-		}
+		addCounters(cms.matrix[i], matrix[i])
 	}
+	cms.allSum += allSum
 	return nil
 }
 
 // WriteTo writes the CountMinSketch onto the specified _stream_ and returns the
 // number of bytes written.
 // It can be used to write to disk (using a file stream) or to network.
+// The stream ends with the checksum of the sketch, verified by ReadFrom.
 func (cms *CountMinSketch) WriteTo(stream io.Writer) (int64, error) {
+	checksum := newChecksumWriter(stream)
+	numBytes, err := cms.writeTo(checksum)
+	if err != nil {
+		return 0, err
+	}
+	err = checksum.writeTrailer()
+	if err != nil {
+		return 0, err
+	}
+	return numBytes + checksumTrailerSize, nil
+}
+
+// writeTo writes the CountMinSketch onto _stream_ without checksum, e.g. within the
+// stream of a TopK
+func (cms *CountMinSketch) writeTo(stream io.Writer) (int64, error) {
+	cms.lock.RLock()
+	defer cms.lock.RUnlock()
+
 	err := binary.Write(stream, binary.BigEndian, uint64(cms.rows))
 	if err != nil {
 		return 0, err
@@ -182,7 +451,21 @@ func (cms *CountMinSketch) WriteTo(stream io.Writer) (int64, error) {
 // ReadFrom reads the CountMinSketch from the specified _stream_ and returns the
 // number of bytes read.
 // It can be used to read from disk (using a file stream) or from network.
+// A stream cut short or which doesn't match its checksum errors out with an
+// ErrCorruptSnapshot and leaves the sketch unchanged.
 func (cms *CountMinSketch) ReadFrom(stream io.Reader) (int64, error) {
+	decompressed, err := decompressStream(stream)
+	if err != nil {
+		return 0, err
+	}
+	defer decompressed.Close()
+	checksum := newChecksumReader(decompressed)
+	return cms.readFrom(checksum, checksum)
+}
+
+// readFrom reads the CountMinSketch from _stream_. If _checksum_ isn't nil, the checksum
+// following the sketch is verified with it before the sketch is replaced.
+func (cms *CountMinSketch) readFrom(stream io.Reader, checksum *checksumReader) (int64, error) {
 	var rows, columns, allSum uint64
 	err := binary.Read(stream, binary.BigEndian, &rows)
 	if err != nil {
@@ -196,23 +479,34 @@ func (cms *CountMinSketch) ReadFrom(stream io.Reader) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	cms.rows = uint(rows)
-	cms.columns = uint(columns)
-	cms.allSum = allSum
-	cms.matrix = make([][]uint64, cms.rows)
-	for r := uint(0); r < cms.rows; r++ {
-		cms.matrix[r] = make([]uint64, cms.columns)
+	err = checkCountMinSketchParams(rows, columns)
+	if err != nil {
+		return 0, err
 	}
-
-	row := make([]uint64, cms.columns)
-	for r := uint(0); r < cms.rows; r++ {
-		err = binary.Read(stream, binary.BigEndian, &row)
+	// rows are appended as they are read so that a truncated stream doesn't
+	// allocate the whole matrix up front
+	var matrix [][]uint64
+	for r := uint64(0); r < rows; r++ {
+		row, err := readUint64s(stream, columns)
 		if err != nil {
 			return 0, err
 		}
-		for c := uint(0); c < cms.columns; c++ {
-			cms.matrix[r][c] = row[c]
+		matrix = append(matrix, row)
+	}
+	trailer := int64(0)
+	if checksum != nil {
+		trailer, err = checksum.verify()
+		if err != nil {
+			return 0, err
 		}
 	}
-	return int64(2*binary.Size(uint64(0)) + int(cms.rows)*binary.Size(row)), nil
+	cms.lock.Lock()
+	defer cms.lock.Unlock()
+	cms.updated.touch()
+
+	cms.rows = uint(rows)
+	cms.columns = uint(columns)
+	cms.allSum = allSum
+	cms.matrix = matrix
+	return int64(3*binary.Size(uint64(0))) + int64(rows*columns)*int64(binary.Size(uint64(0))) + trailer, nil
 }
@@ -0,0 +1,81 @@
+package gostatix
+
+import "fmt"
+
+// Positions returns the column of each row of the sketch counting _data_, so that custom
+// estimators can read and write the cells of an element with GetCell and SetCell
+func (cms *AbstractCountMinSketch) Positions(data []byte) []uint {
+	return cms.getPositions(data)
+}
+
+// checkCell returns an error if the cell at _row_ and _column_ is outside of the sketch
+func (cms *AbstractCountMinSketch) checkCell(row, column uint) error {
+	if row >= cms.rows || column >= cms.columns {
+		return fmt.Errorf("gostatix: cell (%d, %d) is outside of the %dx%d count-min sketch", row, column, cms.rows, cms.columns)
+	}
+	return nil
+}
+
+// GetCell returns the counter at _row_ and _column_ of the Count-Min Sketch
+func (cms *CountMinSketch) GetCell(row, column uint) (uint64, error) {
+	if err := cms.checkCell(row, column); err != nil {
+		return 0, err
+	}
+	cms.lock.RLock()
+	defer cms.lock.RUnlock()
+
+	return cms.matrix[row][column], nil
+}
+
+// SetCell sets the counter at _row_ and _column_ of the Count-Min Sketch to _value_. The
+// total count isn't changed, see SetTotalCount. Each call takes the lock of the sketch,
+// so the estimators updating several cells at once, e.g. conservative updates, have to
+// synchronize their updates themselves.
+func (cms *CountMinSketch) SetCell(row, column uint, value uint64) error {
+	if err := cms.checkCell(row, column); err != nil {
+		return err
+	}
+	cms.lock.Lock()
+	defer cms.lock.Unlock()
+	cms.updated.touch()
+
+	cms.matrix[row][column] = value
+	return nil
+}
+
+// Row returns a copy of the counters of the row _row_ of the Count-Min Sketch
+func (cms *CountMinSketch) Row(row uint) ([]uint64, error) {
+	if row >= cms.rows {
+		return nil, fmt.Errorf("gostatix: row %d is outside of the %d rows of the count-min sketch", row, cms.rows)
+	}
+	cms.lock.RLock()
+	defer cms.lock.RUnlock()
+
+	return append([]uint64(nil), cms.matrix[row]...), nil
+}
+
+// SetTotalCount sets the sum of all the counts returned by TotalCount to _total_, for the
+// estimators updating the cells with SetCell
+func (cms *CountMinSketch) SetTotalCount(total uint64) {
+	cms.lock.Lock()
+	defer cms.lock.Unlock()
+	cms.updated.touch()
+
+	cms.allSum = total
+}
+
+// VisitCells calls _visit_ with the row, the column and the counter of every cell of the
+// Count-Min Sketch, row by row, until _visit_ returns false. The sketch is read locked
+// during the visit, so _visit_ mustn't update it.
+func (cms *CountMinSketch) VisitCells(visit func(row, column uint, value uint64) bool) {
+	cms.lock.RLock()
+	defer cms.lock.RUnlock()
+
+	for r, row := range cms.matrix {
+		for c, value := range row {
+			if !visit(uint(r), uint(c), value) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package gostatix
+
+import (
+	"strconv"
+	"testing"
+)
+
+// conservativeUpdate increments the counters of _data_ in _cms_ like a conservative update
+// sketch, only raising the counters below the new estimate
+func conservativeUpdate(cms *CountMinSketch, data []byte, count uint64) {
+	positions := cms.Positions(data)
+	estimate := cms.Count(data) + count
+	for r, c := range positions {
+		if value, _ := cms.GetCell(uint(r), c); value < estimate {
+			cms.SetCell(uint(r), c, estimate)
+		}
+	}
+	cms.SetTotalCount(cms.TotalCount() + count)
+}
+
+func TestCountMinSketchCells(t *testing.T) {
+	cms, _ := NewCountMinSketch(4, 20)
+	plain, _ := NewCountMinSketch(4, 20)
+	for i := 0; i < 500; i++ {
+		element := []byte(strconv.Itoa(i % 50))
+		conservativeUpdate(cms, element, 1)
+		plain.Update(element, 1)
+	}
+	if cms.TotalCount() != 500 {
+		t.Errorf("total count should be 500, got %d", cms.TotalCount())
+	}
+	for i := 0; i < 50; i++ {
+		element := []byte(strconv.Itoa(i))
+		if count := cms.Count(element); count < 10 || count > plain.Count(element) {
+			t.Errorf("conservative count of %d should be between 10 and %d, got %d", i, plain.Count(element), count)
+		}
+	}
+
+	row, err := cms.Row(2)
+	if err != nil || len(row) != 20 {
+		t.Fatalf("row should have 20 columns, got %d, error: %v", len(row), err)
+	}
+	row[0]++
+	if value, _ := cms.GetCell(2, 0); value == row[0] {
+		t.Errorf("row should be a copy of the counters")
+	}
+	cells := 0
+	cms.VisitCells(func(r, c uint, value uint64) bool {
+		if cell, _ := cms.GetCell(r, c); cell != value {
+			t.Errorf("visited value of cell (%d, %d) should be %d, got %d", r, c, cell, value)
+		}
+		cells++
+		return true
+	})
+	if cells != 80 {
+		t.Errorf("visit should go over 80 cells, got %d", cells)
+	}
+	visited := 0
+	cms.VisitCells(func(r, c uint, value uint64) bool {
+		visited++
+		return visited < 5
+	})
+	if visited != 5 {
+		t.Errorf("visit should stop when the visitor returns false, visited %d cells", visited)
+	}
+
+	if _, err := cms.GetCell(4, 0); err == nil {
+		t.Errorf("cell outside of the rows should error out")
+	}
+	if err := cms.SetCell(0, 20, 1); err == nil {
+		t.Errorf("cell outside of the columns should error out")
+	}
+	if _, err := cms.Row(4); err == nil {
+		t.Errorf("row outside of the sketch should error out")
+	}
+}
@@ -0,0 +1,172 @@
+package gostatix
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/dgryski/go-metro"
+)
+
+// CountMinSketchKV is the implementation of Count-Min Sketch backed by a KVStore
+// _store_ is the KVStore holding the sketch
+// _name_ prefixes the keys of the sketch in the store: the parameters are at _name_, the
+// total count at _name_:total and the counter of row r and column c at _name_:r:c
+// The counters are big endian uint64, a missing counter being 0, and every operation runs
+// in a single transaction of the store.
+type CountMinSketchKV struct {
+	AbstractCountMinSketch
+	store KVStore
+	name  string
+}
+
+// NewCountMinSketchKV creates a CountMinSketchKV named _name_ in _store_ with _rows_ and
+// _columns_, or opens it if it already exists, in which case it should have been created
+// with the same parameters
+func NewCountMinSketchKV(store KVStore, name string, rows, columns uint) (*CountMinSketchKV, error) {
+	if store == nil || name == "" {
+		return nil, fmt.Errorf("gostatix: a store and a name are required for a kv backed count-min sketch")
+	}
+	err := CountMinSketchParams{rows, columns}.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = kvInitParams(store, []byte(name), "count-min sketch", []uint64{uint64(rows), uint64(columns)})
+	if err != nil {
+		return nil, err
+	}
+	abstractSketch := makeAbstractCountMinSketch(rows, columns, 0)
+	return &CountMinSketchKV{AbstractCountMinSketch: *abstractSketch, store: store, name: name}, nil
+}
+
+// NewCountMinSketchKVFromEstimates creates or opens a CountMinSketchKV based upon the
+// desired _errorRate_ and _delta_
+func NewCountMinSketchKVFromEstimates(store KVStore, name string, errorRate, delta float64) (*CountMinSketchKV, error) {
+	params, err := CountMinSketchParamsFromEstimates(errorRate, delta)
+	if err != nil {
+		return nil, err
+	}
+	return NewCountMinSketchKV(store, name, params.Rows, params.Columns)
+}
+
+// Name returns the name of the sketch in its store
+func (cms *CountMinSketchKV) Name() string {
+	return cms.name
+}
+
+// UpdateOnce increments the count of _data_ in Count-Min Sketch by 1
+func (cms *CountMinSketchKV) UpdateOnce(data []byte) error {
+	return cms.Update(data, 1)
+}
+
+// Update increments the count of _data_ (byte slice) in Count-Min Sketch by value _count_ passed
+func (cms *CountMinSketchKV) Update(data []byte, count uint64) error {
+	hash1, hash2 := metro.Hash128(data, 1373)
+	return cms.store.Update(func(tx KVTx) error {
+		for r := uint(0); r < cms.rows; r++ {
+			key := cms.counterKey(r, cms.getPosition(hash1, hash2, r))
+			counter, err := kvGetUint64(tx, key)
+			if err != nil {
+				return err
+			}
+			err = kvSetUint64(tx, key, counter+count)
+			if err != nil {
+				return err
+			}
+		}
+		total, err := kvGetUint64(tx, cms.totalKey())
+		if err != nil {
+			return err
+		}
+		return kvSetUint64(tx, cms.totalKey(), total+count)
+	})
+}
+
+// UpdateString increments the count of _data_ (string) in Count-Min Sketch by value _count_ passed
+func (cms *CountMinSketchKV) UpdateString(data string, count uint64) error {
+	return cms.Update([]byte(data), count)
+}
+
+// Count estimates the count of the _data_ (byte slice) in the Count-Min Sketch data structure
+func (cms *CountMinSketchKV) Count(data []byte) (uint64, error) {
+	hash1, hash2 := metro.Hash128(data, 1373)
+	var min uint64
+	err := cms.store.View(func(tx KVTx) error {
+		for r := uint(0); r < cms.rows; r++ {
+			counter, err := kvGetUint64(tx, cms.counterKey(r, cms.getPosition(hash1, hash2, r)))
+			if err != nil {
+				return err
+			}
+			if r == 0 || counter < min {
+				min = counter
+			}
+		}
+		return nil
+	})
+	return min, err
+}
+
+// CountString estimates the count of the _data_ (string) in the Count-Min Sketch data structure
+func (cms *CountMinSketchKV) CountString(data string) (uint64, error) {
+	return cms.Count([]byte(data))
+}
+
+// TotalCount returns the sum of all the counts added to the Count-Min Sketch so far
+func (cms *CountMinSketchKV) TotalCount() (uint64, error) {
+	var total uint64
+	err := cms.store.View(func(tx KVTx) error {
+		var err error
+		total, err = kvGetUint64(tx, cms.totalKey())
+		return err
+	})
+	return total, err
+}
+
+// Reset sets all the counters of the Count-Min Sketch to 0, keeping its parameters. It
+// deletes every counter of the matrix, i.e. _rows_ * _columns_ keys.
+func (cms *CountMinSketchKV) Reset() error {
+	return cms.store.Update(func(tx KVTx) error {
+		for r := uint(0); r < cms.rows; r++ {
+			for c := uint(0); c < cms.columns; c++ {
+				err := tx.Delete(cms.counterKey(r, c))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Delete(cms.totalKey())
+	})
+}
+
+// Snapshot returns an in-memory CountMinSketch holding the counters of the sketch
+func (cms *CountMinSketchKV) Snapshot() (*CountMinSketch, error) {
+	sketch, err := NewCountMinSketch(cms.rows, cms.columns)
+	if err != nil {
+		return nil, err
+	}
+	err = cms.store.View(func(tx KVTx) error {
+		for r := range sketch.matrix {
+			for c := range sketch.matrix[r] {
+				counter, err := kvGetUint64(tx, cms.counterKey(uint(r), uint(c)))
+				if err != nil {
+					return err
+				}
+				sketch.matrix[r][c] = counter
+			}
+		}
+		var err error
+		sketch.allSum, err = kvGetUint64(tx, cms.totalKey())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sketch, nil
+}
+
+func (cms *CountMinSketchKV) totalKey() []byte {
+	return []byte(cms.name + ":total")
+}
+
+func (cms *CountMinSketchKV) counterKey(row, column uint) []byte {
+	return []byte(cms.name + ":" + strconv.FormatUint(uint64(row), 10) + ":" + strconv.FormatUint(uint64(column), 10))
+}
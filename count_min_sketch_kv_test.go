@@ -0,0 +1,42 @@
+package gostatix
+
+import "testing"
+
+func TestCountMinSketchKVBasic(t *testing.T) {
+	store := NewMemKVStore()
+	cms, err := NewCountMinSketchKVFromEstimates(store, "cms", 0.01, delta)
+	if err != nil {
+		t.Fatalf("creating the sketch shouldn't error out, error: %v", err)
+	}
+	cms.UpdateString("foo", 2)
+	cms.UpdateOnce([]byte("bar"))
+
+	cms, err = NewCountMinSketchKVFromEstimates(store, "cms", 0.01, delta)
+	if err != nil {
+		t.Fatalf("reopening the sketch shouldn't error out, error: %v", err)
+	}
+	if count, _ := cms.CountString("foo"); count != 2 {
+		t.Errorf("count of foo should be 2, found %d", count)
+	}
+	if count, _ := cms.CountString("baz"); count != 0 {
+		t.Errorf("count of baz should be 0, found %d", count)
+	}
+	if total, _ := cms.TotalCount(); total != 3 {
+		t.Errorf("total count should be 3, found %d", total)
+	}
+	snapshot, err := cms.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot shouldn't error out, error: %v", err)
+	}
+	if snapshot.CountString("bar") != 1 || snapshot.TotalCount() != 3 {
+		t.Errorf("snapshot should hold the counts of the sketch")
+	}
+	_, err = NewCountMinSketchKV(store, "cms", 1, 1)
+	if err == nil {
+		t.Errorf("opening the sketch with different parameters should error out")
+	}
+	cms.Reset()
+	if count, _ := cms.CountString("foo"); count != 0 {
+		t.Errorf("count of foo should be 0 after a reset, found %d", count)
+	}
+}
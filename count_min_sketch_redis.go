@@ -14,41 +14,157 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
+	"io"
 	"strconv"
+	"time"
 
+	"github.com/dgryski/go-metro"
 	"github.com/kwertop/gostatix/internal/util"
 	"github.com/redis/go-redis/v9"
 )
 
+var cmsUpdateScript = redis.NewScript(`
+	local size = ARGV[1]
+	local cmsKey = ARGV[2]
+	local count = tonumber(ARGV[3])
+	local metadataKey = ARGV[4]
+	for i=1, tonumber(size)-1, 2 do
+		local row = cmsKey .. KEYS[i]
+		local column = tonumber(KEYS[i+1])
+		local val = redis.call('LINDEX', row, column)
+		val = tonumber(val) + count
+		redis.pcall('LSET', row, column, val)
+	end
+	redis.call('HINCRBY', metadataKey, 'allSum', count)
+	return true
+`)
+
+// cmsUpdateCountScript increases the counters of an element like cmsUpdateScript and
+// returns the estimated count of the element after the increment
+var cmsUpdateCountScript = redis.NewScript(`
+	local size = ARGV[1]
+	local cmsKey = ARGV[2]
+	local count = tonumber(ARGV[3])
+	local metadataKey = ARGV[4]
+	local estimate = 0
+	for i=1, tonumber(size)-1, 2 do
+		local row = cmsKey .. KEYS[i]
+		local column = tonumber(KEYS[i+1])
+		local val = tonumber(redis.call('LINDEX', row, column)) + count
+		redis.pcall('LSET', row, column, val)
+		if val < estimate or i == 1 then
+			estimate = val
+		end
+	end
+	redis.call('HINCRBY', metadataKey, 'allSum', count)
+	return estimate
+`)
+
+// cmsDecrementScript decreases the counters of an element by the count passed, or by its
+// estimated count if it's smaller so that no counter goes below zero, and returns the
+// estimated count of the element after the decrement
+var cmsDecrementScript = redis.NewScript(`
+	local size = ARGV[1]
+	local cmsKey = ARGV[2]
+	local count = tonumber(ARGV[3])
+	local metadataKey = ARGV[4]
+	local estimate = 0
+	for i=1, tonumber(size)-1, 2 do
+		local val = tonumber(redis.call('LINDEX', cmsKey .. KEYS[i], tonumber(KEYS[i+1])))
+		if val < estimate or i == 1 then
+			estimate = val
+		end
+	end
+	if count > estimate then
+		count = estimate
+	end
+	for i=1, tonumber(size)-1, 2 do
+		local row = cmsKey .. KEYS[i]
+		local column = tonumber(KEYS[i+1])
+		local val = tonumber(redis.call('LINDEX', row, column)) - count
+		redis.pcall('LSET', row, column, val)
+	end
+	redis.call('HINCRBY', metadataKey, 'allSum', -count)
+	return estimate - count
+`)
+
+// cmsResetScript overwrites the rows of the sketch at KEYS[1] with zeroes and sets the total
+// count saved in the metadata hash at KEYS[2] to 0. The sorted set at KEYS[3], the heap of
+// a TopKRedis, is deleted if it's passed. The zeroes are pushed in chunks to stay within
+// the limits of unpack.
+var cmsResetScript = redis.NewScript(`
+	local rows = tonumber(ARGV[1])
+	local columns = tonumber(ARGV[2])
+	local chunk = {}
+	for j=1, math.min(columns, 1000) do
+		chunk[j] = 0
+	end
+	for i=1, rows do
+		local rowKey = KEYS[1] .. tostring(i-1)
+		redis.call('DEL', rowKey)
+		local pushed = 0
+		while pushed < columns do
+			local n = math.min(columns - pushed, #chunk)
+			redis.call('RPUSH', rowKey, unpack(chunk, 1, n))
+			pushed = pushed + n
+		end
+	end
+	redis.call('HSET', KEYS[2], 'allSum', 0)
+	if KEYS[3] then
+		redis.call('DEL', KEYS[3])
+	end
+	return true
+`)
+
 // CountMinSketchRedis is the Redis backed implementation of BaseCountMinSketch
 // _key_ holds the Redis key to the list which has the Redis keys of rows of data
 // _metadataKey_ is used to store the additional information about CountMinSketchRedis
 // for retrieving the sketch by the Redis key
+// _updated_ records the time of the last update made through the sketch
+// The total count is only kept in the metadata hash, so that the sketch can be updated
+// concurrently without a lock, see TotalCount.
 type CountMinSketchRedis struct {
 	AbstractCountMinSketch
 	key         string
 	metadataKey string
+	resources   resources
+	updated     updateClock
 }
 
 // NewCountMinSketchRedis creates CountMinSketchRedis with _rows_ and _columns_
-func NewCountMinSketchRedis(rows, columns uint) (*CountMinSketchRedis, error) {
-	if rows <= 0 || columns <= 0 {
-		return nil, errors.New("gostatix: rows and columns size should be greater than 0")
+// _opts_ can name the Redis keys of the sketch with WithName and WithKeyPrefix
+func NewCountMinSketchRedis(rows, columns uint, opts ...Option) (*CountMinSketchRedis, error) {
+	err := CountMinSketchParams{rows, columns}.Validate()
+	if err != nil {
+		return nil, err
+	}
+	o, err := redisOptions("count-min sketch", opts)
+	if err != nil {
+		return nil, err
+	}
+	metadataKey, err := o.metadataKey()
+	if err != nil {
+		return nil, err
+	}
+	key, err := o.dataKey(":matrix")
+	if err != nil {
+		return nil, err
 	}
 	abstractSketch := makeAbstractCountMinSketch(rows, columns, 0)
-	key := util.GenerateRandomString(16)
-	metadataKey := util.GenerateRandomString(16)
-	sketch := &CountMinSketchRedis{*abstractSketch, key, metadataKey}
+	sketch := &CountMinSketchRedis{*abstractSketch, key, metadataKey, resources{}, updateClock{}}
 	metadata := make(map[string]interface{})
 	metadata["rows"] = sketch.rows
 	metadata["columns"] = sketch.columns
 	metadata["key"] = sketch.key
-	err := getRedisClient().HSet(context.Background(), sketch.metadataKey, metadata).Err()
+	metadata["allSum"] = 0
+	err = saveMetadata(sketch.metadataKey, "cms", metadata)
 	if err != nil {
 		return nil, fmt.Errorf("gostatix: error creating count min sketch redis, error: %v", err)
 	}
-	sketch.initMatrix()
+	err = sketch.initMatrix()
+	if err != nil {
+		return nil, err
+	}
 	return sketch, nil
 }
 
@@ -56,28 +172,76 @@ func NewCountMinSketchRedis(rows, columns uint) (*CountMinSketchRedis, error) {
 // _metadataKey_ (the Redis key used to store the metadata about the count-min sketch) passed.
 // For this to work, value should be present in Redis at _key_
 func NewCountMinSketchRedisFromKey(metadataKey string) (*CountMinSketchRedis, error) {
-	values, err := getRedisClient().HGetAll(context.Background(), metadataKey).Result()
+	metadata, err := loadMetadata(metadataKey, "cms")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := metadata.uint("rows")
+	if err != nil {
+		return nil, err
+	}
+	columns, err := metadata.uint("columns")
 	if err != nil {
-		return nil, fmt.Errorf("gostatix: error creating count min sketch from redis key, error: %v", err)
+		return nil, err
+	}
+	key, err := metadata.string("key")
+	if err != nil {
+		return nil, err
+	}
+	allSum, err := metadata.optionalUint("allSum")
+	if err != nil {
+		return nil, err
 	}
-	rows, _ := strconv.Atoi(values["rows"])
-	columns, _ := strconv.Atoi(values["columns"])
-	if rows <= 0 || columns <= 0 {
-		return nil, fmt.Errorf("gostatix: error creating count min sketch from redis key")
+	err = checkCountMinSketchParams(rows, columns)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: invalid count min sketch metadata at key %s, error: %v", metadataKey, err)
 	}
-	key := values["key"]
-	abstractSketch := makeAbstractCountMinSketch(uint(rows), uint(columns), 0)
-	sketch := &CountMinSketchRedis{*abstractSketch, key, metadataKey}
+	abstractSketch := makeAbstractCountMinSketch(uint(rows), uint(columns), allSum)
+	sketch := &CountMinSketchRedis{*abstractSketch, key, metadataKey, resources{}, updateClock{}}
 	return sketch, nil
 }
 
 // NewCountMinSketchRedisFromEstimates creates a new CountMinSketchRedis based upon the desired
 // _errorRate_ and _delta_
 // rows and columns are calculated based upon these supplied values
-func NewCountMinSketchRedisFromEstimates(errorRate, delta float64) (*CountMinSketchRedis, error) {
-	columns := uint(math.Ceil(math.E / errorRate))
-	rows := uint(math.Ceil(math.Log(1 / delta)))
-	return NewCountMinSketchRedis(rows, columns)
+func NewCountMinSketchRedisFromEstimates(errorRate, delta float64, opts ...Option) (*CountMinSketchRedis, error) {
+	params, err := CountMinSketchParamsFromEstimates(errorRate, delta)
+	if err != nil {
+		return nil, err
+	}
+	return NewCountMinSketchRedis(params.Rows, params.Columns, opts...)
+}
+
+// NewOrOpenCountMinSketchRedis creates a CountMinSketchRedis named _name_ (see WithName)
+// with _rows_ and _columns_, or opens it like NewCountMinSketchRedisFromKey if it already
+// exists, in which case it must have been created with the same _rows_ and _columns_
+func NewOrOpenCountMinSketchRedis(name string, rows, columns uint, opts ...Option) (*CountMinSketchRedis, error) {
+	opts, err := namedOptions(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	o, err := redisOptions("count-min sketch", opts)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := o.nameExists()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return NewCountMinSketchRedis(rows, columns, opts...)
+	}
+	metadataKey := o.keyPrefix + o.name
+	sketch, err := NewCountMinSketchRedisFromKey(metadataKey)
+	if err != nil {
+		return nil, err
+	}
+	err = checkOpenedParams("count-min sketch", metadataKey,
+		[]interface{}{sketch.rows, sketch.columns}, []interface{}{rows, columns})
+	if err != nil {
+		return nil, err
+	}
+	return sketch, nil
 }
 
 // MetadataKey returns the metadataKey
@@ -92,69 +256,170 @@ func (cms *CountMinSketchRedis) UpdateOnce(data []byte) {
 
 // Update increments the count of _data_ (byte slice) in CountMinSketchRedis by value _count_ passed
 func (cms *CountMinSketchRedis) Update(data []byte, count uint64) error {
-	updateLists := redis.NewScript(`
-		local size = ARGV[1]
-		local cmsKey = ARGV[2]
-		local count = tonumber(ARGV[3])
-		for i=1, tonumber(size)-1, 2 do
-			local row = cmsKey .. KEYS[i]
-			local column = tonumber(KEYS[i+1])
-			local val = redis.call('LINDEX', row, column)
-			val = tonumber(val) + count
-			redis.pcall('LSET', row, column, val)
-		end
-		return true
-	`)
-	var updateRedisKeys []string
-	for r, c := range cms.getPositions(data) {
-		updateRedisKeys = append(updateRedisKeys, strconv.FormatInt(int64(r), 10), strconv.FormatUint(uint64(c), 10))
-	}
-	_, err := updateLists.Run(
+	keys := getKeys()
+	defer putKeys(keys)
+	*keys = cms.appendPositionKeys(*keys, data)
+	_, err := cmsUpdateScript.Run(
 		context.Background(),
 		getRedisClient(),
-		updateRedisKeys,
-		len(updateRedisKeys),
+		*keys,
+		cms.rows*2,
 		cms.key,
 		count,
+		cms.metadataKey,
 	).Bool()
 	if err != nil {
 		return fmt.Errorf("gostatix: error while updating data %v in redis, error: %v", data, err)
 	}
-	cms.allSum += count
+	cms.updated.touch()
+	return nil
+}
+
+// updateAndCount increments the count of _data_ by _count_ like Update and returns its
+// estimated count after the increment, in a single round trip
+func (cms *CountMinSketchRedis) updateAndCount(data []byte, count uint64) (uint64, error) {
+	keys := getKeys()
+	defer putKeys(keys)
+	*keys = cms.appendPositionKeys(*keys, data)
+	estimate, err := cmsUpdateCountScript.Run(
+		context.Background(),
+		getRedisClient(),
+		*keys,
+		cms.rows*2,
+		cms.key,
+		count,
+		cms.metadataKey,
+	).Uint64()
+	if err != nil {
+		return 0, fmt.Errorf("gostatix: error while updating data %v in redis, error: %v", data, err)
+	}
+	cms.updated.touch()
+	return estimate, nil
+}
+
+// decrement decreases the count of _data_ (byte slice) in CountMinSketchRedis by value _count_
+// passed, or by its estimated count if it's smaller so that no counter goes below zero.
+// It returns the estimated count of _data_ after the decrement.
+func (cms *CountMinSketchRedis) decrement(data []byte, count uint64) (uint64, error) {
+	keys := getKeys()
+	defer putKeys(keys)
+	*keys = cms.appendPositionKeys(*keys, data)
+	estimate, err := cmsDecrementScript.Run(
+		context.Background(),
+		getRedisClient(),
+		*keys,
+		cms.rows*2,
+		cms.key,
+		count,
+		cms.metadataKey,
+	).Uint64()
+	if err != nil {
+		return 0, fmt.Errorf("gostatix: error while decrementing data %v in redis, error: %v", data, err)
+	}
+	cms.updated.touch()
+	return estimate, nil
+}
+
+// WithAsyncWrites returns an AsyncWriter which buffers the updates to the CountMinSketchRedis
+// and flushes them in pipelines of _bufSize_ updates or every _flushInterval_ (if greater than 0).
+// The updates of the same element in a batch are combined into a single update.
+// _onError_ (optional, can be nil) is called with the errors of the background flushes.
+func (cms *CountMinSketchRedis) WithAsyncWrites(bufSize int, flushInterval time.Duration, onError func(error)) (*AsyncWriter, error) {
+	return newAsyncWriter(&cms.resources, cms, bufSize, flushInterval, onError)
+}
+
+func (cms *CountMinSketchRedis) writeBatch(batch []asyncWrite) error {
+	counts := make(map[string]uint64)
+	var elements []string
+	for _, write := range batch {
+		element := string(write.data)
+		if _, ok := counts[element]; !ok {
+			elements = append(elements, element)
+		}
+		counts[element] += write.count
+	}
+	// the keys of all the elements share a single slice
+	size := int(cms.rows) * 2
+	keys := make([]string, 0, len(elements)*size)
+	for _, element := range elements {
+		keys = cms.appendPositionKeys(keys, []byte(element))
+	}
+	ctx := context.Background()
+	err := execScriptPipeline(ctx, cmsUpdateScript, func(pipe redis.Pipeliner) {
+		for i, element := range elements {
+			cmsUpdateScript.EvalSha(ctx, pipe, keys[i*size:(i+1)*size], cms.rows*2, cms.key, counts[element], cms.metadataKey)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	cms.updated.touch()
 	return nil
 }
 
+// appendPositionKeys appends the row and column pairs of _data_, used as KEYS by the
+// scripts of the sketch, to _keys_. The element is hashed once and no positions slice is
+// allocated, the rows being below 100 their strings aren't allocated either.
+func (cms *CountMinSketchRedis) appendPositionKeys(keys []string, data []byte) []string {
+	hash1, hash2 := metro.Hash128(data, 1373)
+	for r := uint(0); r < cms.rows; r++ {
+		keys = append(keys, strconv.FormatUint(uint64(r), 10), strconv.FormatUint(uint64(cms.getPosition(hash1, hash2, r)), 10))
+	}
+	return keys
+}
+
 // UpdateString increments the count of _data_ (string) in CountMinSketchRedis by value _count_ passed
 func (cms *CountMinSketchRedis) UpdateString(data string, count uint64) error {
 	return cms.Update([]byte(data), count)
 }
 
+// UpdateUint64 increments the count of _data_ (unsigned integer) in CountMinSketchRedis by value _count_ passed.
+// The integer is hashed as its 8 byte big endian encoding.
+func (cms *CountMinSketchRedis) UpdateUint64(data uint64, count uint64) error {
+	return cms.Update(uint64Bytes(data), count)
+}
+
+// UpdateKey increments the count of _data_ (Key) in CountMinSketchRedis by value _count_ passed
+func (cms *CountMinSketchRedis) UpdateKey(data Key, count uint64) error {
+	return cms.Update(data.KeyBytes(), count)
+}
+
+// UpdateFromReader streams keys separated by _delim_ from _stream_ and increments the
+// count of each of them by 1 without holding all of them in memory.
+// _progress_ (optional, can be nil) is called periodically with the number of keys processed.
+// It returns the number of keys processed and stops at the first Redis error.
+func (cms *CountMinSketchRedis) UpdateFromReader(stream io.Reader, delim byte, progress ProgressFunc) (uint64, error) {
+	return util.ReadKeys(stream, delim, progress, func(key []byte) error {
+		return cms.Update(key, 1)
+	})
+}
+
+var cmsCountScript = redis.NewScript(`
+	local size = ARGV[1]
+	local cmsKey = ARGV[2]
+	local min = 0
+	for i=1, tonumber(size)-1, 2 do
+		local row = cmsKey .. KEYS[i]
+		local column = tonumber(KEYS[i+1])
+		local val = redis.call('LINDEX', row, column)
+		local count = tonumber(val)
+		if count < min or tonumber(KEYS[i]) == 0 then
+			min = count
+		end
+	end
+	return min
+`)
+
 // Count estimates the count of the _data_ (byte slice) in the CountMinSketchRedis
 func (cms *CountMinSketchRedis) Count(data []byte) (uint64, error) {
-	countLists := redis.NewScript(`
-		local size = ARGV[1]
-		local cmsKey = ARGV[2]
-		local min = 0
-		for i=1, tonumber(size)-1, 2 do
-			local row = cmsKey .. KEYS[i]
-			local column = tonumber(KEYS[i+1])
-			local val = redis.call('LINDEX', row, column)
-			local count = tonumber(val)
-			if count < min or tonumber(KEYS[i]) == 0 then
-				min = count
-			end
-		end
-		return min
-	`)
-	var countRedisKeys []string
-	for r, c := range cms.getPositions(data) {
-		countRedisKeys = append(countRedisKeys, strconv.FormatInt(int64(r), 10), strconv.FormatUint(uint64(c), 10))
-	}
-	minVal, err := countLists.Run(
+	keys := getKeys()
+	defer putKeys(keys)
+	*keys = cms.appendPositionKeys(*keys, data)
+	minVal, err := cmsCountScript.Run(
 		context.Background(),
 		getRedisClient(),
-		countRedisKeys,
-		len(countRedisKeys),
+		*keys,
+		len(*keys),
 		cms.key,
 	).Uint64()
 	if err != nil {
@@ -168,6 +433,30 @@ func (cms *CountMinSketchRedis) CountString(data string) (uint64, error) {
 	return cms.Count([]byte(data))
 }
 
+// CountUint64 estimates the count of the _data_ (unsigned integer) in the CountMinSketchRedis
+func (cms *CountMinSketchRedis) CountUint64(data uint64) (uint64, error) {
+	return cms.Count(uint64Bytes(data))
+}
+
+// CountKey estimates the count of the _data_ (Key) in the CountMinSketchRedis
+func (cms *CountMinSketchRedis) CountKey(data Key) (uint64, error) {
+	return cms.Count(data.KeyBytes())
+}
+
+// TotalCount returns the sum of all the counts added to the CountMinSketchRedis so far.
+// The sum is read from the metadata hash in Redis so that updates made by other
+// clients sharing the same sketch are accounted for
+func (cms *CountMinSketchRedis) TotalCount() (uint64, error) {
+	allSum, err := getRedisClient().HGet(context.Background(), cms.metadataKey, "allSum").Uint64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("gostatix: error while fetching total count from redis, error: %v", err)
+	}
+	return allSum, nil
+}
+
 // Merge merges two Count-Min Sketch data structures
 func (cms *CountMinSketchRedis) Merge(cms1 *CountMinSketchRedis) error {
 	if cms.rows != cms1.rows {
@@ -176,100 +465,203 @@ func (cms *CountMinSketchRedis) Merge(cms1 *CountMinSketchRedis) error {
 	if cms.columns != cms1.columns {
 		return fmt.Errorf("gostatix: can't merge sketches with unequal column counts, %d and %d", cms.columns, cms1.columns)
 	}
-	return cms.mergeMatrix(cms1.key)
+	err := cms.mergeMatrix(cms1)
+	if err != nil {
+		return err
+	}
+	cms.updated.touch()
+	return nil
 }
 
-// Equals checks if two CountMinSketchRedis are equal
+// Equals checks if two CountMinSketchRedis are equal. Sketches with different number of
+// rows or columns aren't equal.
 func (cms *CountMinSketchRedis) Equals(cms1 *CountMinSketchRedis) (bool, error) {
-	if cms.rows != cms1.rows || cms.columns != cms1.columns {
-		return false, nil
+	comparison, err := cms.Compare(cms1)
+	return comparison.Equal(), err
+}
+
+// Compare compares two CountMinSketchRedis and returns why they aren't equal, if they aren't
+func (cms *CountMinSketchRedis) Compare(cms1 *CountMinSketchRedis) (Comparison, error) {
+	if cms.rows != cms1.rows {
+		return parameterMismatch("rows", cms.rows, cms1.rows), nil
+	}
+	if cms.columns != cms1.columns {
+		return parameterMismatch("columns", cms.columns, cms1.columns), nil
+	}
+	ok, err := cms.compareMatrix(cms1.key)
+	if err != nil {
+		return Comparison{}, err
+	}
+	return compareResult(ok, "matrices"), nil
+}
+
+// Reset sets all the counts of the CountMinSketchRedis to zero in a single Lua script,
+// keeping its dimensions, its Redis keys and its metadata
+func (cms *CountMinSketchRedis) Reset() error {
+	return cms.reset("")
+}
+
+// reset runs cmsResetScript on the sketch, deleting the sorted set at _heapKey_ as well
+// if it's not empty
+func (cms *CountMinSketchRedis) reset(heapKey string) error {
+	keys := []string{cms.key, cms.metadataKey}
+	if heapKey != "" {
+		keys = append(keys, heapKey)
+	}
+	err := cmsResetScript.Run(
+		context.Background(),
+		getRedisClient(),
+		keys,
+		cms.rows,
+		cms.columns,
+	).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while resetting count-min sketch %s, error: %v", cms.key, err)
 	}
-	return cms.compareMatrix(cms1.key)
+	cms.updated.touch()
+	return nil
+}
+
+// MemoryUsage returns the estimated number of bytes used in Redis by the CountMinSketchRedis,
+// as reported by MEMORY USAGE for its rows and its metadata
+func (cms *CountMinSketchRedis) MemoryUsage() (uint64, error) {
+	return redisMemoryUsage(cms.redisKeys())
+}
+
+// redisKeys returns the keys of the rows and of the metadata of the sketch
+func (cms *CountMinSketchRedis) redisKeys() []string {
+	keys := []string{cms.metadataKey}
+	for i := uint(0); i < cms.rows; i++ {
+		keys = append(keys, cms.key+strconv.FormatUint(uint64(i), 10))
+	}
+	return keys
+}
+
+// Close releases the resources attached to the CountMinSketchRedis, flushing and stopping
+// its async writers. The data of the sketch is kept in Redis.
+// It's safe to call Close multiple times.
+func (cms *CountMinSketchRedis) Close() error {
+	return cms.resources.close()
 }
 
 // Export JSON marshals the CountMinSketchRedis and returns a byte slice containing the data
+// The matrix and the total count are a point-in-time snapshot, see getSnapshot, so that
+// the export is consistent even with concurrent updates
 func (cms *CountMinSketchRedis) Export() ([]byte, error) {
-	matrix, err := cms.getMatrix()
+	matrix, allSum, err := cms.getSnapshot()
 	if err != nil {
 		return nil, err
 	}
-	return json.Marshal(countMinSketchJSON{cms.rows, cms.columns, cms.allSum, matrix, cms.key})
+	return json.Marshal(countMinSketchJSON{cms.rows, cms.columns, allSum, matrix, cms.key})
 }
 
 // Import JSON unmarshals the _data_ into the CountMinSketchRedis
 func (cms *CountMinSketchRedis) Import(data []byte, withNewKey bool) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
 	var s countMinSketchJSON
-	err := json.Unmarshal(data, &s)
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	err = s.validate()
 	if err != nil {
 		return err
 	}
+	key := s.Key
+	if withNewKey {
+		key, err = newRedisKey(context.Background(), "")
+		if err != nil {
+			return err
+		}
+	}
 	cms.rows = s.Rows
 	cms.columns = s.Columns
-	cms.allSum = s.AllSum
-	if withNewKey {
-		cms.key = util.GenerateRandomString(16)
-	} else {
-		cms.key = s.Key
+	cms.key = key
+	metadata := make(map[string]interface{})
+	metadata["rows"] = cms.rows
+	metadata["columns"] = cms.columns
+	metadata["key"] = cms.key
+	metadata["allSum"] = s.AllSum
+	err = saveMetadata(cms.metadataKey, "cms", metadata)
+	if err != nil {
+		return fmt.Errorf("gostatix: error while saving metadata in redis, error: %v", err)
 	}
+	cms.updated.touch()
 	return cms.setMatrix(s.Matrix)
 }
 
-func (cms *CountMinSketchRedis) compareMatrix(key string) (bool, error) {
-	compareMatrixScript := redis.NewScript(`
-		local key1 = KEYS[1]
-		local key2 = KEYS[2]
-		local rows = tonumber(ARGV[1])
-		local columns = tonumber(ARGV[2])
-		for i=1, tonumber(rows) do
-			local rowKey1 = key1 .. tostring(i-1)
-			local vals1 = redis.pcall('LRANGE', rowKey1, 0, -1)
-			local rowKey2 = key2 .. tostring(i-1)
-			local vals2 = redis.pcall('LRANGE', rowKey2, 0, -1)
-			for j=1, tonumber(columns) do
-				if vals1[j] ~= vals2[j] then
-					return false
-				end
+var cmsEqualsScript = redis.NewScript(`
+	local key1 = KEYS[1]
+	local key2 = KEYS[2]
+	local rows = tonumber(ARGV[1])
+	local columns = tonumber(ARGV[2])
+	for i=1, tonumber(rows) do
+		local rowKey1 = key1 .. tostring(i-1)
+		local vals1 = redis.pcall('LRANGE', rowKey1, 0, -1)
+		local rowKey2 = key2 .. tostring(i-1)
+		local vals2 = redis.pcall('LRANGE', rowKey2, 0, -1)
+		for j=1, tonumber(columns) do
+			if vals1[j] ~= vals2[j] then
+				return false
 			end
 		end
-		return true
-	`)
-	ok, err := compareMatrixScript.Run(
+	end
+	return true
+`)
+
+func (cms *CountMinSketchRedis) compareMatrix(key string) (bool, error) {
+	ok, err := cmsEqualsScript.Run(
 		context.Background(),
 		getRedisClient(),
 		[]string{cms.key, key},
 		cms.rows,
 		cms.columns,
 	).Bool()
-	if err != nil || !ok {
-		return false, fmt.Errorf("gostatix: error while comparing matrix in redis")
+	if err == redis.Nil {
+		// lua false is returned as a nil reply
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("gostatix: error while comparing matrix in redis, error: %v", err)
 	}
 	return ok, nil
 }
 
-func (cms *CountMinSketchRedis) mergeMatrix(key string) error {
-	mergeMatrixScript := redis.NewScript(`
-		local key1 = KEYS[1]
-		local key2 = KEYS[2]
-		local rows = tonumber(ARGV[1])
-		local columns = tonumber(ARGV[2])
-		for i=1, tonumber(rows) do
-			local rowKey1 = key1 .. tostring(i-1)
-			local vals1 = redis.call('LRANGE', rowKey1, 0, -1)
-			local rowKey2 = key2 .. tostring(i-1)
-			local vals2 = redis.call('LRANGE', rowKey2, 0, -1)
-			local vals3 = {}
-			for j=1, tonumber(columns) do
-				vals3[j] = tonumber(vals1[j]) + tonumber(vals2[j])
-			end
-			redis.call('DEL', rowKey1)
-			redis.call('RPUSH', rowKey1, unpack(vals3))
+// cmsMergeScript adds the rows of the sketch at KEYS[2] to the rows of the sketch at
+// KEYS[1] and its total count, in the metadata hash at KEYS[4], to the one at KEYS[3],
+// so that the matrix and the total count are never read half merged. The rows are pushed
+// in chunks to stay within the limits of unpack.
+var cmsMergeScript = redis.NewScript(`
+	local rows = tonumber(ARGV[1])
+	local columns = tonumber(ARGV[2])
+	for i=1, rows do
+		local rowKey1 = KEYS[1] .. tostring(i-1)
+		local vals1 = redis.call('LRANGE', rowKey1, 0, -1)
+		local vals2 = redis.call('LRANGE', KEYS[2] .. tostring(i-1), 0, -1)
+		for j=1, columns do
+			vals1[j] = tonumber(vals1[j]) + tonumber(vals2[j])
+		end
+		redis.call('DEL', rowKey1)
+		local pushed = 0
+		while pushed < columns do
+			local n = math.min(columns - pushed, 1000)
+			redis.call('RPUSH', rowKey1, unpack(vals1, pushed + 1, pushed + n))
+			pushed = pushed + n
 		end
-		return true
-	`)
-	ok, err := mergeMatrixScript.Run(
+	end
+	redis.call('HINCRBY', KEYS[3], 'allSum', tonumber(redis.call('HGET', KEYS[4], 'allSum') or 0))
+	return true
+`)
+
+// mergeMatrix adds the matrix and the total count of _cms1_ to the CountMinSketchRedis
+func (cms *CountMinSketchRedis) mergeMatrix(cms1 *CountMinSketchRedis) error {
+	ok, err := cmsMergeScript.Run(
 		context.Background(),
 		getRedisClient(),
-		[]string{cms.key, key},
+		[]string{cms.key, cms1.key, cms.metadataKey, cms1.metadataKey},
 		cms.rows,
 		cms.columns,
 	).Bool()
@@ -279,64 +671,161 @@ func (cms *CountMinSketchRedis) mergeMatrix(key string) error {
 	return nil
 }
 
-func (cms CountMinSketchRedis) initMatrix() error {
-	rowKeys := make([]string, cms.rows)
-	for i := range rowKeys {
-		rowKeys[i] = cms.key + "_" + strconv.FormatInt(int64(i), 10)
-	}
-	initMatrixRedis := redis.NewScript(`
-		local key = KEYS[1]
-		local rows = ARGV[1]
-		local columns = ARGV[2]
-		for i=1, tonumber(rows) do
-			local rowKey = key .. tostring(i-1)
-			redis.call('DEL', rowKey)
-			local list = {}
-			for j=1, tonumber(columns) do
-				list[j] = 0
-			end
-			redis.call('LPUSH', rowKey, unpack(list))
-		end
-		return true
-	`)
-	ok, err := initMatrixRedis.Run(
+// initMatrix fills the rows of the CountMinSketchRedis with zeroes, which isn't an update
+func (cms *CountMinSketchRedis) initMatrix() error {
+	err := cmsResetScript.Run(
 		context.Background(),
 		getRedisClient(),
-		[]string{cms.key},
+		[]string{cms.key, cms.metadataKey},
 		cms.rows,
 		cms.columns,
-	).Bool()
-	if err != nil || !ok {
-		return errors.New("gostatix: error while initializing matrix in redis")
+	).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while initializing matrix in redis, error: %v", err)
 	}
 	return nil
 }
 
-func (cms *CountMinSketchRedis) getMatrix() ([][]uint64, error) {
-	fetchMatrixAsTable := redis.NewScript(`
-		local key = KEYS[1]
-		local size = ARGV[1]
-		local matrix = {}
-		for i=1, tonumber(size) do
-			matrix[i] = {}
-			local rowKey = key .. tostring(i-1)
-			local values = redis.call('LRANGE', rowKey, 0, -1)
-			for j, v in ipairs(values) do
-				matrix[i][j] = v
-			end
-		end
-		return matrix
-	`)
-	result, err := fetchMatrixAsTable.Run(
+var cmsSnapshotScript = redis.NewScript(`
+	local key = KEYS[1]
+	local metadataKey = KEYS[2]
+	local size = ARGV[1]
+	local allSum = redis.call('HGET', metadataKey, 'allSum')
+	local matrix = {}
+	for i=1, tonumber(size) do
+		local rowKey = key .. tostring(i-1)
+		matrix[i] = redis.call('LRANGE', rowKey, 0, -1)
+	end
+	return {allSum or '0', matrix}
+`)
+
+// cmsPageSize is the number of counters of the largest sketch read in a single Lua script,
+// and the number of counters of a row read by each LRANGE of a larger sketch
+var cmsPageSize uint64 = 1 << 16
+
+// cmsSnapshotTTL is the expiry of the copies of the rows of a large sketch read by an
+// export, so that the copies of an export which didn't delete them don't stay in Redis
+const cmsSnapshotTTL = time.Hour
+
+// cmsCopyScript copies the ARGV[1] rows of the sketch at KEYS[1] to the keys prefixed by
+// KEYS[3], which expire after ARGV[2] milliseconds, and returns the total count in the
+// metadata hash at KEYS[2]. The copies and the total count are a point-in-time snapshot
+// of the sketch.
+var cmsCopyScript = redis.NewScript(`
+	local rows = tonumber(ARGV[1])
+	for i=1, rows do
+		local copyKey = KEYS[3] .. tostring(i-1)
+		redis.call('COPY', KEYS[1] .. tostring(i-1), copyKey, 'REPLACE')
+		redis.call('PEXPIRE', copyKey, ARGV[2])
+	end
+	return redis.call('HGET', KEYS[2], 'allSum') or '0'
+`)
+
+// getSnapshot returns the matrix along with the total count of the CountMinSketchRedis.
+// A sketch of up to cmsPageSize counters is read atomically in a single Lua script. The
+// reply of the script holds the whole matrix, so the rows of a larger sketch are copied
+// along with its total count in a single Lua script instead, and the copies are read row
+// by row in pages of cmsPageSize counters.
+func (cms *CountMinSketchRedis) getSnapshot() ([][]uint64, uint64, error) {
+	if uint64(cms.rows)*uint64(cms.columns) > cmsPageSize {
+		return cms.getPagedSnapshot(context.Background())
+	}
+	return cms.getAtomicSnapshot()
+}
+
+// getPagedSnapshot reads the matrix of the CountMinSketchRedis in pages, see getSnapshot
+func (cms *CountMinSketchRedis) getPagedSnapshot(ctx context.Context) ([][]uint64, uint64, error) {
+	copyKey, err := newRedisKey(ctx, cms.key+"_snapshot_")
+	if err != nil {
+		return nil, 0, err
+	}
+	copyKeys := make([]string, cms.rows)
+	for i := range copyKeys {
+		copyKeys[i] = copyKey + strconv.Itoa(i)
+	}
+	// the copies expire anyway if they can't be deleted
+	defer getRedisClient().Del(ctx, copyKeys...)
+	result, err := cmsCopyScript.Run(
+		ctx,
+		getRedisClient(),
+		[]string{cms.key, cms.metadataKey, copyKey},
+		cms.rows,
+		cmsSnapshotTTL.Milliseconds(),
+	).Text()
+	if err != nil {
+		return nil, 0, fmt.Errorf("gostatix: error while copying sketch %s in redis, error: %v", cms.key, err)
+	}
+	allSum, err := strconv.ParseUint(result, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("gostatix: error parsing total count from redis, error: %v", err)
+	}
+	matrix, err := cms.getPagedMatrix(ctx, copyKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	return matrix, allSum, nil
+}
+
+// getPagedMatrix reads the rows prefixed by _key_ in pages of cmsPageSize counters
+func (cms *CountMinSketchRedis) getPagedMatrix(ctx context.Context, key string) ([][]uint64, error) {
+	client := getRedisClient()
+	matrix := make([][]uint64, cms.rows)
+	for i := range matrix {
+		rowKey := key + strconv.Itoa(i)
+		row := make([]uint64, 0, cms.columns)
+		for start := uint64(0); start < uint64(cms.columns); start += cmsPageSize {
+			values, err := client.LRange(ctx, rowKey, int64(start), int64(start+cmsPageSize-1)).Result()
+			if err != nil {
+				return nil, fmt.Errorf("gostatix: error fetching row %s from redis, error: %v", rowKey, err)
+			}
+			for _, value := range values {
+				count, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("gostatix: error parsing matrix from redis, error: %v", err)
+				}
+				row = append(row, count)
+			}
+		}
+		if len(row) != int(cms.columns) {
+			return nil, fmt.Errorf("gostatix: row %s has %d columns instead of %d", rowKey, len(row), cms.columns)
+		}
+		matrix[i] = row
+	}
+	return matrix, nil
+}
+
+// getAtomicSnapshot returns the matrix along with the total count of the CountMinSketchRedis
+// read atomically in a single Lua script
+func (cms *CountMinSketchRedis) getAtomicSnapshot() ([][]uint64, uint64, error) {
+	result, err := cmsSnapshotScript.Run(
 		context.Background(),
 		getRedisClient(),
-		[]string{cms.key},
+		[]string{cms.key, cms.metadataKey},
 		cms.rows,
 	).Slice()
 	if err != nil {
-		return nil, fmt.Errorf("gostatix: error fetching matrix from redis, error: %v", err)
+		return nil, 0, fmt.Errorf("gostatix: error fetching snapshot from redis, error: %v", err)
 	}
+	if len(result) != 2 {
+		return nil, 0, fmt.Errorf("gostatix: error parsing snapshot from redis")
+	}
+	allSum, err := strconv.ParseUint(fmt.Sprint(result[0]), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("gostatix: error parsing total count from redis, error: %v", err)
+	}
+	rows, ok := result[1].([]interface{})
+	if !ok {
+		return nil, 0, fmt.Errorf("gostatix: error parsing matrix from redis")
+	}
+	matrix, err := parseMatrix(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return matrix, allSum, nil
+}
 
+// parseMatrix converts the rows of counts returned by a Lua script into a matrix
+func parseMatrix(result []interface{}) ([][]uint64, error) {
 	matrix := make([][]uint64, len(result))
 	for i := range result {
 		rowSlice, ok := result[i].([]interface{})
@@ -359,6 +848,25 @@ func (cms *CountMinSketchRedis) getMatrix() ([][]uint64, error) {
 	return matrix, nil
 }
 
+var cmsSetMatrixScript = redis.NewScript(`
+	local key = KEYS[1]
+	local columns = tonumber(ARGV[1])
+	local index = 2
+	local rows = #ARGV / columns
+	for i=1, rows do
+		local rowKey = key .. tostring(i-1)
+		redis.call('DEL', rowKey)
+		local pushed = 0
+		while pushed < columns do
+			local n = math.min(columns - pushed, 1000)
+			redis.call('RPUSH', rowKey, unpack(ARGV, index, index + n - 1))
+			index = index + n
+			pushed = pushed + n
+		end
+	end
+	return true
+`)
+
 func (cms *CountMinSketchRedis) setMatrix(matrix [][]uint64) error {
 	flattenedMatrix := util.Flatten(matrix)
 	args := make([]interface{}, len(flattenedMatrix)+1)
@@ -366,24 +874,7 @@ func (cms *CountMinSketchRedis) setMatrix(matrix [][]uint64) error {
 	for i := range flattenedMatrix {
 		args[i+1] = interface{}(flattenedMatrix[i])
 	}
-	setMatrixScript := redis.NewScript(`
-		local key = KEYS[1]
-		local columns = tonumber(ARGV[1])
-		local index = 2
-		local rows = #ARGV / columns
-		for i=1, rows do
-			local row = {}
-			local rowKey = key .. tostring(i-1)
-			for j=1, columns do
-				row[j] = ARGV[index]
-				index = index + 1
-			end
-			redis.call('DEL', rowKey)
-			redis.call('RPUSH', rowKey, unpack(row))
-		end
-		return true
-	`)
-	_, err := setMatrixScript.Run(
+	_, err := cmsSetMatrixScript.Run(
 		context.Background(),
 		getRedisClient(),
 		[]string{cms.key},
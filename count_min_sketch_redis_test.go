@@ -1,8 +1,11 @@
 package gostatix
 
 import (
+	"context"
+	"encoding/json"
 	"math/rand"
 	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/alicebob/miniredis/v2"
@@ -134,6 +137,156 @@ func TestCountMinSketchRedisImportFromKey(t *testing.T) {
 	}
 }
 
+func TestCountMinSketchRedisDimensions(t *testing.T) {
+	initMockRedis()
+	cms, _ := NewCountMinSketchRedis(3, 500)
+	if cms.Rows() != 3 || cms.Columns() != 500 {
+		t.Errorf("dimensions should be 3x500, found %dx%d", cms.Rows(), cms.Columns())
+	}
+	cms.UpdateString("foo", 2)
+	cms.UpdateString("bar", 1)
+	if total, _ := cms.TotalCount(); total != 3 {
+		t.Errorf("total count should be 3, found %d", total)
+	}
+}
+
+func TestCountMinSketchRedisSharedTotalCount(t *testing.T) {
+	initMockRedis()
+	cms1, _ := NewCountMinSketchRedis(3, 500)
+	cms2, _ := NewCountMinSketchRedisFromKey(cms1.MetadataKey())
+
+	cms1.UpdateString("foo", 2)
+	cms2.UpdateString("bar", 5)
+
+	total1, _ := cms1.TotalCount()
+	total2, _ := cms2.TotalCount()
+	if total1 != 7 || total2 != 7 {
+		t.Errorf("total count should be 7 for both clients, found %d and %d", total1, total2)
+	}
+
+	data, _ := cms1.Export()
+	cms3, _ := NewCountMinSketchRedis(3, 500)
+	cms3.Import(data, true)
+	if total, _ := cms3.TotalCount(); total != 7 {
+		t.Errorf("total count should be 7 after import, found %d", total)
+	}
+
+	cms4, _ := NewCountMinSketchRedisFromKey(cms1.MetadataKey())
+	if total, _ := cms4.TotalCount(); total != 7 {
+		t.Errorf("total count should be 7 when loaded from key, found %d", total)
+	}
+}
+
+func TestCountMinSketchRedisExportConsistent(t *testing.T) {
+	initMockRedis()
+	cms, _ := NewCountMinSketchRedis(4, 50)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				cms.UpdateString(strconv.Itoa(w*1000+i), uint64(i%3+1))
+			}
+		}(w)
+	}
+	for i := 0; i < 20; i++ {
+		data, err := cms.Export()
+		if err != nil {
+			t.Fatalf("export shouldn't error out, error: %v", err)
+		}
+		var snapshot countMinSketchJSON
+		json.Unmarshal(data, &snapshot)
+		for r := range snapshot.Matrix {
+			rowSum := uint64(0)
+			for _, count := range snapshot.Matrix[r] {
+				rowSum += count
+			}
+			if rowSum != snapshot.AllSum {
+				t.Fatalf("sum of row %d is %d, should match the total count %d", r, rowSum, snapshot.AllSum)
+			}
+		}
+	}
+	wg.Wait()
+}
+
+func TestCountMinSketchRedisPagedExportConsistent(t *testing.T) {
+	initMockRedis()
+	defer func(pageSize uint64) { cmsPageSize = pageSize }(cmsPageSize)
+	cmsPageSize = 16
+	cms, _ := NewCountMinSketchRedis(4, 50)
+	source, _ := NewCountMinSketchRedis(4, 50)
+	source.UpdateString("source", 3)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		// the total count is the same before and after each iteration
+		for i := 0; i < 100; i++ {
+			cms.UpdateString(strconv.Itoa(i), 2)
+			cms.decrement([]byte(strconv.Itoa(i)), 2)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			cms.Merge(source)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		data, err := cms.Export()
+		if err != nil {
+			t.Fatalf("export shouldn't error out, error: %v", err)
+		}
+		var snapshot countMinSketchJSON
+		json.Unmarshal(data, &snapshot)
+		for r := range snapshot.Matrix {
+			rowSum := uint64(0)
+			for _, count := range snapshot.Matrix[r] {
+				rowSum += count
+			}
+			if rowSum != snapshot.AllSum {
+				t.Fatalf("sum of row %d is %d, should match the total count %d", r, rowSum, snapshot.AllSum)
+			}
+		}
+	}
+	wg.Wait()
+	copies, _ := getRedisClient().Keys(context.Background(), cms.key+"_snapshot_*").Result()
+	if len(copies) != 0 {
+		t.Errorf("copies of the rows should be deleted after the export, found %v", copies)
+	}
+}
+
+func TestCountMinSketchRedisPagedExport(t *testing.T) {
+	initMockRedis()
+	// the rows are read in 2 pages each, the last one partial
+	cms, _ := NewCountMinSketchRedis(2, uint(cmsPageSize)+100)
+	for i := 0; i < 200; i++ {
+		cms.UpdateString(strconv.Itoa(i), uint64(i%5+1))
+	}
+	data, err := cms.Export()
+	if err != nil {
+		t.Fatalf("export shouldn't error out, error: %v", err)
+	}
+	var snapshot countMinSketchJSON
+	json.Unmarshal(data, &snapshot)
+	if snapshot.AllSum != 600 || len(snapshot.Matrix) != 2 || len(snapshot.Matrix[1]) != int(cmsPageSize)+100 {
+		t.Fatalf("snapshot should have 2 full rows and a total count of 600, got %d rows and %d", len(snapshot.Matrix), snapshot.AllSum)
+	}
+	imported, _ := NewCountMinSketchRedis(1, 1)
+	err = imported.Import(data, true)
+	if err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if equal, _ := imported.Equals(cms); !equal {
+		t.Errorf("imported sketch should equal the exported one")
+	}
+	getRedisClient().RPop(context.Background(), cms.key+"1")
+	if _, err := cms.Export(); err == nil {
+		t.Errorf("export of a truncated row should error out")
+	}
+}
+
 func initMockRedis() {
 	mr, _ := miniredis.Run()
 	redisUri := "redis://" + mr.Addr()
@@ -179,3 +332,75 @@ func BenchmarkCMSRedisLookup0001X09999(b *testing.B) {
 		cms.Count([]byte(strconv.FormatUint(rand.Uint64(), 10)))
 	}
 }
+
+func TestCMSRedisReset(t *testing.T) {
+	initMockRedis()
+	cms, _ := NewCountMinSketchRedis(3, 2500)
+	cms.UpdateString("alice", 3)
+	err := cms.Reset()
+	if err != nil {
+		t.Fatalf("reset shouldn't error out, error: %v", err)
+	}
+	if count, _ := cms.CountString("alice"); count != 0 {
+		t.Errorf("count of alice should be 0 after reset, got %d", count)
+	}
+	if total, _ := cms.TotalCount(); total != 0 {
+		t.Errorf("total count should be 0 after reset, got %d", total)
+	}
+	length, _ := getRedisClient().LLen(context.Background(), cms.key+"2").Result()
+	if length != 2500 {
+		t.Errorf("rows should keep 2500 columns, got %d", length)
+	}
+	cms.UpdateString("alice", 2)
+	if count, _ := cms.CountString("alice"); count != 2 {
+		t.Errorf("count of alice should be 2 after reset, got %d", count)
+	}
+}
+
+func TestCMSRedisPositionKeys(t *testing.T) {
+	initMockRedis()
+	cms, _ := NewCountMinSketchRedis(4, 50)
+	data := []byte("foo")
+	keys := cms.appendPositionKeys(nil, data)
+	for r, c := range cms.getPositions(data) {
+		if keys[2*r] != strconv.Itoa(r) || keys[2*r+1] != strconv.FormatUint(uint64(c), 10) {
+			t.Errorf("keys of row %d should be its index and column %d, got %s and %s", r, c, keys[2*r], keys[2*r+1])
+		}
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		keys := getKeys()
+		*keys = cms.appendPositionKeys(*keys, data)
+		putKeys(keys)
+	})
+	if allocs != 0 {
+		t.Errorf("building the keys of an element shouldn't allocate, got %v allocations", allocs)
+	}
+	cms.UpdateString("foo", 3)
+	if count, _ := cms.CountString("foo"); count != 3 {
+		t.Errorf("count of foo should be 3, got %d", count)
+	}
+}
+
+func TestCountMinSketchRedisUpdateAndCount(t *testing.T) {
+	initMockRedis()
+	cms, _ := NewCountMinSketchRedis(4, 50)
+	expected, _ := NewCountMinSketchRedis(4, 50)
+	for i := 0; i < 300; i++ {
+		element := []byte(strconv.Itoa(i % 70))
+		expected.Update(element, uint64(i%3+1))
+		count, err := cms.updateAndCount(element, uint64(i%3+1))
+		if err != nil {
+			t.Fatalf("update and count shouldn't error out, error: %v", err)
+		}
+		if want, _ := expected.Count(element); count != want {
+			t.Fatalf("count of %s after the update should be %d, got %d", element, want, count)
+		}
+	}
+	if ok, _ := cms.Equals(expected); !ok {
+		t.Errorf("sketch updated with updateAndCount should equal the one updated with Update")
+	}
+	total, _ := cms.TotalCount()
+	if want, _ := expected.TotalCount(); total != want {
+		t.Errorf("total count should be %d, got %d", want, total)
+	}
+}
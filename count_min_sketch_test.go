@@ -2,9 +2,11 @@ package gostatix
 
 import (
 	"bytes"
+	"math"
 	"math/rand"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -113,11 +115,40 @@ func TestCountMinSketchImportExport(t *testing.T) {
 	cms3, _ := NewCountMinSketchFromEstimates(0.001, delta)
 	cms3.Import(sketch1)
 
-	if !cms1.Equals(cms3) {
+	if !equal(cms1.Equals(cms3)) {
 		t.Errorf("cms1 and cms3 should be equal")
 	}
 }
 
+func TestCountMinSketchDimensions(t *testing.T) {
+	cms, _ := NewCountMinSketch(4, 2000)
+	if cms.Rows() != 4 {
+		t.Errorf("rows should be 4, found %d", cms.Rows())
+	}
+	if cms.Columns() != 2000 {
+		t.Errorf("columns should be 2000, found %d", cms.Columns())
+	}
+	if math.Abs(cms.ErrorRate()-math.E/2000) > 1e-12 {
+		t.Errorf("error rate should be %f, found %f", math.E/2000, cms.ErrorRate())
+	}
+	if math.Abs(cms.Delta()-math.Exp(-4)) > 1e-12 {
+		t.Errorf("delta should be %f, found %f", math.Exp(-4), cms.Delta())
+	}
+
+	cms.UpdateString("foo", 3)
+	cms.UpdateString("bar", 2)
+	if cms.TotalCount() != 5 {
+		t.Errorf("total count should be 5, found %d", cms.TotalCount())
+	}
+
+	other, _ := NewCountMinSketch(4, 2000)
+	other.UpdateString("foo", 4)
+	cms.Merge(other)
+	if cms.TotalCount() != 9 {
+		t.Errorf("total count should be 9 after merge, found %d", cms.TotalCount())
+	}
+}
+
 func TestCountMinSketchBinaryReadWrite(t *testing.T) {
 	cms1, _ := NewCountMinSketchFromEstimates(0.001, delta)
 
@@ -138,7 +169,7 @@ func TestCountMinSketchBinaryReadWrite(t *testing.T) {
 		t.Error("should not error out reading from buffer")
 	}
 
-	if !cms1.Equals(cms2) {
+	if !equal(cms1.Equals(cms2)) {
 		t.Error("cms1 and cms2 should be equal")
 	}
 
@@ -180,6 +211,19 @@ func BenchmarkCMSLookup001X0999(b *testing.B) {
 	}
 }
 
+func BenchmarkCMSLookupParallel001X0999(b *testing.B) {
+	cms, _ := NewCountMinSketchFromEstimates(0.001, delta)
+	for i := 0; i < 1000; i++ {
+		cms.Update([]byte(strconv.FormatUint(rand.Uint64(), 10)), 1)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cms.Count([]byte(strconv.FormatUint(rand.Uint64(), 10)))
+		}
+	})
+}
+
 func BenchmarkCMSLookup0001X09999(b *testing.B) {
 	b.StopTimer()
 	cms, _ := NewCountMinSketchFromEstimates(0.00001, 0.99999)
@@ -191,3 +235,83 @@ func BenchmarkCMSLookup0001X09999(b *testing.B) {
 		cms.Count([]byte(strconv.FormatUint(rand.Uint64(), 10)))
 	}
 }
+
+func TestCountMinSketchUpdateFromReader(t *testing.T) {
+	cms, _ := NewCountMinSketchFromEstimates(0.001, delta)
+	var keys strings.Builder
+	for i := 0; i < 250000; i++ {
+		keys.WriteString(strconv.Itoa(i % 10))
+		keys.WriteByte('\n')
+	}
+	var reported []uint64
+	count, err := cms.UpdateFromReader(strings.NewReader(keys.String()), '\n', func(processed uint64) {
+		reported = append(reported, processed)
+	})
+	if err != nil {
+		t.Fatalf("update from reader shouldn't error out, error: %v", err)
+	}
+	if count != 250000 || cms.TotalCount() != 250000 {
+		t.Errorf("250000 keys should be processed, found count %d and total count %d", count, cms.TotalCount())
+	}
+	if !reflect.DeepEqual(reported, []uint64{100000, 200000, 250000}) {
+		t.Errorf("unexpected progress reports %v", reported)
+	}
+	if cms.Count([]byte("7")) != 25000 {
+		t.Errorf("count of 7 should be 25000, found %d", cms.Count([]byte("7")))
+	}
+}
+
+func BenchmarkCMSUpdateCountKeys001X0999(b *testing.B) {
+	cms, _ := NewCountMinSketchFromEstimates(0.001, delta)
+	keys := make([][]byte, 1024)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := keys[i%len(keys)]
+		cms.Update(key, 1)
+		cms.Count(key)
+	}
+}
+
+func TestCMSAllocations(t *testing.T) {
+	cms, _ := NewCountMinSketch(8, 1000)
+	data := []byte("foo")
+	allocs := testing.AllocsPerRun(100, func() {
+		cms.Update(data, 1)
+		cms.Count(data)
+	})
+	if allocs != 0 {
+		t.Errorf("update and count shouldn't allocate, got %v allocations", allocs)
+	}
+}
+
+func TestCMSReset(t *testing.T) {
+	cms, _ := NewCountMinSketch(4, 100)
+	cms.UpdateString("alice", 3)
+	cms.Reset()
+	if cms.CountString("alice") != 0 || cms.TotalCount() != 0 {
+		t.Error("sketch should be empty after reset")
+	}
+	cms.UpdateString("alice", 2)
+	if cms.CountString("alice") != 2 {
+		t.Error("count of alice should be 2 after reset")
+	}
+}
+
+func TestCountMinSketchUpdateAndCount(t *testing.T) {
+	cms, _ := NewCountMinSketch(4, 50)
+	expected, _ := NewCountMinSketch(4, 50)
+	for i := 0; i < 300; i++ {
+		element := []byte(strconv.Itoa(i % 70))
+		expected.Update(element, uint64(i%3+1))
+		if count := cms.updateAndCount(element, uint64(i%3+1)); count != expected.Count(element) {
+			t.Fatalf("count of %s after the update should be %d, got %d", element, expected.Count(element), count)
+		}
+	}
+	if ok, _ := cms.Equals(expected); !ok || cms.TotalCount() != expected.TotalCount() {
+		t.Errorf("sketch updated with updateAndCount should equal the one updated with Update")
+	}
+}
@@ -7,30 +7,44 @@ package gostatix
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"math"
-	"math/rand"
+	"sort"
 	"sync"
+	"unsafe"
 
 	"github.com/kwertop/gostatix/internal/util"
 )
 
 // CuckooFilter is the in-memory implementation of BaseCuckooFilter
-// _buckets_ is a slice of BucketMem
+// _buckets_ holds the fingerprints of all the buckets packed in a slice of words
 // _length_ represents the number of entries present in the Cuckoo Filter
+// _kicks_ is the buffer of the entries kicked out of their buckets during an insert
+// _history_ counts the inserts of each element, by hash, once safe removes are enabled
+// _multiset_ is set once multiset inserts are enabled
+// _payloads_ holds the values stored along with the entries by Put
 // _lock_ is used to synchronize concurrent read/writes
+// _updated_ records the time of the last update
 type CuckooFilter struct {
-	buckets []BucketMem
-	length  uint64
+	buckets  *packedBuckets
+	length   uint64
+	kicks    []packedEntry
+	history  map[uint64]uint64
+	multiset bool
+	payloads map[cuckooEntry][]byte
 	*AbstractCuckooFilter
-	lock sync.RWMutex
+	lock      sync.RWMutex
+	resources resources
+	updated   updateClock
 }
 
 // NewCuckooFilter creates a new in-memory CuckooFilter
 // _size_ is the size of the BucketMem slice
 // _bucketSize_ is the size of the individual buckets inside the bucket slice
 // _fingerPrintLength_ is fingerprint hash of the input to be inserted/removed/lookup
-func NewCuckooFilter(size, bucketSize, fingerPrintLength uint64) *CuckooFilter {
+func NewCuckooFilter(size, bucketSize, fingerPrintLength uint64) (*CuckooFilter, error) {
 	return NewCuckooFilterWithRetries(size, bucketSize, fingerPrintLength, 500)
 }
 
@@ -40,13 +54,37 @@ func NewCuckooFilter(size, bucketSize, fingerPrintLength uint64) *CuckooFilter {
 // _fingerPrintLength_ is fingerprint hash of the input to be inserted/removed/lookup
 // _retries_ is the number of retries that the Cuckoo filter makes if the first two indices obtained
 // after hashing the input is already occupied in the filter
-func NewCuckooFilterWithRetries(size, bucketSize, fingerPrintLength, retries uint64) *CuckooFilter {
-	filter := make([]BucketMem, size)
-	for i := range filter {
-		filter[i] = *newBucketMem(bucketSize)
+func NewCuckooFilterWithRetries(size, bucketSize, fingerPrintLength, retries uint64) (*CuckooFilter, error) {
+	baseFilter, err := makeAbstractCuckooFilter(size, bucketSize, fingerPrintLength, retries)
+	if err != nil {
+		return nil, err
 	}
-	baseFilter := makeAbstractCuckooFilter(size, bucketSize, fingerPrintLength, retries)
-	return &CuckooFilter{buckets: filter, AbstractCuckooFilter: baseFilter}
+	buckets := newPackedBuckets(size, bucketSize, fingerPrintLength)
+	return &CuckooFilter{buckets: buckets, AbstractCuckooFilter: baseFilter}, nil
+}
+
+// NewCuckooFilterWithCandidates creates an in-memory CuckooFilter whose fingerprints have
+// _candidates_ buckets, 2 to 4, instead of 2. An insert can then kick the entries out to
+// more buckets, so the filter fills up to a higher load before its inserts fail, at the cost
+// of lookups probing more buckets and of a higher false positive rate for the same
+// fingerprint length. The kicked out entries are moved to one of their other candidate
+// buckets drawn at random.
+// _size_ should be a power of _candidates_ when they're 3 or 4, e.g. 3^10 or 4^8 buckets
+// _retries_ is the number of retries that the Cuckoo filter makes if all the candidate buckets
+// are occupied
+func NewCuckooFilterWithCandidates(size, bucketSize, fingerPrintLength, retries, candidates uint64) (*CuckooFilter, error) {
+	err := checkCuckooCandidates(size, candidates)
+	if err != nil {
+		return nil, err
+	}
+	cuckooFilter, err := NewCuckooFilterWithRetries(size, bucketSize, fingerPrintLength, retries)
+	if err != nil {
+		return nil, err
+	}
+	if candidates > 2 {
+		cuckooFilter.candidates = candidates
+	}
+	return cuckooFilter, nil
 }
 
 // NewCuckooFilterWithErrorRate creates an in-memory CuckooFilter with a specified false positive
@@ -56,7 +94,11 @@ func NewCuckooFilterWithRetries(size, bucketSize, fingerPrintLength, retries uin
 // _retries_ is the number of retries that the Cuckoo filter makes if the first two indices obtained
 // _errorRate_ is the desired false positive rate of the filter. fingerPrintLength is calculated
 // according to this error rate.
-func NewCuckooFilterWithErrorRate(size, bucketSize, retries uint64, errorRate float64) *CuckooFilter {
+func NewCuckooFilterWithErrorRate(size, bucketSize, retries uint64, errorRate float64) (*CuckooFilter, error) {
+	err := checkCuckooFilterEstimates(size, bucketSize, errorRate)
+	if err != nil {
+		return nil, err
+	}
 	fingerPrintLength := util.CalculateFingerPrintLength(size, errorRate)
 	capacity := uint64(math.Ceil(float64(size) * 0.955 / float64(bucketSize)))
 	return NewCuckooFilterWithRetries(capacity, bucketSize, fingerPrintLength, retries)
@@ -65,98 +107,446 @@ func NewCuckooFilterWithErrorRate(size, bucketSize, retries uint64, errorRate fl
 // Length returns the current length of the Cuckoo Filter or the current number of entries
 // present in the Cuckoo Filter
 func (cuckooFilter *CuckooFilter) Length() uint64 {
+	cuckooFilter.lock.RLock()
+	defer cuckooFilter.lock.RUnlock()
+
 	return cuckooFilter.length
 }
 
 // Insert writes the _data_ in the Cuckoo Filter for future lookup
 // _destructive_ parameter is used to specify if the previous ordering of the
 // present entries is to be preserved after the retries (if that case arises)
+// It panics if the filter is full and returns false if the insert fails otherwise.
+//
+// Deprecated: use InsertWithStats, which returns an error instead of panicking.
 func (cuckooFilter *CuckooFilter) Insert(data []byte, destructive bool) bool {
+	_, err := cuckooFilter.InsertWithStats(data, destructive, 0)
+	if errors.Is(err, ErrCuckooFilterFull) {
+		panic("cannot insert element, cuckoofilter is full")
+	}
+	return err == nil
+}
+
+// InsertWithStats writes the _data_ in the Cuckoo Filter like Insert and returns the
+// statistics of the insert. It returns an error instead of panicking if the filter is full.
+// _destructive_ parameter is used to specify if the previous ordering of the
+// present entries is to be preserved after the retries (if that case arises)
+// _maxKicks_ overrides the number of retries of the filter for this insert, 0 keeps them
+func (cuckooFilter *CuckooFilter) InsertWithStats(data []byte, destructive bool, maxKicks uint64) (CuckooInsertStats, error) {
 	cuckooFilter.lock.Lock()
 	defer cuckooFilter.lock.Unlock()
+	cuckooFilter.updated.touch()
+	return cuckooFilter.insert(data, destructive, maxKicks)
+}
 
-	fingerPrint, fIndex, sIndex, _ := cuckooFilter.getPositions(data)
-	if cuckooFilter.buckets[fIndex].isFree() {
-		cuckooFilter.buckets[fIndex].add(fingerPrint)
-	} else if cuckooFilter.buckets[sIndex].isFree() {
-		cuckooFilter.buckets[sIndex].add(fingerPrint)
-	} else {
-		var index uint64
-		if rand.Float32() < 0.5 {
-			index = fIndex
-		} else {
-			index = sIndex
+// AddIfNotExists inserts _data_ in the Cuckoo Filter and returns true if it wasn't already
+// present. The lookup and the insert are done under the same lock, so that concurrent
+// calls with the same _data_ return true only once. It returns an error if the filter is full.
+func (cuckooFilter *CuckooFilter) AddIfNotExists(data []byte) (bool, error) {
+	cuckooFilter.lock.Lock()
+	defer cuckooFilter.lock.Unlock()
+
+	fingerPrint, candidates := cuckooFilter.getPackedCandidates(data)
+	if cuckooFilter.lookupCandidates(fingerPrint, &candidates) {
+		return false, nil
+	}
+	cuckooFilter.updated.touch()
+	_, err := cuckooFilter.insert(data, false, 0)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// insert is InsertWithStats without the lock
+func (cuckooFilter *CuckooFilter) insert(data []byte, destructive bool, maxKicks uint64) (CuckooInsertStats, error) {
+	var stats CuckooInsertStats
+	fingerPrint, candidates := cuckooFilter.getPackedCandidates(data)
+	if cuckooFilter.multiset {
+		copies, capacity := cuckooFilter.copies(fingerPrint, &candidates)
+		if copies >= capacity {
+			return stats, fmt.Errorf("%w, its buckets are full of its %d copies", ErrCuckooFilterFull, copies)
 		}
+	}
+	if freeIndex, ok := cuckooFilter.freeCandidate(&candidates); ok {
+		cuckooFilter.buckets.add(freeIndex, fingerPrint)
+	} else {
+		index := candidates.indexes[cuckooFilter.randInt63n(int64(candidates.n))]
 		currFingerPrint := fingerPrint
-		var items []entry
-		for i := uint64(0); i < cuckooFilter.retries; i++ {
-			randIndex := uint64(math.Ceil(rand.Float64() * float64(cuckooFilter.buckets[index].getLength()-1)))
-			prevFingerPrint := cuckooFilter.buckets[index].at(randIndex)
-			items = append(items, entry{prevFingerPrint, index, randIndex})
-			cuckooFilter.buckets[index].set(randIndex, currFingerPrint)
-			hash := getHash([]byte(prevFingerPrint))
-			newIndex := (index ^ hash) % uint64(len(cuckooFilter.buckets))
-			if cuckooFilter.buckets[newIndex].isFree() {
-				cuckooFilter.buckets[newIndex].add(prevFingerPrint)
+		// the kicked out entries are tracked in a buffer reused across the inserts
+		items := cuckooFilter.kicks[:0]
+		defer func() { cuckooFilter.kicks = items[:0] }()
+		retries := cuckooFilter.maxKicks(maxKicks)
+		var kickErr error
+		for i := uint64(0); i < retries; i++ {
+			var randIndex uint64
+			randIndex, kickErr = cuckooFilter.kickPosition(cuckooFilter.buckets.getLength(index))
+			if kickErr != nil {
+				break
+			}
+			prevFingerPrint := cuckooFilter.buckets.at(index, randIndex)
+			items = append(items, packedEntry{prevFingerPrint, index, randIndex})
+			stats.Kicks++
+			cuckooFilter.buckets.set(index, randIndex, currFingerPrint)
+			newIndex := cuckooFilter.getKickIndex(index, prevFingerPrint)
+			if cuckooFilter.buckets.isFree(newIndex) {
+				cuckooFilter.buckets.add(newIndex, prevFingerPrint)
 				cuckooFilter.length++
-				return true
+				cuckooFilter.recordInsert(data)
+				return stats, nil
 			}
+			// the kicked out entry is the one to place next, in another of its buckets
+			index, currFingerPrint = newIndex, prevFingerPrint
 		}
-		if !destructive {
+		// the kicks are always rolled back when a bucket can't be kicked out of
+		if !destructive || kickErr != nil {
 			for i := len(items) - 1; i >= 0; i-- {
 				item := items[i]
-				cuckooFilter.buckets[item.firstIndex].set(item.secondIndex, item.fingerPrint)
+				cuckooFilter.buckets.set(item.firstIndex, item.secondIndex, item.fingerPrint)
 			}
+			stats.RolledBack = true
 		}
-		panic("cannot insert element, cuckoofilter is full")
+		if kickErr != nil {
+			return stats, kickErr
+		}
+		return stats, fmt.Errorf("%w after %d kicks", ErrCuckooFilterFull, stats.Kicks)
 	}
 	cuckooFilter.length++
-	return true
+	cuckooFilter.recordInsert(data)
+	return stats, nil
 }
 
-// Lookup returns true if the _data_ is present in the Cuckoo Filter, else false
-func (cuckooFilter *CuckooFilter) Lookup(data []byte) bool {
+// EnableSafeRemove makes Remove refuse to delete the elements which were never inserted.
+// Removing such an element deletes the fingerprint of another element which collides with
+// it, which then isn't found anymore. The 64-bit hashes of the inserted elements are
+// recorded along with their number of inserts, which takes about 16 bytes per element,
+// so a false removal is only possible on a collision of those hashes.
+// It errors out if the filter isn't empty as the history of its elements is unknown.
+// The history isn't exported, Import and ReadFrom disable safe removes.
+func (cuckooFilter *CuckooFilter) EnableSafeRemove() error {
 	cuckooFilter.lock.Lock()
 	defer cuckooFilter.lock.Unlock()
 
-	fingerPrint, fIndex, sIndex, _ := cuckooFilter.getPositions(data)
-	return cuckooFilter.buckets[fIndex].lookup(fingerPrint) ||
-		cuckooFilter.buckets[sIndex].lookup(fingerPrint)
+	if cuckooFilter.length > 0 {
+		return fmt.Errorf("gostatix: safe removes can only be enabled on an empty cuckoo filter, filter has %d entries", cuckooFilter.length)
+	}
+	if cuckooFilter.history == nil {
+		cuckooFilter.history = make(map[uint64]uint64)
+	}
+	return nil
 }
 
-// Remove deletes the _data_ from the Cuckoo Filter
-func (cuckooFilter *CuckooFilter) Remove(data []byte) bool {
+// EnableMultiset makes the Cuckoo Filter count the inserts of the same element, e.g. when
+// the same key is inserted from several sources. Each insert stores a copy of the
+// fingerprint of the element, Remove deletes one copy and Count returns the number of
+// copies. The copies of an element are held by its candidate buckets, so an insert fails fast
+// once they are full of its copies instead of kicking the other entries out to no avail.
+// Combined with EnableSafeRemove, an element can't be removed more times than inserted.
+func (cuckooFilter *CuckooFilter) EnableMultiset() {
 	cuckooFilter.lock.Lock()
 	defer cuckooFilter.lock.Unlock()
 
-	fingerPrint, fIndex, sIndex, _ := cuckooFilter.getPositions(data)
-	if cuckooFilter.buckets[fIndex].lookup(fingerPrint) {
-		cuckooFilter.buckets[fIndex].remove(fingerPrint)
-		cuckooFilter.length--
-		return true
-	} else if cuckooFilter.buckets[sIndex].lookup(fingerPrint) {
-		cuckooFilter.buckets[sIndex].remove(fingerPrint)
-		cuckooFilter.length--
-		return true
-	} else {
-		return false
+	cuckooFilter.multiset = true
+}
+
+// Multiset returns true if the multiset inserts are enabled, see EnableMultiset
+func (cuckooFilter *CuckooFilter) Multiset() bool {
+	cuckooFilter.lock.RLock()
+	defer cuckooFilter.lock.RUnlock()
+
+	return cuckooFilter.multiset
+}
+
+// Count returns the number of copies of the fingerprint of _data_ in its candidate buckets, the
+// number of times it was inserted and not removed if the multiset inserts are enabled.
+// Like Lookup, it can be higher because of the elements whose fingerprints collide.
+func (cuckooFilter *CuckooFilter) Count(data []byte) uint64 {
+	cuckooFilter.lock.RLock()
+	defer cuckooFilter.lock.RUnlock()
+
+	fingerPrint, candidates := cuckooFilter.getPackedCandidates(data)
+	copies, _ := cuckooFilter.copies(fingerPrint, &candidates)
+	return copies
+}
+
+// CountString returns the number of copies of the fingerprint of _data_ (string)
+func (cuckooFilter *CuckooFilter) CountString(data string) uint64 {
+	return cuckooFilter.Count([]byte(data))
+}
+
+// copies returns the number of copies of _fingerPrint_ in the buckets of _candidates_ and
+// the number of fingerprints these buckets can hold
+func (cuckooFilter *CuckooFilter) copies(fingerPrint uint64, candidates *cuckooCandidates) (uint64, uint64) {
+	copies := uint64(0)
+	for _, index := range candidates.list() {
+		copies += cuckooFilter.buckets.count(index, fingerPrint)
+	}
+	return copies, uint64(candidates.n) * cuckooFilter.bucketSize
+}
+
+// lookupCandidates returns true if _fingerPrint_ is in one of the buckets of _candidates_
+func (cuckooFilter *CuckooFilter) lookupCandidates(fingerPrint uint64, candidates *cuckooCandidates) bool {
+	_, ok := cuckooFilter.findCandidate(fingerPrint, candidates)
+	return ok
+}
+
+// findCandidate returns the first bucket of _candidates_ holding _fingerPrint_, false if
+// none of them holds it
+func (cuckooFilter *CuckooFilter) findCandidate(fingerPrint uint64, candidates *cuckooCandidates) (uint64, bool) {
+	for _, index := range candidates.list() {
+		if cuckooFilter.buckets.lookup(index, fingerPrint) {
+			return index, true
+		}
 	}
+	return 0, false
 }
 
-// Equals checks if two CuckooFilter are same or not
-func (aFilter *CuckooFilter) Equals(bFilter *CuckooFilter) bool {
-	count := 0
-	result := true
-	for result && count < len(aFilter.buckets) {
-		bucket := aFilter.buckets[count]
-		if !bFilter.buckets[count].equals(&bucket) {
+// freeCandidate returns the first bucket of _candidates_ which isn't full, false if all
+// of them are full
+func (cuckooFilter *CuckooFilter) freeCandidate(candidates *cuckooCandidates) (uint64, bool) {
+	for _, index := range candidates.list() {
+		if cuckooFilter.buckets.isFree(index) {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
+// Put stores _value_ along with _data_, turning the Cuckoo Filter into an approximate
+// key-value cache. _data_ is inserted if it isn't present, else the value stored with its
+// fingerprint is replaced. Like Lookup, the elements whose fingerprints collide share
+// their value. It returns an error if the filter is full.
+func (cuckooFilter *CuckooFilter) Put(data, value []byte) error {
+	cuckooFilter.lock.Lock()
+	defer cuckooFilter.lock.Unlock()
+	cuckooFilter.updated.touch()
+
+	fingerPrint, candidates := cuckooFilter.getPackedCandidates(data)
+	if !cuckooFilter.lookupCandidates(fingerPrint, &candidates) {
+		_, err := cuckooFilter.insert(data, false, 0)
+		if err != nil {
+			return err
+		}
+	}
+	if cuckooFilter.payloads == nil {
+		cuckooFilter.payloads = make(map[cuckooEntry][]byte)
+	}
+	cuckooFilter.payloads[candidates.entry(fingerPrint)] = append([]byte(nil), value...)
+	return nil
+}
+
+// PutString stores _value_ along with _data_ (string)
+func (cuckooFilter *CuckooFilter) PutString(data string, value []byte) error {
+	return cuckooFilter.Put([]byte(data), value)
+}
+
+// Get returns the value stored with _data_ by Put and true if _data_ is present. The value
+// is nil for the elements inserted without one. Like Lookup, it can return the value of an
+// element whose fingerprint collides with _data_.
+func (cuckooFilter *CuckooFilter) Get(data []byte) ([]byte, bool) {
+	cuckooFilter.lock.RLock()
+	defer cuckooFilter.lock.RUnlock()
+
+	fingerPrint, candidates := cuckooFilter.getPackedCandidates(data)
+	if !cuckooFilter.lookupCandidates(fingerPrint, &candidates) {
+		return nil, false
+	}
+	value, ok := cuckooFilter.payloads[candidates.entry(fingerPrint)]
+	if !ok {
+		return nil, true
+	}
+	return append([]byte(nil), value...), true
+}
+
+// GetString returns the value stored with _data_ (string) by Put
+func (cuckooFilter *CuckooFilter) GetString(data string) ([]byte, bool) {
+	return cuckooFilter.Get([]byte(data))
+}
+
+// SetHashing sets the hashing deriving the fingerprints and the bucket indexes of the
+// elements, see CuckooHashing. The hashing is exported along with the filter, but it isn't
+// written by WriteTo, ReadFrom keeps the hashing of the filter.
+// It errors out if the filter isn't empty as its fingerprints were derived otherwise.
+func (cuckooFilter *CuckooFilter) SetHashing(hashing CuckooHashing) error {
+	if hashing == nil {
+		return fmt.Errorf("gostatix: cuckoo hashing can't be nil")
+	}
+	cuckooFilter.lock.Lock()
+	defer cuckooFilter.lock.Unlock()
+
+	if cuckooFilter.length > 0 {
+		return fmt.Errorf("gostatix: hashing can only be set on an empty cuckoo filter, filter has %d entries", cuckooFilter.length)
+	}
+	cuckooFilter.hashing = hashing
+	return nil
+}
+
+// recordInsert counts an insert of _data_ in the history if safe removes are enabled
+func (cuckooFilter *CuckooFilter) recordInsert(data []byte) {
+	if cuckooFilter.history != nil {
+		cuckooFilter.history[getHistoryHash(data)]++
+	}
+}
+
+// EstimatedPositiveRate returns the false positive rate of the Cuckoo Filter estimated from
+// its fingerprint length and its current occupancy. Unlike CuckooPositiveRate, which is
+// the rate of a full filter, it grows as entries are inserted.
+func (cuckooFilter *CuckooFilter) EstimatedPositiveRate() float64 {
+	cuckooFilter.lock.RLock()
+	defer cuckooFilter.lock.RUnlock()
+
+	return cuckooFilter.positiveRate(cuckooFilter.length)
+}
+
+// InsertString writes the _data_ (string) in the Cuckoo Filter for future lookup
+func (cuckooFilter *CuckooFilter) InsertString(data string, destructive bool) bool {
+	return cuckooFilter.Insert([]byte(data), destructive)
+}
+
+// InsertUint64 writes the _data_ (unsigned integer) in the Cuckoo Filter for future lookup.
+// The integer is hashed as its 8 byte big endian encoding.
+func (cuckooFilter *CuckooFilter) InsertUint64(data uint64, destructive bool) bool {
+	return cuckooFilter.Insert(uint64Bytes(data), destructive)
+}
+
+// InsertKey writes the _data_ (Key) in the Cuckoo Filter for future lookup
+func (cuckooFilter *CuckooFilter) InsertKey(data Key, destructive bool) bool {
+	return cuckooFilter.Insert(data.KeyBytes(), destructive)
+}
+
+// InsertFromReader streams keys separated by _delim_ from _stream_ and inserts them
+// in the Cuckoo Filter without holding all of them in memory.
+// _progress_ (optional, can be nil) is called periodically with the number of keys inserted.
+// It returns the number of keys inserted and stops with an error if the filter gets full.
+func (cuckooFilter *CuckooFilter) InsertFromReader(stream io.Reader, delim byte, progress ProgressFunc) (uint64, error) {
+	return util.ReadKeys(stream, delim, progress, func(key []byte) error {
+		_, err := cuckooFilter.InsertWithStats(key, false, 0)
+		return err
+	})
+}
+
+// Lookup returns true if the _data_ is present in the Cuckoo Filter, else false
+func (cuckooFilter *CuckooFilter) Lookup(data []byte) bool {
+	cuckooFilter.lock.RLock()
+	defer cuckooFilter.lock.RUnlock()
+
+	fingerPrint, candidates := cuckooFilter.getPackedCandidates(data)
+	return cuckooFilter.lookupCandidates(fingerPrint, &candidates)
+}
+
+// LookupMulti looks up all the elements of _data_ under a single lock and returns a slice
+// with the result of Lookup for each of them
+func (cuckooFilter *CuckooFilter) LookupMulti(data [][]byte) []bool {
+	cuckooFilter.lock.RLock()
+	defer cuckooFilter.lock.RUnlock()
+
+	results := make([]bool, len(data))
+	for i, element := range data {
+		fingerPrint, candidates := cuckooFilter.getPackedCandidates(element)
+		results[i] = cuckooFilter.lookupCandidates(fingerPrint, &candidates)
+	}
+	return results
+}
+
+// LookupString returns true if the _data_ (string) is present in the Cuckoo Filter, else false
+func (cuckooFilter *CuckooFilter) LookupString(data string) bool {
+	return cuckooFilter.Lookup([]byte(data))
+}
+
+// LookupUint64 returns true if the _data_ (unsigned integer) is present in the Cuckoo Filter, else false
+func (cuckooFilter *CuckooFilter) LookupUint64(data uint64) bool {
+	return cuckooFilter.Lookup(uint64Bytes(data))
+}
+
+// LookupKey returns true if the _data_ (Key) is present in the Cuckoo Filter, else false
+func (cuckooFilter *CuckooFilter) LookupKey(data Key) bool {
+	return cuckooFilter.Lookup(data.KeyBytes())
+}
+
+// Remove deletes the _data_ from the Cuckoo Filter. If safe removes are enabled, it returns
+// false without deleting anything if _data_ was never inserted.
+func (cuckooFilter *CuckooFilter) Remove(data []byte) bool {
+	cuckooFilter.lock.Lock()
+	defer cuckooFilter.lock.Unlock()
+
+	var historyHash uint64
+	if cuckooFilter.history != nil {
+		historyHash = getHistoryHash(data)
+		if cuckooFilter.history[historyHash] == 0 {
 			return false
 		}
-		count++
+	}
+	fingerPrint, candidates := cuckooFilter.getPackedCandidates(data)
+	index, ok := cuckooFilter.findCandidate(fingerPrint, &candidates)
+	if !ok {
+		return false
+	}
+	cuckooFilter.buckets.remove(index, fingerPrint)
+	cuckooFilter.updated.touch()
+	cuckooFilter.length--
+	if cuckooFilter.payloads != nil {
+		if copies, _ := cuckooFilter.copies(fingerPrint, &candidates); copies == 0 {
+			delete(cuckooFilter.payloads, candidates.entry(fingerPrint))
+		}
+	}
+	if cuckooFilter.history != nil {
+		cuckooFilter.history[historyHash]--
+		if cuckooFilter.history[historyHash] == 0 {
+			delete(cuckooFilter.history, historyHash)
+		}
 	}
 	return true
 }
 
+// RemoveString deletes the _data_ (string) from the Cuckoo Filter
+func (cuckooFilter *CuckooFilter) RemoveString(data string) bool {
+	return cuckooFilter.Remove([]byte(data))
+}
+
+// RemoveUint64 deletes the _data_ (unsigned integer) from the Cuckoo Filter
+func (cuckooFilter *CuckooFilter) RemoveUint64(data uint64) bool {
+	return cuckooFilter.Remove(uint64Bytes(data))
+}
+
+// RemoveKey deletes the _data_ (Key) from the Cuckoo Filter
+func (cuckooFilter *CuckooFilter) RemoveKey(data Key) bool {
+	return cuckooFilter.Remove(data.KeyBytes())
+}
+
+// getPackedCandidates returns the fingerprint of _data_ as an integer and its candidate
+// buckets. The fingerprint is 0, which is never stored, if it can't be computed.
+func (cuckooFilter *CuckooFilter) getPackedCandidates(data []byte) (uint64, cuckooCandidates) {
+	fingerPrint, fIndex, sIndex, err := cuckooFilter.getFingerPrintPositions(data)
+	if err != nil {
+		fingerPrint = 0
+	}
+	if cuckooFilter.Candidates() == 2 {
+		return fingerPrint, pairCandidates(fIndex, sIndex)
+	}
+	return fingerPrint, cuckooFilter.getCandidates(fIndex, fingerPrint)
+}
+
+// Equals checks if two CuckooFilter are same or not. Filters with different parameters
+// aren't equal.
+func (aFilter *CuckooFilter) Equals(bFilter *CuckooFilter) (bool, error) {
+	comparison, err := aFilter.Compare(bFilter)
+	return comparison.Equal(), err
+}
+
+// Compare compares two CuckooFilter and returns why they aren't equal, if they aren't
+func (aFilter *CuckooFilter) Compare(bFilter *CuckooFilter) (Comparison, error) {
+	comparison := aFilter.compareParams(bFilter.AbstractCuckooFilter)
+	if !comparison.Equal() || aFilter == bFilter {
+		return comparison, nil
+	}
+	aFilter.lock.RLock()
+	defer aFilter.lock.RUnlock()
+	bFilter.lock.RLock()
+	defer bFilter.lock.RUnlock()
+
+	return compareResult(aFilter.buckets.equals(bFilter.buckets), "buckets"), nil
+}
+
 // bucketMemJSON is internal struct used to json marshal/unmarshal buckets
 type bucketMemJSON struct {
 	Size     uint64   `json:"s"`
@@ -164,6 +554,10 @@ type bucketMemJSON struct {
 	Elements []string `json:"e"`
 }
 
+// cuckooAltIndexMagic follows the buckets written by WriteTo when the alternate index of the
+// filter is the difference one
+var cuckooAltIndexMagic = []byte("GSTA")
+
 // cuckooFilterMemJSON is internal struct used to json marshal/unmarshal cuckoo filter
 type cuckooFilterMemJSON struct {
 	Size              uint64          `json:"s"`
@@ -172,13 +566,131 @@ type cuckooFilterMemJSON struct {
 	Length            uint64          `json:"l"`
 	Retries           uint64          `json:"r"`
 	Buckets           []bucketMemJSON `json:"b"`
+	Hashing           string          `json:"h,omitempty"`
+	Multiset          bool            `json:"m,omitempty"`
+	Payloads          []cuckooPayload `json:"p,omitempty"`
+	Candidates        uint64          `json:"c,omitempty"`
+	DiffAltIndex      bool            `json:"d,omitempty"`
+}
+
+// cuckooPayload is internal struct used to json marshal/unmarshal the value stored with
+// the entries of fingerprint _FingerPrint_ and buckets _Indexes_
+type cuckooPayload struct {
+	FingerPrint uint64    `json:"f"`
+	Indexes     [2]uint64 `json:"i"`
+	Value       []byte    `json:"v"`
+}
+
+// exportPayloads returns the payloads of _payloads_ sorted by entry, so that the snapshots
+// of equal filters are equal
+func exportPayloads(payloads map[cuckooEntry][]byte) []cuckooPayload {
+	exported := make([]cuckooPayload, 0, len(payloads))
+	for entry, value := range payloads {
+		exported = append(exported, cuckooPayload{entry.fingerPrint, [2]uint64{entry.lowIndex, entry.highIndex}, value})
+	}
+	sort.Slice(exported, func(i, j int) bool {
+		a, b := exported[i], exported[j]
+		if a.Indexes != b.Indexes {
+			if a.Indexes[0] != b.Indexes[0] {
+				return a.Indexes[0] < b.Indexes[0]
+			}
+			return a.Indexes[1] < b.Indexes[1]
+		}
+		return a.FingerPrint < b.FingerPrint
+	})
+	return exported
+}
+
+// importPayloads returns the payloads of the filter of _size_ buckets from _exported_
+func importPayloads(exported []cuckooPayload, size uint64) (map[cuckooEntry][]byte, error) {
+	if len(exported) == 0 {
+		return nil, nil
+	}
+	payloads := make(map[cuckooEntry][]byte, len(exported))
+	for _, payload := range exported {
+		if payload.Indexes[0] >= size || payload.Indexes[1] >= size {
+			return nil, fmt.Errorf("gostatix: invalid cuckoo filter snapshot, payload bucket indexes %v should be lower than %d", payload.Indexes, size)
+		}
+		payloads[newCuckooEntry(payload.FingerPrint, payload.Indexes[0], payload.Indexes[1])] = payload.Value
+	}
+	return payloads, nil
+}
+
+// validate checks the parameters and buckets of the decoded snapshot
+func (f *cuckooFilterMemJSON) validate() error {
+	err := checkCuckooFilterParams(f.Size, f.BucketSize, f.FingerPrintLength)
+	if err != nil {
+		return err
+	}
+	if uint64(len(f.Buckets)) != f.Size {
+		return fmt.Errorf("gostatix: invalid cuckoo filter snapshot, %d buckets found instead of %d", len(f.Buckets), f.Size)
+	}
+	for i := range f.Buckets {
+		if uint64(len(f.Buckets[i].Elements)) > f.BucketSize {
+			return fmt.Errorf("gostatix: invalid cuckoo filter snapshot, bucket %d has %d elements, more than the bucket size %d", i, len(f.Buckets[i].Elements), f.BucketSize)
+		}
+	}
+	if f.Candidates != 0 {
+		err = checkCuckooCandidates(f.Size, f.Candidates)
+		if err != nil {
+			return fmt.Errorf("gostatix: invalid cuckoo filter snapshot, error: %v", err)
+		}
+	}
+	return nil
+}
+
+// Reset removes all the entries of the Cuckoo Filter, keeping its parameters and
+// the memory of its buckets
+func (cuckooFilter *CuckooFilter) Reset() {
+	cuckooFilter.lock.Lock()
+	defer cuckooFilter.lock.Unlock()
+	cuckooFilter.updated.touch()
+
+	for i := range cuckooFilter.buckets.words {
+		cuckooFilter.buckets.words[i] = 0
+	}
+	cuckooFilter.length = 0
+	cuckooFilter.payloads = nil
+	if cuckooFilter.history != nil {
+		cuckooFilter.history = make(map[uint64]uint64)
+	}
+}
+
+// MemoryUsage returns the estimated number of bytes used in-process by the Cuckoo Filter
+func (cuckooFilter *CuckooFilter) MemoryUsage() uint64 {
+	cuckooFilter.lock.RLock()
+	defer cuckooFilter.lock.RUnlock()
+
+	return uint64(unsafe.Sizeof(*cuckooFilter)+unsafe.Sizeof(*cuckooFilter.buckets)+unsafe.Sizeof(*cuckooFilter.AbstractCuckooFilter)) +
+		uint64(cap(cuckooFilter.buckets.words)*wordBytes) +
+		uint64(cap(cuckooFilter.kicks))*uint64(unsafe.Sizeof(packedEntry{})) +
+		uint64(len(cuckooFilter.history)*2*wordBytes) +
+		cuckooFilter.payloadsMemoryUsage()
+}
+
+// payloadsMemoryUsage returns the estimated number of bytes used by the payloads
+func (cuckooFilter *CuckooFilter) payloadsMemoryUsage() uint64 {
+	usage := uint64(0)
+	for _, value := range cuckooFilter.payloads {
+		usage += uint64(unsafe.Sizeof(cuckooEntry{})+unsafe.Sizeof(value)) + uint64(cap(value))
+	}
+	return usage
+}
+
+// Close releases the resources attached to the CuckooFilter.
+// It's safe to call Close multiple times.
+func (cuckooFilter *CuckooFilter) Close() error {
+	return cuckooFilter.resources.close()
 }
 
 // Export JSON marshals the CuckooFilter and returns a byte slice containing the data
 func (cuckooFilter *CuckooFilter) Export() ([]byte, error) {
+	cuckooFilter.lock.RLock()
+	defer cuckooFilter.lock.RUnlock()
+
 	bucketsJSON := make([]bucketMemJSON, cuckooFilter.size)
-	for i := range cuckooFilter.buckets {
-		bucket := cuckooFilter.buckets[i]
+	for i := range bucketsJSON {
+		bucket := cuckooFilter.buckets.toBucketMem(uint64(i))
 		bucketJSON := bucketMemJSON{bucket.Size(), bucket.getLength(), bucket.getElements()}
 		bucketsJSON[i] = bucketJSON
 	}
@@ -189,38 +701,85 @@ func (cuckooFilter *CuckooFilter) Export() ([]byte, error) {
 		cuckooFilter.length,
 		cuckooFilter.retries,
 		bucketsJSON,
+		cuckooFilter.hashingName(),
+		cuckooFilter.multiset,
+		exportPayloads(cuckooFilter.payloads),
+		cuckooFilter.candidates,
+		cuckooFilter.differenceAltIndex(),
 	})
 }
 
-// Import JSON unmarshals the _data_ into the CuckooFilter
+// Import JSON unmarshals the _data_ into the CuckooFilter. The snapshots saved by the former
+// versions keep their alternate index, see getAltIndex.
 func (cuckooFilter *CuckooFilter) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
 	var f cuckooFilterMemJSON
-	err := json.Unmarshal(data, &f)
+	err = json.Unmarshal(data, &f)
 	if err != nil {
 		return err
 	}
-	cuckooFilter.size = f.Size
-	cuckooFilter.bucketSize = f.BucketSize
-	cuckooFilter.fingerPrintLength = f.FingerPrintLength
-	cuckooFilter.length = f.Length
-	cuckooFilter.retries = f.Retries
-	filters := make([]BucketMem, f.Size)
+	err = f.validate()
+	if err != nil {
+		return err
+	}
+	hashing, err := lookupCuckooHashing(f.Hashing)
+	if err != nil {
+		return err
+	}
+	payloads, err := importPayloads(f.Payloads, f.Size)
+	if err != nil {
+		return err
+	}
+	buckets := newPackedBuckets(f.Size, f.BucketSize, f.FingerPrintLength)
 	for i := range f.Buckets {
 		bucketJSON := f.Buckets[i]
-		bucket := *newBucketMem(f.BucketSize)
 		for j := range bucketJSON.Elements {
-			bucket.add(bucketJSON.Elements[j])
+			if bucketJSON.Elements[j] == "" {
+				continue
+			}
+			fingerPrint, err := buckets.pack(bucketJSON.Elements[j])
+			if err != nil {
+				return err
+			}
+			buckets.add(uint64(i), fingerPrint)
 		}
-		filters[i] = bucket
 	}
-	cuckooFilter.buckets = filters
+	cuckooFilter.lock.Lock()
+	defer cuckooFilter.lock.Unlock()
+	cuckooFilter.updated.touch()
+
+	cuckooFilter.size = f.Size
+	cuckooFilter.bucketSize = f.BucketSize
+	cuckooFilter.fingerPrintLength = f.FingerPrintLength
+	cuckooFilter.length = f.Length
+	cuckooFilter.retries = f.Retries
+	cuckooFilter.hashing = hashing
+	cuckooFilter.multiset = f.Multiset
+	cuckooFilter.candidates = f.Candidates
+	cuckooFilter.legacyAltIndex = !f.DiffAltIndex
+	cuckooFilter.buckets = buckets
+	cuckooFilter.history = nil
+	cuckooFilter.payloads = payloads
 	return nil
 }
 
 // WriteTo writes the CuckooFilter onto the specified _stream_ and returns the
 // number of bytes written.
 // It can be used to write to disk (using a file stream) or to network.
+// The buckets are written in the same format as BucketMem. The payloads stored by Put
+// aren't written, they're exported by Export, and neither is the number of candidate
+// buckets, ReadFrom keeps the one of the filter. The buckets are followed by the magic
+// "GSTA" if the alternate index of the filter is the difference one (see getAltIndex), and
+// the stream ends with the checksum of the filter, verified by ReadFrom.
 func (cuckooFilter *CuckooFilter) WriteTo(stream io.Writer) (int64, error) {
+	cuckooFilter.lock.RLock()
+	defer cuckooFilter.lock.RUnlock()
+
+	checksum := newChecksumWriter(stream)
+	stream = checksum
 	err := binary.Write(stream, binary.BigEndian, cuckooFilter.size)
 	if err != nil {
 		return 0, err
@@ -243,21 +802,41 @@ func (cuckooFilter *CuckooFilter) WriteTo(stream io.Writer) (int64, error) {
 	}
 	numBytes := int64(0)
 	for i := uint64(0); i < cuckooFilter.size; i++ {
-		bytes, err := cuckooFilter.buckets[i].writeTo(stream)
+		bytes, err := cuckooFilter.buckets.toBucketMem(i).writeTo(stream)
 		if err != nil {
 			return 0, err
 		}
 		numBytes += bytes
 	}
-	return numBytes + int64(5*binary.Size(uint64(0))), nil
+	if cuckooFilter.differenceAltIndex() {
+		bytes, err := stream.Write(cuckooAltIndexMagic)
+		if err != nil {
+			return 0, err
+		}
+		numBytes += int64(bytes)
+	}
+	err = checksum.writeTrailer()
+	if err != nil {
+		return 0, err
+	}
+	return numBytes + int64(5*binary.Size(uint64(0))+checksumTrailerSize), nil
 }
 
 // ReadFrom reads the CuckooFilter from the specified _stream_ and returns the
 // number of bytes read.
 // It can be used to read from disk (using a file stream) or from network.
+// A stream cut short or which doesn't match its checksum errors out with an
+// ErrCorruptSnapshot and leaves the filter unchanged.
 func (cuckooFilter *CuckooFilter) ReadFrom(stream io.Reader) (int64, error) {
+	decompressed, err := decompressStream(stream)
+	if err != nil {
+		return 0, err
+	}
+	defer decompressed.Close()
+	checksum := newChecksumReader(decompressed)
+	stream = checksum
 	var size, bucketSize, fingerPrintLength, length, retries uint64
-	err := binary.Read(stream, binary.BigEndian, &size)
+	err = binary.Read(stream, binary.BigEndian, &size)
 	if err != nil {
 		return 0, err
 	}
@@ -277,21 +856,58 @@ func (cuckooFilter *CuckooFilter) ReadFrom(stream io.Reader) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	cuckooFilter.size = size
-	cuckooFilter.bucketSize = bucketSize
-	cuckooFilter.fingerPrintLength = fingerPrintLength
-	cuckooFilter.length = length
-	cuckooFilter.retries = retries
-	cuckooFilter.buckets = make([]BucketMem, size)
+	err = checkCuckooFilterParams(size, bucketSize, fingerPrintLength)
+	if err != nil {
+		return 0, err
+	}
+	err = checkCuckooCandidates(size, cuckooFilter.Candidates())
+	if err != nil {
+		return 0, err
+	}
+	// the buckets are read one by one and packed so that the fingerprints of the
+	// whole filter aren't held as strings
+	buckets := &packedBuckets{0, bucketSize, fingerPrintWidth(fingerPrintLength), nil}
 	numBytes := int64(0)
-	for i := uint64(0); i < cuckooFilter.size; i++ {
+	for i := uint64(0); i < size; i++ {
 		bucket := newBucketMem(0)
 		bytes, err := bucket.readFrom(stream)
 		if err != nil {
 			return 0, err
 		}
+		if bucket.size != bucketSize {
+			return 0, fmt.Errorf("gostatix: invalid cuckoo filter snapshot, bucket %d has size %d instead of %d", i, bucket.size, bucketSize)
+		}
 		numBytes += bytes
-		cuckooFilter.buckets[i] = *bucket
+		buckets.grow()
+		err = buckets.fromBucketMem(i, bucket)
+		if err != nil {
+			return 0, err
+		}
+	}
+	// the streams written by the former versions have no alternate index magic
+	differenceAltIndex, err := checksum.readMagic(cuckooAltIndexMagic)
+	if err != nil {
+		return 0, err
+	}
+	if differenceAltIndex {
+		numBytes += int64(len(cuckooAltIndexMagic))
+	}
+	trailer, err := checksum.verify()
+	if err != nil {
+		return 0, err
 	}
-	return numBytes + int64(5*binary.Size(uint64(0))), nil
+	cuckooFilter.lock.Lock()
+	defer cuckooFilter.lock.Unlock()
+	cuckooFilter.updated.touch()
+
+	cuckooFilter.size = size
+	cuckooFilter.bucketSize = bucketSize
+	cuckooFilter.fingerPrintLength = fingerPrintLength
+	cuckooFilter.length = length
+	cuckooFilter.retries = retries
+	cuckooFilter.legacyAltIndex = !differenceAltIndex
+	cuckooFilter.buckets = buckets
+	cuckooFilter.history = nil
+	cuckooFilter.payloads = nil
+	return numBytes + int64(5*binary.Size(uint64(0))) + trailer, nil
 }
@@ -0,0 +1,429 @@
+package gostatix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/kwertop/gostatix/internal/util"
+)
+
+// CuckooFilterKV is the implementation of BaseCuckooFilter backed by a KVStore
+// _store_ is the KVStore holding the filter
+// _name_ prefixes the keys of the filter in the store: the parameters are at _name_,
+// the length at _name_:length, the name of its hashing at _name_:hashing if it isn't the
+// default one, "difference" at _name_:altindex if its alternate index is the difference one
+// (see getAltIndex) and the bucket at index i at _name_:i
+// Every bucket is stored as _bucketSize_ big endian uint64 fingerprints, 0 being an
+// empty cell, and every operation runs in a single transaction of the store.
+type CuckooFilterKV struct {
+	store KVStore
+	name  string
+	*AbstractCuckooFilter
+}
+
+// NewCuckooFilterKV creates a CuckooFilterKV named _name_ in _store_, or opens it if it
+// already exists, in which case it should have been created with the same parameters
+// _size_ is the number of buckets
+// _bucketSize_ is the size of the individual buckets
+// _fingerPrintLength_ is fingerprint hash of the input to be inserted/removed/lookup
+func NewCuckooFilterKV(store KVStore, name string, size, bucketSize, fingerPrintLength uint64) (*CuckooFilterKV, error) {
+	return NewCuckooFilterKVWithRetries(store, name, size, bucketSize, fingerPrintLength, 500)
+}
+
+// NewCuckooFilterKVWithRetries creates or opens a CuckooFilterKV like NewCuckooFilterKV
+// with the specified _retries_
+func NewCuckooFilterKVWithRetries(store KVStore, name string, size, bucketSize, fingerPrintLength, retries uint64) (*CuckooFilterKV, error) {
+	if store == nil || name == "" {
+		return nil, fmt.Errorf("gostatix: a store and a name are required for a kv backed cuckoo filter")
+	}
+	baseFilter, err := makeAbstractCuckooFilter(size, bucketSize, fingerPrintLength, retries)
+	if err != nil {
+		return nil, err
+	}
+	filter := &CuckooFilterKV{store: store, name: name, AbstractCuckooFilter: baseFilter}
+	if filter.differenceAltIndex() {
+		// the filters created by the former versions have no alternate index key
+		err = store.Update(func(tx KVTx) error {
+			params, err := tx.Get([]byte(name))
+			if err != nil || params != nil {
+				return err
+			}
+			return tx.Set(filter.altIndexKey(), []byte("difference"))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = kvInitParams(store, []byte(name), "cuckoo filter", []uint64{size, bucketSize, fingerPrintLength})
+	if err != nil {
+		return nil, err
+	}
+	var hashingName, altIndex []byte
+	err = store.View(func(tx KVTx) error {
+		hashingName, err = tx.Get(filter.hashingKey())
+		if err != nil {
+			return err
+		}
+		altIndex, err = tx.Get(filter.altIndexKey())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	filter.legacyAltIndex = string(altIndex) != "difference"
+	filter.hashing, err = lookupCuckooHashing(string(hashingName))
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// NewCuckooFilterKVWithErrorRate creates or opens a CuckooFilterKV with a specified false
+// positive rate : _errorRate_, like NewCuckooFilterWithErrorRate
+func NewCuckooFilterKVWithErrorRate(store KVStore, name string, size, bucketSize, retries uint64, errorRate float64) (*CuckooFilterKV, error) {
+	err := checkCuckooFilterEstimates(size, bucketSize, errorRate)
+	if err != nil {
+		return nil, err
+	}
+	fingerPrintLength := util.CalculateFingerPrintLength(size, errorRate)
+	capacity := uint64(math.Ceil(float64(size) * 0.955 / float64(bucketSize)))
+	return NewCuckooFilterKVWithRetries(store, name, capacity, bucketSize, fingerPrintLength, retries)
+}
+
+// Name returns the name of the filter in its store
+func (cuckooFilter *CuckooFilterKV) Name() string {
+	return cuckooFilter.name
+}
+
+// Length returns the current number of entries present in the Cuckoo Filter
+func (cuckooFilter *CuckooFilterKV) Length() (uint64, error) {
+	var length uint64
+	err := cuckooFilter.store.View(func(tx KVTx) error {
+		var err error
+		length, err = kvGetUint64(tx, cuckooFilter.lengthKey())
+		return err
+	})
+	return length, err
+}
+
+// Insert writes the _data_ in the Cuckoo Filter for future lookup. It returns an error if
+// the filter is full or the store fails.
+// _destructive_ parameter is used to specify if the previous ordering of the
+// present entries is to be preserved after the retries (if that case arises)
+func (cuckooFilter *CuckooFilterKV) Insert(data []byte, destructive bool) error {
+	_, err := cuckooFilter.InsertWithStats(data, destructive, 0)
+	return err
+}
+
+// InsertWithStats writes the _data_ in the Cuckoo Filter like Insert and returns the
+// statistics of the insert
+// _maxKicks_ overrides the number of retries of the filter for this insert, 0 keeps them
+func (cuckooFilter *CuckooFilterKV) InsertWithStats(data []byte, destructive bool, maxKicks uint64) (CuckooInsertStats, error) {
+	var stats CuckooInsertStats
+	full := false
+	err := cuckooFilter.store.Update(func(tx KVTx) error {
+		var err error
+		stats, full, err = cuckooFilter.insert(tx, data, destructive, maxKicks)
+		return err
+	})
+	if err == nil && full {
+		err = fmt.Errorf("%w after %d kicks", ErrCuckooFilterFull, stats.Kicks)
+	}
+	return stats, err
+}
+
+// AddIfNotExists inserts _data_ in the Cuckoo Filter and returns true if it wasn't already
+// present. The lookup and the insert run in the same transaction of the store.
+func (cuckooFilter *CuckooFilterKV) AddIfNotExists(data []byte) (bool, error) {
+	added, full := false, false
+	err := cuckooFilter.store.Update(func(tx KVTx) error {
+		buckets := cuckooFilter.newKVBuckets(tx)
+		fingerPrint, fIndex, sIndex, err := cuckooFilter.getFingerPrintPositions(data)
+		if err != nil {
+			return err
+		}
+		found, err := buckets.lookup(fIndex, sIndex, fingerPrint)
+		if err != nil || found {
+			return err
+		}
+		_, full, err = cuckooFilter.insert(tx, data, false, 0)
+		added = err == nil && !full
+		return err
+	})
+	if err == nil && full {
+		err = ErrCuckooFilterFull
+	}
+	return added, err
+}
+
+// insert runs the insert of _data_ in the transaction _tx_ and returns true if the filter
+// is full. The modified buckets are written at the end, so that nothing is written if the
+// insert fails and isn't _destructive_. A full filter isn't an error of the transaction,
+// which must be committed to keep the writes of a _destructive_ insert.
+func (cuckooFilter *CuckooFilterKV) insert(tx KVTx, data []byte, destructive bool, maxKicks uint64) (CuckooInsertStats, bool, error) {
+	var stats CuckooInsertStats
+	fingerPrint, fIndex, sIndex, err := cuckooFilter.getFingerPrintPositions(data)
+	if err != nil {
+		return stats, false, err
+	}
+	buckets := cuckooFilter.newKVBuckets(tx)
+	added, err := buckets.add(fIndex, fingerPrint)
+	if err == nil && !added {
+		added, err = buckets.add(sIndex, fingerPrint)
+	}
+	if err != nil {
+		return stats, false, err
+	}
+	if !added {
+		var index uint64
+		if cuckooFilter.randInt63n(2) == 0 {
+			index = fIndex
+		} else {
+			index = sIndex
+		}
+		currFingerPrint := fingerPrint
+		retries := cuckooFilter.maxKicks(maxKicks)
+		for i := uint64(0); i < retries && !added; i++ {
+			bucket, err := buckets.get(index)
+			if err != nil {
+				return stats, false, err
+			}
+			randIndex, err := cuckooFilter.kickPosition(uint64(len(bucket)))
+			if err != nil {
+				return stats, false, err
+			}
+			prevFingerPrint := bucket[randIndex]
+			bucket[randIndex] = currFingerPrint
+			stats.Kicks++
+			added, err = buckets.add(cuckooFilter.getAltIndex(index, prevFingerPrint), prevFingerPrint)
+			if err != nil {
+				return stats, false, err
+			}
+		}
+		if !added {
+			if destructive {
+				err = buckets.save()
+				if err != nil {
+					return stats, false, err
+				}
+			} else {
+				stats.RolledBack = true
+			}
+			return stats, true, nil
+		}
+	}
+	err = buckets.save()
+	if err != nil {
+		return stats, false, err
+	}
+	length, err := kvGetUint64(tx, cuckooFilter.lengthKey())
+	if err != nil {
+		return stats, false, err
+	}
+	return stats, false, kvSetUint64(tx, cuckooFilter.lengthKey(), length+1)
+}
+
+// InsertString writes the _data_ (string) in the Cuckoo Filter for future lookup
+func (cuckooFilter *CuckooFilterKV) InsertString(data string, destructive bool) error {
+	return cuckooFilter.Insert([]byte(data), destructive)
+}
+
+// Lookup returns true if the _data_ is present in the Cuckoo Filter, else false
+func (cuckooFilter *CuckooFilterKV) Lookup(data []byte) (bool, error) {
+	fingerPrint, fIndex, sIndex, err := cuckooFilter.getFingerPrintPositions(data)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	err = cuckooFilter.store.View(func(tx KVTx) error {
+		found, err = cuckooFilter.newKVBuckets(tx).lookup(fIndex, sIndex, fingerPrint)
+		return err
+	})
+	return found, err
+}
+
+// LookupString returns true if the _data_ (string) is present in the Cuckoo Filter, else false
+func (cuckooFilter *CuckooFilterKV) LookupString(data string) (bool, error) {
+	return cuckooFilter.Lookup([]byte(data))
+}
+
+// Remove deletes the _data_ from the Cuckoo Filter and returns true if it was present
+func (cuckooFilter *CuckooFilterKV) Remove(data []byte) (bool, error) {
+	fingerPrint, fIndex, sIndex, err := cuckooFilter.getFingerPrintPositions(data)
+	if err != nil {
+		return false, err
+	}
+	removed := false
+	err = cuckooFilter.store.Update(func(tx KVTx) error {
+		buckets := cuckooFilter.newKVBuckets(tx)
+		for _, index := range []uint64{fIndex, sIndex} {
+			bucket, err := buckets.get(index)
+			if err != nil {
+				return err
+			}
+			for i, cell := range bucket {
+				if cell == fingerPrint {
+					bucket[i] = 0
+					removed = true
+					break
+				}
+			}
+			if removed {
+				break
+			}
+		}
+		if !removed {
+			return nil
+		}
+		err := buckets.save()
+		if err != nil {
+			return err
+		}
+		length, err := kvGetUint64(tx, cuckooFilter.lengthKey())
+		if err != nil {
+			return err
+		}
+		return kvSetUint64(tx, cuckooFilter.lengthKey(), length-1)
+	})
+	return removed, err
+}
+
+// RemoveString deletes the _data_ (string) from the Cuckoo Filter
+func (cuckooFilter *CuckooFilterKV) RemoveString(data string) (bool, error) {
+	return cuckooFilter.Remove([]byte(data))
+}
+
+// Reset deletes all the entries of the Cuckoo Filter, keeping its parameters
+func (cuckooFilter *CuckooFilterKV) Reset() error {
+	return cuckooFilter.store.Update(func(tx KVTx) error {
+		for i := uint64(0); i < cuckooFilter.size; i++ {
+			err := tx.Delete(cuckooFilter.bucketKey(i))
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Delete(cuckooFilter.lengthKey())
+	})
+}
+
+// SetHashing sets the hashing deriving the fingerprints and the bucket indexes of the
+// elements, see CuckooHashing. The hashing is saved in the store by name, so it's kept when
+// the filter is opened again. It errors out if the filter isn't empty as its fingerprints
+// were derived otherwise.
+func (cuckooFilter *CuckooFilterKV) SetHashing(hashing CuckooHashing) error {
+	if hashing == nil {
+		return fmt.Errorf("gostatix: cuckoo hashing can't be nil")
+	}
+	err := cuckooFilter.store.Update(func(tx KVTx) error {
+		length, err := kvGetUint64(tx, cuckooFilter.lengthKey())
+		if err != nil {
+			return err
+		}
+		if length > 0 {
+			return fmt.Errorf("gostatix: hashing can only be set on an empty cuckoo filter, filter has %d entries", length)
+		}
+		return tx.Set(cuckooFilter.hashingKey(), []byte(hashing.Name()))
+	})
+	if err != nil {
+		return err
+	}
+	cuckooFilter.hashing = hashing
+	return nil
+}
+
+func (cuckooFilter *CuckooFilterKV) hashingKey() []byte {
+	return []byte(cuckooFilter.name + ":hashing")
+}
+
+func (cuckooFilter *CuckooFilterKV) altIndexKey() []byte {
+	return []byte(cuckooFilter.name + ":altindex")
+}
+
+func (cuckooFilter *CuckooFilterKV) lengthKey() []byte {
+	return []byte(cuckooFilter.name + ":length")
+}
+
+func (cuckooFilter *CuckooFilterKV) bucketKey(index uint64) []byte {
+	return []byte(cuckooFilter.name + ":" + strconv.FormatUint(index, 10))
+}
+
+// kvBuckets caches the buckets of a CuckooFilterKV read in the transaction _tx_ and
+// writes back the modified ones on save
+type kvBuckets struct {
+	filter  *CuckooFilterKV
+	tx      KVTx
+	buckets map[uint64][]uint64
+}
+
+func (cuckooFilter *CuckooFilterKV) newKVBuckets(tx KVTx) *kvBuckets {
+	return &kvBuckets{cuckooFilter, tx, make(map[uint64][]uint64)}
+}
+
+// get returns the fingerprints of the bucket at _index_, which can be modified in place
+func (b *kvBuckets) get(index uint64) ([]uint64, error) {
+	if bucket, ok := b.buckets[index]; ok {
+		return bucket, nil
+	}
+	bucket := make([]uint64, b.filter.bucketSize)
+	value, err := b.tx.Get(b.filter.bucketKey(index))
+	if err != nil {
+		return nil, err
+	}
+	if value != nil && len(value) != len(bucket)*8 {
+		return nil, fmt.Errorf("gostatix: bucket %d of cuckoo filter %s is corrupted", index, b.filter.name)
+	}
+	for i := 0; i < len(value)/8; i++ {
+		bucket[i] = binary.BigEndian.Uint64(value[8*i:])
+	}
+	b.buckets[index] = bucket
+	return bucket, nil
+}
+
+// add stores _fingerPrint_ in a free cell of the bucket at _index_, it returns false if
+// the bucket is full
+func (b *kvBuckets) add(index, fingerPrint uint64) (bool, error) {
+	bucket, err := b.get(index)
+	if err != nil {
+		return false, err
+	}
+	for i, cell := range bucket {
+		if cell == 0 {
+			bucket[i] = fingerPrint
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// lookup returns true if _fingerPrint_ is in the bucket at _fIndex_ or _sIndex_
+func (b *kvBuckets) lookup(fIndex, sIndex, fingerPrint uint64) (bool, error) {
+	for _, index := range []uint64{fIndex, sIndex} {
+		bucket, err := b.get(index)
+		if err != nil {
+			return false, err
+		}
+		for _, cell := range bucket {
+			if cell == fingerPrint {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// save writes the cached buckets in the transaction
+func (b *kvBuckets) save() error {
+	for index, bucket := range b.buckets {
+		value := make([]byte, 0, len(bucket)*8)
+		for _, cell := range bucket {
+			value = binary.BigEndian.AppendUint64(value, cell)
+		}
+		err := b.tx.Set(b.filter.bucketKey(index), value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
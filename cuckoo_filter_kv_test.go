@@ -0,0 +1,93 @@
+package gostatix
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCuckooFilterKVBasic(t *testing.T) {
+	store := NewMemKVStore()
+	filter, err := NewCuckooFilterKV(store, "cuckoo", 100, 4, 3)
+	if err != nil {
+		t.Fatalf("creating the filter shouldn't error out, error: %v", err)
+	}
+	for _, name := range []string{"john", "jane", "alice"} {
+		err = filter.InsertString(name, false)
+		if err != nil {
+			t.Fatalf("inserting %s shouldn't error out, error: %v", name, err)
+		}
+	}
+	added, err := filter.AddIfNotExists([]byte("john"))
+	if err != nil || added {
+		t.Errorf("john shouldn't be added twice, added %v, error: %v", added, err)
+	}
+
+	filter, err = NewCuckooFilterKV(store, "cuckoo", 100, 4, 3)
+	if err != nil {
+		t.Fatalf("reopening the filter shouldn't error out, error: %v", err)
+	}
+	if length, _ := filter.Length(); length != 3 {
+		t.Errorf("length of the reopened filter should be 3, got %d", length)
+	}
+	if ok, _ := filter.LookupString("jane"); !ok {
+		t.Errorf("jane should be in the reopened filter")
+	}
+	if ok, _ := filter.LookupString("bob"); ok {
+		t.Errorf("bob shouldn't be in the filter")
+	}
+	if ok, _ := filter.RemoveString("jane"); !ok {
+		t.Errorf("jane should be removed")
+	}
+	if ok, _ := filter.LookupString("jane"); ok {
+		t.Errorf("jane shouldn't be in the filter after removing it")
+	}
+	_, err = NewCuckooFilterKV(store, "cuckoo", 200, 4, 3)
+	if err == nil {
+		t.Errorf("opening the filter with different parameters should error out")
+	}
+	err = filter.Reset()
+	if err != nil {
+		t.Fatalf("resetting the filter shouldn't error out, error: %v", err)
+	}
+	if length, _ := filter.Length(); length != 0 {
+		t.Errorf("length of the reset filter should be 0, got %d", length)
+	}
+}
+
+func TestCuckooFilterKVFull(t *testing.T) {
+	filter, _ := NewCuckooFilterKVWithRetries(NewMemKVStore(), "cuckoo", 2, 1, 3, 4)
+	var err error
+	inserted := uint64(0)
+	for i := 0; i < 10 && err == nil; i++ {
+		err = filter.InsertString(strconv.Itoa(i), false)
+		if err == nil {
+			inserted++
+		}
+	}
+	if err == nil {
+		t.Fatalf("inserting in a full filter should error out")
+	}
+	if length, _ := filter.Length(); length != inserted {
+		t.Errorf("length should be %d after a rolled back insert, got %d", inserted, length)
+	}
+}
+
+func TestCuckooFilterKVLegacyAltIndex(t *testing.T) {
+	store := NewMemKVStore()
+	filter, _ := NewCuckooFilterKV(store, "cuckoo", 1000, 4, 6)
+	if filter.legacyAltIndex {
+		t.Errorf("new filter shouldn't use the legacy alternate index")
+	}
+	reopened, err := NewCuckooFilterKV(store, "cuckoo", 1000, 4, 6)
+	if err != nil || reopened.legacyAltIndex {
+		t.Errorf("reopened filter should keep the difference alternate index, error: %v", err)
+	}
+	// the filters created by the former versions have no alternate index key
+	store.Update(func(tx KVTx) error {
+		return tx.Delete(filter.altIndexKey())
+	})
+	reopened, err = NewCuckooFilterKV(store, "cuckoo", 1000, 4, 6)
+	if err != nil || !reopened.legacyAltIndex {
+		t.Errorf("filter without alternate index key should be legacy, error: %v", err)
+	}
+}
@@ -7,33 +7,64 @@ package gostatix
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math"
-	"math/rand"
+	"math/bits"
 	"strconv"
+	"time"
 
 	"github.com/kwertop/gostatix/internal/util"
 	"github.com/redis/go-redis/v9"
 )
 
+// cuckooResetScript empties all the buckets listed at KEYS[1], sets the length saved
+// in the metadata hash at KEYS[2] to 0 and deletes the insert history at KEYS[3] and the
+// payloads at KEYS[4] and the insertion times at KEYS[5]
+var cuckooResetScript = redis.NewScript(`
+	local bucketKeys = redis.call('LRANGE', KEYS[1], 0, -1)
+	for i=1, #bucketKeys do
+		redis.call('DEL', bucketKeys[i])
+		redis.call('SET', bucketKeys[i] .. '_len', 0)
+	end
+	redis.call('HSET', KEYS[2], 'length', 0)
+	redis.call('DEL', KEYS[3])
+	redis.call('DEL', KEYS[4])
+	redis.call('DEL', KEYS[5])
+	return true
+`)
+
 // CuckooFilterRedis is the Redis backed implementation of BaseCuckooFilter
 // _buckets_ is a slice of BucketRedis
 // _key_ holds the Redis key to the list which has the Redis keys of all buckets
 // _metadataKey_ is used to store the additional information about CuckooFilterRedis
 // for retrieving the filter by the Redis key
+// _safeRemove_ is true if the inserts are counted in the Redis hash at historyKey()
+// _expiry_ is true if the insertion times of the entries are kept in the sorted set at expiryKey()
+// _bucketKeys_ caches the Redis keys of the buckets by index, and _bucketKeyPrefix_ their
+// common prefix passed to the insert script, built when the buckets are initialized so that
+// the operations don't build them again
+// _updated_ records the time of the last update made through the filter
 type CuckooFilterRedis struct {
 	buckets     map[string]*BucketRedis
 	key         string
 	metadataKey string
 	*AbstractCuckooFilter
+	resources       resources
+	safeRemove      bool
+	expiry          bool
+	bucketKeys      []string
+	bucketKeyPrefix string
+	updated         updateClock
 }
 
 // NewCuckooFilter creates a new CuckooFilterRedis
 // _size_ is the size of the BucketRedis slice
 // _bucketSize_ is the size of the individual buckets inside the bucket slice
 // _fingerPrintLength_ is fingerprint hash of the input to be inserted/removed/lookup
-func NewCuckooFilterRedis(size, bucketSize, fingerPrintLength uint64) (*CuckooFilterRedis, error) {
-	return NewCuckooFilterRedisWithRetries(size, bucketSize, fingerPrintLength, 500)
+func NewCuckooFilterRedis(size, bucketSize, fingerPrintLength uint64, opts ...Option) (*CuckooFilterRedis, error) {
+	return NewCuckooFilterRedisWithRetries(size, bucketSize, fingerPrintLength, 500, opts...)
 }
 
 // NewCuckooFilterWithRetries creates new CuckooFilterRedis with specified _retries_
@@ -42,12 +73,26 @@ func NewCuckooFilterRedis(size, bucketSize, fingerPrintLength uint64) (*CuckooFi
 // _fingerPrintLength_ is fingerprint hash of the input to be inserted/removed/lookup
 // _retries_ is the number of retries that the Cuckoo filter makes if the first two indices obtained
 // after hashing the input is already occupied in the filter
-func NewCuckooFilterRedisWithRetries(size, bucketSize, fingerPrintLength, retries uint64) (*CuckooFilterRedis, error) {
-	filterKey := util.GenerateRandomString(16)
-	baseFilter := makeAbstractCuckooFilter(size, bucketSize, fingerPrintLength, retries)
-	metadataKey := util.GenerateRandomString(16)
-	filter := &CuckooFilterRedis{make(map[string]*BucketRedis, size), filterKey, metadataKey, baseFilter}
-	err := filter.setMetadata(0)
+// _opts_ can name the Redis keys of the filter with WithName and WithKeyPrefix
+func NewCuckooFilterRedisWithRetries(size, bucketSize, fingerPrintLength, retries uint64, opts ...Option) (*CuckooFilterRedis, error) {
+	baseFilter, err := makeAbstractCuckooFilter(size, bucketSize, fingerPrintLength, retries)
+	if err != nil {
+		return nil, err
+	}
+	o, err := redisOptions("cuckoo filter", opts)
+	if err != nil {
+		return nil, err
+	}
+	metadataKey, err := o.metadataKey()
+	if err != nil {
+		return nil, err
+	}
+	filterKey, err := o.dataKey(":buckets")
+	if err != nil {
+		return nil, err
+	}
+	filter := &CuckooFilterRedis{make(map[string]*BucketRedis, size), filterKey, metadataKey, baseFilter, resources{}, false, false, nil, "", updateClock{}}
+	err = filter.setMetadata(0)
 	if err != nil {
 		return nil, fmt.Errorf("gostatix: error while creating cuckoo filter redis. error: %v", err)
 	}
@@ -65,41 +110,97 @@ func NewCuckooFilterRedisWithRetries(size, bucketSize, fingerPrintLength, retrie
 // _retries_ is the number of retries that the Cuckoo filter makes if the first two indices obtained
 // _errorRate_ is the desired false positive rate of the filter. fingerPrintLength is calculated
 // according to this error rate.
-func NewCuckooFilterRedisWithErrorRate(size, bucketSize, retries uint64, errorRate float64) (*CuckooFilterRedis, error) {
+func NewCuckooFilterRedisWithErrorRate(size, bucketSize, retries uint64, errorRate float64, opts ...Option) (*CuckooFilterRedis, error) {
+	err := checkCuckooFilterEstimates(size, bucketSize, errorRate)
+	if err != nil {
+		return nil, err
+	}
 	fingerPrintLength := util.CalculateFingerPrintLength(size, errorRate)
 	capacity := uint64(math.Ceil(float64(size) * 0.955 / float64(bucketSize)))
-	return NewCuckooFilterRedisWithRetries(capacity, bucketSize, fingerPrintLength, retries)
+	return NewCuckooFilterRedisWithRetries(capacity, bucketSize, fingerPrintLength, retries, opts...)
 }
 
 // NewCuckooFilterRedisFromKey is used to create a new Redis backed Cuckoo Filter from the
 // _metadataKey_ (the Redis key used to store the metadata about the cuckoo filter) passed
 // For this to work, value should be present in Redis at _key_
 func NewCuckooFilterRedisFromKey(metadataKey string) (*CuckooFilterRedis, error) {
-	values, err := getRedisClient().HGetAll(context.Background(), metadataKey).Result()
+	metadata, err := loadMetadata(metadataKey, "cuckoo")
 	if err != nil {
-		return nil, fmt.Errorf("gostatix: error while fetching hash from redis, error: %v", err)
+		return nil, err
+	}
+	params := make([]uint64, 4)
+	for i, field := range []string{"size", "bucketSize", "fingerPrintLength", "retries"} {
+		params[i], err = metadata.uint(field)
+		if err != nil {
+			return nil, err
+		}
+	}
+	key, err := metadata.string("key")
+	if err != nil {
+		return nil, err
 	}
-	size, _ := strconv.Atoi(values["size"])
-	bucketSize, _ := strconv.Atoi(values["bucketSize"])
-	fingerPrintLength, _ := strconv.Atoi(values["fingerPrintLength"])
-	retries, _ := strconv.Atoi(values["retries"])
 	cuckooFilter := &CuckooFilterRedis{}
-	baseFilter := makeAbstractCuckooFilter(uint64(size), uint64(bucketSize), uint64(fingerPrintLength), uint64(retries))
+	baseFilter, err := makeAbstractCuckooFilter(params[0], params[1], params[2], params[3])
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while creating cuckoo filter from redis key %s, error: %v", metadataKey, err)
+	}
 	cuckooFilter.AbstractCuckooFilter = baseFilter
 	cuckooFilter.metadataKey = metadataKey
-	cuckooFilter.key = values["key"]
+	cuckooFilter.key = key
+	cuckooFilter.safeRemove = metadata.values["safeRemove"] == "1"
+	cuckooFilter.expiry = metadata.values["expiry"] == "1"
+	// the filters created by the former versions have no alternate index in their metadata
+	cuckooFilter.legacyAltIndex = metadata.values["altIndex"] != "difference"
+	cuckooFilter.hashing, err = lookupCuckooHashing(metadata.values["hashing"])
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: invalid cuckoo filter metadata at key %s, error: %v", metadataKey, err)
+	}
 	cuckooFilter.buckets = make(map[string]*BucketRedis)
 	cuckooFilter.localInitBuckets()
 	return cuckooFilter, nil
 }
 
+// NewOrOpenCuckooFilterRedis creates a CuckooFilterRedis named _name_ (see WithName) with
+// the parameters of NewCuckooFilterRedis, or opens it like NewCuckooFilterRedisFromKey if it
+// already exists, in which case it must have been created with the same _size_, _bucketSize_
+// and _fingerPrintLength_
+func NewOrOpenCuckooFilterRedis(name string, size, bucketSize, fingerPrintLength uint64, opts ...Option) (*CuckooFilterRedis, error) {
+	opts, err := namedOptions(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	o, err := redisOptions("cuckoo filter", opts)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := o.nameExists()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return NewCuckooFilterRedis(size, bucketSize, fingerPrintLength, opts...)
+	}
+	metadataKey := o.keyPrefix + o.name
+	cuckooFilter, err := NewCuckooFilterRedisFromKey(metadataKey)
+	if err != nil {
+		return nil, err
+	}
+	err = checkOpenedParams("cuckoo filter", metadataKey,
+		[]interface{}{cuckooFilter.size, cuckooFilter.bucketSize, cuckooFilter.fingerPrintLength},
+		[]interface{}{size, bucketSize, fingerPrintLength})
+	if err != nil {
+		return nil, err
+	}
+	return cuckooFilter, nil
+}
+
 // Key returns the value of the _key_ to the Redis list where all bucket keys are stored
-func (cuckooFilter CuckooFilterRedis) Key() string {
+func (cuckooFilter *CuckooFilterRedis) Key() string {
 	return cuckooFilter.key
 }
 
 // MetadataKey return _metadataKey_
-func (cuckooFilter CuckooFilterRedis) MetadataKey() string {
+func (cuckooFilter *CuckooFilterRedis) MetadataKey() string {
 	return cuckooFilter.metadataKey
 }
 
@@ -114,49 +215,365 @@ func (cuckooFilter *CuckooFilterRedis) Length() uint64 {
 // Insert writes the _data_ in the Cuckoo Filter for future lookup
 // _destructive_ parameter is used to specify if the previous ordering of the
 // present entries is to be preserved after the retries (if that case arises)
+// It panics if the filter is full and returns false if the insert fails otherwise.
+//
+// Deprecated: use InsertWithStats, which returns an error instead of panicking.
 func (cuckooFilter *CuckooFilterRedis) Insert(data []byte, destructive bool) bool {
-	fingerPrint, firstBucketIndex, secondBucketIndex, _ := cuckooFilter.getPositions(data)
-	fIndex := cuckooFilter.getIndexKey(firstBucketIndex)
-	sIndex := cuckooFilter.getIndexKey(secondBucketIndex)
-	if cuckooFilter.buckets[fIndex].isFree() {
-		cuckooFilter.buckets[fIndex].add(fingerPrint)
-	} else if cuckooFilter.buckets[sIndex].isFree() {
-		cuckooFilter.buckets[sIndex].add(fingerPrint)
-	} else {
-		var index uint64
-		if rand.Float32() < 0.5 {
-			index = firstBucketIndex
-		} else {
-			index = secondBucketIndex
+	_, err := cuckooFilter.InsertWithStats(data, destructive, 0)
+	if errors.Is(err, ErrCuckooFilterFull) {
+		panic("cannot insert element, cuckoofilter is full")
+	}
+	return err == nil
+}
+
+// cuckooInsertScript inserts a fingerprint in a CuckooFilterRedis, including the kicks of
+// the entries of a full bucket, the length and the insert history, in a single round trip.
+// If ARGV[12] is '1' the fingerprint isn't inserted when it's already in one of its buckets.
+// If ARGV[13] isn't empty, the insert is stamped with the time ARGV[14] in the sorted set
+// of the insertion times (see expiryKey) under the payload field ARGV[13].
+// KEYS are the metadata hash, the hash of the alternate index parts of the fingerprints
+// (see altIndexParts), the insert history and the insertion times. ARGV are the prefix of the bucket keys, the
+// fingerprint, its bucket indices, the bucket size, the filter size, the number of kicks,
+// '1' if the insert is destructive, the alternate index parts of the fingerprint, its
+// field in the history (” without safe removes) and the seed of the kicks. ARGV[15] is
+// '1' if the alternate index is the difference one (see getAltIndex).
+// Like the in-memory insert, each entry kicked out is placed next in its other bucket,
+// kicking out another entry if it's full.
+// It returns {0, kicks} if the fingerprint was inserted, {1, kicks, rolledBack} if the
+// filter is full and {2, 0, fingerprints...} with the kicks rolled back if the alternate
+// index parts of the listed fingerprints are missing, or {3, 0} if the fingerprint was
+// present. It errors out with the kicks rolled back if a bucket to kick an entry out of is
+// empty.
+var cuckooInsertScript = redis.NewScript(bucketRecountLua + `
+	local metadataKey = KEYS[1]
+	local altKey = KEYS[2]
+	local historyKey = KEYS[3]
+	local prefix = ARGV[1]
+	local fingerPrint = ARGV[2]
+	local firstIndex = tonumber(ARGV[3])
+	local secondIndex = tonumber(ARGV[4])
+	local bucketSize = tonumber(ARGV[5])
+	local size = tonumber(ARGV[6])
+	local retries = tonumber(ARGV[7])
+	local destructive = ARGV[8] == '1'
+	local altParts = ARGV[9]
+	local historyField = ARGV[10]
+	math.randomseed(tonumber(ARGV[11]))
+	local ifMissing = ARGV[12] == '1'
+	local expiryField = ARGV[13]
+	local difference = ARGV[15] == '1'
+
+	local function bucketLength(index)
+		return recountBucket(prefix .. index)
+	end
+	local function add(index, element)
+		local bucketKey = prefix .. index
+		local pos = redis.call('LPOS', bucketKey, '')
+		if pos == false then
+			redis.call('LPUSH', bucketKey, element)
+		else
+			redis.call('LSET', bucketKey, pos, element)
+		end
+		redis.call('INCRBY', bucketKey .. '_len', 1)
+	end
+	local function inserted()
+		redis.call('HINCRBY', metadataKey, 'length', 1)
+		redis.call('HSETNX', altKey, fingerPrint, altParts)
+		if historyField ~= '' then
+			redis.call('HINCRBY', historyKey, historyField, 1)
+		end
+		if expiryField ~= '' then
+			local copy = 1
+			while redis.call('ZSCORE', KEYS[4], expiryField .. '|' .. copy) do
+				copy = copy + 1
+			end
+			redis.call('ZADD', KEYS[4], ARGV[14], expiryField .. '|' .. copy)
+		end
+	end
+	local function bxor(a, b)
+		local result, bit = 0, 1
+		while a > 0 or b > 0 do
+			local x, y = a % 2, b % 2
+			if x ~= y then
+				result = result + bit
+			end
+			a, b, bit = (a - x) / 2, (b - y) / 2, bit * 2
+		end
+		return result
+	end
+
+	if ifMissing then
+		if redis.call('LPOS', prefix .. firstIndex, fingerPrint) or redis.call('LPOS', prefix .. secondIndex, fingerPrint) then
+			return {3, 0}
+		end
+	end
+	if bucketLength(firstIndex) < bucketSize then
+		add(firstIndex, fingerPrint)
+		inserted()
+		return {0, 0}
+	end
+	if bucketLength(secondIndex) < bucketSize then
+		add(secondIndex, fingerPrint)
+		inserted()
+		return {0, 0}
+	end
+
+	local index = secondIndex
+	if math.random() < 0.5 then
+		index = firstIndex
+	end
+	local alts = {}
+	alts[fingerPrint] = altParts
+	-- the kicks are tracked as {index, pos, prev} to be rolled back
+	local kicked = {}
+	local function rollback()
+		for i = #kicked, 1, -1 do
+			redis.call('LSET', prefix .. kicked[i][1], kicked[i][2], kicked[i][3])
+		end
+	end
+	local current = fingerPrint
+	for i = 1, retries do
+		local bucketKey = prefix .. index
+		local elements = redis.call('LRANGE', bucketKey, 0, -1)
+		local missing = {}
+		if #elements > 0 then
+			local parts = redis.call('HMGET', altKey, unpack(elements))
+			for j = 1, #elements do
+				if parts[j] then
+					alts[elements[j]] = parts[j]
+				elseif elements[j] ~= '' and not alts[elements[j]] then
+					table.insert(missing, elements[j])
+				end
+			end
+		end
+		if #missing > 0 then
+			rollback()
+			return {2, 0, unpack(missing)}
+		end
+		if #elements == 0 then
+			rollback()
+			return redis.error_reply('cannot kick an entry out of the empty bucket ' .. bucketKey .. ' of length ' .. bucketLength(index))
+		end
+		local pos = math.random(0, #elements - 1)
+		local prev = elements[pos + 1]
+		table.insert(kicked, {index, pos, prev})
+		redis.call('LSET', bucketKey, pos, current)
+		if prev == '' then
+			redis.call('INCRBY', bucketKey .. '_len', 1)
+			inserted()
+			return {0, i}
+		end
+		local parts = alts[prev]
+		local sep = string.find(parts, ':')
+		local high = tonumber(string.sub(parts, 1, sep - 1))
+		local low = tonumber(string.sub(parts, sep + 1))
+		local newIndex
+		if difference then
+			newIndex = (high + size - index) % size
+		else
+			newIndex = (high + bxor(index, low)) % size
+		end
+		if bucketLength(newIndex) < bucketSize then
+			add(newIndex, prev)
+			inserted()
+			return {0, i}
+		end
+		-- the kicked out entry is the one to place next, in its other bucket
+		index, current = newIndex, prev
+	end
+	if destructive then
+		return {1, retries, 0}
+	end
+	rollback()
+	return {1, retries, 1}
+`)
+
+// InsertWithStats writes the _data_ in the Cuckoo Filter like Insert and returns the
+// statistics of the insert. It returns an error instead of panicking if the filter is full.
+// _destructive_ parameter is used to specify if the previous ordering of the
+// present entries is to be preserved after the retries (if that case arises)
+// _maxKicks_ overrides the number of retries of the filter for this insert, 0 keeps them
+// The whole insert runs atomically in a single Lua script.
+func (cuckooFilter *CuckooFilterRedis) InsertWithStats(data []byte, destructive bool, maxKicks uint64) (CuckooInsertStats, error) {
+	stats, _, err := cuckooFilter.insert(time.Now(), data, destructive, maxKicks, false)
+	return stats, err
+}
+
+// AddIfNotExists inserts _data_ in the Cuckoo Filter and returns true if it wasn't already
+// present. The lookup and the insert are done in the same Lua script, so that concurrent
+// calls with the same _data_ return true only once. It returns an error if the filter is full.
+func (cuckooFilter *CuckooFilterRedis) AddIfNotExists(data []byte) (bool, error) {
+	_, added, err := cuckooFilter.insert(time.Now(), data, false, 0, true)
+	return added, err
+}
+
+// insert runs cuckooInsertScript and returns the statistics of the insert and whether
+// _data_ was inserted. If _ifMissing_ is true, _data_ isn't inserted if it's present.
+// With expiry enabled, the insert is stamped with the time _now_.
+func (cuckooFilter *CuckooFilterRedis) insert(now time.Time, data []byte, destructive bool, maxKicks uint64, ifMissing bool) (CuckooInsertStats, bool, error) {
+	var stats CuckooInsertStats
+	fingerPrint, firstBucketIndex, secondBucketIndex, err := cuckooFilter.getPositions(data)
+	if err != nil {
+		return stats, false, err
+	}
+	historyField := ""
+	if cuckooFilter.safeRemove {
+		historyField = strconv.FormatUint(getHistoryHash(data), 16)
+	}
+	expiryField := ""
+	if cuckooFilter.expiry {
+		expiryField = payloadField(fingerPrint, firstBucketIndex, secondBucketIndex)
+	}
+	ctx := context.Background()
+	for {
+		result, err := cuckooInsertScript.Run(
+			ctx,
+			getRedisClient(),
+			[]string{cuckooFilter.metadataKey, cuckooFilter.altKey(), cuckooFilter.historyKey(), cuckooFilter.expiryKey()},
+			cuckooFilter.bucketKeyPrefix,
+			fingerPrint,
+			firstBucketIndex,
+			secondBucketIndex,
+			cuckooFilter.bucketSize,
+			cuckooFilter.size,
+			cuckooFilter.maxKicks(maxKicks),
+			destructive,
+			cuckooFilter.altIndexParts(fingerPrint),
+			historyField,
+			cuckooFilter.randInt31(),
+			ifMissing,
+			expiryField,
+			now.UnixMilli(),
+			cuckooFilter.differenceAltIndex(),
+		).Slice()
+		if err != nil {
+			return stats, false, fmt.Errorf("gostatix: error while inserting in cuckoo filter %s, error: %v", cuckooFilter.key, err)
 		}
-		indexKey := "cuckoo_" + cuckooFilter.key + "_bucket_" + strconv.FormatUint(index, 10)
-		currFingerPrint := fingerPrint
-		var items []entry
-		for i := uint64(0); i < cuckooFilter.retries; i++ {
-			randIndex := uint64(math.Ceil(rand.Float64() * float64(cuckooFilter.buckets[indexKey].getLength()-1)))
-			prevFingerPrint, _ := cuckooFilter.buckets[indexKey].at(randIndex)
-			items = append(items, entry{prevFingerPrint, index, randIndex})
-			cuckooFilter.buckets[indexKey].set(randIndex, currFingerPrint)
-			hash := getHash([]byte(prevFingerPrint))
-			newIndex := (index ^ hash) % uint64(len(cuckooFilter.buckets))
-			newIndexKey := "cuckoo_" + cuckooFilter.key + "_bucket_" + strconv.FormatUint(newIndex, 10)
-			if cuckooFilter.buckets[newIndexKey].isFree() {
-				cuckooFilter.buckets[newIndexKey].add(prevFingerPrint)
-				cuckooFilter.incrLength()
-				return true
-			}
+		status, _ := result[0].(int64)
+		kicks, _ := result[1].(int64)
+		stats.Kicks = uint64(kicks)
+		switch status {
+		case 0:
+			cuckooFilter.updated.touch()
+			return stats, true, nil
+		case 1:
+			rolledBack, _ := result[2].(int64)
+			stats.RolledBack = rolledBack == 1
+			return stats, false, fmt.Errorf("%w after %d kicks", ErrCuckooFilterFull, stats.Kicks)
+		case 3:
+			return stats, false, nil
 		}
-		if !destructive {
-			for i := len(items) - 1; i >= 0; i-- {
-				item := items[i]
-				firstIndexKey := "cuckoo_" + cuckooFilter.key + "_bucket_" + strconv.FormatUint(item.firstIndex, 10)
-				cuckooFilter.buckets[firstIndexKey].set(item.secondIndex, item.fingerPrint)
-			}
+		// the buckets hold fingerprints inserted before the alternate index parts were
+		// recorded, e.g. by an Import, so they're added and the insert is retried
+		parts := make(map[string]interface{}, len(result)-2)
+		for _, element := range result[2:] {
+			missing, _ := element.(string)
+			parts[missing] = cuckooFilter.altIndexParts(missing)
+		}
+		err = getRedisClient().HSet(ctx, cuckooFilter.altKey(), parts).Err()
+		if err != nil {
+			return stats, false, fmt.Errorf("gostatix: error while saving alternate indices in redis, error: %v", err)
 		}
-		panic("cannot insert element, cuckoofilter is full")
 	}
-	cuckooFilter.incrLength()
-	return true
+}
+
+// altIndexParts returns the parts "high:low" of the hash of _fingerPrint_ from which
+// cuckooInsertScript computes its alternate index (index ^ hash) % size without 64-bit
+// integers. _low_ holds the bits of the hash which can be set in an index and _high_ the
+// rest of the hash modulo the size, so that (index ^ hash) % size = (high + (index ^ low)) % size.
+// With the difference alternate index, _high_ is the hash modulo the size and _low_ is 0,
+// the alternate index being (high + size - index) % size.
+func (cuckooFilter *AbstractCuckooFilter) altIndexParts(fingerPrint string) string {
+	hash := cuckooFilter.fingerPrintStringHash(fingerPrint)
+	if cuckooFilter.differenceAltIndex() {
+		return strconv.FormatUint(hash%cuckooFilter.size, 10) + ":0"
+	}
+	mask := ^uint64(0) >> (64 - bits.Len64(cuckooFilter.size-1))
+	return strconv.FormatUint((hash&^mask)%cuckooFilter.size, 10) + ":" + strconv.FormatUint(hash&mask, 10)
+}
+
+// altKey returns the Redis key of the hash holding the alternate index parts of the
+// fingerprints inserted in the filter
+func (cuckooFilter *CuckooFilterRedis) altKey() string {
+	return cuckooFilter.key + "_alt"
+}
+
+// EnableSafeRemove makes Remove refuse to delete the elements which were never inserted,
+// like CuckooFilter.EnableSafeRemove. The inserts are counted by hash in a Redis hash next
+// to the buckets and the setting is saved in the metadata, so it's kept by the filters
+// created from the metadata key. It errors out if the filter isn't empty.
+func (cuckooFilter *CuckooFilterRedis) EnableSafeRemove() error {
+	if length := cuckooFilter.Length(); length > 0 {
+		return fmt.Errorf("gostatix: safe removes can only be enabled on an empty cuckoo filter, filter has %d entries", length)
+	}
+	err := getRedisClient().HSet(context.Background(), cuckooFilter.metadataKey, "safeRemove", 1).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while saving metadata in redis, error: %v", err)
+	}
+	cuckooFilter.safeRemove = true
+	return nil
+}
+
+// SetHashing sets the hashing deriving the fingerprints and the bucket indexes of the
+// elements, see CuckooHashing. The hashing is saved in the metadata by name, so it's kept
+// by the filters created from the metadata key, and exported along with the filter.
+// It errors out if the filter isn't empty as its fingerprints were derived otherwise.
+func (cuckooFilter *CuckooFilterRedis) SetHashing(hashing CuckooHashing) error {
+	if hashing == nil {
+		return fmt.Errorf("gostatix: cuckoo hashing can't be nil")
+	}
+	if length := cuckooFilter.Length(); length > 0 {
+		return fmt.Errorf("gostatix: hashing can only be set on an empty cuckoo filter, filter has %d entries", length)
+	}
+	err := getRedisClient().HSet(context.Background(), cuckooFilter.metadataKey, "hashing", hashing.Name()).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while saving metadata in redis, error: %v", err)
+	}
+	// the alternate index parts were computed with the previous hashing
+	err = getRedisClient().Del(context.Background(), cuckooFilter.altKey()).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while resetting alternate indices in redis, error: %v", err)
+	}
+	cuckooFilter.hashing = hashing
+	return nil
+}
+
+// historyKey returns the Redis key of the hash counting the inserts of each element
+func (cuckooFilter *CuckooFilterRedis) historyKey() string {
+	return cuckooFilter.key + "_history"
+}
+
+// EstimatedPositiveRate returns the false positive rate of the CuckooFilterRedis estimated
+// from its fingerprint length and its current occupancy, see CuckooFilter.EstimatedPositiveRate
+func (cuckooFilter *CuckooFilterRedis) EstimatedPositiveRate() float64 {
+	return cuckooFilter.positiveRate(cuckooFilter.Length())
+}
+
+// InsertString writes the _data_ (string) in the Cuckoo Filter for future lookup
+func (cuckooFilter *CuckooFilterRedis) InsertString(data string, destructive bool) bool {
+	return cuckooFilter.Insert([]byte(data), destructive)
+}
+
+// InsertUint64 writes the _data_ (unsigned integer) in the Cuckoo Filter for future lookup.
+// The integer is hashed as its 8 byte big endian encoding.
+func (cuckooFilter *CuckooFilterRedis) InsertUint64(data uint64, destructive bool) bool {
+	return cuckooFilter.Insert(uint64Bytes(data), destructive)
+}
+
+// InsertKey writes the _data_ (Key) in the Cuckoo Filter for future lookup
+func (cuckooFilter *CuckooFilterRedis) InsertKey(data Key, destructive bool) bool {
+	return cuckooFilter.Insert(data.KeyBytes(), destructive)
+}
+
+// InsertFromReader streams keys separated by _delim_ from _stream_ and inserts them
+// in the CuckooFilterRedis without holding all of them in memory.
+// _progress_ (optional, can be nil) is called periodically with the number of keys inserted.
+// It returns the number of keys inserted and stops with an error if the filter gets full.
+func (cuckooFilter *CuckooFilterRedis) InsertFromReader(stream io.Reader, delim byte, progress ProgressFunc) (uint64, error) {
+	return util.ReadKeys(stream, delim, progress, func(key []byte) error {
+		_, err := cuckooFilter.InsertWithStats(key, false, 0)
+		return err
+	})
 }
 
 // Lookup returns true if the _data_ is present in the Cuckoo Filter, else false
@@ -178,8 +595,65 @@ func (cuckooFilter *CuckooFilterRedis) Lookup(data []byte) (bool, error) {
 	return isAtFirstIndex || isAtSecondIndex, nil
 }
 
-// Remove deletes the _data_ from the Cuckoo Filter
+// LookupMulti looks up all the elements of _data_ and returns a slice with the result of
+// Lookup for each of them. The buckets of all the elements are searched in a single pipeline.
+func (cuckooFilter *CuckooFilterRedis) LookupMulti(data [][]byte) ([]bool, error) {
+	results := make([]bool, len(data))
+	if len(data) == 0 {
+		return results, nil
+	}
+	ctx := context.Background()
+	var positions []*redis.Cmd
+	err := execScriptPipeline(ctx, bucketExistsScript, func(pipe redis.Pipeliner) {
+		positions = make([]*redis.Cmd, 0, 2*len(data))
+		for _, element := range data {
+			fingerPrint, firstBucketIndex, secondBucketIndex, _ := cuckooFilter.getPositions(element)
+			positions = append(positions,
+				bucketExistsScript.EvalSha(ctx, pipe, []string{cuckooFilter.getIndexKey(firstBucketIndex)}, fingerPrint),
+				bucketExistsScript.EvalSha(ctx, pipe, []string{cuckooFilter.getIndexKey(secondBucketIndex)}, fingerPrint),
+			)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while lookup of data: %v", err)
+	}
+	for i := range results {
+		first, _ := positions[2*i].Int64()
+		second, _ := positions[2*i+1].Int64()
+		results[i] = first > -1 || second > -1
+	}
+	return results, nil
+}
+
+// LookupString returns true if the _data_ (string) is present in the Cuckoo Filter, else false
+func (cuckooFilter *CuckooFilterRedis) LookupString(data string) (bool, error) {
+	return cuckooFilter.Lookup([]byte(data))
+}
+
+// LookupUint64 returns true if the _data_ (unsigned integer) is present in the Cuckoo Filter, else false
+func (cuckooFilter *CuckooFilterRedis) LookupUint64(data uint64) (bool, error) {
+	return cuckooFilter.Lookup(uint64Bytes(data))
+}
+
+// LookupKey returns true if the _data_ (Key) is present in the Cuckoo Filter, else false
+func (cuckooFilter *CuckooFilterRedis) LookupKey(data Key) (bool, error) {
+	return cuckooFilter.Lookup(data.KeyBytes())
+}
+
+// Remove deletes the _data_ from the Cuckoo Filter. If safe removes are enabled, it returns
+// false without deleting anything if _data_ was never inserted.
 func (cuckooFilter *CuckooFilterRedis) Remove(data []byte) (bool, error) {
+	var field string
+	if cuckooFilter.safeRemove {
+		field = strconv.FormatUint(getHistoryHash(data), 16)
+		count, err := getRedisClient().HGet(context.Background(), cuckooFilter.historyKey(), field).Int64()
+		if err != nil && err != redis.Nil {
+			return false, fmt.Errorf("gostatix: error while removing the data, error: %v", err)
+		}
+		if count <= 0 {
+			return false, nil
+		}
+	}
 	fingerPrint, firstBucketIndex, secondBucketIndex, _ := cuckooFilter.getPositions(data)
 	fIndex := cuckooFilter.getIndexKey(firstBucketIndex)
 	sIndex := cuckooFilter.getIndexKey(secondBucketIndex)
@@ -189,19 +663,172 @@ func (cuckooFilter *CuckooFilterRedis) Remove(data []byte) (bool, error) {
 	}
 	if isPresent {
 		cuckooFilter.buckets[fIndex].remove(fingerPrint)
-		cuckooFilter.decrLength()
-		return true, nil
+	} else {
+		isPresent, err = cuckooFilter.buckets[sIndex].lookup(fingerPrint)
+		if err != nil {
+			return false, fmt.Errorf("gostatix: error while removing the data, error: %v", err)
+		}
+		if !isPresent {
+			return false, nil
+		}
+		cuckooFilter.buckets[sIndex].remove(fingerPrint)
 	}
-	isPresent, err = cuckooFilter.buckets[sIndex].lookup(fingerPrint)
+	cuckooFilter.updated.touch()
+	cuckooFilter.decrLength()
+	err = cuckooPayloadDropScript.Run(
+		context.Background(),
+		getRedisClient(),
+		[]string{fIndex, sIndex, cuckooFilter.payloadsKey()},
+		fingerPrint,
+		payloadField(fingerPrint, firstBucketIndex, secondBucketIndex),
+	).Err()
 	if err != nil {
-		return false, fmt.Errorf("gostatix: error while removing the data, error: %v", err)
+		return true, fmt.Errorf("gostatix: error while removing the payload of the data, error: %v", err)
 	}
-	if isPresent {
-		cuckooFilter.buckets[sIndex].remove(fingerPrint)
-		cuckooFilter.decrLength()
-		return true, nil
+	if cuckooFilter.expiry {
+		err = cuckooUnstampScript.Run(
+			context.Background(),
+			getRedisClient(),
+			[]string{cuckooFilter.expiryKey()},
+			payloadField(fingerPrint, firstBucketIndex, secondBucketIndex),
+		).Err()
+		if err != nil {
+			return true, fmt.Errorf("gostatix: error while removing the insertion time of the data, error: %v", err)
+		}
+	}
+	if cuckooFilter.safeRemove {
+		ctx := context.Background()
+		count, err := getRedisClient().HIncrBy(ctx, cuckooFilter.historyKey(), field, -1).Result()
+		if err == nil && count <= 0 {
+			getRedisClient().HDel(ctx, cuckooFilter.historyKey(), field)
+		}
 	}
-	return false, nil
+	return true, nil
+}
+
+// cuckooPutScript sets the field ARGV[2] of the payloads hash at KEYS[3] to ARGV[3] and
+// returns 1 if the fingerprint ARGV[1] is in one of the buckets at KEYS[1] and KEYS[2],
+// else it returns 0
+var cuckooPutScript = redis.NewScript(`
+	if redis.call('LPOS', KEYS[1], ARGV[1]) == false and redis.call('LPOS', KEYS[2], ARGV[1]) == false then
+		return 0
+	end
+	redis.call('HSET', KEYS[3], ARGV[2], ARGV[3])
+	return 1
+`)
+
+// cuckooGetScript returns {1, value} with the field ARGV[2] of the payloads hash at KEYS[3]
+// if the fingerprint ARGV[1] is in one of the buckets at KEYS[1] and KEYS[2], {1} if the
+// field isn't set, else false
+var cuckooGetScript = redis.NewScript(`
+	if redis.call('LPOS', KEYS[1], ARGV[1]) == false and redis.call('LPOS', KEYS[2], ARGV[1]) == false then
+		return false
+	end
+	local value = redis.call('HGET', KEYS[3], ARGV[2])
+	if value == false then
+		return {1}
+	end
+	return {1, value}
+`)
+
+// cuckooPayloadDropScript deletes the field ARGV[2] of the payloads hash at KEYS[3] if no
+// copy of the fingerprint ARGV[1] is left in the buckets at KEYS[1] and KEYS[2]
+var cuckooPayloadDropScript = redis.NewScript(`
+	if redis.call('LPOS', KEYS[1], ARGV[1]) == false and redis.call('LPOS', KEYS[2], ARGV[1]) == false then
+		redis.call('HDEL', KEYS[3], ARGV[2])
+	end
+	return true
+`)
+
+// payloadField returns the field of the payloads hash holding the value of the entries of
+// _fingerPrint_ and the buckets at _fIndex_ and _sIndex_
+func payloadField(fingerPrint string, fIndex, sIndex uint64) string {
+	fp, _ := strconv.ParseUint(fingerPrint, 10, 64)
+	return newCuckooEntry(fp, fIndex, sIndex).field()
+}
+
+// payloadsKey returns the Redis key of the hash holding the values stored by Put
+func (cuckooFilter *CuckooFilterRedis) payloadsKey() string {
+	return cuckooFilter.key + "_payloads"
+}
+
+// Put stores _value_ along with _data_ like CuckooFilter.Put. The values are held by a
+// Redis hash next to the buckets. _data_ is inserted if it isn't present, which can race
+// with a concurrent Remove of the same fingerprint, the value is then stored again.
+// It returns an error if the filter is full.
+func (cuckooFilter *CuckooFilterRedis) Put(data, value []byte) error {
+	fingerPrint, firstBucketIndex, secondBucketIndex, err := cuckooFilter.getPositions(data)
+	if err != nil {
+		return err
+	}
+	keys := []string{cuckooFilter.getIndexKey(firstBucketIndex), cuckooFilter.getIndexKey(secondBucketIndex), cuckooFilter.payloadsKey()}
+	field := payloadField(fingerPrint, firstBucketIndex, secondBucketIndex)
+	for {
+		stored, err := cuckooPutScript.Run(context.Background(), getRedisClient(), keys, fingerPrint, field, value).Int64()
+		if err != nil {
+			return fmt.Errorf("gostatix: error while storing the payload of the data, error: %v", err)
+		}
+		if stored == 1 {
+			cuckooFilter.updated.touch()
+			return nil
+		}
+		_, _, err = cuckooFilter.insert(time.Now(), data, false, 0, true)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// PutString stores _value_ along with _data_ (string)
+func (cuckooFilter *CuckooFilterRedis) PutString(data string, value []byte) error {
+	return cuckooFilter.Put([]byte(data), value)
+}
+
+// Get returns the value stored with _data_ by Put and true if _data_ is present, like
+// CuckooFilter.Get. The lookup and the read of the value are done in a single Lua script.
+func (cuckooFilter *CuckooFilterRedis) Get(data []byte) ([]byte, bool, error) {
+	fingerPrint, firstBucketIndex, secondBucketIndex, err := cuckooFilter.getPositions(data)
+	if err != nil {
+		return nil, false, err
+	}
+	result, err := cuckooGetScript.Run(
+		context.Background(),
+		getRedisClient(),
+		[]string{cuckooFilter.getIndexKey(firstBucketIndex), cuckooFilter.getIndexKey(secondBucketIndex), cuckooFilter.payloadsKey()},
+		fingerPrint,
+		payloadField(fingerPrint, firstBucketIndex, secondBucketIndex),
+	).Slice()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("gostatix: error while getting the payload of the data, error: %v", err)
+	}
+	if len(result) < 2 {
+		return nil, true, nil
+	}
+	value, _ := result[1].(string)
+	return []byte(value), true, nil
+}
+
+// GetString returns the value stored with _data_ (string) by Put
+func (cuckooFilter *CuckooFilterRedis) GetString(data string) ([]byte, bool, error) {
+	return cuckooFilter.Get([]byte(data))
+}
+
+// RemoveString deletes the _data_ (string) from the Cuckoo Filter
+func (cuckooFilter *CuckooFilterRedis) RemoveString(data string) (bool, error) {
+	return cuckooFilter.Remove([]byte(data))
+}
+
+// RemoveUint64 deletes the _data_ (unsigned integer) from the Cuckoo Filter
+func (cuckooFilter *CuckooFilterRedis) RemoveUint64(data uint64) (bool, error) {
+	return cuckooFilter.Remove(uint64Bytes(data))
+}
+
+// RemoveKey deletes the _data_ (Key) from the Cuckoo Filter
+func (cuckooFilter *CuckooFilterRedis) RemoveKey(data Key) (bool, error) {
+	return cuckooFilter.Remove(data.KeyBytes())
 }
 
 // bucketRedisJSON is internal struct used to json marshal/unmarshal redis backed buckets
@@ -222,79 +849,297 @@ type cuckooFilterRedisJSON struct {
 	Buckets           []bucketRedisJSON `json:"b"`
 	Key               string            `json:"k"`
 	MetadataKey       string            `json:"mk"`
+	Hashing           string            `json:"h,omitempty"`
+	Payloads          map[string][]byte `json:"p,omitempty"`
+	DiffAltIndex      bool              `json:"d,omitempty"`
+}
+
+// Reset removes all the entries of the CuckooFilterRedis in a single Lua script,
+// keeping its parameters, its Redis keys and its metadata
+func (cuckooFilter *CuckooFilterRedis) Reset() error {
+	err := cuckooResetScript.Run(
+		context.Background(),
+		getRedisClient(),
+		[]string{cuckooFilter.key, cuckooFilter.metadataKey, cuckooFilter.historyKey(), cuckooFilter.payloadsKey(), cuckooFilter.expiryKey()},
+	).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while resetting cuckoo filter %s, error: %v", cuckooFilter.key, err)
+	}
+	cuckooFilter.updated.touch()
+	return nil
+}
+
+// MemoryUsage returns the estimated number of bytes used in Redis by the CuckooFilterRedis,
+// as reported by MEMORY USAGE for its buckets, the list of buckets and its metadata
+func (cuckooFilter *CuckooFilterRedis) MemoryUsage() (uint64, error) {
+	keys := []string{cuckooFilter.key, cuckooFilter.metadataKey, cuckooFilter.historyKey(), cuckooFilter.altKey(), cuckooFilter.payloadsKey(), cuckooFilter.expiryKey()}
+	for i := uint64(0); i < cuckooFilter.size; i++ {
+		bucketKey := cuckooFilter.getIndexKey(i)
+		keys = append(keys, bucketKey, bucketKey+"_len")
+	}
+	return redisMemoryUsage(keys)
+}
+
+// Close releases the resources attached to the CuckooFilterRedis. The data of the filter
+// is kept in Redis. It's safe to call Close multiple times.
+func (cuckooFilter *CuckooFilterRedis) Close() error {
+	return cuckooFilter.resources.close()
 }
 
 // Export JSON marshals the CuckooFilterRedis and returns a byte slice containing the data
+// All the buckets along with the length of the filter are read in a single Lua script so
+// that the export is consistent even with concurrent writes
 func (filter *CuckooFilterRedis) Export() ([]byte, error) {
-	bucketsJSON := make([]bucketRedisJSON, filter.size)
-	for i := uint64(0); i < filter.size; i++ {
-		bucketKey := filter.getIndexKey(i)
-		bucket := filter.buckets[bucketKey]
-		elements, _ := bucket.getElements()
-		bucketJSON := bucketRedisJSON{bucket.Size(), bucket.getLength(), elements, bucketKey}
-		bucketsJSON[i] = bucketJSON
+	length, bucketsJSON, payloads, err := filter.getSnapshot()
+	if err != nil {
+		return nil, err
 	}
 	return json.Marshal(cuckooFilterRedisJSON{
 		filter.size,
 		filter.bucketSize,
 		filter.fingerPrintLength,
-		filter.Length(),
+		length,
 		filter.retries,
 		bucketsJSON,
 		filter.key,
 		filter.metadataKey,
+		filter.hashingName(),
+		payloads,
+		filter.differenceAltIndex(),
 	})
 }
 
-// Import JSON unmarshals the _data_ into the CuckooFilterRedis
+var cuckooSnapshotScript = redis.NewScript(`
+	local key = KEYS[1]
+	local metadataKey = KEYS[2]
+	local size = tonumber(ARGV[1])
+	local length = redis.call('HGET', metadataKey, 'length')
+	local buckets = {}
+	for i=0, size-1 do
+		local bucketKey = 'cuckoo_' .. key .. '_bucket_' .. tostring(i)
+		local bucketLength = redis.call('GET', bucketKey .. '_len')
+		buckets[i+1] = {bucketLength or '0', redis.call('LRANGE', bucketKey, 0, -1)}
+	end
+	return {length or '0', buckets, redis.call('HGETALL', KEYS[3])}
+`)
+
+// cuckooRepairScript recounts the entries of all the buckets listed at KEYS[1], fixing
+// the lengths of the buckets which drifted from them, and saves their sum as the length in
+// the metadata hash at KEYS[2]. It returns the number of fixed buckets and the length.
+var cuckooRepairScript = redis.NewScript(bucketRecountLua + `
+	local bucketKeys = redis.call('LRANGE', KEYS[1], 0, -1)
+	local fixed, length = 0, 0
+	for i=1, #bucketKeys do
+		local previous = tonumber(redis.call('GET', bucketKeys[i] .. '_len'))
+		local count = recountBucket(bucketKeys[i])
+		if previous ~= count then
+			fixed = fixed + 1
+		end
+		length = length + count
+	end
+	redis.call('HSET', KEYS[2], 'length', length)
+	return {fixed, length}
+`)
+
+// Repair recounts the entries of every bucket of the CuckooFilterRedis and fixes the
+// lengths of the buckets and of the filter which drifted from them, e.g. after a script
+// failed midway or the keys were modified by hand. The inserts and removes already fix
+// the lengths of the buckets they touch. It returns the number of buckets fixed.
+func (cuckooFilter *CuckooFilterRedis) Repair() (uint64, error) {
+	result, err := cuckooRepairScript.Run(
+		context.Background(),
+		getRedisClient(),
+		[]string{cuckooFilter.key, cuckooFilter.metadataKey},
+	).Int64Slice()
+	if err != nil || len(result) != 2 {
+		return 0, fmt.Errorf("gostatix: error while repairing cuckoo filter %s, error: %v", cuckooFilter.key, err)
+	}
+	return uint64(result[0]), nil
+}
+
+// getSnapshot returns the length of the filter, the contents of all the buckets and the
+// payloads by field read atomically in a single Lua script
+func (filter *CuckooFilterRedis) getSnapshot() (uint64, []bucketRedisJSON, map[string][]byte, error) {
+	result, err := cuckooSnapshotScript.Run(
+		context.Background(),
+		getRedisClient(),
+		[]string{filter.key, filter.metadataKey, filter.payloadsKey()},
+		filter.size,
+	).Slice()
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("gostatix: error fetching snapshot from redis, error: %v", err)
+	}
+	if len(result) != 3 {
+		return 0, nil, nil, fmt.Errorf("gostatix: error parsing snapshot from redis")
+	}
+	length, err := strconv.ParseUint(fmt.Sprint(result[0]), 10, 64)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("gostatix: error parsing length from redis, error: %v", err)
+	}
+	buckets, ok := result[1].([]interface{})
+	if !ok || uint64(len(buckets)) != filter.size {
+		return 0, nil, nil, fmt.Errorf("gostatix: error parsing buckets from redis")
+	}
+	bucketsJSON := make([]bucketRedisJSON, filter.size)
+	for i := range buckets {
+		bucket, ok := buckets[i].([]interface{})
+		if !ok || len(bucket) != 2 {
+			return 0, nil, nil, fmt.Errorf("gostatix: error parsing bucket %d from redis", i)
+		}
+		bucketLength, err := strconv.ParseUint(fmt.Sprint(bucket[0]), 10, 64)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("gostatix: error parsing length of bucket %d from redis, error: %v", i, err)
+		}
+		values, _ := bucket[1].([]interface{})
+		elements := make([]string, len(values))
+		for j := range values {
+			elements[j] = fmt.Sprint(values[j])
+		}
+		bucketsJSON[i] = bucketRedisJSON{filter.bucketSize, bucketLength, elements, filter.getIndexKey(uint64(i))}
+	}
+	fields, _ := result[2].([]interface{})
+	var payloads map[string][]byte
+	if len(fields) > 0 {
+		payloads = make(map[string][]byte, len(fields)/2)
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		value, _ := fields[i+1].(string)
+		payloads[fmt.Sprint(fields[i])] = []byte(value)
+	}
+	return length, bucketsJSON, payloads, nil
+}
+
+// validate checks the parameters and buckets of the decoded snapshot
+func (f *cuckooFilterRedisJSON) validate() error {
+	err := checkCuckooFilterParams(f.Size, f.BucketSize, f.FingerPrintLength)
+	if err != nil {
+		return err
+	}
+	if uint64(len(f.Buckets)) != f.Size {
+		return fmt.Errorf("gostatix: invalid cuckoo filter snapshot, %d buckets found instead of %d", len(f.Buckets), f.Size)
+	}
+	for i := range f.Buckets {
+		if uint64(len(f.Buckets[i].Elements)) > f.BucketSize {
+			return fmt.Errorf("gostatix: invalid cuckoo filter snapshot, bucket %d has %d elements, more than the bucket size %d", i, len(f.Buckets[i].Elements), f.BucketSize)
+		}
+	}
+	return nil
+}
+
+// Import JSON unmarshals the _data_ into the CuckooFilterRedis. The insert history isn't
+// exported, so Import disables safe removes.
 func (filter *CuckooFilterRedis) Import(data []byte, withNewRedisKey bool) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
 	var f cuckooFilterRedisJSON
-	err := json.Unmarshal(data, &f)
+	err = json.Unmarshal(data, &f)
 	if err != nil {
 		return fmt.Errorf("gostatix: error importing data, error %v", err)
 	}
+	err = f.validate()
+	if err != nil {
+		return err
+	}
+	hashing, err := lookupCuckooHashing(f.Hashing)
+	if err != nil {
+		return err
+	}
+	key, metadataKey := f.Key, f.MetadataKey
+	if withNewRedisKey {
+		key, err = newRedisKey(context.Background(), "")
+		if err == nil {
+			metadataKey, err = newRedisKey(context.Background(), "")
+		}
+		if err != nil {
+			return err
+		}
+	}
 	filter.size = f.Size
 	filter.bucketSize = f.BucketSize
 	filter.fingerPrintLength = f.FingerPrintLength
 	filter.retries = f.Retries
-	if withNewRedisKey {
-		filter.key = util.GenerateRandomString(16)
-		filter.metadataKey = util.GenerateRandomString(16)
-	} else {
-		filter.key = f.Key
-		filter.metadataKey = f.MetadataKey
+	filter.hashing = hashing
+	filter.legacyAltIndex = !f.DiffAltIndex
+	// the imported entries have no insert history nor insertion times
+	filter.safeRemove = false
+	filter.expiry = false
+	filter.key = key
+	filter.metadataKey = metadataKey
+	// the entries of a filter already saved at the key are removed, so that the imported
+	// entries don't add up to them
+	err = cuckooResetScript.Run(
+		context.Background(),
+		getRedisClient(),
+		[]string{filter.key, filter.metadataKey, filter.historyKey(), filter.payloadsKey(), filter.expiryKey()},
+	).Err()
+	if err == nil {
+		err = getRedisClient().Del(context.Background(), filter.altKey()).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("gostatix: error while clearing cuckoo filter %s in redis, error: %v", filter.key, err)
+	}
+	err = filter.setMetadata(f.Length)
+	if err != nil {
+		return fmt.Errorf("gostatix: error while saving metadata in redis, error: %v", err)
+	}
+	err = filter.initBuckets()
+	if err != nil {
+		return err
+	}
+	if len(f.Payloads) > 0 {
+		fields := make([]interface{}, 0, 2*len(f.Payloads))
+		for field, value := range f.Payloads {
+			fields = append(fields, field, value)
+		}
+		err = getRedisClient().HSet(context.Background(), filter.payloadsKey(), fields...).Err()
+		if err != nil {
+			return fmt.Errorf("gostatix: error while importing the payloads in redis, error: %v", err)
+		}
 	}
-	filter.setMetadata(f.Length)
-	filter.initBuckets()
 	filters := make(map[string]*BucketRedis, f.Size)
 	for i := range f.Buckets {
 		bucketJSON := f.Buckets[i]
 		bucketKey := filter.getIndexKey(uint64(i))
 		bucket := newBucketRedis(bucketKey, f.BucketSize)
 		for j := range bucketJSON.Elements {
-			bucket.add(bucketJSON.Elements[j])
+			_, err = bucket.add(bucketJSON.Elements[j])
+			if err != nil {
+				return fmt.Errorf("gostatix: error while importing bucket %d in redis, error: %v", i, err)
+			}
 		}
 		filters[bucketKey] = bucket
 	}
 	filter.buckets = filters
+	filter.updated.touch()
 	return nil
 }
 
-func (aFilter CuckooFilterRedis) Equals(bFilter CuckooFilterRedis) (bool, error) {
-	count := 0
-	result := true
-	for result && count < len(aFilter.buckets) {
-		bucket := aFilter.buckets[aFilter.getIndexKey(uint64(count))]
-		ok, err := bFilter.buckets[bFilter.getIndexKey(uint64(count))].equals(bucket)
+// Equals checks if two CuckooFilterRedis are same or not. Filters with different
+// parameters aren't equal.
+func (aFilter *CuckooFilterRedis) Equals(bFilter *CuckooFilterRedis) (bool, error) {
+	comparison, err := aFilter.Compare(bFilter)
+	return comparison.Equal(), err
+}
+
+// Compare compares two CuckooFilterRedis and returns why they aren't equal, if they aren't
+func (aFilter *CuckooFilterRedis) Compare(bFilter *CuckooFilterRedis) (Comparison, error) {
+	comparison := aFilter.compareParams(bFilter.AbstractCuckooFilter)
+	if !comparison.Equal() {
+		return comparison, nil
+	}
+	for count := uint64(0); count < aFilter.size; count++ {
+		bucket := aFilter.buckets[aFilter.getIndexKey(count)]
+		ok, err := bFilter.buckets[bFilter.getIndexKey(count)].equals(bucket)
 		if err != nil {
-			return false, err
+			return Comparison{}, err
 		}
 		if !ok {
-			return false, nil
+			return contentMismatch("buckets"), nil
 		}
-		count++
 	}
-	return true, nil
+	return equalComparison, nil
 }
 
 func (cuckooFilter *CuckooFilterRedis) incrLength() error {
@@ -312,55 +1157,72 @@ func (cuckooFilter *CuckooFilterRedis) setMetadata(length uint64) error {
 	metadata["fingerPrintLength"] = cuckooFilter.fingerPrintLength
 	metadata["retries"] = cuckooFilter.retries
 	metadata["key"] = cuckooFilter.key
-	metadata["length"] = 0
-	return getRedisClient().HSet(context.Background(), cuckooFilter.metadataKey, metadata).Err()
+	metadata["length"] = length
+	metadata["safeRemove"] = 0
+	if cuckooFilter.safeRemove {
+		metadata["safeRemove"] = 1
+	}
+	metadata["expiry"] = 0
+	if cuckooFilter.expiry {
+		metadata["expiry"] = 1
+	}
+	if name := cuckooFilter.hashingName(); name != "" {
+		metadata["hashing"] = name
+	}
+	metadata["altIndex"] = "xor"
+	if cuckooFilter.differenceAltIndex() {
+		metadata["altIndex"] = "difference"
+	}
+	return saveMetadata(cuckooFilter.metadataKey, "cuckoo", metadata)
 }
 
+var cuckooInitScript = redis.NewScript(`
+	local key = KEYS[1]
+	local size = ARGV[1]
+	local bucketSize = ARGV[2]
+	redis.call("DEL", key)
+	for i=2, tonumber(size)+1 do
+		redis.call("LPUSH", key, KEYS[i])
+	end
+	return true
+`)
+
 func (filter *CuckooFilterRedis) initBuckets() error {
-	var bucketKeys []string
-	for i := uint64(0); i < filter.size; i++ {
-		bucketKey := "cuckoo_" + filter.key + "_bucket_" + strconv.FormatUint(i, 10)
-		bucketKeys = append(bucketKeys, bucketKey)
-	}
-	initCuckooFilterRedis := redis.NewScript(`
-		local key = KEYS[1]
-		local size = ARGV[1]
-		local bucketSize = ARGV[2]
-		redis.call("DEL", key)
-		for i=2, tonumber(size)+1 do
-			redis.call("LPUSH", key, KEYS[i])
-		end
-		return true
-	`)
-	_, err := initCuckooFilterRedis.Run(
+	filter.cacheBucketKeys()
+	_, err := cuckooInitScript.Run(
 		context.Background(),
 		getRedisClient(),
-		append([]string{filter.key}, bucketKeys...),
+		append([]string{filter.key}, filter.bucketKeys...),
 		filter.size,
 		filter.bucketSize,
 	).Bool()
 	if err != nil {
 		return fmt.Errorf("error while init buckets in redis, error: %v", err)
 	}
-	for i := range bucketKeys {
-		bucketKey := bucketKeys[i]
+	for _, bucketKey := range filter.bucketKeys {
 		filter.buckets[bucketKey] = newBucketRedis(bucketKey, filter.bucketSize)
 	}
 	return nil
 }
 
 func (filter *CuckooFilterRedis) localInitBuckets() {
-	var bucketKeys []string
-	for i := uint64(0); i < filter.size; i++ {
-		bucketKey := "cuckoo_" + filter.key + "_bucket_" + strconv.FormatUint(i, 10)
-		bucketKeys = append(bucketKeys, bucketKey)
-	}
-	for i := range bucketKeys {
-		bucketKey := bucketKeys[i]
+	filter.cacheBucketKeys()
+	for _, bucketKey := range filter.bucketKeys {
 		filter.buckets[bucketKey] = newBucketRedis(bucketKey, filter.bucketSize)
 	}
 }
 
+// cacheBucketKeys builds the Redis keys of the buckets for the key and the size of the
+// filter. It's called whenever they change, i.e. when the buckets are initialized.
+func (filter *CuckooFilterRedis) cacheBucketKeys() {
+	filter.bucketKeyPrefix = "cuckoo_" + filter.key + "_bucket_"
+	filter.bucketKeys = make([]string, filter.size)
+	for i := range filter.bucketKeys {
+		filter.bucketKeys[i] = filter.bucketKeyPrefix + strconv.Itoa(i)
+	}
+}
+
+// getIndexKey returns the cached Redis key of the bucket at _index_
 func (cuckooFilter *CuckooFilterRedis) getIndexKey(index uint64) string {
-	return "cuckoo_" + cuckooFilter.key + "_bucket_" + strconv.FormatUint(index, 10)
+	return cuckooFilter.bucketKeys[index]
 }
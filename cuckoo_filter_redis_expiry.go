@@ -0,0 +1,167 @@
+package gostatix
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// cuckooSweepBatch is the number of insertion times read by each run of cuckooSweepScript
+var cuckooSweepBatch = 1000
+
+// cuckooUnstampScript deletes one of the insertion times of the entries of the payload
+// field ARGV[1] from the sorted set at KEYS[1], the oldest one, and moves the last copy
+// into its place so that the copies stay numbered from 1
+var cuckooUnstampScript = redis.NewScript(`
+	local field = ARGV[1]
+	local oldest, oldestScore = 0, nil
+	local copy = 1
+	while true do
+		local score = redis.call('ZSCORE', KEYS[1], field .. '|' .. copy)
+		if not score then
+			break
+		end
+		score = tonumber(score)
+		if oldestScore == nil or score < oldestScore then
+			oldest, oldestScore = copy, score
+		end
+		copy = copy + 1
+	end
+	local last = copy - 1
+	if last == 0 then
+		return 0
+	end
+	if oldest ~= last then
+		redis.call('ZADD', KEYS[1], redis.call('ZSCORE', KEYS[1], field .. '|' .. last), field .. '|' .. oldest)
+	end
+	redis.call('ZREM', KEYS[1], field .. '|' .. last)
+	return 1
+`)
+
+// cuckooSweepScript removes up to ARGV[3] entries inserted before the time ARGV[2] from
+// the buckets prefixed by ARGV[1], along with their insertion times in the sorted set at
+// KEYS[1]. It decrements the length in the metadata hash at KEYS[2] and deletes the
+// payloads at KEYS[3] of the fingerprints which have no copy left. The insertion times
+// of the entries missing from their buckets are dropped without removing anything.
+// It returns {removed, scanned} where scanned is the number of insertion times read.
+var cuckooSweepScript = redis.NewScript(bucketRecountLua + `
+	local expiryKey = KEYS[1]
+	local metadataKey = KEYS[2]
+	local payloadsKey = KEYS[3]
+	local prefix = ARGV[1]
+	local cutoff = tonumber(ARGV[2])
+	local members = redis.call('ZRANGEBYSCORE', expiryKey, '-inf', '(' .. ARGV[2], 'LIMIT', 0, tonumber(ARGV[3]))
+	local removed = 0
+	for i = 1, #members do
+		local member = members[i]
+		local score = redis.call('ZSCORE', expiryKey, member)
+		-- the member may have been replaced by the last copy of its field
+		if score and tonumber(score) < cutoff then
+			local sep = string.find(member, '|', 1, true)
+			local field = string.sub(member, 1, sep - 1)
+			local first = string.find(field, ':', 1, true)
+			local second = string.find(field, ':', first + 1, true)
+			local fingerPrint = string.sub(field, 1, first - 1)
+			local bucketKeys = {prefix .. string.sub(field, first + 1, second - 1), prefix .. string.sub(field, second + 1)}
+
+			local last = 1
+			while redis.call('ZSCORE', expiryKey, field .. '|' .. (last + 1)) do
+				last = last + 1
+			end
+			local lastMember = field .. '|' .. last
+			if member ~= lastMember then
+				redis.call('ZADD', expiryKey, redis.call('ZSCORE', expiryKey, lastMember), member)
+			end
+			redis.call('ZREM', expiryKey, lastMember)
+
+			for _, bucketKey in ipairs(bucketKeys) do
+				local pos = redis.call('LPOS', bucketKey, fingerPrint)
+				if pos then
+					redis.call('LSET', bucketKey, pos, '')
+					recountBucket(bucketKey)
+					redis.call('HINCRBY', metadataKey, 'length', -1)
+					removed = removed + 1
+					break
+				end
+			end
+			if redis.call('LPOS', bucketKeys[1], fingerPrint) == false and redis.call('LPOS', bucketKeys[2], fingerPrint) == false then
+				redis.call('HDEL', payloadsKey, field)
+			end
+		end
+	end
+	return {removed, #members}
+`)
+
+// expiryKey returns the Redis key of the sorted set holding the insertion times of the
+// entries of the filter in milliseconds. Its members are "<payload field>|<copy>", the
+// copies of the entries of a payload field being numbered from 1, so that like the
+// payloads they don't change when the entries are kicked to their other bucket.
+func (cuckooFilter *CuckooFilterRedis) expiryKey() string {
+	return cuckooFilter.key + "_expiry"
+}
+
+// EnableExpiry makes the CuckooFilterRedis record the insertion time of each entry, so
+// that Sweep can remove the entries older than a given age. The times are kept in a Redis
+// sorted set next to the buckets and the setting is saved in the metadata, so it's kept
+// by the filters created from the metadata key. Removing an element drops the oldest
+// time of its entries. It errors out if the filter isn't empty.
+func (cuckooFilter *CuckooFilterRedis) EnableExpiry() error {
+	if length := cuckooFilter.Length(); length > 0 {
+		return fmt.Errorf("gostatix: expiry can only be enabled on an empty cuckoo filter, filter has %d entries", length)
+	}
+	err := getRedisClient().HSet(context.Background(), cuckooFilter.metadataKey, "expiry", 1).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while saving metadata in redis, error: %v", err)
+	}
+	cuckooFilter.expiry = true
+	return nil
+}
+
+// InsertAt writes the _data_ in the Cuckoo Filter like InsertWithStats, recording _now_
+// as its insertion time if expiry is enabled. It returns an error if the filter is full.
+func (cuckooFilter *CuckooFilterRedis) InsertAt(now time.Time, data []byte, destructive bool) error {
+	_, _, err := cuckooFilter.insert(now, data, destructive, 0, false)
+	return err
+}
+
+// Sweep removes the entries inserted more than _olderThan_ ago and returns the number of
+// entries removed. See SweepAt.
+func (cuckooFilter *CuckooFilterRedis) Sweep(olderThan time.Duration) (uint64, error) {
+	return cuckooFilter.SweepAt(time.Now(), olderThan)
+}
+
+// SweepAt removes the entries inserted before _now_ - _olderThan_ and returns the number
+// of entries removed. The entries are removed server side by a Lua script, in batches so
+// that a large sweep doesn't block Redis. As the elements aren't known, the insert history
+// of safe removes isn't decremented. It errors out if expiry isn't enabled.
+func (cuckooFilter *CuckooFilterRedis) SweepAt(now time.Time, olderThan time.Duration) (uint64, error) {
+	if !cuckooFilter.expiry {
+		return 0, fmt.Errorf("gostatix: expiry isn't enabled on cuckoo filter %s", cuckooFilter.key)
+	}
+	keys := []string{cuckooFilter.expiryKey(), cuckooFilter.metadataKey, cuckooFilter.payloadsKey()}
+	cutoff := now.Add(-olderThan).UnixMilli()
+	var removed uint64
+	for {
+		result, err := cuckooSweepScript.Run(
+			context.Background(),
+			getRedisClient(),
+			keys,
+			cuckooFilter.bucketKeyPrefix,
+			cutoff,
+			cuckooSweepBatch,
+		).Int64Slice()
+		if err != nil || len(result) != 2 {
+			return removed, fmt.Errorf("gostatix: error while sweeping cuckoo filter %s, error: %v", cuckooFilter.key, err)
+		}
+		removed += uint64(result[0])
+		if result[0] > 0 {
+			cuckooFilter.updated.touch()
+		}
+		// each run removes at least the first insertion time read
+		if result[1] == 0 {
+			return removed, nil
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package gostatix
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"reflect"
@@ -62,7 +65,7 @@ func TestCuckooRedisRetries(t *testing.T) {
 	firstIndex := filter.getIndexKey(fIndex)
 	secondIndex := filter.getIndexKey(sIndex)
 	filter.buckets[firstIndex].add("bar")
-	filter.buckets[secondIndex].add("baz")
+	filter.buckets[secondIndex].add("qux")
 	filter.incrLength()
 	filter.incrLength()
 	ok := filter.Insert(e, false)
@@ -74,8 +77,8 @@ func TestCuckooRedisRetries(t *testing.T) {
 		bucket := filter.buckets[b]
 		if bucket.getLength() > 0 {
 			elem, _ := bucket.at(0)
-			if elem != "bar" && elem != "baz" && elem != fingerPrint {
-				t.Errorf("elem shuold be either \"bar\", \"baz\" or \"%s\", instead found %v", fingerPrint, elem)
+			if elem != "bar" && elem != "qux" && elem != fingerPrint {
+				t.Errorf("elem shuold be either \"bar\", \"qux\" or \"%s\", instead found %v", fingerPrint, elem)
 			}
 		}
 		bucketsLength += int(bucket.getLength())
@@ -182,9 +185,9 @@ func TestRollbackWhenFullCuckooRedis(t *testing.T) {
 	if !ok {
 		t.Error("should insert four")
 	}
-	ok = filter.Insert([]byte("five"), false)
+	ok = filter.Insert([]byte("zero"), false)
 	if !ok {
-		t.Error("should insert five")
+		t.Error("should insert zero")
 	}
 	snapshot1, _ := filter.Export()
 
@@ -224,9 +227,9 @@ func TestNoRollbackWhenFullCuckooRedis(t *testing.T) {
 	if !ok {
 		t.Error("should insert four")
 	}
-	ok = filter.Insert([]byte("five"), false)
+	ok = filter.Insert([]byte("zero"), false)
 	if !ok {
-		t.Error("should insert five")
+		t.Error("should insert zero")
 	}
 	snapshot1, _ := filter.Export()
 
@@ -267,7 +270,7 @@ func TestCuckooEqualsCuckooRedis(t *testing.T) {
 	filter2.Insert([]byte("one"), false)
 	filter2.Insert([]byte("two"), false)
 	filter2.Insert([]byte("three"), false)
-	if ok, _ := filter1.Equals(*filter2); !ok {
+	if ok, _ := filter1.Equals(filter2); !ok {
 		t.Error("filter1 and filter2 should be same")
 	}
 }
@@ -280,7 +283,7 @@ func TestCuckooMarshalUnmarshalCuckooRedis(t *testing.T) {
 	filter1.Insert([]byte("three"), false)
 	filter1.Insert([]byte("four"), false)
 	snapshot, _ := filter1.Export()
-	filter2, _ := NewCuckooFilterRedis(0, 0, 0)
+	filter2, _ := NewCuckooFilterRedis(1, 1, 1)
 	filter2.Import(snapshot, true)
 	ok, _ := filter2.Lookup([]byte("one"))
 	if !ok {
@@ -290,12 +293,57 @@ func TestCuckooMarshalUnmarshalCuckooRedis(t *testing.T) {
 	if ok {
 		t.Error("\"five\" should not be in filter3")
 	}
-	ok, _ = filter1.Equals(*filter2)
+	ok, _ = filter1.Equals(filter2)
 	if !ok {
 		t.Errorf("filter1 and filter2 should be same")
 	}
 }
 
+func TestCuckooFilterRedisImportSameKey(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewCuckooFilterRedis(10, 2, 3)
+	filter.InsertString("a", false)
+	filter.InsertString("b", false)
+	snapshot, _ := filter.Export()
+	filter.Put([]byte("c"), []byte("stale"))
+	fingerPrint, _, _, _ := filter.getPositions([]byte("c"))
+
+	err := filter.Import(snapshot, false)
+	if err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if filter.Length() != 2 {
+		t.Errorf("length should be 2 after import, found %d", filter.Length())
+	}
+	exported, _ := filter.Export()
+	var f cuckooFilterRedisJSON
+	json.Unmarshal(exported, &f)
+	if f.Length != 2 {
+		t.Errorf("exported length should be 2 after import, found %d", f.Length)
+	}
+	entries := 0
+	for _, bucket := range f.Buckets {
+		for _, element := range bucket.Elements {
+			if element != "" {
+				entries++
+			}
+		}
+	}
+	if entries != 2 {
+		t.Errorf("buckets should hold the 2 imported entries, found %d", entries)
+	}
+	filter.RemoveString("a")
+	if ok, _ := filter.LookupString("a"); ok {
+		t.Error("a shouldn't be found after its removal")
+	}
+	if _, found, _ := filter.Get([]byte("c")); found {
+		t.Error("payload of c shouldn't survive the import")
+	}
+	if exists, _ := getRedisClient().HExists(context.Background(), filter.altKey(), fingerPrint).Result(); exists {
+		t.Error("alternate index parts of c shouldn't survive the import")
+	}
+}
+
 func TestCuckooRedisImportFromRedisKey(t *testing.T) {
 	initMockRedis()
 	filter1, _ := NewCuckooFilterRedis(5, 1, 3)
@@ -314,7 +362,7 @@ func TestCuckooRedisImportFromRedisKey(t *testing.T) {
 	if ok {
 		t.Error("\"five\" should not be in filter3")
 	}
-	ok, _ = filter1.Equals(*filter2)
+	ok, _ = filter1.Equals(filter2)
 	if !ok {
 		t.Errorf("filter1 and filter2 should be same")
 	}
@@ -364,3 +412,400 @@ func BenchmarkCuckooRedisLookup100MX4X500X001X1M(b *testing.B) {
 		filter.Lookup([]byte(strconv.FormatUint(rand.Uint64(), 10)))
 	}
 }
+
+func TestCuckooFilterRedisInsertWithStats(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewCuckooFilterRedis(1, 1, 3)
+	stats, err := filter.InsertWithStats([]byte("foo"), false, 0)
+	if err != nil || stats.Kicks != 0 || stats.RolledBack {
+		t.Errorf("insert in a free bucket shouldn't kick entries, stats: %+v, error: %v", stats, err)
+	}
+	stats, err = filter.InsertWithStats([]byte("bar"), false, 5)
+	if !errors.Is(err, ErrCuckooFilterFull) {
+		t.Errorf("insert in a full filter should error out with ErrCuckooFilterFull, error: %v", err)
+	}
+	if stats.Kicks != 5 || !stats.RolledBack {
+		t.Errorf("insert should kick 5 entries and roll back, stats: %+v", stats)
+	}
+	if ok, _ := filter.LookupString("foo"); !ok || filter.Length() != 1 {
+		t.Error("foo should be restored in the filter after the roll back")
+	}
+}
+
+func TestCuckooFilterRedisInsertError(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewCuckooFilterRedis(10, 2, 3)
+	// the script fails on the metadata replaced by a string
+	getRedisClient().Set(context.Background(), filter.MetadataKey(), "foo", 0)
+	defer getRedisClient().Del(context.Background(), filter.MetadataKey())
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("insert shouldn't panic if redis fails, panic: %v", r)
+		}
+	}()
+	if filter.InsertString("foo", false) {
+		t.Error("insert should fail if redis fails")
+	}
+	if _, err := filter.InsertWithStats([]byte("foo"), false, 0); err == nil || errors.Is(err, ErrCuckooFilterFull) {
+		t.Errorf("insert should error out without ErrCuckooFilterFull if redis fails, error: %v", err)
+	}
+}
+
+func TestCuckooFilterRedisReset(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewCuckooFilterRedis(10, 2, 3)
+	filter.InsertString("alice", false)
+	filter.InsertString("bob", false)
+	err := filter.Reset()
+	if err != nil {
+		t.Fatalf("reset shouldn't error out, error: %v", err)
+	}
+	if ok, _ := filter.LookupString("alice"); ok || filter.Length() != 0 {
+		t.Error("filter should be empty after reset")
+	}
+	filter.InsertString("carol", false)
+	loaded, _ := NewCuckooFilterRedisFromKey(filter.MetadataKey())
+	if ok, _ := loaded.LookupString("carol"); !ok || loaded.Length() != 1 {
+		t.Error("carol should be inserted after reset")
+	}
+}
+
+func TestCuckooFilterRedisSafeRemove(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewCuckooFilterRedis(100, 4, 2)
+	if err := filter.EnableSafeRemove(); err != nil {
+		t.Fatalf("enabling safe removes shouldn't error out, error: %v", err)
+	}
+	filter.InsertString("foo", false)
+	filter.InsertString("foo", false)
+	collision := ""
+	for i := 0; collision == ""; i++ {
+		if ok, _ := filter.LookupString(strconv.Itoa(i)); ok {
+			collision = strconv.Itoa(i)
+		}
+	}
+	loaded, _ := NewCuckooFilterRedisFromKey(filter.MetadataKey())
+	if ok, _ := loaded.RemoveString(collision); ok {
+		t.Errorf("%s was never inserted and shouldn't be removed", collision)
+	}
+	ok1, _ := loaded.RemoveString("foo")
+	ok2, _ := filter.RemoveString("foo")
+	ok3, _ := filter.RemoveString("foo")
+	if !ok1 || !ok2 || ok3 {
+		t.Errorf("foo was inserted twice and should be removed twice, got %v %v %v", ok1, ok2, ok3)
+	}
+	filter.InsertString("bar", false)
+	filter.Reset()
+	if ok, _ := filter.RemoveString("bar"); ok {
+		t.Errorf("bar shouldn't be removed after reset")
+	}
+	if rate := filter.EstimatedPositiveRate(); rate != 0 {
+		t.Errorf("estimated positive rate of an empty filter should be 0, got %f", rate)
+	}
+}
+
+func TestCuckooAltIndexParts(t *testing.T) {
+	for _, size := range []uint64{1, 2, 7, 10, 64, 1000, 1<<32 + 5} {
+		for _, legacy := range []bool{false, true} {
+			filter, _ := makeAbstractCuckooFilter(size, 2, 3, 10)
+			filter.legacyAltIndex = legacy
+			for i := 0; i < 100; i++ {
+				fingerPrint := strconv.Itoa(100 + i*7)
+				var high, low uint64
+				fmt.Sscanf(filter.altIndexParts(fingerPrint), "%d:%d", &high, &low)
+				for _, index := range []uint64{0, size / 3, size - 1} {
+					expected := filter.getAltIndex(index, uint64(100+i*7))
+					actual := (high + (index ^ low)) % size
+					if filter.differenceAltIndex() {
+						actual = (high + size - index) % size
+					}
+					if actual != expected {
+						t.Errorf("alternate index of %s from %d with size %d should be %d, found %d", fingerPrint, index, size, expected, actual)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestCuckooFilterRedisKicksKeepLengths(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewCuckooFilterRedis(20, 2, 3)
+	inserted := 0
+	for i := 0; i < 40; i++ {
+		_, err := filter.InsertWithStats([]byte("element"+strconv.Itoa(i)), false, 0)
+		if err != nil {
+			break
+		}
+		inserted++
+	}
+	if inserted < 20 {
+		t.Errorf("filter should hold at least 20 elements with kicks, inserted %d", inserted)
+	}
+	if filter.Length() != uint64(inserted) {
+		t.Errorf("filter length should be %d, found %d", inserted, filter.Length())
+	}
+	bucketsLength := uint64(0)
+	for _, bucket := range filter.buckets {
+		elements, _ := bucket.getElements()
+		for _, element := range elements {
+			if element != "" {
+				bucketsLength++
+			}
+		}
+		if bucket.getLength() > filter.bucketSize {
+			t.Errorf("bucket %s length %d is larger than the bucket size", bucket.key, bucket.getLength())
+		}
+	}
+	if bucketsLength != uint64(inserted) {
+		t.Errorf("buckets should hold %d entries, found %d", inserted, bucketsLength)
+	}
+}
+
+func TestCuckooFilterRedisKicksKeepEntries(t *testing.T) {
+	initMockRedis()
+	// the alternate index of the sizes which aren't powers of two isn't a xor
+	for _, size := range []uint64{64, 60} {
+		filter, _ := NewCuckooFilterRedis(size, 4, 6)
+		filter.SetSeed(1)
+		var inserted []string
+		for i := uint64(0); i < size*4*9/10; i++ {
+			element := "element" + strconv.FormatUint(i, 10)
+			if _, err := filter.InsertWithStats([]byte(element), false, 0); err == nil {
+				inserted = append(inserted, element)
+			}
+		}
+		if uint64(len(inserted)) < size*3 {
+			t.Errorf("filter should hold at least %d elements with kicks, inserted %d", size*3, len(inserted))
+		}
+		for _, element := range inserted {
+			if ok, _ := filter.LookupString(element); !ok {
+				t.Errorf("%s was inserted but isn't found with size %d", element, size)
+			}
+		}
+	}
+}
+
+func TestCuckooFilterRedisLegacyAltIndex(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewCuckooFilterRedis(1000, 4, 6)
+	reopened, err := NewCuckooFilterRedisFromKey(filter.MetadataKey())
+	if err != nil || reopened.legacyAltIndex {
+		t.Errorf("reopened filter should keep the difference alternate index, error: %v", err)
+	}
+	// the filters created by the former versions have neither the alternate index nor the
+	// checksum in their metadata
+	filter.legacyAltIndex = true
+	for i := 0; i < 100; i++ {
+		filter.InsertString(strconv.Itoa(i), false)
+	}
+	getRedisClient().HDel(context.Background(), filter.MetadataKey(), "altIndex", "checksum")
+	reopened, err = NewCuckooFilterRedisFromKey(filter.MetadataKey())
+	if err != nil || !reopened.legacyAltIndex {
+		t.Fatalf("filter without alternate index in its metadata should be legacy, error: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if ok, _ := reopened.LookupString(strconv.Itoa(i)); !ok {
+			t.Errorf("%d should be found in the reopened filter", i)
+		}
+	}
+}
+
+func TestCuckooFilterRedisLengthDrift(t *testing.T) {
+	initMockRedis()
+	ctx := context.Background()
+	filter, _ := NewCuckooFilterRedis(1, 2, 3)
+	filter.InsertString("foo", false)
+	bucketKey := filter.getIndexKey(0)
+	// the length of the bucket says it's full while it holds a single entry
+	getRedisClient().Set(ctx, bucketKey+"_len", 2, 0)
+	if _, err := filter.InsertWithStats([]byte("bar"), false, 0); err != nil {
+		t.Fatalf("insert should recount the entries of the bucket, error: %v", err)
+	}
+	if length, _ := getRedisClient().Get(ctx, bucketKey+"_len").Int64(); length != 2 {
+		t.Errorf("bucket length should be fixed to 2, found %d", length)
+	}
+
+	// the entries of the bucket are lost while the lengths say they're there
+	getRedisClient().Del(ctx, bucketKey)
+	fixed, err := filter.Repair()
+	if err != nil {
+		t.Fatalf("repair shouldn't error out, error: %v", err)
+	}
+	if fixed != 1 || filter.Length() != 0 {
+		t.Errorf("repair should fix the bucket and the filter length, fixed %d, length %d", fixed, filter.Length())
+	}
+	if fixed, _ := filter.Repair(); fixed != 0 {
+		t.Errorf("repair of a consistent filter shouldn't fix anything, fixed %d", fixed)
+	}
+}
+
+func TestCuckooFilterRedisAddIfNotExists(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewCuckooFilterRedis(100, 4, 3)
+	added, err := filter.AddIfNotExists([]byte("foo"))
+	if err != nil || !added {
+		t.Errorf("foo should be added, error: %v", err)
+	}
+	added, err = filter.AddIfNotExists([]byte("foo"))
+	if err != nil || added {
+		t.Errorf("foo shouldn't be added twice, error: %v", err)
+	}
+	if ok, _ := filter.LookupString("foo"); !ok || filter.Length() != 1 {
+		t.Errorf("foo should be found once in the filter, length %d", filter.Length())
+	}
+}
+
+func TestCuckooFilterRedisLookupMulti(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewCuckooFilterRedis(100, 4, 3)
+	filter.InsertString("foo", false)
+	filter.InsertString("baz", false)
+	results, err := filter.LookupMulti([][]byte{[]byte("foo"), []byte("bar"), []byte("baz")})
+	if err != nil {
+		t.Fatalf("lookup shouldn't error out, error: %v", err)
+	}
+	if len(results) != 3 || !results[0] || results[1] || !results[2] {
+		t.Errorf("lookup of foo, bar and baz should be [true false true], found %v", results)
+	}
+}
+
+func TestCuckooFilterRedisBucketKeys(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewCuckooFilterRedis(16, 2, 3)
+	filter.InsertString("foo", false)
+	if filter.getIndexKey(7) != "cuckoo_"+filter.key+"_bucket_7" {
+		t.Errorf("bucket key should be cached at creation, got %s", filter.getIndexKey(7))
+	}
+	if allocs := testing.AllocsPerRun(100, func() { filter.getIndexKey(7) }); allocs != 0 {
+		t.Errorf("bucket keys shouldn't be built again, got %v allocations", allocs)
+	}
+	opened, _ := NewCuckooFilterRedisFromKey(filter.MetadataKey())
+	if len(opened.bucketKeys) != 16 || opened.getIndexKey(15) != filter.getIndexKey(15) {
+		t.Errorf("bucket keys should be cached when the filter is loaded")
+	}
+	data, _ := filter.Export()
+	imported, _ := NewCuckooFilterRedis(4, 2, 3)
+	if err := imported.Import(data, true); err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if len(imported.bucketKeys) != 16 || imported.getIndexKey(3) != "cuckoo_"+imported.key+"_bucket_3" {
+		t.Errorf("bucket keys should be cached again for the key and size of the import")
+	}
+	if ok, _ := imported.LookupString("foo"); !ok {
+		t.Errorf("foo should be found in the imported filter")
+	}
+}
+
+func TestCuckooFilterRedisPayloads(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewCuckooFilterRedis(64, 4, 4)
+	filter.InsertString("foo", false)
+	if value, ok, err := filter.GetString("foo"); err != nil || !ok || value != nil {
+		t.Errorf("foo should be found without a value, got %q, %v, error: %v", value, ok, err)
+	}
+	for i := 0; i < 150; i++ {
+		if err := filter.PutString(strconv.Itoa(i), []byte("v"+strconv.Itoa(i))); err != nil {
+			t.Fatalf("put of %d shouldn't error out, error: %v", i, err)
+		}
+	}
+	filter.PutString("foo", []byte("bar"))
+	filter.PutString("foo", []byte("baz"))
+	if filter.Length() != 151 {
+		t.Errorf("puts of the present elements shouldn't insert them again, length: %d", filter.Length())
+	}
+	for i := 0; i < 150; i++ {
+		if value, ok, _ := filter.GetString(strconv.Itoa(i)); !ok || string(value) != "v"+strconv.Itoa(i) {
+			t.Errorf("value of %d should be v%d, got %q, %v", i, i, value, ok)
+		}
+	}
+	if value, _, _ := filter.GetString("foo"); string(value) != "baz" {
+		t.Errorf("value of foo should be replaced by the last put, got %q", value)
+	}
+	if _, ok, _ := filter.GetString("missing"); ok {
+		t.Errorf("missing shouldn't be found")
+	}
+
+	data, _ := filter.Export()
+	imported, _ := NewCuckooFilterRedis(4, 2, 3)
+	if err := imported.Import(data, true); err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if value, _, _ := imported.GetString("42"); string(value) != "v42" {
+		t.Errorf("values should be imported, got %q", value)
+	}
+	filter.RemoveString("foo")
+	if n := getRedisClient().HLen(context.Background(), filter.payloadsKey()).Val(); n != 150 {
+		t.Errorf("value of foo should be deleted with its last copy, %d values left", n)
+	}
+	filter.Reset()
+	if value, ok, _ := filter.GetString("42"); ok || value != nil {
+		t.Errorf("values should be deleted by a reset")
+	}
+}
+
+func TestCuckooFilterRedisSweep(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewCuckooFilterRedis(64, 4, 4)
+	if _, err := filter.Sweep(time.Minute); err == nil {
+		t.Errorf("sweep without expiry should error out")
+	}
+	if err := filter.EnableExpiry(); err != nil {
+		t.Fatalf("expiry should be enabled on an empty filter, error: %v", err)
+	}
+	defer func(batch int) { cuckooSweepBatch = batch }(cuckooSweepBatch)
+	cuckooSweepBatch = 7
+
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := filter.InsertAt(now.Add(-time.Hour), []byte("old"+strconv.Itoa(i)), false); err != nil {
+			t.Fatalf("insert shouldn't error out, error: %v", err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		filter.InsertAt(now, []byte("new"+strconv.Itoa(i)), false)
+	}
+	// a duplicate inserted again recently keeps one copy after the sweep
+	filter.InsertAt(now, []byte("old7"), false)
+	filter.PutString("old3", []byte("value"))
+	loaded, err := NewCuckooFilterRedisFromKey(filter.MetadataKey())
+	if err != nil {
+		t.Fatalf("filter loading shouldn't error out, error: %v", err)
+	}
+	removed, err := loaded.SweepAt(now, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("sweep shouldn't error out, error: %v", err)
+	}
+	if removed != 100 || filter.Length() != 51 {
+		t.Errorf("sweep should remove the 100 old entries, removed %d, length %d", removed, filter.Length())
+	}
+	for i := 0; i < 50; i++ {
+		if ok, _ := filter.LookupString("new" + strconv.Itoa(i)); !ok {
+			t.Errorf("new%d should be found after the sweep", i)
+		}
+	}
+	if ok, _ := filter.LookupString("old7"); !ok {
+		t.Errorf("old7 inserted again should be found after the sweep")
+	}
+	if _, ok, _ := filter.GetString("old3"); ok {
+		t.Errorf("old3 and its payload should be swept")
+	}
+	if n := getRedisClient().ZCard(context.Background(), filter.expiryKey()).Val(); n != 51 {
+		t.Errorf("sweep should drop the insertion times of the removed entries, %d left", n)
+	}
+
+	filter.RemoveString("old7")
+	if removed, _ := filter.SweepAt(now.Add(time.Hour), time.Minute); removed != 50 || filter.Length() != 0 {
+		t.Errorf("second sweep should remove the 50 new entries, removed %d, length %d", removed, filter.Length())
+	}
+	if err := filter.Reset(); err != nil {
+		t.Fatalf("reset shouldn't error out, error: %v", err)
+	}
+	if n := getRedisClient().Exists(context.Background(), filter.expiryKey()).Val(); n != 0 {
+		t.Errorf("reset should delete the insertion times")
+	}
+	filter.InsertString("foo", false)
+	if err := filter.EnableExpiry(); err == nil {
+		t.Errorf("expiry shouldn't be enabled on a filter with entries")
+	}
+}
@@ -2,22 +2,26 @@ package gostatix
 
 import (
 	"bytes"
+	"errors"
+	"math"
 	"math/rand"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 )
 
 func TestCuckooFilterBasic(t *testing.T) {
-	filter := NewCuckooFilterWithErrorRate(20, 4, 500, 0.01)
+	filter, _ := NewCuckooFilterWithErrorRate(20, 4, 500, 0.01)
 	filter.Insert([]byte("john"), false)
 	filter.Insert([]byte("jane"), false)
 	if filter.length != 2 {
 		t.Errorf("filter length should be 2, instead found %v", filter.length)
 	}
 	bucketsLength := 0
-	for b := range filter.buckets {
-		bucketsLength += int(filter.buckets[b].getLength())
+	for b := uint64(0); b < filter.size; b++ {
+		bucketsLength += int(filter.buckets.getLength(b))
 	}
 	if bucketsLength != 2 {
 		t.Errorf("total elements insisde buckets should be 2, instead found %v", bucketsLength)
@@ -25,22 +29,22 @@ func TestCuckooFilterBasic(t *testing.T) {
 }
 
 func TestAddDifferentBuckets(t *testing.T) {
-	filter := NewCuckooFilterWithErrorRate(20, 2, 500, 0.01)
+	filter, _ := NewCuckooFilterWithErrorRate(20, 2, 500, 0.01)
 	e := []byte("foo")
 	filter.Insert(e, false)
 	filter.Insert(e, false)
 	filter.Insert(e, false)
 	filter.Insert(e, false)
 	_, fIndex, sIndex, _ := filter.getPositions(e)
-	if filter.buckets[fIndex].isFree() || filter.buckets[sIndex].isFree() {
+	if filter.buckets.isFree(fIndex) || filter.buckets.isFree(sIndex) {
 		t.Error("both buckets should be full")
 	}
 	if filter.length != 4 {
 		t.Errorf("filter length should be 4, instead found %v", filter.length)
 	}
 	bucketsLength := 0
-	for b := range filter.buckets {
-		bucketsLength += int(filter.buckets[b].getLength())
+	for b := uint64(0); b < filter.size; b++ {
+		bucketsLength += int(filter.buckets.getLength(b))
 	}
 	if bucketsLength != 4 {
 		t.Errorf("total elements insisde buckets should be 4, instead found %v", bucketsLength)
@@ -48,26 +52,25 @@ func TestAddDifferentBuckets(t *testing.T) {
 }
 
 func TestRetries(t *testing.T) {
-	filter := NewCuckooFilterWithRetries(10, 1, 3, 1)
+	filter, _ := NewCuckooFilterWithRetries(10, 1, 3, 1)
 	e := []byte("foo")
-	fingerPrint, fIndex, sIndex, _ := filter.getPositions(e)
-	filter.buckets[fIndex].add("bar")
-	filter.buckets[sIndex].add("baz")
+	fingerPrint, candidates := filter.getPackedCandidates(e)
+	filter.buckets.add(candidates.indexes[0], 123)
+	filter.buckets.add(candidates.indexes[1], 321)
 	filter.length += 2
 	ok := filter.Insert(e, false)
 	if !ok {
 		t.Errorf("%v should get added in the filter", string(e))
 	}
 	bucketsLength := 0
-	for b := range filter.buckets {
-		bucket := filter.buckets[b]
-		if bucket.getLength() > 0 {
-			elem := bucket.at(0)
-			if elem != "bar" && elem != "baz" && elem != fingerPrint {
-				t.Errorf("elem shuold be either \"bar\", \"baz\" or \"%s\", instead found %v", fingerPrint, elem)
+	for b := uint64(0); b < filter.size; b++ {
+		if filter.buckets.getLength(b) > 0 {
+			elem := filter.buckets.at(b, 0)
+			if elem != 123 && elem != 321 && elem != fingerPrint {
+				t.Errorf("elem shuold be either 123, 321 or %d, instead found %v", fingerPrint, elem)
 			}
 		}
-		bucketsLength += int(bucket.getLength())
+		bucketsLength += int(filter.buckets.getLength(b))
 	}
 	if filter.length != 3 {
 		t.Errorf("filter length should be 3, instead found %v", filter.length)
@@ -84,14 +87,14 @@ func TestFilterFull(t *testing.T) {
 		}
 	}()
 
-	filter := NewCuckooFilter(1, 1, 3)
+	filter, _ := NewCuckooFilter(1, 1, 3)
 	e := []byte("foo")
 	filter.Insert(e, false)
 	filter.Insert(e, false)
 }
 
 func TestInsertAndLookup(t *testing.T) {
-	filter := NewCuckooFilterWithErrorRate(20, 4, 500, 0.01)
+	filter, _ := NewCuckooFilterWithErrorRate(20, 4, 500, 0.01)
 	filter.Insert([]byte("alice"), false)
 	filter.Insert([]byte("andrew"), false)
 	filter.Insert([]byte("bob"), false)
@@ -122,7 +125,7 @@ func TestInsertAndLookup(t *testing.T) {
 }
 
 func TestRemovePresent(t *testing.T) {
-	filter := NewCuckooFilterWithErrorRate(20, 4, 500, 0.01)
+	filter, _ := NewCuckooFilterWithErrorRate(20, 4, 500, 0.01)
 	e1 := []byte("foo")
 	e2 := []byte("bar")
 	filter.Insert(e1, false)
@@ -138,7 +141,7 @@ func TestRemovePresent(t *testing.T) {
 }
 
 func TestRemoveNotPresent(t *testing.T) {
-	filter := NewCuckooFilterWithErrorRate(20, 4, 500, 0.01)
+	filter, _ := NewCuckooFilterWithErrorRate(20, 4, 500, 0.01)
 	e1 := []byte("foo")
 	filter.Insert(e1, false)
 	ok := filter.Remove([]byte("bar"))
@@ -148,7 +151,7 @@ func TestRemoveNotPresent(t *testing.T) {
 }
 
 func TestRollbackWhenFull(t *testing.T) {
-	filter := NewCuckooFilter(5, 1, 3)
+	filter, _ := NewCuckooFilter(5, 1, 3)
 	ok := filter.Insert([]byte("one"), false)
 	if !ok {
 		t.Error("should insert one")
@@ -165,9 +168,9 @@ func TestRollbackWhenFull(t *testing.T) {
 	if !ok {
 		t.Error("should insert four")
 	}
-	ok = filter.Insert([]byte("five"), false)
+	ok = filter.Insert([]byte("zero"), false)
 	if !ok {
-		t.Error("should insert five")
+		t.Error("should insert zero")
 	}
 	snapshot1, _ := filter.Export()
 
@@ -188,7 +191,7 @@ func TestRollbackWhenFull(t *testing.T) {
 	}
 }
 func TestNoRollbackWhenFull(t *testing.T) {
-	filter := NewCuckooFilter(5, 1, 3)
+	filter, _ := NewCuckooFilter(5, 1, 3)
 	ok := filter.Insert([]byte("one"), false)
 	if !ok {
 		t.Error("should insert one")
@@ -205,9 +208,9 @@ func TestNoRollbackWhenFull(t *testing.T) {
 	if !ok {
 		t.Error("should insert four")
 	}
-	ok = filter.Insert([]byte("five"), false)
+	ok = filter.Insert([]byte("zero"), false)
 	if !ok {
-		t.Error("should insert five")
+		t.Error("should insert zero")
 	}
 	snapshot1, _ := filter.Export()
 
@@ -239,27 +242,27 @@ func TestCuckooImportInvalidJSON(t *testing.T) {
 }
 
 func TestCuckooEquals(t *testing.T) {
-	filter1 := NewCuckooFilter(5, 1, 3)
+	filter1, _ := NewCuckooFilter(5, 1, 3)
 	filter1.Insert([]byte("one"), false)
 	filter1.Insert([]byte("two"), false)
 	filter1.Insert([]byte("three"), false)
-	filter2 := NewCuckooFilter(5, 1, 3)
+	filter2, _ := NewCuckooFilter(5, 1, 3)
 	filter2.Insert([]byte("one"), false)
 	filter2.Insert([]byte("two"), false)
 	filter2.Insert([]byte("three"), false)
-	if !filter1.Equals(filter2) {
+	if !equal(filter1.Equals(filter2)) {
 		t.Error("filter1 and filter2 should be same")
 	}
 }
 
 func TestCuckooMarshalUnmarshal(t *testing.T) {
-	filter1 := NewCuckooFilter(5, 1, 3)
+	filter1, _ := NewCuckooFilter(5, 1, 3)
 	filter1.Insert([]byte("one"), false)
 	filter1.Insert([]byte("two"), false)
 	filter1.Insert([]byte("three"), false)
 	filter1.Insert([]byte("four"), false)
 	snapshot1, _ := filter1.Export()
-	filter2 := NewCuckooFilter(5, 1, 3)
+	filter2, _ := NewCuckooFilter(5, 1, 3)
 	filter2.Insert([]byte("one"), false)
 	filter2.Insert([]byte("two"), false)
 	filter2.Insert([]byte("three"), false)
@@ -268,7 +271,7 @@ func TestCuckooMarshalUnmarshal(t *testing.T) {
 	if !reflect.DeepEqual(snapshot1, snapshot2) {
 		t.Error("snapshot1 and snapshot2 should be equal")
 	}
-	filter3 := NewCuckooFilter(0, 0, 0)
+	filter3, _ := NewCuckooFilter(1, 1, 1)
 	filter3.Import(snapshot1)
 	ok := filter3.Lookup([]byte("one"))
 	if !ok {
@@ -278,13 +281,13 @@ func TestCuckooMarshalUnmarshal(t *testing.T) {
 	if ok {
 		t.Error("\"five\" should not be in filter3")
 	}
-	if !filter1.Equals(filter3) || !filter2.Equals(filter3) {
+	if !equal(filter1.Equals(filter3)) || !equal(filter2.Equals(filter3)) {
 		t.Errorf("filter1, filter2 and filter3 are same")
 	}
 }
 
 func TestCuckooBinaryReadWrite(t *testing.T) {
-	filter1 := NewCuckooFilter(5, 1, 3)
+	filter1, _ := NewCuckooFilter(5, 1, 3)
 	filter1.Insert([]byte("one"), false)
 	filter1.Insert([]byte("two"), false)
 	filter1.Insert([]byte("three"), false)
@@ -296,13 +299,13 @@ func TestCuckooBinaryReadWrite(t *testing.T) {
 		t.Error("should not error out in writing to buffer")
 	}
 
-	filter2 := NewCuckooFilter(0, 0, 0)
+	filter2, _ := NewCuckooFilter(1, 1, 1)
 	_, err = filter2.ReadFrom(&buff)
 	if err != nil {
 		t.Error("should not error out in reading from buffer")
 	}
 
-	if !filter1.Equals(filter2) {
+	if !equal(filter1.Equals(filter2)) {
 		t.Errorf("filter1 and filter2 should be same")
 	}
 
@@ -319,7 +322,7 @@ func TestCuckooBinaryReadWrite(t *testing.T) {
 
 func BenchmarkCuckooInsert10MX4X500X001(b *testing.B) {
 	b.StopTimer()
-	filter := NewCuckooFilterWithErrorRate(10*1000*1000, 4, 500, 0.001)
+	filter, _ := NewCuckooFilterWithErrorRate(10*1000*1000, 4, 500, 0.001)
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
 		filter.Insert([]byte(strconv.FormatUint(rand.Uint64(), 10)), true)
@@ -328,7 +331,7 @@ func BenchmarkCuckooInsert10MX4X500X001(b *testing.B) {
 
 func BenchmarkCuckooLookup10MX4X500X001(b *testing.B) {
 	b.StopTimer()
-	filter := NewCuckooFilterWithErrorRate(10*1000*1000, 4, 500, 0.001)
+	filter, _ := NewCuckooFilterWithErrorRate(10*1000*1000, 4, 500, 0.001)
 	for i := 0; i < 1000000; i++ {
 		filter.Insert([]byte(strconv.FormatUint(rand.Uint64(), 10)), true)
 	}
@@ -338,9 +341,22 @@ func BenchmarkCuckooLookup10MX4X500X001(b *testing.B) {
 	}
 }
 
+func BenchmarkCuckooLookupParallel1MX4X500X001(b *testing.B) {
+	filter, _ := NewCuckooFilterWithErrorRate(1000*1000, 4, 500, 0.001)
+	for i := 0; i < 100000; i++ {
+		filter.Insert([]byte(strconv.FormatUint(rand.Uint64(), 10)), true)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			filter.Lookup([]byte(strconv.FormatUint(rand.Uint64(), 10)))
+		}
+	})
+}
+
 func BenchmarkCuckooLookup1BX16X1kX001X5M(b *testing.B) {
 	b.StopTimer()
-	filter := NewCuckooFilterWithErrorRate(1000*1000*1000, 4, 500, 0.001)
+	filter, _ := NewCuckooFilterWithErrorRate(1000*1000*1000, 4, 500, 0.001)
 	for i := 0; i < 1000000; i++ {
 		filter.Insert([]byte(strconv.FormatUint(rand.Uint64(), 10)), true)
 	}
@@ -349,3 +365,496 @@ func BenchmarkCuckooLookup1BX16X1kX001X5M(b *testing.B) {
 		filter.Lookup([]byte(strconv.FormatUint(rand.Uint64(), 10)))
 	}
 }
+
+func TestCuckooInsertFromReader(t *testing.T) {
+	filter, _ := NewCuckooFilterWithErrorRate(20, 4, 500, 0.01)
+	count, err := filter.InsertFromReader(strings.NewReader("john,jane,"), ',', nil)
+	if err != nil {
+		t.Fatalf("insert from reader shouldn't error out, error: %v", err)
+	}
+	if count != 2 || filter.Length() != 2 {
+		t.Errorf("2 keys should be inserted, found count %d and length %d", count, filter.Length())
+	}
+	if !filter.Lookup([]byte("john")) || !filter.Lookup([]byte("jane")) {
+		t.Error("john and jane should be present in the filter")
+	}
+}
+
+func TestCuckooInsertFromReaderFull(t *testing.T) {
+	filter, _ := NewCuckooFilter(1, 1, 3)
+	count, err := filter.InsertFromReader(strings.NewReader("foo\nfoo\nfoo"), '\n', nil)
+	if err == nil {
+		t.Error("should error out as the filter is full")
+	}
+	if count != 1 {
+		t.Errorf("only 1 key should be inserted, found %d", count)
+	}
+}
+
+func TestCuckooFingerPrintPositions(t *testing.T) {
+	filter, _ := NewCuckooFilter(1000, 4, 7)
+	legacy, _ := NewCuckooFilter(1000, 4, 7)
+	legacy.legacyAltIndex = true
+	for i := 0; i < 1000; i++ {
+		data := []byte(strconv.Itoa(i))
+		// the fingerprint and the indices should stay the ones of the string based fingerprints
+		hash := getHash(data)
+		hashString := strconv.FormatUint(hash, 10)
+		fingerPrint := hashString[:filter.fingerPrintLength]
+		firstIndex := hash % filter.size
+		secondIndex := (firstIndex ^ getHash([]byte(fingerPrint))) % filter.size
+		packed, fIndex, sIndex, err := legacy.getFingerPrintPositions(data)
+		if err != nil || strconv.FormatUint(packed, 10) != fingerPrint || fIndex != firstIndex || sIndex != secondIndex {
+			t.Fatalf("positions of %s should be %s, %d, %d, got %d, %d, %d", data, fingerPrint, firstIndex, secondIndex, packed, fIndex, sIndex)
+		}
+		secondIndex = (getHash([]byte(fingerPrint))%filter.size + filter.size - firstIndex) % filter.size
+		packed, fIndex, sIndex, err = filter.getFingerPrintPositions(data)
+		if err != nil || strconv.FormatUint(packed, 10) != fingerPrint || fIndex != firstIndex || sIndex != secondIndex {
+			t.Fatalf("positions of %s should be %s, %d, %d, got %d, %d, %d", data, fingerPrint, firstIndex, secondIndex, packed, fIndex, sIndex)
+		}
+	}
+}
+
+func TestCuckooFilterAllocations(t *testing.T) {
+	filter, _ := NewCuckooFilter(1000, 4, 7)
+	data := []byte("foo")
+	allocs := testing.AllocsPerRun(100, func() {
+		filter.Insert(data, false)
+		filter.Lookup(data)
+		filter.Remove(data)
+	})
+	if allocs != 0 {
+		t.Errorf("insert, lookup and remove shouldn't allocate, got %v allocations", allocs)
+	}
+}
+
+func TestCuckooFilterInsertWithStats(t *testing.T) {
+	filter, _ := NewCuckooFilter(1, 1, 3)
+	stats, err := filter.InsertWithStats([]byte("foo"), false, 0)
+	if err != nil || stats.Kicks != 0 || stats.RolledBack {
+		t.Errorf("insert in a free bucket shouldn't kick entries, stats: %+v, error: %v", stats, err)
+	}
+	stats, err = filter.InsertWithStats([]byte("bar"), false, 7)
+	if !errors.Is(err, ErrCuckooFilterFull) {
+		t.Errorf("insert in a full filter should error out with ErrCuckooFilterFull, error: %v", err)
+	}
+	if stats.Kicks != 7 || !stats.RolledBack {
+		t.Errorf("insert should kick 7 entries and roll back, stats: %+v", stats)
+	}
+	if !filter.LookupString("foo") || filter.Length() != 1 {
+		t.Error("foo should be restored in the filter after the roll back")
+	}
+	stats, err = filter.InsertWithStats([]byte("bar"), true, 0)
+	if err == nil || stats.Kicks != filter.Retries() || stats.RolledBack {
+		t.Errorf("destructive insert should kick %d entries without roll back, stats: %+v", filter.Retries(), stats)
+	}
+}
+
+func TestCuckooFilterReset(t *testing.T) {
+	filter, _ := NewCuckooFilter(10, 2, 3)
+	filter.InsertString("alice", false)
+	filter.InsertString("bob", false)
+	filter.Reset()
+	if filter.Length() != 0 || filter.LookupString("alice") || filter.LookupString("bob") {
+		t.Error("filter should be empty after reset")
+	}
+	filter.InsertString("carol", false)
+	if !filter.LookupString("carol") || filter.Length() != 1 {
+		t.Error("carol should be inserted after reset")
+	}
+}
+
+func TestCuckooFilterEstimatedPositiveRate(t *testing.T) {
+	filter, _ := NewCuckooFilter(2000, 4, 3)
+	if rate := filter.EstimatedPositiveRate(); rate != 0 {
+		t.Errorf("estimated positive rate of an empty filter should be 0, got %f", rate)
+	}
+	for i := 0; i < 6000; i++ {
+		filter.InsertWithStats([]byte("in"+strconv.Itoa(i)), false, 0)
+	}
+	var negatives strings.Builder
+	for i := 0; i < 100000; i++ {
+		negatives.WriteString("out" + strconv.Itoa(i) + "\n")
+	}
+	report, _ := filter.MeasureFalsePositiveRate(strings.NewReader(negatives.String()), '\n')
+	if math.Abs(report.Divergence) > 4 {
+		t.Errorf("observed rate %f should be close to the estimated rate %f", report.Observed, report.Expected)
+	}
+	if full := filter.CuckooPositiveRate(); full < report.Expected || full > 1 {
+		t.Errorf("rate of a full filter %f should bound the estimated rate %f", full, report.Expected)
+	}
+}
+
+func TestCuckooFilterSafeRemove(t *testing.T) {
+	filter, _ := NewCuckooFilter(1000, 4, 2)
+	if err := filter.EnableSafeRemove(); err != nil {
+		t.Fatalf("enabling safe removes shouldn't error out, error: %v", err)
+	}
+	filter.InsertString("foo", false)
+	filter.InsertString("foo", false)
+	collision := ""
+	for i := 0; collision == ""; i++ {
+		if candidate := strconv.Itoa(i); filter.LookupString(candidate) {
+			collision = candidate
+		}
+	}
+	if filter.RemoveString(collision) {
+		t.Errorf("%s was never inserted and shouldn't be removed", collision)
+	}
+	if !filter.RemoveString("foo") || !filter.RemoveString("foo") {
+		t.Errorf("foo was inserted twice and should be removed twice")
+	}
+	if filter.RemoveString("foo") || filter.Length() != 0 {
+		t.Errorf("foo shouldn't be removed a third time")
+	}
+	other, _ := NewCuckooFilter(1000, 4, 2)
+	other.InsertString("bar", false)
+	if err := other.EnableSafeRemove(); err == nil {
+		t.Errorf("enabling safe removes on a filter with entries should error out")
+	}
+}
+
+func TestCuckooFilterAddIfNotExists(t *testing.T) {
+	filter, _ := NewCuckooFilter(100, 4, 3)
+	added, err := filter.AddIfNotExists([]byte("foo"))
+	if err != nil || !added {
+		t.Errorf("foo should be added, error: %v", err)
+	}
+	added, err = filter.AddIfNotExists([]byte("foo"))
+	if err != nil || added {
+		t.Errorf("foo shouldn't be added twice, error: %v", err)
+	}
+	if filter.Length() != 1 {
+		t.Errorf("filter length should be 1, found %d", filter.Length())
+	}
+}
+
+func TestCuckooFilterLookupMulti(t *testing.T) {
+	filter, _ := NewCuckooFilter(100, 4, 3)
+	filter.InsertString("foo", false)
+	filter.InsertString("baz", false)
+	results := filter.LookupMulti([][]byte{[]byte("foo"), []byte("bar"), []byte("baz")})
+	if len(results) != 3 || !results[0] || results[1] || !results[2] {
+		t.Errorf("lookup of foo, bar and baz should be [true false true], found %v", results)
+	}
+}
+
+func TestCuckooFilterConcurrentExport(t *testing.T) {
+	filter, _ := NewCuckooFilterWithErrorRate(1000, 4, 500, 0.01)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			filter.InsertString(strconv.Itoa(i), false)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			data, _ := filter.Export()
+			other, _ := NewCuckooFilterWithErrorRate(1000, 4, 500, 0.01)
+			err := other.Import(data)
+			if err != nil {
+				t.Errorf("importing a concurrent export shouldn't error out, error: %v", err)
+			}
+		}
+	}()
+	wg.Wait()
+	if filter.Length() != 500 {
+		t.Errorf("length should be 500, got %d", filter.Length())
+	}
+}
+
+func TestCuckooFilterKicksKeepEntries(t *testing.T) {
+	// the alternate index of the sizes which aren't powers of two isn't a xor
+	for _, size := range []uint64{256, 239} {
+		filter, _ := NewCuckooFilter(size, 4, 6)
+		inserted, kicks := []string{}, uint64(0)
+		for i := uint64(0); i < size*4*9/10; i++ {
+			stats, err := filter.InsertWithStats([]byte(strconv.FormatUint(i, 10)), false, 0)
+			if err == nil {
+				inserted = append(inserted, strconv.FormatUint(i, 10))
+			}
+			kicks += stats.Kicks
+		}
+		if kicks == 0 {
+			t.Fatalf("inserts should kick entries out of their buckets")
+		}
+		for _, data := range inserted {
+			if !filter.LookupString(data) {
+				t.Errorf("%s should be found after the kicks with size %d", data, size)
+			}
+		}
+		if filter.Length() != uint64(len(inserted)) {
+			t.Errorf("filter length should be %d, found %d", len(inserted), filter.Length())
+		}
+	}
+}
+
+func TestCuckooFilterKickPosition(t *testing.T) {
+	filter, _ := NewCuckooFilter(10, 4, 3)
+	if _, err := filter.kickPosition(0); err == nil {
+		t.Errorf("kick out of an empty bucket should error out")
+	}
+	if position, err := filter.kickPosition(1); err != nil || position != 0 {
+		t.Errorf("kick out of a bucket of length 1 should be at position 0, got %d, error: %v", position, err)
+	}
+	seen := make(map[uint64]bool)
+	for i := 0; i < 1000; i++ {
+		position, _ := filter.kickPosition(4)
+		if position >= 4 {
+			t.Fatalf("kick position %d should be in the bucket of length 4", position)
+		}
+		seen[position] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("kicks should draw every position of the bucket, got %v", seen)
+	}
+}
+
+func TestCuckooFilterMultiset(t *testing.T) {
+	filter, _ := NewCuckooFilter(64, 4, 4)
+	filter.EnableMultiset()
+	if !filter.Multiset() {
+		t.Errorf("multiset inserts should be enabled")
+	}
+	_, candidates := filter.getPackedCandidates([]byte("foo"))
+	capacity := uint64(4 * candidates.n)
+	for i := uint64(0); i < capacity; i++ {
+		if _, err := filter.InsertWithStats([]byte("foo"), false, 0); err != nil {
+			t.Fatalf("copy %d of foo should be inserted, error: %v", i, err)
+		}
+	}
+	stats, err := filter.InsertWithStats([]byte("foo"), false, 0)
+	if err == nil || stats.Kicks != 0 {
+		t.Errorf("insert should fail fast once the buckets are full of copies, kicks: %d, error: %v", stats.Kicks, err)
+	}
+	for i := 0; i < 100; i++ {
+		filter.InsertWithStats([]byte(strconv.Itoa(i)), false, 0)
+	}
+	if filter.CountString("foo") != capacity {
+		t.Errorf("foo should have %d copies, got %d", capacity, filter.CountString("foo"))
+	}
+	for i := capacity; i > 0; i-- {
+		if !filter.RemoveString("foo") {
+			t.Fatalf("copy %d of foo should be removed", i)
+		}
+		if filter.CountString("foo") != i-1 {
+			t.Errorf("foo should have %d copies after a remove, got %d", i-1, filter.CountString("foo"))
+		}
+	}
+	if filter.RemoveString("foo") || filter.LookupString("foo") {
+		t.Errorf("foo shouldn't be found once all its copies are removed")
+	}
+
+	filter.InsertString("bar", false)
+	data, _ := filter.Export()
+	imported, _ := NewCuckooFilter(1, 1, 1)
+	if err := imported.Import(data); err != nil || !imported.Multiset() {
+		t.Errorf("multiset inserts should be imported, error: %v", err)
+	}
+}
+
+func TestCuckooFilterPayloads(t *testing.T) {
+	filter, _ := NewCuckooFilter(64, 4, 4)
+	filter.InsertString("foo", false)
+	if value, ok := filter.GetString("foo"); !ok || value != nil {
+		t.Errorf("foo should be found without a value, got %q, %v", value, ok)
+	}
+	for i := 0; i < 150; i++ {
+		if err := filter.PutString(strconv.Itoa(i), []byte("v"+strconv.Itoa(i))); err != nil {
+			t.Fatalf("put of %d shouldn't error out, error: %v", i, err)
+		}
+	}
+	filter.PutString("foo", []byte("bar"))
+	filter.PutString("foo", []byte("baz"))
+	if filter.Length() != 151 {
+		t.Errorf("puts of the present elements shouldn't insert them again, length: %d", filter.Length())
+	}
+	// the kicks of the inserts move the entries without their values
+	for i := 0; i < 150; i++ {
+		if value, ok := filter.GetString(strconv.Itoa(i)); !ok || string(value) != "v"+strconv.Itoa(i) {
+			t.Errorf("value of %d should be v%d, got %q, %v", i, i, value, ok)
+		}
+	}
+	if value, _ := filter.GetString("foo"); string(value) != "baz" {
+		t.Errorf("value of foo should be replaced by the last put, got %q", value)
+	}
+	if _, ok := filter.GetString("missing"); ok {
+		t.Errorf("missing shouldn't be found")
+	}
+
+	data, _ := filter.Export()
+	imported, _ := NewCuckooFilter(1, 1, 1)
+	if err := imported.Import(data); err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if value, _ := imported.GetString("42"); string(value) != "v42" {
+		t.Errorf("values should be imported, got %q", value)
+	}
+	filter.RemoveString("foo")
+	if len(filter.payloads) != 150 {
+		t.Errorf("value of foo should be deleted with its last copy, %d values left", len(filter.payloads))
+	}
+	filter.Reset()
+	if value, ok := filter.GetString("42"); ok || value != nil {
+		t.Errorf("values should be deleted by a reset")
+	}
+	invalid := []byte(`{"s":1,"bs":1,"fpl":1,"l":0,"r":1,"b":[{"s":1,"l":0,"e":[]}],"p":[{"f":1,"i":[0,3],"v":""}]}`)
+	if err := imported.Import(invalid); err == nil {
+		t.Errorf("import of a payload out of the buckets should error out")
+	}
+}
+
+func TestCuckooFilterCandidates(t *testing.T) {
+	for _, c := range []struct{ size, candidates uint64 }{{64, 1}, {64, 5}, {10, 3}, {32, 4}} {
+		if _, err := NewCuckooFilterWithCandidates(c.size, 1, 6, 500, c.candidates); err == nil {
+			t.Errorf("%d candidates of %d buckets should error out", c.candidates, c.size)
+		}
+	}
+	filter, err := NewCuckooFilterWithCandidates(81, 1, 6, 500, 3)
+	if err != nil || filter.Candidates() != 3 {
+		t.Fatalf("filter of 3 candidates should be created, error: %v", err)
+	}
+	// the candidates are the same from every candidate bucket of a fingerprint
+	for fingerPrint := uint64(100000); fingerPrint < 100100; fingerPrint++ {
+		candidates := filter.getCandidates(fingerPrint%81, fingerPrint)
+		for _, index := range candidates.list() {
+			other := filter.getCandidates(index, fingerPrint)
+			if candidates.entry(fingerPrint) != other.entry(fingerPrint) || candidates.n != other.n {
+				t.Fatalf("candidates of %d from %d should be %v, got %v", fingerPrint, index, candidates.list(), other.list())
+			}
+		}
+	}
+
+	// the load reached before the first failed insert grows with the candidates
+	loads := make(map[uint64]uint64)
+	for _, candidates := range []uint64{2, 4} {
+		filter, _ := NewCuckooFilterWithCandidates(256, 1, 6, 500, candidates)
+		for i := 0; ; i++ {
+			if _, err := filter.InsertWithStats([]byte(strconv.Itoa(i)), false, 0); err != nil {
+				break
+			}
+		}
+		loads[candidates] = filter.Length()
+		for i := uint64(0); i < filter.Length(); i++ {
+			if !filter.LookupString(strconv.FormatUint(i, 10)) {
+				t.Fatalf("%d should be found in the filter of %d candidates", i, candidates)
+			}
+		}
+	}
+	if loads[4] < 230 || loads[4] <= loads[2] {
+		t.Errorf("filter of 4 candidates should fill up more than the one of 2, loads: %v", loads)
+	}
+
+	filter, _ = NewCuckooFilterWithCandidates(64, 2, 6, 500, 4)
+	for i := 0; i < 100; i++ {
+		filter.PutString(strconv.Itoa(i), []byte("v"+strconv.Itoa(i)))
+	}
+	data, _ := filter.Export()
+	imported, _ := NewCuckooFilter(1, 1, 1)
+	if err := imported.Import(data); err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if equal, _ := filter.Equals(imported); !equal || imported.Candidates() != 4 {
+		t.Errorf("imported filter should equal the exported one with its candidates")
+	}
+	if value, _ := imported.GetString("42"); string(value) != "v42" {
+		t.Errorf("values should be found in the candidate buckets, got %q", value)
+	}
+	twoBuckets, _ := NewCuckooFilterWithRetries(64, 2, 6, 500)
+	if comparison, _ := filter.Compare(twoBuckets); comparison.Equal() {
+		t.Errorf("filters of different candidates shouldn't be equal")
+	}
+	for i := 0; i < 100; i++ {
+		if !imported.RemoveString(strconv.Itoa(i)) {
+			t.Errorf("%d should be removed", i)
+		}
+	}
+	if imported.Length() != 0 {
+		t.Errorf("filter should be empty, length: %d", imported.Length())
+	}
+	var buf bytes.Buffer
+	filter.WriteTo(&buf)
+	if _, err := twoBuckets.ReadFrom(&buf); err != nil {
+		t.Errorf("read of the buckets shouldn't error out, error: %v", err)
+	}
+	invalid := []byte(`{"s":10,"bs":1,"fpl":1,"l":0,"r":1,"b":[{"s":1,"l":0,"e":[]},{"s":1,"l":0,"e":[]},{"s":1,"l":0,"e":[]},{"s":1,"l":0,"e":[]},{"s":1,"l":0,"e":[]},{"s":1,"l":0,"e":[]},{"s":1,"l":0,"e":[]},{"s":1,"l":0,"e":[]},{"s":1,"l":0,"e":[]},{"s":1,"l":0,"e":[]}],"c":4}`)
+	if err := imported.Import(invalid); err == nil {
+		t.Errorf("import of candidates which don't cycle over the buckets should error out")
+	}
+}
+
+func TestCuckooFilterSeed(t *testing.T) {
+	initMockRedis()
+	filters := make([]*CuckooFilter, 2)
+	redisFilters := make([]*CuckooFilterRedis, 2)
+	kicks := uint64(0)
+	for i := range filters {
+		filters[i], _ = NewCuckooFilterWithCandidates(64, 1, 4, 500, 4)
+		filters[i].SetSeed(42)
+		redisFilters[i], _ = NewCuckooFilterRedis(32, 2, 4)
+		redisFilters[i].SetSeed(42)
+		for j := 0; j < 56; j++ {
+			stats, _ := filters[i].InsertWithStats([]byte(strconv.Itoa(j)), false, 0)
+			redisStats, _ := redisFilters[i].InsertWithStats([]byte(strconv.Itoa(j)), false, 0)
+			kicks += stats.Kicks + redisStats.Kicks
+		}
+	}
+	if kicks == 0 {
+		t.Fatalf("filters under high load should kick entries")
+	}
+	if equal, _ := filters[0].Equals(filters[1]); !equal {
+		t.Errorf("filters seeded alike should kick the same entries")
+	}
+	if equal, _ := redisFilters[0].Equals(redisFilters[1]); !equal {
+		t.Errorf("redis filters seeded alike should kick the same entries")
+	}
+}
+
+func TestCuckooAltIndexInvolution(t *testing.T) {
+	for _, size := range []uint64{1, 7, 64, 239, 1000} {
+		filter, _ := NewCuckooFilter(size, 4, 6)
+		for i := uint64(0); i < 1000; i++ {
+			fingerPrint := 100000 + i*7
+			index := i % size
+			if back := filter.getAltIndex(filter.getAltIndex(index, fingerPrint), fingerPrint); back != index {
+				t.Fatalf("alternate index of %d from %d with size %d should lead back to %d, got %d", fingerPrint, index, size, index, back)
+			}
+		}
+	}
+}
+
+func TestCuckooFilterLegacyAltIndex(t *testing.T) {
+	legacy, _ := NewCuckooFilter(1000, 4, 6)
+	legacy.legacyAltIndex = true
+	filter, _ := NewCuckooFilter(1000, 4, 6)
+	for i := 0; i < 100; i++ {
+		legacy.InsertString(strconv.Itoa(i), false)
+		filter.InsertString(strconv.Itoa(i), false)
+	}
+	for _, f := range []*CuckooFilter{legacy, filter} {
+		data, _ := f.Export()
+		imported, _ := NewCuckooFilter(10, 4, 6)
+		if err := imported.Import(data); err != nil {
+			t.Fatalf("import shouldn't error out, error: %v", err)
+		}
+		var buf bytes.Buffer
+		f.WriteTo(&buf)
+		read, _ := NewCuckooFilter(10, 4, 6)
+		if _, err := read.ReadFrom(&buf); err != nil {
+			t.Fatalf("read shouldn't error out, error: %v", err)
+		}
+		for _, g := range []*CuckooFilter{imported, read} {
+			if g.legacyAltIndex != f.legacyAltIndex {
+				t.Errorf("legacy alternate index should be %v, got %v", f.legacyAltIndex, g.legacyAltIndex)
+			}
+			for i := 0; i < 100; i++ {
+				if !g.LookupString(strconv.Itoa(i)) {
+					t.Errorf("%d should be found in the restored filter", i)
+				}
+			}
+		}
+	}
+}
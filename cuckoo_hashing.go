@@ -0,0 +1,180 @@
+package gostatix
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/dgryski/go-metro"
+)
+
+// CuckooHashing derives the fingerprints and the bucket indexes of the elements of a
+// cuckoo filter. The first bucket of an element is its hash modulo the number of buckets,
+// and the other bucket of a fingerprint held by the bucket at index i is
+// (i ^ Hash(fingerprint)) % size, the fingerprint being hashed as its decimal string, so
+// that fingerprints can be moved between their buckets without their element. A hashing
+// can hash the fingerprints differently by implementing FingerPrintHash(fingerPrint uint64) uint64.
+//
+// The hashing of a filter is saved by name along with it, so a custom hashing has to be
+// registered with RegisterCuckooHashing before loading the filters using it. A hashing can
+// also implement CollisionRate(fingerPrintLength uint64) float64, the probability of the
+// fingerprints of two elements being equal, from which the positive rates of the filters
+// are estimated. The rate of PrefixFingerPrint is assumed otherwise.
+type CuckooHashing interface {
+	// Name identifies the hashing
+	Name() string
+	// Hash returns the 64-bit hash of _data_
+	Hash(data []byte) uint64
+	// FingerPrint returns the fingerprint of _fingerPrintLength_ decimal digits of the
+	// element of hash _hash_, between 10^(fingerPrintLength-1) and 10^fingerPrintLength - 1
+	FingerPrint(hash, fingerPrintLength uint64) (uint64, error)
+}
+
+// FingerPrintEncoding is the way the built-in hashings derive the fingerprint of an
+// element from its hash. Custom hashings can derive their fingerprints with it too.
+type FingerPrintEncoding uint8
+
+const (
+	// PrefixFingerPrint takes the first digits of the decimal representation of the hash.
+	// The fingerprints starting with 1 are more likely than the others, as the hashes of
+	// 20 digits all start with 1, and the hashes with fewer digits than the fingerprint
+	// can't be inserted.
+	PrefixFingerPrint FingerPrintEncoding = iota
+	// UniformFingerPrint maps the hash uniformly to the fingerprints of the length of the
+	// filter, so that all of them are equally likely
+	UniformFingerPrint
+)
+
+// cuckooHashing is a built-in CuckooHashing hashing the elements with murmur3, or metro
+// Hash64 if _metro_ is set, and deriving their fingerprints with _encoding_
+type cuckooHashing struct {
+	name     string
+	metro    bool
+	encoding FingerPrintEncoding
+}
+
+var (
+	// MurmurCuckooHashing hashes the elements with murmur3 and takes the decimal prefixes of
+	// the hashes as fingerprints. It's the default hashing of the cuckoo filters.
+	MurmurCuckooHashing CuckooHashing = &cuckooHashing{"murmur", false, PrefixFingerPrint}
+	// MetroCuckooHashing hashes the elements with metro Hash64 and takes the decimal prefixes
+	// of the hashes as fingerprints
+	MetroCuckooHashing CuckooHashing = &cuckooHashing{"metro", true, PrefixFingerPrint}
+	// UniformMurmurCuckooHashing hashes the elements with murmur3 and maps the hashes to
+	// uniform fingerprints
+	UniformMurmurCuckooHashing CuckooHashing = &cuckooHashing{"murmur-uniform", false, UniformFingerPrint}
+	// UniformMetroCuckooHashing hashes the elements with metro Hash64 and maps the hashes to
+	// uniform fingerprints
+	UniformMetroCuckooHashing CuckooHashing = &cuckooHashing{"metro-uniform", true, UniformFingerPrint}
+	// RedisBloomCuckooHashing hashes the elements and derives their buckets like the cuckoo
+	// filters of RedisBloom, see NewCuckooFilterForRedisBloom
+	RedisBloomCuckooHashing CuckooHashing = redisBloomCuckooHashing{}
+)
+
+// cuckooHashings holds the hashings which the cuckoo filters can be loaded with, by name
+var cuckooHashings = struct {
+	byName map[string]CuckooHashing
+	lock   sync.RWMutex
+}{byName: map[string]CuckooHashing{
+	MurmurCuckooHashing.Name():        MurmurCuckooHashing,
+	MetroCuckooHashing.Name():         MetroCuckooHashing,
+	UniformMurmurCuckooHashing.Name(): UniformMurmurCuckooHashing,
+	UniformMetroCuckooHashing.Name():  UniformMetroCuckooHashing,
+	RedisBloomCuckooHashing.Name():    RedisBloomCuckooHashing,
+}}
+
+// RegisterCuckooHashing registers _hashing_ by its name, so that the cuckoo filters using
+// it can be loaded from their snapshots and metadata. It errors out if another hashing is
+// registered with the same name.
+func RegisterCuckooHashing(hashing CuckooHashing) error {
+	if hashing == nil || hashing.Name() == "" {
+		return fmt.Errorf("gostatix: cuckoo hashing should have a name")
+	}
+	cuckooHashings.lock.Lock()
+	defer cuckooHashings.lock.Unlock()
+
+	if registered, ok := cuckooHashings.byName[hashing.Name()]; ok && registered != hashing {
+		return fmt.Errorf("gostatix: another cuckoo hashing is registered as %s", hashing.Name())
+	}
+	cuckooHashings.byName[hashing.Name()] = hashing
+	return nil
+}
+
+// lookupCuckooHashing returns the hashing registered as _name_, the default hashing if
+// _name_ is empty
+func lookupCuckooHashing(name string) (CuckooHashing, error) {
+	if name == "" {
+		return MurmurCuckooHashing, nil
+	}
+	cuckooHashings.lock.RLock()
+	defer cuckooHashings.lock.RUnlock()
+
+	hashing, ok := cuckooHashings.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("gostatix: unknown cuckoo hashing %s, it should be registered with RegisterCuckooHashing", name)
+	}
+	return hashing, nil
+}
+
+// Name returns the name of the hashing
+func (hashing *cuckooHashing) Name() string {
+	return hashing.name
+}
+
+// Hash returns the hash of _data_
+func (hashing *cuckooHashing) Hash(data []byte) uint64 {
+	if hashing.metro {
+		return getMetroHash(data)
+	}
+	return getHash(data)
+}
+
+// FingerPrint returns the fingerprint of _fingerPrintLength_ digits derived from _hash_
+func (hashing *cuckooHashing) FingerPrint(hash, fingerPrintLength uint64) (uint64, error) {
+	return hashing.encoding.FingerPrint(hash, fingerPrintLength)
+}
+
+// CollisionRate returns the probability of the fingerprints of _fingerPrintLength_ digits
+// of two elements being equal
+func (hashing *cuckooHashing) CollisionRate(fingerPrintLength uint64) float64 {
+	return hashing.encoding.CollisionRate(fingerPrintLength)
+}
+
+// FingerPrint returns the fingerprint of _fingerPrintLength_ digits, between 1 and 20,
+// derived from _hash_ with the encoding
+func (encoding FingerPrintEncoding) FingerPrint(hash, fingerPrintLength uint64) (uint64, error) {
+	if fingerPrintLength == 0 || fingerPrintLength > maxFingerPrintLength {
+		return 0, fmt.Errorf("gostatix: cuckoo filter fingerprint length %d should be between 1 and %d", fingerPrintLength, maxFingerPrintLength)
+	}
+	if encoding == UniformFingerPrint {
+		return pow10[fingerPrintLength-1] + hash%uniformFingerPrints(fingerPrintLength), nil
+	}
+	numDigits := decimalDigits(hash)
+	if fingerPrintLength > numDigits {
+		return 0, fmt.Errorf("gostatix: the fingerprint length %d is higher than the hash length %d", fingerPrintLength, numDigits)
+	}
+	return hash / pow10[numDigits-fingerPrintLength], nil
+}
+
+// CollisionRate returns the probability of the fingerprints of _fingerPrintLength_ digits
+// of two elements being equal with the encoding
+func (encoding FingerPrintEncoding) CollisionRate(fingerPrintLength uint64) float64 {
+	if encoding == UniformFingerPrint {
+		return 1 / float64(uniformFingerPrints(fingerPrintLength))
+	}
+	return fingerPrintCollisionRate(fingerPrintLength)
+}
+
+// uniformFingerPrints returns the number of fingerprints of _fingerPrintLength_ digits
+// which fit in an uint64
+func uniformFingerPrints(fingerPrintLength uint64) uint64 {
+	if fingerPrintLength == maxFingerPrintLength {
+		return math.MaxUint64 - pow10[maxFingerPrintLength-1] + 1
+	}
+	return 9 * pow10[fingerPrintLength-1]
+}
+
+// getMetroHash returns the metro Hash64 of _data_
+func getMetroHash(data []byte) uint64 {
+	return metro.Hash64(data, 1373)
+}
@@ -0,0 +1,207 @@
+package gostatix
+
+import (
+	"hash/fnv"
+	"strconv"
+	"testing"
+)
+
+// fnvCuckooHashing is a custom hashing deriving the fingerprints of the FNV-1a hashes like
+// the built-in uniform hashings
+type fnvCuckooHashing struct{}
+
+func (fnvCuckooHashing) Name() string {
+	return "fnv-test"
+}
+
+func (fnvCuckooHashing) Hash(data []byte) uint64 {
+	hash := fnv.New64a()
+	hash.Write(data)
+	return hash.Sum64()
+}
+
+func (fnvCuckooHashing) FingerPrint(hash, fingerPrintLength uint64) (uint64, error) {
+	return UniformFingerPrint.FingerPrint(hash, fingerPrintLength)
+}
+
+var builtinCuckooHashings = []CuckooHashing{
+	MurmurCuckooHashing,
+	MetroCuckooHashing,
+	UniformMurmurCuckooHashing,
+	UniformMetroCuckooHashing,
+}
+
+func TestCuckooHashingStrategies(t *testing.T) {
+	for _, hashing := range append(builtinCuckooHashings, fnvCuckooHashing{}) {
+		// the filter is sized so that the inserts don't kick entries out
+		filter, _ := NewCuckooFilter(1024, 4, 4)
+		if err := filter.SetHashing(hashing); err != nil {
+			t.Fatalf("%s: setting the hashing shouldn't error out, error: %v", hashing.Name(), err)
+		}
+		if filter.Hashing().Name() != hashing.Name() {
+			t.Errorf("%s: hashing should be set, got %s", hashing.Name(), filter.Hashing().Name())
+		}
+		for i := 0; i < 300; i++ {
+			if _, err := filter.InsertWithStats([]byte(strconv.Itoa(i)), false, 0); err != nil {
+				t.Fatalf("%s: insert shouldn't error out, error: %v", hashing.Name(), err)
+			}
+		}
+		for i := 0; i < 300; i++ {
+			if !filter.Lookup([]byte(strconv.Itoa(i))) {
+				t.Errorf("%s: %d should be found", hashing.Name(), i)
+			}
+		}
+		for i := 0; i < 150; i++ {
+			if !filter.Remove([]byte(strconv.Itoa(i))) {
+				t.Errorf("%s: %d should be removed", hashing.Name(), i)
+			}
+		}
+		if filter.Length() != 150 {
+			t.Errorf("%s: length should be 150 after the removals, got %d", hashing.Name(), filter.Length())
+		}
+		if err := filter.SetHashing(MurmurCuckooHashing); err == nil {
+			t.Errorf("%s: setting the hashing of a filter which isn't empty should error out", hashing.Name())
+		}
+	}
+}
+
+func TestCuckooHashingPositions(t *testing.T) {
+	murmur, _ := NewCuckooFilter(1000, 4, 6)
+	metro, _ := NewCuckooFilter(1000, 4, 6)
+	metro.SetHashing(MetroCuckooHashing)
+	different := 0
+	for i := 0; i < 100; i++ {
+		data := []byte(strconv.Itoa(i))
+		murmurFingerPrint, murmurIndex, _, _ := murmur.getFingerPrintPositions(data)
+		metroFingerPrint, metroIndex, metroAltIndex, err := metro.getFingerPrintPositions(data)
+		if err != nil {
+			t.Fatalf("positions shouldn't error out, error: %v", err)
+		}
+		if metroIndex != getMetroHash(data)%1000 {
+			t.Errorf("first index should be the metro hash modulo the size")
+		}
+		var buf [maxFingerPrintLength]byte
+		altHash := getMetroHash(strconv.AppendUint(buf[:0], metroFingerPrint, 10))
+		if metroAltIndex != (altHash%1000+1000-metroIndex)%1000 {
+			t.Errorf("alternate index should be derived from the metro hash of the fingerprint")
+		}
+		if murmurFingerPrint != metroFingerPrint || murmurIndex != metroIndex {
+			different++
+		}
+	}
+	if different < 90 {
+		t.Errorf("metro and murmur hashings should derive different positions, %d of 100 differ", different)
+	}
+}
+
+func TestFingerPrintEncodings(t *testing.T) {
+	for _, hash := range []uint64{0, 7, 123456, 1 << 63, ^uint64(0)} {
+		for _, length := range []uint64{1, 3, 19, 20} {
+			fingerPrint, err := UniformFingerPrint.FingerPrint(hash, length)
+			if err != nil || decimalDigits(fingerPrint) != length {
+				t.Errorf("uniform fingerprint of %d should have %d digits, got %d, error: %v", hash, length, fingerPrint, err)
+			}
+		}
+	}
+	if fingerPrint, _ := PrefixFingerPrint.FingerPrint(123456, 3); fingerPrint != 123 {
+		t.Errorf("prefix fingerprint of 123456 should be 123, got %d", fingerPrint)
+	}
+	if _, err := PrefixFingerPrint.FingerPrint(42, 3); err == nil {
+		t.Errorf("prefix fingerprint longer than the hash should error out")
+	}
+	if _, err := UniformFingerPrint.FingerPrint(42, 0); err == nil {
+		t.Errorf("fingerprint of 0 digits should error out")
+	}
+	if UniformFingerPrint.CollisionRate(2) != 1.0/90 {
+		t.Errorf("collision rate of uniform fingerprints of 2 digits should be 1/90")
+	}
+	uniform, _ := NewCuckooFilter(100, 4, 2)
+	uniform.SetHashing(UniformMurmurCuckooHashing)
+	prefix, _ := NewCuckooFilter(100, 4, 2)
+	if uniform.CuckooPositiveRate() >= prefix.CuckooPositiveRate() {
+		t.Errorf("uniform fingerprints should collide less than prefix ones")
+	}
+}
+
+func TestCuckooHashingExportImport(t *testing.T) {
+	filter, _ := NewCuckooFilter(100, 4, 3)
+	filter.SetHashing(UniformMetroCuckooHashing)
+	filter.InsertString("foo", false)
+	data, _ := filter.Export()
+	imported, _ := NewCuckooFilter(10, 2, 2)
+	if err := imported.Import(data); err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if imported.Hashing() != UniformMetroCuckooHashing || !imported.LookupString("foo") {
+		t.Errorf("hashing should be imported, got %s", imported.Hashing().Name())
+	}
+	if equal, _ := filter.Equals(imported); !equal {
+		t.Errorf("imported filter should equal the exported one")
+	}
+	other, _ := NewCuckooFilter(100, 4, 3)
+	if comparison, _ := filter.Compare(other); comparison.Equal() {
+		t.Errorf("filters with different hashings shouldn't be equal")
+	}
+
+	unregistered := []byte(`{"s":1,"bs":1,"fpl":1,"l":0,"r":1,"b":[{"s":1,"l":0,"e":[]}],"h":"unknown"}`)
+	if err := imported.Import(unregistered); err == nil {
+		t.Errorf("import of a filter with an unregistered hashing should error out")
+	}
+	if err := RegisterCuckooHashing(fnvCuckooHashing{}); err != nil {
+		t.Errorf("registering a custom hashing shouldn't error out, error: %v", err)
+	}
+	if err := RegisterCuckooHashing(&cuckooHashing{name: "murmur"}); err == nil {
+		t.Errorf("registering another hashing with the name of a registered one should error out")
+	}
+}
+
+func TestCuckooHashingRedis(t *testing.T) {
+	initMockRedis()
+	RegisterCuckooHashing(fnvCuckooHashing{})
+	filter, _ := NewCuckooFilterRedis(64, 4, 4)
+	if err := filter.SetHashing(fnvCuckooHashing{}); err != nil {
+		t.Fatalf("setting the hashing shouldn't error out, error: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		filter.InsertString(strconv.Itoa(i), false)
+	}
+	if err := filter.SetHashing(MetroCuckooHashing); err == nil {
+		t.Errorf("setting the hashing of a filter which isn't empty should error out")
+	}
+	opened, err := NewCuckooFilterRedisFromKey(filter.MetadataKey())
+	if err != nil {
+		t.Fatalf("opening the filter shouldn't error out, error: %v", err)
+	}
+	if opened.Hashing().Name() != "fnv-test" {
+		t.Errorf("hashing should be loaded from the metadata, got %s", opened.Hashing().Name())
+	}
+	for i := 0; i < 100; i++ {
+		if ok, _ := opened.LookupString(strconv.Itoa(i)); !ok {
+			t.Errorf("%d should be found in the opened filter", i)
+		}
+	}
+	local, _ := NewCuckooFilter(1, 1, 1)
+	data, _ := filter.Export()
+	if err := local.Import(data); err != nil || local.Hashing().Name() != "fnv-test" {
+		t.Errorf("hashing should be imported from a redis export, got %s, error: %v", local.Hashing().Name(), err)
+	}
+}
+
+func TestCuckooHashingKV(t *testing.T) {
+	store := NewMemKVStore()
+	filter, _ := NewCuckooFilterKV(store, "cuckoo", 64, 4, 4)
+	if err := filter.SetHashing(MetroCuckooHashing); err != nil {
+		t.Fatalf("setting the hashing shouldn't error out, error: %v", err)
+	}
+	filter.InsertString("foo", false)
+	if err := filter.SetHashing(MurmurCuckooHashing); err == nil {
+		t.Errorf("setting the hashing of a filter which isn't empty should error out")
+	}
+	opened, _ := NewCuckooFilterKV(store, "cuckoo", 64, 4, 4)
+	if opened.Hashing() != MetroCuckooHashing {
+		t.Errorf("hashing should be loaded from the store, got %s", opened.Hashing().Name())
+	}
+	if ok, _ := opened.LookupString("foo"); !ok {
+		t.Errorf("foo should be found in the opened filter")
+	}
+}
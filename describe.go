@@ -0,0 +1,408 @@
+package gostatix
+
+import (
+	"math"
+	"strings"
+)
+
+// Description describes a data structure at runtime, as returned by the Describe method
+// of every data structure, so that tooling can inspect heterogeneous data structures
+// without type switches
+// _Type_ is the type of the data structure, named like in the Redis metadata: bloom,
+// spectral, apbf, iblt, cuckoo, cms, hll, rollinghll, topk, linearcounting, kmv, histogram
+// or reservoir
+// _Backend_ is where the data structure is stored
+// _Parameters_ holds the parameters of the data structure by name, formatted like the
+// values of the Redis metadata
+// _ErrorRate_ is the estimated error: the false positive rate of the filters, the error
+// factor of the count-min sketches and top-k, the maximum relative error of the exponential
+// histograms, the relative standard error of the cardinality sketches. It's 0 if it can't be
+// estimated.
+// _Keys_ holds the Redis keys of the data structure, the metadata key first, the path of a
+// memory mapped file or the name of a data structure in a KVStore
+// _Count_ is the number of bits set of a bloom filter, the number of counters greater than
+// zero of a spectral bloom filter, the number of bits set of an age-partitioned bloom
+// filter over all its slices, the number of keys inserted minus the number of keys deleted
+// of an invertible bloom lookup table, the number of elements of a cuckoo filter, the total
+// count of a count-min sketch or top-k, the approximate number of events in the window of
+// an exponential histogram, the number of sampled elements of a reservoir sampler and the
+// estimated number of distinct elements of a cardinality sketch, over all the retained
+// intervals for a rolling hyperloglog
+type Description struct {
+	Type       string
+	Backend    Backend
+	Parameters map[string]string
+	ErrorRate  float64
+	Keys       []string
+	Count      uint64
+}
+
+// Describer is implemented by all the data structures
+type Describer interface {
+	Describe() (Description, error)
+}
+
+var (
+	_ Describer = (*BloomFilter)(nil)
+	_ Describer = (*CuckooFilter)(nil)
+	_ Describer = (*CuckooFilterRedis)(nil)
+	_ Describer = (*CuckooFilterKV)(nil)
+	_ Describer = (*CountMinSketch)(nil)
+	_ Describer = (*CountMinSketchRedis)(nil)
+	_ Describer = (*CountMinSketchKV)(nil)
+	_ Describer = (*HyperLogLog)(nil)
+	_ Describer = (*HyperLogLogRedis)(nil)
+	_ Describer = (*RollingHyperLogLog)(nil)
+	_ Describer = (*TopK)(nil)
+	_ Describer = (*TopKRedis)(nil)
+	_ Describer = (*LinearCounting)(nil)
+	_ Describer = (*KMinValues)(nil)
+	_ Describer = (*SpectralBloomFilter)(nil)
+	_ Describer = (*AgePartitionedBloomFilter)(nil)
+	_ Describer = (*IBLT)(nil)
+	_ Describer = (*ExponentialHistogram)(nil)
+	_ Describer = (*ReservoirSampler[string])(nil)
+	_ Describer = (*ReservoirSamplerRedis)(nil)
+)
+
+// parameters formats _values_, given as name and value pairs, into the parameters of a
+// Description
+func parameters(values ...interface{}) map[string]string {
+	params := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		params[values[i].(string)] = formatMetadataValue(values[i+1])
+	}
+	return params
+}
+
+// Describe returns the description of the BloomFilter
+func (bloomFilter *BloomFilter) Describe() (Description, error) {
+	if bloomFilter.needsLock() {
+		bloomFilter.lock.RLock()
+		defer bloomFilter.lock.RUnlock()
+	}
+	count, err := bloomFilter.filter.bitCount()
+	if err != nil {
+		return Description{}, err
+	}
+	d := Description{
+		Type:       "bloom",
+		Parameters: parameters("size", bloomFilter.size, "numHashes", bloomFilter.numHashes, "hashing", uint8(bloomFilter.hashing)),
+		ErrorRate:  bloomFilter.BloomPositiveRate(),
+		Count:      uint64(count),
+	}
+	switch bitSet := bloomFilter.filter.(type) {
+	case *BitSetRedis:
+		d.Backend = RedisBackend
+		d.Keys = []string{bloomFilter.metadataKey, bitSet.getKey()}
+	case *ShardedBitSetRedis:
+		d.Backend = RedisBackend
+		d.Keys = append([]string{bloomFilter.metadataKey}, bitSet.getKeys()...)
+		d.Parameters["bitsetKeys"] = strings.Join(bitSet.getKeys(), ",")
+	case *BitSetMmap:
+		d.Backend = MmapBackend
+		d.Keys = []string{bitSet.Path()}
+	default:
+		d.Backend = MemoryBackend
+	}
+	return d, nil
+}
+
+// cuckooParameters returns the parameters of a cuckoo filter
+func (cuckooFilter *AbstractCuckooFilter) cuckooParameters() map[string]string {
+	return parameters("size", cuckooFilter.size, "bucketSize", cuckooFilter.bucketSize,
+		"fingerPrintLength", cuckooFilter.fingerPrintLength, "retries", cuckooFilter.retries,
+		"hashing", cuckooFilter.Hashing().Name(), "candidates", cuckooFilter.Candidates())
+}
+
+// Describe returns the description of the CuckooFilter
+func (cuckooFilter *CuckooFilter) Describe() (Description, error) {
+	cuckooFilter.lock.RLock()
+	defer cuckooFilter.lock.RUnlock()
+
+	return Description{
+		Type:       "cuckoo",
+		Backend:    MemoryBackend,
+		Parameters: cuckooFilter.cuckooParameters(),
+		ErrorRate:  cuckooFilter.positiveRate(cuckooFilter.length),
+		Count:      cuckooFilter.length,
+	}, nil
+}
+
+// Describe returns the description of the CuckooFilterRedis
+func (cuckooFilter *CuckooFilterRedis) Describe() (Description, error) {
+	length := cuckooFilter.Length()
+	return Description{
+		Type:       "cuckoo",
+		Backend:    RedisBackend,
+		Parameters: cuckooFilter.cuckooParameters(),
+		ErrorRate:  cuckooFilter.positiveRate(length),
+		Keys:       []string{cuckooFilter.metadataKey, cuckooFilter.key},
+		Count:      length,
+	}, nil
+}
+
+// Describe returns the description of the CuckooFilterKV
+func (cuckooFilter *CuckooFilterKV) Describe() (Description, error) {
+	length, err := cuckooFilter.Length()
+	if err != nil {
+		return Description{}, err
+	}
+	return Description{
+		Type:       "cuckoo",
+		Backend:    KVBackend,
+		Parameters: cuckooFilter.cuckooParameters(),
+		ErrorRate:  cuckooFilter.positiveRate(length),
+		Keys:       []string{cuckooFilter.name},
+		Count:      length,
+	}, nil
+}
+
+// Describe returns the description of the CountMinSketch
+func (cms *CountMinSketch) Describe() (Description, error) {
+	cms.lock.RLock()
+	defer cms.lock.RUnlock()
+
+	return Description{
+		Type:       "cms",
+		Backend:    MemoryBackend,
+		Parameters: parameters("rows", cms.rows, "columns", cms.columns),
+		ErrorRate:  cms.ErrorRate(),
+		Count:      cms.allSum,
+	}, nil
+}
+
+// Describe returns the description of the CountMinSketchRedis
+func (cms *CountMinSketchRedis) Describe() (Description, error) {
+	total, err := cms.TotalCount()
+	if err != nil {
+		return Description{}, err
+	}
+	return Description{
+		Type:       "cms",
+		Backend:    RedisBackend,
+		Parameters: parameters("rows", cms.rows, "columns", cms.columns),
+		ErrorRate:  cms.ErrorRate(),
+		Keys:       []string{cms.metadataKey, cms.key},
+		Count:      total,
+	}, nil
+}
+
+// Describe returns the description of the CountMinSketchKV
+func (cms *CountMinSketchKV) Describe() (Description, error) {
+	total, err := cms.TotalCount()
+	if err != nil {
+		return Description{}, err
+	}
+	return Description{
+		Type:       "cms",
+		Backend:    KVBackend,
+		Parameters: parameters("rows", cms.rows, "columns", cms.columns),
+		ErrorRate:  cms.ErrorRate(),
+		Keys:       []string{cms.name},
+		Count:      total,
+	}, nil
+}
+
+// Describe returns the description of the HyperLogLog
+func (h *HyperLogLog) Describe() (Description, error) {
+	return Description{
+		Type:       "hll",
+		Backend:    MemoryBackend,
+		Parameters: parameters("numRegisters", h.numRegisters, "estimator", h.estimator),
+		ErrorRate:  h.Accuracy(),
+		Count:      h.Count(true, true),
+	}, nil
+}
+
+// Describe returns the description of the HyperLogLogRedis
+func (h *HyperLogLogRedis) Describe() (Description, error) {
+	count, err := h.Count(true, true)
+	if err != nil {
+		return Description{}, err
+	}
+	return Description{
+		Type:       "hll",
+		Backend:    RedisBackend,
+		Parameters: parameters("numRegisters", h.numRegisters, "estimator", h.estimator),
+		ErrorRate:  h.Accuracy(),
+		Keys:       []string{h.metadataKey, h.key},
+		Count:      count,
+	}, nil
+}
+
+// Describe returns the description of the TopK
+func (t *TopK) Describe() (Description, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return Description{
+		Type:       "topk",
+		Backend:    MemoryBackend,
+		Parameters: parameters("k", t.k, "errorRate", t.errorRate, "accuracy", t.accuracy),
+		ErrorRate:  t.errorRate,
+		Count:      t.sketch.TotalCount(),
+	}, nil
+}
+
+// Describe returns the description of the TopKRedis
+func (t *TopKRedis) Describe() (Description, error) {
+	total, err := t.sketch.TotalCount()
+	if err != nil {
+		return Description{}, err
+	}
+	return Description{
+		Type:       "topk",
+		Backend:    RedisBackend,
+		Parameters: parameters("k", t.k, "errorRate", t.errorRate, "accuracy", t.accuracy),
+		ErrorRate:  t.errorRate,
+		Keys:       []string{t.metadataKey, t.heapKey, t.sketch.metadataKey, t.sketch.key},
+		Count:      total,
+	}, nil
+}
+
+// Describe returns the description of the LinearCounting. Its error isn't estimated.
+func (l *LinearCounting) Describe() (Description, error) {
+	return Description{
+		Type:       "linearcounting",
+		Backend:    MemoryBackend,
+		Parameters: parameters("numBits", l.numBits),
+		Count:      l.Count(),
+	}, nil
+}
+
+// Describe returns the description of the KMinValues
+func (h *KMinValues) Describe() (Description, error) {
+	return Description{
+		Type:       "kmv",
+		Backend:    MemoryBackend,
+		Parameters: parameters("k", h.k),
+		ErrorRate:  h.Accuracy(),
+		Count:      h.Count(),
+	}, nil
+}
+
+// Describe returns the description of the SpectralBloomFilter
+func (filter *SpectralBloomFilter) Describe() (Description, error) {
+	filter.lock.RLock()
+	defer filter.lock.RUnlock()
+
+	counters, err := filter.counters.values(filter.size)
+	if err != nil {
+		return Description{}, err
+	}
+	var count uint64
+	for _, counter := range counters {
+		if counter != 0 {
+			count++
+		}
+	}
+	d := Description{
+		Type:       "spectral",
+		Backend:    MemoryBackend,
+		Parameters: parameters("size", filter.size, "numHashes", filter.numHashes),
+		ErrorRate:  math.Pow(float64(count)/float64(filter.size), float64(filter.numHashes)),
+		Count:      count,
+	}
+	if redisCounters, ok := filter.counters.(*spectralCountersRedis); ok {
+		d.Backend = RedisBackend
+		d.Keys = []string{filter.metadataKey, redisCounters.key}
+	}
+	return d, nil
+}
+
+// Describe returns the description of the AgePartitionedBloomFilter
+func (filter *AgePartitionedBloomFilter) Describe() (Description, error) {
+	counts, err := filter.sliceCounts()
+	if err != nil {
+		return Description{}, err
+	}
+	var count uint64
+	for _, c := range counts {
+		count += c
+	}
+	d := Description{
+		Type:    "apbf",
+		Backend: MemoryBackend,
+		Parameters: parameters("numHashes", filter.numHashes, "numGenerations", filter.numGenerations,
+			"sliceSize", filter.sliceSize, "generationSize", filter.generationSize, "window", filter.window.String()),
+		ErrorRate: filter.falsePositiveRate(counts),
+		Count:     count,
+	}
+	if redisSlices, ok := filter.slices.(*apbfSlicesRedis); ok {
+		d.Backend = RedisBackend
+		d.Keys = []string{filter.metadataKey, redisSlices.bitsKey, redisSlices.stateKey}
+	}
+	return d, nil
+}
+
+// Describe returns the description of the IBLT
+func (t *IBLT) Describe() (Description, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	var sum int64
+	for _, count := range t.counts {
+		sum += count
+	}
+	if sum < 0 {
+		sum = -sum
+	}
+	return Description{
+		Type:       "iblt",
+		Backend:    MemoryBackend,
+		Parameters: parameters("numCells", t.numCells, "numHashes", t.numHashes, "keySize", t.keySize),
+		Count:      uint64(sum) / t.numHashes,
+	}, nil
+}
+
+// Describe returns the description of the RollingHyperLogLog
+func (h *RollingHyperLogLog) Describe() (Description, error) {
+	count, err := h.CountLast(h.retention, true, true)
+	if err != nil {
+		return Description{}, err
+	}
+	return Description{
+		Type:       "rollinghll",
+		Backend:    MemoryBackend,
+		Parameters: parameters("numRegisters", h.numRegisters, "interval", h.interval.String(), "retention", h.retention),
+		ErrorRate:  h.Accuracy(),
+		Count:      count,
+	}, nil
+}
+
+// Describe returns the description of the ExponentialHistogram
+func (h *ExponentialHistogram) Describe() (Description, error) {
+	return Description{
+		Type:       "histogram",
+		Backend:    MemoryBackend,
+		Parameters: parameters("window", h.window.String(), "errorRate", h.errorRate),
+		ErrorRate:  h.errorRate,
+		Count:      h.Count(),
+	}, nil
+}
+
+// Describe returns the description of the ReservoirSampler. Its error isn't estimated.
+func (r *ReservoirSampler[T]) Describe() (Description, error) {
+	return Description{
+		Type:       "reservoir",
+		Backend:    MemoryBackend,
+		Parameters: parameters("k", r.k),
+		Count:      r.Len(),
+	}, nil
+}
+
+// Describe returns the description of the ReservoirSamplerRedis. Its error isn't estimated.
+func (r *ReservoirSamplerRedis) Describe() (Description, error) {
+	length, err := r.Len()
+	if err != nil {
+		return Description{}, err
+	}
+	return Description{
+		Type:       "reservoir",
+		Backend:    RedisBackend,
+		Parameters: parameters("k", r.k),
+		Keys:       []string{r.metadataKey, r.key},
+		Count:      length,
+	}, nil
+}
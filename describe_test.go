@@ -0,0 +1,56 @@
+package gostatix
+
+import (
+	"testing"
+)
+
+func TestDescribe(t *testing.T) {
+	initMockRedis()
+	memFilter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	memFilter.InsertString("foo")
+	redisFilter, _ := NewRedisBloomFilterWithShards(1000, 0.01, 2)
+	cuckoo, _ := NewCuckooFilterRedis(100, 4, 8)
+	cuckoo.InsertString("foo", false)
+	cuckoo.InsertString("bar", false)
+	kvSketch, _ := NewCountMinSketchKV(NewMemKVStore(), "cms", 4, 100)
+	kvSketch.UpdateString("foo", 5)
+	log, _ := NewHyperLogLog(64)
+	log.UpdateString("foo")
+	topk, _ := NewTopKRedis(5, 0.01, 0.99)
+	topk.InsertString("foo", 3)
+	kmv, _ := NewKMinValues(16)
+
+	cases := []struct {
+		describer Describer
+		typ       string
+		backend   Backend
+		numKeys   int
+		count     uint64
+	}{
+		{memFilter, "bloom", MemoryBackend, 0, uint64(memFilter.GetNumHashes())},
+		{redisFilter, "bloom", RedisBackend, 3, 0},
+		{cuckoo, "cuckoo", RedisBackend, 2, 2},
+		{kvSketch, "cms", KVBackend, 1, 5},
+		{log, "hll", MemoryBackend, 0, log.Count(true, true)},
+		{topk, "topk", RedisBackend, 4, 3},
+		{kmv, "kmv", MemoryBackend, 0, 0},
+	}
+	for _, c := range cases {
+		d, err := c.describer.Describe()
+		if err != nil {
+			t.Fatalf("describe of %s shouldn't error out, error: %v", c.typ, err)
+		}
+		if d.Type != c.typ || d.Backend != c.backend || len(d.Keys) != c.numKeys || d.Count != c.count {
+			t.Errorf("unexpected description of %s, got %+v", c.typ, d)
+		}
+	}
+
+	d, _ := memFilter.Describe()
+	if d.Parameters["size"] != "9586" || d.Parameters["numHashes"] != "7" || d.ErrorRate <= 0 {
+		t.Errorf("unexpected parameters of the bloom filter, got %v, error rate %v", d.Parameters, d.ErrorRate)
+	}
+	d, _ = topk.Describe()
+	if d.Keys[0] != topk.MetadataKey() || d.Parameters["errorRate"] != "0.01" {
+		t.Errorf("unexpected description of the topk, got %+v", d)
+	}
+}
@@ -0,0 +1,308 @@
+/*
+Implements the optional encryption of the snapshots returned by Export and of the streams
+written by WriteTo with an AEAD whose key is supplied by the caller, so that snapshots
+holding sensitive fingerprints can be stored on untrusted media.
+*/
+package gostatix
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// An encrypted stream starts with a header, followed by the encrypted chunks of the data.
+// Each chunk is sealed with the nonce of the header XORed with the index of the chunk and
+// authenticates the header, its index and its length, so chunks can't be reordered,
+// dropped or truncated. All the integers are big endian.
+//
+//	header  magic "GSTE" (4 bytes), version (1 byte), reserved (3 bytes, 0), nonce
+//	chunk   length of the sealed chunk (uint32, high bit set on the last chunk), sealed chunk
+
+// encryptionMagic starts every encrypted stream
+var encryptionMagic = []byte("GSTE")
+
+// encryptionVersion is the version of the encrypted stream layout
+const encryptionVersion = 1
+
+// encryptionHeaderSize is the size of the header of an encrypted stream, before the nonce
+const encryptionHeaderSize = 8
+
+// encryptionChunkSize is the number of bytes of data sealed in each chunk
+const encryptionChunkSize = 1 << 16
+
+// encryptionLastChunk flags the length of the last chunk of an encrypted stream
+const encryptionLastChunk = 1 << 31
+
+// NewAESGCM returns the AES-GCM AEAD of _key_, which must be 16, 24 or 32 bytes long to
+// use AES-128, AES-192 or AES-256
+func NewAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: invalid encryption key, error: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// checkAEAD returns an error if _aead_ can't encrypt streams
+func checkAEAD(aead cipher.AEAD) error {
+	if aead == nil {
+		return fmt.Errorf("gostatix: encryption needs an AEAD")
+	}
+	if aead.NonceSize() < 8 {
+		return fmt.Errorf("gostatix: encryption needs a nonce of at least 8 bytes, got %d", aead.NonceSize())
+	}
+	return nil
+}
+
+// chunkNonce returns the nonce of the chunk _index_ of a stream whose header holds _nonce_
+func chunkNonce(nonce []byte, index uint64) []byte {
+	chunk := append([]byte(nil), nonce...)
+	tail := chunk[len(chunk)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^index)
+	return chunk
+}
+
+// chunkAdditionalData returns the data authenticated along with the chunk _index_ of
+// _length_ bytes of a stream starting with _header_
+func chunkAdditionalData(header []byte, index uint64, length uint32) []byte {
+	data := append([]byte(nil), header...)
+	data = binary.BigEndian.AppendUint64(data, index)
+	return binary.BigEndian.AppendUint32(data, length)
+}
+
+// encryptedWriter seals the data written to it in chunks onto _stream_
+type encryptedWriter struct {
+	stream io.Writer
+	aead   cipher.AEAD
+	header []byte
+	nonce  []byte
+	index  uint64
+	buf    []byte
+	err    error
+}
+
+// NewEncryptedWriter returns a writer encrypting the data written to it with _aead_ onto
+// _w_, e.g. to pass to the WriteTo method of a data structure. It must be closed to write
+// the last chunk, which doesn't close _w_; a stream which isn't closed can't be read.
+// The data should be compressed before it's encrypted, since encrypted data doesn't
+// compress.
+func NewEncryptedWriter(w io.Writer, aead cipher.AEAD) (io.WriteCloser, error) {
+	err := checkAEAD(aead)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while generating encryption nonce, error: %v", err)
+	}
+	header := make([]byte, encryptionHeaderSize, encryptionHeaderSize+len(nonce))
+	copy(header, encryptionMagic)
+	header[len(encryptionMagic)] = encryptionVersion
+	header = append(header, nonce...)
+	_, err = w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedWriter{stream: w, aead: aead, header: header, nonce: nonce, buf: make([]byte, 0, encryptionChunkSize)}, nil
+}
+
+func (w *encryptedWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(w.buf) == encryptionChunkSize {
+			// the last chunk is only sealed by Close, so a full chunk waits for more data
+			w.err = w.seal(false)
+			if w.err != nil {
+				return written, w.err
+			}
+		}
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// seal writes the buffered data as the next chunk of the stream
+func (w *encryptedWriter) seal(last bool) error {
+	length := uint32(len(w.buf) + w.aead.Overhead())
+	if last {
+		length |= encryptionLastChunk
+	}
+	chunk := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(w.buf)+w.aead.Overhead()), length)
+	chunk = w.aead.Seal(chunk, chunkNonce(w.nonce, w.index), w.buf, chunkAdditionalData(w.header, w.index, length))
+	w.index++
+	w.buf = w.buf[:0]
+	_, err := w.stream.Write(chunk)
+	return err
+}
+
+// Close writes the last chunk of the stream
+func (w *encryptedWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.seal(true)
+	if w.err != nil {
+		return w.err
+	}
+	w.err = fmt.Errorf("gostatix: encrypted writer is closed")
+	return nil
+}
+
+// encryptedReader opens the chunks read from _stream_
+type encryptedReader struct {
+	stream io.Reader
+	aead   cipher.AEAD
+	header []byte
+	nonce  []byte
+	index  uint64
+	buf    []byte
+	last   bool
+}
+
+// NewEncryptedReader returns a reader decrypting the stream written by a writer returned
+// by NewEncryptedWriter from _r_ with _aead_, e.g. to pass to the ReadFrom method of a
+// data structure. A wrong key, a stream altered or cut short are reported as an error
+// wrapping ErrCorruptSnapshot.
+func NewEncryptedReader(r io.Reader, aead cipher.AEAD) (io.Reader, error) {
+	err := checkAEAD(aead)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, encryptionHeaderSize+aead.NonceSize())
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: encryption header cut short", ErrCorruptSnapshot)
+	}
+	if !bytes.Equal(header[:len(encryptionMagic)], encryptionMagic) {
+		return nil, fmt.Errorf("gostatix: not an encrypted stream")
+	}
+	if header[len(encryptionMagic)] != encryptionVersion {
+		return nil, fmt.Errorf("gostatix: unsupported encrypted stream version %d", header[len(encryptionMagic)])
+	}
+	return &encryptedReader{stream: r, aead: aead, header: header, nonce: header[encryptionHeaderSize:]}, nil
+}
+
+func (r *encryptedReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.last {
+			return 0, io.EOF
+		}
+		err := r.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next chunk of the stream
+func (r *encryptedReader) open() error {
+	var length uint32
+	err := binary.Read(r.stream, binary.BigEndian, &length)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("%w: encrypted stream cut short", ErrCorruptSnapshot)
+		}
+		return err
+	}
+	size := length &^ encryptionLastChunk
+	if size < uint32(r.aead.Overhead()) || size > uint32(encryptionChunkSize+r.aead.Overhead()) {
+		return fmt.Errorf("%w: invalid encrypted chunk of %d bytes", ErrCorruptSnapshot, size)
+	}
+	sealed := make([]byte, size)
+	_, err = io.ReadFull(r.stream, sealed)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("%w: encrypted stream cut short", ErrCorruptSnapshot)
+		}
+		return err
+	}
+	r.buf, err = r.aead.Open(sealed[:0], chunkNonce(r.nonce, r.index), sealed, chunkAdditionalData(r.header, r.index, length))
+	if err != nil {
+		return fmt.Errorf("%w: encrypted chunk can't be decrypted, the key may be wrong", ErrCorruptSnapshot)
+	}
+	r.index++
+	r.last = length&encryptionLastChunk != 0
+	return nil
+}
+
+// Encrypted wraps a data structure whose snapshots are encrypted, e.g. to save it with a
+// Snapshotter on untrusted media or to add it to a Bundle. To compress the snapshots as
+// well, the Encrypted should wrap a Compressed.
+type Encrypted struct {
+	structure Snapshottable
+	aead      cipher.AEAD
+}
+
+var _ Snapshottable = (*Encrypted)(nil)
+
+// NewEncrypted wraps _structure_ so that its exports are encrypted with _aead_, e.g. the
+// one returned by NewAESGCM
+func NewEncrypted(structure Snapshottable, aead cipher.AEAD) (*Encrypted, error) {
+	if structure == nil {
+		return nil, fmt.Errorf("gostatix: encrypted snapshots need a data structure")
+	}
+	err := checkAEAD(aead)
+	if err != nil {
+		return nil, err
+	}
+	return &Encrypted{structure, aead}, nil
+}
+
+// Unwrap returns the wrapped data structure
+func (e *Encrypted) Unwrap() Snapshottable {
+	return e.structure
+}
+
+// Export exports the data structure and encrypts the snapshot
+func (e *Encrypted) Export() ([]byte, error) {
+	data, err := e.structure.Export()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writer, err := NewEncryptedWriter(&buf, e.aead)
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(data)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while encrypting snapshot, error: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Import decrypts _data_ and imports it into the data structure. The decrypted snapshot
+// can't exceed MaxSnapshotSize.
+func (e *Encrypted) Import(data []byte) error {
+	reader, err := NewEncryptedReader(bytes.NewReader(data), e.aead)
+	if err != nil {
+		return err
+	}
+	decrypted, err := io.ReadAll(io.LimitReader(reader, int64(minUint64(MaxSnapshotSize, math.MaxInt64-1))+1))
+	if err != nil {
+		return err
+	}
+	if uint64(len(decrypted)) > MaxSnapshotSize {
+		return fmt.Errorf("gostatix: decrypted snapshot exceeds the maximum snapshot size of %d bytes", MaxSnapshotSize)
+	}
+	return e.structure.Import(decrypted)
+}
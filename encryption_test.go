@@ -0,0 +1,110 @@
+package gostatix
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestEncryptedExport(t *testing.T) {
+	sketch, _ := NewCountMinSketch(4, 2000)
+	for i := 0; i < 100; i++ {
+		sketch.UpdateString(strconv.Itoa(i), 1)
+	}
+	aead, err := NewAESGCM(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("32 bytes key shouldn't error out, error: %v", err)
+	}
+	if _, err := NewAESGCM([]byte("short")); err == nil {
+		t.Errorf("5 bytes key should error out")
+	}
+	compressed, _ := NewCompressed(sketch, GzipCompression)
+	encrypted, err := NewEncrypted(compressed, aead)
+	if err != nil {
+		t.Fatalf("encryption shouldn't error out, error: %v", err)
+	}
+	data, err := encrypted.Export()
+	if err != nil {
+		t.Fatalf("export shouldn't error out, error: %v", err)
+	}
+	if !bytes.HasPrefix(data, encryptionMagic) {
+		t.Errorf("encrypted snapshot should start with its magic")
+	}
+	restored, _ := NewCountMinSketch(1, 1)
+	if err := restored.Import(data); err == nil {
+		t.Errorf("encrypted snapshot shouldn't be imported without its key")
+	}
+	restoredCompressed, _ := NewCompressed(restored, GzipCompression)
+	decrypting, _ := NewEncrypted(restoredCompressed, aead)
+	if err := decrypting.Import(data); err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if equal, _ := restored.Equals(sketch); !equal {
+		t.Errorf("decrypted sketch should equal the exported one")
+	}
+
+	other, _ := NewAESGCM(bytes.Repeat([]byte{8}, 32))
+	wrong, _ := NewEncrypted(restoredCompressed, other)
+	if err := wrong.Import(data); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("import with the wrong key should be a corrupt snapshot, got %v", err)
+	}
+	tampered := append([]byte(nil), data...)
+	tampered[len(tampered)/2] ^= 1
+	if err := decrypting.Import(tampered); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("altered snapshot should be a corrupt snapshot, got %v", err)
+	}
+	if err := decrypting.Import(data[:len(data)-1]); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("truncated snapshot should be a corrupt snapshot, got %v", err)
+	}
+	if _, err := NewEncrypted(sketch, nil); err == nil {
+		t.Errorf("encryption without AEAD should error out")
+	}
+}
+
+func TestEncryptedWriter(t *testing.T) {
+	filter, _ := NewMemBloomFilterWithParameters(100000, 0.001)
+	for i := 0; i < 1000; i++ {
+		filter.InsertString(strconv.Itoa(i))
+	}
+	aead, _ := NewAESGCM(bytes.Repeat([]byte{7}, 16))
+	var buf bytes.Buffer
+	writer, err := NewEncryptedWriter(&buf, aead)
+	if err != nil {
+		t.Fatalf("encrypted writer shouldn't error out, error: %v", err)
+	}
+	written, err := filter.WriteTo(writer)
+	if err != nil {
+		t.Fatalf("filter should be written, error: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("encrypted writer should be closed, error: %v", err)
+	}
+	if written <= encryptionChunkSize {
+		t.Fatalf("filter should span several chunks, got %d bytes", written)
+	}
+	data := buf.Bytes()
+
+	reader, err := NewEncryptedReader(bytes.NewReader(data), aead)
+	if err != nil {
+		t.Fatalf("encrypted reader shouldn't error out, error: %v", err)
+	}
+	restored, _ := NewMemBloomFilterWithParameters(1, 0.1)
+	read, err := restored.ReadFrom(reader)
+	if err != nil || read != written {
+		t.Fatalf("filter should be read, got %d bytes instead of %d, error: %v", read, written, err)
+	}
+	if equal, _ := restored.Equals(filter); !equal {
+		t.Errorf("decrypted filter should equal the written one")
+	}
+
+	// dropping the last chunk leaves a stream whose chunks are all authentic
+	lastChunk := len(data) - (int(written)%encryptionChunkSize + aead.Overhead() + 4)
+	reader, _ = NewEncryptedReader(bytes.NewReader(data[:lastChunk]), aead)
+	if _, err := restored.ReadFrom(reader); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("stream without its last chunk should be a corrupt snapshot, got %v", err)
+	}
+	if _, err := NewEncryptedReader(bytes.NewReader([]byte("not encrypted")), aead); err == nil {
+		t.Errorf("stream without the encryption magic should error out")
+	}
+}
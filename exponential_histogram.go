@@ -0,0 +1,275 @@
+/*
+Implements the exponential histogram used in counting the events of a stream over a
+sliding time window.
+
+Exponential Histogram: A probabilistic data structure which groups the events in buckets
+whose sizes are powers of 2, keeping only the size and the time of the latest event of
+each bucket. At most a fixed number of buckets of each size are kept, the two oldest ones
+being merged when there are more, so the memory used is logarithmic in the number of
+events in the window. Buckets older than the window are dropped. Only the oldest bucket
+of a window is partially in it, which bounds the relative error of the counts.
+Refer: https://dl.acm.org/doi/10.1137/S0097539701398363
+
+The in-memory data structure is thread-safe.
+*/
+package gostatix
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// ExponentialHistogram struct. This is an in-memory implementation of an exponential
+// histogram counting the events of the last _window_.
+// _window_ is the sliding window over which the events are counted
+// _errorRate_ is the maximum relative error of the counts
+// _maxPerSize_ is the maximum number of buckets of the same size
+// _buckets_ holds the buckets from the oldest to the latest
+// _lock_ is used to synchronize concurrent read/writes
+type ExponentialHistogram struct {
+	window     time.Duration
+	errorRate  float64
+	maxPerSize int
+	buckets    []histogramBucket
+	lock       sync.RWMutex
+}
+
+// histogramBucket is a bucket of _size_ events, the latest of which happened at _timestamp_
+// in nanoseconds since the epoch
+type histogramBucket struct {
+	Size      uint64 `json:"s"`
+	Timestamp int64  `json:"t"`
+}
+
+type exponentialHistogramJSON struct {
+	Window    int64             `json:"w"`
+	ErrorRate float64           `json:"e"`
+	Buckets   []histogramBucket `json:"b"`
+}
+
+// NewExponentialHistogram creates new ExponentialHistogram counting the events of the last
+// _window_ with a relative error of at most _errorRate_, between 0 and 1
+func NewExponentialHistogram(window time.Duration, errorRate float64) (*ExponentialHistogram, error) {
+	err := checkExponentialHistogramParams(window, errorRate)
+	if err != nil {
+		return nil, err
+	}
+	return &ExponentialHistogram{
+		window:     window,
+		errorRate:  errorRate,
+		maxPerSize: maxBucketsPerSize(errorRate),
+	}, nil
+}
+
+// checkExponentialHistogramParams returns an error if an exponential histogram can't be
+// created with _window_ and _errorRate_
+func checkExponentialHistogramParams(window time.Duration, errorRate float64) error {
+	if window <= 0 {
+		return fmt.Errorf("gostatix: exponential histogram window %v should be positive", window)
+	}
+	if errorRate <= 0 || errorRate >= 1 {
+		return fmt.Errorf("gostatix: exponential histogram error rate %v should be between 0 and 1", errorRate)
+	}
+	return nil
+}
+
+// maxBucketsPerSize returns the number of buckets of the same size to keep for a relative
+// error of at most _errorRate_
+func maxBucketsPerSize(errorRate float64) int {
+	return int(math.Ceil(1/(2*errorRate))) + 1
+}
+
+// Window returns the sliding window of the ExponentialHistogram
+func (h *ExponentialHistogram) Window() time.Duration {
+	return h.window
+}
+
+// ErrorRate returns the maximum relative error of the counts of the ExponentialHistogram
+func (h *ExponentialHistogram) ErrorRate() float64 {
+	return h.errorRate
+}
+
+// Add adds _count_ events happening now to the ExponentialHistogram
+func (h *ExponentialHistogram) Add(count uint64) {
+	h.AddAt(time.Now(), count)
+}
+
+// AddAt adds _count_ events happening at _t_ to the ExponentialHistogram, e.g. to count
+// the events of a stream by their own timestamps. Events are expected in time order, the
+// ones older than the latest event added are counted as happening with it. Adding takes
+// O(log count) steps for each size of bucket.
+func (h *ExponentialHistogram) AddAt(t time.Time, count uint64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	timestamp := t.UnixNano()
+	if n := len(h.buckets); n > 0 && timestamp < h.buckets[n-1].Timestamp {
+		timestamp = h.buckets[n-1].Timestamp
+	}
+	if count > 0 {
+		h.addBuckets(timestamp, count)
+	}
+	h.expire(timestamp)
+}
+
+// addBuckets adds _count_ buckets of 1 event at _timestamp_, merging the buckets as if
+// they were added one by one. The buckets of a size are merged as a queue: the two oldest
+// ones are merged into a bucket of twice the size, with the timestamp of the latest of
+// the two, as long as there are more than _maxPerSize_ of them. Each size is fed by the
+// merged buckets of the size below, so the sizes are merged in turn, the buckets at
+// _timestamp_ being counted as a run instead of being added one by one.
+func (h *ExponentialHistogram) addBuckets(timestamp int64, count uint64) {
+	maxPerSize := uint64(h.maxPerSize)
+	end := len(h.buckets)
+	// the buckets left of each size, from the smallest size
+	var levels [][]histogramBucket
+	var carried []histogramBucket
+	run := count
+	for size := uint64(1); end > 0 || len(carried) > 0 || run > 0; size *= 2 {
+		start := end
+		for start > 0 && h.buckets[start-1].Size == size {
+			start--
+		}
+		// the queue of the size is its buckets, the buckets carried from the size below
+		// and the run of buckets at _timestamp_
+		queue := append(append([]histogramBucket(nil), h.buckets[start:end]...), carried...)
+		end = start
+		merges := uint64(0)
+		if total := uint64(len(queue)) + run; total > maxPerSize {
+			merges = (total - maxPerSize + 1) / 2
+		}
+		carried = nil
+		for i := uint64(0); i < merges && 2*i+1 < uint64(len(queue)); i++ {
+			carried = append(carried, histogramBucket{2 * size, queue[2*i+1].Timestamp})
+		}
+		merged := 2 * merges
+		var left []histogramBucket
+		if merged <= uint64(len(queue)) {
+			left = queue[merged:]
+			merged = 0
+		} else {
+			merged -= uint64(len(queue))
+		}
+		for i := merged; i < run; i++ {
+			left = append(left, histogramBucket{size, timestamp})
+		}
+		levels = append(levels, left)
+		run = merges - uint64(len(carried))
+	}
+	buckets := make([]histogramBucket, 0, len(h.buckets)+h.maxPerSize)
+	for i := len(levels) - 1; i >= 0; i-- {
+		buckets = append(buckets, levels[i]...)
+	}
+	h.buckets = buckets
+}
+
+// expire drops the buckets whose latest event isn't in the window ending at _timestamp_
+func (h *ExponentialHistogram) expire(timestamp int64) {
+	i := 0
+	for i < len(h.buckets) && h.buckets[i].Timestamp <= timestamp-int64(h.window) {
+		i++
+	}
+	if i > 0 {
+		h.buckets = append(h.buckets[:0], h.buckets[i:]...)
+	}
+}
+
+// Count returns the approximate number of events of the window of the ExponentialHistogram
+// ending now
+func (h *ExponentialHistogram) Count() uint64 {
+	return h.CountLastAt(time.Now(), h.window)
+}
+
+// CountLast returns the approximate number of events of the last _window_. Windows longer
+// than the one of the ExponentialHistogram are shortened to it, as the older events are
+// dropped.
+func (h *ExponentialHistogram) CountLast(window time.Duration) uint64 {
+	return h.CountLastAt(time.Now(), window)
+}
+
+// CountLastAt returns the approximate number of events of the _window_ ending at _now_
+func (h *ExponentialHistogram) CountLastAt(now time.Time, window time.Duration) uint64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if window > h.window {
+		window = h.window
+	}
+	end := now.UnixNano()
+	start := end - int64(window)
+	var total, oldest uint64
+	for i := len(h.buckets) - 1; i >= 0; i-- {
+		bucket := h.buckets[i]
+		if bucket.Timestamp <= start {
+			break
+		}
+		if bucket.Timestamp > end {
+			continue
+		}
+		total += bucket.Size
+		oldest = bucket.Size
+	}
+	// half of the oldest bucket is assumed to be in the window
+	return total - oldest/2
+}
+
+// Reset removes all the events from the ExponentialHistogram
+func (h *ExponentialHistogram) Reset() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.buckets = h.buckets[:0]
+}
+
+// MemoryUsage returns the estimated number of bytes used in-process by the ExponentialHistogram
+func (h *ExponentialHistogram) MemoryUsage() uint64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return uint64(unsafe.Sizeof(*h)) + uint64(cap(h.buckets))*uint64(unsafe.Sizeof(histogramBucket{}))
+}
+
+// Export JSON marshals the ExponentialHistogram and returns a byte slice containing the data
+func (h *ExponentialHistogram) Export() ([]byte, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return json.Marshal(exponentialHistogramJSON{int64(h.window), h.errorRate, h.buckets})
+}
+
+// Import JSON unmarshals the _data_ into the ExponentialHistogram
+func (h *ExponentialHistogram) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
+	var g exponentialHistogramJSON
+	err = json.Unmarshal(data, &g)
+	if err != nil {
+		return err
+	}
+	err = checkExponentialHistogramParams(time.Duration(g.Window), g.ErrorRate)
+	if err != nil {
+		return fmt.Errorf("gostatix: invalid exponential histogram snapshot, error: %v", err)
+	}
+	for i, bucket := range g.Buckets {
+		if bucket.Size == 0 || bucket.Size&(bucket.Size-1) != 0 {
+			return fmt.Errorf("gostatix: invalid exponential histogram snapshot, bucket size %d isn't a power of 2", bucket.Size)
+		}
+		if i > 0 && (bucket.Size > g.Buckets[i-1].Size || bucket.Timestamp < g.Buckets[i-1].Timestamp) {
+			return fmt.Errorf("gostatix: invalid exponential histogram snapshot, buckets aren't ordered")
+		}
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.window = time.Duration(g.Window)
+	h.errorRate = g.ErrorRate
+	h.maxPerSize = maxBucketsPerSize(g.ErrorRate)
+	h.buckets = g.Buckets
+	return nil
+}
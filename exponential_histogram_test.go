@@ -0,0 +1,136 @@
+package gostatix
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExponentialHistogramCountLast(t *testing.T) {
+	h, err := NewExponentialHistogram(time.Hour, 0.05)
+	if err != nil {
+		t.Fatalf("creation shouldn't error out, error: %v", err)
+	}
+	start := time.Unix(1700000000, 0)
+	// one event per second for 2 hours
+	for i := 0; i < 7200; i++ {
+		h.AddAt(start.Add(time.Duration(i)*time.Second), 1)
+	}
+	now := start.Add(7199 * time.Second)
+	cases := []struct {
+		window time.Duration
+		exact  float64
+	}{
+		{time.Minute, 60},
+		{10 * time.Minute, 600},
+		{time.Hour, 3600},
+		{2 * time.Hour, 3600},
+	}
+	for _, c := range cases {
+		count := h.CountLastAt(now, c.window)
+		if math.Abs(float64(count)-c.exact) > c.exact*h.ErrorRate() {
+			t.Errorf("too much variance in count of last %v; got %d, exact %v", c.window, count, c.exact)
+		}
+	}
+	if len(h.buckets) > 2*h.maxPerSize*12 {
+		t.Errorf("too many buckets kept, got %d", len(h.buckets))
+	}
+	if count := h.CountLastAt(now.Add(2*time.Hour), time.Hour); count != 0 {
+		t.Errorf("events older than the window shouldn't be counted, got %d", count)
+	}
+}
+
+func TestExponentialHistogramAddCount(t *testing.T) {
+	h, _ := NewExponentialHistogram(time.Minute, 0.1)
+	now := time.Unix(1700000000, 0)
+	h.AddAt(now, 5)
+	h.AddAt(now.Add(-time.Second), 3)
+	if count := h.CountLastAt(now, time.Second); count < 7 || count > 8 {
+		t.Errorf("out of order events should be counted with the latest one, got %d", count)
+	}
+	h.AddAt(now.Add(time.Minute), 2)
+	if count := h.CountLastAt(now.Add(time.Minute), time.Minute); count != 2 {
+		t.Errorf("count should only include the events of the window, got %d", count)
+	}
+	h.Reset()
+	if count := h.CountLastAt(now, time.Minute); count != 0 {
+		t.Errorf("count should be 0 after a reset, got %d", count)
+	}
+	if _, err := NewExponentialHistogram(0, 0.1); err == nil {
+		t.Errorf("creation with a zero window should error out")
+	}
+	if _, err := NewExponentialHistogram(time.Minute, 1); err == nil {
+		t.Errorf("creation with an error rate of 1 should error out")
+	}
+}
+
+// addOneByOne adds _count_ events at _timestamp_ to _buckets_ one by one, merging the two
+// oldest buckets of a size as long as there are more than _maxPerSize_ of them
+func addOneByOne(buckets []histogramBucket, maxPerSize int, timestamp int64, count uint64) []histogramBucket {
+	for i := uint64(0); i < count; i++ {
+		buckets = append(buckets, histogramBucket{1, timestamp})
+		end := len(buckets) - 1
+		for end >= 0 {
+			start := end
+			for start > 0 && buckets[start-1].Size == buckets[end].Size {
+				start--
+			}
+			if end-start+1 <= maxPerSize {
+				break
+			}
+			buckets[start+1].Size *= 2
+			buckets = append(buckets[:start], buckets[start+1:]...)
+			end = start
+		}
+	}
+	return buckets
+}
+
+func TestExponentialHistogramAddMerges(t *testing.T) {
+	for _, errorRate := range []float64{0.5, 0.1, 0.05} {
+		h, _ := NewExponentialHistogram(time.Hour, errorRate)
+		var expected []histogramBucket
+		now := time.Unix(1700000000, 0)
+		for i, count := range []uint64{1, 3, 2, 17, 1, 100, 5, 1000, 64, 1, 12345} {
+			now = now.Add(time.Second)
+			h.AddAt(now, count)
+			expected = addOneByOne(expected, h.maxPerSize, now.UnixNano(), count)
+			if !reflect.DeepEqual(h.buckets, expected) {
+				t.Fatalf("adding %d events at once should merge the buckets like adding them one by one, add %d, error rate %v\ngot      %v\nexpected %v", count, i, errorRate, h.buckets, expected)
+			}
+		}
+	}
+	h, _ := NewExponentialHistogram(time.Hour, 0.05)
+	h.Add(1 << 40)
+	if count := h.Count(); math.Abs(float64(count)/(1<<40)-1) > h.ErrorRate() {
+		t.Errorf("count should be about 2^40, got %d", count)
+	}
+	if len(h.buckets) > (h.maxPerSize+1)*41 {
+		t.Errorf("too many buckets kept, got %d", len(h.buckets))
+	}
+}
+
+func TestExponentialHistogramExportImport(t *testing.T) {
+	h, _ := NewExponentialHistogram(time.Hour, 0.05)
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 1000; i++ {
+		h.AddAt(start.Add(time.Duration(i)*time.Second), 2)
+	}
+	data, err := h.Export()
+	if err != nil {
+		t.Fatalf("export shouldn't error out, error: %v", err)
+	}
+	imported, _ := NewExponentialHistogram(time.Minute, 0.5)
+	err = imported.Import(data)
+	if err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	now := start.Add(999 * time.Second)
+	if imported.Window() != time.Hour || imported.CountLastAt(now, time.Hour) != h.CountLastAt(now, time.Hour) {
+		t.Errorf("imported histogram should count like the exported one")
+	}
+	if imported.Import([]byte(`{"w":60,"e":0.1,"b":[{"s":3,"t":1}]}`)) == nil {
+		t.Errorf("import of a bucket size which isn't a power of 2 should error out")
+	}
+}
@@ -0,0 +1,103 @@
+package gostatix
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/kwertop/gostatix/internal/util"
+)
+
+// FalsePositiveReport is the result of measuring the false positive rate of a filter
+// against a stream of known negatives (elements never inserted in the filter)
+// _Queries_ is the number of negatives looked up in the filter
+// _FalsePositives_ is the number of negatives reported present by the filter
+// _Observed_ is the measured false positive rate
+// _Expected_ is the theoretical false positive rate of the filter
+// _StdDev_ is the standard deviation of the measured rate if the filter behaved as expected
+// _Divergence_ is the number of standard deviations by which the observed rate exceeds
+// the expected rate. It's negative if the observed rate is lower than expected.
+type FalsePositiveReport struct {
+	Queries        uint64
+	FalsePositives uint64
+	Observed       float64
+	Expected       float64
+	StdDev         float64
+	Divergence     float64
+}
+
+// Exceeds returns true if the observed false positive rate is higher than the expected
+// rate by more than _sigmas_ standard deviations. A value of 3 to 4 for _sigmas_ is
+// usually enough to tell a misconfigured filter or a broken hash from sampling noise.
+func (r *FalsePositiveReport) Exceeds(sigmas float64) bool {
+	return r.Divergence > sigmas
+}
+
+// String returns a human readable summary of the report
+func (r *FalsePositiveReport) String() string {
+	return fmt.Sprintf("%d false positives in %d queries, observed rate %.6f, expected rate %.6f, divergence %.2f sigma",
+		r.FalsePositives, r.Queries, r.Observed, r.Expected, r.Divergence)
+}
+
+// MeasureFalsePositiveRate looks up every key separated by _delim_ in the _negatives_
+// stream using _lookup_ and compares the rate of positives with the _expected_ rate.
+// All keys in _negatives_ must be known to be absent from the filter being measured.
+func MeasureFalsePositiveRate(lookup func(data []byte) (bool, error), expected float64, negatives io.Reader, delim byte) (*FalsePositiveReport, error) {
+	falsePositives := uint64(0)
+	queries, err := util.ReadKeys(negatives, delim, nil, func(key []byte) error {
+		ok, err := lookup(key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			falsePositives++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while measuring false positive rate, error: %v", err)
+	}
+	if queries == 0 {
+		return nil, fmt.Errorf("gostatix: no negatives found to measure false positive rate")
+	}
+	report := &FalsePositiveReport{
+		Queries:        queries,
+		FalsePositives: falsePositives,
+		Observed:       float64(falsePositives) / float64(queries),
+		Expected:       expected,
+		StdDev:         math.Sqrt(expected * (1 - expected) / float64(queries)),
+	}
+	if report.StdDev > 0 {
+		report.Divergence = (report.Observed - report.Expected) / report.StdDev
+	} else if report.Observed > report.Expected {
+		report.Divergence = math.Inf(1)
+	}
+	return report, nil
+}
+
+// MeasureFalsePositiveRate measures the false positive rate of the bloom filter by looking
+// up the keys separated by _delim_ in the _negatives_ stream. The expected rate is the
+// probability of all the hashed bits of an absent element being set, FillRatio()^numHashes,
+// for the current state of the filter.
+func (bloomFilter *BloomFilter) MeasureFalsePositiveRate(negatives io.Reader, delim byte) (*FalsePositiveReport, error) {
+	expected := math.Pow(bloomFilter.FillRatio(), float64(bloomFilter.numHashes))
+	return MeasureFalsePositiveRate(func(data []byte) (bool, error) {
+		return bloomFilter.Lookup(data), nil
+	}, expected, negatives, delim)
+}
+
+// MeasureFalsePositiveRate measures the false positive rate of the Cuckoo Filter by looking
+// up the keys separated by _delim_ in the _negatives_ stream. The expected rate is the
+// one returned by EstimatedPositiveRate for the current occupancy of the filter.
+func (cuckooFilter *CuckooFilter) MeasureFalsePositiveRate(negatives io.Reader, delim byte) (*FalsePositiveReport, error) {
+	return MeasureFalsePositiveRate(func(data []byte) (bool, error) {
+		return cuckooFilter.Lookup(data), nil
+	}, cuckooFilter.EstimatedPositiveRate(), negatives, delim)
+}
+
+// MeasureFalsePositiveRate measures the false positive rate of the CuckooFilterRedis by
+// looking up the keys separated by _delim_ in the _negatives_ stream. The expected rate
+// is the one returned by EstimatedPositiveRate for the current occupancy of the filter.
+func (cuckooFilter *CuckooFilterRedis) MeasureFalsePositiveRate(negatives io.Reader, delim byte) (*FalsePositiveReport, error) {
+	return MeasureFalsePositiveRate(cuckooFilter.Lookup, cuckooFilter.EstimatedPositiveRate(), negatives, delim)
+}
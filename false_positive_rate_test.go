@@ -0,0 +1,68 @@
+package gostatix
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func negativeKeys(from, to int) *strings.Reader {
+	var keys strings.Builder
+	for i := from; i < to; i++ {
+		keys.WriteString("negative-")
+		keys.WriteString(strconv.Itoa(i))
+		keys.WriteByte('\n')
+	}
+	return strings.NewReader(keys.String())
+}
+
+func TestBloomMeasureFalsePositiveRate(t *testing.T) {
+	filter, _ := NewMemBloomFilterWithParameters(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		filter.InsertString(strconv.Itoa(i))
+	}
+	report, err := filter.MeasureFalsePositiveRate(negativeKeys(0, 100000), '\n')
+	if err != nil {
+		t.Fatalf("measuring false positive rate shouldn't error out, error: %v", err)
+	}
+	if report.Queries != 100000 {
+		t.Errorf("queries should be 100000, found %d", report.Queries)
+	}
+	if report.Expected > 0.011 {
+		t.Errorf("expected rate %v should be close to the configured error rate 0.01", report.Expected)
+	}
+	if report.Exceeds(4) {
+		t.Errorf("observed rate shouldn't diverge from the expected rate, %s", report)
+	}
+}
+
+func TestMeasureFalsePositiveRateDivergence(t *testing.T) {
+	lookups := 0
+	brokenLookup := func(data []byte) (bool, error) {
+		lookups++
+		return lookups%10 == 0, nil
+	}
+	report, _ := MeasureFalsePositiveRate(brokenLookup, 0.01, negativeKeys(0, 1000), '\n')
+	if report.FalsePositives != 100 || report.Observed != 0.1 {
+		t.Errorf("100 false positives should be observed, found %d", report.FalsePositives)
+	}
+	if !report.Exceeds(4) {
+		t.Errorf("observed rate should diverge from the expected rate, %s", report)
+	}
+}
+
+func TestMeasureFalsePositiveRateErrors(t *testing.T) {
+	_, err := MeasureFalsePositiveRate(func(data []byte) (bool, error) {
+		return false, nil
+	}, 0.01, strings.NewReader(""), '\n')
+	if err == nil {
+		t.Error("should error out as there are no negatives")
+	}
+	_, err = MeasureFalsePositiveRate(func(data []byte) (bool, error) {
+		return false, errors.New("lookup failed")
+	}, 0.01, negativeKeys(0, 10), '\n')
+	if err == nil {
+		t.Error("should error out as the lookup fails")
+	}
+}
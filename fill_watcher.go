@@ -0,0 +1,194 @@
+package gostatix
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultFillCheckEvery is the number of inserts between two checks of a FillWatcher if
+// FillAlarmConfig.CheckEvery isn't set
+const defaultFillCheckEvery = 1024
+
+// FillAlarm is raised by a FillWatcher when a bloom filter crosses one of its thresholds
+// _FillRatio_ is the fraction of bits set in the bitset of the filter
+// _PositiveRate_ is the estimated false positive rate of the filter, as returned by
+// BloomPositiveRate
+type FillAlarm struct {
+	FillRatio    float64
+	PositiveRate float64
+}
+
+// FillAlarmConfig configures a FillWatcher created with WatchFill
+// _FillRatio_ raises the alarm when the fraction of bits set reaches it, 0 disables it
+// _PositiveRate_ raises the alarm when the estimated false positive rate reaches it, 0
+// disables it
+// _CheckEvery_ is the number of inserts between two checks, 1024 if 0. A check counts the
+// bits set, which is a BITCOUNT for a Redis backed filter.
+// _OnAlarm_ (optional, can be nil) is called with the alarm when a threshold is crossed
+// _OnError_ (optional, can be nil) is called with the errors of the checks
+type FillAlarmConfig struct {
+	FillRatio    float64
+	PositiveRate float64
+	CheckEvery   uint64
+	OnAlarm      func(FillAlarm)
+	OnError      func(error)
+}
+
+// FillWatcher monitors the fill ratio and the estimated false positive rate of a bloom
+// filter after its inserts, e.g. to rotate the filter instead of polling BloomPositiveRate.
+// The alarm is raised once when a threshold is crossed, and rearmed when the filter goes
+// back below the thresholds, e.g. after a Reset. Alarms are passed to OnAlarm after the
+// insert which raised them, and sent on C if it has room, so they can be received from
+// another goroutine. The bitset changes made through another BloomFilter, like the inserts
+// of other processes in a Redis backed filter, are seen at the next check.
+type FillWatcher struct {
+	// C receives the alarms. It's buffered with room for one alarm, alarms raised while
+	// it's full are only passed to OnAlarm. It's closed by Close.
+	C <-chan FillAlarm
+
+	filter  *BloomFilter
+	config  FillAlarmConfig
+	inserts atomic.Uint64
+	alarms  chan FillAlarm
+	lock    sync.Mutex
+	raised  bool
+	closed  bool
+}
+
+// WatchFill returns a FillWatcher raising an alarm when the bloom filter crosses the
+// thresholds of _config_. The watcher is closed along with the filter.
+func (bloomFilter *BloomFilter) WatchFill(config FillAlarmConfig) (*FillWatcher, error) {
+	if config.FillRatio < 0 || config.FillRatio > 1 || config.PositiveRate < 0 || config.PositiveRate > 1 {
+		return nil, fmt.Errorf("gostatix: fill alarm thresholds should be between 0 and 1")
+	}
+	if config.FillRatio == 0 && config.PositiveRate == 0 {
+		return nil, fmt.Errorf("gostatix: fill alarm needs a fill ratio or a positive rate threshold")
+	}
+	if config.CheckEvery == 0 {
+		config.CheckEvery = defaultFillCheckEvery
+	}
+	alarms := make(chan FillAlarm, 1)
+	w := &FillWatcher{C: alarms, filter: bloomFilter, config: config, alarms: alarms}
+	err := bloomFilter.resources.attach(w)
+	if err != nil {
+		return nil, err
+	}
+	bloomFilter.watchersLock.Lock()
+	defer bloomFilter.watchersLock.Unlock()
+
+	var watchers []*FillWatcher
+	if current := bloomFilter.watchers.Load(); current != nil {
+		watchers = append(watchers, *current...)
+	}
+	watchers = append(watchers, w)
+	bloomFilter.watchers.Store(&watchers)
+	return w, nil
+}
+
+// Check checks the thresholds now, regardless of the number of inserts since the last
+// check, and returns the alarm raised if any
+func (w *FillWatcher) Check() (*FillAlarm, error) {
+	alarm, err := w.check()
+	if err != nil {
+		return nil, err
+	}
+	if alarm != nil && w.config.OnAlarm != nil {
+		w.config.OnAlarm(*alarm)
+	}
+	return alarm, nil
+}
+
+// check computes the fill of the filter and raises the alarm if a threshold is crossed
+// for the first time since the watcher was armed
+func (w *FillWatcher) check() (*FillAlarm, error) {
+	bloomFilter := w.filter
+	if bloomFilter.needsLock() {
+		bloomFilter.lock.RLock()
+	}
+	length, err := bloomFilter.filter.bitCount()
+	fill := FillAlarm{
+		FillRatio:    float64(length) / float64(bloomFilter.size),
+		PositiveRate: bloomFilter.positiveRate(length),
+	}
+	if bloomFilter.needsLock() {
+		bloomFilter.lock.RUnlock()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while checking fill of bloom filter, error: %v", err)
+	}
+	exceeded := (w.config.FillRatio > 0 && fill.FillRatio >= w.config.FillRatio) ||
+		(w.config.PositiveRate > 0 && fill.PositiveRate >= w.config.PositiveRate)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed || exceeded == w.raised {
+		return nil, nil
+	}
+	w.raised = exceeded
+	if !exceeded {
+		return nil, nil
+	}
+	select {
+	case w.alarms <- fill:
+	default:
+	}
+	return &fill, nil
+}
+
+// inserted counts _inserts_ inserts in the filter and checks the thresholds if the number
+// of inserts since the last check reaches CheckEvery, or if _inserts_ is 0, which means
+// that the bitset was changed otherwise
+func (w *FillWatcher) inserted(inserts uint64) {
+	if inserts > 0 {
+		total := w.inserts.Add(inserts)
+		if total/w.config.CheckEvery == (total-inserts)/w.config.CheckEvery {
+			return
+		}
+	}
+	_, err := w.Check()
+	if err != nil && w.config.OnError != nil {
+		w.config.OnError(err)
+	}
+}
+
+// Close stops the FillWatcher and closes C. It's safe to call Close multiple times.
+func (w *FillWatcher) Close() error {
+	bloomFilter := w.filter
+	bloomFilter.watchersLock.Lock()
+	if current := bloomFilter.watchers.Load(); current != nil {
+		watchers := make([]*FillWatcher, 0, len(*current))
+		for _, watcher := range *current {
+			if watcher != w {
+				watchers = append(watchers, watcher)
+			}
+		}
+		bloomFilter.watchers.Store(&watchers)
+	}
+	bloomFilter.watchersLock.Unlock()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if !w.closed {
+		w.closed = true
+		close(w.alarms)
+	}
+	return nil
+}
+
+// notifyFillWatchers passes _inserts_ inserts to the watchers of the bloom filter, or
+// makes them check the thresholds if _inserts_ is 0. It must be called without holding
+// the lock of the filter, so that the alarm callbacks can use the filter. As it's called
+// after every update, it also records the time of the update.
+func (bloomFilter *BloomFilter) notifyFillWatchers(inserts uint64) {
+	bloomFilter.updated.touch()
+	watchers := bloomFilter.watchers.Load()
+	if watchers == nil {
+		return
+	}
+	for _, w := range *watchers {
+		w.inserted(inserts)
+	}
+}
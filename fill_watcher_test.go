@@ -0,0 +1,94 @@
+package gostatix
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestFillWatcher(t *testing.T) {
+	filter, _ := NewMemBloomFilterWithParameters(100, 0.01)
+	var alarms []FillAlarm
+	w, err := filter.WatchFill(FillAlarmConfig{
+		PositiveRate: 0.01,
+		CheckEvery:   1,
+		OnAlarm:      func(alarm FillAlarm) { alarms = append(alarms, alarm) },
+	})
+	if err != nil {
+		t.Fatalf("watch shouldn't error out, error: %v", err)
+	}
+	for i := 0; i < 300; i++ {
+		filter.InsertString(strconv.Itoa(i))
+	}
+	if len(alarms) != 1 {
+		t.Fatalf("alarm should be raised once, got %d alarms", len(alarms))
+	}
+	if alarms[0].PositiveRate < 0.01 || alarms[0].FillRatio <= 0 {
+		t.Errorf("alarm should hold the crossed positive rate, got %+v", alarms[0])
+	}
+	select {
+	case alarm := <-w.C:
+		if alarm != alarms[0] {
+			t.Errorf("alarm sent on C should be the one passed to OnAlarm, got %+v", alarm)
+		}
+	default:
+		t.Errorf("alarm should be sent on C")
+	}
+
+	filter.Reset()
+	filter.InsertString("foo")
+	if len(alarms) != 1 {
+		t.Errorf("alarm shouldn't be raised below the thresholds, got %d alarms", len(alarms))
+	}
+	for i := 0; i < 300; i++ {
+		filter.InsertString(strconv.Itoa(i))
+	}
+	if len(alarms) != 2 {
+		t.Errorf("alarm should be raised again after a reset, got %d alarms", len(alarms))
+	}
+
+	filter.Close()
+	// the second alarm is still buffered in C
+	if _, ok := <-w.C; ok {
+		if _, ok := <-w.C; ok {
+			t.Errorf("C should be closed along with the filter")
+		}
+	}
+	if _, err := filter.WatchFill(FillAlarmConfig{FillRatio: 0.5}); err == nil {
+		t.Errorf("watch of a closed filter should error out")
+	}
+}
+
+func TestFillWatcherRedis(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewRedisBloomFilterWithParameters(100, 0.01)
+	rotations := 0
+	w, err := filter.WatchFill(FillAlarmConfig{
+		FillRatio:  0.4,
+		CheckEvery: 10,
+		OnAlarm: func(alarm FillAlarm) {
+			rotations++
+			// the callback can use the filter, e.g. to rotate it
+			filter.Reset()
+		},
+	})
+	if err != nil {
+		t.Fatalf("watch shouldn't error out, error: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		filter.InsertString(strconv.Itoa(i))
+	}
+	if rotations < 2 {
+		t.Errorf("filter should be rotated more than once, got %d rotations", rotations)
+	}
+	if fill := filter.FillRatio(); fill >= 0.4 {
+		t.Errorf("filter should be rotated before reaching the fill ratio, got %v", fill)
+	}
+	w.Close()
+	w.Close()
+	if _, err := filter.WatchFill(FillAlarmConfig{}); err == nil {
+		t.Errorf("watch without thresholds should error out")
+	}
+	if _, err := filter.WatchFill(FillAlarmConfig{FillRatio: 2}); err == nil {
+		t.Errorf("watch with a threshold greater than 1 should error out")
+	}
+}
@@ -0,0 +1,209 @@
+package gostatix
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func bloomFilterSeed() *BloomFilter {
+	filter, _ := NewMemBloomFilterWithParameters(100, 0.01)
+	for i := 0; i < 10; i++ {
+		filter.InsertString(strconv.Itoa(i))
+	}
+	return filter
+}
+
+func cuckooFilterSeed() *CuckooFilter {
+	filter, _ := NewCuckooFilter(20, 2, 3)
+	for i := 0; i < 10; i++ {
+		filter.Insert([]byte(strconv.Itoa(i)), false)
+	}
+	return filter
+}
+
+func countMinSketchSeed() *CountMinSketch {
+	cms, _ := NewCountMinSketch(3, 20)
+	for i := 0; i < 10; i++ {
+		cms.Update([]byte(strconv.Itoa(i)), uint64(i+1))
+	}
+	return cms
+}
+
+func hyperLogLogSeed() *HyperLogLog {
+	h, _ := NewHyperLogLog(16)
+	for i := 0; i < 10; i++ {
+		h.Update([]byte(strconv.Itoa(i)))
+	}
+	return h
+}
+
+func topKSeed() *TopK {
+	topk, _ := NewTopK(3, 0.1, 0.9)
+	for i := 0; i < 10; i++ {
+		topk.Insert([]byte(strconv.Itoa(i%4)), 1)
+	}
+	return topk
+}
+
+// addSeeds adds the binary snapshot written by _writeTo_ along with a few
+// truncated versions of it to the seed corpus of _f_
+func addSeeds(f *testing.F, writeTo func(*bytes.Buffer) error) {
+	var buff bytes.Buffer
+	err := writeTo(&buff)
+	if err != nil {
+		f.Fatalf("error writing seed, error: %v", err)
+	}
+	data := buff.Bytes()
+	f.Add(data)
+	f.Add(data[:len(data)/2])
+	f.Add(data[:8])
+	f.Add([]byte{})
+}
+
+func FuzzBloomFilterReadFrom(f *testing.F) {
+	addSeeds(f, func(buff *bytes.Buffer) error {
+		_, err := bloomFilterSeed().WriteTo(buff)
+		return err
+	})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		filter := &BloomFilter{}
+		_, err := filter.ReadFrom(bytes.NewReader(data))
+		if err == nil {
+			filter.Lookup([]byte("0"))
+		}
+	})
+}
+
+func FuzzBloomFilterImport(f *testing.F) {
+	addSeeds(f, func(buff *bytes.Buffer) error {
+		data, err := bloomFilterSeed().Export()
+		buff.Write(data)
+		return err
+	})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		filter, _ := NewMemBloomFilterWithParameters(10, 0.1)
+		err := filter.Import(data)
+		if err == nil {
+			filter.Lookup([]byte("0"))
+		}
+	})
+}
+
+func FuzzCuckooFilterReadFrom(f *testing.F) {
+	addSeeds(f, func(buff *bytes.Buffer) error {
+		_, err := cuckooFilterSeed().WriteTo(buff)
+		return err
+	})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		filter, _ := NewCuckooFilter(1, 1, 1)
+		_, err := filter.ReadFrom(bytes.NewReader(data))
+		if err == nil {
+			filter.Lookup([]byte("0"))
+		}
+	})
+}
+
+func FuzzCuckooFilterImport(f *testing.F) {
+	addSeeds(f, func(buff *bytes.Buffer) error {
+		data, err := cuckooFilterSeed().Export()
+		buff.Write(data)
+		return err
+	})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		filter, _ := NewCuckooFilter(1, 1, 1)
+		err := filter.Import(data)
+		if err == nil {
+			filter.Lookup([]byte("0"))
+		}
+	})
+}
+
+func FuzzCountMinSketchReadFrom(f *testing.F) {
+	addSeeds(f, func(buff *bytes.Buffer) error {
+		_, err := countMinSketchSeed().WriteTo(buff)
+		return err
+	})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cms, _ := NewCountMinSketch(1, 1)
+		_, err := cms.ReadFrom(bytes.NewReader(data))
+		if err == nil {
+			cms.Count([]byte("0"))
+		}
+	})
+}
+
+func FuzzCountMinSketchImport(f *testing.F) {
+	addSeeds(f, func(buff *bytes.Buffer) error {
+		data, err := countMinSketchSeed().Export()
+		buff.Write(data)
+		return err
+	})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cms, _ := NewCountMinSketch(1, 1)
+		err := cms.Import(data)
+		if err == nil {
+			cms.Count([]byte("0"))
+		}
+	})
+}
+
+func FuzzHyperLogLogReadFrom(f *testing.F) {
+	addSeeds(f, func(buff *bytes.Buffer) error {
+		_, err := hyperLogLogSeed().WriteTo(buff)
+		return err
+	})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, _ := NewHyperLogLog(1)
+		_, err := h.ReadFrom(bytes.NewReader(data))
+		if err == nil {
+			h.Count(true, true)
+		}
+	})
+}
+
+func FuzzHyperLogLogImport(f *testing.F) {
+	addSeeds(f, func(buff *bytes.Buffer) error {
+		data, err := hyperLogLogSeed().Export()
+		buff.Write(data)
+		return err
+	})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, _ := NewHyperLogLog(1)
+		err := h.Import(data)
+		if err == nil {
+			h.Count(true, true)
+		}
+	})
+}
+
+func FuzzTopKReadFrom(f *testing.F) {
+	addSeeds(f, func(buff *bytes.Buffer) error {
+		_, err := topKSeed().WriteTo(buff)
+		return err
+	})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		topk, _ := NewTopK(1, 0.1, 0.9)
+		_, err := topk.ReadFrom(bytes.NewReader(data))
+		if err == nil {
+			topk.Insert([]byte("0"), 1)
+			topk.Values()
+		}
+	})
+}
+
+func FuzzTopKImport(f *testing.F) {
+	addSeeds(f, func(buff *bytes.Buffer) error {
+		data, err := topKSeed().Export()
+		buff.Write(data)
+		return err
+	})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		topk, _ := NewTopK(1, 0.1, 0.9)
+		err := topk.Import(data)
+		if err == nil {
+			topk.Insert([]byte("0"), 1)
+			topk.Values()
+		}
+	})
+}
@@ -0,0 +1,118 @@
+/*
+Package gostatixtest provides helpers for testing code which uses gostatix data structures.
+
+SetupMiniredis starts an in-process Redis server (https://github.com/alicebob/miniredis)
+and points the gostatix Redis client to it, so that the Redis backed data structures can
+be used in tests without a running Redis. Equal and AssertEqual compare two data structures
+of the same type using their Equals methods.
+*/
+package gostatixtest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/kwertop/gostatix"
+)
+
+var (
+	once     sync.Once
+	server   *miniredis.Miniredis
+	startErr error
+)
+
+// SetupMiniredis starts an in-process miniredis server the first time it's called and
+// points the gostatix Redis client to it. The gostatix Redis client can only be created
+// once per process, so SetupMiniredis must be called before anything else calls
+// gostatix.MakeRedisClient and the same server is shared by all the tests of a package.
+// The server is flushed before the test starts and again when it finishes, so tests
+// using it shouldn't run in parallel.
+func SetupMiniredis(t testing.TB) *miniredis.Miniredis {
+	t.Helper()
+	once.Do(func() {
+		server, startErr = miniredis.Run()
+		if startErr == nil {
+			gostatix.MakeRedisClient(gostatix.RedisConnOptions{Address: server.Addr()})
+		}
+	})
+	if startErr != nil {
+		t.Fatalf("gostatixtest: error while starting miniredis, error: %v", startErr)
+	}
+	server.FlushAll()
+	t.Cleanup(server.FlushAll)
+	return server
+}
+
+// Equal returns true if _expected_ and _actual_ are equal as per the Equals method of
+// their type. Both should be pointers to the same gostatix data structure type.
+func Equal(expected, actual interface{}) (bool, error) {
+	switch e := expected.(type) {
+	case *gostatix.BloomFilter:
+		if a, ok := actual.(*gostatix.BloomFilter); ok {
+			return e.Equals(a)
+		}
+	case *gostatix.CuckooFilter:
+		if a, ok := actual.(*gostatix.CuckooFilter); ok {
+			return e.Equals(a)
+		}
+	case *gostatix.CuckooFilterRedis:
+		if a, ok := actual.(*gostatix.CuckooFilterRedis); ok {
+			return e.Equals(a)
+		}
+	case *gostatix.CountMinSketch:
+		if a, ok := actual.(*gostatix.CountMinSketch); ok {
+			return e.Equals(a)
+		}
+	case *gostatix.CountMinSketchRedis:
+		if a, ok := actual.(*gostatix.CountMinSketchRedis); ok {
+			return e.Equals(a)
+		}
+	case *gostatix.HyperLogLog:
+		if a, ok := actual.(*gostatix.HyperLogLog); ok {
+			return e.Equals(a)
+		}
+	case *gostatix.HyperLogLogRedis:
+		if a, ok := actual.(*gostatix.HyperLogLogRedis); ok {
+			return e.Equals(a)
+		}
+	case *gostatix.TopK:
+		if a, ok := actual.(*gostatix.TopK); ok {
+			return e.Equals(a)
+		}
+	case *gostatix.TopKRedis:
+		if a, ok := actual.(*gostatix.TopKRedis); ok {
+			return e.Equals(a)
+		}
+	default:
+		return false, fmt.Errorf("gostatixtest: unsupported type %T", expected)
+	}
+	return false, fmt.Errorf("gostatixtest: can't compare %T with %T", expected, actual)
+}
+
+// AssertEqual fails the test if _expected_ and _actual_ aren't equal as per Equal
+func AssertEqual(t testing.TB, expected, actual interface{}) {
+	t.Helper()
+	ok, err := Equal(expected, actual)
+	if err != nil {
+		t.Errorf("gostatixtest: error while comparing %T, error: %v", expected, err)
+		return
+	}
+	if !ok {
+		t.Errorf("gostatixtest: %T should be equal", expected)
+	}
+}
+
+// AssertNotEqual fails the test if _expected_ and _actual_ are equal as per Equal
+func AssertNotEqual(t testing.TB, expected, actual interface{}) {
+	t.Helper()
+	ok, err := Equal(expected, actual)
+	if err != nil {
+		t.Errorf("gostatixtest: error while comparing %T, error: %v", expected, err)
+		return
+	}
+	if ok {
+		t.Errorf("gostatixtest: %T shouldn't be equal", expected)
+	}
+}
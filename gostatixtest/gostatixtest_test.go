@@ -0,0 +1,57 @@
+package gostatixtest
+
+import (
+	"testing"
+
+	"github.com/kwertop/gostatix"
+)
+
+func TestSetupMiniredis(t *testing.T) {
+	server := SetupMiniredis(t)
+	filter, err := gostatix.NewRedisBloomFilterWithParameters(100, 0.01)
+	if err != nil {
+		t.Fatalf("redis backed filter should be created, error: %v", err)
+	}
+	filter.InsertString("cat")
+	if !filter.LookupString("cat") {
+		t.Error("cat should be present in the filter")
+	}
+	if !server.Exists(filter.GetMetadataKey()) {
+		t.Error("filter metadata should be saved in miniredis")
+	}
+}
+
+func TestSetupMiniredisFlushes(t *testing.T) {
+	server := SetupMiniredis(t)
+	if len(server.Keys()) != 0 {
+		t.Errorf("miniredis should be flushed before the test, found keys %v", server.Keys())
+	}
+}
+
+func TestAssertEqual(t *testing.T) {
+	SetupMiniredis(t)
+	a, _ := gostatix.NewHyperLogLogRedis(16)
+	b, _ := gostatix.NewHyperLogLogRedis(16)
+	a.Update([]byte("cat"))
+	b.Update([]byte("cat"))
+	AssertEqual(t, a, b)
+	b.Update([]byte("dog"))
+	AssertNotEqual(t, a, b)
+
+	c, _ := gostatix.NewCuckooFilter(10, 2, 3)
+	d, _ := gostatix.NewCuckooFilter(10, 2, 3)
+	AssertEqual(t, c, d)
+}
+
+func TestEqualUnsupported(t *testing.T) {
+	cms, _ := gostatix.NewCountMinSketch(2, 10)
+	h, _ := gostatix.NewHyperLogLog(16)
+	_, err := Equal(cms, h)
+	if err == nil {
+		t.Error("should error out as the types are different")
+	}
+	_, err = Equal("cms", "cms")
+	if err == nil {
+		t.Error("should error out as the type isn't supported")
+	}
+}
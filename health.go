@@ -0,0 +1,135 @@
+package gostatix
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kwertop/gostatix/internal/util"
+)
+
+// HealthReport is the result of a successful HealthCheck
+// _Ping_ is the round trip time of a PING
+// _Write_ and _Read_ are the latencies of writing and reading back a temporary key
+// _ScriptsLoaded_ is the number of Lua scripts which weren't cached by Redis and were
+// loaded by the check
+type HealthReport struct {
+	Ping          time.Duration
+	Write         time.Duration
+	Read          time.Duration
+	ScriptsLoaded int
+}
+
+// healthCheckTTL bounds the lifetime of the temporary key written by HealthCheck in
+// case it isn't deleted
+const healthCheckTTL = 10 * time.Second
+
+// HealthCheck verifies that the Redis used by the Redis backed data structures is
+// reachable and serving reads and writes, and loads the Lua scripts which Redis hasn't
+// cached yet. It can be used as the readiness probe of a service before accepting traffic,
+// _ctx_ bounds the time spent in the check.
+func HealthCheck(ctx context.Context) (*HealthReport, error) {
+	client := getRedisClient()
+	if client == nil {
+		return nil, fmt.Errorf("gostatix: redis client isn't initialized, call MakeRedisClient first")
+	}
+	report := &HealthReport{}
+
+	start := time.Now()
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while pinging redis, error: %v", err)
+	}
+	report.Ping = time.Since(start)
+
+	key := "gostatix:health:" + util.GenerateRandomString(16)
+	value := util.GenerateRandomString(16)
+	start = time.Now()
+	err = client.Set(ctx, key, value, healthCheckTTL).Err()
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while writing to redis, error: %v", err)
+	}
+	report.Write = time.Since(start)
+	start = time.Now()
+	read, err := client.Get(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while reading from redis, error: %v", err)
+	}
+	report.Read = time.Since(start)
+	client.Del(ctx, key)
+	if read != value {
+		return nil, fmt.Errorf("gostatix: redis returned %q for key %s instead of %q", read, key, value)
+	}
+
+	hashes := make([]string, len(redisScripts))
+	for i, script := range redisScripts {
+		hashes[i] = script.Hash()
+	}
+	exists, err := client.ScriptExists(ctx, hashes...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while checking lua scripts in redis, error: %v", err)
+	}
+	for i, script := range redisScripts {
+		if exists[i] {
+			continue
+		}
+		err = script.Load(ctx, client).Err()
+		if err != nil {
+			return nil, fmt.Errorf("gostatix: error while loading lua script %s in redis, error: %v", hashes[i], err)
+		}
+		report.ScriptsLoaded++
+	}
+	return report, nil
+}
+
+// healthCheckStructure runs HealthCheck and checks that the metadata of the data
+// structure of type _kind_ at _metadataKey_ is still in Redis and intact
+func healthCheckStructure(ctx context.Context, metadataKey, kind string) (*HealthReport, error) {
+	report, err := HealthCheck(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, err = loadMetadataContext(ctx, metadataKey, kind)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// HealthCheck runs the package HealthCheck and checks the metadata of a Redis backed
+// Bloom filter. An in-memory Bloom filter is always healthy and gets an empty report.
+func (bloomFilter *BloomFilter) HealthCheck(ctx context.Context) (*HealthReport, error) {
+	if bloomFilter.metadataKey == "" {
+		return &HealthReport{}, nil
+	}
+	return healthCheckStructure(ctx, bloomFilter.metadataKey, "bloom")
+}
+
+// HealthCheck runs the package HealthCheck and checks the metadata of the filter
+func (cuckooFilter *CuckooFilterRedis) HealthCheck(ctx context.Context) (*HealthReport, error) {
+	return healthCheckStructure(ctx, cuckooFilter.metadataKey, "cuckoo")
+}
+
+// HealthCheck runs the package HealthCheck and checks the metadata of the sketch
+func (cms *CountMinSketchRedis) HealthCheck(ctx context.Context) (*HealthReport, error) {
+	return healthCheckStructure(ctx, cms.metadataKey, "cms")
+}
+
+// HealthCheck runs the package HealthCheck and checks the metadata of the HyperLogLog
+func (h *HyperLogLogRedis) HealthCheck(ctx context.Context) (*HealthReport, error) {
+	return healthCheckStructure(ctx, h.metadataKey, "hll")
+}
+
+// HealthCheck runs the package HealthCheck and checks the metadata of the TopKRedis and
+// of its Count-Min sketch
+func (t *TopKRedis) HealthCheck(ctx context.Context) (*HealthReport, error) {
+	report, err := healthCheckStructure(ctx, t.metadataKey, "topk")
+	if err != nil {
+		return nil, err
+	}
+	_, err = loadMetadataContext(ctx, t.sketch.metadataKey, "cms")
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
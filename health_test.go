@@ -0,0 +1,63 @@
+package gostatix
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHealthCheck(t *testing.T) {
+	initMockRedis()
+	ctx := context.Background()
+	err := getRedisClient().ScriptFlush(ctx).Err()
+	if err != nil {
+		t.Fatalf("script flush shouldn't error out, error: %v", err)
+	}
+	report, err := HealthCheck(ctx)
+	if err != nil {
+		t.Fatalf("health check shouldn't error out, error: %v", err)
+	}
+	if report.ScriptsLoaded != len(redisScripts) {
+		t.Errorf("health check should load %d scripts after a flush, loaded %d", len(redisScripts), report.ScriptsLoaded)
+	}
+	report, err = HealthCheck(ctx)
+	if err != nil {
+		t.Fatalf("health check shouldn't error out, error: %v", err)
+	}
+	if report.ScriptsLoaded != 0 {
+		t.Errorf("health check shouldn't reload cached scripts, loaded %d", report.ScriptsLoaded)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = HealthCheck(cancelled)
+	if err == nil {
+		t.Errorf("health check should error out with a cancelled context")
+	}
+}
+
+func TestStructureHealthCheck(t *testing.T) {
+	initMockRedis()
+	ctx := context.Background()
+	filter, _ := NewRedisBloomFilterWithParameters(100, 0.01)
+	_, err := filter.HealthCheck(ctx)
+	if err != nil {
+		t.Errorf("bloom filter health check shouldn't error out, error: %v", err)
+	}
+	memFilter, _ := NewMemBloomFilterWithParameters(100, 0.01)
+	_, err = memFilter.HealthCheck(ctx)
+	if err != nil {
+		t.Errorf("in-memory bloom filter health check shouldn't error out, error: %v", err)
+	}
+	topk, _ := NewTopKRedis(10, 0.001, 0.999)
+	_, err = topk.HealthCheck(ctx)
+	if err != nil {
+		t.Errorf("topk health check shouldn't error out, error: %v", err)
+	}
+
+	h, _ := NewHyperLogLogRedis(16)
+	getRedisClient().Del(ctx, h.MetadataKey())
+	_, err = h.HealthCheck(ctx)
+	if err == nil {
+		t.Errorf("hyperloglog health check should error out without metadata")
+	}
+}
@@ -16,8 +16,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"math"
 	"sync"
+	"unsafe"
 
 	"github.com/kwertop/gostatix/internal/util"
 )
@@ -27,25 +27,40 @@ import (
 // at different hashed locations
 // _numRegisters_ is used to specify the size of the _registers_ slice
 // _lock_ is used to synchronize concurrent read/writes
+// _updated_ records the time of the last update
 type HyperLogLog struct {
 	AbstractHyperLogLog
 	registers []uint8
 	lock      sync.RWMutex
+	resources resources
+	updated   updateClock
 }
 
-// NewHyperLogLog creates new HyperLogLog with the specified _numRegisters_
+// NewHyperLogLog creates new HyperLogLog with the specified _numRegisters_, counting with
+// the LegacyEstimator
 func NewHyperLogLog(numRegisters uint64) (*HyperLogLog, error) {
-	registers := make([]uint8, numRegisters)
-	abstractLog, err := makeAbstractHyperLogLog(numRegisters)
+	return NewHyperLogLogWithEstimator(numRegisters, LegacyEstimator)
+}
+
+// NewHyperLogLogWithEstimator creates new HyperLogLog with the specified _numRegisters_,
+// counting its distinct elements with _estimator_. The estimator is fixed at creation since
+// it decides how the elements update the registers.
+func NewHyperLogLogWithEstimator(numRegisters uint64, estimator HyperLogLogEstimator) (*HyperLogLog, error) {
+	abstractLog, err := makeAbstractHyperLogLog(numRegisters, estimator)
 	if err != nil {
 		return nil, err
 	}
+	registers := make([]uint8, numRegisters)
 	h := &HyperLogLog{AbstractHyperLogLog: *abstractLog, registers: registers}
 	return h, nil
 }
 
 // Reset sets all values in the _registers_ slice to zero
 func (h *HyperLogLog) Reset() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.updated.touch()
+
 	for i := range h.registers {
 		h.registers[i] = 0
 	}
@@ -56,64 +71,214 @@ func (h *HyperLogLog) Reset() {
 func (h *HyperLogLog) Update(data []byte) {
 	h.lock.Lock()
 	defer h.lock.Unlock()
+	h.updated.touch()
 
 	registerIndex, count := h.getRegisterIndexAndCount(data)
 	h.registers[registerIndex] = uint8(util.Max(uint(h.registers[registerIndex]), uint(count)))
 }
 
+// UpdateMulti updates the HyperLogLog with all the elements of _data_ under a single lock,
+// like calling Update with each of them
+func (h *HyperLogLog) UpdateMulti(data [][]byte) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.updated.touch()
+
+	for _, element := range data {
+		registerIndex, count := h.getRegisterIndexAndCount(element)
+		h.registers[registerIndex] = uint8(util.Max(uint(h.registers[registerIndex]), uint(count)))
+	}
+}
+
+// updateHashes updates the HyperLogLog with the elements whose hashes (see getHashes) are
+// _hashes_ under a single lock, like UpdateMulti without hashing them again
+func (h *HyperLogLog) updateHashes(hashes [][4]uint64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.updated.touch()
+
+	for _, hash := range hashes {
+		registerIndex, count := h.getRegisterIndexAndCountOfHash(hash[0])
+		h.registers[registerIndex] = uint8(util.Max(uint(h.registers[registerIndex]), uint(count)))
+	}
+}
+
+// UpdateString sets the count of the passed _data_ (string) to the hashed location
+func (h *HyperLogLog) UpdateString(data string) {
+	h.Update([]byte(data))
+}
+
+// UpdateUint64 sets the count of the passed _data_ (unsigned integer) to the hashed location.
+// The integer is hashed as its 8 byte big endian encoding.
+func (h *HyperLogLog) UpdateUint64(data uint64) {
+	h.Update(uint64Bytes(data))
+}
+
+// UpdateKey sets the count of the passed _data_ (Key) to the hashed location
+func (h *HyperLogLog) UpdateKey(data Key) {
+	h.Update(data.KeyBytes())
+}
+
+// UpdateFromReader streams keys separated by _delim_ from _stream_ and updates the
+// HyperLogLog with each of them without holding all of them in memory.
+// _progress_ (optional, can be nil) is called periodically with the number of keys processed.
+// It returns the total number of keys processed.
+func (h *HyperLogLog) UpdateFromReader(stream io.Reader, delim byte, progress ProgressFunc) (uint64, error) {
+	return util.ReadKeys(stream, delim, progress, func(key []byte) error {
+		h.Update(key)
+		return nil
+	})
+}
+
+// UpdateFromReaderParallel is UpdateFromReader spreading the keys over _workers_ goroutines,
+// GOMAXPROCS if _workers_ is 0. Each worker updates a HyperLogLog of its own, which are
+// merged in the HyperLogLog once the stream is exhausted, so the load takes _workers_ times
+// the memory of the registers. The keys read before an error are merged too.
+func (h *HyperLogLog) UpdateFromReaderParallel(stream io.Reader, delim byte, workers int, progress ProgressFunc) (uint64, error) {
+	shards := make([]*HyperLogLog, parallelWorkers(workers))
+	for i := range shards {
+		shards[i], _ = NewHyperLogLogWithEstimator(h.numRegisters, h.estimator)
+	}
+	count, err := readKeysParallel(stream, delim, len(shards), progress, func(worker int, key []byte) {
+		shards[worker].Update(key)
+	})
+	mergeErr := MergeHyperLogLogs(h, shards)
+	if err == nil {
+		err = mergeErr
+	}
+	return count, err
+}
+
 // Count returns the number of distinct elements so far
-// _withCorrection_ is used to specify if correction is to be done for large registers
+// _withCorrection_ is used to specify if correction is to be done for large registers with
+// the LegacyEstimator, and for small cardinalities with HLLEstimator and HLLPlusPlusEstimator
 // _withRoundingOff_ is used to specify if rounding off is required for estimation
 func (h *HyperLogLog) Count(withCorrection, withRoundingOff bool) uint64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return h.estimate(h.registers, withCorrection, withRoundingOff)
+}
+
+// SetCorrectionBias replaces the constant alpha multiplying the raw estimation of the
+// HyperLogLog, e.g. with one measured on the workload. The bias correction of the
+// HLLPlusPlusEstimator is scaled accordingly.
+func (h *HyperLogLog) SetCorrectionBias(correctionBias float64) error {
+	err := checkCorrectionBias(correctionBias)
+	if err != nil {
+		return err
+	}
 	h.lock.Lock()
 	defer h.lock.Unlock()
 
-	harmonicMean := 0.0
-	for i := range h.registers {
-		harmonicMean += math.Pow(2, -float64(h.registers[i]))
-	}
-	return h.getEstimation(harmonicMean, withCorrection, withRoundingOff)
+	h.correctionBias = correctionBias
+	return nil
 }
 
-// Merge merges two Hyperloglog data structures
+// Merge merges two Hyperloglog data structures. The registers of large hyperloglogs are
+// split in chunks merged concurrently.
 func (h *HyperLogLog) Merge(g *HyperLogLog) error {
 	if h.numRegisters != g.numRegisters {
 		return fmt.Errorf("gostatix: number of registers %d, %d don't match", h.numRegisters, g.numRegisters)
 	}
-	for i := range g.registers {
-		h.registers[i] = uint8(util.Max(uint(h.registers[i]), uint(g.registers[i])))
+	if h.estimator != g.estimator {
+		return fmt.Errorf("gostatix: estimators %v, %v don't match", h.estimator, g.estimator)
+	}
+	if h == g {
+		return nil
 	}
+	g.lock.RLock()
+	registers := append([]uint8(nil), g.registers...)
+	g.lock.RUnlock()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.updated.touch()
+
+	maxRegisters(h.registers, registers)
 	return nil
 }
 
-// Equals checks if two Hyperloglog data structures are equal
-func (h *HyperLogLog) Equals(g *HyperLogLog) bool {
+// Equals checks if two Hyperloglog data structures are equal. Hyperloglogs with different
+// number of registers or estimators aren't equal.
+func (h *HyperLogLog) Equals(g *HyperLogLog) (bool, error) {
+	comparison, err := h.Compare(g)
+	return comparison.Equal(), err
+}
+
+// Compare compares two Hyperloglog data structures and returns why they aren't equal, if
+// they aren't
+func (h *HyperLogLog) Compare(g *HyperLogLog) (Comparison, error) {
 	if h.numRegisters != g.numRegisters {
-		return false
+		return parameterMismatch("numRegisters", h.numRegisters, g.numRegisters), nil
 	}
-	for i := 0; i < int(h.numRegisters)-1; i++ {
+	if h.estimator != g.estimator {
+		return parameterMismatch("estimator", h.estimator, g.estimator), nil
+	}
+	if h == g {
+		return equalComparison, nil
+	}
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	for i := range h.registers {
 		if h.registers[i] != g.registers[i] {
-			return false
+			return contentMismatch("registers"), nil
 		}
 	}
-	return true
+	return equalComparison, nil
+}
+
+// MemoryUsage returns the estimated number of bytes used in-process by the HyperLogLog
+func (h *HyperLogLog) MemoryUsage() uint64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return uint64(unsafe.Sizeof(*h)) + uint64(cap(h.registers))
+}
+
+// Close releases the resources attached to the HyperLogLog.
+// It's safe to call Close multiple times.
+func (h *HyperLogLog) Close() error {
+	return h.resources.close()
 }
 
 // Export JSON marshals the HyperLogLog and returns a byte slice containing the data
 func (h *HyperLogLog) Export() ([]byte, error) {
-	return json.Marshal(hyperLogLogJSON{h.numRegisters, h.numBytesPerHash, h.correctionBias, h.registers, ""})
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return json.Marshal(hyperLogLogJSON{h.numRegisters, h.numBytesPerHash, h.correctionBias, h.registers, "", h.estimator})
 }
 
-// Import JSON unmarshals the _data_ into the HyperLogLog
+// Import JSON unmarshals the _data_ into the HyperLogLog, or decodes it if it is a portable
+// snapshot written by ExportPortable
 func (h *HyperLogLog) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
+	if isPortable(data) {
+		return h.importPortable(data)
+	}
 	var g hyperLogLogJSON
-	err := json.Unmarshal(data, &g)
+	err = json.Unmarshal(data, &g)
+	if err != nil {
+		return err
+	}
+	err = g.validate()
 	if err != nil {
 		return err
 	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.updated.touch()
+
 	h.numRegisters = g.NumRegisters
 	h.numBytesPerHash = g.NumBytesPerHash
 	h.correctionBias = g.CorrectionBias
+	h.estimator = g.Estimator
 	h.registers = g.Registers
 	return nil
 }
@@ -121,12 +286,18 @@ func (h *HyperLogLog) Import(data []byte) error {
 // WriteTo writes the HyperLogLog onto the specified _stream_ and returns the
 // number of bytes written.
 // It can be used to write to disk (using a file stream) or to network.
+// The stream ends with the checksum of the hyperloglog, verified by ReadFrom.
 func (h *HyperLogLog) WriteTo(stream io.Writer) (int64, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	checksum := newChecksumWriter(stream)
+	stream = checksum
 	err := binary.Write(stream, binary.BigEndian, h.numRegisters)
 	if err != nil {
 		return 0, err
 	}
-	err = binary.Write(stream, binary.BigEndian, h.numBytesPerHash)
+	err = binary.Write(stream, binary.BigEndian, h.numBytesPerHash|uint64(h.estimator)<<estimatorShift)
 	if err != nil {
 		return 0, err
 	}
@@ -138,15 +309,28 @@ func (h *HyperLogLog) WriteTo(stream io.Writer) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	return int64((h.numRegisters + 3) * uint64(binary.Size(uint64(0)))), nil
+	err = checksum.writeTrailer()
+	if err != nil {
+		return 0, err
+	}
+	return int64((h.numRegisters+3)*uint64(binary.Size(uint64(0))) + checksumTrailerSize), nil
 }
 
 // ReadFrom reads the BloomFilter from the specified _stream_ and returns the
 // number of bytes read.
 // It can be used to read from disk (using a file stream) or from network.
+// A stream cut short or which doesn't match its checksum errors out with an
+// ErrCorruptSnapshot and leaves the hyperloglog unchanged.
 func (h *HyperLogLog) ReadFrom(stream io.Reader) (int64, error) {
+	decompressed, err := decompressStream(stream)
+	if err != nil {
+		return 0, err
+	}
+	defer decompressed.Close()
+	checksum := newChecksumReader(decompressed)
+	stream = checksum
 	var numRegisters, numBytesPerHash uint64
-	err := binary.Read(stream, binary.BigEndian, &numRegisters)
+	err = binary.Read(stream, binary.BigEndian, &numRegisters)
 	if err != nil {
 		return 0, err
 	}
@@ -159,14 +343,32 @@ func (h *HyperLogLog) ReadFrom(stream io.Reader) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	h.numRegisters = numRegisters
-	h.numBytesPerHash = numBytesPerHash
-	h.correctionBias = correctionBias
-	registers := make([]uint8, numRegisters)
-	err = binary.Read(stream, binary.BigEndian, &registers)
+	estimator := HyperLogLogEstimator(numBytesPerHash >> estimatorShift)
+	numBytesPerHash &= 1<<estimatorShift - 1
+	err = checkHyperLogLogParams(numRegisters, numBytesPerHash)
+	if err != nil {
+		return 0, err
+	}
+	err = estimator.validate()
 	if err != nil {
 		return 0, err
 	}
+	registers, err := readBytes(stream, numRegisters)
+	if err != nil {
+		return 0, err
+	}
+	trailer, err := checksum.verify()
+	if err != nil {
+		return 0, err
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.updated.touch()
+
+	h.numRegisters = numRegisters
+	h.numBytesPerHash = numBytesPerHash
+	h.correctionBias = correctionBias
+	h.estimator = estimator
 	h.registers = registers
-	return int64((h.numRegisters + 3) * uint64(binary.Size(uint64(0)))), nil
+	return int64((h.numRegisters+3)*uint64(binary.Size(uint64(0)))) + trailer, nil
 }
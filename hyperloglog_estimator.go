@@ -0,0 +1,197 @@
+package gostatix
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// HyperLogLogEstimator is the estimator used by a hyperloglog to count its distinct
+// elements. The estimators other than LegacyEstimator share the same registers: the first
+// bits of the hash of an element select its register and the register holds the position
+// of the first bit set in the remaining bits.
+type HyperLogLogEstimator uint8
+
+const (
+	// LegacyEstimator is the estimator of the hyperloglogs created by NewHyperLogLog and
+	// NewHyperLogLogRedis, which keeps reading the registers written by the former versions:
+	// the harmonic mean of the registers with a correction for large cardinalities
+	LegacyEstimator HyperLogLogEstimator = iota
+	// LogLogEstimator is the original LogLog estimator (Durand & Flajolet), the geometric
+	// mean of the registers. Its standard error is 1.30/sqrt(m) instead of 1.04/sqrt(m) for
+	// m registers and it overestimates cardinalities smaller than m.
+	LogLogEstimator
+	// HLLEstimator is the HyperLogLog estimator (Flajolet et al.), the harmonic mean of the
+	// registers, counting the empty registers (linear counting) for cardinalities below 2.5m
+	HLLEstimator
+	// HLLPlusPlusEstimator is the HyperLogLog++ estimator (Heule et al.), the harmonic mean
+	// of the registers minus its empirical bias, from the tables published with
+	// HyperLogLog++, for cardinalities below 5m, and linear counting below the thresholds
+	// of HyperLogLog++
+	HLLPlusPlusEstimator
+)
+
+// String returns the name of the estimator
+func (estimator HyperLogLogEstimator) String() string {
+	switch estimator {
+	case LegacyEstimator:
+		return "legacy"
+	case LogLogEstimator:
+		return "loglog"
+	case HLLEstimator:
+		return "hll"
+	case HLLPlusPlusEstimator:
+		return "hll++"
+	default:
+		return fmt.Sprintf("HyperLogLogEstimator(%d)", uint8(estimator))
+	}
+}
+
+// validate checks that the estimator of a decoded snapshot is known
+func (estimator HyperLogLogEstimator) validate() error {
+	if estimator > HLLPlusPlusEstimator {
+		return fmt.Errorf("gostatix: invalid hyperloglog snapshot, unknown estimator %d", uint8(estimator))
+	}
+	return nil
+}
+
+// estimatorShift is the position of the estimator in the number of bytes per hash written
+// by WriteTo
+const estimatorShift = 56
+
+// defaultCorrectionBias returns the constant alpha of _estimator_ for _m_ registers
+func defaultCorrectionBias(estimator HyperLogLogEstimator, m uint64) float64 {
+	if estimator == LogLogEstimator {
+		return 0.39701 - (2*math.Pi*math.Pi+math.Ln2*math.Ln2)/(48*float64(m))
+	}
+	return getAlpha(uint(m))
+}
+
+// getRank returns the register index and the rank of _hash_ for _precision_ bits of
+// register index: the index is made of the first bits of the hash and the rank is the
+// position of the first bit set in the remaining bits
+func getRank(hash, precision uint64) (uint64, uint64) {
+	index := hash >> (64 - precision)
+	zeros := uint64(bits.LeadingZeros64(hash << precision))
+	if zeros > 64-precision {
+		zeros = 64 - precision
+	}
+	return index, zeros + 1
+}
+
+// harmonicMean returns the sum of 2^-register of _registers_
+func harmonicMean(registers []uint8) float64 {
+	sum := 0.0
+	for _, register := range registers {
+		sum += math.Ldexp(1, -int(register))
+	}
+	return sum
+}
+
+// estimate returns the cardinality estimated from _registers_ by the estimator of the
+// hyperloglog
+func (h *AbstractHyperLogLog) estimate(registers []uint8, withCorrection, withRoundingOff bool) uint64 {
+	if h.estimator == LegacyEstimator {
+		return h.getEstimation(harmonicMean(registers), withCorrection, withRoundingOff)
+	}
+	m := float64(h.numRegisters)
+	var estimation float64
+	if h.estimator == LogLogEstimator {
+		sum := 0.0
+		for _, register := range registers {
+			sum += float64(register)
+		}
+		estimation = h.correctionBias * m * math.Exp2(sum/m)
+	} else {
+		estimation = h.correctionBias * m * m / harmonicMean(registers)
+		if withCorrection {
+			estimation = h.correctEstimation(estimation, registers)
+		}
+	}
+	if withRoundingOff {
+		estimation = math.Round(estimation)
+	}
+	return uint64(estimation)
+}
+
+// correctEstimation applies the small cardinality corrections of HLLEstimator and
+// HLLPlusPlusEstimator to the raw _estimation_ of _registers_
+func (h *AbstractHyperLogLog) correctEstimation(estimation float64, registers []uint8) float64 {
+	m := float64(h.numRegisters)
+	zeros := 0
+	for _, register := range registers {
+		if register == 0 {
+			zeros++
+		}
+	}
+	if h.estimator == HLLEstimator {
+		if estimation <= 2.5*m && zeros > 0 {
+			return m * math.Log(m/float64(zeros))
+		}
+		return estimation
+	}
+	if estimation <= 5*m {
+		// the bias is measured with the default alpha, the estimation is scaled to it
+		scale := h.correctionBias / defaultCorrectionBias(HLLPlusPlusEstimator, h.numRegisters)
+		estimation = math.Max(estimation-scale*getHLLBias(h.numBytesPerHash, estimation/scale), 0)
+	}
+	if zeros > 0 {
+		linearCount := m * math.Log(m/float64(zeros))
+		if linearCount <= linearCountingThreshold(h.numBytesPerHash) {
+			return linearCount
+		}
+	}
+	return estimation
+}
+
+// hllThresholds are the cardinalities below which HyperLogLog++ uses linear counting, for
+// the precisions 4 to 18
+var hllThresholds = []float64{10, 20, 40, 80, 220, 400, 900, 1800, 3100, 6500, 11500, 20000, 50000, 120000, 350000}
+
+// linearCountingThreshold returns the linear counting threshold of HyperLogLog++ for
+// _precision_ bits of register index. The thresholds of the precisions out of 4 to 18 are
+// scaled from the closest one.
+func linearCountingThreshold(precision uint64) float64 {
+	p := uint64(math.Max(4, math.Min(18, float64(precision))))
+	return math.Ldexp(hllThresholds[p-4], int(precision)-int(p))
+}
+
+const (
+	// hllBiasMinPrecision and hllBiasMaxPrecision are the precisions of the bias tables
+	hllBiasMinPrecision = 4
+	hllBiasMaxPrecision = 18
+	// hllBiasNeighbors is the number of closest raw estimates whose bias is averaged
+	hllBiasNeighbors = 6
+)
+
+// getHLLBias returns the empirical bias of the raw _estimation_ of a HyperLogLog++ with
+// _precision_ bits of register index, the mean bias of the closest raw estimates of its
+// tables, see hllRawEstimates. The bias of the precisions above 18 is the one of the
+// precision 18 scaled by the number of registers, and the precisions below 4 have none.
+func getHLLBias(precision uint64, estimation float64) float64 {
+	if precision < hllBiasMinPrecision {
+		return 0
+	}
+	p := precision
+	if p > hllBiasMaxPrecision {
+		p = hllBiasMaxPrecision
+	}
+	scale := math.Ldexp(1, int(precision-p))
+	estimates, biases := hllRawEstimates[p-hllBiasMinPrecision], hllBiases[p-hllBiasMinPrecision]
+	x := estimation / scale
+	i := sort.SearchFloat64s(estimates, x)
+	lo, hi := i, i
+	for hi-lo < hllBiasNeighbors {
+		if lo == 0 || (hi < len(estimates) && estimates[hi]-x < x-estimates[lo-1]) {
+			hi++
+		} else {
+			lo--
+		}
+	}
+	bias := 0.0
+	for _, b := range biases[lo:hi] {
+		bias += b
+	}
+	return bias / float64(hi-lo) * scale
+}
@@ -15,35 +15,89 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"strconv"
+	"io"
+	"strings"
+	"time"
 
 	"github.com/kwertop/gostatix/internal/util"
 	"github.com/redis/go-redis/v9"
 )
 
+// hllUpdateScript raises the register at index ARGV[1] of the registers at KEYS[1] to
+// ARGV[2]. The registers are a string of one byte per register, read and written in place
+// with GETRANGE and SETRANGE.
+var hllUpdateScript = redis.NewScript(`
+	local key = KEYS[1]
+	local index = tonumber(ARGV[1])
+	local val = tonumber(ARGV[2])
+	local count = string.byte(redis.call('GETRANGE', key, index, index)) or 0
+	if val > count then
+		redis.call('SETRANGE', key, index, string.char(val))
+	end
+	return true
+`)
+
+// hllUpdateMultiScript raises the registers at KEYS[1] to the counts following their
+// indexes in ARGV
+var hllUpdateMultiScript = redis.NewScript(`
+	local key = KEYS[1]
+	for i=1, #ARGV, 2 do
+		local index = tonumber(ARGV[i])
+		local val = tonumber(ARGV[i+1])
+		if val > (string.byte(redis.call('GETRANGE', key, index, index)) or 0) then
+			redis.call('SETRANGE', key, index, string.char(val))
+		end
+	end
+	return true
+`)
+
 // HyperLogLogRedis is the Redis backed implementation of BaseHyperLogLog
-// _key_ holds the Redis key to the list which has the registers
+// _key_ holds the Redis key to the string which has the registers, one byte per register
 // _metadataKey_ is used to store the additional information about HyperLogLogRedis
 // for retrieving the sketch by the Redis key
+// _updated_ records the time of the last update made through the sketch
 type HyperLogLogRedis struct {
 	AbstractHyperLogLog
 	key         string
 	metadataKey string
+	resources   resources
+	updated     updateClock
+}
+
+// NewHyperLogLogRedis creates new HyperLogLogRedis with the specified _numRegisters_,
+// counting with the LegacyEstimator
+// _opts_ can name the Redis keys of the HyperLogLog with WithName and WithKeyPrefix
+func NewHyperLogLogRedis(numRegisters uint64, opts ...Option) (*HyperLogLogRedis, error) {
+	return NewHyperLogLogRedisWithEstimator(numRegisters, LegacyEstimator, opts...)
 }
 
-// NewHyperLogLogRedis creates new HyperLogLogRedis with the specified _numRegisters_
-func NewHyperLogLogRedis(numRegisters uint64) (*HyperLogLogRedis, error) {
-	abstractLog, err := makeAbstractHyperLogLog(numRegisters)
+// NewHyperLogLogRedisWithEstimator creates new HyperLogLogRedis with the specified
+// _numRegisters_, counting its distinct elements with _estimator_. The estimator is saved in
+// the metadata, like the number of registers.
+// _opts_ can name the Redis keys of the HyperLogLog with WithName and WithKeyPrefix
+func NewHyperLogLogRedisWithEstimator(numRegisters uint64, estimator HyperLogLogEstimator, opts ...Option) (*HyperLogLogRedis, error) {
+	abstractLog, err := makeAbstractHyperLogLog(numRegisters, estimator)
+	if err != nil {
+		return nil, err
+	}
+	o, err := redisOptions("hyperloglog", opts)
+	if err != nil {
+		return nil, err
+	}
+	metadataKey, err := o.metadataKey()
+	if err != nil {
+		return nil, err
+	}
+	key, err := o.dataKey(":registers")
 	if err != nil {
 		return nil, err
 	}
-	key := util.GenerateRandomString(16)
-	metadataKey := util.GenerateRandomString(16)
-	h := &HyperLogLogRedis{*abstractLog, key, metadataKey}
+	h := &HyperLogLogRedis{*abstractLog, key, metadataKey, resources{}, updateClock{}}
 	metadata := make(map[string]interface{})
 	metadata["numRegisters"] = h.numRegisters
 	metadata["key"] = h.key
-	err = getRedisClient().HSet(context.Background(), h.metadataKey, metadata).Err()
+	metadata["estimator"] = uint8(h.estimator)
+	err = saveMetadata(h.metadataKey, "hll", metadata)
 	if err != nil {
 		return nil, fmt.Errorf("gostatix: error creating count min sketch redis, error: %v", err)
 	}
@@ -58,40 +112,201 @@ func NewHyperLogLogRedis(numRegisters uint64) (*HyperLogLogRedis, error) {
 // _metadataKey_ (the Redis key used to store the metadata about the hyperloglog) passed.
 // For this to work, value should be present in Redis at _key_
 func NewHyperLogLogRedisFromKey(metadataKey string) (*HyperLogLogRedis, error) {
-	values, err := getRedisClient().HGetAll(context.Background(), metadataKey).Result()
+	metadata, err := loadMetadata(metadataKey, "hll")
+	if err != nil {
+		return nil, err
+	}
+	numRegisters, err := metadata.uint("numRegisters")
+	if err != nil {
+		return nil, err
+	}
+	key, err := metadata.string("key")
 	if err != nil {
-		return nil, fmt.Errorf("gostatix: error creating hyeprloglog from redis key, error: %v", err)
+		return nil, err
 	}
-	numRegisters, _ := strconv.Atoi(values["numRegisters"])
-	abstractLog, err := makeAbstractHyperLogLog(uint64(numRegisters))
+	// the hyperloglogs saved by the former versions have no estimator
+	estimator, err := metadata.optionalUint("estimator")
+	if err != nil {
+		return nil, err
+	}
+	abstractLog, err := makeAbstractHyperLogLog(numRegisters, HyperLogLogEstimator(estimator))
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: invalid hyperloglog metadata at key %s, error: %v", metadataKey, err)
+	}
+	h := &HyperLogLogRedis{*abstractLog, key, metadataKey, resources{}, updateClock{}}
+	err = h.migrateRegisters()
 	if err != nil {
 		return nil, err
 	}
-	h := &HyperLogLogRedis{*abstractLog, values["key"], metadataKey}
 	return h, nil
 }
 
+// NewOrOpenHyperLogLogRedis creates a HyperLogLogRedis named _name_ (see WithName) with
+// _numRegisters_, or opens it like NewHyperLogLogRedisFromKey if it already exists, in which
+// case it must have been created with the same _numRegisters_
+func NewOrOpenHyperLogLogRedis(name string, numRegisters uint64, opts ...Option) (*HyperLogLogRedis, error) {
+	opts, err := namedOptions(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	o, err := redisOptions("hyperloglog", opts)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := o.nameExists()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return NewHyperLogLogRedis(numRegisters, opts...)
+	}
+	metadataKey := o.keyPrefix + o.name
+	log, err := NewHyperLogLogRedisFromKey(metadataKey)
+	if err != nil {
+		return nil, err
+	}
+	err = checkOpenedParams("hyperloglog", metadataKey, []interface{}{log.numRegisters}, []interface{}{numRegisters})
+	if err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
 // MetadataKey returns the metadataKey
 func (h *HyperLogLogRedis) MetadataKey() string {
 	return h.metadataKey
 }
 
 // Update sets the count of the passed _data_ (byte slice) to the hashed location
-// in the Redis list at _key_
+// in the Redis string at _key_
 func (h *HyperLogLogRedis) Update(data []byte) error {
 	registerIndex, count := h.getRegisterIndexAndCount(data)
-	return h.updateRegisters(uint8(registerIndex), uint8(count))
+	return h.updateRegisters(registerIndex, uint8(count))
+}
+
+// UpdateMulti updates the HyperLogLogRedis with all the elements of _data_ in a single
+// script invocation, e.g. with the IDs ingested in a tick. Only the largest count of each
+// register is sent to Redis.
+func (h *HyperLogLogRedis) UpdateMulti(data [][]byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	registers := make(map[uint64]uint8)
+	var indexes []uint64
+	for _, element := range data {
+		registerIndex, count := h.getRegisterIndexAndCount(element)
+		current, ok := registers[registerIndex]
+		if !ok {
+			indexes = append(indexes, registerIndex)
+		}
+		if !ok || uint8(count) > current {
+			registers[registerIndex] = uint8(count)
+		}
+	}
+	args := make([]interface{}, 0, 2*len(indexes))
+	for _, index := range indexes {
+		args = append(args, index, registers[index])
+	}
+	_, err := hllUpdateMultiScript.Run(context.Background(), getRedisClient(), []string{h.key}, args...).Bool()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while updating hyperloglog registers in redis, error: %v", err)
+	}
+	h.updated.touch()
+	return nil
+}
+
+// UpdateString sets the count of the passed _data_ (string) to the hashed location
+func (h *HyperLogLogRedis) UpdateString(data string) error {
+	return h.Update([]byte(data))
+}
+
+// UpdateUint64 sets the count of the passed _data_ (unsigned integer) to the hashed location.
+// The integer is hashed as its 8 byte big endian encoding.
+func (h *HyperLogLogRedis) UpdateUint64(data uint64) error {
+	return h.Update(uint64Bytes(data))
+}
+
+// UpdateKey sets the count of the passed _data_ (Key) to the hashed location
+func (h *HyperLogLogRedis) UpdateKey(data Key) error {
+	return h.Update(data.KeyBytes())
+}
+
+// UpdateFromReader streams keys separated by _delim_ from _stream_ and updates the
+// HyperLogLogRedis with each of them without holding all of them in memory.
+// _progress_ (optional, can be nil) is called periodically with the number of keys processed.
+// It returns the number of keys processed and stops at the first Redis error.
+func (h *HyperLogLogRedis) UpdateFromReader(stream io.Reader, delim byte, progress ProgressFunc) (uint64, error) {
+	return util.ReadKeys(stream, delim, progress, func(key []byte) error {
+		return h.Update(key)
+	})
+}
+
+// WithAsyncWrites returns an AsyncWriter which buffers the updates to the HyperLogLogRedis
+// and flushes them in pipelines of _bufSize_ updates or every _flushInterval_ (if greater than 0).
+// Only the largest update of each register in a batch is written to Redis.
+// _onError_ (optional, can be nil) is called with the errors of the background flushes.
+func (h *HyperLogLogRedis) WithAsyncWrites(bufSize int, flushInterval time.Duration, onError func(error)) (*AsyncWriter, error) {
+	return newAsyncWriter(&h.resources, h, bufSize, flushInterval, onError)
 }
 
-// Count returns the number of distinct elements so far
-// _withCorrection_ is used to specify if correction is to be done for large registers
+func (h *HyperLogLogRedis) writeBatch(batch []asyncWrite) error {
+	data := make([][]byte, len(batch))
+	for i, write := range batch {
+		data[i] = write.data
+	}
+	return h.UpdateMulti(data)
+}
+
+// Count returns the number of distinct elements so far, reading the registers with a
+// single GET
+// _withCorrection_ is used to specify if correction is to be done for large registers with
+// the LegacyEstimator, and for small cardinalities with HLLEstimator and HLLPlusPlusEstimator
 // _withRoundingOff_ is used to specify if rounding off is required for estimation
 func (h *HyperLogLogRedis) Count(withCorrection bool, withRoundingOff bool) (uint64, error) {
-	harmonicMean, err := h.computeHarmonicMean()
+	registers, err := h.fetchRegisters()
 	if err != nil {
 		return 0, err
 	}
-	return h.getEstimation(harmonicMean, withCorrection, withRoundingOff), nil
+	return h.estimate(registers, withCorrection, withRoundingOff), nil
+}
+
+// SetCorrectionBias replaces the constant alpha multiplying the raw estimation of the
+// HyperLogLogRedis, like HyperLogLog.SetCorrectionBias. It isn't saved in Redis: it applies
+// to the counts of this instance and to its exports.
+func (h *HyperLogLogRedis) SetCorrectionBias(correctionBias float64) error {
+	err := checkCorrectionBias(correctionBias)
+	if err != nil {
+		return err
+	}
+	h.correctionBias = correctionBias
+	return nil
+}
+
+// CountHyperLogLogsRedis returns the number of distinct elements of each of the _hlls_, in
+// the same order, reading all their registers in a single pipeline instead of one round trip
+// per hyperloglog, e.g. for the hyperloglogs of the registry loaded once with
+// NewHyperLogLogRedisFromKey
+// _withCorrection_ and _withRoundingOff_ are the ones of Count
+func CountHyperLogLogsRedis(ctx context.Context, hlls []*HyperLogLogRedis, withCorrection, withRoundingOff bool) ([]uint64, error) {
+	if len(hlls) == 0 {
+		return nil, nil
+	}
+	pipe := getRedisClient().Pipeline()
+	cmds := make([]*redis.StringCmd, len(hlls))
+	for i, h := range hlls {
+		cmds[i] = pipe.Get(ctx, h.key)
+	}
+	// the errors of the commands are checked one by one below
+	pipe.Exec(ctx)
+	counts := make([]uint64, len(hlls))
+	for i, h := range hlls {
+		registers, err := h.checkRegisters(cmds[i].Bytes())
+		if err != nil {
+			return nil, err
+		}
+		counts[i] = h.estimate(registers, withCorrection, withRoundingOff)
+	}
+	return counts, nil
 }
 
 // Merge merges two HyperLogLogRedis data structures
@@ -99,171 +314,217 @@ func (h *HyperLogLogRedis) Merge(g *HyperLogLogRedis) error {
 	if h.numRegisters != g.numRegisters {
 		return fmt.Errorf("gostatix: number of registers %d, %d don't match", h.numRegisters, g.numRegisters)
 	}
+	if h.estimator != g.estimator {
+		return fmt.Errorf("gostatix: estimators %v, %v don't match", h.estimator, g.estimator)
+	}
 	return h.mergeRegisters(g.key)
 }
 
-// Equals checks if two HyperLogLogRedis data structures are equal
+// MergeMany merges the HyperLogLogRedis data structures at _keys_ (their metadata keys) in
+// the HyperLogLogRedis, e.g. to aggregate the hyperloglogs of many shards or time windows.
+// The metadata of every key is checked first, then all the registers are folded in a single
+// script invocation, so the merge is atomic: either every hyperloglog is merged or none.
+func (h *HyperLogLogRedis) MergeMany(keys ...string) error {
+	registerKeys := make([]string, len(keys))
+	for i, key := range keys {
+		g, err := NewHyperLogLogRedisFromKey(key)
+		if err != nil {
+			return err
+		}
+		if h.numRegisters != g.numRegisters {
+			return fmt.Errorf("gostatix: number of registers %d, %d of key %s don't match", h.numRegisters, g.numRegisters, key)
+		}
+		if h.estimator != g.estimator {
+			return fmt.Errorf("gostatix: estimators %v, %v of key %s don't match", h.estimator, g.estimator, key)
+		}
+		registerKeys[i] = g.key
+	}
+	if len(registerKeys) == 0 {
+		return nil
+	}
+	return h.mergeRegisters(registerKeys...)
+}
+
+// Equals checks if two HyperLogLogRedis data structures are equal. Hyperloglogs with
+// different number of registers or estimators aren't equal.
 func (h *HyperLogLogRedis) Equals(g *HyperLogLogRedis) (bool, error) {
+	comparison, err := h.Compare(g)
+	return comparison.Equal(), err
+}
+
+// Compare compares two HyperLogLogRedis data structures and returns why they aren't equal,
+// if they aren't
+func (h *HyperLogLogRedis) Compare(g *HyperLogLogRedis) (Comparison, error) {
 	if h.numRegisters != g.numRegisters {
-		return false, nil
+		return parameterMismatch("numRegisters", h.numRegisters, g.numRegisters), nil
+	}
+	if h.estimator != g.estimator {
+		return parameterMismatch("estimator", h.estimator, g.estimator), nil
 	}
-	return h.compareRegisters(g.key)
+	ok, err := h.compareRegisters(g.key)
+	if err != nil {
+		return Comparison{}, err
+	}
+	return compareResult(ok, "registers"), nil
+}
+
+// MemoryUsage returns the estimated number of bytes used in Redis by the HyperLogLogRedis,
+// as reported by MEMORY USAGE for its registers and its metadata
+func (h *HyperLogLogRedis) MemoryUsage() (uint64, error) {
+	return redisMemoryUsage([]string{h.key, h.metadataKey})
+}
+
+// Close releases the resources attached to the HyperLogLogRedis, flushing and stopping
+// its async writers. The data of the hyperloglog is kept in Redis.
+// It's safe to call Close multiple times.
+func (h *HyperLogLogRedis) Close() error {
+	return h.resources.close()
 }
 
 // Export JSON marshals the HyperLogLogRedis and returns a byte slice containing the data
 func (h *HyperLogLogRedis) Export() ([]byte, error) {
-	result, err := getRedisClient().LRange(
-		context.Background(),
-		h.key,
-		0,
-		-1,
-	).Result()
+	registers, err := h.fetchRegisters()
 	if err != nil {
-		return nil, fmt.Errorf("gostatix: error fetching registers from redis, error: %v", err)
-	}
-	registers := make([]uint8, h.numRegisters)
-	for i := range registers {
-		val, _ := strconv.Atoi(result[i])
-		registers[i] = uint8(val)
+		return nil, err
 	}
-	return json.Marshal(hyperLogLogJSON{h.numRegisters, h.numBytesPerHash, h.correctionBias, registers, h.key})
+	return json.Marshal(hyperLogLogJSON{h.numRegisters, h.numBytesPerHash, h.correctionBias, registers, h.key, h.estimator})
 }
 
 // Import JSON unmarshals the _data_ into the HyperLogLogRedis
 func (h *HyperLogLogRedis) Import(data []byte, withNewKey bool) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
 	var g hyperLogLogJSON
-	err := json.Unmarshal(data, &g)
+	err = json.Unmarshal(data, &g)
+	if err != nil {
+		return err
+	}
+	err = g.validate()
 	if err != nil {
 		return err
 	}
+	key := g.Key
+	if withNewKey {
+		key, err = newRedisKey(context.Background(), "")
+		if err != nil {
+			return err
+		}
+	}
 	h.numRegisters = g.NumRegisters
 	h.numBytesPerHash = g.NumBytesPerHash
 	h.correctionBias = g.CorrectionBias
-	if withNewKey {
-		h.key = util.GenerateRandomString(16)
-	} else {
-		h.key = g.Key
-	}
+	h.estimator = g.Estimator
+	h.key = key
 	return h.importRegisters(g.Registers)
 }
 
+// importRegisters writes _registers_ at the key of the HyperLogLogRedis, replacing its
+// registers
 func (h *HyperLogLogRedis) importRegisters(registers []uint8) error {
-	args := make([]interface{}, len(registers))
-	for i := range registers {
-		args[i] = interface{}(registers[i])
-	}
-	importRegistersScript := redis.NewScript(`
-		local key = KEYS[1]
-		local size = #ARGV
-		local registers = {}
-		for i=1, size do
-			registers[i] = tonumber(ARGV[i])
-		end
-		redis.call('RPUSH', key, unpack(registers))
-		return true
-	`)
-	_, err := importRegistersScript.Run(
-		context.Background(),
-		getRedisClient(),
-		[]string{h.key},
-		args...,
-	).Bool()
+	err := getRedisClient().Set(context.Background(), h.key, registers, 0).Err()
 	if err != nil {
 		return fmt.Errorf("gostatix: error importing registers for key: %s, error: %v", h.key, err)
 	}
+	h.updated.touch()
 	return nil
 }
 
-func (h *HyperLogLogRedis) mergeRegisters(key string) error {
-	mergeRegistersScript := redis.NewScript(`
-		local key1 = KEYS[1]
-		local key2 = KEYS[2]
-		local size = ARGV[1]
-		local vals1 = redis.pcall('LRANGE', key1, 0, -1)
-		local vals2 = redis.pcall('LRANGE', key2, 0, -1)
-		for i=1, tonumber(size) do
-			if tonumber(vals1[i]) < tonumber(vals2[i]) then
-				vals1[i] = vals2[i]
+// hllMergeScript folds the registers at KEYS[2], KEYS[3]... into the registers at KEYS[1],
+// keeping the maximum of each register. The registers of KEYS[1] are overwritten in place
+// with a single SETRANGE, so the merge is atomic and keeps the expiry of KEYS[1].
+var hllMergeScript = redis.NewScript(`
+	local size = tonumber(ARGV[1])
+	local registers = redis.call('GET', KEYS[1])
+	if not registers or #registers ~= size then
+		return redis.error_reply('registers at ' .. KEYS[1] .. ' have length ' .. (registers and #registers or 0) .. ' instead of ' .. size)
+	end
+	local merged = {}
+	for i=1, size do
+		merged[i] = string.byte(registers, i)
+	end
+	local changed = false
+	for k=2, #KEYS do
+		local values = redis.call('GET', KEYS[k])
+		if not values or #values ~= size then
+			return redis.error_reply('registers at ' .. KEYS[k] .. ' have length ' .. (values and #values or 0) .. ' instead of ' .. size)
+		end
+		for i=1, size do
+			local value = string.byte(values, i)
+			if value > merged[i] then
+				merged[i] = value
+				changed = true
 			end
 		end
-		redis.pcall('LPUSH', key1, unpack(vals1))
-		return true
-	`)
-	_, err := mergeRegistersScript.Run(
+	end
+	if changed then
+		local chars = {}
+		for i=1, size do
+			chars[i] = string.char(merged[i])
+		end
+		redis.call('SETRANGE', KEYS[1], 0, table.concat(chars))
+	end
+	return true
+`)
+
+// mergeRegisters folds the registers at _keys_ into the registers of the HyperLogLogRedis
+// in a single script invocation
+func (h *HyperLogLogRedis) mergeRegisters(keys ...string) error {
+	_, err := hllMergeScript.Run(
 		context.Background(),
 		getRedisClient(),
-		[]string{h.key, key},
+		append([]string{h.key}, keys...),
 		h.numRegisters,
 	).Bool()
 	if err != nil {
-		return fmt.Errorf("gostatix: error while merging registers %s with %s, error: %v", h.key, key, err)
+		return fmt.Errorf("gostatix: error while merging registers %s with %s, error: %v", h.key, strings.Join(keys, ","), err)
 	}
+	h.updated.touch()
 	return nil
 }
 
+// hllEqualsScript compares the registers at KEYS[1] and KEYS[2] in Redis, without sending
+// them to the client
+var hllEqualsScript = redis.NewScript(`
+	return redis.call('GET', KEYS[1]) == redis.call('GET', KEYS[2])
+`)
+
 func (h *HyperLogLogRedis) compareRegisters(key string) (bool, error) {
-	equals := redis.NewScript(`
-		local key1 = KEYS[1]
-		local key2 = KEYS[2]
-		local size = ARGV[1]
-		local vals1 = redis.pcall('LRANGE', key1, 0, -1)
-		local vals2 = redis.pcall('LRANGE', key2, 0, -1)
-		for i=1, tonumber(size) do
-			if tonumber(vals1[i]) ~= tonumber(vals2[i]) then
-				return false
-			end
-		end
-		return true
-	`)
-	ok, err := equals.Run(
+	ok, err := hllEqualsScript.Run(
 		context.Background(),
 		getRedisClient(),
 		[]string{h.key, key},
-		h.numRegisters,
 	).Bool()
+	if err == redis.Nil {
+		// lua false is returned as a nil reply
+		return false, nil
+	}
 	if err != nil {
 		return false, fmt.Errorf("gostatix: error while comparing registers %s with %s, error: %v", h.key, key, err)
 	}
 	return ok, nil
 }
 
-func (h *HyperLogLogRedis) computeHarmonicMean() (float64, error) {
-	harmonicMeanScript := redis.NewScript(`
-		local key = KEYS[1]
-		local size = ARGV[1]
-		local hmean = 0.0
-		local values = redis.pcall('LRANGE', key, 0, -1)
-		for i=1, tonumber(size) do
-			local value = (-1)*tonumber(values[i])
-			hmean = hmean + 2^(value)
-		end
-		return hmean
-	`)
-	hmean, err := harmonicMeanScript.Run(
-		context.Background(),
-		getRedisClient(),
-		[]string{h.key},
-		h.numRegisters,
-	).Float64()
+// fetchRegisters reads the registers of the HyperLogLogRedis with a single GET
+func (h *HyperLogLogRedis) fetchRegisters() ([]uint8, error) {
+	return h.checkRegisters(getRedisClient().Get(context.Background(), h.key).Bytes())
+}
+
+// checkRegisters returns the _registers_ read from the key of the HyperLogLogRedis, or an
+// error if the read failed or their number isn't the one of the HyperLogLogRedis
+func (h *HyperLogLogRedis) checkRegisters(registers []byte, err error) ([]uint8, error) {
 	if err != nil {
-		return 0, fmt.Errorf("gostatix: error while computing harmonic mean of hyperloglog, error: %v", err)
+		return nil, fmt.Errorf("gostatix: error fetching registers of hyperloglog %s from redis, error: %v", h.key, err)
+	}
+	if uint64(len(registers)) != h.numRegisters {
+		return nil, fmt.Errorf("gostatix: registers of hyperloglog %s have length %d instead of %d", h.key, len(registers), h.numRegisters)
 	}
-	return hmean, nil
+	return registers, nil
 }
 
-func (h *HyperLogLogRedis) updateRegisters(index, count uint8) error {
-	updateList := redis.NewScript(`
-		local key = KEYS[1]
-		local index = tonumber(ARGV[1])
-		local val = tonumber(ARGV[2])
-		local count = redis.call('LINDEX', key, index)
-		if val > tonumber(count) then
-			count = val
-		end
-		redis.call('LSET', key, index, count)
-		return true
-	`)
-	_, err := updateList.Run(
+func (h *HyperLogLogRedis) updateRegisters(index uint64, count uint8) error {
+	_, err := hllUpdateScript.Run(
 		context.Background(),
 		getRedisClient(),
 		[]string{h.key},
@@ -273,29 +534,48 @@ func (h *HyperLogLogRedis) updateRegisters(index, count uint8) error {
 	if err != nil {
 		return fmt.Errorf("gostatix: error while updating hyperloglog registers in redis, error: %v", err)
 	}
+	h.updated.touch()
 	return nil
 }
 
+// initRegisters writes the registers of the HyperLogLogRedis, all 0
 func (h *HyperLogLogRedis) initRegisters() error {
-	initList := redis.NewScript(`
-		local key = KEYS[1]
-		local size = ARGV[1]
-		local registers = {}
-		for i=1, tonumber(size)/2 do
-			registers[i] = 0
-		end
-		redis.call('LPUSH', key, unpack(registers))
-		redis.call('LPUSH', key, unpack(registers))
-		return true
-	`)
-	_, err := initList.Run(
-		context.Background(),
-		getRedisClient(),
-		[]string{h.key},
-		h.numRegisters,
-	).Bool()
+	err := getRedisClient().Set(context.Background(), h.key, make([]byte, h.numRegisters), 0).Err()
 	if err != nil {
 		return fmt.Errorf("gostatix: error while initializing hyperloglog registers in redis, error: %v", err)
 	}
 	return nil
 }
+
+// hllMigrateScript converts the registers at KEYS[1] from the Redis list of one element per
+// register written by the former versions to the string of one byte per register. It
+// returns 1 if the registers were converted and 0 if they already are a string.
+var hllMigrateScript = redis.NewScript(`
+	local key = KEYS[1]
+	if redis.call('TYPE', key).ok ~= 'list' then
+		return 0
+	end
+	local values = redis.call('LRANGE', key, 0, -1)
+	local chars = {}
+	for i=1, #values do
+		chars[i] = string.char(tonumber(values[i]))
+	end
+	local ttl = redis.call('PTTL', key)
+	redis.call('DEL', key)
+	redis.call('SET', key, table.concat(chars))
+	if ttl > 0 then
+		redis.call('PEXPIRE', key, ttl)
+	end
+	return 1
+`)
+
+// migrateRegisters converts the registers of a HyperLogLogRedis created by a former version
+// from a Redis list to a string, in place and atomically, so that the hyperloglogs saved in
+// Redis keep working after an upgrade
+func (h *HyperLogLogRedis) migrateRegisters() error {
+	err := hllMigrateScript.Run(context.Background(), getRedisClient(), []string{h.key}).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while converting registers of hyperloglog %s, error: %v", h.key, err)
+	}
+	return nil
+}
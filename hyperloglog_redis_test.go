@@ -1,9 +1,13 @@
 package gostatix
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"math"
 	"math/rand"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -46,6 +50,58 @@ func TestHyperLogLogRedisMerge(t *testing.T) {
 	}
 }
 
+func TestHyperLogLogRedisMergeKeepsRegisters(t *testing.T) {
+	initMockRedis()
+	f, _ := NewHyperLogLogRedis(16)
+	g, _ := NewHyperLogLogRedis(16)
+	f.Update([]byte("foo"))
+	g.Update([]byte("bar"))
+	err := f.Merge(g)
+	if err != nil {
+		t.Fatalf("merge shouldn't error out, error: %v", err)
+	}
+	length, _ := getRedisClient().StrLen(context.Background(), f.key).Result()
+	if length != 16 {
+		t.Errorf("merged hyperloglog should keep 16 registers, got %d", length)
+	}
+	expected, _ := NewHyperLogLogRedis(16)
+	expected.Update([]byte("foo"))
+	expected.Update([]byte("bar"))
+	if ok, _ := f.Equals(expected); !ok {
+		t.Errorf("merged hyperloglog should be equal to the one updated with both elements")
+	}
+}
+
+func TestHyperLogLogRedisMergeMany(t *testing.T) {
+	initMockRedis()
+	h, _ := NewHyperLogLogRedis(64)
+	expected, _ := NewHyperLogLogRedis(64)
+	keys := make([]string, 5)
+	for i := range keys {
+		g, _ := NewHyperLogLogRedis(64)
+		for j := 0; j < 20; j++ {
+			data := []byte(strconv.Itoa(i*20 + j))
+			g.Update(data)
+			expected.Update(data)
+		}
+		keys[i] = g.MetadataKey()
+	}
+	err := h.MergeMany(keys...)
+	if err != nil {
+		t.Fatalf("merge of many hyperloglogs shouldn't error out, error: %v", err)
+	}
+	if ok, _ := h.Equals(expected); !ok {
+		t.Errorf("hyperloglog merged with many should be equal to the one updated with all elements")
+	}
+	other, _ := NewHyperLogLogRedis(32)
+	if err = h.MergeMany(keys[0], other.MetadataKey()); err == nil {
+		t.Errorf("merge of hyperloglogs with different registers should error out")
+	}
+	if ok, _ := h.Equals(expected); !ok {
+		t.Errorf("failed merge shouldn't change the hyperloglog")
+	}
+}
+
 func TestHyperLogLogRedisEquals(t *testing.T) {
 	initMockRedis()
 	f, _ := NewHyperLogLogRedis(32)
@@ -155,3 +211,151 @@ func BenchmarkHLLRedisCount65536(b *testing.B) {
 		h.Count(true, true)
 	}
 }
+
+func TestHyperLogLogRedisUpdateFromReader(t *testing.T) {
+	initMockRedis()
+	var keys strings.Builder
+	for i := 0; i < 1000; i++ {
+		keys.WriteString(strconv.Itoa(i))
+		keys.WriteByte('\n')
+	}
+	h1, _ := NewHyperLogLogRedis(128)
+	count, err := h1.UpdateFromReader(strings.NewReader(keys.String()), '\n', nil)
+	if err != nil {
+		t.Fatalf("update from reader shouldn't error out, error: %v", err)
+	}
+	if count != 1000 {
+		t.Errorf("count should be 1000, found %d", count)
+	}
+	h2, _ := NewHyperLogLogRedis(128)
+	for i := 0; i < 1000; i++ {
+		h2.Update([]byte(strconv.Itoa(i)))
+	}
+	c1, _ := h1.Count(true, true)
+	c2, _ := h2.Count(true, true)
+	if c1 != c2 {
+		t.Errorf("cardinality should match the one from individual updates, got %d, expected %d", c1, c2)
+	}
+}
+
+func TestHyperLogLogRedisUpdateMulti(t *testing.T) {
+	initMockRedis()
+	h, _ := NewHyperLogLogRedis(128)
+	g, _ := NewHyperLogLogRedis(128)
+	data := make([][]byte, 1000)
+	for i := range data {
+		data[i] = []byte(strconv.Itoa(i % 300))
+		g.Update(data[i])
+	}
+	if err := h.UpdateMulti(data); err != nil {
+		t.Fatalf("batch update shouldn't error out, error: %v", err)
+	}
+	if err := h.UpdateMulti(nil); err != nil {
+		t.Errorf("empty batch shouldn't error out, error: %v", err)
+	}
+	if equal, _ := h.Equals(g); !equal {
+		t.Errorf("batch update should set the registers of the single updates")
+	}
+
+	f, _ := NewHyperLogLogRedis(128)
+	f.UpdateMulti([][]byte{[]byte("foo"), []byte("bar")})
+	counts, err := CountHyperLogLogsRedis(context.Background(), []*HyperLogLogRedis{h, f, g}, true, true)
+	if err != nil || len(counts) != 3 {
+		t.Fatalf("counts of 3 hyperloglogs should be returned, got %v, error: %v", counts, err)
+	}
+	for i, hll := range []*HyperLogLogRedis{h, f, g} {
+		if count, _ := hll.Count(true, true); counts[i] != count {
+			t.Errorf("count %d should be %d, got %d", i, count, counts[i])
+		}
+	}
+	if counts[1] == counts[0] {
+		t.Errorf("counts should be the ones of each hyperloglog, got %v", counts)
+	}
+}
+
+func TestHyperLogLogRedisRegisters(t *testing.T) {
+	initMockRedis()
+	ctx := context.Background()
+	h, _ := NewHyperLogLogRedis(1024)
+	for i := 0; i < 5000; i++ {
+		h.UpdateString(strconv.Itoa(i))
+	}
+	registers, err := getRedisClient().Get(ctx, h.key).Bytes()
+	if err != nil || len(registers) != 1024 {
+		t.Fatalf("registers should be stored as one byte per register, got %d bytes, error: %v", len(registers), err)
+	}
+	count, _ := h.Count(true, true)
+	data, _ := h.Export()
+	var exported hyperLogLogJSON
+	json.Unmarshal(data, &exported)
+	if !bytes.Equal(exported.Registers, registers) {
+		t.Errorf("exported registers should be the stored ones")
+	}
+
+	// registers saved as a list by a former version are converted when loaded
+	legacy := make([]interface{}, len(registers))
+	for i := range registers {
+		legacy[i] = registers[i]
+	}
+	getRedisClient().Del(ctx, h.key)
+	getRedisClient().RPush(ctx, h.key, legacy...)
+	loaded, err := NewHyperLogLogRedisFromKey(h.metadataKey)
+	if err != nil {
+		t.Fatalf("hyperloglog with list registers should be loaded, error: %v", err)
+	}
+	if kind, _ := getRedisClient().Type(ctx, h.key).Result(); kind != "string" {
+		t.Errorf("registers should be converted to a string, got a %s", kind)
+	}
+	if converted, _ := loaded.Count(true, true); converted != count {
+		t.Errorf("count of the converted registers should be %d, got %d", count, converted)
+	}
+
+	getRedisClient().SetRange(ctx, h.key, 1024, "x")
+	if _, err := loaded.Count(true, true); err == nil {
+		t.Errorf("count of registers of the wrong length should error out")
+	}
+}
+
+func TestHyperLogLogRedisEstimators(t *testing.T) {
+	initMockRedis()
+	h, err := NewHyperLogLogRedisWithEstimator(1024, HLLPlusPlusEstimator)
+	if err != nil {
+		t.Fatalf("hyperloglog with the hll++ estimator shouldn't error out, error: %v", err)
+	}
+	g, _ := NewHyperLogLogWithEstimator(1024, HLLPlusPlusEstimator)
+	data := make([][]byte, 3000)
+	for i := range data {
+		data[i] = []byte(strconv.Itoa(i))
+		g.Update(data[i])
+	}
+	h.UpdateMulti(data)
+	count, err := h.Count(true, true)
+	if err != nil || count != g.Count(true, true) {
+		t.Errorf("redis hyperloglog should count like the in-memory one, got %d and %d, error: %v", count, g.Count(true, true), err)
+	}
+
+	loaded, err := NewHyperLogLogRedisFromKey(h.MetadataKey())
+	if err != nil || loaded.Estimator() != HLLPlusPlusEstimator || loaded.Precision() != 10 {
+		t.Fatalf("estimator should be loaded from the metadata, got %v, error: %v", loaded.Estimator(), err)
+	}
+	legacy, _ := NewHyperLogLogRedis(1024)
+	if comparison, _ := h.Compare(legacy); comparison.Detail != "estimator hll++ and legacy" {
+		t.Errorf("hyperloglogs of different estimators should differ by their estimator, got %v", comparison)
+	}
+	if err := h.MergeMany(legacy.MetadataKey()); err == nil {
+		t.Errorf("merging hyperloglogs of different estimators should error out")
+	}
+	counts, _ := CountHyperLogLogsRedis(context.Background(), []*HyperLogLogRedis{h, legacy}, true, true)
+	if counts[0] != count {
+		t.Errorf("pipelined count should be %d, got %d", count, counts[0])
+	}
+
+	exported, _ := h.Export()
+	imported, _ := NewHyperLogLogRedis(16)
+	if err := imported.Import(exported, true); err != nil || imported.Estimator() != HLLPlusPlusEstimator {
+		t.Fatalf("estimator should be imported, got %v, error: %v", imported.Estimator(), err)
+	}
+	if err := h.SetCorrectionBias(math.Inf(1)); err == nil {
+		t.Errorf("infinite correction bias should error out")
+	}
+}
@@ -56,17 +56,17 @@ func TestHyperLogLogEquals(t *testing.T) {
 	g.Update([]byte("john"))
 	g.Update([]byte("jane"))
 
-	if f.Equals(g) || f.Equals(h) {
+	if equal(f.Equals(g)) || equal(f.Equals(h)) {
 		t.Errorf("f is neither equal to g nor h")
 	}
 
-	if !h.Equals(g) {
+	if !equal(h.Equals(g)) {
 		t.Errorf("h and g should be equal")
 	}
 
 	g.Update([]byte("alice"))
 
-	if h.Equals(g) {
+	if equal(h.Equals(g)) {
 		t.Errorf("h and g shouldn't be equal")
 	}
 }
@@ -93,7 +93,7 @@ func TestHyperLogLogImportExport(t *testing.T) {
 	f, _ := NewHyperLogLog(16)
 	f.Import(s2)
 
-	if !g.Equals(f) || !h.Equals(f) {
+	if !equal(g.Equals(f)) || !equal(h.Equals(f)) {
 		t.Errorf("h, g and f should be same")
 	}
 }
@@ -117,7 +117,7 @@ func TestHyperLogLogBinaryReadWrite(t *testing.T) {
 		t.Error("should not error out while reading from buffer")
 	}
 
-	if !g.Equals(h) {
+	if !equal(g.Equals(h)) {
 		t.Error("g and h should be equal")
 	}
 }
@@ -143,6 +143,19 @@ func BenchmarkHLLCount8192(b *testing.B) {
 	}
 }
 
+func BenchmarkHLLCountParallel8192(b *testing.B) {
+	h, _ := NewHyperLogLog(8192)
+	for i := 0; i < 10000; i++ {
+		h.Update([]byte(strconv.FormatUint(rand.Uint64(), 10)))
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			h.Count(true, true)
+		}
+	})
+}
+
 func BenchmarkHLLCount65536(b *testing.B) {
 	b.StopTimer()
 	h, _ := NewHyperLogLog(65536)
@@ -154,3 +167,119 @@ func BenchmarkHLLCount65536(b *testing.B) {
 		h.Count(true, true)
 	}
 }
+
+func TestHyperLogLogUpdateMulti(t *testing.T) {
+	h, _ := NewHyperLogLog(128)
+	g, _ := NewHyperLogLog(128)
+	data := make([][]byte, 1000)
+	for i := range data {
+		data[i] = []byte(strconv.Itoa(i % 300))
+		g.Update(data[i])
+	}
+	h.UpdateMulti(data)
+	if equal, _ := h.Equals(g); !equal {
+		t.Errorf("batch update should set the registers of the single updates")
+	}
+}
+
+func TestHyperLogLogEstimators(t *testing.T) {
+	for _, estimator := range []HyperLogLogEstimator{LogLogEstimator, HLLEstimator, HLLPlusPlusEstimator} {
+		h, err := NewHyperLogLogWithEstimator(1024, estimator)
+		if err != nil {
+			t.Fatalf("hyperloglog with the %v estimator shouldn't error out, error: %v", estimator, err)
+		}
+		if h.Precision() != 10 || h.Estimator() != estimator {
+			t.Errorf("precision and estimator should be 10 and %v, got %d and %v", estimator, h.Precision(), h.Estimator())
+		}
+		n := 0
+		for _, numDistinct := range []int{100, 1000, 3000, 100000} {
+			for ; n < numDistinct; n++ {
+				h.UpdateString(strconv.Itoa(n))
+			}
+			if estimator == LogLogEstimator && numDistinct < 3000 {
+				// loglog overestimates the cardinalities smaller than the number of registers
+				continue
+			}
+			count := h.Count(true, true)
+			if math.Abs(float64(count)/float64(numDistinct)-1) > 4*h.Accuracy() {
+				t.Errorf("%v estimator should count about %d distinct values, got %d", estimator, numDistinct, count)
+			}
+		}
+	}
+	if _, err := NewHyperLogLogWithEstimator(1024, HLLPlusPlusEstimator+1); err == nil {
+		t.Errorf("unknown estimator should error out")
+	}
+
+	h, _ := NewHyperLogLogWithEstimator(1024, HLLPlusPlusEstimator)
+	for i := 0; i < 200; i++ {
+		h.UpdateString(strconv.Itoa(i))
+	}
+	if count := h.Count(true, true); count < 196 || count > 204 {
+		// linear counting is almost exact for small cardinalities
+		t.Errorf("hll++ should count about 200 distinct values, got %d", count)
+	}
+	count := h.Count(false, false)
+	if err := h.SetCorrectionBias(2 * h.CorrectionBias()); err != nil {
+		t.Fatalf("setting the correction bias shouldn't error out, error: %v", err)
+	}
+	if doubled := h.Count(false, false); doubled < 2*count-1 || doubled > 2*count+1 {
+		t.Errorf("raw count should double with the correction bias, got %d instead of %d", doubled, 2*count)
+	}
+	if err := h.SetCorrectionBias(0); err == nil {
+		t.Errorf("zero correction bias should error out")
+	}
+
+	data, _ := h.Export()
+	imported, _ := NewHyperLogLog(16)
+	if err := imported.Import(data); err != nil || imported.Estimator() != HLLPlusPlusEstimator {
+		t.Fatalf("estimator should be imported, got %v, error: %v", imported.Estimator(), err)
+	}
+	var buf bytes.Buffer
+	h.WriteTo(&buf)
+	read, _ := NewHyperLogLog(16)
+	if _, err := read.ReadFrom(&buf); err != nil || read.Estimator() != HLLPlusPlusEstimator {
+		t.Fatalf("estimator should be read, got %v, error: %v", read.Estimator(), err)
+	}
+	if equal, _ := h.Equals(read); !equal {
+		t.Errorf("read hyperloglog should equal the written one")
+	}
+	g, _ := NewHyperLogLogWithEstimator(1024, HLLEstimator)
+	if comparison, _ := h.Compare(g); comparison.Detail != "estimator hll++ and hll" {
+		t.Errorf("hyperloglogs of different estimators should differ by their estimator, got %+v", comparison)
+	}
+	if err := h.Merge(g); err == nil {
+		t.Errorf("merging hyperloglogs of different estimators should error out")
+	}
+	if _, err := h.ExportPortable(); err == nil {
+		t.Errorf("portable export of the hll++ estimator should error out")
+	}
+}
+
+func TestHyperLogLogPlusPlusBias(t *testing.T) {
+	// the bias of the first raw estimate of a table is the mean bias of the first ones
+	expected := 0.0
+	for _, bias := range hllBiases[0][:hllBiasNeighbors] {
+		expected += bias
+	}
+	expected /= hllBiasNeighbors
+	if bias := getHLLBias(4, hllRawEstimates[0][0]); math.Abs(bias-expected) > 1e-9 {
+		t.Errorf("bias of the first raw estimate should be %f, got %f", expected, bias)
+	}
+	// the bias of the precisions above 18 is scaled from the precision 18
+	last := hllRawEstimates[len(hllRawEstimates)-1]
+	estimate := last[len(last)/2]
+	if bias, scaled := getHLLBias(18, estimate), getHLLBias(20, 4*estimate); math.Abs(scaled-4*bias) > 1e-6 {
+		t.Errorf("bias at the precision 20 should be 4 times the one at the precision 18, got %f and %f", scaled, bias)
+	}
+	if bias := getHLLBias(3, 20); bias != 0 {
+		t.Errorf("precisions below 4 shouldn't have a bias, got %f", bias)
+	}
+
+	h, _ := NewHyperLogLogWithEstimator(1<<14, HLLPlusPlusEstimator)
+	for n := 0; n < 30000; n++ {
+		h.UpdateString(strconv.Itoa(n))
+	}
+	if count := h.Count(true, true); math.Abs(float64(count)/30000-1) > 4*h.Accuracy() {
+		t.Errorf("hll++ estimator should count about 30000 distinct values, got %d", count)
+	}
+}
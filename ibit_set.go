@@ -5,11 +5,105 @@ for redis, bitset operations of redis are used.
 */
 package gostatix
 
-import "io"
+import (
+	"fmt"
+	"io"
+)
 
 const wordSize = int(64)
 const wordBytes = wordSize / 8
 
+var (
+	_ IBitSet = (*BitSetMem)(nil)
+	_ IBitSet = (*BitSetRedis)(nil)
+	_ IBitSet = (*ShardedBitSetRedis)(nil)
+	_ IBitSet = (*BitSetMmap)(nil)
+	_ IBitSet = (*BitSetRoaring)(nil)
+)
+
+// BitSet is the public API of the bitsets, which can be used on their own, independently
+// of the bloom filters. It's implemented by BitSetMem, BitSetMmap and BitSetRedis.
+// The indexes of the bits are between 0 and Size() - 1, the ranges [start, end) exclude
+// their end. The operations combining two bitsets apply to bitsets of the same type and
+// size. The in-memory bitsets aren't safe for concurrent use, the single bit operations
+// of BitSetRedis are atomic.
+type BitSet interface {
+	// Size returns the number of bits of the bitset
+	Size() uint
+
+	// Test returns true if the bit at _index_ is set
+	Test(index uint) (bool, error)
+
+	// Set sets the bit at _index_
+	Set(index uint) error
+
+	// Clear unsets the bit at _index_
+	Clear(index uint) error
+
+	// Flip flips the bit at _index_
+	Flip(index uint) error
+
+	// SetRange sets the bits in [_start_, _end_)
+	SetRange(start, end uint) error
+
+	// ClearRange unsets the bits in [_start_, _end_)
+	ClearRange(start, end uint) error
+
+	// FlipRange flips the bits in [_start_, _end_)
+	FlipRange(start, end uint) error
+
+	// Count returns the number of bits set
+	Count() (uint, error)
+
+	// And unsets the bits which aren't set in _other_
+	And(other BitSet) error
+
+	// Or sets the bits set in _other_
+	Or(other BitSet) error
+
+	// Xor flips the bits set in _other_
+	Xor(other BitSet) error
+
+	// Not flips all the bits
+	Not() error
+
+	// Grow extends the bitset to _size_ bits, the new bits are unset
+	Grow(size uint) error
+
+	// Shrink truncates the bitset to its first _size_ bits
+	Shrink(size uint) error
+
+	// NextSet returns the index of the first bit set from _index_ included, false if none
+	NextSet(index uint) (uint, bool, error)
+
+	// ForEach calls _fn_ with the index of each bit set in increasing order, until _fn_
+	// returns false
+	ForEach(fn func(index uint) bool) error
+}
+
+var (
+	_ BitSet = (*BitSetMem)(nil)
+	_ BitSet = (*BitSetRedis)(nil)
+	_ BitSet = (*BitSetMmap)(nil)
+)
+
+// checkBitIndex returns an error if _index_ is out of a bitset of _size_ bits
+func checkBitIndex(index, size uint) error {
+	if index >= size {
+		return fmt.Errorf("gostatix: index %d is out of range of bitset of size %d", index, size)
+	}
+	return nil
+}
+
+// checkBitRange returns an error if [_start_, _end_) isn't a range of a bitset of _size_
+// bits
+func checkBitRange(start, end, size uint) error {
+	if start > end || end > size {
+		return fmt.Errorf("gostatix: invalid range [%d, %d) for bitset of size %d", start, end, size)
+	}
+	return nil
+}
+
 type IBitSet interface {
 	// Size returns the number of bits in the bitset
 	getSize() uint
@@ -27,6 +121,10 @@ type IBitSet interface {
 	// Insert sets the bits at the indices passed in the indexes array
 	insertMulti(indexes []uint) (bool, error)
 
+	// InsertMultiIfMissing atomically sets the bits at the indices passed in the indexes
+	// array and returns true if any of them wasn't set
+	insertMultiIfMissing(indexes []uint) (bool, error)
+
 	// Equals checks if two bitsets are equal
 	equals(otherBitSet IBitSet) (bool, error)
 
@@ -37,6 +135,16 @@ type IBitSet interface {
 	// BitCount returns the total number of set bits in the bitset
 	bitCount() (uint, error)
 
+	// Union sets the bits set in another bitset of the same type and size
+	union(otherBitSet IBitSet) error
+
+	// Clear unsets all the bits of the bitset, keeping its size
+	clear() error
+
+	// MemoryUsage returns the number of bytes used by the bits of the bitset, in-process
+	// or in Redis
+	memoryUsage() (uint64, error)
+
 	// Export returns the json marshalling of the bitset
 	marshal() (uint, []byte, error)
 
@@ -62,3 +170,26 @@ func isBitSetMem(t interface{}) bool {
 		return false
 	}
 }
+
+// isBitSetInProcess checks if the bitset `t` lives in the memory of the process, i.e.
+// it's a BitSetMem, a BitSetMmap or a BitSetRoaring
+func isBitSetInProcess(t interface{}) bool {
+	switch t.(type) {
+	case *BitSetMem, *BitSetMmap, *BitSetRoaring:
+		return true
+	default:
+		return false
+	}
+}
+
+// asBitSetMem returns the BitSetMem of an in-process bitset `t`, if it's one
+func asBitSetMem(t IBitSet) (*BitSetMem, bool) {
+	switch bitSet := t.(type) {
+	case *BitSetMem:
+		return bitSet, true
+	case *BitSetMmap:
+		return bitSet.BitSetMem, true
+	default:
+		return nil, false
+	}
+}
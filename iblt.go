@@ -0,0 +1,405 @@
+/*
+Implements the invertible Bloom lookup table, used to reconcile the sets of two nodes by
+exchanging sketches whose size depends on the size of their difference rather than on the
+size of the sets.
+
+Invertible Bloom lookup table: each key is added to k cells, one in each of k partitions
+of the table. A cell holds the number of keys added to it, the XOR of the keys and the
+XOR of their check hashes. A cell holding a single key gives it back, and removing that
+key from its other cells may leave more cells with a single key, so the keys are listed
+by peeling the table as long as the number of keys is below about 75% of the number of
+cells. Subtracting the table of a set B from the table of a set A leaves the keys of A
+which aren't in B, with a positive count, and the keys of B which aren't in A, with a
+negative count. Refer: https://arxiv.org/abs/1101.2245
+
+The in-memory data structure is thread-safe.
+*/
+package gostatix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"unsafe"
+
+	"github.com/dgryski/go-metro"
+)
+
+// ErrIBLTIncomplete is returned by ListEntries when the table holds too many keys to
+// list all of them, along with the keys which could be listed
+var ErrIBLTIncomplete = errors.New("gostatix: invertible bloom lookup table can't be fully listed")
+
+// ibltLengthSize is the number of bytes of the XOR of the key lengths in a cell
+const ibltLengthSize = 2
+
+// ibltCheckSeed is the seed of the check hashes of the keys, which differ from the hashes
+// selecting their cells
+const ibltCheckSeed = 7919
+
+// IBLT struct. This is an in-memory implementation of an invertible Bloom lookup table.
+// _numCells_ is the number of cells, a multiple of _numHashes_
+// _numHashes_ is the number of cells of a key, one per partition of the table
+// _keySize_ is the maximum length of a key in bytes
+// _counts_ holds the number of keys of each cell
+// _keySums_ holds the XOR of the lengths and of the keys of each cell, padded with zeros
+// to _keySize_, in cells of _ibltLengthSize_ + _keySize_ bytes
+// _hashSums_ holds the XOR of the check hashes of the keys of each cell
+// _lock_ is used to synchronize concurrent read/writes
+type IBLT struct {
+	numCells  uint64
+	numHashes uint64
+	keySize   uint64
+	counts    []int64
+	keySums   []byte
+	hashSums  []uint64
+	lock      sync.RWMutex
+}
+
+type ibltJSON struct {
+	NumCells  uint64   `json:"m"`
+	NumHashes uint64   `json:"k"`
+	KeySize   uint64   `json:"s"`
+	Counts    []int64  `json:"c"`
+	KeySums   []byte   `json:"ks"`
+	HashSums  []uint64 `json:"hs"`
+}
+
+// NewIBLT creates an IBLT with _numCells_ cells, rounded up to a multiple of _numHashes_,
+// holding keys of up to _keySize_ bytes
+// _numHashes_ is the number of cells of a key, 3 or 4 is usual
+func NewIBLT(numCells, numHashes uint64, keySize uint) (*IBLT, error) {
+	if numHashes < 2 {
+		return nil, fmt.Errorf("gostatix: invertible bloom lookup table number of hashes %d should be at least 2", numHashes)
+	}
+	if numCells < numHashes {
+		return nil, fmt.Errorf("gostatix: invertible bloom lookup table number of cells %d should be at least the number of hashes %d", numCells, numHashes)
+	}
+	numCells = (numCells + numHashes - 1) / numHashes * numHashes
+	err := checkIBLTParams(numCells, numHashes, uint64(keySize))
+	if err != nil {
+		return nil, err
+	}
+	return &IBLT{
+		numCells:  numCells,
+		numHashes: numHashes,
+		keySize:   uint64(keySize),
+		counts:    make([]int64, numCells),
+		keySums:   make([]byte, numCells*(ibltLengthSize+uint64(keySize))),
+		hashSums:  make([]uint64, numCells),
+	}, nil
+}
+
+// NewIBLTForDifferences creates an IBLT able to list a difference of _numDifferences_ keys
+// of up to _keySize_ bytes, once the table of the other set is subtracted from it. It has
+// 4 hashes and 1.5 cells per key plus 40 cells, so that a difference of that many keys is
+// listed with a probability above 99%.
+func NewIBLTForDifferences(numDifferences uint64, keySize uint) (*IBLT, error) {
+	if numDifferences == 0 {
+		return nil, fmt.Errorf("gostatix: invertible bloom lookup table number of differences should be greater than 0")
+	}
+	return NewIBLT(uint64(math.Ceil(1.5*float64(numDifferences)))+40, 4, keySize)
+}
+
+// checkIBLTParams returns an error if an IBLT can't have _numCells_ cells, _numHashes_
+// hashes and keys of _keySize_ bytes
+func checkIBLTParams(numCells, numHashes, keySize uint64) error {
+	if numHashes < 2 || numCells < numHashes || numCells%numHashes != 0 {
+		return fmt.Errorf("gostatix: invertible bloom lookup table number of cells %d should be a multiple of the number of hashes %d, which should be at least 2", numCells, numHashes)
+	}
+	if keySize == 0 || keySize >= 1<<(8*ibltLengthSize) {
+		return fmt.Errorf("gostatix: invertible bloom lookup table key size %d should be between 1 and %d", keySize, 1<<(8*ibltLengthSize)-1)
+	}
+	return checkSnapshotSize("invertible bloom lookup table", numCells, 16+ibltLengthSize+keySize)
+}
+
+// NumCells returns the number of cells of the IBLT
+func (t *IBLT) NumCells() uint64 {
+	return t.numCells
+}
+
+// NumHashes returns the number of cells of a key
+func (t *IBLT) NumHashes() uint64 {
+	return t.numHashes
+}
+
+// KeySize returns the maximum length of a key in bytes
+func (t *IBLT) KeySize() uint64 {
+	return t.keySize
+}
+
+// cellSize returns the number of bytes of the XOR of the keys of a cell
+func (t *IBLT) cellSize() uint64 {
+	return ibltLengthSize + t.keySize
+}
+
+// cells returns the cells of _key_, one per partition of the table. The double hashes are
+// mixed before they're reduced to a partition, otherwise two keys whose hashes are equal
+// modulo the size of the partitions would share all their cells, and neither of them
+// could be listed.
+func (t *IBLT) cells(key []byte) []uint64 {
+	hashes := getHashes(key)
+	partition := t.numCells / t.numHashes
+	cells := make([]uint64, t.numHashes)
+	for i := range cells {
+		hash := fmix64(hashes[0] + uint64(i)*hashes[1])
+		cells[i] = uint64(i)*partition + hash%partition
+	}
+	return cells
+}
+
+// add adds _count_ times _key_ to its cells, whose XORs are updated by _cellKey_, the
+// key prefixed by its length and padded to the cell size, and _hash_, its check hash
+func (t *IBLT) add(cells []uint64, cellKey []byte, hash uint64, count int64) {
+	size := t.cellSize()
+	for _, cell := range cells {
+		t.counts[cell] += count
+		t.hashSums[cell] ^= hash
+		sum := t.keySums[cell*size : (cell+1)*size]
+		for i := range cellKey {
+			sum[i] ^= cellKey[i]
+		}
+	}
+}
+
+// cellKey returns _key_ prefixed by its length and padded to the cell size
+func (t *IBLT) cellKey(key []byte) ([]byte, error) {
+	if uint64(len(key)) > t.keySize {
+		return nil, fmt.Errorf("gostatix: key of %d bytes exceeds the key size %d of the invertible bloom lookup table", len(key), t.keySize)
+	}
+	cellKey := make([]byte, t.cellSize())
+	binary.BigEndian.PutUint16(cellKey, uint16(len(key)))
+	copy(cellKey[ibltLengthSize:], key)
+	return cellKey, nil
+}
+
+// update adds _count_ times _key_ to the table
+func (t *IBLT) update(key []byte, count int64) error {
+	cellKey, err := t.cellKey(key)
+	if err != nil {
+		return err
+	}
+	cells := t.cells(key)
+	hash := metro.Hash64(key, ibltCheckSeed)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.add(cells, cellKey, hash, count)
+	return nil
+}
+
+// Insert adds _key_ to the IBLT. A key can't be longer than the key size of the table.
+func (t *IBLT) Insert(key []byte) error {
+	return t.update(key, 1)
+}
+
+// InsertString adds _key_ (string) to the IBLT
+func (t *IBLT) InsertString(key string) error {
+	return t.Insert([]byte(key))
+}
+
+// Delete removes _key_ from the IBLT. Deleting a key which wasn't inserted leaves it in
+// the table with a negative count, which ListEntries lists as deleted.
+func (t *IBLT) Delete(key []byte) error {
+	return t.update(key, -1)
+}
+
+// DeleteString removes _key_ (string) from the IBLT
+func (t *IBLT) DeleteString(key string) error {
+	return t.Delete([]byte(key))
+}
+
+// Subtract removes the keys of the IBLT _other_ from the IBLT, which should have the same
+// number of cells, number of hashes and key size. The keys left with a positive count are
+// the keys inserted in this table and not in _other_, the ones left with a negative count
+// are the keys inserted in _other_ and not in this table.
+func (t *IBLT) Subtract(other *IBLT) error {
+	if t.numCells != other.numCells || t.numHashes != other.numHashes || t.keySize != other.keySize {
+		return fmt.Errorf("gostatix: invertible bloom lookup tables with %d, %d cells, %d, %d hashes and key sizes %d, %d can't be subtracted",
+			t.numCells, other.numCells, t.numHashes, other.numHashes, t.keySize, other.keySize)
+	}
+	if t == other {
+		t.Reset()
+		return nil
+	}
+	other.lock.RLock()
+	counts := append([]int64(nil), other.counts...)
+	keySums := append([]byte(nil), other.keySums...)
+	hashSums := append([]uint64(nil), other.hashSums...)
+	other.lock.RUnlock()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for i := range t.counts {
+		t.counts[i] -= counts[i]
+		t.hashSums[i] ^= hashSums[i]
+	}
+	for i := range t.keySums {
+		t.keySums[i] ^= keySums[i]
+	}
+	return nil
+}
+
+// ListEntries lists the keys of the IBLT without modifying it: the keys with a positive
+// count in _inserted_ and the ones with a negative count in _deleted_, e.g. after
+// subtracting the table of another set. If the table holds too many keys to list all of
+// them, the keys which could be listed are returned along with ErrIBLTIncomplete.
+func (t *IBLT) ListEntries() (inserted [][]byte, deleted [][]byte, err error) {
+	t.lock.RLock()
+	counts := append([]int64(nil), t.counts...)
+	keySums := append([]byte(nil), t.keySums...)
+	hashSums := append([]uint64(nil), t.hashSums...)
+	t.lock.RUnlock()
+	peeled := &IBLT{numCells: t.numCells, numHashes: t.numHashes, keySize: t.keySize, counts: counts, keySums: keySums, hashSums: hashSums}
+
+	size := t.cellSize()
+	pending := make([]uint64, 0, t.numCells)
+	for cell := uint64(0); cell < t.numCells; cell++ {
+		pending = append(pending, cell)
+	}
+	for len(pending) > 0 {
+		cell := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		count := peeled.counts[cell]
+		if count != 1 && count != -1 {
+			continue
+		}
+		cellKey := append([]byte(nil), peeled.keySums[cell*size:(cell+1)*size]...)
+		length := uint64(binary.BigEndian.Uint16(cellKey))
+		if length > t.keySize || !allZeros(cellKey[ibltLengthSize+length:]) {
+			continue
+		}
+		key := cellKey[ibltLengthSize : ibltLengthSize+length]
+		hash := metro.Hash64(key, ibltCheckSeed)
+		if hash != peeled.hashSums[cell] {
+			continue
+		}
+		cells := peeled.cells(key)
+		peeled.add(cells, cellKey, hash, -count)
+		pending = append(pending, cells...)
+		if count > 0 {
+			inserted = append(inserted, key)
+		} else {
+			deleted = append(deleted, key)
+		}
+	}
+	for i := range peeled.counts {
+		if peeled.counts[i] != 0 || peeled.hashSums[i] != 0 {
+			return inserted, deleted, ErrIBLTIncomplete
+		}
+	}
+	if !allZeros(peeled.keySums) {
+		return inserted, deleted, ErrIBLTIncomplete
+	}
+	return inserted, deleted, nil
+}
+
+// allZeros returns true if all the bytes of _data_ are zeros
+func allZeros(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Reset removes all the keys from the IBLT
+func (t *IBLT) Reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for i := range t.counts {
+		t.counts[i] = 0
+		t.hashSums[i] = 0
+	}
+	for i := range t.keySums {
+		t.keySums[i] = 0
+	}
+}
+
+// Equals checks if two IBLT data structures are equal
+func (t *IBLT) Equals(other *IBLT) (bool, error) {
+	comparison, err := t.Compare(other)
+	return comparison.Equal(), err
+}
+
+// Compare compares two IBLT data structures and returns why they aren't equal, if they
+// aren't
+func (t *IBLT) Compare(other *IBLT) (Comparison, error) {
+	if t.numCells != other.numCells {
+		return parameterMismatch("numCells", t.numCells, other.numCells), nil
+	}
+	if t.numHashes != other.numHashes {
+		return parameterMismatch("numHashes", t.numHashes, other.numHashes), nil
+	}
+	if t.keySize != other.keySize {
+		return parameterMismatch("keySize", t.keySize, other.keySize), nil
+	}
+	if t == other {
+		return equalComparison, nil
+	}
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	other.lock.RLock()
+	defer other.lock.RUnlock()
+
+	for i := range t.counts {
+		if t.counts[i] != other.counts[i] || t.hashSums[i] != other.hashSums[i] {
+			return contentMismatch("cells"), nil
+		}
+	}
+	if !bytes.Equal(t.keySums, other.keySums) {
+		return contentMismatch("cells"), nil
+	}
+	return equalComparison, nil
+}
+
+// MemoryUsage returns the estimated number of bytes used in-process by the IBLT
+func (t *IBLT) MemoryUsage() uint64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return uint64(unsafe.Sizeof(*t)) + uint64(cap(t.counts)*8+cap(t.keySums)+cap(t.hashSums)*8)
+}
+
+// Export JSON marshals the IBLT and returns a byte slice containing the data
+func (t *IBLT) Export() ([]byte, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return json.Marshal(ibltJSON{t.numCells, t.numHashes, t.keySize, t.counts, t.keySums, t.hashSums})
+}
+
+// Import JSON unmarshals the _data_ into the IBLT
+func (t *IBLT) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
+	var g ibltJSON
+	err = json.Unmarshal(data, &g)
+	if err != nil {
+		return err
+	}
+	err = checkIBLTParams(g.NumCells, g.NumHashes, g.KeySize)
+	if err != nil {
+		return fmt.Errorf("gostatix: invalid invertible bloom lookup table snapshot, error: %v", err)
+	}
+	if uint64(len(g.Counts)) != g.NumCells || uint64(len(g.HashSums)) != g.NumCells || uint64(len(g.KeySums)) != g.NumCells*(ibltLengthSize+g.KeySize) {
+		return fmt.Errorf("gostatix: invalid invertible bloom lookup table snapshot, cells don't match the %d cells of %d bytes", g.NumCells, g.KeySize)
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.numCells = g.NumCells
+	t.numHashes = g.NumHashes
+	t.keySize = g.KeySize
+	t.counts = g.Counts
+	t.keySums = g.KeySums
+	t.hashSums = g.HashSums
+	return nil
+}
@@ -0,0 +1,116 @@
+package gostatix
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func sortedKeys(keys [][]byte) []string {
+	sorted := make([]string, len(keys))
+	for i, key := range keys {
+		sorted[i] = string(key)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func TestIBLTSubtract(t *testing.T) {
+	a, err := NewIBLTForDifferences(100, 16)
+	if err != nil {
+		t.Fatalf("table creation shouldn't error out, error: %v", err)
+	}
+	b, _ := NewIBLTForDifferences(100, 16)
+	// 10000 common keys, 60 keys only in a and 40 only in b
+	for i := 0; i < 10000; i++ {
+		a.InsertString("key" + strconv.Itoa(i))
+		b.InsertString("key" + strconv.Itoa(i))
+	}
+	for i := 0; i < 60; i++ {
+		a.InsertString("a" + strconv.Itoa(i))
+	}
+	for i := 0; i < 40; i++ {
+		b.InsertString("b" + strconv.Itoa(i))
+	}
+	if _, _, err := a.ListEntries(); !errors.Is(err, ErrIBLTIncomplete) {
+		t.Errorf("table holding 10060 keys shouldn't be fully listed, error: %v", err)
+	}
+	err = a.Subtract(b)
+	if err != nil {
+		t.Fatalf("subtract shouldn't error out, error: %v", err)
+	}
+	inserted, deleted, err := a.ListEntries()
+	if err != nil {
+		t.Fatalf("difference of 100 keys should be listed, error: %v", err)
+	}
+	if len(inserted) != 60 || len(deleted) != 40 {
+		t.Fatalf("difference should have 60 inserted and 40 deleted keys, got %d and %d", len(inserted), len(deleted))
+	}
+	for i, key := range sortedKeys(inserted) {
+		if key[0] != 'a' {
+			t.Errorf("inserted key %d should be only in a, got %s", i, key)
+		}
+	}
+	for i, key := range sortedKeys(deleted) {
+		if key[0] != 'b' {
+			t.Errorf("deleted key %d should be only in b, got %s", i, key)
+		}
+	}
+	if d, _ := a.Describe(); d.Type != "iblt" || d.Count != 20 {
+		t.Errorf("description should be of an iblt with a net count of 20, got %+v", d)
+	}
+
+	c, _ := NewIBLT(30, 3, 8)
+	if err := a.Subtract(c); err == nil {
+		t.Errorf("tables with different parameters shouldn't be subtracted")
+	}
+	if err := c.InsertString("longer than 8 bytes"); err == nil {
+		t.Errorf("key longer than the key size should error out")
+	}
+	if _, err := NewIBLT(30, 1, 8); err == nil {
+		t.Errorf("table with a single hash should error out")
+	}
+}
+
+func TestIBLTListEntries(t *testing.T) {
+	table, _ := NewIBLT(31, 3, 8)
+	if table.NumCells() != 33 {
+		t.Errorf("number of cells should be rounded up to 33, got %d", table.NumCells())
+	}
+	table.InsertString("")
+	table.InsertString("cat")
+	table.InsertString("dog")
+	table.DeleteString("owl")
+	table.InsertString("fox")
+	table.DeleteString("fox")
+	inserted, deleted, err := table.ListEntries()
+	if err != nil {
+		t.Fatalf("list entries shouldn't error out, error: %v", err)
+	}
+	if keys := sortedKeys(inserted); len(keys) != 3 || keys[0] != "" || keys[1] != "cat" || keys[2] != "dog" {
+		t.Errorf("inserted keys should be the empty key, cat and dog, got %q", keys)
+	}
+	if keys := sortedKeys(deleted); len(keys) != 1 || keys[0] != "owl" {
+		t.Errorf("deleted keys should be owl, got %q", keys)
+	}
+
+	data, err := table.Export()
+	if err != nil {
+		t.Fatalf("export shouldn't error out, error: %v", err)
+	}
+	imported, _ := NewIBLT(3, 3, 1)
+	if err := imported.Import(data); err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if equal, _ := imported.Equals(table); !equal {
+		t.Errorf("imported table should equal the exported one")
+	}
+	if err := imported.Import([]byte(`{"m":33,"k":3,"s":8,"c":[],"ks":"","hs":[]}`)); err == nil {
+		t.Errorf("snapshot without cells should error out")
+	}
+	table.Subtract(table)
+	if inserted, deleted, err := table.ListEntries(); len(inserted)+len(deleted) != 0 || err != nil {
+		t.Errorf("table subtracted from itself should be empty, error: %v", err)
+	}
+}
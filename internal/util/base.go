@@ -1,19 +1,15 @@
 package util
 
 import (
+	"crypto/rand"
+	"fmt"
 	"math"
-	"math/rand"
-	"time"
-	"unsafe"
 )
 
-var src = rand.NewSource(time.Now().UnixNano())
-
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 const (
 	letterIdxBits = 6                    // 6 bits to represent a letter index
 	letterIdxMask = 1<<letterIdxBits - 1 // All 1-bits, as many as letterIdxBits
-	letterIdxMax  = 63 / letterIdxBits   // # of letter indices fitting in 63 bits
 )
 
 func CalculateFilterSize(length uint, errorRate float64) uint {
@@ -29,22 +25,26 @@ func CalculateFingerPrintLength(size uint64, errorRate float64) uint64 {
 	return uint64(math.Ceil(v / 8)) //gostatix uses 64 bit hash for cuckoo filter
 }
 
+// GenerateRandomString returns a string of _n_ letters read from crypto/rand, so that
+// the keys generated concurrently or by different processes don't follow each other.
+// The random bytes whose low 6 bits don't index a letter are skipped to keep the letters
+// uniformly distributed.
 func GenerateRandomString(n int) string {
 	b := make([]byte, n)
-	// A src.Int63() generates 63 random bits, enough for letterIdxMax characters!
-	for i, cache, remain := n-1, src.Int63(), letterIdxMax; i >= 0; {
-		if remain == 0 {
-			cache, remain = src.Int63(), letterIdxMax
+	buf := make([]byte, n+n/4+8)
+	for i := 0; i < n; {
+		_, err := rand.Read(buf)
+		if err != nil {
+			panic(fmt.Sprintf("gostatix: error while reading random bytes, error: %v", err))
 		}
-		if idx := int(cache & letterIdxMask); idx < len(letterBytes) {
-			b[i] = letterBytes[idx]
-			i--
+		for _, r := range buf {
+			if idx := int(r & letterIdxMask); idx < len(letterBytes) && i < n {
+				b[i] = letterBytes[idx]
+				i++
+			}
 		}
-		cache >>= letterIdxBits
-		remain--
 	}
-
-	return *(*string)(unsafe.Pointer(&b))
+	return string(b)
 }
 
 func ReverseBytes(slice []byte) {
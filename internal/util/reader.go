@@ -0,0 +1,62 @@
+package util
+
+import (
+	"bufio"
+	"io"
+)
+
+// ProgressInterval is the number of keys after which the progress callback
+// of ReadKeys is invoked
+const ProgressInterval = 100000
+
+const readerBufferSize = 64 * 1024
+
+// ReadKeys streams the keys separated by _delim_ from _stream_ and calls _fn_ for every
+// non-empty key. A trailing '\r' is dropped when _delim_ is '\n'.
+// The key passed to _fn_ is only valid until _fn_ returns.
+// _progress_ (if not nil) is called with the number of keys read every ProgressInterval
+// keys and once more at the end of the stream.
+// It returns the total number of keys read.
+func ReadKeys(stream io.Reader, delim byte, progress func(uint64), fn func(key []byte) error) (uint64, error) {
+	reader := bufio.NewReaderSize(stream, readerBufferSize)
+	var pending []byte
+	count := uint64(0)
+	for {
+		line, err := reader.ReadSlice(delim)
+		if err == bufio.ErrBufferFull {
+			pending = append(pending, line...)
+			continue
+		}
+		if len(pending) > 0 {
+			pending = append(pending, line...)
+			line = pending
+		}
+		if len(line) > 0 && line[len(line)-1] == delim {
+			line = line[:len(line)-1]
+		}
+		if delim == '\n' && len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+		if len(line) > 0 {
+			fnErr := fn(line)
+			if fnErr != nil {
+				return count, fnErr
+			}
+			count++
+			if progress != nil && count%ProgressInterval == 0 {
+				progress(count)
+			}
+		}
+		pending = pending[:0]
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+	if progress != nil {
+		progress(count)
+	}
+	return count, nil
+}
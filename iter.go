@@ -0,0 +1,85 @@
+//go:build go1.23
+
+/*
+Implements the iterators of the data structures, consumed with range-over-func loops.
+They're only built by Go 1.23 and later, the methods returning slices are available
+with all the supported versions.
+*/
+package gostatix
+
+import (
+	"iter"
+	"sort"
+)
+
+// All returns an iterator over the top _k_ elements of the TopK and their counts, in the
+// order of Values. The elements are read under the lock before the iteration starts, so
+// that the loop body can update the TopK.
+func (t *TopK) All() iter.Seq2[string, uint64] {
+	t.lock.RLock()
+	heap := append([]heapElement(nil), t.heap...)
+	t.lock.RUnlock()
+	sort.Slice(heap, func(i, j int) bool {
+		if heap[i].frequency == heap[j].frequency {
+			return heap[i].value < heap[j].value
+		}
+		return heap[i].frequency > heap[j].frequency
+	})
+	return func(yield func(string, uint64) bool) {
+		for _, element := range heap {
+			if !yield(element.value, element.frequency) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over the slots of the bucket holding an element and their
+// elements
+func (bucket *BucketMem) All() iter.Seq2[int, string] {
+	return func(yield func(int, string) bool) {
+		for i, element := range bucket.elements {
+			if element != "" && !yield(i, element) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over the indexes of the bits set, in increasing order
+func (bitSet *BitSetMem) All() iter.Seq[uint] {
+	return func(yield func(uint) bool) {
+		bitSet.ForEach(yield)
+	}
+}
+
+// All returns an iterator over the indexes of the bits set, in increasing order. The
+// string is read lazily in chunks of _redisChunkSize_ bytes, the iteration stops early on
+// a Redis error.
+func (bitSet *BitSetRedis) All() iter.Seq[uint] {
+	return func(yield func(uint) bool) {
+		bitSet.ForEach(yield)
+	}
+}
+
+// All returns an iterator over the members of the collection and their names, in the
+// order of Names. The members are read under the lock before the iteration starts and
+// aren't marked as used, unlike with Get.
+func (c *Collection[T]) All() iter.Seq2[string, T] {
+	c.lock.RLock()
+	names := make([]string, 0, len(c.members))
+	members := make(map[string]T, len(c.members))
+	for name, entry := range c.members {
+		names = append(names, name)
+		members[name] = entry.member
+	}
+	c.lock.RUnlock()
+	sort.Strings(names)
+	return func(yield func(string, T) bool) {
+		for _, name := range names {
+			if !yield(name, members[name]) {
+				return
+			}
+		}
+	}
+}
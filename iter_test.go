@@ -0,0 +1,86 @@
+//go:build go1.23
+
+package gostatix
+
+import (
+	"fmt"
+	"iter"
+	"testing"
+)
+
+func TestTopKAll(t *testing.T) {
+	topK, _ := NewTopK(3, 0.001, 0.99)
+	for i, element := range []string{"a", "b", "c", "d"} {
+		topK.InsertString(element, uint64(i+1))
+	}
+	topK.InsertString("b", 2)
+	var elements []string
+	for element, count := range topK.All() {
+		elements = append(elements, fmt.Sprintf("%s:%d", element, count))
+		// the loop body can update the topk without deadlocking
+		topK.InsertString("e", 1)
+	}
+	if fmt.Sprint(elements) != "[b:4 d:4 c:3]" {
+		t.Errorf("elements should be iterated by decreasing count, got %v", elements)
+	}
+	for range topK.All() {
+		break
+	}
+}
+
+func TestBucketMemAll(t *testing.T) {
+	bucket := newBucketMem(4)
+	bucket.add("12")
+	bucket.add("34")
+	bucket.add("56")
+	bucket.remove("34")
+	var slots []string
+	for slot, element := range bucket.All() {
+		slots = append(slots, fmt.Sprintf("%d:%s", slot, element))
+	}
+	if fmt.Sprint(slots) != "[0:12 2:56]" {
+		t.Errorf("only the slots holding an element should be iterated, got %v", slots)
+	}
+}
+
+func TestBitSetAll(t *testing.T) {
+	initMockRedis()
+	redisBitSet, _ := NewBitSetRedis(100)
+	for _, bitSet := range []interface {
+		BitSet
+		All() iter.Seq[uint]
+	}{NewBitSetMem(100), redisBitSet} {
+		bitSet.Set(3)
+		bitSet.Set(70)
+		bitSet.Set(99)
+		var indexes []uint
+		for index := range bitSet.All() {
+			if index == 99 {
+				break
+			}
+			indexes = append(indexes, index)
+		}
+		if fmt.Sprint(indexes) != "[3 70]" {
+			t.Errorf("%T: bits set should be iterated in order, got %v", bitSet, indexes)
+		}
+	}
+}
+
+func TestCollectionAll(t *testing.T) {
+	collection, _ := NewCollection(func(name string) (*HyperLogLog, error) {
+		return NewHyperLogLog(16)
+	}, 0)
+	defer collection.Close()
+	collection.GetOrCreate("b")
+	collection.GetOrCreate("a")
+	var names []string
+	for name, member := range collection.All() {
+		if member == nil {
+			t.Errorf("member %s shouldn't be nil", name)
+		}
+		names = append(names, name)
+	}
+	if fmt.Sprint(names) != "[a b]" {
+		t.Errorf("members should be iterated by name, got %v", names)
+	}
+}
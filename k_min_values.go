@@ -0,0 +1,310 @@
+/*
+Implements probabilistic data structure k-minimum values used in estimating unique entries
+in a large dataset.
+
+K-Minimum Values: A probabilistic data structure which keeps the k smallest hashes of the
+elements. As the hashes are uniformly distributed, the k-th smallest one gives an estimate
+of the cardinality. Unlike HyperLogLog, the hashes kept by two sketches can be compared,
+which gives estimates of the size of the intersection of the two sets.
+Refer: https://dl.acm.org/doi/10.1145/1247480.1247504
+
+The in-memory data structure is thread-safe.
+*/
+package gostatix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"unsafe"
+
+	"github.com/dgryski/go-metro"
+	"github.com/kwertop/gostatix/internal/util"
+)
+
+// KMinValues struct. This is an in-memory implementation of K-Minimum Values.
+// _k_ is the number of minimum hashes kept
+// _hashes_ holds the smallest distinct hashes seen so far in ascending order
+// _lock_ is used to synchronize concurrent read/writes
+type KMinValues struct {
+	k         uint64
+	hashes    []uint64
+	lock      sync.RWMutex
+	resources resources
+}
+
+type kMinValuesJSON struct {
+	K      uint64   `json:"k"`
+	Hashes []uint64 `json:"h"`
+}
+
+// NewKMinValues creates new KMinValues keeping the _k_ smallest hashes. The relative
+// standard error of the estimates is about 1/sqrt(_k_).
+func NewKMinValues(k uint64) (*KMinValues, error) {
+	err := KMinValuesParams{k}.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &KMinValues{k: k}, nil
+}
+
+// K returns the number of minimum hashes kept by the KMinValues
+func (h *KMinValues) K() uint64 {
+	return h.k
+}
+
+// Accuracy returns the relative standard error of the estimates of the KMinValues
+func (h *KMinValues) Accuracy() float64 {
+	return 1 / math.Sqrt(float64(h.k))
+}
+
+// Reset removes all the hashes from the KMinValues
+func (h *KMinValues) Reset() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.hashes = h.hashes[:0]
+}
+
+// Update adds the hash of the passed _data_ (byte slice) to the KMinValues if it's one
+// of the k smallest
+func (h *KMinValues) Update(data []byte) {
+	hash, _ := metro.Hash128(data, 1373)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.hashes = insertMinHash(h.hashes, hash, h.k)
+}
+
+// UpdateString adds the hash of the passed _data_ (string) to the KMinValues if it's one
+// of the k smallest
+func (h *KMinValues) UpdateString(data string) {
+	h.Update([]byte(data))
+}
+
+// UpdateUint64 adds the hash of the passed _data_ (unsigned integer) to the KMinValues.
+// The integer is hashed as its 8 byte big endian encoding.
+func (h *KMinValues) UpdateUint64(data uint64) {
+	h.Update(uint64Bytes(data))
+}
+
+// UpdateKey adds the hash of the passed _data_ (Key) to the KMinValues
+func (h *KMinValues) UpdateKey(data Key) {
+	h.Update(data.KeyBytes())
+}
+
+// UpdateFromReader streams keys separated by _delim_ from _stream_ and updates the
+// KMinValues with each of them without holding all of them in memory.
+// _progress_ (optional, can be nil) is called periodically with the number of keys processed.
+// It returns the total number of keys processed.
+func (h *KMinValues) UpdateFromReader(stream io.Reader, delim byte, progress ProgressFunc) (uint64, error) {
+	return util.ReadKeys(stream, delim, progress, func(key []byte) error {
+		h.Update(key)
+		return nil
+	})
+}
+
+// Count returns the estimated number of distinct elements so far. The count is exact
+// while fewer than k distinct elements have been seen.
+func (h *KMinValues) Count() uint64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return estimateMinHashes(h.hashes, h.k)
+}
+
+// Merge merges two KMinValues data structures with the same k. The merged KMinValues
+// keeps the k smallest hashes of both.
+func (h *KMinValues) Merge(g *KMinValues) error {
+	if h == g {
+		return nil
+	}
+	if h.k != g.k {
+		return fmt.Errorf("gostatix: k %d, %d don't match", h.k, g.k)
+	}
+	g.lock.RLock()
+	other := append([]uint64(nil), g.hashes...)
+	g.lock.RUnlock()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.hashes = unionMinHashes(h.hashes, other, h.k)
+	return nil
+}
+
+// Intersection returns the estimated number of distinct elements seen by both KMinValues
+// data structures, which should have the same k. The estimate is the fraction of the
+// k smallest hashes of the union which are in both, times the estimated size of the union.
+func (h *KMinValues) Intersection(g *KMinValues) (uint64, error) {
+	if h.k != g.k {
+		return 0, fmt.Errorf("gostatix: k %d, %d don't match", h.k, g.k)
+	}
+	if h == g {
+		return h.Count(), nil
+	}
+	h.lock.RLock()
+	hashes := append([]uint64(nil), h.hashes...)
+	h.lock.RUnlock()
+	g.lock.RLock()
+	other := append([]uint64(nil), g.hashes...)
+	g.lock.RUnlock()
+
+	union := unionMinHashes(hashes, other, h.k)
+	numCommon := 0
+	for _, hash := range union {
+		if containsMinHash(hashes, hash) && containsMinHash(other, hash) {
+			numCommon++
+		}
+	}
+	if len(union) == 0 {
+		return 0, nil
+	}
+	estimate := float64(numCommon) / float64(len(union)) * float64(estimateMinHashes(union, h.k))
+	return uint64(math.Round(estimate)), nil
+}
+
+// Equals checks if two KMinValues data structures are equal. KMinValues with a different
+// k aren't equal.
+func (h *KMinValues) Equals(g *KMinValues) (bool, error) {
+	comparison, err := h.Compare(g)
+	return comparison.Equal(), err
+}
+
+// Compare compares two KMinValues data structures and returns why they aren't equal, if
+// they aren't
+func (h *KMinValues) Compare(g *KMinValues) (Comparison, error) {
+	if h.k != g.k {
+		return parameterMismatch("k", h.k, g.k), nil
+	}
+	if h == g {
+		return equalComparison, nil
+	}
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	if len(h.hashes) != len(g.hashes) {
+		return contentMismatch("hashes"), nil
+	}
+	for i := range h.hashes {
+		if h.hashes[i] != g.hashes[i] {
+			return contentMismatch("hashes"), nil
+		}
+	}
+	return equalComparison, nil
+}
+
+// MemoryUsage returns the estimated number of bytes used in-process by the KMinValues
+func (h *KMinValues) MemoryUsage() uint64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return uint64(unsafe.Sizeof(*h)) + uint64(cap(h.hashes)*8)
+}
+
+// Close releases the resources attached to the KMinValues.
+// It's safe to call Close multiple times.
+func (h *KMinValues) Close() error {
+	return h.resources.close()
+}
+
+// Export JSON marshals the KMinValues and returns a byte slice containing the data
+func (h *KMinValues) Export() ([]byte, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return json.Marshal(kMinValuesJSON{h.k, h.hashes})
+}
+
+// Import JSON unmarshals the _data_ into the KMinValues
+func (h *KMinValues) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
+	var g kMinValuesJSON
+	err = json.Unmarshal(data, &g)
+	if err != nil {
+		return err
+	}
+	err = KMinValuesParams{g.K}.Validate()
+	if err != nil {
+		return fmt.Errorf("gostatix: invalid k-minimum values snapshot, error: %v", err)
+	}
+	if uint64(len(g.Hashes)) > g.K {
+		return fmt.Errorf("gostatix: invalid k-minimum values snapshot, %d hashes found for k %d", len(g.Hashes), g.K)
+	}
+	for i := 1; i < len(g.Hashes); i++ {
+		if g.Hashes[i-1] >= g.Hashes[i] {
+			return fmt.Errorf("gostatix: invalid k-minimum values snapshot, hashes aren't sorted and distinct")
+		}
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.k = g.K
+	h.hashes = g.Hashes
+	return nil
+}
+
+// insertMinHash inserts _hash_ into the sorted _hashes_ if it isn't there yet and is one
+// of the _k_ smallest, and returns the updated slice
+func insertMinHash(hashes []uint64, hash uint64, k uint64) []uint64 {
+	i := sort.Search(len(hashes), func(i int) bool { return hashes[i] >= hash })
+	if i < len(hashes) && hashes[i] == hash {
+		return hashes
+	}
+	if uint64(len(hashes)) < k {
+		hashes = append(hashes, 0)
+	} else if i == len(hashes) {
+		return hashes
+	}
+	copy(hashes[i+1:], hashes[i:])
+	hashes[i] = hash
+	return hashes
+}
+
+// unionMinHashes returns the _k_ smallest distinct hashes of the sorted _a_ and _b_
+func unionMinHashes(a, b []uint64, k uint64) []uint64 {
+	size := uint64(len(a) + len(b))
+	if size > k {
+		size = k
+	}
+	union := make([]uint64, 0, size)
+	i, j := 0, 0
+	for uint64(len(union)) < k && (i < len(a) || j < len(b)) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i] < b[j]):
+			union = append(union, a[i])
+			i++
+		case i == len(a) || b[j] < a[i]:
+			union = append(union, b[j])
+			j++
+		default:
+			union = append(union, a[i])
+			i++
+			j++
+		}
+	}
+	return union
+}
+
+// containsMinHash returns true if _hash_ is in the sorted _hashes_
+func containsMinHash(hashes []uint64, hash uint64) bool {
+	i := sort.Search(len(hashes), func(i int) bool { return hashes[i] >= hash })
+	return i < len(hashes) && hashes[i] == hash
+}
+
+// estimateMinHashes estimates the number of distinct elements from their _k_ smallest
+// sorted _hashes_. The count is exact if fewer than _k_ hashes are kept.
+func estimateMinHashes(hashes []uint64, k uint64) uint64 {
+	if uint64(len(hashes)) < k {
+		return uint64(len(hashes))
+	}
+	kth := (float64(hashes[k-1]) + 1) / math.Pow(2, 64)
+	return uint64(math.Round(float64(k-1) / kth))
+}
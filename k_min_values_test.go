@@ -0,0 +1,82 @@
+package gostatix
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestKMinValuesCount(t *testing.T) {
+	h, _ := NewKMinValues(1024)
+	for i := 0; i < 100; i++ {
+		h.UpdateString(strconv.Itoa(i))
+		h.UpdateString(strconv.Itoa(i))
+	}
+	if count := h.Count(); count != 100 {
+		t.Errorf("count should be exact below k; got %d, exact %d", count, 100)
+	}
+	for i := 0; i < 100000; i++ {
+		h.UpdateString(strconv.Itoa(i))
+	}
+	count := h.Count()
+	if math.Abs(float64(count)-100000) > 100000*3*h.Accuracy() {
+		t.Errorf("too much variance in calculated distinct values; got %d, exact %d", count, 100000)
+	}
+}
+
+func TestKMinValuesMergeIntersection(t *testing.T) {
+	f, _ := NewKMinValues(1024)
+	g, _ := NewKMinValues(1024)
+	all, _ := NewKMinValues(1024)
+	for i := 0; i < 60000; i++ {
+		f.UpdateString(strconv.Itoa(i))
+		all.UpdateString(strconv.Itoa(i))
+	}
+	for i := 40000; i < 100000; i++ {
+		g.UpdateString(strconv.Itoa(i))
+		all.UpdateString(strconv.Itoa(i))
+	}
+	intersection, err := f.Intersection(g)
+	if err != nil {
+		t.Fatalf("intersection shouldn't error out, error: %v", err)
+	}
+	if math.Abs(float64(intersection)-20000) > 20000*0.2 {
+		t.Errorf("too much variance in calculated intersection; got %d, exact %d", intersection, 20000)
+	}
+	if err := f.Merge(g); err != nil {
+		t.Fatalf("merge shouldn't error out, error: %v", err)
+	}
+	if !equal(f.Equals(all)) {
+		t.Errorf("merged k-minimum values should be equal to the one of all the elements")
+	}
+	other, _ := NewKMinValues(16)
+	if f.Merge(other) == nil {
+		t.Errorf("merge of k-minimum values with different k should error out")
+	}
+	if _, err := f.Intersection(other); err == nil {
+		t.Errorf("intersection of k-minimum values with different k should error out")
+	}
+}
+
+func TestKMinValuesExportImport(t *testing.T) {
+	h, _ := NewKMinValues(16)
+	for i := 0; i < 100; i++ {
+		h.UpdateUint64(uint64(i))
+	}
+	data, err := h.Export()
+	if err != nil {
+		t.Fatalf("export shouldn't error out, error: %v", err)
+	}
+	g, _ := NewKMinValues(2)
+	if err := g.Import(data); err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if !equal(h.Equals(g)) {
+		t.Errorf("imported k-minimum values should be equal to the exported one")
+	}
+	for _, invalid := range []string{`{"k":1,"h":[]}`, `{"k":2,"h":[1,2,3]}`, `{"k":4,"h":[2,1]}`} {
+		if g.Import([]byte(invalid)) == nil {
+			t.Errorf("import of %s should error out", invalid)
+		}
+	}
+}
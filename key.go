@@ -0,0 +1,51 @@
+package gostatix
+
+import "encoding/binary"
+
+// Key is implemented by the values which can be inserted in, updated in or looked up
+// from the data structures using the *Key methods. KeyBytes returns the byte slice
+// which is hashed for the key.
+type Key interface {
+	KeyBytes() []byte
+}
+
+// BytesKey is a Key holding a byte slice, hashed as is
+type BytesKey []byte
+
+// KeyBytes returns the byte slice of the key
+func (k BytesKey) KeyBytes() []byte {
+	return k
+}
+
+// StringKey is a Key holding a string, hashed as its bytes. It's the same key as
+// the one used by the *String methods.
+type StringKey string
+
+// KeyBytes returns the bytes of the string
+func (k StringKey) KeyBytes() []byte {
+	return []byte(k)
+}
+
+// Uint64Key is a Key holding an unsigned integer, hashed as its 8 byte big endian
+// encoding. It's the same key as the one used by the *Uint64 methods.
+type Uint64Key uint64
+
+// KeyBytes returns the 8 byte big endian encoding of the integer
+func (k Uint64Key) KeyBytes() []byte {
+	return uint64Bytes(uint64(k))
+}
+
+// IntKey is a Key holding a signed integer, hashed as the 8 byte big endian encoding
+// of its two's complement. Non-negative values are the same keys as the equal Uint64Key.
+type IntKey int64
+
+// KeyBytes returns the 8 byte big endian encoding of the integer
+func (k IntKey) KeyBytes() []byte {
+	return uint64Bytes(uint64(k))
+}
+
+func uint64Bytes(value uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, value)
+	return data
+}
@@ -0,0 +1,113 @@
+package gostatix
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeyBytes(t *testing.T) {
+	if !bytes.Equal(Uint64Key(258).KeyBytes(), []byte{0, 0, 0, 0, 0, 0, 1, 2}) {
+		t.Errorf("uint64 key should be big endian encoded, got %v", Uint64Key(258).KeyBytes())
+	}
+	if !bytes.Equal(IntKey(258).KeyBytes(), Uint64Key(258).KeyBytes()) {
+		t.Error("non-negative int key should be the same as the uint64 key")
+	}
+	if bytes.Equal(IntKey(-1).KeyBytes(), IntKey(1).KeyBytes()) {
+		t.Error("negative int key shouldn't be the same as the positive one")
+	}
+	if !bytes.Equal(StringKey("abc").KeyBytes(), BytesKey("abc").KeyBytes()) {
+		t.Error("string key should be the same as the bytes key")
+	}
+}
+
+func TestKeyMethodsBloomFilter(t *testing.T) {
+	filter, _ := NewMemBloomFilterWithParameters(1000, 0.001)
+	filter.InsertUint64(42)
+	filter.InsertKey(IntKey(-7))
+	filter.InsertKey(StringKey("foo"))
+	if !filter.Lookup(uint64Bytes(42)) || !filter.LookupKey(Uint64Key(42)) {
+		t.Error("42 should be present in the bloom filter")
+	}
+	if !filter.LookupKey(IntKey(-7)) || !filter.LookupString("foo") {
+		t.Error("-7 and foo should be present in the bloom filter")
+	}
+	if filter.LookupUint64(43) {
+		t.Error("43 shouldn't be present in the bloom filter")
+	}
+}
+
+func TestKeyMethodsCuckooFilter(t *testing.T) {
+	filter, _ := NewCuckooFilter(100, 4, 8)
+	filter.InsertUint64(42, false)
+	filter.InsertString("foo", false)
+	if !filter.LookupKey(Uint64Key(42)) || !filter.LookupKey(StringKey("foo")) {
+		t.Error("42 and foo should be present in the cuckoo filter")
+	}
+	if !filter.RemoveUint64(42) || filter.LookupUint64(42) {
+		t.Error("42 should be removed from the cuckoo filter")
+	}
+	if !filter.RemoveString("foo") || filter.Length() != 0 {
+		t.Error("foo should be removed from the cuckoo filter")
+	}
+}
+
+func TestKeyMethodsCuckooFilterRedis(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewCuckooFilterRedis(100, 4, 8)
+	filter.InsertKey(IntKey(42), false)
+	found, err := filter.LookupUint64(42)
+	if err != nil || !found {
+		t.Errorf("42 should be present in the cuckoo filter, error: %v", err)
+	}
+	removed, err := filter.RemoveKey(Uint64Key(42))
+	if err != nil || !removed {
+		t.Errorf("42 should be removed from the cuckoo filter, error: %v", err)
+	}
+}
+
+func TestKeyMethodsCountMinSketch(t *testing.T) {
+	cms, _ := NewCountMinSketch(4, 1000)
+	cms.UpdateUint64(42, 3)
+	cms.UpdateKey(Uint64Key(42), 2)
+	if cms.CountUint64(42) != 5 || cms.Count(uint64Bytes(42)) != 5 {
+		t.Errorf("count of 42 should be 5, got %d", cms.CountUint64(42))
+	}
+	cms.UpdateKey(StringKey("foo"), 1)
+	if cms.CountString("foo") != 1 {
+		t.Errorf("count of foo should be 1, got %d", cms.CountString("foo"))
+	}
+}
+
+func TestKeyMethodsCountMinSketchRedis(t *testing.T) {
+	initMockRedis()
+	cms, _ := NewCountMinSketchRedis(4, 1000)
+	cms.UpdateUint64(42, 3)
+	cms.UpdateKey(IntKey(42), 2)
+	count, err := cms.CountKey(Uint64Key(42))
+	if err != nil || count != 5 {
+		t.Errorf("count of 42 should be 5, got %d, error: %v", count, err)
+	}
+}
+
+func TestKeyMethodsHyperLogLog(t *testing.T) {
+	h, _ := NewHyperLogLog(1024)
+	g, _ := NewHyperLogLog(1024)
+	h.UpdateUint64(42)
+	h.UpdateString("foo")
+	g.Update(uint64Bytes(42))
+	g.UpdateKey(BytesKey("foo"))
+	if !equal(h.Equals(g)) {
+		t.Error("hyperloglogs updated with the same keys should be equal")
+	}
+}
+
+func TestKeyMethodsTopK(t *testing.T) {
+	topk, _ := NewTopK(2, 0.001, 0.99)
+	topk.InsertUint64(42, 3)
+	topk.InsertKey(Uint64Key(42), 2)
+	topk.InsertString("foo", 1)
+	values := topk.Values()
+	if len(values) != 2 || values[0].Element() != string(uint64Bytes(42)) || values[0].Count() != 5 {
+		t.Errorf("42 should be the top element with count 5, got %v", values)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Implements the storage of data structures in an embedded key-value store.
+
+CuckooFilterKV and CountMinSketchKV keep their state in a KVStore instead of Redis, so that
+they persist without a Redis server, e.g. in edge deployments. KVStore is modeled on the
+transactions of embedded stores like bbolt or Badger, which can back it with a small adapter.
+The github.com/kwertop/gostatix/boltkv module is the one of bbolt.
+*/
+package gostatix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// KVStore is a transactional key-value store holding the state of the KV backed data
+// structures. Each operation of a data structure runs in a single transaction.
+type KVStore interface {
+	// View runs _fn_ in a read-only transaction
+	View(fn func(tx KVTx) error) error
+	// Update runs _fn_ in a read-write transaction, which is committed if _fn_ returns
+	// nil and rolled back otherwise
+	Update(fn func(tx KVTx) error) error
+}
+
+// KVTx is a transaction of a KVStore
+type KVTx interface {
+	// Get returns the value of _key_, or nil if it doesn't exist. The value is only
+	// valid during the transaction.
+	Get(key []byte) ([]byte, error)
+	// Set sets the _value_ of _key_
+	Set(key, value []byte) error
+	// Delete deletes _key_, it's a no-op if _key_ doesn't exist
+	Delete(key []byte) error
+}
+
+// memKVStore is an in-memory KVStore, whose transactions are serialized by _lock_
+type memKVStore struct {
+	data map[string][]byte
+	lock sync.RWMutex
+}
+
+// memKVTx is a transaction of memKVStore. The writes are buffered in _writes_, a nil
+// value marking a delete, and applied on commit.
+type memKVTx struct {
+	store    *memKVStore
+	writes   map[string][]byte
+	readOnly bool
+}
+
+// NewMemKVStore creates an in-memory KVStore. It doesn't persist anything, it's meant for
+// tests and for the data structures which only need the transactions of a KVStore.
+func NewMemKVStore() KVStore {
+	return &memKVStore{data: make(map[string][]byte)}
+}
+
+func (store *memKVStore) View(fn func(tx KVTx) error) error {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return fn(&memKVTx{store: store, readOnly: true})
+}
+
+func (store *memKVStore) Update(fn func(tx KVTx) error) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	tx := &memKVTx{store: store, writes: make(map[string][]byte)}
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+	for key, value := range tx.writes {
+		if value == nil {
+			delete(store.data, key)
+		} else {
+			store.data[key] = value
+		}
+	}
+	return nil
+}
+
+func (tx *memKVTx) Get(key []byte) ([]byte, error) {
+	if value, ok := tx.writes[string(key)]; ok {
+		return value, nil
+	}
+	return tx.store.data[string(key)], nil
+}
+
+func (tx *memKVTx) Set(key, value []byte) error {
+	if tx.readOnly {
+		return fmt.Errorf("gostatix: can't write in a read-only transaction")
+	}
+	tx.writes[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (tx *memKVTx) Delete(key []byte) error {
+	if tx.readOnly {
+		return fmt.Errorf("gostatix: can't write in a read-only transaction")
+	}
+	tx.writes[string(key)] = nil
+	return nil
+}
+
+// kvGetUint64 returns the uint64 stored at _key_, 0 if it doesn't exist
+func kvGetUint64(tx KVTx, key []byte) (uint64, error) {
+	value, err := tx.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if value == nil {
+		return 0, nil
+	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("gostatix: value of key %s isn't an uint64", key)
+	}
+	return binary.BigEndian.Uint64(value), nil
+}
+
+// kvSetUint64 stores _value_ at _key_ as 8 big endian bytes
+func kvSetUint64(tx KVTx, key []byte, value uint64) error {
+	return tx.Set(key, binary.BigEndian.AppendUint64(nil, value))
+}
+
+// kvInitParams stores the _params_ of a data structure at _key_ if it doesn't exist,
+// otherwise checks that the stored parameters are the same
+func kvInitParams(store KVStore, key []byte, kind string, params []uint64) error {
+	return store.Update(func(tx KVTx) error {
+		value, err := tx.Get(key)
+		if err != nil {
+			return err
+		}
+		encoded := make([]byte, 0, 8*len(params))
+		for _, param := range params {
+			encoded = binary.BigEndian.AppendUint64(encoded, param)
+		}
+		if value == nil {
+			return tx.Set(key, encoded)
+		}
+		if string(value) != string(encoded) {
+			return fmt.Errorf("gostatix: %s at key %s was created with different parameters", kind, key)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,88 @@
+package gostatix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxSnapshotSize is the maximum size in bytes of a data structure decoded by Import or
+// ReadFrom. The size is computed from the parameters and length fields of the snapshot
+// before anything is allocated, and snapshots describing a larger data structure are
+// rejected with an error, so that a corrupt or hostile snapshot can't exhaust memory.
+// It can be raised before decoding snapshots of very large data structures.
+var MaxSnapshotSize uint64 = 1 << 30
+
+// readChunkSize is the number of values read at once while decoding slices from a stream
+const readChunkSize = 1 << 16
+
+// stringSize is the size of a string header, used to account for slices of strings
+const stringSize = 16
+
+// sliceSize is the size of a slice header
+const sliceSize = 24
+
+// checkSnapshotSize returns an error if _count_ elements of _elemSize_ bytes each
+// exceed MaxSnapshotSize
+func checkSnapshotSize(name string, count, elemSize uint64) error {
+	if elemSize > 0 && count > MaxSnapshotSize/elemSize {
+		return fmt.Errorf("gostatix: %s of %d x %d bytes exceeds the maximum snapshot size of %d bytes", name, count, elemSize, MaxSnapshotSize)
+	}
+	return nil
+}
+
+// readBytes reads exactly _n_ bytes from _stream_. The slice grows with the data
+// actually read, so a truncated stream doesn't allocate all _n_ bytes up front.
+func readBytes(stream io.Reader, n uint64) ([]byte, error) {
+	err := checkSnapshotSize("byte slice", n, 1)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(stream, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+// readUint64s reads _n_ big endian uint64 values from _stream_ in chunks of
+// _readChunkSize_ values, growing the slice with the data actually read
+func readUint64s(stream io.Reader, n uint64) ([]uint64, error) {
+	err := checkSnapshotSize("uint64 slice", n, 8)
+	if err != nil {
+		return nil, err
+	}
+	chunk := make([]uint64, minUint64(n, readChunkSize))
+	values := make([]uint64, 0, len(chunk))
+	for uint64(len(values)) < n {
+		c := chunk[:minUint64(n-uint64(len(values)), readChunkSize)]
+		err := binary.Read(stream, binary.BigEndian, c)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		values = append(values, c...)
+	}
+	return values, nil
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// wordsFor returns the number of 64 bit words needed to hold _bits_ bits
+func wordsFor(bits uint64) uint64 {
+	words := bits / uint64(wordSize)
+	if bits%uint64(wordSize) != 0 {
+		words++
+	}
+	return words
+}
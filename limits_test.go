@@ -0,0 +1,94 @@
+package gostatix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func encodeHeader(values ...uint64) *bytes.Reader {
+	var buff bytes.Buffer
+	binary.Write(&buff, binary.BigEndian, values)
+	return bytes.NewReader(buff.Bytes())
+}
+
+func TestReadFromHugeLengths(t *testing.T) {
+	cms, _ := NewCountMinSketch(1, 1)
+	_, err := cms.ReadFrom(encodeHeader(math.MaxUint64, math.MaxUint64, 0))
+	if err == nil {
+		t.Error("count-min sketch with huge rows and columns should error out")
+	}
+	filter, _ := NewCuckooFilter(1, 1, 1)
+	_, err = filter.ReadFrom(encodeHeader(1<<40, 4, 3, 0, 500))
+	if err == nil {
+		t.Error("cuckoo filter with huge size should error out")
+	}
+	_, err = filter.ReadFrom(encodeHeader(1, 4, 3, 1, 500, 4, 1, 1<<40))
+	if err == nil {
+		t.Error("cuckoo filter with huge fingerprint length should error out")
+	}
+	h, _ := NewHyperLogLog(16)
+	_, err = h.ReadFrom(encodeHeader(1<<62, 62, 0))
+	if err == nil {
+		t.Error("hyperloglog with huge number of registers should error out")
+	}
+	topk, _ := NewTopK(1, 0.1, 0.9)
+	_, err = topk.ReadFrom(encodeHeader(1, 0, 0, 1, 1, 0, 0, 1<<40))
+	if err == nil {
+		t.Error("top-k with huge element length should error out")
+	}
+	bloom := &BloomFilter{}
+	_, err = bloom.ReadFrom(encodeHeader(64, 3, 64, 1<<62))
+	if err == nil {
+		t.Error("bloom filter with huge bitset length should error out")
+	}
+}
+
+func TestReadFromTruncated(t *testing.T) {
+	cms, _ := NewCountMinSketch(1, 1)
+	_, err := cms.ReadFrom(encodeHeader(4, 1<<20, 0, 1, 2))
+	if err == nil {
+		t.Error("truncated count-min sketch should error out")
+	}
+	if cms.rows != 1 || cms.columns != 1 {
+		t.Error("count-min sketch shouldn't be modified by a failed ReadFrom")
+	}
+}
+
+func TestMaxSnapshotSize(t *testing.T) {
+	cms, _ := NewCountMinSketch(4, 100)
+	var buff bytes.Buffer
+	cms.WriteTo(&buff)
+	defer func(size uint64) { MaxSnapshotSize = size }(MaxSnapshotSize)
+	MaxSnapshotSize = 1024
+	cms1, _ := NewCountMinSketch(1, 1)
+	_, err := cms1.ReadFrom(bytes.NewReader(buff.Bytes()))
+	if err == nil {
+		t.Error("count-min sketch larger than MaxSnapshotSize should error out")
+	}
+	MaxSnapshotSize = 4096
+	_, err = cms1.ReadFrom(bytes.NewReader(buff.Bytes()))
+	if err != nil {
+		t.Errorf("count-min sketch smaller than MaxSnapshotSize shouldn't error out, error: %v", err)
+	}
+}
+
+func TestImportInconsistentSnapshots(t *testing.T) {
+	cms, _ := NewCountMinSketch(1, 1)
+	if err := cms.Import([]byte(`{"r":2,"c":2,"m":[[1,2]]}`)); err == nil {
+		t.Error("count-min sketch with missing rows should error out")
+	}
+	filter, _ := NewCuckooFilter(1, 1, 1)
+	if err := filter.Import([]byte(`{}`)); err == nil {
+		t.Error("cuckoo filter of size 0 should error out")
+	}
+	h, _ := NewHyperLogLog(16)
+	if err := h.Import([]byte(`{"nr":16,"nbp":4}`)); err == nil {
+		t.Error("hyperloglog with missing registers should error out")
+	}
+	topk, _ := NewTopK(1, 0.1, 0.9)
+	if err := topk.Import([]byte(`{"k":0}`)); err == nil {
+		t.Error("top-k with k 0 should error out")
+	}
+}
@@ -0,0 +1,239 @@
+/*
+Implements probabilistic data structure linear counting used in estimating unique entries
+in a dataset.
+
+Linear Counting: A probabilistic data structure which hashes the elements to the bits of a
+bitmap and estimates the cardinality from the fraction of bits left unset. It's more accurate
+than a HyperLogLog of the same size for small cardinalities, but the bitmap saturates once
+the cardinality gets much larger than its number of bits.
+Refer: https://dl.acm.org/doi/10.1145/78922.78925
+
+The in-memory data structure is thread-safe.
+*/
+package gostatix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"sync"
+	"unsafe"
+
+	"github.com/dgryski/go-metro"
+	"github.com/kwertop/gostatix/internal/util"
+)
+
+// LinearCounting struct. This is an in-memory implementation of Linear Counting.
+// _numBits_ is the number of bits of the bitmap
+// _words_ holds the bits of the bitmap
+// _lock_ is used to synchronize concurrent read/writes
+type LinearCounting struct {
+	numBits   uint64
+	words     []uint64
+	lock      sync.RWMutex
+	resources resources
+}
+
+type linearCountingJSON struct {
+	NumBits uint64   `json:"m"`
+	Words   []uint64 `json:"w"`
+}
+
+// NewLinearCounting creates new LinearCounting with a bitmap of _numBits_ bits.
+// The cardinality can be estimated accurately up to a few times _numBits_.
+func NewLinearCounting(numBits uint64) (*LinearCounting, error) {
+	err := LinearCountingParams{numBits}.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &LinearCounting{numBits: numBits, words: make([]uint64, wordsFor(numBits))}, nil
+}
+
+// NumBits returns the number of bits of the bitmap of the LinearCounting
+func (l *LinearCounting) NumBits() uint64 {
+	return l.numBits
+}
+
+// Reset unsets all the bits of the bitmap
+func (l *LinearCounting) Reset() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for i := range l.words {
+		l.words[i] = 0
+	}
+}
+
+// Update sets the bit of the passed _data_ (byte slice) in the bitmap
+func (l *LinearCounting) Update(data []byte) {
+	index := l.getIndex(data)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.words[index/uint64(wordSize)] |= 1 << (index % uint64(wordSize))
+}
+
+// UpdateString sets the bit of the passed _data_ (string) in the bitmap
+func (l *LinearCounting) UpdateString(data string) {
+	l.Update([]byte(data))
+}
+
+// UpdateUint64 sets the bit of the passed _data_ (unsigned integer) in the bitmap.
+// The integer is hashed as its 8 byte big endian encoding.
+func (l *LinearCounting) UpdateUint64(data uint64) {
+	l.Update(uint64Bytes(data))
+}
+
+// UpdateKey sets the bit of the passed _data_ (Key) in the bitmap
+func (l *LinearCounting) UpdateKey(data Key) {
+	l.Update(data.KeyBytes())
+}
+
+// UpdateFromReader streams keys separated by _delim_ from _stream_ and updates the
+// LinearCounting with each of them without holding all of them in memory.
+// _progress_ (optional, can be nil) is called periodically with the number of keys processed.
+// It returns the total number of keys processed.
+func (l *LinearCounting) UpdateFromReader(stream io.Reader, delim byte, progress ProgressFunc) (uint64, error) {
+	return util.ReadKeys(stream, delim, progress, func(key []byte) error {
+		l.Update(key)
+		return nil
+	})
+}
+
+// Count returns the estimated number of distinct elements so far. If all the bits of
+// the bitmap are set, the bitmap is saturated and the count is a lower bound computed
+// as if a single bit was unset.
+func (l *LinearCounting) Count() uint64 {
+	numUnset := l.numBits - l.numBitsSet()
+	if numUnset == 0 {
+		numUnset = 1
+	}
+	m := float64(l.numBits)
+	return uint64(math.Round(-m * math.Log(float64(numUnset)/m)))
+}
+
+// Saturated returns true if all the bits of the bitmap are set, in which case Count
+// can't estimate the cardinality anymore
+func (l *LinearCounting) Saturated() bool {
+	return l.numBitsSet() == l.numBits
+}
+
+// Merge merges two LinearCounting data structures of the same number of bits
+func (l *LinearCounting) Merge(g *LinearCounting) error {
+	if l == g {
+		return nil
+	}
+	if l.numBits != g.numBits {
+		return fmt.Errorf("gostatix: number of bits %d, %d don't match", l.numBits, g.numBits)
+	}
+	g.lock.RLock()
+	words := append([]uint64(nil), g.words...)
+	g.lock.RUnlock()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for i := range words {
+		l.words[i] |= words[i]
+	}
+	return nil
+}
+
+// Equals checks if two LinearCounting data structures are equal. LinearCountings with a
+// different number of bits aren't equal.
+func (l *LinearCounting) Equals(g *LinearCounting) (bool, error) {
+	comparison, err := l.Compare(g)
+	return comparison.Equal(), err
+}
+
+// Compare compares two LinearCounting data structures and returns why they aren't equal,
+// if they aren't
+func (l *LinearCounting) Compare(g *LinearCounting) (Comparison, error) {
+	if l.numBits != g.numBits {
+		return parameterMismatch("numBits", l.numBits, g.numBits), nil
+	}
+	if l == g {
+		return equalComparison, nil
+	}
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	for i := range l.words {
+		if l.words[i] != g.words[i] {
+			return contentMismatch("bits"), nil
+		}
+	}
+	return equalComparison, nil
+}
+
+// MemoryUsage returns the estimated number of bytes used in-process by the LinearCounting
+func (l *LinearCounting) MemoryUsage() uint64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return uint64(unsafe.Sizeof(*l)) + uint64(cap(l.words)*wordBytes)
+}
+
+// Close releases the resources attached to the LinearCounting.
+// It's safe to call Close multiple times.
+func (l *LinearCounting) Close() error {
+	return l.resources.close()
+}
+
+// Export JSON marshals the LinearCounting and returns a byte slice containing the data
+func (l *LinearCounting) Export() ([]byte, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return json.Marshal(linearCountingJSON{l.numBits, l.words})
+}
+
+// Import JSON unmarshals the _data_ into the LinearCounting
+func (l *LinearCounting) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
+	var g linearCountingJSON
+	err = json.Unmarshal(data, &g)
+	if err != nil {
+		return err
+	}
+	err = LinearCountingParams{g.NumBits}.Validate()
+	if err != nil {
+		return fmt.Errorf("gostatix: invalid linear counting snapshot, error: %v", err)
+	}
+	if uint64(len(g.Words)) != wordsFor(g.NumBits) {
+		return fmt.Errorf("gostatix: invalid linear counting snapshot, %d words found instead of %d", len(g.Words), wordsFor(g.NumBits))
+	}
+	if extra := g.NumBits % uint64(wordSize); extra != 0 && g.Words[len(g.Words)-1]>>extra != 0 {
+		return fmt.Errorf("gostatix: invalid linear counting snapshot, bits set beyond the %d bits of the bitmap", g.NumBits)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.numBits = g.NumBits
+	l.words = g.Words
+	return nil
+}
+
+// numBitsSet returns the number of bits set in the bitmap
+func (l *LinearCounting) numBitsSet() uint64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	numSet := uint64(0)
+	for _, word := range l.words {
+		numSet += uint64(bits.OnesCount64(word))
+	}
+	return numSet
+}
+
+// getIndex returns the index of the bit of _data_ in the bitmap
+func (l *LinearCounting) getIndex(data []byte) uint64 {
+	hash, _ := metro.Hash128(data, 1373)
+	return hash % l.numBits
+}
@@ -0,0 +1,77 @@
+package gostatix
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestLinearCountingCount(t *testing.T) {
+	l, _ := NewLinearCounting(10000)
+	for i := 0; i < 5000; i++ {
+		l.UpdateString(strconv.Itoa(i))
+		l.UpdateString(strconv.Itoa(i))
+	}
+	count := l.Count()
+	if math.Abs(float64(count)-5000) > 150 {
+		t.Errorf("too much variance in calculated distinct values; got %d, exact %d", count, 5000)
+	}
+	if l.Saturated() {
+		t.Errorf("linear counting shouldn't be saturated")
+	}
+}
+
+func TestLinearCountingSaturated(t *testing.T) {
+	l, _ := NewLinearCounting(10)
+	for i := 0; i < 1000; i++ {
+		l.UpdateUint64(uint64(i))
+	}
+	if !l.Saturated() {
+		t.Errorf("linear counting of 10 bits should be saturated after 1000 updates")
+	}
+	if count := l.Count(); count != 23 {
+		t.Errorf("count of a saturated linear counting of 10 bits should be 23, got %d", count)
+	}
+}
+
+func TestLinearCountingMerge(t *testing.T) {
+	f, _ := NewLinearCounting(1000)
+	g, _ := NewLinearCounting(1000)
+	h, _ := NewLinearCounting(1000)
+	f.UpdateString("foo")
+	g.UpdateString("bar")
+	h.UpdateString("foo")
+	h.UpdateString("bar")
+	if err := f.Merge(g); err != nil {
+		t.Fatalf("merge shouldn't error out, error: %v", err)
+	}
+	if !equal(f.Equals(h)) {
+		t.Errorf("merged linear counting should be equal to h")
+	}
+	other, _ := NewLinearCounting(100)
+	if f.Merge(other) == nil {
+		t.Errorf("merge of linear countings of different sizes should error out")
+	}
+}
+
+func TestLinearCountingExportImport(t *testing.T) {
+	l, _ := NewLinearCounting(100)
+	l.UpdateString("foo")
+	l.UpdateString("bar")
+	data, err := l.Export()
+	if err != nil {
+		t.Fatalf("export shouldn't error out, error: %v", err)
+	}
+	g, _ := NewLinearCounting(10)
+	if err := g.Import(data); err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if !equal(l.Equals(g)) {
+		t.Errorf("imported linear counting should be equal to the exported one")
+	}
+	for _, invalid := range []string{`{"m":0,"w":[]}`, `{"m":100,"w":[0]}`, `{"m":10,"w":[1024]}`} {
+		if g.Import([]byte(invalid)) == nil {
+			t.Errorf("import of %s should error out", invalid)
+		}
+	}
+}
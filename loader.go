@@ -0,0 +1,76 @@
+package gostatix
+
+import (
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/kwertop/gostatix/internal/util"
+)
+
+// ProgressFunc is invoked periodically while keys are streamed from an io.Reader
+// into a data structure with the number of keys processed so far. It's called every
+// 100000 keys and once more after the stream is exhausted.
+type ProgressFunc func(processed uint64)
+
+// keyBatchSize is the number of keys handed at once to a worker of the parallel loaders
+const keyBatchSize = 1024
+
+// keyBatch holds keys read from a stream, the key _i_ ending at _ends[i]_ in _data_
+type keyBatch struct {
+	data []byte
+	ends []int
+}
+
+var keyBatchPool = sync.Pool{New: func() interface{} {
+	return &keyBatch{ends: make([]int, 0, keyBatchSize)}
+}}
+
+// parallelWorkers returns the number of workers of a parallel load asked for _workers_,
+// GOMAXPROCS if it's 0 or less
+func parallelWorkers(workers int) int {
+	if workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return workers
+}
+
+// readKeysParallel streams keys separated by _delim_ from _stream_ like util.ReadKeys and
+// hands them in batches to _workers_ goroutines, which call _fn_ with their index in
+// [0, _workers_) and each key of the batches they take. _progress_ is called with the
+// number of keys read. It returns once all the keys read were handed to _fn_.
+func readKeysParallel(stream io.Reader, delim byte, workers int, progress ProgressFunc, fn func(worker int, key []byte)) (uint64, error) {
+	batches := make(chan *keyBatch, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for batch := range batches {
+				start := 0
+				for _, end := range batch.ends {
+					fn(worker, batch.data[start:end])
+					start = end
+				}
+				batch.data, batch.ends = batch.data[:0], batch.ends[:0]
+				keyBatchPool.Put(batch)
+			}
+		}(w)
+	}
+	batch := keyBatchPool.Get().(*keyBatch)
+	count, err := util.ReadKeys(stream, delim, progress, func(key []byte) error {
+		batch.data = append(batch.data, key...)
+		batch.ends = append(batch.ends, len(batch.data))
+		if len(batch.ends) == keyBatchSize {
+			batches <- batch
+			batch = keyBatchPool.Get().(*keyBatch)
+		}
+		return nil
+	})
+	if len(batch.ends) > 0 {
+		batches <- batch
+	}
+	close(batches)
+	wg.Wait()
+	return count, err
+}
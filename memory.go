@@ -0,0 +1,47 @@
+package gostatix
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// memoryUsageBatchSize is the number of MEMORY USAGE commands sent in a single pipeline
+const memoryUsageBatchSize = 1000
+
+// redisMemoryUsage returns the sum of the number of bytes used by the values at _keys_
+// in Redis, as reported by MEMORY USAGE. Missing keys and empty key names are skipped.
+// The commands are pipelined in batches of memoryUsageBatchSize.
+func redisMemoryUsage(keys []string) (uint64, error) {
+	ctx := context.Background()
+	total := uint64(0)
+	for start := 0; start < len(keys); start += memoryUsageBatchSize {
+		end := start + memoryUsageBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		pipe := getRedisClient().Pipeline()
+		var usages []*redis.IntCmd
+		for _, key := range keys[start:end] {
+			if key != "" {
+				usages = append(usages, pipe.MemoryUsage(ctx, key))
+			}
+		}
+		_, err := pipe.Exec(ctx)
+		if err != nil && err != redis.Nil {
+			return 0, fmt.Errorf("gostatix: error while fetching memory usage from redis, error: %v", err)
+		}
+		for _, usage := range usages {
+			bytes, err := usage.Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				return 0, fmt.Errorf("gostatix: error while fetching memory usage from redis, error: %v", err)
+			}
+			total += uint64(bytes)
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,43 @@
+package gostatix
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMemoryUsage(t *testing.T) {
+	bloom, _ := NewMemBloomFilterWithParameters(10000, 0.01)
+	if usage, err := bloom.MemoryUsage(); err != nil || usage < uint64(bloom.GetCap()/8) {
+		t.Errorf("bloom filter should use at least %d bytes, got %d, error: %v", bloom.GetCap()/8, usage, err)
+	}
+	cuckoo, _ := NewCuckooFilter(1000, 4, 3)
+	if usage := cuckoo.MemoryUsage(); usage < 1000*4*10/8 {
+		t.Errorf("cuckoo filter should use at least %d bytes, got %d", 1000*4*10/8, usage)
+	}
+	cms, _ := NewCountMinSketch(4, 100)
+	if usage := cms.MemoryUsage(); usage < 4*100*8 {
+		t.Errorf("count-min sketch should use at least %d bytes, got %d", 4*100*8, usage)
+	}
+	hll, _ := NewHyperLogLog(1024)
+	if usage := hll.MemoryUsage(); usage < 1024 {
+		t.Errorf("hyperloglog should use at least 1024 bytes, got %d", usage)
+	}
+	topk, _ := NewTopK(10, 0.01, 0.9)
+	before := topk.MemoryUsage()
+	topk.InsertString(strings.Repeat("a", 1000), 1)
+	if usage := topk.MemoryUsage(); usage < before+1000 || before < topk.sketch.MemoryUsage() {
+		t.Errorf("topk should account for its sketch and elements, got %d and %d", before, usage)
+	}
+}
+
+func TestRedisMemoryUsage(t *testing.T) {
+	initMockRedis()
+	if usage, err := redisMemoryUsage([]string{"", ""}); err != nil || usage != 0 {
+		t.Errorf("empty keys should be skipped, got %d, error: %v", usage, err)
+	}
+	// miniredis doesn't implement MEMORY USAGE, the error should be reported
+	filter, _ := NewRedisBloomFilterWithParameters(1000, 0.01)
+	if _, err := filter.MemoryUsage(); err == nil || !strings.Contains(err.Error(), "memory usage") {
+		t.Errorf("unsupported MEMORY USAGE should error out, error: %v", err)
+	}
+}
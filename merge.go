@@ -0,0 +1,92 @@
+/*
+Implements the merge of many in-memory sketches into one, e.g. the per-worker sketches of a
+parallel load into the sketch of the whole stream.
+
+The counters, registers and words of large sketches are split in chunks merged
+concurrently, one goroutine per chunk up to GOMAXPROCS, see forEachWordChunk.
+*/
+package gostatix
+
+import (
+	"fmt"
+)
+
+// MergeCountMinSketches adds the counts of the _sources_ to the _target_ sketch. All the
+// sketches must have the same number of rows and columns, which is checked before any of
+// them is merged. The sources are merged one after the other, each like Merge.
+func MergeCountMinSketches(target *CountMinSketch, sources []*CountMinSketch) error {
+	for i, source := range sources {
+		if target.rows != source.rows || target.columns != source.columns {
+			return fmt.Errorf("gostatix: can't merge sketch %d of %dx%d counters in a sketch of %dx%d counters",
+				i, source.rows, source.columns, target.rows, target.columns)
+		}
+	}
+	for _, source := range sources {
+		err := target.Merge(source)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeHyperLogLogs merges the _sources_ in the _target_ hyperloglog. All the hyperloglogs
+// must have the same number of registers and estimator, which is checked before any of them
+// is merged. The sources are merged one after the other, each like Merge.
+func MergeHyperLogLogs(target *HyperLogLog, sources []*HyperLogLog) error {
+	for i, source := range sources {
+		if target.numRegisters != source.numRegisters || target.estimator != source.estimator {
+			return fmt.Errorf("gostatix: can't merge hyperloglog %d of %d registers and %v estimator in a hyperloglog of %d registers and %v estimator",
+				i, source.numRegisters, source.estimator, target.numRegisters, target.estimator)
+		}
+	}
+	for _, source := range sources {
+		err := target.Merge(source)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeBloomFilters merges the _sources_ in the _target_ bloom filter, which then holds the
+// elements inserted in all of them. All the filters must have the same size, number of
+// hashes and hashing, which is checked before any of them is merged. The sources are merged
+// one after the other, each like Merge.
+func MergeBloomFilters(target *BloomFilter, sources []*BloomFilter) error {
+	for i, source := range sources {
+		if target.size != source.size || target.numHashes != source.numHashes || target.hashing != source.hashing {
+			return fmt.Errorf("gostatix: can't merge bloom filter %d of %d bits, %d hashes and %v hashing in a bloom filter of %d bits, %d hashes and %v hashing",
+				i, source.size, source.numHashes, source.hashing, target.size, target.numHashes, target.hashing)
+		}
+	}
+	for _, source := range sources {
+		err := target.Merge(source)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addCounters adds the counters of _source_ to the ones of _target_, concurrently for
+// large slices
+func addCounters(target, source []uint64) {
+	forEachWordChunk(len(target), func(_, from, to int) {
+		for i := from; i < to; i++ {
+			target[i] += source[i]
+		}
+	})
+}
+
+// maxRegisters sets the registers of _target_ to their maximum with the ones of _source_,
+// concurrently for large slices
+func maxRegisters(target, source []uint8) {
+	forEachWordChunk(len(target), func(_, from, to int) {
+		for i := from; i < to; i++ {
+			if source[i] > target[i] {
+				target[i] = source[i]
+			}
+		}
+	})
+}
@@ -0,0 +1,234 @@
+/*
+Implements the merge of many Redis backed sketches into one, e.g. the per-worker sketches
+of a sharded stream into the sketch of the whole stream.
+
+The counters, registers and heaps are merged by Lua scripts, one source at a time, so the
+matrices never leave Redis. The merge of a source is retried on errors and reported to a
+progress callback once it's done.
+*/
+package gostatix
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kwertop/gostatix/internal/util"
+	"github.com/redis/go-redis/v9"
+)
+
+// mergeGuardTTL is the expiry of the hash recording the sources merged in a sketch by a
+// merge, long enough to outlive the retries of the merge of a source
+const mergeGuardTTL = time.Hour
+
+// MergeProgressFunc is called after each source of a Redis merge is merged, with the
+// number of sources merged so far and the number of sources
+type MergeProgressFunc func(merged, total int)
+
+// RedisMergeOptions configures the merges of many Redis backed sketches into one.
+// _Retries_ is the number of times the merge of a source is retried after an error
+// _RetryDelay_ is waited before the first retry and doubled before each next one
+// _Progress_ (optional, can be nil) is called after each source is merged
+type RedisMergeOptions struct {
+	Retries    int
+	RetryDelay time.Duration
+	Progress   MergeProgressFunc
+}
+
+// mergeRedisSources calls _merge_ for the _total_ sources one after the other, retrying
+// it as configured by _opts_, and stops at the first source which can't be merged
+func mergeRedisSources(ctx context.Context, total int, opts RedisMergeOptions, merge func(i int) error) error {
+	if opts.Retries < 0 {
+		return fmt.Errorf("gostatix: number of merge retries %d shouldn't be negative", opts.Retries)
+	}
+	for i := 0; i < total; i++ {
+		delay := opts.RetryDelay
+		for attempt := 0; ; attempt++ {
+			err := merge(i)
+			if err == nil {
+				break
+			}
+			if attempt == opts.Retries {
+				return fmt.Errorf("gostatix: merge of source %d failed after %d attempts, error: %v", i, attempt+1, err)
+			}
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return fmt.Errorf("gostatix: merge of source %d cancelled, error: %v", i, ctx.Err())
+			case <-timer.C:
+			}
+			delay *= 2
+		}
+		if opts.Progress != nil {
+			opts.Progress(i+1, total)
+		}
+	}
+	return nil
+}
+
+// cmsMergeSourceScript adds the rows of the sketch at KEYS[2] to the rows of the sketch at
+// KEYS[1] and its total count, in the metadata hash at KEYS[4], to the one at KEYS[3].
+// The source ARGV[4] is recorded with the token ARGV[3] of the merge in the hash at KEYS[5],
+// so that a retry of a merge which went through but whose reply was lost doesn't add the
+// source twice. The rows are pushed in chunks to stay within the limits of unpack. The total
+// count of the target is returned.
+var cmsMergeSourceScript = redis.NewScript(`
+	local rows = tonumber(ARGV[1])
+	local columns = tonumber(ARGV[2])
+	if redis.call('HGET', KEYS[5], ARGV[4]) == ARGV[3] then
+		return tonumber(redis.call('HGET', KEYS[3], 'allSum'))
+	end
+	for i=1, rows do
+		local rowKey1 = KEYS[1] .. tostring(i-1)
+		local vals1 = redis.call('LRANGE', rowKey1, 0, -1)
+		local vals2 = redis.call('LRANGE', KEYS[2] .. tostring(i-1), 0, -1)
+		for j=1, columns do
+			vals1[j] = tonumber(vals1[j]) + tonumber(vals2[j])
+		end
+		redis.call('DEL', rowKey1)
+		local pushed = 0
+		while pushed < columns do
+			local n = math.min(columns - pushed, 1000)
+			redis.call('RPUSH', rowKey1, unpack(vals1, pushed + 1, pushed + n))
+			pushed = pushed + n
+		end
+	end
+	local allSum = redis.call('HINCRBY', KEYS[3], 'allSum', tonumber(redis.call('HGET', KEYS[4], 'allSum') or 0))
+	redis.call('HSET', KEYS[5], ARGV[4], ARGV[3])
+	redis.call('PEXPIRE', KEYS[5], ARGV[5])
+	return allSum
+`)
+
+// MergeCountMinSketchesRedis adds the counts of the _sources_ to the _target_ sketch. All the
+// sketches must have the same number of rows and columns, which is checked before any of
+// them is merged. Each source is merged in a single script invocation, so a source is
+// either merged or not, and a retried source is never counted twice.
+func MergeCountMinSketchesRedis(ctx context.Context, target *CountMinSketchRedis, sources []*CountMinSketchRedis, opts RedisMergeOptions) error {
+	for _, source := range sources {
+		if target.rows != source.rows || target.columns != source.columns {
+			return fmt.Errorf("gostatix: can't merge sketch %s of %dx%d counters in sketch %s of %dx%d counters",
+				source.metadataKey, source.rows, source.columns, target.metadataKey, target.rows, target.columns)
+		}
+	}
+	token := util.GenerateRandomString(16)
+	return mergeRedisSources(ctx, len(sources), opts, func(i int) error {
+		return target.mergeSource(ctx, sources[i], token)
+	})
+}
+
+// mergeSource adds the counts of _source_ to the CountMinSketchRedis unless it was already
+// merged with _token_
+func (cms *CountMinSketchRedis) mergeSource(ctx context.Context, source *CountMinSketchRedis, token string) error {
+	err := cmsMergeSourceScript.Run(
+		ctx,
+		getRedisClient(),
+		[]string{cms.key, source.key, cms.metadataKey, source.metadataKey, cms.metadataKey + ":merges"},
+		cms.rows,
+		cms.columns,
+		token,
+		source.metadataKey,
+		mergeGuardTTL.Milliseconds(),
+	).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while merging sketch %s in %s, error: %v", source.metadataKey, cms.metadataKey, err)
+	}
+	return nil
+}
+
+// MergeHyperLogLogsRedis merges the _sources_ in the _target_ hyperloglog. All the
+// hyperloglogs must have the same number of registers, which is checked before any of them
+// is merged. Merging the registers of a source again leaves them unchanged, so retries are
+// safe.
+func MergeHyperLogLogsRedis(ctx context.Context, target *HyperLogLogRedis, sources []*HyperLogLogRedis, opts RedisMergeOptions) error {
+	for _, source := range sources {
+		if target.numRegisters != source.numRegisters {
+			return fmt.Errorf("gostatix: can't merge hyperloglog %s of %d registers in hyperloglog %s of %d registers",
+				source.metadataKey, source.numRegisters, target.metadataKey, target.numRegisters)
+		}
+	}
+	return mergeRedisSources(ctx, len(sources), opts, func(i int) error {
+		return target.mergeRegisters(sources[i].key)
+	})
+}
+
+// topKRescoreScript sets the score of the elements ARGV[4...] in the heap at KEYS[1] to their
+// estimated counts in the sketch at ARGV[1] of ARGV[3] rows, the columns of each element
+// following it in ARGV, and trims the heap back to the ARGV[2] elements of highest scores
+var topKRescoreScript = redis.NewScript(`
+	local cmsKey = ARGV[1]
+	local k = tonumber(ARGV[2])
+	local rows = tonumber(ARGV[3])
+	for i=4, #ARGV, rows+1 do
+		local estimate = 0
+		for r=1, rows do
+			local val = tonumber(redis.call('LINDEX', cmsKey .. tostring(r-1), tonumber(ARGV[i+r])))
+			if val < estimate or r == 1 then
+				estimate = val
+			end
+		end
+		redis.call('ZADD', KEYS[1], estimate, ARGV[i])
+	end
+	local size = redis.call('ZCARD', KEYS[1])
+	if size > k then
+		redis.call('ZREMRANGEBYRANK', KEYS[1], 0, size-k-1)
+	end
+	return true
+`)
+
+// MergeTopKsRedis merges the _sources_ in the _target_ TopKRedis. All the TopKs must have
+// the same parameters, which is checked before any of them is merged. The sketch of each
+// source is merged like in MergeCountMinSketchesRedis, then the elements of the heaps of the
+// source and the target are scored with their counts in the merged sketch and the heap of
+// the target keeps the top _k_ of them. Only the elements of the heaps are read by the
+// client, to hash them.
+func MergeTopKsRedis(ctx context.Context, target *TopKRedis, sources []*TopKRedis, opts RedisMergeOptions) error {
+	params := TopKParams{target.k, target.errorRate, target.accuracy}
+	for _, source := range sources {
+		comparison := compareTopKParams(params, TopKParams{source.k, source.errorRate, source.accuracy})
+		if !comparison.Equal() {
+			return fmt.Errorf("gostatix: can't merge topk %s in topk %s, %s", source.metadataKey, target.metadataKey, comparison)
+		}
+	}
+	token := util.GenerateRandomString(16)
+	return mergeRedisSources(ctx, len(sources), opts, func(i int) error {
+		return target.mergeSource(ctx, sources[i], token)
+	})
+}
+
+// mergeSource merges the sketch of _source_ in the sketch of the TopKRedis unless it was
+// already merged with _token_, then rescores the elements of both heaps. Rescoring sets
+// the scores to the counts of the merged sketch, so it can be retried.
+func (t *TopKRedis) mergeSource(ctx context.Context, source *TopKRedis, token string) error {
+	err := t.sketch.mergeSource(ctx, source.sketch, token)
+	if err != nil {
+		return err
+	}
+	client := getRedisClient()
+	elements, err := client.ZRange(ctx, t.heapKey, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while reading heap %s, error: %v", t.heapKey, err)
+	}
+	sourceElements, err := client.ZRange(ctx, source.heapKey, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while reading heap %s, error: %v", source.heapKey, err)
+	}
+	args := []interface{}{t.sketch.key, t.k, t.sketch.rows}
+	seen := make(map[string]bool, len(elements)+len(sourceElements))
+	for _, element := range append(elements, sourceElements...) {
+		if seen[element] {
+			continue
+		}
+		seen[element] = true
+		args = append(args, element)
+		for _, column := range t.sketch.getPositions([]byte(element)) {
+			args = append(args, strconv.FormatUint(uint64(column), 10))
+		}
+	}
+	err = topKRescoreScript.Run(ctx, client, []string{t.heapKey}, args...).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while rescoring heap %s, error: %v", t.heapKey, err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package gostatix
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMergeCountMinSketchesRedis(t *testing.T) {
+	initMockRedis()
+	target, _ := NewCountMinSketchRedis(3, 100)
+	target.UpdateString("a", 1)
+	var sources []*CountMinSketchRedis
+	for w := 0; w < 3; w++ {
+		source, _ := NewCountMinSketchRedis(3, 100)
+		for i := 0; i <= w; i++ {
+			source.UpdateString("a", 2)
+			source.UpdateString(strconv.Itoa(w), 1)
+		}
+		sources = append(sources, source)
+	}
+	var progress []int
+	opts := RedisMergeOptions{Progress: func(merged, total int) {
+		if total != 3 {
+			t.Errorf("progress total should be 3, got %d", total)
+		}
+		progress = append(progress, merged)
+	}}
+	if err := MergeCountMinSketchesRedis(context.Background(), target, sources, opts); err != nil {
+		t.Fatalf("merge shouldn't error out, error: %v", err)
+	}
+	if len(progress) != 3 || progress[0] != 1 || progress[2] != 3 {
+		t.Errorf("progress should be reported after each source, got %v", progress)
+	}
+	if count, _ := target.CountString("a"); count != 13 {
+		t.Errorf("count of a should be 13, got %d", count)
+	}
+	if count, _ := target.CountString("2"); count != 3 {
+		t.Errorf("count of 2 should be 3, got %d", count)
+	}
+	if total, _ := target.TotalCount(); total != 19 {
+		t.Errorf("total count should be 19, got %d", total)
+	}
+
+	// a retry of a source already merged with the same token leaves the sketch unchanged
+	target.mergeSource(context.Background(), sources[0], "token")
+	target.mergeSource(context.Background(), sources[0], "token")
+	if count, _ := target.CountString("a"); count != 15 {
+		t.Errorf("count of a should be 15 after merging a source once more, got %d", count)
+	}
+
+	other, _ := NewCountMinSketchRedis(3, 50)
+	if err := MergeCountMinSketchesRedis(context.Background(), target, []*CountMinSketchRedis{sources[0], other}, RedisMergeOptions{}); err == nil {
+		t.Errorf("merge of sketches of different sizes should error out")
+	}
+	if count, _ := target.CountString("a"); count != 15 {
+		t.Errorf("no source should be merged if one of them doesn't match, got %d", count)
+	}
+}
+
+func TestMergeHyperLogLogsRedis(t *testing.T) {
+	initMockRedis()
+	target, _ := NewHyperLogLogRedis(64)
+	expected, _ := NewHyperLogLogRedis(64)
+	var sources []*HyperLogLogRedis
+	for w := 0; w < 4; w++ {
+		source, _ := NewHyperLogLogRedis(64)
+		for i := 0; i < 50; i++ {
+			source.UpdateString(strconv.Itoa(w*25 + i))
+			expected.UpdateString(strconv.Itoa(w*25 + i))
+		}
+		sources = append(sources, source)
+	}
+	if err := MergeHyperLogLogsRedis(context.Background(), target, sources, RedisMergeOptions{}); err != nil {
+		t.Fatalf("merge shouldn't error out, error: %v", err)
+	}
+	if equal, _ := target.Equals(expected); !equal {
+		t.Errorf("merged hyperloglog should equal the hyperloglog of all the elements")
+	}
+	other, _ := NewHyperLogLogRedis(128)
+	if err := MergeHyperLogLogsRedis(context.Background(), target, []*HyperLogLogRedis{other}, RedisMergeOptions{}); err == nil {
+		t.Errorf("merge of hyperloglogs with different registers should error out")
+	}
+}
+
+func TestMergeTopKsRedis(t *testing.T) {
+	initMockRedis()
+	target, _ := NewTopKRedis(3, 0.001, 0.999)
+	target.InsertString("a", 5)
+	target.InsertString("b", 4)
+	target.InsertString("c", 3)
+	first, _ := NewTopKRedis(3, 0.001, 0.999)
+	first.InsertString("c", 10)
+	first.InsertString("d", 6)
+	first.InsertString("e", 1)
+	second, _ := NewTopKRedis(3, 0.001, 0.999)
+	second.InsertString("b", 3)
+	second.InsertString("e", 2)
+	if err := MergeTopKsRedis(context.Background(), target, []*TopKRedis{first, second}, RedisMergeOptions{}); err != nil {
+		t.Fatalf("merge shouldn't error out, error: %v", err)
+	}
+	values, _ := target.Values()
+	expected := []TopKElement{{"c", 13}, {"b", 7}, {"d", 6}}
+	if len(values) != len(expected) {
+		t.Fatalf("merged topk should hold %d elements, got %v", len(expected), values)
+	}
+	for i := range expected {
+		if values[i] != expected[i] {
+			t.Errorf("element %d of the merged topk should be %v, got %v", i, expected[i], values[i])
+		}
+	}
+	if count, _ := target.sketch.CountString("e"); count != 3 {
+		t.Errorf("sketches should be merged, count of e should be 3, got %d", count)
+	}
+	other, _ := NewTopKRedis(4, 0.001, 0.999)
+	if err := MergeTopKsRedis(context.Background(), target, []*TopKRedis{other}, RedisMergeOptions{}); err == nil {
+		t.Errorf("merge of topks with different parameters should error out")
+	}
+}
+
+func TestMergeRedisSourcesRetries(t *testing.T) {
+	attempts := 0
+	merge := func(i int) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}
+	opts := RedisMergeOptions{Retries: 2, RetryDelay: time.Millisecond}
+	if err := mergeRedisSources(context.Background(), 1, opts, merge); err != nil || attempts != 3 {
+		t.Errorf("merge should succeed after 2 retries, attempts: %d, error: %v", attempts, err)
+	}
+	attempts = 0
+	opts.Retries = 1
+	if err := mergeRedisSources(context.Background(), 1, opts, merge); err == nil || attempts != 2 {
+		t.Errorf("merge should fail after 1 retry, attempts: %d", attempts)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts = 0
+	opts.RetryDelay = time.Hour
+	if err := mergeRedisSources(ctx, 1, opts, merge); err == nil || attempts != 1 {
+		t.Errorf("merge should stop retrying once the context is cancelled, attempts: %d", attempts)
+	}
+	if err := mergeRedisSources(context.Background(), 1, RedisMergeOptions{Retries: -1}, merge); err == nil {
+		t.Errorf("negative retries should error out")
+	}
+}
@@ -0,0 +1,140 @@
+package gostatix
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMergeCountMinSketches(t *testing.T) {
+	// wide enough rows to be merged in several chunks
+	target, _ := NewCountMinSketch(3, 3*wordChunkSize)
+	expected, _ := NewCountMinSketch(3, 3*wordChunkSize)
+	sources := make([]*CountMinSketch, 4)
+	for i := range sources {
+		sources[i], _ = NewCountMinSketch(3, 3*wordChunkSize)
+		for j := 0; j < 1000; j++ {
+			sources[i].UpdateString(strconv.Itoa(i*500+j), uint64(i+1))
+			expected.UpdateString(strconv.Itoa(i*500+j), uint64(i+1))
+		}
+	}
+	err := MergeCountMinSketches(target, sources)
+	if err != nil {
+		t.Fatalf("merge shouldn't error out, error: %v", err)
+	}
+	if equal, _ := target.Equals(expected); !equal || target.TotalCount() != expected.TotalCount() {
+		t.Errorf("merged sketch should equal the sketch of all the updates")
+	}
+	other, _ := NewCountMinSketch(3, 100)
+	if err := MergeCountMinSketches(target, []*CountMinSketch{sources[0], other}); err == nil {
+		t.Errorf("sketches of different dimensions shouldn't be merged")
+	}
+	if equal, _ := target.Equals(expected); !equal {
+		t.Errorf("sources shouldn't be merged if one of them can't be")
+	}
+}
+
+func TestMergeHyperLogLogs(t *testing.T) {
+	target, _ := NewHyperLogLogWithEstimator(1<<18, HLLPlusPlusEstimator)
+	expected, _ := NewHyperLogLogWithEstimator(1<<18, HLLPlusPlusEstimator)
+	sources := make([]*HyperLogLog, 4)
+	for i := range sources {
+		sources[i], _ = NewHyperLogLogWithEstimator(1<<18, HLLPlusPlusEstimator)
+		for j := 0; j < 10000; j++ {
+			sources[i].UpdateString(strconv.Itoa(i*5000 + j))
+			expected.UpdateString(strconv.Itoa(i*5000 + j))
+		}
+	}
+	err := MergeHyperLogLogs(target, sources)
+	if err != nil {
+		t.Fatalf("merge shouldn't error out, error: %v", err)
+	}
+	if equal, _ := target.Equals(expected); !equal {
+		t.Errorf("merged hyperloglog should equal the hyperloglog of all the updates")
+	}
+	legacy, _ := NewHyperLogLog(1 << 18)
+	if err := MergeHyperLogLogs(target, []*HyperLogLog{legacy}); err == nil {
+		t.Errorf("hyperloglogs with different estimators shouldn't be merged")
+	}
+}
+
+func TestMergeBloomFilters(t *testing.T) {
+	target, _ := NewMemBloomFilterWithParameters(10000, 0.001)
+	sources := make([]*BloomFilter, 3)
+	for i := range sources {
+		sources[i], _ = NewMemBloomFilterWithParameters(10000, 0.001)
+		sources[i].InsertString(strconv.Itoa(i))
+	}
+	err := MergeBloomFilters(target, sources)
+	if err != nil {
+		t.Fatalf("merge shouldn't error out, error: %v", err)
+	}
+	for i := range sources {
+		if !target.LookupString(strconv.Itoa(i)) {
+			t.Errorf("%d should be found in the merged filter", i)
+		}
+	}
+	other, _ := NewMemBloomFilterWithParameters(100, 0.001)
+	if err := MergeBloomFilters(target, []*BloomFilter{other}); err == nil {
+		t.Errorf("filters of different sizes shouldn't be merged")
+	}
+}
+
+// failingReader returns _data_ and then an error
+type failingReader struct {
+	data *strings.Reader
+}
+
+func (r failingReader) Read(p []byte) (int, error) {
+	n, _ := r.data.Read(p)
+	if n == 0 {
+		return 0, errors.New("connection reset")
+	}
+	return n, nil
+}
+
+func TestParallelLoaders(t *testing.T) {
+	var keys strings.Builder
+	for i := 0; i < 250000; i++ {
+		keys.WriteString(strconv.Itoa(i % 5000))
+		keys.WriteByte('\n')
+	}
+	cms, _ := NewCountMinSketch(4, 20000)
+	expectedCMS, _ := NewCountMinSketch(4, 20000)
+	expectedCMS.UpdateFromReader(strings.NewReader(keys.String()), '\n', nil)
+	var reported []uint64
+	count, err := cms.UpdateFromReaderParallel(strings.NewReader(keys.String()), '\n', 4, func(processed uint64) {
+		reported = append(reported, processed)
+	})
+	if err != nil || count != 250000 || len(reported) != 3 {
+		t.Fatalf("250000 keys should be loaded with 3 progress reports, got %d and %v, error: %v", count, reported, err)
+	}
+	if equal, _ := cms.Equals(expectedCMS); !equal || cms.TotalCount() != 250000 {
+		t.Errorf("sketch loaded in parallel should equal the sketch loaded sequentially")
+	}
+
+	hll, _ := NewHyperLogLog(1 << 12)
+	expectedHLL, _ := NewHyperLogLog(1 << 12)
+	expectedHLL.UpdateFromReader(strings.NewReader(keys.String()), '\n', nil)
+	hll.UpdateFromReaderParallel(strings.NewReader(keys.String()), '\n', 0, nil)
+	if equal, _ := hll.Equals(expectedHLL); !equal {
+		t.Errorf("hyperloglog loaded in parallel should equal the hyperloglog loaded sequentially")
+	}
+
+	filter, _ := NewMemBloomFilterWithParameters(5000, 0.001)
+	expectedFilter, _ := NewMemBloomFilterWithParameters(5000, 0.001)
+	expectedFilter.InsertFromReader(strings.NewReader(keys.String()), '\n', nil)
+	count, err = filter.InsertFromReaderParallel(failingReader{strings.NewReader(keys.String())}, '\n', 3, nil)
+	if err == nil || count != 250000 {
+		t.Errorf("load should report the error of the stream after its 250000 keys, got %d, error: %v", count, err)
+	}
+	if equal, _ := filter.Equals(expectedFilter); !equal {
+		t.Errorf("keys read before the error should be merged in the filter")
+	}
+	initMockRedis()
+	redisFilter, _ := NewRedisBloomFilterWithParameters(5000, 0.001)
+	if _, err := redisFilter.InsertFromReaderParallel(strings.NewReader(keys.String()), '\n', 3, nil); err == nil {
+		t.Errorf("redis backed filter shouldn't be loaded in parallel")
+	}
+}
@@ -1,6 +1,7 @@
 package gostatix
 
 import (
+	"encoding/binary"
 	"math/bits"
 	"unsafe"
 )
@@ -139,3 +140,53 @@ func sum128(data []byte) (h1 uint64, h2 uint64) {
 	tail := data[nblocks*d.Size():]
 	return d.Sum128(tail, uint(dlen))
 }
+
+// sum256 returns the murmur3 hashes of _data_ and of _data_ followed by the byte 1, as
+// used by github.com/bits-and-blooms/bloom. The blocks of _data_ are only mixed once and
+// the byte is appended to a copy of the tail on the stack.
+func sum256(data []byte) [4]uint64 {
+	d := digest128{h1: 0, h2: 0}
+	dlen := len(data)
+	nblocks := dlen / block_size
+	d.bmix(data, nblocks)
+	tail := data[nblocks*block_size:]
+	h1, h2 := d.Sum128(tail, uint(dlen))
+	var buf [block_size]byte
+	n := copy(buf[:], tail)
+	buf[n] = 1
+	if n+1 == block_size {
+		d.bmix(buf[:], 1)
+		h3, h4 := d.Sum128(nil, uint(dlen+1))
+		return [4]uint64{h1, h2, h3, h4}
+	}
+	h3, h4 := d.Sum128(buf[:n+1], uint(dlen+1))
+	return [4]uint64{h1, h2, h3, h4}
+}
+
+// murmurHash64A is the 64-bit MurmurHash2 (MurmurHash64A) of _data_ with _seed_, as used
+// by RedisBloom to hash the elements of its filters. Blocks are read in little endian.
+func murmurHash64A(data []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ (uint64(len(data)) * m)
+	numBlocks := len(data) / 8
+	for i := 0; i < numBlocks; i++ {
+		k := binary.LittleEndian.Uint64(data[i*8:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	tail := data[numBlocks*8:]
+	if len(tail) > 0 {
+		for i := len(tail) - 1; i >= 0; i-- {
+			h ^= uint64(tail[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
@@ -0,0 +1,286 @@
+package gostatix
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"time"
+
+	"github.com/kwertop/gostatix/internal/util"
+)
+
+// options holds the settings applied by the Options passed to NewBloomFilter
+type options struct {
+	backend   Backend
+	numItems  uint
+	errorRate float64
+	sharded   bool
+	numShards uint
+	hashing   BloomHashing
+	ttl       time.Duration
+	keyPrefix string
+	name      string
+	locking   bool
+	roaring   bool
+	ctx       context.Context
+}
+
+// Option configures a data structure created by NewBloomFilter
+type Option func(*options)
+
+// defaultOptions returns the settings used when no Option overrides them: an in-memory
+// data structure with locking and metro hashing
+func defaultOptions() options {
+	return options{backend: MemoryBackend, hashing: MetroHashing, locking: true, ctx: context.Background()}
+}
+
+// WithBackend stores the data structure in _backend_. It's MemoryBackend by default.
+func WithBackend(backend Backend) Option {
+	return func(o *options) {
+		o.backend = backend
+	}
+}
+
+// WithCapacity sizes the data structure for _numItems_ items with the false positive
+// rate _errorRate_. It's required.
+func WithCapacity(numItems uint, errorRate float64) Option {
+	return func(o *options) {
+		o.numItems = numItems
+		o.errorRate = errorRate
+	}
+}
+
+// WithShards splits the bitset of a Redis backed data structure across _numShards_ Redis
+// keys (see ShardedBitSetRedis)
+func WithShards(numShards uint) Option {
+	return func(o *options) {
+		o.sharded = true
+		o.numShards = numShards
+	}
+}
+
+// WithHashing sets the scheme used to hash the elements. It's MetroHashing by default.
+func WithHashing(hashing BloomHashing) Option {
+	return func(o *options) {
+		o.hashing = hashing
+	}
+}
+
+// WithTTL expires the Redis keys of a Redis backed data structure _ttl_ after its
+// creation. The keys aren't expired by default.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithKeyPrefix prepends _prefix_ to the random Redis keys of a Redis backed data
+// structure, e.g. to namespace them per service
+func WithKeyPrefix(prefix string) Option {
+	return func(o *options) {
+		o.keyPrefix = prefix
+	}
+}
+
+// WithName names a Redis backed data structure: its metadata key is the key prefix
+// followed by _name_ and its other keys are derived from it, instead of random keys, so
+// that infrastructure can refer to it by a predictable key. The creation errors out if the
+// metadata key already exists, NewRedisBloomFilterFromKey and the other FromKey loaders
+// open an existing data structure.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithLocking enables or disables the lock synchronizing the reads and writes of an
+// in-memory data structure. It's enabled by default, it can be disabled for data
+// structures only used by a single goroutine.
+func WithLocking(enabled bool) Option {
+	return func(o *options) {
+		o.locking = enabled
+	}
+}
+
+// WithRoaring backs an in-memory bloom filter with a BitSetRoaring instead of a BitSetMem,
+// which saves memory for sparse filters of huge sizes with few bits set. The filter holds
+// at most 2^32 bits.
+func WithRoaring() Option {
+	return func(o *options) {
+		o.roaring = true
+	}
+}
+
+// WithContext sets the context of the Redis commands sent while creating a Redis backed
+// data structure. It's context.Background() by default.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// validate checks that the settings are consistent with the backend
+func (o *options) validate() error {
+	switch o.backend {
+	case MemoryBackend:
+		if o.sharded || o.ttl != 0 || o.keyPrefix != "" || o.name != "" {
+			return fmt.Errorf("gostatix: shards, ttl, key prefix and name are only supported by the redis backend")
+		}
+	case RedisBackend:
+		if o.ttl < 0 {
+			return fmt.Errorf("gostatix: ttl %v can't be negative", o.ttl)
+		}
+		if o.roaring {
+			return fmt.Errorf("gostatix: roaring bitsets are only supported by the memory backend")
+		}
+	default:
+		return fmt.Errorf("gostatix: unknown backend %v", o.backend)
+	}
+	if o.ctx == nil {
+		return fmt.Errorf("gostatix: context can't be nil")
+	}
+	if o.hashing > BlockedHashing {
+		return fmt.Errorf("gostatix: unknown hashing scheme %v", o.hashing)
+	}
+	return nil
+}
+
+// bloomFilterSize returns the size and the number of hashes of a bloom filter with the
+// capacity set by WithCapacity. A Redis backed bitset larger than a Redis string is split
+// across as many shards as needed if WithShards isn't set.
+func (o *options) bloomFilterSize() (uint, uint, error) {
+	params := BloomFilterParams{o.numItems, o.errorRate}
+	err := params.Validate()
+	if err != nil {
+		return 0, 0, err
+	}
+	size := params.Size()
+	if o.hashing == BlockedHashing {
+		// whole blocks, so that the blocks of an in-memory bitset are aligned on its words
+		size = (size + blockBits - 1) / blockBits * blockBits
+	}
+	// a size held by a uint can only exceed a Redis string on 64-bit platforms, the filters
+	// are never sharded automatically on 32-bit ones
+	if bits.UintSize == 64 && o.backend == RedisBackend && !o.sharded && uint64(size) > maxShardSize {
+		o.sharded = true
+		o.numShards = uint((uint64(size) + maxShardSize - 1) / maxShardSize)
+	}
+	return size, params.NumHashes(), nil
+}
+
+// redisOptions applies _opts_ for the creation of a Redis backed _kind_ other than a
+// bloom filter, which only supports WithName, WithKeyPrefix and WithContext
+func redisOptions(kind string, opts []Option) (options, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.numItems != 0 || o.errorRate != 0 || o.sharded || o.ttl != 0 || o.hashing != MetroHashing || !o.locking || o.roaring {
+		return o, fmt.Errorf("gostatix: only WithName, WithKeyPrefix and WithContext apply to a redis backed %s", kind)
+	}
+	if o.ctx == nil {
+		return o, fmt.Errorf("gostatix: context can't be nil")
+	}
+	return o, nil
+}
+
+// maxKeyAttempts is the number of random keys tried before giving up on finding a key
+// which doesn't exist in Redis
+const maxKeyAttempts = 5
+
+// newRedisKey returns a random Redis key prefixed by _prefix_ which doesn't exist in Redis
+func newRedisKey(ctx context.Context, prefix string) (string, error) {
+	for i := 0; i < maxKeyAttempts; i++ {
+		key := prefix + util.GenerateRandomString(16)
+		exists, err := getRedisClient().Exists(ctx, key).Result()
+		if err != nil {
+			return "", fmt.Errorf("gostatix: error while checking redis key %s, error: %v", key, err)
+		}
+		if exists == 0 {
+			return key, nil
+		}
+	}
+	return "", fmt.Errorf("gostatix: no free redis key found after %d attempts", maxKeyAttempts)
+}
+
+// metadataKey returns the metadata key of a new data structure: the key prefix followed
+// by the name if it's named, which mustn't exist yet, else a new random key
+func (o *options) metadataKey() (string, error) {
+	if o.name == "" {
+		return newRedisKey(o.ctx, o.keyPrefix)
+	}
+	key := o.keyPrefix + o.name
+	exists, err := o.nameExists()
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", fmt.Errorf("gostatix: redis key %s already exists", key)
+	}
+	return key, nil
+}
+
+// nameExists returns true if the metadata key of the data structure named by _o_ exists
+func (o *options) nameExists() (bool, error) {
+	key := o.keyPrefix + o.name
+	exists, err := getRedisClient().Exists(o.ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("gostatix: error while checking redis key %s, error: %v", key, err)
+	}
+	return exists != 0, nil
+}
+
+// namedOptions returns _opts_ naming a data structure _name_, for the NewOrOpen
+// constructors. _opts_ isn't modified.
+func namedOptions(name string, opts []Option) ([]Option, error) {
+	if name == "" {
+		return nil, fmt.Errorf("gostatix: name can't be empty")
+	}
+	return append(append([]Option{}, opts...), WithName(name)), nil
+}
+
+// checkOpenedParams returns an error if the _opened_ parameters of the _kind_ at _key_
+// differ from the _expected_ ones of the NewOrOpen constructor
+func checkOpenedParams(kind, key string, opened, expected []interface{}) error {
+	for i := range opened {
+		if opened[i] != expected[i] {
+			return fmt.Errorf("gostatix: %s at key %s was created with different parameters", kind, key)
+		}
+	}
+	return nil
+}
+
+// dataKey returns a Redis key holding the data of a new data structure: the metadata key
+// followed by _suffix_ if it's named, else a new random key
+func (o *options) dataKey(suffix string) (string, error) {
+	if o.name == "" {
+		return newRedisKey(o.ctx, o.keyPrefix)
+	}
+	return o.keyPrefix + o.name + suffix, nil
+}
+
+// keyOptions returns the options naming the keys of a data structure created along with
+// the one configured by _o_, named after it with _suffix_
+func (o *options) keyOptions(suffix string) []Option {
+	opts := []Option{WithKeyPrefix(o.keyPrefix), WithContext(o.ctx)}
+	if o.name != "" {
+		opts = append(opts, WithName(o.name+suffix))
+	}
+	return opts
+}
+
+// expire sets the ttl on the Redis _keys_, if any
+func (o *options) expire(keys ...string) error {
+	if o.ttl == 0 {
+		return nil
+	}
+	pipe := getRedisClient().Pipeline()
+	for _, key := range keys {
+		pipe.PExpire(o.ctx, key, o.ttl)
+	}
+	_, err := pipe.Exec(o.ctx)
+	if err != nil {
+		return fmt.Errorf("gostatix: error while setting ttl of keys in redis, error: %v", err)
+	}
+	return nil
+}
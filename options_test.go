@@ -0,0 +1,244 @@
+package gostatix
+
+import (
+	"context"
+	"math/bits"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewBloomFilterOptions(t *testing.T) {
+	initMockRedis()
+	filter, err := NewBloomFilter(
+		WithBackend(RedisBackend),
+		WithCapacity(1000, 0.01),
+		WithKeyPrefix("svc:"),
+		WithTTL(time.Hour),
+		WithHashing(RedisBloomHashing),
+		WithContext(context.Background()),
+	)
+	if err != nil {
+		t.Fatalf("filter creation shouldn't error out, error: %v", err)
+	}
+	filter.InsertString("foo")
+	if !filter.LookupString("foo") {
+		t.Errorf("foo should be found in the filter")
+	}
+	metadataKey := filter.GetMetadataKey()
+	bitSetKey := filter.filter.(*BitSetRedis).getKey()
+	for _, key := range []string{metadataKey, bitSetKey} {
+		if !strings.HasPrefix(key, "svc:") {
+			t.Errorf("key %s should start with the key prefix", key)
+		}
+		ttl, _ := getRedisClient().PTTL(context.Background(), key).Result()
+		if ttl <= 0 || ttl > time.Hour {
+			t.Errorf("key %s should expire within an hour, ttl: %v", key, ttl)
+		}
+	}
+	loaded, _ := NewRedisBloomFilterFromKey(metadataKey)
+	if loaded.Hashing() != RedisBloomHashing || !loaded.LookupString("foo") {
+		t.Errorf("filter loaded from key should use redisbloom hashing and find foo, hashing: %v", loaded.Hashing())
+	}
+
+	sharded, err := NewBloomFilter(WithBackend(RedisBackend), WithCapacity(1000, 0.01), WithShards(3), WithKeyPrefix("svc:"))
+	if err != nil {
+		t.Fatalf("sharded filter creation shouldn't error out, error: %v", err)
+	}
+	for _, key := range sharded.filter.(*ShardedBitSetRedis).getKeys() {
+		if !strings.HasPrefix(key, "svc:") {
+			t.Errorf("shard key %s should start with the key prefix", key)
+		}
+	}
+}
+
+func TestNewBloomFilterUnsynchronized(t *testing.T) {
+	filter, err := NewBloomFilter(WithCapacity(1000, 0.01), WithLocking(false))
+	if err != nil {
+		t.Fatalf("filter creation shouldn't error out, error: %v", err)
+	}
+	if filter.needsLock() {
+		t.Errorf("filter created without locking shouldn't need the lock")
+	}
+	filter.InsertString("foo")
+	if !filter.LookupString("foo") {
+		t.Errorf("foo should be found in the filter")
+	}
+	locked, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	if !locked.needsLock() {
+		t.Errorf("in-memory filter should need the lock by default")
+	}
+}
+
+func TestNewBloomFilterInvalidOptions(t *testing.T) {
+	invalid := map[string][]Option{
+		"no capacity":     {},
+		"memory ttl":      {WithCapacity(1000, 0.01), WithTTL(time.Minute)},
+		"memory prefix":   {WithCapacity(1000, 0.01), WithKeyPrefix("svc:")},
+		"memory shards":   {WithCapacity(1000, 0.01), WithShards(2)},
+		"unknown backend": {WithCapacity(1000, 0.01), WithBackend(Backend(7))},
+		"unknown hashing": {WithCapacity(1000, 0.01), WithHashing(BloomHashing(9))},
+		"nil context":     {WithCapacity(1000, 0.01), WithContext(nil)},
+	}
+	for name, opts := range invalid {
+		if _, err := NewBloomFilter(opts...); err == nil {
+			t.Errorf("filter creation should error out for %s", name)
+		}
+	}
+}
+
+func TestNamedRedisKeys(t *testing.T) {
+	initMockRedis()
+	filter, err := NewBloomFilter(WithBackend(RedisBackend), WithCapacity(1000, 0.01), WithKeyPrefix("svc:"), WithName("users"))
+	if err != nil {
+		t.Fatalf("named filter creation shouldn't error out, error: %v", err)
+	}
+	if filter.GetMetadataKey() != "svc:users" || filter.filter.(*BitSetRedis).getKey() != "svc:users:bits" {
+		t.Errorf("named filter should use predictable keys, metadata key: %s", filter.GetMetadataKey())
+	}
+	_, err = NewBloomFilter(WithBackend(RedisBackend), WithCapacity(1000, 0.01), WithKeyPrefix("svc:"), WithName("users"))
+	if err == nil {
+		t.Errorf("filter creation should error out when its name is taken")
+	}
+
+	cuckoo, err := NewCuckooFilterRedis(100, 4, 8, WithName("cuckoo"))
+	if err != nil {
+		t.Fatalf("named cuckoo filter creation shouldn't error out, error: %v", err)
+	}
+	if cuckoo.metadataKey != "cuckoo" || cuckoo.key != "cuckoo:buckets" {
+		t.Errorf("named cuckoo filter should use predictable keys, keys: %s, %s", cuckoo.metadataKey, cuckoo.key)
+	}
+	log, err := NewHyperLogLogRedis(16, WithName("hll"))
+	if err != nil {
+		t.Fatalf("named hyperloglog creation shouldn't error out, error: %v", err)
+	}
+	if log.metadataKey != "hll" || log.key != "hll:registers" {
+		t.Errorf("named hyperloglog should use predictable keys, keys: %s, %s", log.metadataKey, log.key)
+	}
+	topk, err := NewTopKRedis(5, 0.01, 0.99, WithKeyPrefix("svc:"), WithName("topk"))
+	if err != nil {
+		t.Fatalf("named topk creation shouldn't error out, error: %v", err)
+	}
+	if topk.metadataKey != "svc:topk" || topk.heapKey != "svc:topk:heap" || topk.sketch.metadataKey != "svc:topk:cms" {
+		t.Errorf("named topk and its sketch should use predictable keys, keys: %s, %s", topk.metadataKey, topk.sketch.metadataKey)
+	}
+
+	if _, err = NewCountMinSketchRedis(4, 100, WithCapacity(1000, 0.01)); err == nil {
+		t.Errorf("count-min sketch creation should error out for bloom filter options")
+	}
+	if _, err = NewHyperLogLogRedis(16, WithContext(nil)); err == nil {
+		t.Errorf("hyperloglog creation should error out for a nil context")
+	}
+}
+
+func TestNewRedisKey(t *testing.T) {
+	initMockRedis()
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		key, err := newRedisKey(context.Background(), "svc:")
+		if err != nil {
+			t.Fatalf("key generation shouldn't error out, error: %v", err)
+		}
+		if len(key) != 20 || !strings.HasPrefix(key, "svc:") || seen[key] {
+			t.Errorf("key %s should be a new prefixed random key of 16 letters", key)
+		}
+		seen[key] = true
+	}
+}
+
+func TestNewOrOpen(t *testing.T) {
+	initMockRedis()
+	filter, err := NewOrOpenBloomFilter("seen", WithCapacity(1000, 0.01), WithKeyPrefix("svc:"))
+	if err != nil {
+		t.Fatalf("filter creation shouldn't error out, error: %v", err)
+	}
+	filter.InsertString("foo")
+	opened, err := NewOrOpenBloomFilter("seen", WithCapacity(1000, 0.01), WithKeyPrefix("svc:"))
+	if err != nil {
+		t.Fatalf("filter opening shouldn't error out, error: %v", err)
+	}
+	if opened.GetMetadataKey() != "svc:seen" || !opened.LookupString("foo") {
+		t.Errorf("opened filter should be the created one and find foo")
+	}
+	if _, err = NewOrOpenBloomFilter("seen", WithCapacity(2000, 0.01), WithKeyPrefix("svc:")); err == nil {
+		t.Errorf("filter opening should error out for a different capacity")
+	}
+	if _, err = NewOrOpenBloomFilter("seen", WithCapacity(1000, 0.01), WithKeyPrefix("svc:"), WithShards(2)); err == nil {
+		t.Errorf("filter opening should error out for a different number of shards")
+	}
+	if _, err = NewOrOpenBloomFilter("", WithCapacity(1000, 0.01)); err == nil {
+		t.Errorf("filter creation should error out for an empty name")
+	}
+
+	cuckoo, _ := NewOrOpenCuckooFilterRedis("cuckoo", 100, 4, 8)
+	cuckoo.InsertString("foo", false)
+	openedCuckoo, err := NewOrOpenCuckooFilterRedis("cuckoo", 100, 4, 8)
+	if err != nil {
+		t.Fatalf("cuckoo filter opening shouldn't error out, error: %v", err)
+	}
+	if found, _ := openedCuckoo.LookupString("foo"); !found {
+		t.Errorf("opened cuckoo filter should find foo")
+	}
+	if _, err = NewOrOpenCuckooFilterRedis("cuckoo", 100, 2, 8); err == nil {
+		t.Errorf("cuckoo filter opening should error out for a different bucket size")
+	}
+
+	sketch, _ := NewOrOpenCountMinSketchRedis("cms", 4, 100)
+	sketch.UpdateString("foo", 3)
+	openedSketch, err := NewOrOpenCountMinSketchRedis("cms", 4, 100)
+	if err != nil {
+		t.Fatalf("count-min sketch opening shouldn't error out, error: %v", err)
+	}
+	if count, _ := openedSketch.CountString("foo"); count != 3 {
+		t.Errorf("opened count-min sketch should count foo 3 times, count: %d", count)
+	}
+	if _, err = NewOrOpenCountMinSketchRedis("cms", 4, 200); err == nil {
+		t.Errorf("count-min sketch opening should error out for different columns")
+	}
+
+	if _, err = NewOrOpenHyperLogLogRedis("hll", 16); err != nil {
+		t.Fatalf("hyperloglog creation shouldn't error out, error: %v", err)
+	}
+	if _, err = NewOrOpenHyperLogLogRedis("hll", 16); err != nil {
+		t.Errorf("hyperloglog opening shouldn't error out, error: %v", err)
+	}
+	if _, err = NewOrOpenHyperLogLogRedis("hll", 32); err == nil {
+		t.Errorf("hyperloglog opening should error out for different registers")
+	}
+
+	if _, err = NewOrOpenTopKRedis("topk", 5, 0.01, 0.99); err != nil {
+		t.Fatalf("topk creation shouldn't error out, error: %v", err)
+	}
+	if _, err = NewOrOpenTopKRedis("topk", 5, 0.01, 0.99); err != nil {
+		t.Errorf("topk opening shouldn't error out, error: %v", err)
+	}
+	if _, err = NewOrOpenTopKRedis("topk", 10, 0.01, 0.99); err == nil {
+		t.Errorf("topk opening should error out for a different k")
+	}
+}
+
+func TestRedisBloomFilterAutoShards(t *testing.T) {
+	if bits.UintSize < 64 {
+		t.Skip("bloom filters larger than a redis string need 64-bit sizes")
+	}
+	o := defaultOptions()
+	WithBackend(RedisBackend)(&o)
+	WithCapacity(500000000, 0.01)(&o)
+	size, _, err := o.bloomFilterSize()
+	if err != nil {
+		t.Fatalf("sizing shouldn't error out, error: %v", err)
+	}
+	if !o.sharded || o.numShards != 2 {
+		t.Errorf("bitset of %d bits should be split across 2 shards, got %d", size, o.numShards)
+	}
+	if shardSize, _ := shardSizeFor(uint64(size), o.numShards); uint64(shardSize) > maxShardSize {
+		t.Errorf("shards of %d bits should fit in a redis string", shardSize)
+	}
+	o = defaultOptions()
+	WithBackend(RedisBackend)(&o)
+	WithCapacity(1000, 0.01)(&o)
+	o.bloomFilterSize()
+	if o.sharded {
+		t.Errorf("bitset fitting in a redis string shouldn't be sharded")
+	}
+}
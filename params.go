@@ -0,0 +1,251 @@
+package gostatix
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kwertop/gostatix/internal/util"
+)
+
+// maxFingerPrintLength is the maximum fingerprint length of a Cuckoo Filter. Fingerprints
+// are prefixes of the decimal representation of a 64 bit hash, which has at most 20 digits.
+const maxFingerPrintLength = 20
+
+// BloomFilterParams are the parameters of a BloomFilter created with
+// NewMemBloomFilterWithParameters or NewRedisBloomFilterWithParameters
+// _NumItems_ is the number of items expected to be inserted in the filter
+// _ErrorRate_ is the acceptable false positive rate, between 0 and 1
+type BloomFilterParams struct {
+	NumItems  uint
+	ErrorRate float64
+}
+
+// Validate returns a descriptive error if the parameters can't be used to create a BloomFilter
+func (p BloomFilterParams) Validate() error {
+	if p.NumItems == 0 {
+		return fmt.Errorf("gostatix: bloom filter number of items should be greater than 0")
+	}
+	if !(p.ErrorRate > 0 && p.ErrorRate < 1) {
+		return fmt.Errorf("gostatix: bloom filter error rate %v should be between 0 and 1", p.ErrorRate)
+	}
+	return nil
+}
+
+// Size returns the number of bits of the BloomFilter created with these parameters
+func (p BloomFilterParams) Size() uint {
+	return util.Max(util.CalculateFilterSize(p.NumItems, p.ErrorRate), 1)
+}
+
+// NumHashes returns the number of hash functions of the BloomFilter created with these
+// parameters
+func (p BloomFilterParams) NumHashes() uint {
+	return util.Max(util.CalculateNumHashes(p.Size(), p.NumItems), 1)
+}
+
+// Estimate validates the parameters and returns the number of bytes used by the
+// bitset of an in-memory BloomFilter created with them
+func (p BloomFilterParams) Estimate() (uint64, error) {
+	err := p.Validate()
+	if err != nil {
+		return 0, err
+	}
+	return wordsFor(uint64(p.Size())) * uint64(wordBytes), nil
+}
+
+// CuckooFilterParams are the parameters of a CuckooFilter created with
+// NewCuckooFilterWithRetries or NewCuckooFilterRedisWithRetries
+// _Size_ is the number of buckets
+// _BucketSize_ is the number of fingerprints held by each bucket
+// _FingerPrintLength_ is the length of the fingerprints, between 1 and 20
+// _Retries_ is the number of relocations attempted before an insert fails
+type CuckooFilterParams struct {
+	Size              uint64
+	BucketSize        uint64
+	FingerPrintLength uint64
+	Retries           uint64
+}
+
+// Validate returns a descriptive error if the parameters can't be used to create a CuckooFilter
+func (p CuckooFilterParams) Validate() error {
+	if p.Size == 0 {
+		return fmt.Errorf("gostatix: cuckoo filter size should be greater than 0")
+	}
+	if p.BucketSize == 0 {
+		return fmt.Errorf("gostatix: cuckoo filter bucket size should be greater than 0")
+	}
+	if p.FingerPrintLength == 0 || p.FingerPrintLength > maxFingerPrintLength {
+		return fmt.Errorf("gostatix: cuckoo filter fingerprint length %d should be between 1 and %d, the length of the hash", p.FingerPrintLength, maxFingerPrintLength)
+	}
+	return nil
+}
+
+// Estimate validates the parameters and returns the approximate number of bytes used by
+// the packed buckets of an in-memory CuckooFilter created with them
+func (p CuckooFilterParams) Estimate() (uint64, error) {
+	err := p.Validate()
+	if err != nil {
+		return 0, err
+	}
+	// the fingerprints are packed in words of _width_ bits each
+	width := fingerPrintWidth(p.FingerPrintLength)
+	if p.BucketSize > math.MaxUint64/width || p.Size > math.MaxUint64/(p.BucketSize*width) {
+		return 0, fmt.Errorf("gostatix: cuckoo filter of %d buckets of size %d is too large", p.Size, p.BucketSize)
+	}
+	bits := p.Size * p.BucketSize * width
+	return (bits/uint64(wordSize) + minUint64(bits%uint64(wordSize), 1)) * uint64(wordBytes), nil
+}
+
+// CountMinSketchParams are the parameters of a CountMinSketch created with
+// NewCountMinSketch or NewCountMinSketchRedis
+// _Rows_ is the number of rows (hash functions) of the matrix
+// _Columns_ is the number of columns of the matrix
+type CountMinSketchParams struct {
+	Rows    uint
+	Columns uint
+}
+
+// CountMinSketchParamsFromEstimates returns the CountMinSketchParams for the desired
+// _errorRate_ and _delta_, both between 0 and 1
+func CountMinSketchParamsFromEstimates(errorRate, delta float64) (CountMinSketchParams, error) {
+	if !(errorRate > 0 && errorRate < 1) {
+		return CountMinSketchParams{}, fmt.Errorf("gostatix: count-min sketch error rate %v should be between 0 and 1", errorRate)
+	}
+	if !(delta > 0 && delta < 1) {
+		return CountMinSketchParams{}, fmt.Errorf("gostatix: count-min sketch delta %v should be between 0 and 1", delta)
+	}
+	columns := uint(math.Ceil(math.E / errorRate))
+	rows := uint(math.Ceil(math.Log(1 / delta)))
+	return CountMinSketchParams{Rows: util.Max(rows, 1), Columns: columns}, nil
+}
+
+// Validate returns a descriptive error if the parameters can't be used to create a CountMinSketch
+func (p CountMinSketchParams) Validate() error {
+	if p.Rows == 0 || p.Columns == 0 {
+		return fmt.Errorf("gostatix: rows and columns size should be greater than 0")
+	}
+	return nil
+}
+
+// Estimate validates the parameters and returns the number of bytes used by the
+// matrix of an in-memory CountMinSketch created with them
+func (p CountMinSketchParams) Estimate() (uint64, error) {
+	err := p.Validate()
+	if err != nil {
+		return 0, err
+	}
+	rows, columns := uint64(p.Rows), uint64(p.Columns)
+	if columns > math.MaxUint64/8 || rows > math.MaxUint64/(columns*8+sliceSize) {
+		return 0, fmt.Errorf("gostatix: count-min sketch of %d rows and %d columns is too large", rows, columns)
+	}
+	return rows * (columns*8 + sliceSize), nil
+}
+
+// HyperLogLogParams are the parameters of a HyperLogLog created with
+// NewHyperLogLog or NewHyperLogLogRedis
+// _NumRegisters_ is the number of registers, a power of two
+type HyperLogLogParams struct {
+	NumRegisters uint64
+}
+
+// Validate returns a descriptive error if the parameters can't be used to create a HyperLogLog
+func (p HyperLogLogParams) Validate() error {
+	if p.NumRegisters == 0 {
+		return fmt.Errorf("gostatix: hyperloglog number of registers can't be zero")
+	}
+	if p.NumRegisters&(p.NumRegisters-1) != 0 {
+		return fmt.Errorf("gostatix: hyperloglog number of registers %d not a power of two", p.NumRegisters)
+	}
+	return nil
+}
+
+// Estimate validates the parameters and returns the number of bytes used by the
+// registers of an in-memory HyperLogLog created with them
+func (p HyperLogLogParams) Estimate() (uint64, error) {
+	err := p.Validate()
+	if err != nil {
+		return 0, err
+	}
+	return p.NumRegisters, nil
+}
+
+// LinearCountingParams are the parameters of a LinearCounting created with NewLinearCounting
+// _NumBits_ is the number of bits of the bitmap
+type LinearCountingParams struct {
+	NumBits uint64
+}
+
+// Validate returns a descriptive error if the parameters can't be used to create a LinearCounting
+func (p LinearCountingParams) Validate() error {
+	if p.NumBits == 0 {
+		return fmt.Errorf("gostatix: linear counting number of bits can't be zero")
+	}
+	return nil
+}
+
+// Estimate validates the parameters and returns the number of bytes used by the
+// bitmap of a LinearCounting created with them
+func (p LinearCountingParams) Estimate() (uint64, error) {
+	err := p.Validate()
+	if err != nil {
+		return 0, err
+	}
+	return wordsFor(p.NumBits) * uint64(wordBytes), nil
+}
+
+// KMinValuesParams are the parameters of a KMinValues created with NewKMinValues
+// _K_ is the number of minimum hashes kept, at least 2
+type KMinValuesParams struct {
+	K uint64
+}
+
+// Validate returns a descriptive error if the parameters can't be used to create a KMinValues
+func (p KMinValuesParams) Validate() error {
+	if p.K < 2 {
+		return fmt.Errorf("gostatix: k-minimum values k %d should be at least 2", p.K)
+	}
+	return nil
+}
+
+// Estimate validates the parameters and returns the number of bytes used by the
+// hashes of a full KMinValues created with them
+func (p KMinValuesParams) Estimate() (uint64, error) {
+	err := p.Validate()
+	if err != nil {
+		return 0, err
+	}
+	return p.K * 8, nil
+}
+
+// TopKParams are the parameters of a TopK created with NewTopK or NewTopKRedis
+// _K_ is the number of top elements to track
+// _ErrorRate_ is the acceptable error rate of the count-min sketch, between 0 and 1
+// _Accuracy_ is the delta of the count-min sketch, between 0 and 1
+type TopKParams struct {
+	K         uint
+	ErrorRate float64
+	Accuracy  float64
+}
+
+// Validate returns a descriptive error if the parameters can't be used to create a TopK
+func (p TopKParams) Validate() error {
+	if p.K == 0 {
+		return fmt.Errorf("gostatix: top-k k should be greater than 0")
+	}
+	_, err := CountMinSketchParamsFromEstimates(p.ErrorRate, p.Accuracy)
+	return err
+}
+
+// Estimate validates the parameters and returns the approximate number of bytes used by
+// an in-memory TopK created with them, excluding the length of the tracked elements
+func (p TopKParams) Estimate() (uint64, error) {
+	err := p.Validate()
+	if err != nil {
+		return 0, err
+	}
+	sketch, _ := CountMinSketchParamsFromEstimates(p.ErrorRate, p.Accuracy)
+	sketchBytes, err := sketch.Estimate()
+	if err != nil {
+		return 0, err
+	}
+	return sketchBytes + uint64(p.K)*(stringSize+8), nil
+}
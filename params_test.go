@@ -0,0 +1,100 @@
+package gostatix
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParamsValidate(t *testing.T) {
+	invalid := []interface{ Validate() error }{
+		BloomFilterParams{0, 0.01},
+		BloomFilterParams{100, 1},
+		BloomFilterParams{100, math.NaN()},
+		CuckooFilterParams{0, 4, 3, 500},
+		CuckooFilterParams{100, 0, 3, 500},
+		CuckooFilterParams{100, 4, 0, 500},
+		CuckooFilterParams{100, 4, 21, 500},
+		CountMinSketchParams{0, 10},
+		CountMinSketchParams{10, 0},
+		HyperLogLogParams{0},
+		HyperLogLogParams{1000},
+		TopKParams{0, 0.01, 0.9},
+		TopKParams{10, 1.5, 0.9},
+		TopKParams{10, 0.01, 0},
+	}
+	for _, params := range invalid {
+		if params.Validate() == nil {
+			t.Errorf("%#v should be invalid", params)
+		}
+	}
+	valid := []interface{ Validate() error }{
+		BloomFilterParams{100, 0.01},
+		CuckooFilterParams{100, 4, 20, 0},
+		CountMinSketchParams{1, 1},
+		HyperLogLogParams{1024},
+		TopKParams{10, 0.01, 0.9},
+	}
+	for _, params := range valid {
+		if err := params.Validate(); err != nil {
+			t.Errorf("%#v should be valid, error: %v", params, err)
+		}
+	}
+}
+
+func TestParamsEstimate(t *testing.T) {
+	bytes, _ := BloomFilterParams{1000, 0.01}.Estimate()
+	filter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	if bytes != uint64(filter.filter.(*BitSetMem).set.BinaryStorageSize()-8) {
+		t.Errorf("bloom filter estimate %d should match the size of the bitset", bytes)
+	}
+	bytes, _ = CountMinSketchParams{4, 100}.Estimate()
+	if bytes != 4*(100*8+sliceSize) {
+		t.Errorf("count-min sketch estimate %d should be %d", bytes, 4*(100*8+sliceSize))
+	}
+	bytes, _ = HyperLogLogParams{1024}.Estimate()
+	if bytes != 1024 {
+		t.Errorf("hyperloglog estimate %d should be 1024", bytes)
+	}
+	_, err := CountMinSketchParams{math.MaxUint, math.MaxUint}.Estimate()
+	if err == nil {
+		t.Error("count-min sketch estimate should error out as it overflows")
+	}
+	_, err = CuckooFilterParams{math.MaxUint64, math.MaxUint64, 3, 500}.Estimate()
+	if err == nil {
+		t.Error("cuckoo filter estimate should error out as it overflows")
+	}
+	_, err = TopKParams{10, 2, 0.9}.Estimate()
+	if err == nil {
+		t.Error("top-k estimate should error out as the error rate is invalid")
+	}
+}
+
+func TestConstructorValidation(t *testing.T) {
+	if _, err := NewCuckooFilter(0, 4, 3); err == nil {
+		t.Error("cuckoo filter of size 0 should error out")
+	}
+	if _, err := NewCuckooFilter(10, 4, 30); err == nil {
+		t.Error("cuckoo filter with fingerprint longer than the hash should error out")
+	}
+	if _, err := NewCuckooFilterWithErrorRate(100, 4, 500, 1); err == nil {
+		t.Error("cuckoo filter with error rate 1 should error out")
+	}
+	if _, err := NewCuckooFilterWithErrorRate(100, 0, 500, 0.01); err == nil {
+		t.Error("cuckoo filter with bucket size 0 should error out")
+	}
+	if _, err := NewCuckooFilterRedis(0, 0, 0); err == nil {
+		t.Error("redis cuckoo filter of size 0 should error out")
+	}
+	if _, err := NewCuckooFilterKV(NewMemKVStore(), "cuckoo", 10, 0, 3); err == nil {
+		t.Error("kv cuckoo filter with bucket size 0 should error out")
+	}
+	if _, err := NewCountMinSketchFromEstimates(0.01, 1); err == nil {
+		t.Error("count-min sketch with delta 1 should error out")
+	}
+	if _, err := NewHyperLogLog(0); err == nil {
+		t.Error("hyperloglog with 0 registers should error out")
+	}
+	if _, err := NewTopK(0, 0.01, 0.9); err == nil {
+		t.Error("top-k with k 0 should error out")
+	}
+}
@@ -0,0 +1,279 @@
+package gostatix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// The portable snapshots of BloomFilter, CountMinSketch and HyperLogLog use a binary
+// layout which doesn't depend on Go, so that they can be produced and consumed by other
+// languages. All the integers are big endian.
+//
+//	header       magic "GSTX" (4 bytes), version (1 byte), kind (1 byte), reserved (2 bytes, 0)
+//	bloom filter size in bits (uint64), number of hashes (uint64), hashing scheme (1 byte),
+//	             bits (size/8 bytes rounded up, bit i is bit i%8 of byte i/8, least
+//	             significant first)
+//	count-min    rows (uint64), columns (uint64), total count (uint64),
+//	             counters (rows*columns uint64, row by row)
+//	hyperloglog  number of registers (uint64), registers (1 byte each)
+//
+// The hashing of the elements isn't part of the layout, ports have to hash them like
+// gostatix to update or query a data structure decoded from a snapshot.
+
+// portableMagic starts every portable snapshot
+var portableMagic = []byte("GSTX")
+
+// portableVersion is the version of the portable layout
+const portableVersion = 1
+
+// portableHeaderSize is the size of the header of a portable snapshot
+const portableHeaderSize = 8
+
+// kinds of data structures in portable snapshots
+const (
+	portableBloomFilter    = 1
+	portableCountMinSketch = 2
+	portableHyperLogLog    = 3
+)
+
+// isPortable returns true if _data_ starts with the magic of a portable snapshot
+func isPortable(data []byte) bool {
+	return bytes.HasPrefix(data, portableMagic)
+}
+
+// newPortableBuffer returns a buffer holding the header of a portable snapshot of _kind_,
+// with room for _size_ more bytes
+func newPortableBuffer(kind uint8, size uint64) *bytes.Buffer {
+	buf := bytes.NewBuffer(make([]byte, 0, portableHeaderSize+size))
+	buf.Write(portableMagic)
+	buf.Write([]byte{portableVersion, kind, 0, 0})
+	return buf
+}
+
+// readPortableHeader checks the header of the portable snapshot _data_ of _kind_ and
+// returns a reader of its body
+func readPortableHeader(data []byte, kind uint8, name string) (*bytes.Reader, error) {
+	if len(data) < portableHeaderSize || !isPortable(data) {
+		return nil, fmt.Errorf("gostatix: invalid portable snapshot, header is missing")
+	}
+	if data[4] != portableVersion {
+		return nil, fmt.Errorf("gostatix: unsupported portable snapshot version %d", data[4])
+	}
+	if data[5] != kind {
+		return nil, fmt.Errorf("gostatix: portable snapshot of kind %d isn't a %s", data[5], name)
+	}
+	return bytes.NewReader(data[portableHeaderSize:]), nil
+}
+
+// checkPortableEnd returns an error if _stream_ has bytes left after the body
+func checkPortableEnd(stream *bytes.Reader) error {
+	if stream.Len() != 0 {
+		return fmt.Errorf("gostatix: invalid portable snapshot, %d trailing bytes", stream.Len())
+	}
+	return nil
+}
+
+// ExportPortable returns the BloomFilter in the portable binary layout, which can be read
+// by Import and by other languages. The bitset of a Redis backed filter is streamed from
+// Redis.
+func (bloomFilter *BloomFilter) ExportPortable() ([]byte, error) {
+	size, numHashes, words, err := bloomFilter.bitSetWords()
+	if err != nil {
+		return nil, err
+	}
+	numBytes := (size + 7) / 8
+	buf := newPortableBuffer(portableBloomFilter, 17+numBytes)
+	binary.Write(buf, binary.BigEndian, size)
+	binary.Write(buf, binary.BigEndian, numHashes&(1<<hashingShift-1))
+	buf.WriteByte(byte(numHashes >> hashingShift))
+	bitBytes := make([]byte, 0, len(words)*wordBytes)
+	for _, word := range words {
+		bitBytes = binary.LittleEndian.AppendUint64(bitBytes, word)
+	}
+	for uint64(len(bitBytes)) < numBytes {
+		bitBytes = append(bitBytes, 0)
+	}
+	buf.Write(bitBytes[:numBytes])
+	return buf.Bytes(), nil
+}
+
+// bitSetWords returns the size of the BloomFilter, its number of hashes with the hashing
+// scheme in the top byte and the words of its bitset, read from the stream written by
+// WriteTo so that the bitset of a Redis backed filter is streamed from Redis
+func (bloomFilter *BloomFilter) bitSetWords() (uint64, uint64, []uint64, error) {
+	var stream bytes.Buffer
+	_, err := bloomFilter.WriteTo(&stream)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	// the stream written by WriteTo holds the size, the number of hashes with the hashing
+	// scheme in the top byte, the size of the bitset and its length followed by its words
+	var header [4]uint64
+	err = binary.Read(&stream, binary.BigEndian, &header)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	words, err := readUint64s(&stream, wordsFor(header[3]))
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return header[0], header[1], words, nil
+}
+
+// importPortable reads the portable snapshot _data_ into the BloomFilter, through ReadFrom
+// so that the bitset of a Redis backed filter is replaced as well
+func (bloomFilter *BloomFilter) importPortable(data []byte) error {
+	stream, err := readPortableHeader(data, portableBloomFilter, "bloom filter")
+	if err != nil {
+		return err
+	}
+	var size, numHashes uint64
+	err = binary.Read(stream, binary.BigEndian, &size)
+	if err != nil {
+		return err
+	}
+	err = binary.Read(stream, binary.BigEndian, &numHashes)
+	if err != nil {
+		return err
+	}
+	hashing, err := stream.ReadByte()
+	if err != nil {
+		return err
+	}
+	err = checkBloomFilterParams(size, numHashes)
+	if err != nil {
+		return err
+	}
+	bitBytes, err := readBytes(stream, (size+7)/8)
+	if err != nil {
+		return err
+	}
+	err = checkPortableEnd(stream)
+	if err != nil {
+		return err
+	}
+	if size%8 != 0 {
+		// the bits past the size are ignored
+		bitBytes[len(bitBytes)-1] &= byte(1)<<(size%8) - 1
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, []uint64{size, numHashes | uint64(hashing)<<hashingShift, size, size})
+	for i := uint64(0); i < wordsFor(size); i++ {
+		var word [wordBytes]byte
+		copy(word[:], bitBytes[i*uint64(wordBytes):])
+		binary.Write(&buf, binary.BigEndian, binary.LittleEndian.Uint64(word[:]))
+	}
+	_, err = bloomFilter.ReadFrom(&buf)
+	return err
+}
+
+// ExportPortable returns the CountMinSketch in the portable binary layout, which can be
+// read by Import and by other languages
+func (cms *CountMinSketch) ExportPortable() ([]byte, error) {
+	cms.lock.RLock()
+	defer cms.lock.RUnlock()
+
+	buf := newPortableBuffer(portableCountMinSketch, 24+8*uint64(cms.rows)*uint64(cms.columns))
+	binary.Write(buf, binary.BigEndian, []uint64{uint64(cms.rows), uint64(cms.columns), cms.allSum})
+	for _, row := range cms.matrix {
+		binary.Write(buf, binary.BigEndian, row)
+	}
+	return buf.Bytes(), nil
+}
+
+// importPortable reads the portable snapshot _data_ into the CountMinSketch
+func (cms *CountMinSketch) importPortable(data []byte) error {
+	stream, err := readPortableHeader(data, portableCountMinSketch, "count-min sketch")
+	if err != nil {
+		return err
+	}
+	header, err := readUint64s(stream, 3)
+	if err != nil {
+		return err
+	}
+	rows, columns := header[0], header[1]
+	err = checkCountMinSketchParams(rows, columns)
+	if err != nil {
+		return err
+	}
+	matrix := make([][]uint64, 0, rows)
+	for r := uint64(0); r < rows; r++ {
+		row, err := readUint64s(stream, columns)
+		if err != nil {
+			return err
+		}
+		matrix = append(matrix, row)
+	}
+	err = checkPortableEnd(stream)
+	if err != nil {
+		return err
+	}
+	cms.lock.Lock()
+	defer cms.lock.Unlock()
+	cms.updated.touch()
+
+	cms.rows = uint(rows)
+	cms.columns = uint(columns)
+	cms.allSum = header[2]
+	cms.matrix = matrix
+	return nil
+}
+
+// ExportPortable returns the HyperLogLog in the portable binary layout, which can be read
+// by Import and by other languages. Only the hyperloglogs of the LegacyEstimator can be
+// exported, the layout doesn't hold the estimator.
+func (h *HyperLogLog) ExportPortable() ([]byte, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if h.estimator != LegacyEstimator {
+		return nil, fmt.Errorf("gostatix: portable snapshots can't hold hyperloglogs of the %v estimator", h.estimator)
+	}
+
+	buf := newPortableBuffer(portableHyperLogLog, 8+h.numRegisters)
+	binary.Write(buf, binary.BigEndian, h.numRegisters)
+	buf.Write(h.registers)
+	return buf.Bytes(), nil
+}
+
+// importPortable reads the portable snapshot _data_ into the HyperLogLog. The number of
+// bytes per hash and the correction bias are derived from the number of registers.
+func (h *HyperLogLog) importPortable(data []byte) error {
+	stream, err := readPortableHeader(data, portableHyperLogLog, "hyperloglog")
+	if err != nil {
+		return err
+	}
+	var numRegisters uint64
+	err = binary.Read(stream, binary.BigEndian, &numRegisters)
+	if err != nil {
+		return err
+	}
+	err = checkHyperLogLogParams(numRegisters, uint64(bits.TrailingZeros64(numRegisters)))
+	if err != nil {
+		return err
+	}
+	registers, err := readBytes(stream, numRegisters)
+	if err != nil {
+		return err
+	}
+	err = checkPortableEnd(stream)
+	if err != nil {
+		return err
+	}
+	abstractLog, err := makeAbstractHyperLogLog(numRegisters, LegacyEstimator)
+	if err != nil {
+		return err
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.updated.touch()
+
+	h.numRegisters = abstractLog.numRegisters
+	h.numBytesPerHash = abstractLog.numBytesPerHash
+	h.correctionBias = abstractLog.correctionBias
+	h.estimator = abstractLog.estimator
+	h.registers = registers
+	return nil
+}
@@ -0,0 +1,115 @@
+package gostatix
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestBloomFilterPortable(t *testing.T) {
+	initMockRedis()
+	filter, _ := NewRedisBloomFilterWithParameters(1000, 0.01)
+	for i := 0; i < 100; i++ {
+		filter.InsertString(strconv.Itoa(i))
+	}
+	data, err := filter.ExportPortable()
+	if err != nil {
+		t.Fatalf("portable export shouldn't error out, error: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte("GSTX\x01\x01\x00\x00")) {
+		t.Errorf("portable snapshot should start with the header of a bloom filter, got %v", data[:8])
+	}
+	if len(data) != 8+17+int(filter.GetCap()+7)/8 {
+		t.Errorf("portable snapshot of %d bits has an unexpected length %d", filter.GetCap(), len(data))
+	}
+	imported, _ := NewMemBloomFilterWithParameters(10, 0.1)
+	err = imported.Import(data)
+	if err != nil {
+		t.Fatalf("portable import shouldn't error out, error: %v", err)
+	}
+	if imported.GetCap() != filter.GetCap() || imported.GetNumHashes() != filter.GetNumHashes() {
+		t.Errorf("imported filter should have the parameters of the exported one")
+	}
+	for i := 0; i < 100; i++ {
+		if !imported.LookupString(strconv.Itoa(i)) {
+			t.Errorf("%d should be found in the imported filter", i)
+		}
+	}
+	again, _ := imported.ExportPortable()
+	if !bytes.Equal(data, again) {
+		t.Errorf("portable snapshots of redis and in-memory filters should be the same")
+	}
+}
+
+func TestBloomFilterPortableLayout(t *testing.T) {
+	// a filter of 12 bits with bits 0, 9 and 11 set, as written by another language
+	data := []byte("GSTX\x01\x01\x00\x00")
+	data = append(data, 0, 0, 0, 0, 0, 0, 0, 12, 0, 0, 0, 0, 0, 0, 0, 2, 0)
+	data = append(data, 0x01, 0xfa)
+	filter, _ := NewMemBloomFilterWithParameters(10, 0.1)
+	err := filter.Import(data)
+	if err != nil {
+		t.Fatalf("portable import shouldn't error out, error: %v", err)
+	}
+	for i := uint(0); i < 12; i++ {
+		expected := i == 0 || i == 9 || i == 11
+		if has, _ := filter.filter.has(i); has != expected {
+			t.Errorf("bit %d should be %v", i, expected)
+		}
+	}
+	exported, _ := filter.ExportPortable()
+	if exported[len(exported)-1] != 0x0a {
+		t.Errorf("bits past the size should be cleared, got %x", exported[len(exported)-1])
+	}
+}
+
+func TestCountMinSketchPortable(t *testing.T) {
+	sketch, _ := NewCountMinSketch(3, 50)
+	sketch.UpdateString("foo", 3)
+	sketch.UpdateString("bar", 7)
+	data, err := sketch.ExportPortable()
+	if err != nil {
+		t.Fatalf("portable export shouldn't error out, error: %v", err)
+	}
+	if len(data) != 8+24+3*50*8 {
+		t.Errorf("portable snapshot has an unexpected length %d", len(data))
+	}
+	imported, _ := NewCountMinSketch(1, 1)
+	err = imported.Import(data)
+	if err != nil {
+		t.Fatalf("portable import shouldn't error out, error: %v", err)
+	}
+	if ok, _ := imported.Equals(sketch); !ok {
+		t.Errorf("imported sketch should be equal to the exported one")
+	}
+	if imported.Import(append(data, 0)) == nil {
+		t.Errorf("portable import should error out for trailing bytes")
+	}
+	var filter BloomFilter
+	if filter.Import(data) == nil {
+		t.Errorf("portable import of a count-min sketch in a bloom filter should error out")
+	}
+}
+
+func TestHyperLogLogPortable(t *testing.T) {
+	h, _ := NewHyperLogLog(64)
+	for i := 0; i < 100; i++ {
+		h.UpdateString(strconv.Itoa(i))
+	}
+	data, err := h.ExportPortable()
+	if err != nil {
+		t.Fatalf("portable export shouldn't error out, error: %v", err)
+	}
+	imported, _ := NewHyperLogLog(16)
+	err = imported.Import(data)
+	if err != nil {
+		t.Fatalf("portable import shouldn't error out, error: %v", err)
+	}
+	if ok, _ := imported.Equals(h); !ok {
+		t.Errorf("imported hyperloglog should be equal to the exported one")
+	}
+	data[4] = 2
+	if imported.Import(data) == nil {
+		t.Errorf("portable import should error out for an unknown version")
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Implements a processor feeding a stream of elements to several data structures at once,
+e.g. the bloom filter, the count-min sketch, the hyperloglog and the top-k of a dataset.
+*/
+package gostatix
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Processor updates all the data structures added to it with each element passed to
+// Process. The in-memory Bloom filters, Count-Min Sketches, HyperLogLogs and Top-Ks all
+// derive their positions from the same hashes of an element, so the Processor hashes each
+// element once for all of them. The Redis backed ones hash the elements themselves.
+// _batchSize_ is the number of elements buffered before they're written, each data
+// structure taking its lock once per batch and the Redis backed ones writing a batch in a
+// single pipeline. With a _batchSize_ of 1 each element is written by Process.
+type Processor struct {
+	blooms     []*BloomFilter
+	sketches   []*CountMinSketch
+	hlls       []*HyperLogLog
+	topKs      []*TopK
+	writers    []batchWriter
+	redisTopKs []*TopKRedis
+	batchSize  int
+	batch      []asyncWrite
+	data       [][]byte
+	hashes     [][4]uint64
+	lock       sync.Mutex
+}
+
+// NewProcessor creates a Processor without data structures buffering _batchSize_ elements
+func NewProcessor(batchSize int) (*Processor, error) {
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("gostatix: batch size of processor should be greater than 0")
+	}
+	return &Processor{batchSize: batchSize, batch: make([]asyncWrite, 0, batchSize)}, nil
+}
+
+// Add registers _structure_ to be updated with the processed elements. It can be a
+// *BloomFilter, a *CountMinSketch, a *HyperLogLog or a *TopK, or the Redis backed
+// *CountMinSketchRedis, *HyperLogLogRedis or *TopKRedis. The elements are counted once
+// by the Count-Min Sketches and the Top-Ks. The elements buffered before are flushed first
+// so that _structure_ is only updated with the elements processed after it was added.
+func (p *Processor) Add(structure interface{}) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err := p.flush(); err != nil {
+		return err
+	}
+	switch s := structure.(type) {
+	case *BloomFilter:
+		p.blooms = append(p.blooms, s)
+	case *CountMinSketch:
+		p.sketches = append(p.sketches, s)
+	case *HyperLogLog:
+		p.hlls = append(p.hlls, s)
+	case *TopK:
+		p.topKs = append(p.topKs, s)
+	case *CountMinSketchRedis:
+		p.writers = append(p.writers, s)
+	case *HyperLogLogRedis:
+		p.writers = append(p.writers, s)
+	case *TopKRedis:
+		p.redisTopKs = append(p.redisTopKs, s)
+	default:
+		return fmt.Errorf("gostatix: processor doesn't support data structures of type %T", structure)
+	}
+	return nil
+}
+
+// Process updates all the data structures of the Processor with _data_, once the batch
+// holding it is full. _data_ is copied, so it can be reused by the caller.
+func (p *Processor) Process(data []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.batch = append(p.batch, asyncWrite{append([]byte(nil), data...), 1})
+	if len(p.batch) < p.batchSize {
+		return nil
+	}
+	return p.flush()
+}
+
+// ProcessString updates all the data structures of the Processor with _data_ (string)
+func (p *Processor) ProcessString(data string) error {
+	return p.Process([]byte(data))
+}
+
+// Flush writes the buffered elements to all the data structures of the Processor
+func (p *Processor) Flush() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.flush()
+}
+
+// Close flushes the buffered elements. The data structures aren't closed.
+func (p *Processor) Close() error {
+	return p.Flush()
+}
+
+// flush writes the batch to all the data structures and empties it. The batch is emptied
+// even if a write fails, the first error being returned after all the data structures
+// are written.
+func (p *Processor) flush() error {
+	if len(p.batch) == 0 {
+		return nil
+	}
+	defer func() {
+		p.batch = p.batch[:0]
+	}()
+	p.data = p.data[:0]
+	p.hashes = p.hashes[:0]
+	if len(p.blooms)+len(p.sketches)+len(p.hlls)+len(p.topKs) > 0 {
+		for _, write := range p.batch {
+			p.data = append(p.data, write.data)
+			p.hashes = append(p.hashes, getHashes(write.data))
+		}
+	}
+	var errs []error
+	for _, bloomFilter := range p.blooms {
+		if err := bloomFilter.insertHashes(p.data, p.hashes); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, cms := range p.sketches {
+		cms.updateHashes(p.hashes, 1)
+	}
+	for _, h := range p.hlls {
+		h.updateHashes(p.hashes)
+	}
+	for _, t := range p.topKs {
+		t.insertHashes(p.data, p.hashes)
+	}
+	for _, writer := range p.writers {
+		if err := writer.writeBatch(p.batch); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, t := range p.redisTopKs {
+		for _, write := range p.batch {
+			if err := t.Insert(write.data, write.count); err != nil {
+				errs = append(errs, err)
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("gostatix: error while processing %d elements, error: %v", len(p.batch), errs[0])
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package gostatix
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestProcessor(t *testing.T) {
+	initMockRedis()
+	processor, err := NewProcessor(16)
+	if err != nil {
+		t.Fatalf("processor creation shouldn't error out, error: %v", err)
+	}
+	bloomFilter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	cms, _ := NewCountMinSketch(4, 200)
+	hll, _ := NewHyperLogLog(64)
+	topK, _ := NewTopK(5, 0.001, 0.99)
+	redisCMS, _ := NewCountMinSketchRedis(4, 200)
+	redisHLL, _ := NewHyperLogLogRedis(64)
+	redisTopK, _ := NewTopKRedis(5, 0.001, 0.99)
+	for _, structure := range []interface{}{bloomFilter, cms, hll, topK, redisCMS, redisHLL, redisTopK} {
+		if err := processor.Add(structure); err != nil {
+			t.Fatalf("%T should be added to the processor, error: %v", structure, err)
+		}
+	}
+	if err := processor.Add(&CuckooFilter{}); err == nil {
+		t.Errorf("unsupported data structure shouldn't be added")
+	}
+
+	expectedBloom, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	expectedCMS, _ := NewCountMinSketch(4, 200)
+	expectedHLL, _ := NewHyperLogLog(64)
+	expectedTopK, _ := NewTopK(5, 0.001, 0.99)
+	expectedRedisCMS, _ := NewCountMinSketchRedis(4, 200)
+	expectedRedisHLL, _ := NewHyperLogLogRedis(64)
+	for i := 0; i < 500; i++ {
+		element := strconv.Itoa(i % (1 + i%37))
+		if err := processor.ProcessString(element); err != nil {
+			t.Fatalf("process shouldn't error out, error: %v", err)
+		}
+		expectedBloom.InsertString(element)
+		expectedCMS.UpdateString(element, 1)
+		expectedHLL.UpdateString(element)
+		expectedTopK.InsertString(element, 1)
+		expectedRedisCMS.UpdateString(element, 1)
+		expectedRedisHLL.UpdateString(element)
+	}
+	if cms.TotalCount() != 496 {
+		t.Errorf("the last 4 elements should be buffered, total count %d", cms.TotalCount())
+	}
+	if err := processor.Close(); err != nil {
+		t.Fatalf("close shouldn't error out, error: %v", err)
+	}
+
+	if ok, _ := bloomFilter.Equals(expectedBloom); !ok {
+		t.Errorf("bloom filter should hold the processed elements")
+	}
+	if ok, _ := cms.Equals(expectedCMS); !ok {
+		t.Errorf("count-min sketch should count the processed elements")
+	}
+	if ok, _ := hll.Equals(expectedHLL); !ok {
+		t.Errorf("hyperloglog should count the processed elements")
+	}
+	if !reflect.DeepEqual(topK.Values(), expectedTopK.Values()) {
+		t.Errorf("top-k should hold %v, got %v", expectedTopK.Values(), topK.Values())
+	}
+	if ok, err := redisCMS.Equals(expectedRedisCMS); !ok || err != nil {
+		t.Errorf("redis count-min sketch should count the processed elements, error: %v", err)
+	}
+	if ok, err := redisHLL.Equals(expectedRedisHLL); !ok || err != nil {
+		t.Errorf("redis hyperloglog should count the processed elements, error: %v", err)
+	}
+	if values, _ := redisTopK.Values(); !reflect.DeepEqual(values, expectedTopK.Values()) {
+		t.Errorf("redis top-k should hold %v, got %v", expectedTopK.Values(), values)
+	}
+
+	if _, err := NewProcessor(0); err == nil {
+		t.Errorf("processor without a batch size should error out")
+	}
+}
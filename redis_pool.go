@@ -0,0 +1,29 @@
+package gostatix
+
+import "sync"
+
+// keysPool holds the slices of KEYS passed to the Lua scripts by the hot paths of the Redis
+// backed data structures, so that high-throughput ingestion reuses them instead of
+// allocating a slice per call
+var keysPool = sync.Pool{
+	New: func() interface{} {
+		keys := make([]string, 0, 32)
+		return &keys
+	},
+}
+
+// getKeys returns an empty slice of KEYS from the pool, to give back with putKeys once the
+// script has run
+func getKeys() *[]string {
+	return keysPool.Get().(*[]string)
+}
+
+// putKeys clears _keys_ so that the strings it holds can be collected and puts it back in
+// the pool
+func putKeys(keys *[]string) {
+	for i := range *keys {
+		(*keys)[i] = ""
+	}
+	*keys = (*keys)[:0]
+	keysPool.Put(keys)
+}
@@ -0,0 +1,529 @@
+/*
+Implements the chunk format of RedisBloom's BF.SCANDUMP and BF.LOADCHUNK commands, and of
+CF.SCANDUMP and CF.LOADCHUNK, so that Bloom and cuckoo filters can be moved between
+RedisBloom and gostatix.
+
+RedisBloom hashes the elements differently from gostatix, so the filters read from or
+written to RedisBloom use its hashing scheme (MurmurHash64A with double hashing for Bloom
+filters, RedisBloomCuckooHashing for cuckoo filters). Filters created by the other gostatix
+constructors can't be converted, their elements would have to be inserted again.
+
+RedisBloom stores 8-bit fingerprints in its cuckoo filters while gostatix stores decimal
+digits, so a RedisBloom fingerprint f is stored as the 3 digits 100 + f.
+*/
+package gostatix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/kwertop/gostatix/internal/util"
+)
+
+// options of a RedisBloom filter chain
+const (
+	redisBloomOptNoRound = 1
+	redisBloomOptForce64 = 4
+)
+
+const (
+	// redisBloomHashSeed is the seed of the first hash of an element in RedisBloom
+	redisBloomHashSeed = 0xc6a4a7935bd1e995
+	// redisBloomGrowth is the default growth factor of RedisBloom scalable filters
+	redisBloomGrowth = 2
+	// redisBloomHeaderSize is the size of the header of a filter chain, followed by
+	// the headers of its links
+	redisBloomHeaderSize = 20
+	// redisBloomLinkSize is the size of the header of a link of a filter chain
+	redisBloomLinkSize = 53
+	// scanDumpChunkSize is the maximum number of bytes of bits in a chunk
+	scanDumpChunkSize = 10 << 20
+)
+
+const (
+	// redisBloomCuckooHeaderSize is the size of the header of a RedisBloom cuckoo filter,
+	// the struct of 4 uint64 and 3 uint16 padded to 8 bytes
+	redisBloomCuckooHeaderSize = 40
+	// redisBloomFingerPrintOffset is added to the fingerprints of RedisBloom, 1 to 255, so
+	// that they have 3 decimal digits
+	redisBloomFingerPrintOffset = 100
+	// redisBloomFingerPrints is the number of fingerprints of RedisBloom
+	redisBloomFingerPrints = 255
+	// redisBloomFingerPrintLength is the fingerprint length of the filters using
+	// RedisBloomCuckooHashing
+	redisBloomFingerPrintLength = 3
+	// redisBloomAltHashFactor is multiplied by a fingerprint to get its alternate index
+	redisBloomAltHashFactor = 0x5bd1e995
+	// redisBloomMaxBucketSize is the maximum bucket size of a RedisBloom cuckoo filter
+	redisBloomMaxBucketSize = 255
+	// redisBloomCuckooExpansion is the default expansion of RedisBloom cuckoo filters
+	redisBloomCuckooExpansion = 1
+)
+
+// ScanDumpChunk is a chunk of a Bloom or cuckoo filter in the format of RedisBloom's
+// BF.SCANDUMP or CF.SCANDUMP.
+// _Iterator_ is the iterator returned by SCANDUMP along with the chunk, which has to
+// be passed back to LOADCHUNK. The first chunk, with iterator 1, holds the header of
+// the filter while the following ones hold its bits or its buckets.
+// _Data_ is the content of the chunk
+type ScanDumpChunk struct {
+	Iterator int64
+	Data     []byte
+}
+
+// redisBloomLink is the header of a link of a RedisBloom filter chain
+type redisBloomLink struct {
+	Bytes   uint64
+	Bits    uint64
+	Size    uint64
+	Error   float64
+	Bpe     float64
+	Hashes  uint32
+	Entries uint64
+	N2      uint8
+}
+
+// redisBloomCuckooHeader is the header of a RedisBloom cuckoo filter
+type redisBloomCuckooHeader struct {
+	NumItems      uint64
+	NumBuckets    uint64
+	NumDeletes    uint64
+	NumFilters    uint64
+	BucketSize    uint16
+	MaxIterations uint16
+	Expansion     uint16
+}
+
+// redisBloomCuckooHashing is the CuckooHashing of the cuckoo filters of RedisBloom
+type redisBloomCuckooHashing struct{}
+
+// NewMemBloomFilterForRedisBloom creates and returns a new in-memory BloomFilter sized
+// and hashing the elements like RedisBloom's BF.RESERVE, so that it can be written to
+// RedisBloom with ScanDump or SaveToRedisBloom.
+// _numItems_ is the number of items for which the bloom filter has to be checked for validation
+// _errorRate_ is the acceptable false positive error rate
+func NewMemBloomFilterForRedisBloom(numItems uint, errorRate float64) (*BloomFilter, error) {
+	err := BloomFilterParams{numItems, errorRate}.Validate()
+	if err != nil {
+		return nil, err
+	}
+	bpe := -math.Log(errorRate) / (math.Ln2 * math.Ln2)
+	numWords := (uint64(float64(numItems)*bpe) + uint64(wordSize) - 1) / uint64(wordSize)
+	size := util.Max(uint(numWords), 1) * uint(wordSize)
+	numHashes := uint(math.Ceil(math.Ln2 * bpe))
+	filter, err := NewBloomFilterWithBitSet(size, numHashes, newBitSetMem(size), "")
+	if err != nil {
+		return nil, err
+	}
+	filter.hashing = RedisBloomHashing
+	return filter, nil
+}
+
+// NewMemBloomFilterFromScanDump creates and returns a new in-memory BloomFilter from the
+// _chunks_ returned by RedisBloom's BF.SCANDUMP. Only filters of a single link are
+// supported, i.e. filters created with NONSCALING or which haven't scaled yet.
+func NewMemBloomFilterFromScanDump(chunks []ScanDumpChunk) (*BloomFilter, error) {
+	if len(chunks) == 0 || chunks[0].Iterator != 1 {
+		return nil, fmt.Errorf("gostatix: invalid scandump, the first chunk should be the header with iterator 1")
+	}
+	link, err := decodeRedisBloomHeader(chunks[0].Data)
+	if err != nil {
+		return nil, err
+	}
+	size := uint(link.Bits)
+	if link.N2 > 0 {
+		size = 1 << link.N2
+	}
+	err = checkBloomFilterParams(uint64(size), uint64(link.Hashes))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(size) > link.Bytes*8 {
+		return nil, fmt.Errorf("gostatix: invalid scandump, %d bits don't fit in %d bytes", size, link.Bytes)
+	}
+	data := make([]byte, (uint64(size)+uint64(wordSize)-1)/uint64(wordSize)*uint64(wordBytes))
+	for _, chunk := range chunks[1:] {
+		start := chunk.Iterator - int64(len(chunk.Data)) - 1
+		if start < 0 || uint64(start)+uint64(len(chunk.Data)) > link.Bytes {
+			return nil, fmt.Errorf("gostatix: invalid scandump, chunk with iterator %d is out of the %d bytes of the filter", chunk.Iterator, link.Bytes)
+		}
+		if start < int64(len(data)) {
+			copy(data[start:], chunk.Data)
+		}
+	}
+	words := make([]uint64, len(data)/wordBytes)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(data[i*wordBytes:])
+	}
+	bitSet := newBitSetMem(size)
+	err = bitSet.OrWords(words, 0)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := NewBloomFilterWithBitSet(size, uint(link.Hashes), bitSet, "")
+	if err != nil {
+		return nil, err
+	}
+	filter.hashing = RedisBloomHashing
+	return filter, nil
+}
+
+// ScanDump returns the BloomFilter as chunks in the format of RedisBloom's BF.SCANDUMP,
+// which can be loaded in RedisBloom with BF.LOADCHUNK in order. The filter should hash
+// the elements like RedisBloom, i.e. it should be created by NewMemBloomFilterForRedisBloom
+// or read from RedisBloom. The capacity and error rate in the header are derived from the
+// size and the number of hashes of the filter.
+func (bloomFilter *BloomFilter) ScanDump() ([]ScanDumpChunk, error) {
+	if bloomFilter.hashing != RedisBloomHashing {
+		return nil, fmt.Errorf("gostatix: only bloom filters using redisbloom hashing can be written to redisbloom, filter uses %v hashing", bloomFilter.hashing)
+	}
+	if bloomFilter.needsLock() {
+		bloomFilter.lock.RLock()
+		defer bloomFilter.lock.RUnlock()
+	}
+	var buf bytes.Buffer
+	_, err := bloomFilter.filter.writeTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+	buf.Next(wordBytes)
+	set, _, err := readSet(&buf)
+	if err != nil {
+		return nil, err
+	}
+	words := set.Bytes()
+	data := make([]byte, len(words)*wordBytes)
+	for i, word := range words {
+		binary.LittleEndian.PutUint64(data[i*wordBytes:], word)
+	}
+	numBitsSet, err := bloomFilter.filter.bitCount()
+	if err != nil {
+		return nil, err
+	}
+	size := float64(bloomFilter.size)
+	numHashes := float64(bloomFilter.numHashes)
+	bpe := numHashes / math.Ln2
+	link := redisBloomLink{
+		Bytes:   uint64(len(data)),
+		Bits:    uint64(bloomFilter.size),
+		Error:   math.Exp(-bpe * math.Ln2 * math.Ln2),
+		Bpe:     bpe,
+		Hashes:  uint32(bloomFilter.numHashes),
+		Entries: uint64(util.Max(uint(size/bpe), 1)),
+	}
+	if numBitsSet < bloomFilter.size {
+		link.Size = uint64(math.Round(-size / numHashes * math.Log(1-float64(numBitsSet)/size)))
+	} else {
+		link.Size = link.Entries
+	}
+	return splitScanDump(encodeRedisBloomHeader(link), data), nil
+}
+
+// NewMemBloomFilterFromRedisBloom creates and returns a new in-memory BloomFilter from the
+// RedisBloom filter at _key_, read with BF.SCANDUMP using the gostatix Redis client
+func NewMemBloomFilterFromRedisBloom(key string) (*BloomFilter, error) {
+	chunks, err := scanDumpRedisBloom("BF.SCANDUMP", key)
+	if err != nil {
+		return nil, err
+	}
+	return NewMemBloomFilterFromScanDump(chunks)
+}
+
+// SaveToRedisBloom writes the BloomFilter to a new RedisBloom filter at _key_ with
+// BF.LOADCHUNK using the gostatix Redis client. The filter should use RedisBloom hashing
+// (see ScanDump) and _key_ shouldn't exist.
+func (bloomFilter *BloomFilter) SaveToRedisBloom(key string) error {
+	chunks, err := bloomFilter.ScanDump()
+	if err != nil {
+		return err
+	}
+	return loadChunksToRedisBloom("BF.LOADCHUNK", key, chunks)
+}
+
+// NewCuckooFilterForRedisBloom creates and returns a new in-memory CuckooFilter sized and
+// hashing the elements like RedisBloom's CF.RESERVE, so that it can be written to RedisBloom
+// with ScanDump or SaveToRedisBloom. Its number of buckets is the power of two following
+// _capacity_ / _bucketSize_ and its fingerprints have 3 digits (see RedisBloomCuckooHashing).
+// _capacity_ is the number of elements the filter should hold, at least twice _bucketSize_
+// _bucketSize_ is the number of fingerprints of each bucket, between 1 and 255 (2 in RedisBloom by default)
+// _maxIterations_ is the number of kicks of an insert before the filter is full (20 in RedisBloom by default)
+func NewCuckooFilterForRedisBloom(capacity, bucketSize, maxIterations uint64) (*CuckooFilter, error) {
+	if bucketSize == 0 || bucketSize > redisBloomMaxBucketSize {
+		return nil, fmt.Errorf("gostatix: redisbloom cuckoo filter bucket size %d should be between 1 and %d", bucketSize, redisBloomMaxBucketSize)
+	}
+	if capacity < 2*bucketSize {
+		return nil, fmt.Errorf("gostatix: redisbloom cuckoo filter capacity %d should be at least twice the bucket size %d", capacity, bucketSize)
+	}
+	if maxIterations > math.MaxUint16 {
+		return nil, fmt.Errorf("gostatix: redisbloom cuckoo filter max iterations %d should be at most %d", maxIterations, math.MaxUint16)
+	}
+	size := uint64(1) << bits.Len64(capacity/bucketSize-1)
+	return newRedisBloomCuckooFilter(size, bucketSize, maxIterations)
+}
+
+// NewCuckooFilterFromScanDump creates and returns a new in-memory CuckooFilter from the
+// _chunks_ returned by RedisBloom's CF.SCANDUMP. Only filters of a single sub-filter are
+// supported, i.e. filters which haven't expanded yet. The deleted entries of the filter
+// aren't kept, its length being the number of fingerprints of its buckets.
+func NewCuckooFilterFromScanDump(chunks []ScanDumpChunk) (*CuckooFilter, error) {
+	if len(chunks) == 0 || chunks[0].Iterator != 1 {
+		return nil, fmt.Errorf("gostatix: invalid scandump, the first chunk should be the header with iterator 1")
+	}
+	header, err := decodeRedisBloomCuckooHeader(chunks[0].Data)
+	if err != nil {
+		return nil, err
+	}
+	cuckooFilter, err := newRedisBloomCuckooFilter(header.NumBuckets, uint64(header.BucketSize), uint64(header.MaxIterations))
+	if err != nil {
+		return nil, err
+	}
+	numBytes := header.NumBuckets * uint64(header.BucketSize)
+	bucketSize := uint64(header.BucketSize)
+	for _, chunk := range chunks[1:] {
+		start := chunk.Iterator - int64(len(chunk.Data)) - 1
+		if start < 0 || uint64(start)+uint64(len(chunk.Data)) > numBytes {
+			return nil, fmt.Errorf("gostatix: invalid scandump, chunk with iterator %d is out of the %d bytes of the filter", chunk.Iterator, numBytes)
+		}
+		for i, fingerPrint := range chunk.Data {
+			pos := uint64(start) + uint64(i)
+			if fingerPrint != 0 {
+				cuckooFilter.buckets.set(pos/bucketSize, pos%bucketSize, redisBloomFingerPrintOffset+uint64(fingerPrint))
+			}
+		}
+	}
+	for i := uint64(0); i < cuckooFilter.size; i++ {
+		cuckooFilter.length += cuckooFilter.buckets.getLength(i)
+	}
+	return cuckooFilter, nil
+}
+
+// ScanDump returns the CuckooFilter as chunks in the format of RedisBloom's CF.SCANDUMP,
+// which can be loaded in RedisBloom with CF.LOADCHUNK in order. The filter should be created
+// by NewCuckooFilterForRedisBloom or read from RedisBloom. The payloads stored by Put aren't
+// written.
+func (cuckooFilter *CuckooFilter) ScanDump() ([]ScanDumpChunk, error) {
+	cuckooFilter.lock.RLock()
+	defer cuckooFilter.lock.RUnlock()
+
+	if cuckooFilter.Hashing() != RedisBloomCuckooHashing || cuckooFilter.fingerPrintLength != redisBloomFingerPrintLength {
+		return nil, fmt.Errorf("gostatix: only cuckoo filters using redisbloom hashing can be written to redisbloom, filter uses %s hashing", cuckooFilter.Hashing().Name())
+	}
+	if cuckooFilter.Candidates() != 2 || cuckooFilter.size&(cuckooFilter.size-1) != 0 || cuckooFilter.bucketSize > redisBloomMaxBucketSize {
+		return nil, fmt.Errorf("gostatix: cuckoo filter of %d buckets of size %d with %d candidates can't be written to redisbloom", cuckooFilter.size, cuckooFilter.bucketSize, cuckooFilter.Candidates())
+	}
+	data := make([]byte, cuckooFilter.size*cuckooFilter.bucketSize)
+	for i := uint64(0); i < cuckooFilter.size; i++ {
+		for slot := uint64(0); slot < cuckooFilter.bucketSize; slot++ {
+			if fingerPrint := cuckooFilter.buckets.at(i, slot); fingerPrint != 0 {
+				data[i*cuckooFilter.bucketSize+slot] = byte(fingerPrint - redisBloomFingerPrintOffset)
+			}
+		}
+	}
+	header := redisBloomCuckooHeader{
+		NumItems:      cuckooFilter.length,
+		NumBuckets:    cuckooFilter.size,
+		NumFilters:    1,
+		BucketSize:    uint16(cuckooFilter.bucketSize),
+		MaxIterations: uint16(minUint64(cuckooFilter.retries, math.MaxUint16)),
+		Expansion:     redisBloomCuckooExpansion,
+	}
+	return splitScanDump(encodeRedisBloomCuckooHeader(header), data), nil
+}
+
+// NewCuckooFilterFromRedisBloom creates and returns a new in-memory CuckooFilter from the
+// RedisBloom cuckoo filter at _key_, read with CF.SCANDUMP using the gostatix Redis client
+func NewCuckooFilterFromRedisBloom(key string) (*CuckooFilter, error) {
+	chunks, err := scanDumpRedisBloom("CF.SCANDUMP", key)
+	if err != nil {
+		return nil, err
+	}
+	return NewCuckooFilterFromScanDump(chunks)
+}
+
+// SaveToRedisBloom writes the CuckooFilter to a new RedisBloom cuckoo filter at _key_ with
+// CF.LOADCHUNK using the gostatix Redis client. The filter should use RedisBloom hashing
+// (see ScanDump) and _key_ shouldn't exist.
+func (cuckooFilter *CuckooFilter) SaveToRedisBloom(key string) error {
+	chunks, err := cuckooFilter.ScanDump()
+	if err != nil {
+		return err
+	}
+	return loadChunksToRedisBloom("CF.LOADCHUNK", key, chunks)
+}
+
+// newRedisBloomCuckooFilter creates an empty cuckoo filter of _size_ buckets using
+// RedisBloomCuckooHashing. _size_ should be a power of two, so that the alternate index
+// is the xor of RedisBloom.
+func newRedisBloomCuckooFilter(size, bucketSize, maxIterations uint64) (*CuckooFilter, error) {
+	if size == 0 || size&(size-1) != 0 {
+		return nil, fmt.Errorf("gostatix: redisbloom cuckoo filter number of buckets %d should be a power of two", size)
+	}
+	cuckooFilter, err := NewCuckooFilterWithRetries(size, bucketSize, redisBloomFingerPrintLength, maxIterations)
+	if err != nil {
+		return nil, err
+	}
+	cuckooFilter.hashing = RedisBloomCuckooHashing
+	return cuckooFilter, nil
+}
+
+// scanDumpRedisBloom reads the RedisBloom filter at _key_ with _command_, BF.SCANDUMP or
+// CF.SCANDUMP, and returns its chunks
+func scanDumpRedisBloom(command, key string) ([]ScanDumpChunk, error) {
+	ctx := context.Background()
+	var chunks []ScanDumpChunk
+	iterator := int64(0)
+	for {
+		result, err := getRedisClient().Do(ctx, command, key, iterator).Slice()
+		if err != nil {
+			return nil, fmt.Errorf("gostatix: error while dumping redisbloom filter %s, error: %v", key, err)
+		}
+		if len(result) != 2 {
+			return nil, fmt.Errorf("gostatix: unexpected reply of %s with %d elements", command, len(result))
+		}
+		next, ok := result[0].(int64)
+		if !ok {
+			return nil, fmt.Errorf("gostatix: unexpected iterator %v in reply of %s", result[0], command)
+		}
+		if next == 0 {
+			break
+		}
+		data, ok := result[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("gostatix: unexpected data of type %T in reply of %s", result[1], command)
+		}
+		chunks = append(chunks, ScanDumpChunk{next, []byte(data)})
+		iterator = next
+	}
+	return chunks, nil
+}
+
+// loadChunksToRedisBloom writes the _chunks_ to the RedisBloom filter at _key_ with
+// _command_, BF.LOADCHUNK or CF.LOADCHUNK
+func loadChunksToRedisBloom(command, key string, chunks []ScanDumpChunk) error {
+	ctx := context.Background()
+	for _, chunk := range chunks {
+		err := getRedisClient().Do(ctx, command, key, chunk.Iterator, chunk.Data).Err()
+		if err != nil {
+			return fmt.Errorf("gostatix: error while loading chunk %d of redisbloom filter %s, error: %v", chunk.Iterator, key, err)
+		}
+	}
+	return nil
+}
+
+// splitScanDump returns the chunks of a filter made of its _header_ followed by its _data_
+// split in chunks of at most scanDumpChunkSize bytes, whose iterators are 1 plus the
+// offset of their end
+func splitScanDump(header, data []byte) []ScanDumpChunk {
+	chunks := []ScanDumpChunk{{1, header}}
+	for start := 0; start < len(data); start += scanDumpChunkSize {
+		end := start + scanDumpChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunks = append(chunks, ScanDumpChunk{int64(end) + 1, data[start:end]})
+	}
+	return chunks
+}
+
+// decodeRedisBloomHeader decodes the header chunk of a RedisBloom filter chain of a
+// single link and returns the header of the link
+func decodeRedisBloomHeader(data []byte) (redisBloomLink, error) {
+	var link redisBloomLink
+	if len(data) < redisBloomHeaderSize {
+		return link, fmt.Errorf("gostatix: invalid scandump header of %d bytes", len(data))
+	}
+	numFilters := binary.LittleEndian.Uint32(data[8:])
+	options := binary.LittleEndian.Uint32(data[12:])
+	if numFilters != 1 {
+		return link, fmt.Errorf("gostatix: only redisbloom filters of a single link are supported, filter has %d links", numFilters)
+	}
+	if options&redisBloomOptForce64 == 0 {
+		return link, fmt.Errorf("gostatix: redisbloom filters using 32-bit hashing aren't supported")
+	}
+	if len(data) != redisBloomHeaderSize+redisBloomLinkSize {
+		return link, fmt.Errorf("gostatix: invalid scandump header of %d bytes for a single link", len(data))
+	}
+	err := binary.Read(bytes.NewReader(data[redisBloomHeaderSize:]), binary.LittleEndian, &link)
+	return link, err
+}
+
+// encodeRedisBloomHeader encodes the header chunk of a RedisBloom filter chain made of
+// the single _link_
+func encodeRedisBloomHeader(link redisBloomLink) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, link.Size)
+	binary.Write(&buf, binary.LittleEndian, uint32(1))
+	binary.Write(&buf, binary.LittleEndian, uint32(redisBloomOptNoRound|redisBloomOptForce64))
+	binary.Write(&buf, binary.LittleEndian, uint32(redisBloomGrowth))
+	binary.Write(&buf, binary.LittleEndian, link)
+	return buf.Bytes()
+}
+
+// getRedisBloomHashes returns the two hashes of _data_ computed like RedisBloom
+func getRedisBloomHashes(data []byte) [4]uint64 {
+	hash1 := murmurHash64A(data, redisBloomHashSeed)
+	return [4]uint64{hash1, murmurHash64A(data, hash1)}
+}
+
+// decodeRedisBloomCuckooHeader decodes the header chunk of a RedisBloom cuckoo filter of a
+// single sub-filter. The header may be packed, without its trailing padding.
+func decodeRedisBloomCuckooHeader(data []byte) (redisBloomCuckooHeader, error) {
+	var header redisBloomCuckooHeader
+	if len(data) != binary.Size(header) && len(data) != redisBloomCuckooHeaderSize {
+		return header, fmt.Errorf("gostatix: invalid cuckoo scandump header of %d bytes", len(data))
+	}
+	err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &header)
+	if err != nil {
+		return header, err
+	}
+	if header.NumFilters != 1 {
+		return header, fmt.Errorf("gostatix: only redisbloom cuckoo filters of a single sub-filter are supported, filter has %d", header.NumFilters)
+	}
+	if header.BucketSize == 0 || header.BucketSize > redisBloomMaxBucketSize {
+		return header, fmt.Errorf("gostatix: invalid cuckoo scandump header, bucket size %d should be between 1 and %d", header.BucketSize, redisBloomMaxBucketSize)
+	}
+	return header, nil
+}
+
+// encodeRedisBloomCuckooHeader encodes the header chunk of a RedisBloom cuckoo filter,
+// padded to redisBloomCuckooHeaderSize bytes
+func encodeRedisBloomCuckooHeader(header redisBloomCuckooHeader) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header)
+	buf.Write(make([]byte, redisBloomCuckooHeaderSize-buf.Len()))
+	return buf.Bytes()
+}
+
+// Name returns the name of the hashing
+func (redisBloomCuckooHashing) Name() string {
+	return "redisbloom"
+}
+
+// Hash returns the MurmurHash64A of _data_ with seed 0
+func (redisBloomCuckooHashing) Hash(data []byte) uint64 {
+	return murmurHash64A(data, 0)
+}
+
+// FingerPrint returns the RedisBloom fingerprint of _hash_, hash % 255 + 1, stored as the 3
+// digits 100 + fingerprint. _fingerPrintLength_ should be 3.
+func (redisBloomCuckooHashing) FingerPrint(hash, fingerPrintLength uint64) (uint64, error) {
+	if fingerPrintLength != redisBloomFingerPrintLength {
+		return 0, fmt.Errorf("gostatix: redisbloom cuckoo hashing needs a fingerprint length of %d, got %d", redisBloomFingerPrintLength, fingerPrintLength)
+	}
+	return redisBloomFingerPrintOffset + hash%redisBloomFingerPrints + 1, nil
+}
+
+// FingerPrintHash returns the hash of _fingerPrint_ xored with the index of its bucket
+// to get its other bucket, the RedisBloom fingerprint multiplied by 0x5bd1e995
+func (redisBloomCuckooHashing) FingerPrintHash(fingerPrint uint64) uint64 {
+	return (fingerPrint - redisBloomFingerPrintOffset) * redisBloomAltHashFactor
+}
+
+// CollisionRate returns the probability of the fingerprints of two elements being equal
+func (redisBloomCuckooHashing) CollisionRate(uint64) float64 {
+	return 1.0 / redisBloomFingerPrints
+}
@@ -0,0 +1,233 @@
+package gostatix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"testing"
+)
+
+func TestRedisBloomLinkSize(t *testing.T) {
+	if size := binary.Size(redisBloomLink{}); size != redisBloomLinkSize {
+		t.Errorf("size of an encoded link should be %d, got %d", redisBloomLinkSize, size)
+	}
+}
+
+func TestBloomFilterScanDump(t *testing.T) {
+	filter, err := NewMemBloomFilterForRedisBloom(1000, 0.01)
+	if err != nil {
+		t.Fatalf("filter creation shouldn't error out, error: %v", err)
+	}
+	if filter.GetCap() != 9600 || filter.GetNumHashes() != 7 {
+		t.Errorf("filter should be sized like BF.RESERVE 0.01 1000, got %d bits and %d hashes", filter.GetCap(), filter.GetNumHashes())
+	}
+	for i := 0; i < 500; i++ {
+		filter.InsertString(strconv.Itoa(i))
+	}
+	chunks, err := filter.ScanDump()
+	if err != nil {
+		t.Fatalf("scandump shouldn't error out, error: %v", err)
+	}
+	if len(chunks) != 2 || chunks[0].Iterator != 1 || chunks[1].Iterator != 1201 {
+		t.Fatalf("scandump should be a header and a chunk of 1200 bytes, got %d chunks", len(chunks))
+	}
+	link, _ := decodeRedisBloomHeader(chunks[0].Data)
+	if link.Size < 450 || link.Size > 550 {
+		t.Errorf("estimated number of items should be close to 500, got %d", link.Size)
+	}
+	loaded, err := NewMemBloomFilterFromScanDump(chunks)
+	if err != nil {
+		t.Fatalf("loading scandump shouldn't error out, error: %v", err)
+	}
+	if ok, _ := filter.Equals(loaded); !ok {
+		t.Errorf("filter loaded from scandump should be equal to the original")
+	}
+	for i := 0; i < 500; i++ {
+		if !loaded.LookupString(strconv.Itoa(i)) {
+			t.Errorf("%d should be found in the loaded filter", i)
+		}
+	}
+}
+
+func TestBloomFilterScanDumpChunks(t *testing.T) {
+	link := redisBloomLink{Bytes: 16, Bits: 128, Hashes: 2, Entries: 10}
+	header := encodeRedisBloomHeader(link)
+	chunks := []ScanDumpChunk{
+		{1, header},
+		{9, []byte{0x01, 0, 0, 0, 0, 0, 0, 0}},
+		{17, []byte{0, 0x80, 0, 0, 0, 0, 0, 0}},
+	}
+	filter, err := NewMemBloomFilterFromScanDump(chunks)
+	if err != nil {
+		t.Fatalf("loading scandump shouldn't error out, error: %v", err)
+	}
+	for _, index := range []uint{0, 79} {
+		if ok, _ := filter.filter.has(index); !ok {
+			t.Errorf("bit %d should be set", index)
+		}
+	}
+	if count, _ := filter.filter.bitCount(); count != 2 {
+		t.Errorf("2 bits should be set, got %d", count)
+	}
+	chunks[2].Iterator = 25
+	if _, err := NewMemBloomFilterFromScanDump(chunks); err == nil {
+		t.Errorf("loading a chunk past the end of the filter should error out")
+	}
+	binary.LittleEndian.PutUint32(header[8:], 2)
+	if _, err := NewMemBloomFilterFromScanDump(chunks[:1]); err == nil {
+		t.Errorf("loading a scaled filter should error out")
+	}
+}
+
+func TestBloomFilterScanDumpHashing(t *testing.T) {
+	filter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	if _, err := filter.ScanDump(); err == nil {
+		t.Errorf("scandump of a filter using metro hashing should error out")
+	}
+	redisBloomFilter, _ := NewMemBloomFilterForRedisBloom(1000, 0.01)
+	redisBloomFilter.InsertString("foo")
+
+	data, _ := redisBloomFilter.Export()
+	imported, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	imported.Import(data)
+	if !imported.LookupString("foo") || imported.hashing != RedisBloomHashing {
+		t.Errorf("import should keep the redisbloom hashing")
+	}
+
+	var buf bytes.Buffer
+	redisBloomFilter.WriteTo(&buf)
+	read := &BloomFilter{}
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatalf("read shouldn't error out, error: %v", err)
+	}
+	if !read.LookupString("foo") || read.hashing != RedisBloomHashing {
+		t.Errorf("read should keep the redisbloom hashing")
+	}
+
+	comparison, _ := redisBloomFilter.Compare(&BloomFilter{size: redisBloomFilter.size, numHashes: redisBloomFilter.numHashes, filter: newBitSetMem(redisBloomFilter.size)})
+	if comparison.Reason != ParameterMismatch {
+		t.Errorf("filters using different hashing shouldn't be equal, got %v", comparison)
+	}
+}
+
+func TestBloomFilterFromRedisBloomUnsupported(t *testing.T) {
+	initMockRedis()
+	if _, err := NewMemBloomFilterFromRedisBloom("filter"); err == nil {
+		t.Errorf("BF.SCANDUMP should error out on a server without RedisBloom")
+	}
+}
+
+func TestCuckooFilterScanDump(t *testing.T) {
+	filter, err := NewCuckooFilterForRedisBloom(1000, 2, 20)
+	if err != nil {
+		t.Fatalf("filter creation shouldn't error out, error: %v", err)
+	}
+	if filter.size != 512 || filter.Hashing() != RedisBloomCuckooHashing {
+		t.Errorf("filter should be sized like CF.RESERVE 1000, got %d buckets", filter.size)
+	}
+	for i := 0; i < 400; i++ {
+		if _, err := filter.InsertWithStats([]byte(strconv.Itoa(i)), false, 0); err != nil {
+			t.Fatalf("insert of %d shouldn't error out, error: %v", i, err)
+		}
+	}
+	chunks, err := filter.ScanDump()
+	if err != nil {
+		t.Fatalf("scandump shouldn't error out, error: %v", err)
+	}
+	if len(chunks) != 2 || len(chunks[0].Data) != redisBloomCuckooHeaderSize || chunks[1].Iterator != 1025 {
+		t.Fatalf("scandump should be a header and a chunk of 1024 bytes, got %d chunks", len(chunks))
+	}
+	header, err := decodeRedisBloomCuckooHeader(chunks[0].Data)
+	if err != nil {
+		t.Fatalf("header decoding shouldn't error out, error: %v", err)
+	}
+	expected := redisBloomCuckooHeader{NumItems: 400, NumBuckets: 512, NumFilters: 1, BucketSize: 2, MaxIterations: 20, Expansion: 1}
+	if header != expected {
+		t.Errorf("header should be %+v, got %+v", expected, header)
+	}
+	loaded, err := NewCuckooFilterFromScanDump(chunks)
+	if err != nil {
+		t.Fatalf("loading scandump shouldn't error out, error: %v", err)
+	}
+	if equal, _ := filter.Equals(loaded); !equal || loaded.Length() != 400 {
+		t.Errorf("loaded filter should be equal to the dumped filter")
+	}
+	for i := 0; i < 400; i++ {
+		if !loaded.LookupString(strconv.Itoa(i)) {
+			t.Errorf("%d should be found in the loaded filter", i)
+		}
+	}
+	packed := append(chunks[0].Data[:0:0], chunks[0].Data[:38]...)
+	if _, err := NewCuckooFilterFromScanDump([]ScanDumpChunk{{1, packed}, chunks[1]}); err != nil {
+		t.Errorf("loading a packed header shouldn't error out, error: %v", err)
+	}
+}
+
+func TestCuckooFilterScanDumpHashing(t *testing.T) {
+	data := []byte("gostatix")
+	hash := murmurHash64A(data, 0)
+	fingerPrint := hash%255 + 1
+	firstIndex := hash % 8
+	secondIndex := (hash ^ fingerPrint*0x5bd1e995) % 8
+
+	// the element is in its second bucket, as if RedisBloom had kicked it out of the first one
+	bucketData := make([]byte, 8*2)
+	bucketData[secondIndex*2+1] = byte(fingerPrint)
+	filter, err := NewCuckooFilterFromScanDump([]ScanDumpChunk{
+		{1, encodeRedisBloomCuckooHeader(redisBloomCuckooHeader{NumItems: 1, NumBuckets: 8, NumFilters: 1, BucketSize: 2, MaxIterations: 20, Expansion: 1})},
+		{17, bucketData},
+	})
+	if err != nil {
+		t.Fatalf("loading scandump shouldn't error out, error: %v", err)
+	}
+	if !filter.Lookup(data) || filter.Length() != 1 {
+		t.Errorf("element should be found in its second bucket")
+	}
+	if !filter.Remove(data) || filter.Length() != 0 {
+		t.Errorf("element should be removed from its second bucket")
+	}
+	if _, err := filter.InsertWithStats(data, false, 0); err != nil {
+		t.Fatalf("insert shouldn't error out, error: %v", err)
+	}
+	chunks, err := filter.ScanDump()
+	if err != nil {
+		t.Fatalf("scandump shouldn't error out, error: %v", err)
+	}
+	if chunks[1].Data[firstIndex*2] != byte(fingerPrint) {
+		t.Errorf("fingerprint %d should be dumped in bucket %d, got %v", fingerPrint, firstIndex, chunks[1].Data)
+	}
+}
+
+func TestCuckooFilterScanDumpErrors(t *testing.T) {
+	if _, err := NewCuckooFilterForRedisBloom(3, 2, 20); err == nil {
+		t.Errorf("capacity lower than twice the bucket size should error out")
+	}
+	if _, err := NewCuckooFilterForRedisBloom(1000, 256, 20); err == nil {
+		t.Errorf("bucket size higher than 255 should error out")
+	}
+	filter, _ := NewCuckooFilter(512, 2, 3)
+	if _, err := filter.ScanDump(); err == nil {
+		t.Errorf("scandump of a filter not using redisbloom hashing should error out")
+	}
+	header := redisBloomCuckooHeader{NumBuckets: 8, NumFilters: 1, BucketSize: 2, MaxIterations: 20, Expansion: 1}
+	invalid := map[string][]ScanDumpChunk{
+		"no header":    nil,
+		"short header": {{1, encodeRedisBloomCuckooHeader(header)[:32]}},
+		"sub-filters":  {{1, encodeRedisBloomCuckooHeader(redisBloomCuckooHeader{NumBuckets: 8, NumFilters: 2, BucketSize: 2})}},
+		"buckets":      {{1, encodeRedisBloomCuckooHeader(redisBloomCuckooHeader{NumBuckets: 6, NumFilters: 1, BucketSize: 2})}},
+		"bucket size":  {{1, encodeRedisBloomCuckooHeader(redisBloomCuckooHeader{NumBuckets: 8, NumFilters: 1})}},
+		"chunk":        {{1, encodeRedisBloomCuckooHeader(header)}, {18, make([]byte, 16)}},
+	}
+	for name, chunks := range invalid {
+		if _, err := NewCuckooFilterFromScanDump(chunks); err == nil {
+			t.Errorf("loading scandump with invalid %s should error out", name)
+		}
+	}
+}
+
+func TestCuckooFilterFromRedisBloomUnsupported(t *testing.T) {
+	initMockRedis()
+	if _, err := NewCuckooFilterFromRedisBloom("filter"); err == nil {
+		t.Errorf("CF.SCANDUMP should error out on a server without RedisBloom")
+	}
+}
@@ -0,0 +1,263 @@
+package gostatix
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RegistryKey is the Redis set holding the metadata keys of all the Redis backed
+// data structures created by gostatix
+const RegistryKey = "gostatix:registry"
+
+// StructureInfo describes a Redis backed data structure recorded in the registry
+// _MetadataKey_ is the Redis key of the hash holding the metadata of the structure
+// _Type_ is one of bloom, cuckoo, cms, hll or topk
+// _CreatedAt_ is the time at which the structure was created
+// _Parameters_ holds the rest of the metadata like sizes and the Redis keys of the data
+type StructureInfo struct {
+	MetadataKey string
+	Type        string
+	CreatedAt   time.Time
+	Parameters  map[string]string
+}
+
+// mutableMetadataFields are the metadata fields updated after the creation of a data
+// structure, which aren't covered by the checksum
+var mutableMetadataFields = map[string]bool{
+	"checksum":   true,
+	"createdAt":  true,
+	"length":     true,
+	"allSum":     true,
+	"safeRemove": true,
+	"expiry":     true,
+	"hashing":    true,
+}
+
+// saveMetadata writes _metadata_ to the hash at _metadataKey_ tagged with the type _kind_
+// and records _metadataKey_ in the registry. The creation time is only set the first
+// time the metadata is saved. A checksum of the fields which don't change after the
+// creation is saved along with them and checked by loadMetadata.
+func saveMetadata(metadataKey, kind string, metadata map[string]interface{}) error {
+	return saveMetadataContext(context.Background(), metadataKey, kind, metadata)
+}
+
+// saveMetadataContext is saveMetadata sending the commands with the context _ctx_
+func saveMetadataContext(ctx context.Context, metadataKey, kind string, metadata map[string]interface{}) error {
+	values := make(map[string]string, len(metadata)+2)
+	for field, value := range metadata {
+		values[field] = formatMetadataValue(value)
+	}
+	values["type"] = kind
+	values["checksum"] = metadataChecksum(values)
+	_, err := getRedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, metadataKey, values)
+		pipe.HSetNX(ctx, metadataKey, "createdAt", time.Now().UnixMilli())
+		pipe.SAdd(ctx, RegistryKey, metadataKey)
+		return nil
+	})
+	return err
+}
+
+// formatMetadataValue formats _value_ the way it's stored in the metadata hash
+func formatMetadataValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// metadataChecksum returns the CRC-32 of the sorted fields and values of _values_ which
+// don't change after the creation of the data structure
+func metadataChecksum(values map[string]string) string {
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		if !mutableMetadataFields[field] {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	hash := crc32.NewIEEE()
+	for _, field := range fields {
+		fmt.Fprintf(hash, "%s=%s\n", field, values[field])
+	}
+	return strconv.FormatUint(uint64(hash.Sum32()), 16)
+}
+
+// structureMetadata is the metadata of a Redis backed data structure read by loadMetadata
+type structureMetadata struct {
+	key    string
+	values map[string]string
+}
+
+// loadMetadata fetches the metadata hash at _metadataKey_ and checks that it describes a
+// data structure of type _kind_ and that it matches its checksum. Metadata saved by
+// versions which didn't record the type or the checksum is accepted.
+func loadMetadata(metadataKey, kind string) (*structureMetadata, error) {
+	return loadMetadataContext(context.Background(), metadataKey, kind)
+}
+
+// loadMetadataContext is loadMetadata sending the commands with the context _ctx_
+func loadMetadataContext(ctx context.Context, metadataKey, kind string) (*structureMetadata, error) {
+	values, err := getRedisClient().HGetAll(ctx, metadataKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while fetching metadata from redis, error: %v", err)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("gostatix: no metadata found at key %s", metadataKey)
+	}
+	if values["type"] != "" && values["type"] != kind {
+		return nil, fmt.Errorf("gostatix: metadata at key %s is of a %s, not a %s", metadataKey, values["type"], kind)
+	}
+	if checksum := values["checksum"]; checksum != "" && checksum != metadataChecksum(values) {
+		return nil, fmt.Errorf("gostatix: metadata at key %s doesn't match its checksum, it may be corrupted", metadataKey)
+	}
+	return &structureMetadata{metadataKey, values}, nil
+}
+
+// string returns the value of the required _field_
+func (m *structureMetadata) string(field string) (string, error) {
+	value := m.values[field]
+	if value == "" {
+		return "", fmt.Errorf("gostatix: metadata at key %s is missing field %s", m.key, field)
+	}
+	return value, nil
+}
+
+// uint returns the value of the required _field_ parsed as an unsigned integer
+func (m *structureMetadata) uint(field string) (uint64, error) {
+	value, err := m.string(field)
+	if err != nil {
+		return 0, err
+	}
+	parsed, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("gostatix: field %s of metadata at key %s isn't an unsigned integer: %q", field, m.key, value)
+	}
+	return parsed, nil
+}
+
+// optionalUint returns the value of _field_ parsed as an unsigned integer, or 0 if it's missing
+func (m *structureMetadata) optionalUint(field string) (uint64, error) {
+	if m.values[field] == "" {
+		return 0, nil
+	}
+	return m.uint(field)
+}
+
+// float returns the value of the required _field_ parsed as a float
+func (m *structureMetadata) float(field string) (float64, error) {
+	value, err := m.string(field)
+	if err != nil {
+		return 0, err
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("gostatix: field %s of metadata at key %s isn't a number: %q", field, m.key, value)
+	}
+	return parsed, nil
+}
+
+// checkKeysExist returns an error if any of the Redis _keys_ referenced by the metadata
+// doesn't exist
+func (m *structureMetadata) checkKeysExist(keys ...string) error {
+	ctx := context.Background()
+	pipe := getRedisClient().Pipeline()
+	exists := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		exists[i] = pipe.Exists(ctx, key)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("gostatix: error while checking keys in redis, error: %v", err)
+	}
+	for i, key := range keys {
+		if exists[i].Val() == 0 {
+			return fmt.Errorf("gostatix: key %s referenced by metadata at key %s doesn't exist", key, m.key)
+		}
+	}
+	return nil
+}
+
+// ListStructures returns the information about all the Redis backed data structures
+// recorded in the registry, ordered by their metadata keys. Registry entries whose
+// metadata no longer exists in Redis are skipped.
+func ListStructures() ([]StructureInfo, error) {
+	ctx := context.Background()
+	metadataKeys, err := getRedisClient().SMembers(ctx, RegistryKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while fetching registry from redis, error: %v", err)
+	}
+	sort.Strings(metadataKeys)
+	pipe := getRedisClient().Pipeline()
+	values := make([]*redis.MapStringStringCmd, len(metadataKeys))
+	for i := range metadataKeys {
+		values[i] = pipe.HGetAll(ctx, metadataKeys[i])
+	}
+	if len(metadataKeys) > 0 {
+		_, err = pipe.Exec(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("gostatix: error while fetching metadata from redis, error: %v", err)
+		}
+	}
+	structures := make([]StructureInfo, 0, len(metadataKeys))
+	for i := range metadataKeys {
+		if len(values[i].Val()) == 0 {
+			continue
+		}
+		structures = append(structures, makeStructureInfo(metadataKeys[i], values[i].Val()))
+	}
+	return structures, nil
+}
+
+// Describe returns the information about the Redis backed data structure whose
+// metadata is stored at _metadataKey_
+func Describe(metadataKey string) (*StructureInfo, error) {
+	values, err := getRedisClient().HGetAll(context.Background(), metadataKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while fetching metadata from redis, error: %v", err)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("gostatix: no data structure found at key %s", metadataKey)
+	}
+	info := makeStructureInfo(metadataKey, values)
+	return &info, nil
+}
+
+// Unregister removes _metadataKey_ from the registry. The data of the structure
+// is left untouched in Redis.
+func Unregister(metadataKey string) error {
+	err := getRedisClient().SRem(context.Background(), RegistryKey, metadataKey).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while removing %s from registry, error: %v", metadataKey, err)
+	}
+	return nil
+}
+
+func makeStructureInfo(metadataKey string, values map[string]string) StructureInfo {
+	info := StructureInfo{MetadataKey: metadataKey, Type: values["type"], Parameters: make(map[string]string)}
+	createdAt, err := strconv.ParseInt(values["createdAt"], 10, 64)
+	if err == nil {
+		info.CreatedAt = time.UnixMilli(createdAt)
+	}
+	for field, value := range values {
+		if field != "type" && field != "createdAt" && field != "checksum" {
+			info.Parameters[field] = value
+		}
+	}
+	return info
+}
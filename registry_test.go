@@ -0,0 +1,135 @@
+package gostatix
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRegistryListStructures(t *testing.T) {
+	initMockRedis()
+	before := time.Now().Add(-time.Second)
+	filter, _ := NewRedisBloomFilterWithParameters(100, 0.01)
+	h, _ := NewHyperLogLogRedis(16)
+	structures, err := ListStructures()
+	if err != nil {
+		t.Fatalf("list structures shouldn't error out, error: %v", err)
+	}
+	found := make(map[string]StructureInfo)
+	for _, info := range structures {
+		found[info.MetadataKey] = info
+	}
+	bloomInfo, ok := found[filter.GetMetadataKey()]
+	if !ok || bloomInfo.Type != "bloom" {
+		t.Errorf("bloom filter should be registered with type bloom, found %+v", bloomInfo)
+	}
+	if bloomInfo.CreatedAt.Before(before) {
+		t.Errorf("creation time %v should be after %v", bloomInfo.CreatedAt, before)
+	}
+	hllInfo, ok := found[h.MetadataKey()]
+	if !ok || hllInfo.Type != "hll" || hllInfo.Parameters["numRegisters"] != "16" {
+		t.Errorf("hyperloglog should be registered with type hll and 16 registers, found %+v", hllInfo)
+	}
+
+	err = Unregister(h.MetadataKey())
+	if err != nil {
+		t.Errorf("unregister shouldn't error out, error: %v", err)
+	}
+	structures, _ = ListStructures()
+	for _, info := range structures {
+		if info.MetadataKey == h.MetadataKey() {
+			t.Error("hyperloglog shouldn't be listed after unregister")
+		}
+	}
+}
+
+func TestRegistryDescribe(t *testing.T) {
+	initMockRedis()
+	cms, _ := NewCountMinSketchRedis(3, 20)
+	info, err := Describe(cms.MetadataKey())
+	if err != nil {
+		t.Fatalf("describe shouldn't error out, error: %v", err)
+	}
+	if info.Type != "cms" || info.Parameters["rows"] != "3" || info.Parameters["columns"] != "20" {
+		t.Errorf("unexpected structure info %+v", info)
+	}
+
+	getRedisClient().Del(context.Background(), cms.MetadataKey())
+	_, err = Describe(cms.MetadataKey())
+	if err == nil {
+		t.Error("should error out as the metadata is deleted")
+	}
+	structures, _ := ListStructures()
+	for _, info := range structures {
+		if info.MetadataKey == cms.MetadataKey() {
+			t.Error("structure with deleted metadata shouldn't be listed")
+		}
+	}
+}
+
+func TestFromKeyIntegrity(t *testing.T) {
+	initMockRedis()
+	ctx := context.Background()
+	filter, _ := NewRedisBloomFilterWithParameters(100, 0.01)
+	h, _ := NewHyperLogLogRedis(16)
+	cms, _ := NewCountMinSketchRedis(3, 20)
+	topk, _ := NewTopKRedis(10, 0.001, 0.01)
+	cuckoo, _ := NewCuckooFilterRedis(10, 2, 3)
+
+	if _, err := NewRedisBloomFilterFromKey("missing"); err == nil {
+		t.Error("loading a bloom filter from a missing key should error out")
+	}
+	if _, err := NewHyperLogLogRedisFromKey(filter.GetMetadataKey()); err == nil {
+		t.Error("loading a hyperloglog from the key of a bloom filter should error out")
+	}
+	if _, err := NewCountMinSketchRedisFromKey(topk.MetadataKey()); err == nil {
+		t.Error("loading a count min sketch from the key of a topk should error out")
+	}
+	if _, err := NewCuckooFilterRedisFromKey(h.MetadataKey()); err == nil {
+		t.Error("loading a cuckoo filter from the key of a hyperloglog should error out")
+	}
+
+	cms.UpdateString("foo", 3)
+	cuckoo.InsertString("foo", false)
+	if _, err := NewCountMinSketchRedisFromKey(cms.MetadataKey()); err != nil {
+		t.Errorf("updates shouldn't break the checksum of a count min sketch, error: %v", err)
+	}
+	if _, err := NewCuckooFilterRedisFromKey(cuckoo.MetadataKey()); err != nil {
+		t.Errorf("inserts shouldn't break the checksum of a cuckoo filter, error: %v", err)
+	}
+
+	getRedisClient().HSet(ctx, h.MetadataKey(), "numRegisters", 32)
+	if _, err := NewHyperLogLogRedisFromKey(h.MetadataKey()); err == nil {
+		t.Error("loading a hyperloglog with a tampered number of registers should error out")
+	}
+
+	getRedisClient().HDel(ctx, cms.MetadataKey(), "checksum", "columns")
+	if _, err := NewCountMinSketchRedisFromKey(cms.MetadataKey()); err == nil {
+		t.Error("loading a count min sketch without columns should error out")
+	}
+
+	getRedisClient().HDel(ctx, topk.MetadataKey(), "checksum")
+	getRedisClient().HSet(ctx, topk.MetadataKey(), "errorRate", "abc")
+	if _, err := NewTopKRedisFromKey(topk.MetadataKey()); err == nil {
+		t.Error("loading a topk with an invalid error rate should error out")
+	}
+
+	bitSetKey := filter.filter.(*BitSetRedis).getKey()
+	getRedisClient().Del(ctx, bitSetKey)
+	if _, err := NewRedisBloomFilterFromKey(filter.GetMetadataKey()); err == nil {
+		t.Error("loading a bloom filter whose bitset is deleted should error out")
+	}
+	getRedisClient().Set(ctx, bitSetKey, "ab", 0)
+	if _, err := NewRedisBloomFilterFromKey(filter.GetMetadataKey()); err == nil {
+		t.Error("loading a bloom filter whose bitset is truncated should error out")
+	}
+}
+
+func TestFromKeyWithoutChecksum(t *testing.T) {
+	initMockRedis()
+	h, _ := NewHyperLogLogRedis(16)
+	getRedisClient().HDel(context.Background(), h.MetadataKey(), "checksum", "type")
+	if _, err := NewHyperLogLogRedisFromKey(h.MetadataKey()); err != nil {
+		t.Errorf("metadata without type and checksum should be accepted, error: %v", err)
+	}
+}
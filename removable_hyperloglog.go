@@ -0,0 +1,206 @@
+/*
+Implements the removable hyperloglog used in estimating the unique entries of a stream with
+retractions, like the users of a list who can leave it.
+
+Removable HyperLogLog: keeps a HyperLogLog of the elements added and another one of the
+elements removed. The count of distinct elements is the count of the union of both minus
+the count of the removed elements, i.e. the elements added and never removed. As the
+registers of a hyperloglog can't forget an element, an element removed once stays removed,
+even if it's added again.
+
+The in-memory data structure is thread-safe.
+*/
+package gostatix
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// RemovableHyperLogLog struct. This is an in-memory implementation of a HyperLogLog
+// supporting removals.
+// _added_ is the hyperloglog of the elements added
+// _removed_ is the hyperloglog of the elements removed
+// _lock_ guards the swap of the hyperloglogs by Import, the hyperloglogs synchronize
+// their own read/writes
+type RemovableHyperLogLog struct {
+	added   *HyperLogLog
+	removed *HyperLogLog
+	lock    sync.RWMutex
+}
+
+type removableHyperLogLogJSON struct {
+	NumRegisters uint64  `json:"nr"`
+	Added        []uint8 `json:"a"`
+	Removed      []uint8 `json:"r"`
+}
+
+// NewRemovableHyperLogLog creates new RemovableHyperLogLog keeping two HyperLogLogs with
+// _numRegisters_ registers each
+func NewRemovableHyperLogLog(numRegisters uint64) (*RemovableHyperLogLog, error) {
+	added, err := NewHyperLogLog(numRegisters)
+	if err != nil {
+		return nil, err
+	}
+	removed, _ := NewHyperLogLog(numRegisters)
+	return &RemovableHyperLogLog{added: added, removed: removed}, nil
+}
+
+// NumRegisters returns the number of registers of each hyperloglog
+func (h *RemovableHyperLogLog) NumRegisters() uint64 {
+	return h.added.numRegisters
+}
+
+// Update adds _data_ (byte slice) to the RemovableHyperLogLog
+func (h *RemovableHyperLogLog) Update(data []byte) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	h.added.Update(data)
+}
+
+// UpdateString adds _data_ (string) to the RemovableHyperLogLog
+func (h *RemovableHyperLogLog) UpdateString(data string) {
+	h.Update([]byte(data))
+}
+
+// Remove removes _data_ (byte slice) from the RemovableHyperLogLog. It's no longer counted
+// even if it's added again.
+func (h *RemovableHyperLogLog) Remove(data []byte) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	h.removed.Update(data)
+}
+
+// RemoveString removes _data_ (string) from the RemovableHyperLogLog
+func (h *RemovableHyperLogLog) RemoveString(data string) {
+	h.Remove([]byte(data))
+}
+
+// Count returns the number of distinct elements added and not removed, the count of the
+// union of the added and removed elements minus the count of the removed ones. It's 0 if
+// the estimate of the removed elements is the higher.
+// _withCorrection_ and _withRoundingOff_ are passed to HyperLogLog.Count
+func (h *RemovableHyperLogLog) Count(withCorrection, withRoundingOff bool) uint64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	union, _ := NewHyperLogLog(h.added.numRegisters)
+	union.Merge(h.added)
+	union.Merge(h.removed)
+	all := union.Count(withCorrection, withRoundingOff)
+	removed := h.removed.Count(withCorrection, withRoundingOff)
+	if removed >= all {
+		return 0
+	}
+	return all - removed
+}
+
+// Added returns a copy of the HyperLogLog of the elements added, removed or not
+func (h *RemovableHyperLogLog) Added() *HyperLogLog {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return copyHyperLogLog(h.added)
+}
+
+// Removed returns a copy of the HyperLogLog of the elements removed
+func (h *RemovableHyperLogLog) Removed() *HyperLogLog {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return copyHyperLogLog(h.removed)
+}
+
+// copyHyperLogLog returns a new HyperLogLog with the registers of _g_
+func copyHyperLogLog(g *HyperLogLog) *HyperLogLog {
+	h, _ := NewHyperLogLog(g.numRegisters)
+	h.Merge(g)
+	return h
+}
+
+// Merge merges the added and the removed elements of _g_ in the RemovableHyperLogLog
+func (h *RemovableHyperLogLog) Merge(g *RemovableHyperLogLog) error {
+	if h.NumRegisters() != g.NumRegisters() {
+		return fmt.Errorf("gostatix: number of registers %d, %d don't match", h.NumRegisters(), g.NumRegisters())
+	}
+	if h == g {
+		return nil
+	}
+	added, removed := g.Added(), g.Removed()
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	h.added.Merge(added)
+	h.removed.Merge(removed)
+	return nil
+}
+
+// Equals checks if two RemovableHyperLogLogs have the same added and removed elements
+func (h *RemovableHyperLogLog) Equals(g *RemovableHyperLogLog) (bool, error) {
+	if h == g {
+		return true, nil
+	}
+	equal, err := h.Added().Equals(g.Added())
+	if err != nil || !equal {
+		return false, err
+	}
+	return h.Removed().Equals(g.Removed())
+}
+
+// Reset removes all the added and removed elements of the RemovableHyperLogLog
+func (h *RemovableHyperLogLog) Reset() {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	h.added.Reset()
+	h.removed.Reset()
+}
+
+// MemoryUsage returns the estimated number of bytes used in-process by the
+// RemovableHyperLogLog
+func (h *RemovableHyperLogLog) MemoryUsage() uint64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return uint64(unsafe.Sizeof(*h)) + h.added.MemoryUsage() + h.removed.MemoryUsage()
+}
+
+// Export JSON marshals the RemovableHyperLogLog and returns a byte slice containing the data
+func (h *RemovableHyperLogLog) Export() ([]byte, error) {
+	added, removed := h.Added(), h.Removed()
+	return json.Marshal(removableHyperLogLogJSON{added.numRegisters, added.registers, removed.registers})
+}
+
+// Import JSON unmarshals the _data_ into the RemovableHyperLogLog
+func (h *RemovableHyperLogLog) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
+	var g removableHyperLogLogJSON
+	err = json.Unmarshal(data, &g)
+	if err != nil {
+		return err
+	}
+	err = HyperLogLogParams{g.NumRegisters}.Validate()
+	if err != nil {
+		return fmt.Errorf("gostatix: invalid removable hyperloglog snapshot, error: %v", err)
+	}
+	if uint64(len(g.Added)) != g.NumRegisters || uint64(len(g.Removed)) != g.NumRegisters {
+		return fmt.Errorf("gostatix: invalid removable hyperloglog snapshot, %d and %d registers found instead of %d", len(g.Added), len(g.Removed), g.NumRegisters)
+	}
+	added, _ := NewHyperLogLog(g.NumRegisters)
+	added.registers = g.Added
+	removed, _ := NewHyperLogLog(g.NumRegisters)
+	removed.registers = g.Removed
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.added = added
+	h.removed = removed
+	return nil
+}
@@ -0,0 +1,94 @@
+package gostatix
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRemovableHyperLogLogCount(t *testing.T) {
+	h, err := NewRemovableHyperLogLog(1024)
+	if err != nil {
+		t.Fatalf("creation shouldn't error out, error: %v", err)
+	}
+	added, _ := NewHyperLogLog(1024)
+	removed, _ := NewHyperLogLog(1024)
+	for i := 0; i < 5000; i++ {
+		h.UpdateString(strconv.Itoa(i))
+		added.UpdateString(strconv.Itoa(i))
+	}
+	for i := 0; i < 2000; i++ {
+		h.RemoveString(strconv.Itoa(i))
+		removed.UpdateString(strconv.Itoa(i))
+	}
+	added.Merge(removed)
+	expected := added.Count(true, true) - removed.Count(true, true)
+	if count := h.Count(true, true); count != expected {
+		t.Errorf("count should be the count of the union minus the removed ones %d, got %d", expected, count)
+	}
+	h.UpdateString("0")
+	if count := h.Count(true, true); count != expected {
+		t.Errorf("elements removed shouldn't be counted when added again, got %d", count)
+	}
+	for i := 0; i < 5000; i++ {
+		h.RemoveString(strconv.Itoa(i))
+	}
+	if count := h.Count(true, true); count != 0 {
+		t.Errorf("count should be 0 once all elements are removed, got %d", count)
+	}
+	h.Reset()
+	if count := h.Count(true, true); count != 0 {
+		t.Errorf("count should be 0 after a reset, got %d", count)
+	}
+	if _, err := NewRemovableHyperLogLog(10); err == nil {
+		t.Errorf("creation with invalid registers should error out")
+	}
+}
+
+func TestRemovableHyperLogLogMerge(t *testing.T) {
+	h, _ := NewRemovableHyperLogLog(64)
+	g, _ := NewRemovableHyperLogLog(64)
+	for i := 0; i < 100; i++ {
+		h.UpdateString(strconv.Itoa(i))
+		g.UpdateString(strconv.Itoa(i + 50))
+	}
+	h.RemoveString("3")
+	g.RemoveString("7")
+	added, removed := h.Added(), h.Removed()
+	added.Merge(g.Added())
+	removed.Merge(g.Removed())
+	if err := h.Merge(g); err != nil {
+		t.Fatalf("merge shouldn't error out, error: %v", err)
+	}
+	if equal, _ := h.Added().Equals(added); !equal {
+		t.Errorf("added elements of both hyperloglogs should be merged")
+	}
+	if equal, _ := h.Removed().Equals(removed); !equal {
+		t.Errorf("removed elements of both hyperloglogs should be merged")
+	}
+	other, _ := NewRemovableHyperLogLog(128)
+	if err := h.Merge(other); err == nil {
+		t.Errorf("merge of hyperloglogs with different registers should error out")
+	}
+}
+
+func TestRemovableHyperLogLogExportImport(t *testing.T) {
+	h, _ := NewRemovableHyperLogLog(64)
+	for i := 0; i < 100; i++ {
+		h.UpdateString(strconv.Itoa(i))
+	}
+	h.RemoveString("1")
+	data, err := h.Export()
+	if err != nil {
+		t.Fatalf("export shouldn't error out, error: %v", err)
+	}
+	g, _ := NewRemovableHyperLogLog(16)
+	if err := g.Import(data); err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if equal, _ := h.Equals(g); !equal || g.NumRegisters() != 64 {
+		t.Errorf("imported hyperloglog should equal the exported one")
+	}
+	if err := g.Import([]byte(`{"nr":64,"a":"AA==","r":"AA=="}`)); err == nil {
+		t.Errorf("import of the wrong number of registers should error out")
+	}
+}
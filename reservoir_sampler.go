@@ -0,0 +1,431 @@
+/*
+Implements reservoir sampling, used in keeping a uniform or weighted random sample of a
+stream of unknown length.
+
+Reservoir sampling: Every element of the stream is given a random key u^(1/w), where u is
+uniform in (0, 1] and w is the weight of the element, and the k elements with the largest
+keys are kept (algorithm A-Res). With equal weights the sample is uniform, otherwise
+elements are sampled in proportion to their weights. As the keys are independent, merging
+two samples by keeping the k largest keys of both gives a sample of both streams.
+Refer: https://doi.org/10.1016/j.ipl.2005.11.003
+
+The keys are kept as log(u)/w, which sorts like u^(1/w) without underflowing for large
+weights. The package implements both in-mem and Redis backed solutions for the data
+structures. The in-memory data structures are thread-safe.
+*/
+package gostatix
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+
+	"github.com/kwertop/gostatix/internal/util"
+	"github.com/redis/go-redis/v9"
+)
+
+// reservoirAddScript adds the member ARGV[2] with the score ARGV[1] to the sorted set at
+// KEYS[1] and removes the members with the lowest scores past the first ARGV[3]
+var reservoirAddScript = redis.NewScript(`
+	redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+	local excess = redis.call('ZCARD', KEYS[1]) - tonumber(ARGV[3])
+	if excess > 0 then
+		redis.call('ZREMRANGEBYRANK', KEYS[1], 0, excess - 1)
+	end
+	return true
+`)
+
+// reservoirMergeScript adds the members of the sorted set at KEYS[2] to the one at KEYS[1]
+// and removes the members with the lowest scores past the first ARGV[1]
+var reservoirMergeScript = redis.NewScript(`
+	redis.call('ZUNIONSTORE', KEYS[1], 2, KEYS[1], KEYS[2], 'AGGREGATE', 'MAX')
+	local excess = redis.call('ZCARD', KEYS[1]) - tonumber(ARGV[1])
+	if excess > 0 then
+		redis.call('ZREMRANGEBYRANK', KEYS[1], 0, excess - 1)
+	end
+	return true
+`)
+
+// reservoirTagLength is the length of the random tag prepended to the elements stored in
+// the sorted set of a ReservoirSamplerRedis, so that repeated elements are distinct members
+const reservoirTagLength = 16
+
+// ReservoirSampler struct. This is an in-memory implementation of reservoir sampling
+// keeping a random sample of _k_ elements of type _T_.
+// _k_ is the size of the sample
+// _items_ is a min-heap of the sampled elements by key
+// _lock_ is used to synchronize concurrent read/writes
+type ReservoirSampler[T any] struct {
+	k     uint64
+	items reservoirHeap[T]
+	lock  sync.RWMutex
+}
+
+// reservoirItem is a sampled element _Item_ with its random key _Key_
+type reservoirItem[T any] struct {
+	Item T       `json:"v"`
+	Key  float64 `json:"s"`
+}
+
+// reservoirHeap is a min-heap of sampled elements by key
+type reservoirHeap[T any] []reservoirItem[T]
+
+func (h reservoirHeap[T]) Len() int           { return len(h) }
+func (h reservoirHeap[T]) Less(i, j int) bool { return h[i].Key < h[j].Key }
+func (h reservoirHeap[T]) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *reservoirHeap[T]) Push(x any) {
+	*h = append(*h, x.(reservoirItem[T]))
+}
+
+func (h *reservoirHeap[T]) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+type reservoirSamplerJSON[T any] struct {
+	K     uint64             `json:"k"`
+	Items []reservoirItem[T] `json:"i"`
+}
+
+// NewReservoirSampler creates new ReservoirSampler keeping a sample of _k_ elements
+func NewReservoirSampler[T any](k uint64) (*ReservoirSampler[T], error) {
+	if k == 0 {
+		return nil, fmt.Errorf("gostatix: reservoir sample size should be greater than 0")
+	}
+	return &ReservoirSampler[T]{k: k}, nil
+}
+
+// reservoirKey returns a random key for an element of weight _weight_
+func reservoirKey(weight float64) (float64, error) {
+	if !(weight > 0) || math.IsInf(weight, 1) {
+		return 0, fmt.Errorf("gostatix: reservoir sample weight %v should be positive and finite", weight)
+	}
+	return math.Log(1-rand.Float64()) / weight, nil
+}
+
+// K returns the size of the sample of the ReservoirSampler
+func (r *ReservoirSampler[T]) K() uint64 {
+	return r.k
+}
+
+// Add offers _item_ to the sample of the ReservoirSampler, every element having the same
+// chance of being sampled
+func (r *ReservoirSampler[T]) Add(item T) {
+	key, _ := reservoirKey(1)
+	r.offer(reservoirItem[T]{item, key})
+}
+
+// AddWeighted offers _item_ to the sample of the ReservoirSampler with a chance of being
+// sampled in proportion to _weight_, which should be positive
+func (r *ReservoirSampler[T]) AddWeighted(item T, weight float64) error {
+	key, err := reservoirKey(weight)
+	if err != nil {
+		return err
+	}
+	r.offer(reservoirItem[T]{item, key})
+	return nil
+}
+
+// offer adds _item_ to the sample if its key is one of the _k_ largest
+func (r *ReservoirSampler[T]) offer(item reservoirItem[T]) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.offerLocked(item)
+}
+
+func (r *ReservoirSampler[T]) offerLocked(item reservoirItem[T]) {
+	if uint64(len(r.items)) < r.k {
+		heap.Push(&r.items, item)
+	} else if item.Key > r.items[0].Key {
+		r.items[0] = item
+		heap.Fix(&r.items, 0)
+	}
+}
+
+// Sample returns the sampled elements, at most _k_, in no particular order
+func (r *ReservoirSampler[T]) Sample() []T {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	sample := make([]T, len(r.items))
+	for i, item := range r.items {
+		sample[i] = item.Item
+	}
+	return sample
+}
+
+// Len returns the number of sampled elements, which is _k_ once _k_ elements are added
+func (r *ReservoirSampler[T]) Len() uint64 {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return uint64(len(r.items))
+}
+
+// Merge merges the sample of _g_ in the ReservoirSampler, which then holds a sample of the
+// elements added to both. The samples should have the same size.
+func (r *ReservoirSampler[T]) Merge(g *ReservoirSampler[T]) error {
+	if r.k != g.k {
+		return fmt.Errorf("gostatix: reservoir sample sizes %d, %d don't match", r.k, g.k)
+	}
+	g.lock.RLock()
+	items := append(reservoirHeap[T](nil), g.items...)
+	g.lock.RUnlock()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, item := range items {
+		r.offerLocked(item)
+	}
+	return nil
+}
+
+// Reset removes all the elements from the sample of the ReservoirSampler
+func (r *ReservoirSampler[T]) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.items = r.items[:0]
+}
+
+// Export JSON marshals the ReservoirSampler and returns a byte slice containing the data.
+// The elements are marshaled with encoding/json.
+func (r *ReservoirSampler[T]) Export() ([]byte, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return json.Marshal(reservoirSamplerJSON[T]{r.k, r.items})
+}
+
+// Import JSON unmarshals the _data_ into the ReservoirSampler
+func (r *ReservoirSampler[T]) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
+	var g reservoirSamplerJSON[T]
+	err = json.Unmarshal(data, &g)
+	if err != nil {
+		return err
+	}
+	if g.K == 0 || uint64(len(g.Items)) > g.K {
+		return fmt.Errorf("gostatix: invalid reservoir sample snapshot, %d elements found for size %d", len(g.Items), g.K)
+	}
+	items := reservoirHeap[T](g.Items)
+	heap.Init(&items)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.k = g.K
+	r.items = items
+	return nil
+}
+
+// ReservoirSamplerRedis is the Redis backed implementation of reservoir sampling, keeping a
+// random sample of _k_ strings
+// _key_ holds the Redis key to the sorted set of the sampled elements by key. Each element is
+// prefixed with a random tag so that repeated elements are sampled independently.
+// _metadataKey_ is used to store the additional information about ReservoirSamplerRedis
+// for retrieving the sampler by the Redis key
+type ReservoirSamplerRedis struct {
+	k           uint64
+	key         string
+	metadataKey string
+}
+
+// NewReservoirSamplerRedis creates new ReservoirSamplerRedis keeping a sample of _k_ elements
+// _opts_ can name the Redis keys of the sampler with WithName and WithKeyPrefix
+func NewReservoirSamplerRedis(k uint64, opts ...Option) (*ReservoirSamplerRedis, error) {
+	if k == 0 {
+		return nil, fmt.Errorf("gostatix: reservoir sample size should be greater than 0")
+	}
+	o, err := redisOptions("reservoir sampler", opts)
+	if err != nil {
+		return nil, err
+	}
+	metadataKey, err := o.metadataKey()
+	if err != nil {
+		return nil, err
+	}
+	key, err := o.dataKey(":sample")
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]interface{})
+	metadata["k"] = k
+	metadata["key"] = key
+	err = saveMetadataContext(o.ctx, metadataKey, "reservoir", metadata)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error creating reservoir sampler redis, error: %v", err)
+	}
+	return &ReservoirSamplerRedis{k, key, metadataKey}, nil
+}
+
+// NewReservoirSamplerRedisFromKey is used to create a new Redis backed ReservoirSamplerRedis
+// from the _metadataKey_ (the Redis key used to store the metadata about the sampler) passed
+func NewReservoirSamplerRedisFromKey(metadataKey string) (*ReservoirSamplerRedis, error) {
+	metadata, err := loadMetadata(metadataKey, "reservoir")
+	if err != nil {
+		return nil, err
+	}
+	k, err := metadata.uint("k")
+	if err != nil {
+		return nil, err
+	}
+	key, err := metadata.string("key")
+	if err != nil {
+		return nil, err
+	}
+	if k == 0 {
+		return nil, fmt.Errorf("gostatix: invalid reservoir sampler metadata at key %s, sample size is 0", metadataKey)
+	}
+	return &ReservoirSamplerRedis{k, key, metadataKey}, nil
+}
+
+// K returns the size of the sample of the ReservoirSamplerRedis
+func (r *ReservoirSamplerRedis) K() uint64 {
+	return r.k
+}
+
+// MetadataKey returns the metadataKey
+func (r *ReservoirSamplerRedis) MetadataKey() string {
+	return r.metadataKey
+}
+
+// Add offers _item_ to the sample of the ReservoirSamplerRedis, every element having the
+// same chance of being sampled
+func (r *ReservoirSamplerRedis) Add(item string) error {
+	return r.AddWeighted(item, 1)
+}
+
+// AddWeighted offers _item_ to the sample of the ReservoirSamplerRedis with a chance of
+// being sampled in proportion to _weight_, which should be positive
+func (r *ReservoirSamplerRedis) AddWeighted(item string, weight float64) error {
+	key, err := reservoirKey(weight)
+	if err != nil {
+		return err
+	}
+	member := util.GenerateRandomString(reservoirTagLength) + item
+	err = reservoirAddScript.Run(context.Background(), getRedisClient(), []string{r.key}, strconv.FormatFloat(key, 'g', -1, 64), member, r.k).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while adding to reservoir sample in redis, error: %v", err)
+	}
+	return nil
+}
+
+// Sample returns the sampled elements, at most _k_, in no particular order
+func (r *ReservoirSamplerRedis) Sample() ([]string, error) {
+	items, err := r.items()
+	if err != nil {
+		return nil, err
+	}
+	sample := make([]string, len(items))
+	for i, item := range items {
+		sample[i] = item.Item
+	}
+	return sample, nil
+}
+
+// items returns the sampled elements without their tags along with their keys
+func (r *ReservoirSamplerRedis) items() ([]reservoirItem[string], error) {
+	members, err := getRedisClient().ZRangeWithScores(context.Background(), r.key, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while fetching reservoir sample from redis, error: %v", err)
+	}
+	items := make([]reservoirItem[string], len(members))
+	for i, member := range members {
+		item, _ := member.Member.(string)
+		if len(item) < reservoirTagLength {
+			return nil, fmt.Errorf("gostatix: invalid reservoir sample member %q in redis", item)
+		}
+		items[i] = reservoirItem[string]{item[reservoirTagLength:], member.Score}
+	}
+	return items, nil
+}
+
+// Len returns the number of sampled elements, which is _k_ once _k_ elements are added
+func (r *ReservoirSamplerRedis) Len() (uint64, error) {
+	length, err := getRedisClient().ZCard(context.Background(), r.key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("gostatix: error while fetching reservoir sample from redis, error: %v", err)
+	}
+	return uint64(length), nil
+}
+
+// Merge merges the sample of _g_ in the ReservoirSamplerRedis, which then holds a sample of
+// the elements added to both. The samples should have the same size.
+func (r *ReservoirSamplerRedis) Merge(g *ReservoirSamplerRedis) error {
+	if r.k != g.k {
+		return fmt.Errorf("gostatix: reservoir sample sizes %d, %d don't match", r.k, g.k)
+	}
+	err := reservoirMergeScript.Run(context.Background(), getRedisClient(), []string{r.key, g.key}, r.k).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while merging reservoir samples in redis, error: %v", err)
+	}
+	return nil
+}
+
+// Reset removes all the elements from the sample of the ReservoirSamplerRedis
+func (r *ReservoirSamplerRedis) Reset() error {
+	err := getRedisClient().Del(context.Background(), r.key).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while resetting reservoir sample in redis, error: %v", err)
+	}
+	return nil
+}
+
+// Export JSON marshals the ReservoirSamplerRedis and returns a byte slice containing the
+// data, in the format of the export of a ReservoirSampler[string]
+func (r *ReservoirSamplerRedis) Export() ([]byte, error) {
+	items, err := r.items()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(reservoirSamplerJSON[string]{r.k, items})
+}
+
+// Import JSON unmarshals the _data_, exported by a ReservoirSamplerRedis or a
+// ReservoirSampler[string], into the ReservoirSamplerRedis, replacing its sample. The size
+// of the samples should match.
+func (r *ReservoirSamplerRedis) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
+	var g reservoirSamplerJSON[string]
+	err = json.Unmarshal(data, &g)
+	if err != nil {
+		return err
+	}
+	if g.K != r.k || uint64(len(g.Items)) > g.K {
+		return fmt.Errorf("gostatix: invalid reservoir sample snapshot, %d elements found for size %d, expected size %d", len(g.Items), g.K, r.k)
+	}
+	members := make([]redis.Z, len(g.Items))
+	for i, item := range g.Items {
+		members[i] = redis.Z{Score: item.Key, Member: util.GenerateRandomString(reservoirTagLength) + item.Item}
+	}
+	ctx := context.Background()
+	_, err = getRedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.key)
+		if len(members) > 0 {
+			pipe.ZAdd(ctx, r.key, members...)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("gostatix: error while importing reservoir sample in redis, error: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package gostatix
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestReservoirSamplerUniform(t *testing.T) {
+	r, err := NewReservoirSampler[int](100)
+	if err != nil {
+		t.Fatalf("creation shouldn't error out, error: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		r.Add(i)
+	}
+	if r.Len() != 50 {
+		t.Errorf("all elements should be sampled below k, got %d", r.Len())
+	}
+	for i := 50; i < 10000; i++ {
+		r.Add(i)
+	}
+	sample := r.Sample()
+	if len(sample) != 100 {
+		t.Fatalf("sample should have k elements, got %d", len(sample))
+	}
+	var firstHalf int
+	for _, item := range sample {
+		if item < 5000 {
+			firstHalf++
+		}
+	}
+	if firstHalf < 30 || firstHalf > 70 {
+		t.Errorf("sample should be uniform, got %d elements of the first half", firstHalf)
+	}
+	if _, err := NewReservoirSampler[int](0); err == nil {
+		t.Errorf("creation with a zero sample size should error out")
+	}
+}
+
+func TestReservoirSamplerWeighted(t *testing.T) {
+	r, _ := NewReservoirSampler[string](10)
+	for i := 0; i < 1000; i++ {
+		r.AddWeighted("light"+strconv.Itoa(i), 1)
+	}
+	for i := 0; i < 10; i++ {
+		r.AddWeighted("heavy"+strconv.Itoa(i), 1e6)
+	}
+	var heavy int
+	for _, item := range r.Sample() {
+		if item[:5] == "heavy" {
+			heavy++
+		}
+	}
+	if heavy < 8 {
+		t.Errorf("heavy elements should dominate the sample, got %d", heavy)
+	}
+	if r.AddWeighted("foo", 0) == nil || r.AddWeighted("foo", -1) == nil {
+		t.Errorf("weights which aren't positive should error out")
+	}
+}
+
+func TestReservoirSamplerMergeExportImport(t *testing.T) {
+	a, _ := NewReservoirSampler[string](20)
+	b, _ := NewReservoirSampler[string](20)
+	for i := 0; i < 15; i++ {
+		a.Add("a" + strconv.Itoa(i))
+		b.Add("b" + strconv.Itoa(i))
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("merge shouldn't error out, error: %v", err)
+	}
+	if a.Len() != 20 {
+		t.Errorf("merged sample should have k elements, got %d", a.Len())
+	}
+	other, _ := NewReservoirSampler[string](10)
+	if a.Merge(other) == nil {
+		t.Errorf("merge of samples of different sizes should error out")
+	}
+	data, err := a.Export()
+	if err != nil {
+		t.Fatalf("export shouldn't error out, error: %v", err)
+	}
+	if err := other.Import(data); err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if other.K() != 20 || other.Len() != 20 {
+		t.Errorf("imported sample should be the exported one, got size %d with %d elements", other.K(), other.Len())
+	}
+	if other.Import([]byte(`{"k":1,"i":[{"v":"a","s":-1},{"v":"b","s":-2}]}`)) == nil {
+		t.Errorf("import of more elements than the sample size should error out")
+	}
+}
+
+func TestReservoirSamplerRedis(t *testing.T) {
+	initMockRedis()
+	r, err := NewReservoirSamplerRedis(10)
+	if err != nil {
+		t.Fatalf("creation shouldn't error out, error: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		r.Add("same")
+	}
+	sample, err := r.Sample()
+	if err != nil {
+		t.Fatalf("sample shouldn't error out, error: %v", err)
+	}
+	if len(sample) != 10 || sample[0] != "same" || sample[9] != "same" {
+		t.Fatalf("repeated elements should be sampled independently, got %v", sample)
+	}
+	for i := 0; i < 100; i++ {
+		r.Add(strconv.Itoa(i))
+	}
+
+	opened, err := NewReservoirSamplerRedisFromKey(r.MetadataKey())
+	if err != nil {
+		t.Fatalf("opening from key shouldn't error out, error: %v", err)
+	}
+	other, _ := NewReservoirSamplerRedis(10)
+	other.Add("other")
+	if err := opened.Merge(other); err != nil {
+		t.Fatalf("merge shouldn't error out, error: %v", err)
+	}
+	if length, _ := r.Len(); length != 10 {
+		t.Errorf("merged sample should have k elements, got %d", length)
+	}
+
+	data, err := r.Export()
+	if err != nil {
+		t.Fatalf("export shouldn't error out, error: %v", err)
+	}
+	mem, _ := NewReservoirSampler[string](1)
+	if err := mem.Import(data); err != nil {
+		t.Fatalf("import of a redis sample in memory shouldn't error out, error: %v", err)
+	}
+	sample, _ = r.Sample()
+	memSample := mem.Sample()
+	if len(memSample) != len(sample) {
+		t.Errorf("imported sample should have the elements of the exported one")
+	}
+	r.Reset()
+	if err := r.Import(data); err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if length, _ := r.Len(); length != 10 {
+		t.Errorf("imported sample should have k elements, got %d", length)
+	}
+	d, _ := r.Describe()
+	if d.Type != "reservoir" || d.Count != 10 || len(d.Keys) != 2 {
+		t.Errorf("unexpected description of the sampler, got %+v", d)
+	}
+}
@@ -0,0 +1,388 @@
+/*
+Implements the exports of the Bloom filters as raw bit slices and as Roaring bitmaps, so
+that their contents can be loaded by query engines and analytics systems, along with the
+constructors of the filters from Roaring bitmaps.
+
+The Roaring bitmaps are written in the portable format of the Roaring specification
+(https://github.com/RoaringBitmap/RoaringFormatSpec), read by the Roaring libraries of
+Java, C, Go and the engines built on them. The bits of the filter are split in chunks of
+2^16 bits, the bits set in a chunk being held in a sorted array of their low 16 bits if
+there are at most 4096 of them, else in a bitmap of 2^16 bits. The bitmaps with run
+containers, written by the libraries once their runs are optimized, can be read too.
+*/
+package gostatix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+const (
+	// roaringCookie starts the portable Roaring bitmaps without run containers, it's
+	// followed by the number of containers
+	roaringCookie = 12346
+	// roaringRunCookie starts the portable Roaring bitmaps with run containers in its low
+	// 16 bits, the number of containers minus 1 being in its high 16 bits
+	roaringRunCookie = 12347
+	// roaringNoOffsetThreshold is the number of containers under which the bitmaps with run
+	// containers have no offset header
+	roaringNoOffsetThreshold = 4
+	// roaringArrayMax is the maximum number of values of an array container
+	roaringArrayMax = 4096
+	// roaringBitmapWords is the number of words of a bitmap container
+	roaringBitmapWords = 1 << 16 / wordSize
+)
+
+// roaringContainer holds the values of a Roaring bitmap whose high 16 bits are _key_.
+// Their low 16 bits are held by _array_, sorted, if there are at most roaringArrayMax of
+// them, else by the bits of _bitmap_.
+type roaringContainer struct {
+	key    uint16
+	array  []uint16
+	bitmap []uint64
+}
+
+// roaringBitmap is a set of uint32 values split in containers sorted by key
+type roaringBitmap struct {
+	containers []roaringContainer
+}
+
+// cardinality returns the number of values of the container
+func (c *roaringContainer) cardinality() int {
+	if c.bitmap == nil {
+		return len(c.array)
+	}
+	count := 0
+	for _, word := range c.bitmap {
+		count += bits.OnesCount64(word)
+	}
+	return count
+}
+
+// forEach calls _fn_ with each value of the container in increasing order, until _fn_
+// returns false. It returns false if _fn_ did.
+func (c *roaringContainer) forEach(fn func(value uint32) bool) bool {
+	high := uint32(c.key) << 16
+	if c.bitmap == nil {
+		for _, low := range c.array {
+			if !fn(high | uint32(low)) {
+				return false
+			}
+		}
+		return true
+	}
+	for i, word := range c.bitmap {
+		for word != 0 {
+			if !fn(high | uint32(i*wordSize+bits.TrailingZeros64(word))) {
+				return false
+			}
+			word &= word - 1
+		}
+	}
+	return true
+}
+
+// newRoaringFromWords returns the Roaring bitmap of the bits set in _words_, the bit i
+// being the bit i % 64 of the word i / 64. _words_ should hold at most 2^32 bits.
+func newRoaringFromWords(words []uint64) *roaringBitmap {
+	rb := &roaringBitmap{}
+	for from := 0; from < len(words); from += roaringBitmapWords {
+		to := from + roaringBitmapWords
+		if to > len(words) {
+			to = len(words)
+		}
+		count := 0
+		for _, word := range words[from:to] {
+			count += bits.OnesCount64(word)
+		}
+		if count == 0 {
+			continue
+		}
+		c := roaringContainer{key: uint16(from / roaringBitmapWords)}
+		if count > roaringArrayMax {
+			c.bitmap = make([]uint64, roaringBitmapWords)
+			copy(c.bitmap, words[from:to])
+		} else {
+			c.array = make([]uint16, 0, count)
+			for i, word := range words[from:to] {
+				for word != 0 {
+					c.array = append(c.array, uint16(i*wordSize+bits.TrailingZeros64(word)))
+					word &= word - 1
+				}
+			}
+		}
+		rb.containers = append(rb.containers, c)
+	}
+	return rb
+}
+
+// forEach calls _fn_ with each value of the bitmap in increasing order, until _fn_
+// returns false
+func (rb *roaringBitmap) forEach(fn func(value uint32) bool) {
+	for i := range rb.containers {
+		if !rb.containers[i].forEach(fn) {
+			return
+		}
+	}
+}
+
+// words returns the words of a bitset of _size_ bits holding the values of the bitmap.
+// It errors out if a value isn't lower than _size_.
+func (rb *roaringBitmap) words(size uint64) ([]uint64, error) {
+	words := make([]uint64, wordsFor(size))
+	var err error
+	rb.forEach(func(value uint32) bool {
+		if uint64(value) >= size {
+			err = fmt.Errorf("gostatix: roaring bitmap value %d is out of range of bitset of size %d", value, size)
+			return false
+		}
+		words[value/uint32(wordSize)] |= 1 << (value % uint32(wordSize))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return words, nil
+}
+
+// marshal returns the bitmap in the portable Roaring format, without run containers
+func (rb *roaringBitmap) marshal() []byte {
+	n := len(rb.containers)
+	size := 8 + 8*n
+	for i := range rb.containers {
+		if rb.containers[i].bitmap != nil {
+			size += roaringBitmapWords * wordBytes
+		} else {
+			size += 2 * len(rb.containers[i].array)
+		}
+	}
+	data := make([]byte, 0, size)
+	data = binary.LittleEndian.AppendUint32(data, roaringCookie)
+	data = binary.LittleEndian.AppendUint32(data, uint32(n))
+	for i := range rb.containers {
+		data = binary.LittleEndian.AppendUint16(data, rb.containers[i].key)
+		data = binary.LittleEndian.AppendUint16(data, uint16(rb.containers[i].cardinality()-1))
+	}
+	offset := 8 + 8*n
+	for i := range rb.containers {
+		data = binary.LittleEndian.AppendUint32(data, uint32(offset))
+		if rb.containers[i].bitmap != nil {
+			offset += roaringBitmapWords * wordBytes
+		} else {
+			offset += 2 * len(rb.containers[i].array)
+		}
+	}
+	for i := range rb.containers {
+		if rb.containers[i].bitmap != nil {
+			for _, word := range rb.containers[i].bitmap {
+				data = binary.LittleEndian.AppendUint64(data, word)
+			}
+		} else {
+			for _, low := range rb.containers[i].array {
+				data = binary.LittleEndian.AppendUint16(data, low)
+			}
+		}
+	}
+	return data
+}
+
+// roaringReader reads the little endian integers of a portable Roaring bitmap
+type roaringReader struct {
+	data []byte
+	pos  int
+}
+
+// next returns the next _n_ bytes of the bitmap
+func (r *roaringReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, fmt.Errorf("gostatix: invalid roaring bitmap, %d bytes expected at offset %d of %d", n, r.pos, len(r.data))
+	}
+	buf := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return buf, nil
+}
+
+// unmarshalRoaring decodes the portable Roaring bitmap _data_, with or without run
+// containers. The run containers are decoded as bitmap containers.
+func unmarshalRoaring(data []byte) (*roaringBitmap, error) {
+	r := &roaringReader{data: data}
+	buf, err := r.next(4)
+	if err != nil {
+		return nil, err
+	}
+	cookie := binary.LittleEndian.Uint32(buf)
+	var n int
+	var runs []byte
+	switch {
+	case cookie == roaringCookie:
+		buf, err = r.next(4)
+		if err != nil {
+			return nil, err
+		}
+		if count := binary.LittleEndian.Uint32(buf); count > math.MaxUint16+1 {
+			return nil, fmt.Errorf("gostatix: invalid roaring bitmap, %d containers found, more than %d", count, math.MaxUint16+1)
+		}
+		n = int(binary.LittleEndian.Uint32(buf))
+	case cookie&0xFFFF == roaringRunCookie:
+		n = int(cookie>>16) + 1
+		runs, err = r.next((n + 7) / 8)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("gostatix: invalid roaring bitmap, unknown cookie %d", cookie)
+	}
+	header, err := r.next(4 * n)
+	if err != nil {
+		return nil, err
+	}
+	if runs == nil || n >= roaringNoOffsetThreshold {
+		// the containers are read in order, so the offsets aren't needed
+		_, err = r.next(4 * n)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rb := &roaringBitmap{containers: make([]roaringContainer, n)}
+	for i := 0; i < n; i++ {
+		key := binary.LittleEndian.Uint16(header[4*i:])
+		cardinality := int(binary.LittleEndian.Uint16(header[4*i+2:])) + 1
+		if i > 0 && key <= rb.containers[i-1].key {
+			return nil, fmt.Errorf("gostatix: invalid roaring bitmap, container keys should be increasing, %d follows %d", key, rb.containers[i-1].key)
+		}
+		c := roaringContainer{key: key}
+		switch {
+		case runs != nil && runs[i/8]&(1<<(i%8)) != 0:
+			err = c.readRuns(r)
+		case cardinality <= roaringArrayMax:
+			buf, err = r.next(2 * cardinality)
+			if err == nil {
+				c.array = make([]uint16, cardinality)
+				for j := range c.array {
+					c.array[j] = binary.LittleEndian.Uint16(buf[2*j:])
+				}
+			}
+		default:
+			buf, err = r.next(roaringBitmapWords * wordBytes)
+			if err == nil {
+				c.bitmap = make([]uint64, roaringBitmapWords)
+				for j := range c.bitmap {
+					c.bitmap[j] = binary.LittleEndian.Uint64(buf[wordBytes*j:])
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		rb.containers[i] = c
+	}
+	return rb, nil
+}
+
+// readRuns reads a run container from _r_ in the container, as a bitmap
+func (c *roaringContainer) readRuns(r *roaringReader) error {
+	buf, err := r.next(2)
+	if err != nil {
+		return err
+	}
+	numRuns := int(binary.LittleEndian.Uint16(buf))
+	buf, err = r.next(4 * numRuns)
+	if err != nil {
+		return err
+	}
+	c.bitmap = make([]uint64, roaringBitmapWords)
+	for i := 0; i < numRuns; i++ {
+		start := int(binary.LittleEndian.Uint16(buf[4*i:]))
+		end := start + int(binary.LittleEndian.Uint16(buf[4*i+2:]))
+		if end > math.MaxUint16 {
+			return fmt.Errorf("gostatix: invalid roaring bitmap, run [%d, %d] is out of its container", start, end)
+		}
+		for value := start; value <= end; value++ {
+			c.bitmap[value/wordSize] |= 1 << (value % wordSize)
+		}
+	}
+	return nil
+}
+
+// ExportWords returns the bitset of the BloomFilter as a slice of words, the bit i being
+// the bit i % 64 of the word i / 64, ready to be loaded by the engines reading raw
+// bitsets. The bitset of a Redis backed filter is streamed from Redis. The filter can be
+// created again with NewMemBloomFilterFromBitSet if its size is a multiple of 64 and it
+// uses the default hashing.
+func (bloomFilter *BloomFilter) ExportWords() ([]uint64, error) {
+	size, _, words, err := bloomFilter.bitSetWords()
+	if err != nil {
+		return nil, err
+	}
+	numWords := wordsFor(size)
+	if uint64(len(words)) >= numWords {
+		return words[:numWords], nil
+	}
+	return append(words, make([]uint64, numWords-uint64(len(words)))...), nil
+}
+
+// ExportRoaring returns the bitset of the BloomFilter as a portable Roaring bitmap holding
+// the indexes of its bits set. It errors out if the filter has more than 2^32 bits, the
+// values of a Roaring bitmap being 32-bit. The size and the number of hashes of the filter
+// aren't part of the bitmap, they're passed to NewMemBloomFilterFromRoaring along with it.
+// The bitmap of a filter backed by a BitSetRoaring is written as is.
+func (bloomFilter *BloomFilter) ExportRoaring() ([]byte, error) {
+	if uint64(bloomFilter.GetCap()) > math.MaxUint32+1 {
+		return nil, fmt.Errorf("gostatix: bloom filter of %d bits doesn't fit in a roaring bitmap of %d bits", bloomFilter.GetCap(), uint64(math.MaxUint32+1))
+	}
+	if bitSet, ok := bloomFilter.filter.(*BitSetRoaring); ok {
+		if bloomFilter.needsLock() {
+			bloomFilter.lock.RLock()
+			defer bloomFilter.lock.RUnlock()
+		}
+		return bitSet.bitmap.marshal(), nil
+	}
+	words, err := bloomFilter.ExportWords()
+	if err != nil {
+		return nil, err
+	}
+	return newRoaringFromWords(words).marshal(), nil
+}
+
+// roaringWords returns the words of the bitset of _size_ bits holding the values of the
+// portable Roaring bitmap _data_, after checking the parameters of the filter
+func roaringWords(data []byte, size, numHashes uint) ([]uint64, error) {
+	err := checkBloomFilterParams(uint64(size), uint64(numHashes))
+	if err != nil {
+		return nil, err
+	}
+	rb, err := unmarshalRoaring(data)
+	if err != nil {
+		return nil, err
+	}
+	return rb.words(uint64(size))
+}
+
+// NewMemBloomFilterFromRoaring creates and returns a new in-memory BloomFilter of _size_
+// bits and _numHashes_ hashes from the portable Roaring bitmap _data_ of its bits set,
+// e.g. written by ExportRoaring. It errors out if a bit of the bitmap is out of the filter.
+func NewMemBloomFilterFromRoaring(data []byte, size, numHashes uint) (*BloomFilter, error) {
+	words, err := roaringWords(data, size, numHashes)
+	if err != nil {
+		return nil, err
+	}
+	bitSet := newBitSetMem(size)
+	err = bitSet.OrWords(words, 0)
+	if err != nil {
+		return nil, err
+	}
+	return NewBloomFilterWithBitSet(size, numHashes, bitSet, "")
+}
+
+// NewRedisBloomFilterFromRoaring creates and returns a new Redis backed BloomFilter of
+// _size_ bits and _numHashes_ hashes from the portable Roaring bitmap _data_ of its bits
+// set, e.g. written by ExportRoaring
+func NewRedisBloomFilterFromRoaring(data []byte, size, numHashes uint) (*BloomFilter, error) {
+	words, err := roaringWords(data, size, numHashes)
+	if err != nil {
+		return nil, err
+	}
+	return newRedisBloomFilterFromWords(words, size, numHashes)
+}
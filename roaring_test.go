@@ -0,0 +1,98 @@
+package gostatix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"testing"
+)
+
+func TestRoaringFormat(t *testing.T) {
+	// {1, 2, 3} as written by the Roaring libraries
+	expected := []byte{0x3a, 0x30, 0, 0, 1, 0, 0, 0, 0, 0, 2, 0, 16, 0, 0, 0, 1, 0, 2, 0, 3, 0}
+	if data := newRoaringFromWords([]uint64{0xe}).marshal(); !bytes.Equal(data, expected) {
+		t.Errorf("roaring bitmap of {1, 2, 3} should be %v, got %v", expected, data)
+	}
+	words := make([]uint64, 3*roaringBitmapWords)
+	for i := 0; i < 5000; i++ {
+		// dense first chunk, sparse third one
+		words[i/wordSize] |= 1 << (i % wordSize)
+	}
+	words[2*roaringBitmapWords] = 1
+	rb, err := unmarshalRoaring(newRoaringFromWords(words).marshal())
+	if err != nil {
+		t.Fatalf("unmarshal shouldn't error out, error: %v", err)
+	}
+	if len(rb.containers) != 2 || rb.containers[0].bitmap == nil || rb.containers[1].array == nil {
+		t.Errorf("dense chunks should be bitmaps and sparse chunks arrays")
+	}
+	decoded, _ := rb.words(uint64(len(words) * wordSize))
+	for i := range words {
+		if decoded[i] != words[i] {
+			t.Fatalf("word %d should be %x, got %x", i, words[i], decoded[i])
+		}
+	}
+	if _, err := rb.words(1 << 16); err == nil {
+		t.Errorf("values out of the bitset should error out")
+	}
+
+	// {5, ..., 9} in a run container
+	runs := binary.LittleEndian.AppendUint32(nil, roaringRunCookie)
+	runs = append(runs, 1, 0, 0, 4, 0, 1, 0, 5, 0, 4, 0)
+	rb, err = unmarshalRoaring(runs)
+	if err != nil {
+		t.Fatalf("unmarshal of run containers shouldn't error out, error: %v", err)
+	}
+	if decoded, _ := rb.words(64); decoded[0] != 0x3e0 {
+		t.Errorf("run should be decoded as bits 5 to 9, got %x", decoded[0])
+	}
+	if _, err := unmarshalRoaring(runs[:len(runs)-1]); err == nil {
+		t.Errorf("truncated bitmap should error out")
+	}
+	if _, err := unmarshalRoaring([]byte{1, 2, 3, 4, 0, 0, 0, 0}); err == nil {
+		t.Errorf("unknown cookie should error out")
+	}
+}
+
+func TestBloomFilterExportRoaring(t *testing.T) {
+	filter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		filter.InsertString(strconv.Itoa(i))
+	}
+	words, err := filter.ExportWords()
+	if err != nil || uint64(len(words)) != wordsFor(uint64(filter.GetCap())) {
+		t.Fatalf("words of the bitset should be exported, got %d, error: %v", len(words), err)
+	}
+	data, err := filter.ExportRoaring()
+	if err != nil {
+		t.Fatalf("roaring export shouldn't error out, error: %v", err)
+	}
+	imported, err := NewMemBloomFilterFromRoaring(data, filter.GetCap(), filter.GetNumHashes())
+	if err != nil {
+		t.Fatalf("roaring import shouldn't error out, error: %v", err)
+	}
+	if equal, _ := filter.Equals(imported); !equal {
+		t.Errorf("imported filter should equal the exported one")
+	}
+	for i := 0; i < 500; i++ {
+		if !imported.LookupString(strconv.Itoa(i)) {
+			t.Errorf("%d should be found in the imported filter", i)
+		}
+	}
+	if _, err := NewMemBloomFilterFromRoaring(data, 64, 3); err == nil {
+		t.Errorf("import in a smaller filter should error out")
+	}
+
+	initMockRedis()
+	redisFilter, err := NewRedisBloomFilterFromRoaring(data, filter.GetCap(), filter.GetNumHashes())
+	if err != nil {
+		t.Fatalf("roaring import in redis shouldn't error out, error: %v", err)
+	}
+	if !redisFilter.LookupString("42") || redisFilter.LookupString("foo") {
+		t.Errorf("redis filter should hold the bits of the bitmap")
+	}
+	redisData, _ := redisFilter.ExportRoaring()
+	if !bytes.Equal(redisData, data) {
+		t.Errorf("roaring export of the redis filter should equal the in-memory one")
+	}
+}
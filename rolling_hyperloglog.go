@@ -0,0 +1,316 @@
+/*
+Implements the rolling hyperloglog used in estimating the unique entries of the last
+intervals of a stream, like the hourly or daily uniques.
+
+Rolling HyperLogLog: keeps a HyperLogLog per time interval, for a fixed number of the
+latest intervals. The count of distinct elements over several intervals is estimated by
+merging their hyperloglogs, so an element seen in several of them is counted once. The
+hyperloglogs of the intervals older than the retention are dropped as the time goes on.
+
+The in-memory data structure is thread-safe.
+*/
+package gostatix
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// RollingHyperLogLog struct. This is an in-memory implementation of a rolling HyperLogLog
+// counting the distinct elements of the last _retention_ intervals of _interval_ each.
+// Intervals are aligned on the Unix epoch, e.g. an interval of an hour starts on the hour.
+// _numRegisters_ is the number of registers of the hyperloglog of each interval
+// _slots_ holds the hyperloglogs of the intervals, the one of interval _i_ at _i_ modulo
+// _retention_
+// _latest_ is the latest interval updated
+// _lock_ is used to synchronize concurrent read/writes
+type RollingHyperLogLog struct {
+	numRegisters uint64
+	interval     time.Duration
+	retention    int
+	slots        []*rollingInterval
+	latest       int64
+	lock         sync.RWMutex
+}
+
+// rollingInterval is the hyperloglog _hll_ of the interval _index_
+type rollingInterval struct {
+	index int64
+	hll   *HyperLogLog
+}
+
+type rollingHyperLogLogJSON struct {
+	NumRegisters uint64                `json:"nr"`
+	Interval     int64                 `json:"i"`
+	Retention    int                   `json:"r"`
+	Intervals    []rollingIntervalJSON `json:"s"`
+	Latest       int64                 `json:"l"`
+}
+
+type rollingIntervalJSON struct {
+	Index     int64   `json:"i"`
+	Registers []uint8 `json:"r"`
+}
+
+// NewRollingHyperLogLog creates new RollingHyperLogLog keeping a HyperLogLog with
+// _numRegisters_ registers for each of the last _retention_ intervals of _interval_
+func NewRollingHyperLogLog(numRegisters uint64, interval time.Duration, retention int) (*RollingHyperLogLog, error) {
+	err := HyperLogLogParams{numRegisters}.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = checkRollingHyperLogLogParams(interval, retention)
+	if err != nil {
+		return nil, err
+	}
+	return &RollingHyperLogLog{
+		numRegisters: numRegisters,
+		interval:     interval,
+		retention:    retention,
+		slots:        make([]*rollingInterval, retention),
+	}, nil
+}
+
+// checkRollingHyperLogLogParams returns an error if a rolling hyperloglog can't be created
+// with _interval_ and _retention_
+func checkRollingHyperLogLogParams(interval time.Duration, retention int) error {
+	if interval <= 0 {
+		return fmt.Errorf("gostatix: rolling hyperloglog interval %v should be positive", interval)
+	}
+	if retention <= 0 {
+		return fmt.Errorf("gostatix: rolling hyperloglog retention %d should be positive", retention)
+	}
+	return checkSnapshotSize("rolling hyperloglog", uint64(retention), 8)
+}
+
+// NumRegisters returns the number of registers of the hyperloglog of each interval
+func (h *RollingHyperLogLog) NumRegisters() uint64 {
+	return h.numRegisters
+}
+
+// Interval returns the duration of an interval of the RollingHyperLogLog
+func (h *RollingHyperLogLog) Interval() time.Duration {
+	return h.interval
+}
+
+// Retention returns the number of intervals kept by the RollingHyperLogLog
+func (h *RollingHyperLogLog) Retention() int {
+	return h.retention
+}
+
+// Accuracy returns the accuracy of the counts of the RollingHyperLogLog, the one of a
+// HyperLogLog with the same number of registers
+func (h *RollingHyperLogLog) Accuracy() float64 {
+	abstractLog := AbstractHyperLogLog{numRegisters: h.numRegisters}
+	return abstractLog.Accuracy()
+}
+
+// intervalIndex returns the index of the interval of _t_
+func (h *RollingHyperLogLog) intervalIndex(t time.Time) int64 {
+	nanos := t.UnixNano()
+	index := nanos / int64(h.interval)
+	if nanos < 0 && nanos%int64(h.interval) != 0 {
+		index--
+	}
+	return index
+}
+
+// slot returns the position of the interval _index_ in the slots
+func (h *RollingHyperLogLog) slot(index int64) int {
+	slot := int(index % int64(h.retention))
+	if slot < 0 {
+		slot += h.retention
+	}
+	return slot
+}
+
+// Update adds _data_ to the current interval of the RollingHyperLogLog
+func (h *RollingHyperLogLog) Update(data []byte) {
+	h.UpdateAt(time.Now(), data)
+}
+
+// UpdateString adds _data_ to the current interval of the RollingHyperLogLog
+func (h *RollingHyperLogLog) UpdateString(data string) {
+	h.Update([]byte(data))
+}
+
+// UpdateAt adds _data_ to the interval of _t_, e.g. to count the elements of a stream by
+// their own timestamps. Elements older than the retention, counting back from the latest
+// interval updated, are dropped. Updating a new interval drops the intervals which are
+// no longer retained.
+func (h *RollingHyperLogLog) UpdateAt(t time.Time, data []byte) {
+	index := h.intervalIndex(t)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if index <= h.latest-int64(h.retention) {
+		return
+	}
+	if index > h.latest {
+		h.latest = index
+		h.prune()
+	}
+	slot := h.slot(index)
+	current := h.slots[slot]
+	if current == nil || current.index != index {
+		hll, _ := NewHyperLogLog(h.numRegisters)
+		current = &rollingInterval{index, hll}
+		h.slots[slot] = current
+	}
+	current.hll.Update(data)
+}
+
+// prune drops the intervals older than the retention
+func (h *RollingHyperLogLog) prune() {
+	for i, current := range h.slots {
+		if current != nil && current.index <= h.latest-int64(h.retention) {
+			h.slots[i] = nil
+		}
+	}
+}
+
+// CountLast returns the number of distinct elements of the last _n_ intervals, the
+// current one included
+// _withCorrection_ and _withRoundingOff_ are passed to HyperLogLog.Count
+func (h *RollingHyperLogLog) CountLast(n int, withCorrection, withRoundingOff bool) (uint64, error) {
+	return h.CountLastAt(time.Now(), n, withCorrection, withRoundingOff)
+}
+
+// CountLastAt returns the number of distinct elements of the last _n_ intervals up to the
+// interval of _now_ included
+func (h *RollingHyperLogLog) CountLastAt(now time.Time, n int, withCorrection, withRoundingOff bool) (uint64, error) {
+	merged, err := h.MergeLastAt(now, n)
+	if err != nil {
+		return 0, err
+	}
+	return merged.Count(withCorrection, withRoundingOff), nil
+}
+
+// MergeLast returns a new HyperLogLog holding the distinct elements of the last _n_
+// intervals, the current one included, e.g. to keep the uniques of a day
+func (h *RollingHyperLogLog) MergeLast(n int) (*HyperLogLog, error) {
+	return h.MergeLastAt(time.Now(), n)
+}
+
+// MergeLastAt returns a new HyperLogLog holding the distinct elements of the last _n_
+// intervals up to the interval of _now_ included
+func (h *RollingHyperLogLog) MergeLastAt(now time.Time, n int) (*HyperLogLog, error) {
+	if n <= 0 || n > h.retention {
+		return nil, fmt.Errorf("gostatix: number of intervals %d should be between 1 and the retention %d", n, h.retention)
+	}
+	last := h.intervalIndex(now)
+	merged, err := NewHyperLogLog(h.numRegisters)
+	if err != nil {
+		return nil, err
+	}
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	for _, current := range h.slots {
+		if current != nil && current.index <= last && current.index > last-int64(n) {
+			err = merged.Merge(current.hll)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return merged, nil
+}
+
+// Reset drops all the intervals of the RollingHyperLogLog
+func (h *RollingHyperLogLog) Reset() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for i := range h.slots {
+		h.slots[i] = nil
+	}
+	h.latest = 0
+}
+
+// MemoryUsage returns the estimated number of bytes used in-process by the
+// RollingHyperLogLog
+func (h *RollingHyperLogLog) MemoryUsage() uint64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	usage := uint64(unsafe.Sizeof(*h)) + uint64(cap(h.slots))*uint64(unsafe.Sizeof(h.slots[0]))
+	for _, current := range h.slots {
+		if current != nil {
+			usage += uint64(unsafe.Sizeof(*current)) + current.hll.MemoryUsage()
+		}
+	}
+	return usage
+}
+
+// Export JSON marshals the RollingHyperLogLog and returns a byte slice containing the data
+func (h *RollingHyperLogLog) Export() ([]byte, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	intervals := make([]rollingIntervalJSON, 0, len(h.slots))
+	for _, current := range h.slots {
+		if current == nil {
+			continue
+		}
+		current.hll.lock.RLock()
+		registers := append([]uint8(nil), current.hll.registers...)
+		current.hll.lock.RUnlock()
+		intervals = append(intervals, rollingIntervalJSON{current.index, registers})
+	}
+	return json.Marshal(rollingHyperLogLogJSON{h.numRegisters, int64(h.interval), h.retention, intervals, h.latest})
+}
+
+// Import JSON unmarshals the _data_ into the RollingHyperLogLog
+func (h *RollingHyperLogLog) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
+	var g rollingHyperLogLogJSON
+	err = json.Unmarshal(data, &g)
+	if err != nil {
+		return err
+	}
+	err = HyperLogLogParams{g.NumRegisters}.Validate()
+	if err == nil {
+		err = checkRollingHyperLogLogParams(time.Duration(g.Interval), g.Retention)
+	}
+	if err != nil {
+		return fmt.Errorf("gostatix: invalid rolling hyperloglog snapshot, error: %v", err)
+	}
+	imported := &RollingHyperLogLog{
+		numRegisters: g.NumRegisters,
+		interval:     time.Duration(g.Interval),
+		retention:    g.Retention,
+		slots:        make([]*rollingInterval, g.Retention),
+		latest:       g.Latest,
+	}
+	for _, interval := range g.Intervals {
+		if uint64(len(interval.Registers)) != g.NumRegisters {
+			return fmt.Errorf("gostatix: invalid rolling hyperloglog snapshot, %d registers found instead of %d", len(interval.Registers), g.NumRegisters)
+		}
+		if interval.Index > g.Latest || interval.Index <= g.Latest-int64(g.Retention) {
+			return fmt.Errorf("gostatix: invalid rolling hyperloglog snapshot, interval %d isn't retained", interval.Index)
+		}
+		slot := imported.slot(interval.Index)
+		if imported.slots[slot] != nil {
+			return fmt.Errorf("gostatix: invalid rolling hyperloglog snapshot, interval %d is duplicated", interval.Index)
+		}
+		hll, _ := NewHyperLogLog(g.NumRegisters)
+		hll.registers = interval.Registers
+		imported.slots[slot] = &rollingInterval{interval.Index, hll}
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.numRegisters = imported.numRegisters
+	h.interval = imported.interval
+	h.retention = imported.retention
+	h.slots = imported.slots
+	h.latest = imported.latest
+	return nil
+}
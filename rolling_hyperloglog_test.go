@@ -0,0 +1,151 @@
+package gostatix
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// referenceHyperLogLog returns the merge of the hyperloglogs of the users of each hour in
+// [from, to) of TestRollingHyperLogLogCountLast
+func referenceHyperLogLog(from, to int) *HyperLogLog {
+	h, _ := NewHyperLogLog(1024)
+	for hour := from; hour < to; hour++ {
+		g, _ := NewHyperLogLog(1024)
+		for i := 0; i < 1000; i++ {
+			g.Update([]byte(strconv.Itoa(hour*500 + i)))
+		}
+		h.Merge(g)
+	}
+	return h
+}
+
+func TestRollingHyperLogLogCountLast(t *testing.T) {
+	h, err := NewRollingHyperLogLog(1024, time.Hour, 24)
+	if err != nil {
+		t.Fatalf("creation shouldn't error out, error: %v", err)
+	}
+	start := time.Unix(1700000000, 0).Truncate(time.Hour)
+	// 1000 distinct users per hour for 30 hours, half of them seen the hour before
+	for hour := 0; hour < 30; hour++ {
+		at := start.Add(time.Duration(hour) * time.Hour)
+		for i := 0; i < 1000; i++ {
+			h.UpdateAt(at, []byte(strconv.Itoa(hour*500+i)))
+		}
+	}
+	now := start.Add(29 * time.Hour)
+	for _, n := range []int{1, 2, 24} {
+		merged, err := h.MergeLastAt(now, n)
+		if err != nil {
+			t.Fatalf("merge shouldn't error out, error: %v", err)
+		}
+		if equal, _ := merged.Equals(referenceHyperLogLog(30-n, 30)); !equal {
+			t.Errorf("merge of last %d intervals should equal the merge of their hyperloglogs", n)
+		}
+		count, _ := h.CountLastAt(now, n, true, true)
+		if expected := referenceHyperLogLog(30-n, 30).Count(true, true); count != expected {
+			t.Errorf("count of last %d intervals should be %d, got %d", n, expected, count)
+		}
+	}
+	if merged, _ := h.MergeLastAt(now.Add(-time.Hour), 2); !mustEqual(merged, referenceHyperLogLog(27, 29)) {
+		t.Errorf("merge should only include the intervals up to now")
+	}
+	retained := 0
+	for _, current := range h.slots {
+		if current != nil {
+			retained++
+		}
+	}
+	if retained != 24 {
+		t.Errorf("24 intervals should be retained, got %d", retained)
+	}
+	if merged, _ := h.MergeLastAt(now.Add(48*time.Hour), 24); !mustEqual(merged, referenceHyperLogLog(0, 0)) {
+		t.Errorf("intervals older than the retention shouldn't be merged")
+	}
+	if _, err := h.CountLastAt(now, 25, true, true); err == nil {
+		t.Errorf("count of more intervals than the retention should error out")
+	}
+	if _, err := h.CountLastAt(now, 0, true, true); err == nil {
+		t.Errorf("count of 0 intervals should error out")
+	}
+}
+
+func mustEqual(h, g *HyperLogLog) bool {
+	equal, _ := h.Equals(g)
+	return equal
+}
+
+func TestRollingHyperLogLogPrune(t *testing.T) {
+	h, _ := NewRollingHyperLogLog(16, time.Minute, 3)
+	start := time.Unix(1700000000, 0).Truncate(time.Minute)
+	h.UpdateAt(start, []byte("a"))
+	h.UpdateAt(start.Add(5*time.Minute), []byte("b"))
+	for _, current := range h.slots {
+		if current != nil && current.index != h.intervalIndex(start.Add(5*time.Minute)) {
+			t.Errorf("intervals older than the retention should be dropped, found %d", current.index)
+		}
+	}
+	h.UpdateAt(start, []byte("c"))
+	if current := h.slots[h.slot(h.intervalIndex(start))]; current != nil {
+		t.Errorf("updates older than the retention should be dropped")
+	}
+	h.UpdateAt(start.Add(4*time.Minute), []byte("d"))
+	expected, _ := NewHyperLogLog(16)
+	for _, data := range []string{"b", "d"} {
+		g, _ := NewHyperLogLog(16)
+		g.UpdateString(data)
+		expected.Merge(g)
+	}
+	if merged, _ := h.MergeLastAt(start.Add(5*time.Minute), 2); !mustEqual(merged, expected) {
+		t.Errorf("late updates within the retention should be merged")
+	}
+	h.Reset()
+	empty, _ := NewHyperLogLog(16)
+	if merged, _ := h.MergeLastAt(start.Add(5*time.Minute), 3); !mustEqual(merged, empty) {
+		t.Errorf("merge should be empty after a reset")
+	}
+	if _, err := NewRollingHyperLogLog(16, 0, 3); err == nil {
+		t.Errorf("creation with a zero interval should error out")
+	}
+	if _, err := NewRollingHyperLogLog(16, time.Minute, 0); err == nil {
+		t.Errorf("creation with a zero retention should error out")
+	}
+	if _, err := NewRollingHyperLogLog(10, time.Minute, 3); err == nil {
+		t.Errorf("creation with invalid registers should error out")
+	}
+}
+
+func TestRollingHyperLogLogExportImport(t *testing.T) {
+	h, _ := NewRollingHyperLogLog(64, time.Hour, 4)
+	start := time.Unix(1700000000, 0).Truncate(time.Hour)
+	for hour := 0; hour < 6; hour++ {
+		for i := 0; i < 20; i++ {
+			h.UpdateAt(start.Add(time.Duration(hour)*time.Hour), []byte(strconv.Itoa(hour*20+i)))
+		}
+	}
+	data, err := h.Export()
+	if err != nil {
+		t.Fatalf("export shouldn't error out, error: %v", err)
+	}
+	g, _ := NewRollingHyperLogLog(16, time.Minute, 1)
+	if err := g.Import(data); err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	if g.NumRegisters() != 64 || g.Interval() != time.Hour || g.Retention() != 4 {
+		t.Errorf("parameters should be imported, got %d, %v, %d", g.NumRegisters(), g.Interval(), g.Retention())
+	}
+	now := start.Add(5 * time.Hour)
+	for n := 1; n <= 4; n++ {
+		expected, _ := h.CountLastAt(now, n, true, true)
+		count, _ := g.CountLastAt(now, n, true, true)
+		if count != expected {
+			t.Errorf("imported count of last %d intervals should be %d, got %d", n, expected, count)
+		}
+	}
+	if err := g.Import([]byte(`{"nr":64,"i":3600000000000,"r":4,"s":[{"i":1,"r":"AA=="}],"l":1}`)); err == nil {
+		t.Errorf("import of intervals with the wrong number of registers should error out")
+	}
+	if err := g.Import([]byte(`{"nr":64,"i":0,"r":4,"s":[],"l":1}`)); err == nil {
+		t.Errorf("import of a zero interval should error out")
+	}
+}
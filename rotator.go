@@ -0,0 +1,458 @@
+/*
+Implements the rotation of the membership filters of rolling windows without downtime.
+
+A Rotator keeps an active filter and, for a grace window after each rotation, the
+previous one. During the grace window the inserts go to both filters and the lookups
+consult both, so the elements inserted just before a rotation are still found, and the
+processes which haven't switched to the new filter yet still see the new elements. Once the
+grace window is over, the previous filter is retired: it's closed and left out.
+
+A Redis rotator names its filters in a Redis hash, so all the processes sharing its name
+switch to the same filters. The process rotating creates the new filter under a short lock
+and swaps the names in the hash, the others pick them up at their next rotation or Sync.
+*/
+package gostatix
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kwertop/gostatix/internal/util"
+	"github.com/redis/go-redis/v9"
+)
+
+// rotatorLockTTL is the expiry of the lock taken on a Redis rotator while the new filter is
+// created and named, released once the names are swapped
+const rotatorLockTTL = 10 * time.Second
+
+// Rotator rotates the MembershipFilter of a rolling window.
+// _newFilter_ creates the filter made active by each rotation
+// _interval_ is the time between two rotations, 0 if the rotations are only made by Rotate
+// _grace_ is the time during which the previous filter is kept after a rotation
+// _active_ and _previous_ are the current filters, _previous_ is nil out of grace windows
+// _rotatedAt_ is the time of the last rotation
+// _pointerKey_ is the Redis hash naming the filters of a Redis rotator, empty otherwise
+// _onRetire_ is called with each filter retired, before it's closed
+type Rotator struct {
+	newFilter  func() (MembershipFilter, error)
+	interval   time.Duration
+	grace      time.Duration
+	active     MembershipFilter
+	previous   MembershipFilter
+	rotatedAt  time.Time
+	pointerKey string
+	onRetire   func(MembershipFilter)
+	lock       sync.RWMutex
+}
+
+// rotatorState is the state of a Redis rotator saved in its hash
+type rotatorState struct {
+	active    string
+	previous  string
+	rotatedAt time.Time
+}
+
+// NewRotator creates a Rotator whose filters are created by _newFilter_, making the first
+// one active. The filters are rotated every _interval_, or only by Rotate if it's 0, and
+// the previous filter is kept for _grace_ after each rotation. _grace_ should be shorter
+// than _interval_.
+func NewRotator(newFilter func() (MembershipFilter, error), interval, grace time.Duration) (*Rotator, error) {
+	return NewRotatorAt(time.Now(), newFilter, interval, grace)
+}
+
+// NewRotatorAt is NewRotator with the first filter made active at _now_
+func NewRotatorAt(now time.Time, newFilter func() (MembershipFilter, error), interval, grace time.Duration) (*Rotator, error) {
+	err := checkRotatorParams(newFilter, interval, grace)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := newFilter()
+	if err != nil {
+		return nil, err
+	}
+	return &Rotator{newFilter: newFilter, interval: interval, grace: grace, active: filter, rotatedAt: now}, nil
+}
+
+// NewRedisRotator creates or opens the Redis rotator _name_, whose filters are Redis backed
+// filters created by _newFilter_. The metadata keys of the active and previous filters and
+// the time of the last rotation are saved in the Redis hash _name_, so that the rotators of
+// all the processes sharing the name use the same filters. If the hash doesn't exist, a
+// first filter is created and made active. _interval_ and _grace_ are like in NewRotator
+// and should be the same in all the processes.
+func NewRedisRotator(name string, newFilter func() (MembershipFilter, error), interval, grace time.Duration) (*Rotator, error) {
+	return NewRedisRotatorAt(time.Now(), name, newFilter, interval, grace)
+}
+
+// NewRedisRotatorAt is NewRedisRotator with the first filter made active at _now_ if the
+// hash doesn't exist
+func NewRedisRotatorAt(now time.Time, name string, newFilter func() (MembershipFilter, error), interval, grace time.Duration) (*Rotator, error) {
+	if name == "" {
+		return nil, fmt.Errorf("gostatix: redis rotator name can't be empty")
+	}
+	err := checkRotatorParams(newFilter, interval, grace)
+	if err != nil {
+		return nil, err
+	}
+	r := &Rotator{newFilter: newFilter, interval: interval, grace: grace, pointerKey: name}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	err = r.rotateRedis(now, true)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// checkRotatorParams validates the parameters of a Rotator
+func checkRotatorParams(newFilter func() (MembershipFilter, error), interval, grace time.Duration) error {
+	if newFilter == nil {
+		return fmt.Errorf("gostatix: rotator filter constructor can't be nil")
+	}
+	if interval < 0 || grace < 0 {
+		return fmt.Errorf("gostatix: rotator interval %v and grace %v can't be negative", interval, grace)
+	}
+	if interval > 0 && grace >= interval {
+		return fmt.Errorf("gostatix: rotator grace %v should be shorter than its interval %v", grace, interval)
+	}
+	return nil
+}
+
+// SetRetireHook sets _fn_ to be called with each filter retired by the Rotator before it's
+// closed, e.g. to export it or to delete its Redis keys. The filters of a Redis rotator are
+// retired by all the processes sharing it.
+func (r *Rotator) SetRetireHook(fn func(filter MembershipFilter)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.onRetire = fn
+}
+
+// Insert writes _data_ in the active filter, and in the previous one during a grace window
+func (r *Rotator) Insert(data []byte) error {
+	return r.InsertAt(time.Now(), data)
+}
+
+// InsertString writes _data_ (string) like Insert
+func (r *Rotator) InsertString(data string) error {
+	return r.InsertAt(time.Now(), []byte(data))
+}
+
+// InsertAt is Insert at _now_, rotating and retiring the filters which are due first
+func (r *Rotator) InsertAt(now time.Time, data []byte) error {
+	active, previous, err := r.filtersAt(now)
+	if err != nil {
+		return err
+	}
+	err = active.Insert(data)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		return previous.Insert(data)
+	}
+	return nil
+}
+
+// Lookup returns true if _data_ is found in the active filter, or in the previous one
+// during a grace window
+func (r *Rotator) Lookup(data []byte) (bool, error) {
+	return r.LookupAt(time.Now(), data)
+}
+
+// LookupString looks _data_ (string) up like Lookup
+func (r *Rotator) LookupString(data string) (bool, error) {
+	return r.LookupAt(time.Now(), []byte(data))
+}
+
+// LookupAt is Lookup at _now_, rotating and retiring the filters which are due first
+func (r *Rotator) LookupAt(now time.Time, data []byte) (bool, error) {
+	active, previous, err := r.filtersAt(now)
+	if err != nil {
+		return false, err
+	}
+	found, err := active.Lookup(data)
+	if err != nil || found || previous == nil {
+		return found, err
+	}
+	return previous.Lookup(data)
+}
+
+// Rotate makes a new filter active and keeps the active one as the previous filter for the
+// grace window, retiring the previous one if it's still kept. The next rotation is due an
+// interval later. A Redis rotator whose filters were rotated by another process since it
+// last synced switches to them instead.
+func (r *Rotator) Rotate() error {
+	return r.RotateAt(time.Now())
+}
+
+// RotateAt is Rotate at _now_
+func (r *Rotator) RotateAt(now time.Time) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.pointerKey != "" {
+		err := r.rotateRedis(now, true)
+		if err != nil {
+			return err
+		}
+		r.retireAt(now)
+		return nil
+	}
+	filter, err := r.newFilter()
+	if err != nil {
+		return err
+	}
+	r.retire(r.previous)
+	r.active, r.previous, r.rotatedAt = filter, r.active, now
+	r.retireAt(now)
+	return nil
+}
+
+// Sync switches a Redis rotator to the filters named in its hash, e.g. after another
+// process rotated them with Rotate. The rotations due at the interval are synced anyway.
+func (r *Rotator) Sync() error {
+	return r.SyncAt(time.Now())
+}
+
+// SyncAt is Sync at _now_
+func (r *Rotator) SyncAt(now time.Time) error {
+	if r.pointerKey == "" {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	state, err := r.readState(context.Background())
+	if err != nil {
+		return err
+	}
+	err = r.apply(state)
+	if err != nil {
+		return err
+	}
+	r.retireAt(now)
+	return nil
+}
+
+// Active returns the active filter
+func (r *Rotator) Active() MembershipFilter {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.active
+}
+
+// Previous returns the previous filter during a grace window, nil otherwise
+func (r *Rotator) Previous() MembershipFilter {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.previous
+}
+
+// RotatedAt returns the time of the last rotation
+func (r *Rotator) RotatedAt() time.Time {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.rotatedAt
+}
+
+// Close closes the active and previous filters. The hash of a Redis rotator is left in
+// Redis.
+func (r *Rotator) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var err error
+	for _, filter := range []MembershipFilter{r.active, r.previous} {
+		if filter != nil {
+			if closeErr := filter.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	r.previous = nil
+	return err
+}
+
+// filtersAt returns the active and previous filters at _now_, after rotating them if a
+// rotation is due and retiring the previous one if its grace window is over
+func (r *Rotator) filtersAt(now time.Time) (MembershipFilter, MembershipFilter, error) {
+	r.lock.RLock()
+	if !r.dueAt(now) {
+		defer r.lock.RUnlock()
+		return r.active, r.previous, nil
+	}
+	r.lock.RUnlock()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.interval > 0 && !now.Before(r.rotatedAt.Add(r.interval)) {
+		var err error
+		if r.pointerKey != "" {
+			err = r.rotateRedis(now, false)
+		} else {
+			err = r.rotateLocal(now)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	r.retireAt(now)
+	return r.active, r.previous, nil
+}
+
+// dueAt returns true if a rotation is due at _now_ or if the grace window of the previous
+// filter is over
+func (r *Rotator) dueAt(now time.Time) bool {
+	if r.interval > 0 && !now.Before(r.rotatedAt.Add(r.interval)) {
+		return true
+	}
+	return r.previous != nil && !now.Before(r.rotatedAt.Add(r.grace))
+}
+
+// rotateLocal rotates the filters of a local rotator, as many intervals as elapsed since
+// the last rotation being skipped at once
+func (r *Rotator) rotateLocal(now time.Time) error {
+	filter, err := r.newFilter()
+	if err != nil {
+		return err
+	}
+	rotatedAt := r.rotatedAt.Add(now.Sub(r.rotatedAt) / r.interval * r.interval)
+	r.retire(r.previous)
+	r.active, r.previous, r.rotatedAt = filter, r.active, rotatedAt
+	return nil
+}
+
+// retireAt retires the previous filter if its grace window is over at _now_
+func (r *Rotator) retireAt(now time.Time) {
+	if r.previous != nil && !now.Before(r.rotatedAt.Add(r.grace)) {
+		r.retire(r.previous)
+		r.previous = nil
+	}
+}
+
+// retire calls the retire hook with _filter_ and closes it, if it isn't nil
+func (r *Rotator) retire(filter MembershipFilter) {
+	if filter == nil {
+		return
+	}
+	if r.onRetire != nil {
+		r.onRetire(filter)
+	}
+	filter.Close()
+}
+
+// rotateRedis rotates the filters of a Redis rotator under the lock of its hash, at _now_ if
+// _forced_ or at the due time of the rotation otherwise. If the hash names other filters
+// than the local ones, which were rotated by another process, or if the lock is held by
+// another process, the rotator switches to the filters of the hash instead.
+func (r *Rotator) rotateRedis(now time.Time, forced bool) error {
+	ctx := context.Background()
+	client := getRedisClient()
+	lockKey := r.pointerKey + ":lock"
+	token := util.GenerateRandomString(16)
+	locked, err := client.SetNX(ctx, lockKey, token, rotatorLockTTL).Result()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while locking rotator %s, error: %v", r.pointerKey, err)
+	}
+	if locked {
+		defer rotatorUnlockScript.Run(ctx, client, []string{lockKey}, token)
+	}
+	state, err := r.readState(ctx)
+	if err != nil {
+		return err
+	}
+	stale := r.active == nil || state.active != membershipFilterKey(r.active)
+	if !locked || (state.active != "" && (stale || !r.rotationDue(state, now, forced))) {
+		if state.active == "" {
+			return fmt.Errorf("gostatix: rotator %s is being created by another process", r.pointerKey)
+		}
+		return r.apply(state)
+	}
+	filter, err := r.newFilter()
+	if err != nil {
+		return err
+	}
+	key := membershipFilterKey(filter)
+	if key == "" {
+		filter.Close()
+		return fmt.Errorf("gostatix: filters of redis rotator %s should be redis backed, got %T", r.pointerKey, UnwrapMembershipFilter(filter))
+	}
+	rotatedAt := now
+	if !forced && state.active != "" {
+		rotatedAt = state.rotatedAt.Add(now.Sub(state.rotatedAt) / r.interval * r.interval)
+	}
+	// the time is saved in milliseconds, the other processes read it back as such
+	rotatedAt = time.UnixMilli(rotatedAt.UnixMilli())
+	err = client.HSet(ctx, r.pointerKey, "active", key, "previous", state.active, "rotatedAt", rotatedAt.UnixMilli()).Err()
+	if err != nil {
+		filter.Close()
+		return fmt.Errorf("gostatix: error while saving rotator %s, error: %v", r.pointerKey, err)
+	}
+	r.retire(r.previous)
+	r.active, r.previous, r.rotatedAt = filter, r.active, rotatedAt
+	return nil
+}
+
+// rotationDue returns true if the filters named by _state_ are to be rotated at _now_
+func (r *Rotator) rotationDue(state rotatorState, now time.Time, forced bool) bool {
+	return forced || (r.interval > 0 && !now.Before(state.rotatedAt.Add(r.interval)))
+}
+
+// rotatorUnlockScript deletes the lock at KEYS[1] if it's still held with the token ARGV[1]
+var rotatorUnlockScript = redis.NewScript(`
+	if redis.call('GET', KEYS[1]) == ARGV[1] then
+		return redis.call('DEL', KEYS[1])
+	end
+	return 0
+`)
+
+// readState reads the hash of a Redis rotator, whose fields are empty if it doesn't exist
+func (r *Rotator) readState(ctx context.Context) (rotatorState, error) {
+	values, err := getRedisClient().HGetAll(ctx, r.pointerKey).Result()
+	if err != nil {
+		return rotatorState{}, fmt.Errorf("gostatix: error while reading rotator %s, error: %v", r.pointerKey, err)
+	}
+	state := rotatorState{active: values["active"], previous: values["previous"]}
+	if state.active == "" {
+		return state, nil
+	}
+	rotatedAt, err := strconv.ParseInt(values["rotatedAt"], 10, 64)
+	if err != nil {
+		return rotatorState{}, fmt.Errorf("gostatix: invalid rotation time %q of rotator %s", values["rotatedAt"], r.pointerKey)
+	}
+	state.rotatedAt = time.UnixMilli(rotatedAt)
+	return state, nil
+}
+
+// apply switches a Redis rotator to the filters named by _state_, keeping the local filters
+// it still names and retiring the others
+func (r *Rotator) apply(state rotatorState) error {
+	local := make(map[string]MembershipFilter, 2)
+	for _, filter := range []MembershipFilter{r.active, r.previous} {
+		if filter != nil {
+			local[membershipFilterKey(filter)] = filter
+		}
+	}
+	load := func(key string) (MembershipFilter, error) {
+		if key == "" {
+			return nil, nil
+		}
+		if filter, ok := local[key]; ok {
+			delete(local, key)
+			return filter, nil
+		}
+		return LoadMembershipFilter(key)
+	}
+	active, err := load(state.active)
+	if err != nil {
+		return err
+	}
+	previous, err := load(state.previous)
+	if err != nil {
+		// the previous filter may have been retired and deleted by another process
+		previous = nil
+	}
+	for _, filter := range local {
+		r.retire(filter)
+	}
+	r.active, r.previous, r.rotatedAt = active, previous, state.rotatedAt
+	return nil
+}
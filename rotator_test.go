@@ -0,0 +1,122 @@
+package gostatix
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRotator(t *testing.T) {
+	newFilter := func() (MembershipFilter, error) {
+		return NewMembershipFilter(MembershipFilterConfig{Kind: BloomFilterKind, NumItems: 1000, ErrorRate: 0.001})
+	}
+	now := time.Now()
+	rotator, err := NewRotatorAt(now, newFilter, time.Hour, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("rotator creation shouldn't error out, error: %v", err)
+	}
+	var retired []MembershipFilter
+	rotator.SetRetireHook(func(filter MembershipFilter) {
+		retired = append(retired, filter)
+	})
+	first := rotator.Active()
+	rotator.InsertAt(now, []byte("cat"))
+	rotator.InsertAt(now.Add(time.Hour), []byte("dog"))
+	if rotator.Active() == first || rotator.Previous() != first {
+		t.Fatalf("filters should be rotated after an hour")
+	}
+	// dog is written to both filters during the grace window
+	for _, name := range []string{"cat", "dog"} {
+		if ok, _ := rotator.LookupAt(now.Add(time.Hour+5*time.Minute), []byte(name)); !ok {
+			t.Errorf("%s should be found during the grace window", name)
+		}
+	}
+	if ok, _ := rotator.LookupAt(now.Add(time.Hour+10*time.Minute), []byte("cat")); ok {
+		t.Errorf("cat shouldn't be found after the grace window")
+	}
+	if ok, _ := rotator.LookupAt(now.Add(time.Hour+10*time.Minute), []byte("dog")); !ok {
+		t.Errorf("dog should be found in the active filter")
+	}
+	if len(retired) != 1 || retired[0] != first || rotator.Previous() != nil {
+		t.Errorf("first filter should be retired after the grace window")
+	}
+
+	// the rotations skip the intervals without accesses
+	rotator.InsertAt(now.Add(5*time.Hour+30*time.Minute), []byte("owl"))
+	if !rotator.RotatedAt().Equal(now.Add(5 * time.Hour)) {
+		t.Errorf("last rotation should be at the 5th interval, got %v", rotator.RotatedAt())
+	}
+	if ok, _ := rotator.LookupAt(now.Add(5*time.Hour+30*time.Minute), []byte("dog")); ok {
+		t.Errorf("dog shouldn't be found once its filter is retired")
+	}
+	err = rotator.RotateAt(now.Add(5*time.Hour + 40*time.Minute))
+	if err != nil {
+		t.Fatalf("rotation shouldn't error out, error: %v", err)
+	}
+	if ok, _ := rotator.LookupAt(now.Add(5*time.Hour+45*time.Minute), []byte("owl")); !ok {
+		t.Errorf("owl should be found during the grace window of a forced rotation")
+	}
+
+	if _, err := NewRotator(newFilter, time.Minute, time.Hour); err == nil {
+		t.Errorf("rotator with a grace longer than its interval should error out")
+	}
+	if _, err := NewRotator(nil, time.Hour, 0); err == nil {
+		t.Errorf("rotator without filter constructor should error out")
+	}
+}
+
+func TestRedisRotator(t *testing.T) {
+	initMockRedis()
+	newFilter := func() (MembershipFilter, error) {
+		return NewMembershipFilter(MembershipFilterConfig{Backend: RedisBackend, Kind: CuckooFilterKind, NumItems: 1000, ErrorRate: 0.001})
+	}
+	now := time.Now()
+	a, err := NewRedisRotatorAt(now, "sessions", newFilter, time.Hour, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("redis rotator creation shouldn't error out, error: %v", err)
+	}
+	b, err := NewRedisRotatorAt(now, "sessions", newFilter, time.Hour, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("redis rotator opening shouldn't error out, error: %v", err)
+	}
+	if membershipFilterKey(a.Active()) != membershipFilterKey(b.Active()) {
+		t.Fatalf("rotators sharing a name should use the same filter")
+	}
+	a.InsertAt(now, []byte("cat"))
+	if ok, _ := b.LookupAt(now, []byte("cat")); !ok {
+		t.Errorf("cat inserted by a should be found by b")
+	}
+
+	// a rotates at the interval, b switches to the filter created by a
+	a.InsertAt(now.Add(time.Hour), []byte("dog"))
+	if ok, _ := b.LookupAt(now.Add(time.Hour+time.Minute), []byte("dog")); !ok {
+		t.Errorf("dog inserted by a after the rotation should be found by b")
+	}
+	if membershipFilterKey(a.Active()) != membershipFilterKey(b.Active()) || b.Previous() == nil {
+		t.Errorf("b should switch to the filters rotated by a")
+	}
+	if ok, _ := b.LookupAt(now.Add(time.Hour+time.Minute), []byte("cat")); !ok {
+		t.Errorf("cat should be found in the previous filter during the grace window")
+	}
+
+	// a forced rotation is picked up by Sync
+	a.RotateAt(now.Add(time.Hour + 20*time.Minute))
+	b.SyncAt(now.Add(time.Hour + 20*time.Minute))
+	if membershipFilterKey(a.Active()) != membershipFilterKey(b.Active()) {
+		t.Errorf("b should switch to the filter of the forced rotation after a sync")
+	}
+	if ok, _ := b.LookupAt(now.Add(time.Hour+21*time.Minute), []byte("dog")); !ok {
+		t.Errorf("dog should be found in the previous filter after the forced rotation")
+	}
+	if ok, _ := b.LookupAt(now.Add(time.Hour+21*time.Minute), []byte("cat")); ok {
+		t.Errorf("cat shouldn't be found once its filter is retired")
+	}
+
+	local := func() (MembershipFilter, error) {
+		return NewMembershipFilter(MembershipFilterConfig{Kind: BloomFilterKind, NumItems: 1000, ErrorRate: 0.001})
+	}
+	if _, err := NewRedisRotator("local", local, time.Hour, 0); err == nil {
+		t.Errorf("redis rotator of in-memory filters should error out")
+	}
+	a.Close()
+	b.Close()
+}
@@ -0,0 +1,104 @@
+package gostatix
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisScripts are all the Lua scripts used by the Redis backed data structures. They're
+// created once and loaded in Redis when the client is made, so that the operations only
+// send their SHA1 digests with EVALSHA. Script.Run sends the script body again if Redis
+// replies NOSCRIPT, e.g. after a restart or a SCRIPT FLUSH, which loads it back.
+var redisScripts = []*redis.Script{
+	apbfInsertScript,
+	apbfLookupScript,
+	bitRangeScript,
+	bitTruncateScript,
+	bucketIsFreeScript,
+	bucketAddScript,
+	bucketRemoveScript,
+	bucketExistsScript,
+	bucketEqualsScript,
+	bucketRecountScript,
+	cmsUpdateScript,
+	cmsUpdateCountScript,
+	cmsDecrementScript,
+	cmsResetScript,
+	cmsCountScript,
+	cmsEqualsScript,
+	cmsMergeScript,
+	cmsMergeSourceScript,
+	cmsSnapshotScript,
+	cmsCopyScript,
+	cmsSetMatrixScript,
+	cuckooResetScript,
+	cuckooInsertScript,
+	cuckooSnapshotScript,
+	cuckooPutScript,
+	cuckooGetScript,
+	cuckooPayloadDropScript,
+	cuckooInitScript,
+	cuckooRepairScript,
+	cuckooUnstampScript,
+	cuckooSweepScript,
+	hllUpdateScript,
+	hllUpdateMultiScript,
+	hllMergeScript,
+	hllEqualsScript,
+	hllMigrateScript,
+	reservoirAddScript,
+	reservoirMergeScript,
+	rotatorUnlockScript,
+	spectralAddScript,
+	spectralRemoveScript,
+	topKImportScript,
+	topKUpdateScript,
+	topKAdjustScript,
+	topKEqualsScript,
+	topKVerifyScript,
+	topKRescoreScript,
+}
+
+// loadScripts loads all the Lua scripts in Redis with a single pipeline
+func loadScripts(ctx context.Context, client *redis.Client) error {
+	pipe := client.Pipeline()
+	for _, script := range redisScripts {
+		script.Load(ctx, pipe)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("gostatix: error while loading lua scripts in redis, error: %v", err)
+	}
+	return nil
+}
+
+// execScriptPipeline runs the calls to _script_ queued by _queue_ with EVALSHA in a
+// single pipeline. If Redis doesn't have the script, none of the calls is executed, so
+// the script is loaded and the pipeline sent once more.
+func execScriptPipeline(ctx context.Context, script *redis.Script, queue func(pipe redis.Pipeliner)) error {
+	client := getRedisClient()
+	for attempt := 0; ; attempt++ {
+		pipe := client.Pipeline()
+		queue(pipe)
+		cmds, err := pipe.Exec(ctx)
+		if err == nil || attempt > 0 || !allNoScript(cmds) {
+			return err
+		}
+		err = script.Load(ctx, client).Err()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// allNoScript returns true if all the _cmds_ failed with a NOSCRIPT error
+func allNoScript(cmds []redis.Cmder) bool {
+	for _, cmd := range cmds {
+		if !redis.HasErrorPrefix(cmd.Err(), "NOSCRIPT") {
+			return false
+		}
+	}
+	return len(cmds) > 0
+}
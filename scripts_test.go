@@ -0,0 +1,48 @@
+package gostatix
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLoadScripts(t *testing.T) {
+	initMockRedis()
+	ctx := context.Background()
+	getRedisClient().ScriptFlush(ctx)
+	err := loadScripts(ctx, getRedisClient())
+	if err != nil {
+		t.Fatalf("loading scripts shouldn't error out, error: %v", err)
+	}
+	for _, script := range redisScripts {
+		exists, _ := script.Exists(ctx, getRedisClient()).Result()
+		if len(exists) != 1 || !exists[0] {
+			t.Errorf("script %s should be loaded", script.Hash())
+		}
+	}
+}
+
+func TestScriptPipelineAfterFlush(t *testing.T) {
+	initMockRedis()
+	ctx := context.Background()
+	cms, _ := NewCountMinSketchRedis(4, 100)
+	getRedisClient().ScriptFlush(ctx)
+	err := cms.writeBatch([]asyncWrite{{[]byte("foo"), 2}, {[]byte("bar"), 1}, {[]byte("foo"), 1}})
+	if err != nil {
+		t.Fatalf("batch write shouldn't error out after a script flush, error: %v", err)
+	}
+	count, _ := cms.Count([]byte("foo"))
+	if count != 3 {
+		t.Errorf("count of foo should be 3, found %d", count)
+	}
+	total, _ := cms.TotalCount()
+	if total != 4 {
+		t.Errorf("total count should be 4, found %d", total)
+	}
+
+	h, _ := NewHyperLogLogRedis(16)
+	getRedisClient().ScriptFlush(ctx)
+	err = h.writeBatch([]asyncWrite{{[]byte("foo"), 1}})
+	if err != nil {
+		t.Fatalf("batch write shouldn't error out after a script flush, error: %v", err)
+	}
+}
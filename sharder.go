@@ -0,0 +1,227 @@
+/*
+Implements the routing of the elements across many data structures of the same type, e.g.
+to scale a cuckoo filter beyond the capacity of a single one.
+
+Sharder: places each shard at many points of a hash ring and routes an element to the
+first point following its hash (consistent hashing), so that adding or removing a shard
+only moves the elements of the ring segments it gains or loses.
+*/
+package gostatix
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/dgryski/go-metro"
+)
+
+// shardSeed is the seed of the hashes routing the elements, different from the seeds of
+// the data structures so that the elements of a shard aren't biased in them
+const shardSeed = 7919
+
+// ringPoint is a point of the hash ring of a Sharder owned by the shard _name_
+type ringPoint struct {
+	hash uint64
+	name string
+}
+
+// Sharder routes the elements to one of many named shards with consistent hashing.
+// _replicas_ is the number of points of each shard on the ring, the more of them the more
+// even the split of the elements between the shards
+// _shards_ holds the shards by name
+// _ring_ holds the points of all the shards sorted by hash
+// _lock_ is used to synchronize concurrent read/writes
+type Sharder[T any] struct {
+	replicas int
+	shards   map[string]T
+	ring     []ringPoint
+	lock     sync.RWMutex
+}
+
+// NewSharder creates an empty Sharder placing each shard at _replicas_ points of the ring
+func NewSharder[T any](replicas int) (*Sharder[T], error) {
+	if replicas <= 0 {
+		return nil, fmt.Errorf("gostatix: number of replicas %d of sharder should be positive", replicas)
+	}
+	return &Sharder[T]{replicas: replicas, shards: make(map[string]T)}, nil
+}
+
+// Add adds _shard_ as _name_. The elements of the ring segments it takes are routed to it
+// from now on. It errors out if a shard is already named _name_.
+func (s *Sharder[T]) Add(name string, shard T) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.shards[name]; ok {
+		return fmt.Errorf("gostatix: sharder already has a shard named %s", name)
+	}
+	s.shards[name] = shard
+	for i := 0; i < s.replicas; i++ {
+		s.ring = append(s.ring, ringPoint{metro.Hash64([]byte(name+"#"+strconv.Itoa(i)), shardSeed), name})
+	}
+	sort.Slice(s.ring, func(i, j int) bool {
+		if s.ring[i].hash == s.ring[j].hash {
+			return s.ring[i].name < s.ring[j].name
+		}
+		return s.ring[i].hash < s.ring[j].hash
+	})
+	return nil
+}
+
+// Remove removes the shard _name_ and returns it. Its elements are routed to the shards
+// following its points on the ring from now on, the data of the shard isn't moved.
+func (s *Sharder[T]) Remove(name string) (T, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	shard, ok := s.shards[name]
+	if !ok {
+		return shard, false
+	}
+	delete(s.shards, name)
+	ring := s.ring[:0]
+	for _, point := range s.ring {
+		if point.name != name {
+			ring = append(ring, point)
+		}
+	}
+	s.ring = ring
+	return shard, true
+}
+
+// Shard returns the name of the shard of _data_ and the shard. It errors out if the
+// Sharder has no shards.
+func (s *Sharder[T]) Shard(data []byte) (string, T, error) {
+	hash := metro.Hash64(data, shardSeed)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if len(s.ring) == 0 {
+		var shard T
+		return "", shard, fmt.Errorf("gostatix: sharder has no shards")
+	}
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= hash })
+	if i == len(s.ring) {
+		i = 0
+	}
+	name := s.ring[i].name
+	return name, s.shards[name], nil
+}
+
+// Get returns the shard _name_
+func (s *Sharder[T]) Get(name string) (T, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	shard, ok := s.shards[name]
+	return shard, ok
+}
+
+// Names returns the names of the shards, sorted
+func (s *Sharder[T]) Names() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	names := make([]string, 0, len(s.shards))
+	for name := range s.shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Len returns the number of shards
+func (s *Sharder[T]) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return len(s.shards)
+}
+
+// ShardedFilter is a MembershipFilter spreading its elements over the filters of a
+// Sharder, each element being inserted in and looked up from its shard only
+type ShardedFilter struct {
+	*Sharder[MembershipFilter]
+}
+
+// shardedFilterJSON is the snapshot of a ShardedFilter holding the exported data of each
+// shard
+type shardedFilterJSON struct {
+	Shards map[string]json.RawMessage `json:"s"`
+}
+
+// NewShardedFilter creates an empty ShardedFilter placing each filter at _replicas_ points
+// of the ring. The filters are added with Add.
+func NewShardedFilter(replicas int) (*ShardedFilter, error) {
+	sharder, err := NewSharder[MembershipFilter](replicas)
+	if err != nil {
+		return nil, err
+	}
+	return &ShardedFilter{sharder}, nil
+}
+
+// Insert inserts _data_ in the filter of its shard
+func (f *ShardedFilter) Insert(data []byte) error {
+	_, filter, err := f.Shard(data)
+	if err != nil {
+		return err
+	}
+	return filter.Insert(data)
+}
+
+// InsertString inserts _data_ (string) in the filter of its shard
+func (f *ShardedFilter) InsertString(data string) error {
+	return f.Insert([]byte(data))
+}
+
+// Lookup returns true if _data_ is in the filter of its shard
+func (f *ShardedFilter) Lookup(data []byte) (bool, error) {
+	_, filter, err := f.Shard(data)
+	if err != nil {
+		return false, err
+	}
+	return filter.Lookup(data)
+}
+
+// LookupString returns true if _data_ (string) is in the filter of its shard
+func (f *ShardedFilter) LookupString(data string) (bool, error) {
+	return f.Lookup([]byte(data))
+}
+
+// Export JSON marshals the exported data of the filters of all the shards by name
+func (f *ShardedFilter) Export() ([]byte, error) {
+	shards := make(map[string]json.RawMessage)
+	for _, name := range f.Names() {
+		filter, ok := f.Get(name)
+		if !ok {
+			continue
+		}
+		data, err := filter.Export()
+		if err != nil {
+			return nil, fmt.Errorf("gostatix: error while exporting shard %s, error: %v", name, err)
+		}
+		shards[name] = data
+	}
+	return json.Marshal(shardedFilterJSON{shards})
+}
+
+// Close closes the filters of all the shards and returns the first error
+func (f *ShardedFilter) Close() error {
+	var firstErr error
+	for _, name := range f.Names() {
+		filter, ok := f.Get(name)
+		if !ok {
+			continue
+		}
+		err := filter.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+var _ MembershipFilter = (*ShardedFilter)(nil)
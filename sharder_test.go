@@ -0,0 +1,103 @@
+package gostatix
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func TestSharderRouting(t *testing.T) {
+	s, err := NewSharder[int](100)
+	if err != nil {
+		t.Fatalf("creation shouldn't error out, error: %v", err)
+	}
+	if _, _, err := s.Shard([]byte("foo")); err == nil {
+		t.Errorf("routing with no shards should error out")
+	}
+	for i := 0; i < 4; i++ {
+		if err := s.Add("shard-"+strconv.Itoa(i), i); err != nil {
+			t.Fatalf("adding a shard shouldn't error out, error: %v", err)
+		}
+	}
+	if err := s.Add("shard-0", 7); err == nil {
+		t.Errorf("adding a shard with the name of another one should error out")
+	}
+	before := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		data := strconv.Itoa(i)
+		name, shard, _ := s.Shard([]byte(data))
+		if name != "shard-"+strconv.Itoa(shard) {
+			t.Fatalf("shard %d should be returned with its name, got %s", shard, name)
+		}
+		before[data] = name
+		counts[name]++
+	}
+	for name, count := range counts {
+		if count < 1500 || count > 3500 {
+			t.Errorf("elements should be spread evenly, %s got %d of 10000", name, count)
+		}
+	}
+	if shard, ok := s.Remove("shard-2"); !ok || shard != 2 {
+		t.Errorf("removed shard should be returned, got %d", shard)
+	}
+	s.Add("shard-4", 4)
+	moved := 0
+	for data, name := range before {
+		after, _, _ := s.Shard([]byte(data))
+		if after != name {
+			moved++
+			if name != "shard-2" && after != "shard-4" {
+				t.Fatalf("%s should only move off the removed shard or to the added one, moved from %s to %s", data, name, after)
+			}
+		}
+	}
+	if moved < 1000 || moved > 5000 {
+		t.Errorf("about a quarter of the elements should move, %d moved", moved)
+	}
+	if names := s.Names(); len(names) != 4 || names[3] != "shard-4" || s.Len() != 4 {
+		t.Errorf("names should be sorted, got %v", names)
+	}
+	if _, ok := s.Remove("shard-2"); ok {
+		t.Errorf("removing a missing shard should return false")
+	}
+	if _, err := NewSharder[int](0); err == nil {
+		t.Errorf("creation with no replicas should error out")
+	}
+}
+
+func TestShardedFilter(t *testing.T) {
+	f, _ := NewShardedFilter(50)
+	if err := f.InsertString("foo"); err == nil {
+		t.Errorf("insert with no shards should error out")
+	}
+	for i := 0; i < 3; i++ {
+		filter, _ := NewMembershipFilter(MembershipFilterConfig{Kind: CuckooFilterKind, NumItems: 1000, ErrorRate: 0.001})
+		f.Add(strconv.Itoa(i), filter)
+	}
+	for i := 0; i < 1500; i++ {
+		if err := f.InsertString(strconv.Itoa(i)); err != nil {
+			t.Fatalf("insert shouldn't error out, error: %v", err)
+		}
+	}
+	for i := 0; i < 1500; i++ {
+		if ok, _ := f.LookupString(strconv.Itoa(i)); !ok {
+			t.Errorf("%d should be found", i)
+		}
+		_, filter, _ := f.Shard([]byte(strconv.Itoa(i)))
+		if ok, _ := filter.Lookup([]byte(strconv.Itoa(i))); !ok {
+			t.Errorf("%d should be inserted in the filter of its shard", i)
+		}
+	}
+	data, err := f.Export()
+	if err != nil {
+		t.Fatalf("export shouldn't error out, error: %v", err)
+	}
+	var snapshot shardedFilterJSON
+	if err := json.Unmarshal(data, &snapshot); err != nil || len(snapshot.Shards) != 3 {
+		t.Errorf("export should hold the filters of the 3 shards, error: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("close shouldn't error out, error: %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package gostatix
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/kwertop/gostatix/internal/util"
+)
+
+// redisKeyOverhead is the approximate memory used by Redis for every key besides its
+// name and value (dict entry, object header and expiry bookkeeping)
+const redisKeyOverhead = 64
+
+// redisIntEntrySize is the maximum size of an integer entry of a Redis list (listpack)
+const redisIntEntrySize = 10
+
+// redisStringEntryOverhead is the size of the header and back length of a short string
+// entry of a Redis list (listpack)
+const redisStringEntryOverhead = 2
+
+// redisRandomKeyLength is the length of the random keys generated for Redis backed structures
+const redisRandomKeyLength = 16
+
+// MemoryEstimate is the estimated memory needed by a data structure
+// _Bytes_ is the memory used by the in-memory implementation
+// _RedisBytes_ is the approximate memory used in Redis by the Redis backed implementation,
+// excluding its metadata hash
+type MemoryEstimate struct {
+	Bytes      uint64
+	RedisBytes uint64
+}
+
+// BloomFilterEstimate is the sizing of a Bloom filter for a number of items and error rate
+// _Size_ is the number of bits of the filter
+// _NumHashes_ is the number of hash functions applied to each element
+type BloomFilterEstimate struct {
+	Size      uint
+	NumHashes uint
+	MemoryEstimate
+}
+
+// EstimateBloomFilterMemory returns the size, number of hashes and the memory needed by a
+// BloomFilter created with NewMemBloomFilterWithParameters or NewRedisBloomFilterWithParameters
+// for _numItems_ items and the false positive rate _errorRate_, without creating it
+func EstimateBloomFilterMemory(numItems uint, errorRate float64) (*BloomFilterEstimate, error) {
+	params := BloomFilterParams{numItems, errorRate}
+	bytes, err := params.Estimate()
+	if err != nil {
+		return nil, err
+	}
+	size := params.Size()
+	estimate := &BloomFilterEstimate{Size: size, NumHashes: util.Max(util.CalculateNumHashes(size, numItems), 1)}
+	estimate.Bytes = bytes
+	// the Redis string backing the bitset is allocated with one byte per bit of the filter
+	estimate.RedisBytes = redisKeyOverhead + redisRandomKeyLength + uint64(size)
+	return estimate, nil
+}
+
+// CountMinSketchEstimate is the sizing of a Count-Min Sketch for an error rate and delta
+// _Rows_ is the number of rows (hash functions) of the matrix
+// _Columns_ is the number of columns of the matrix
+type CountMinSketchEstimate struct {
+	Rows    uint
+	Columns uint
+	MemoryEstimate
+}
+
+// EstimateCMSDimensions returns the dimensions and the memory needed by a CountMinSketch
+// created with NewCountMinSketchFromEstimates or NewCountMinSketchRedisFromEstimates for the
+// desired _errorRate_ and _delta_, without creating it
+func EstimateCMSDimensions(errorRate, delta float64) (*CountMinSketchEstimate, error) {
+	params, err := CountMinSketchParamsFromEstimates(errorRate, delta)
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := params.Estimate()
+	if err != nil {
+		return nil, err
+	}
+	estimate := &CountMinSketchEstimate{Rows: params.Rows, Columns: params.Columns}
+	estimate.Bytes = bytes
+	// every row is a Redis list of _columns_ counters
+	rowKeyLength := uint64(redisRandomKeyLength + len(strconv.FormatUint(uint64(params.Rows), 10)))
+	rowBytes := redisKeyOverhead + rowKeyLength + uint64(params.Columns)*redisIntEntrySize
+	estimate.RedisBytes = uint64(params.Rows) * rowBytes
+	return estimate, nil
+}
+
+// CuckooFilterEstimate is the sizing of a Cuckoo Filter for a number of items and error rate
+// _Size_ is the number of buckets
+// _BucketSize_ is the number of fingerprints held by each bucket
+// _FingerPrintLength_ is the length of the fingerprints
+// _Capacity_ is the maximum number of fingerprints the filter can hold
+type CuckooFilterEstimate struct {
+	Size              uint64
+	BucketSize        uint64
+	FingerPrintLength uint64
+	Capacity          uint64
+	MemoryEstimate
+}
+
+// EstimateCuckooCapacity returns the dimensions, the capacity and the memory needed by a
+// full CuckooFilter created with NewCuckooFilterWithErrorRate or NewCuckooFilterRedisWithErrorRate
+// for _size_ items in buckets of _bucketSize_ and the false positive rate _fpr_, without creating it
+func EstimateCuckooCapacity(size, bucketSize uint64, fpr float64) (*CuckooFilterEstimate, error) {
+	err := checkCuckooFilterEstimates(size, bucketSize, fpr)
+	if err != nil {
+		return nil, err
+	}
+	params := CuckooFilterParams{
+		Size:              uint64(math.Ceil(float64(size) * 0.955 / float64(bucketSize))),
+		BucketSize:        bucketSize,
+		FingerPrintLength: util.CalculateFingerPrintLength(size, fpr),
+	}
+	bytes, err := params.Estimate()
+	if err != nil {
+		return nil, err
+	}
+	estimate := &CuckooFilterEstimate{
+		Size:              params.Size,
+		BucketSize:        params.BucketSize,
+		FingerPrintLength: params.FingerPrintLength,
+		Capacity:          params.Size * params.BucketSize,
+	}
+	estimate.Bytes = bytes
+	// every bucket is a Redis list of fingerprints and its key is also listed in the
+	// list of bucket keys of the filter
+	bucketKeyLength := uint64(len("cuckoo_"+"_bucket_")+redisRandomKeyLength) + uint64(len(strconv.FormatUint(params.Size, 10)))
+	bucketBytes := redisKeyOverhead + bucketKeyLength + params.BucketSize*(params.FingerPrintLength+redisStringEntryOverhead)
+	keyListBytes := redisKeyOverhead + redisRandomKeyLength + params.Size*(bucketKeyLength+redisStringEntryOverhead)
+	estimate.RedisBytes = params.Size*bucketBytes + keyListBytes
+	return estimate, nil
+}
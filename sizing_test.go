@@ -0,0 +1,66 @@
+package gostatix
+
+import "testing"
+
+func TestEstimateBloomFilterMemory(t *testing.T) {
+	estimate, err := EstimateBloomFilterMemory(10000, 0.01)
+	if err != nil {
+		t.Fatalf("estimate shouldn't error out, error: %v", err)
+	}
+	filter, _ := NewMemBloomFilterWithParameters(10000, 0.01)
+	if estimate.Size != filter.GetCap() || estimate.NumHashes != filter.GetNumHashes() {
+		t.Errorf("estimated size %d and hashes %d should match the filter's %d and %d",
+			estimate.Size, estimate.NumHashes, filter.GetCap(), filter.GetNumHashes())
+	}
+	if estimate.Bytes != uint64(estimate.Size+63)/64*8 {
+		t.Errorf("estimated bytes %d should be the size of the bitset", estimate.Bytes)
+	}
+	if estimate.RedisBytes < uint64(estimate.Size) {
+		t.Errorf("estimated redis bytes %d should be at least the size %d", estimate.RedisBytes, estimate.Size)
+	}
+	_, err = EstimateBloomFilterMemory(10000, 1)
+	if err == nil {
+		t.Error("estimate should error out as the error rate is 1")
+	}
+}
+
+func TestEstimateCMSDimensions(t *testing.T) {
+	estimate, err := EstimateCMSDimensions(0.001, 0.99)
+	if err != nil {
+		t.Fatalf("estimate shouldn't error out, error: %v", err)
+	}
+	cms, _ := NewCountMinSketchFromEstimates(0.001, 0.99)
+	if estimate.Rows != cms.Rows() || estimate.Columns != cms.Columns() {
+		t.Errorf("estimated dimensions %dx%d should match the sketch's %dx%d",
+			estimate.Rows, estimate.Columns, cms.Rows(), cms.Columns())
+	}
+	if estimate.Bytes < uint64(estimate.Rows*estimate.Columns*8) || estimate.RedisBytes == 0 {
+		t.Errorf("estimated bytes %d and redis bytes %d should account for all counters", estimate.Bytes, estimate.RedisBytes)
+	}
+	_, err = EstimateCMSDimensions(0.001, 0)
+	if err == nil {
+		t.Error("estimate should error out as delta is 0")
+	}
+}
+
+func TestEstimateCuckooCapacity(t *testing.T) {
+	estimate, err := EstimateCuckooCapacity(10000, 4, 0.001)
+	if err != nil {
+		t.Fatalf("estimate shouldn't error out, error: %v", err)
+	}
+	filter, _ := NewCuckooFilterWithErrorRate(10000, 4, 500, 0.001)
+	if estimate.Size != filter.Size() || estimate.FingerPrintLength != filter.FingerPrintLength() {
+		t.Errorf("estimated size %d and fingerprint length %d should match the filter's %d and %d",
+			estimate.Size, estimate.FingerPrintLength, filter.Size(), filter.FingerPrintLength())
+	}
+	if estimate.Capacity != estimate.Size*4 {
+		t.Errorf("capacity %d should be %d", estimate.Capacity, estimate.Size*4)
+	}
+	if estimate.Bytes == 0 || estimate.RedisBytes == 0 {
+		t.Error("estimated bytes should be greater than 0")
+	}
+	_, err = EstimateCuckooCapacity(10000, 0, 0.001)
+	if err == nil {
+		t.Error("estimate should error out as the bucket size is 0")
+	}
+}
@@ -0,0 +1,413 @@
+/*
+Defines the interfaces shared by the in-memory and Redis backed data structures answering
+the same kind of queries, and factories selecting the data structure and its backend from
+a config.
+
+The concrete types keep their own method sets (the in-memory ones don't return errors, the
+Redis backed ones do), so the factories return adapters around them. Code written against
+the interfaces can switch between in-memory structures in unit tests and Redis backed ones
+in production by changing the config only.
+*/
+package gostatix
+
+import (
+	"fmt"
+	"io"
+)
+
+// Backend is the storage of a data structure created by the factories
+type Backend int
+
+const (
+	// MemoryBackend keeps the data structure in memory
+	MemoryBackend Backend = iota
+	// RedisBackend keeps the data structure in Redis, using the gostatix Redis client
+	RedisBackend
+	// MmapBackend keeps the data structure in a memory mapped file. It's reported by
+	// Describe, the factories don't support it.
+	MmapBackend
+	// KVBackend keeps the data structure in a KVStore. It's reported by Describe, the
+	// factories don't support it.
+	KVBackend
+)
+
+// String returns the name of the backend
+func (b Backend) String() string {
+	switch b {
+	case MemoryBackend:
+		return "memory"
+	case RedisBackend:
+		return "redis"
+	case MmapBackend:
+		return "mmap"
+	case KVBackend:
+		return "kv"
+	default:
+		return fmt.Sprintf("Backend(%d)", int(b))
+	}
+}
+
+// MembershipFilter is implemented by the adapters of the Bloom and Cuckoo filters
+// answering approximate set membership queries
+type MembershipFilter interface {
+	io.Closer
+	Insert(data []byte) error
+	Lookup(data []byte) (bool, error)
+	Export() ([]byte, error)
+}
+
+// FrequencySketch is implemented by the adapters of the Count-Min sketches estimating the
+// frequency of the elements
+type FrequencySketch interface {
+	io.Closer
+	Update(data []byte, count uint64) error
+	Count(data []byte) (uint64, error)
+	TotalCount() (uint64, error)
+	Export() ([]byte, error)
+}
+
+// CardinalitySketch is implemented by the adapters of the HyperLogLog, linear counting
+// and k-minimum values estimating the number of distinct elements
+type CardinalitySketch interface {
+	io.Closer
+	Update(data []byte) error
+	Count() (uint64, error)
+	Export() ([]byte, error)
+}
+
+// FilterKind is the kind of a MembershipFilter created by NewMembershipFilter
+type FilterKind int
+
+const (
+	// BloomFilterKind creates a BloomFilter
+	BloomFilterKind FilterKind = iota
+	// CuckooFilterKind creates a CuckooFilter or a CuckooFilterRedis, which also support removes
+	CuckooFilterKind
+)
+
+// MembershipFilterConfig is the config of a MembershipFilter created by NewMembershipFilter
+// _Backend_ is where the filter is stored
+// _Kind_ is the kind of filter
+// _NumItems_ is the number of items expected to be inserted in the filter
+// _ErrorRate_ is the acceptable false positive rate, between 0 and 1
+// _BucketSize_ is the size of the buckets of a Cuckoo filter, 4 if 0
+// _Retries_ is the number of relocations attempted by a Cuckoo filter before an insert
+// fails, 500 if 0
+type MembershipFilterConfig struct {
+	Backend    Backend
+	Kind       FilterKind
+	NumItems   uint
+	ErrorRate  float64
+	BucketSize uint64
+	Retries    uint64
+}
+
+// NewMembershipFilter creates the filter described by _config_ and returns it as a
+// MembershipFilter. The underlying filter can be retrieved with UnwrapMembershipFilter.
+func NewMembershipFilter(config MembershipFilterConfig) (MembershipFilter, error) {
+	switch config.Kind {
+	case BloomFilterKind:
+		var filter *BloomFilter
+		var err error
+		switch config.Backend {
+		case MemoryBackend:
+			filter, err = NewMemBloomFilterWithParameters(config.NumItems, config.ErrorRate)
+		case RedisBackend:
+			filter, err = NewRedisBloomFilterWithParameters(config.NumItems, config.ErrorRate)
+		default:
+			return nil, fmt.Errorf("gostatix: unknown backend %v", config.Backend)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return bloomMembershipFilter{filter}, nil
+	case CuckooFilterKind:
+		bucketSize, retries := config.BucketSize, config.Retries
+		if bucketSize == 0 {
+			bucketSize = 4
+		}
+		if retries == 0 {
+			retries = 500
+		}
+		switch config.Backend {
+		case MemoryBackend:
+			filter, err := NewCuckooFilterWithErrorRate(uint64(config.NumItems), bucketSize, retries, config.ErrorRate)
+			if err != nil {
+				return nil, err
+			}
+			return cuckooMembershipFilter{filter}, nil
+		case RedisBackend:
+			filter, err := NewCuckooFilterRedisWithErrorRate(uint64(config.NumItems), bucketSize, retries, config.ErrorRate)
+			if err != nil {
+				return nil, err
+			}
+			return cuckooRedisMembershipFilter{filter}, nil
+		default:
+			return nil, fmt.Errorf("gostatix: unknown backend %v", config.Backend)
+		}
+	default:
+		return nil, fmt.Errorf("gostatix: unknown filter kind %d", config.Kind)
+	}
+}
+
+// LoadMembershipFilter loads the Redis backed Bloom or Cuckoo filter whose metadata is
+// stored at _metadataKey_ and returns it as a MembershipFilter
+func LoadMembershipFilter(metadataKey string) (MembershipFilter, error) {
+	info, err := Describe(metadataKey)
+	if err != nil {
+		return nil, err
+	}
+	switch info.Type {
+	case "bloom":
+		filter, err := NewRedisBloomFilterFromKey(metadataKey)
+		if err != nil {
+			return nil, err
+		}
+		return bloomMembershipFilter{filter}, nil
+	case "cuckoo":
+		filter, err := NewCuckooFilterRedisFromKey(metadataKey)
+		if err != nil {
+			return nil, err
+		}
+		return cuckooRedisMembershipFilter{filter}, nil
+	default:
+		return nil, fmt.Errorf("gostatix: data structure at key %s is a %s, not a membership filter", metadataKey, info.Type)
+	}
+}
+
+// membershipFilterKey returns the metadata key of the Redis backed filter adapted by
+// _filter_, empty if it isn't Redis backed
+func membershipFilterKey(filter MembershipFilter) string {
+	switch f := UnwrapMembershipFilter(filter).(type) {
+	case *BloomFilter:
+		if isBitSetInProcess(f.filter) {
+			return ""
+		}
+		return f.GetMetadataKey()
+	case *CuckooFilterRedis:
+		return f.MetadataKey()
+	default:
+		return ""
+	}
+}
+
+// FrequencySketchConfig is the config of a FrequencySketch created by NewFrequencySketch
+// _Backend_ is where the sketch is stored
+// _ErrorRate_ is the acceptable error rate of the counts, between 0 and 1
+// _Delta_ is the probability of a count exceeding the error rate, between 0 and 1
+type FrequencySketchConfig struct {
+	Backend   Backend
+	ErrorRate float64
+	Delta     float64
+}
+
+// NewFrequencySketch creates the Count-Min sketch described by _config_ and returns it as
+// a FrequencySketch. The underlying sketch can be retrieved with UnwrapFrequencySketch.
+func NewFrequencySketch(config FrequencySketchConfig) (FrequencySketch, error) {
+	switch config.Backend {
+	case MemoryBackend:
+		cms, err := NewCountMinSketchFromEstimates(config.ErrorRate, config.Delta)
+		if err != nil {
+			return nil, err
+		}
+		return cmsFrequencySketch{cms}, nil
+	case RedisBackend:
+		cms, err := NewCountMinSketchRedisFromEstimates(config.ErrorRate, config.Delta)
+		if err != nil {
+			return nil, err
+		}
+		return cms, nil
+	default:
+		return nil, fmt.Errorf("gostatix: unknown backend %v", config.Backend)
+	}
+}
+
+// CardinalityKind is the kind of a CardinalitySketch created by NewCardinalitySketch
+type CardinalityKind int
+
+const (
+	// HyperLogLogKind creates a HyperLogLog or a HyperLogLogRedis
+	HyperLogLogKind CardinalityKind = iota
+	// LinearCountingKind creates a LinearCounting, only in memory
+	LinearCountingKind
+	// KMinValuesKind creates a KMinValues, only in memory
+	KMinValuesKind
+)
+
+// CardinalitySketchConfig is the config of a CardinalitySketch created by NewCardinalitySketch
+// _Backend_ is where the sketch is stored
+// _Kind_ is the kind of sketch
+// _Size_ is the number of registers of a HyperLogLog, the number of bits of a linear
+// counting or the k of a k-minimum values
+type CardinalitySketchConfig struct {
+	Backend Backend
+	Kind    CardinalityKind
+	Size    uint64
+}
+
+// NewCardinalitySketch creates the sketch described by _config_ and returns it as a
+// CardinalitySketch. The underlying sketch can be retrieved with UnwrapCardinalitySketch.
+// HyperLogLog counts are computed with correction and rounding off.
+func NewCardinalitySketch(config CardinalitySketchConfig) (CardinalitySketch, error) {
+	if config.Backend != MemoryBackend && config.Backend != RedisBackend {
+		return nil, fmt.Errorf("gostatix: unknown backend %v", config.Backend)
+	}
+	if config.Kind != HyperLogLogKind && config.Backend != MemoryBackend {
+		return nil, fmt.Errorf("gostatix: cardinality sketch kind %d isn't supported by backend %v", config.Kind, config.Backend)
+	}
+	switch config.Kind {
+	case HyperLogLogKind:
+		if config.Backend == RedisBackend {
+			h, err := NewHyperLogLogRedis(config.Size)
+			if err != nil {
+				return nil, err
+			}
+			return hllRedisCardinalitySketch{h}, nil
+		}
+		h, err := NewHyperLogLog(config.Size)
+		if err != nil {
+			return nil, err
+		}
+		return hllCardinalitySketch{h}, nil
+	case LinearCountingKind:
+		l, err := NewLinearCounting(config.Size)
+		if err != nil {
+			return nil, err
+		}
+		return linearCountingCardinalitySketch{l}, nil
+	case KMinValuesKind:
+		h, err := NewKMinValues(config.Size)
+		if err != nil {
+			return nil, err
+		}
+		return kMinValuesCardinalitySketch{h}, nil
+	default:
+		return nil, fmt.Errorf("gostatix: unknown cardinality sketch kind %d", config.Kind)
+	}
+}
+
+// UnwrapMembershipFilter returns the *BloomFilter, *CuckooFilter or *CuckooFilterRedis
+// adapted by _filter_, or _filter_ itself if it isn't an adapter
+func UnwrapMembershipFilter(filter MembershipFilter) any {
+	switch f := filter.(type) {
+	case bloomMembershipFilter:
+		return f.BloomFilter
+	case cuckooMembershipFilter:
+		return f.CuckooFilter
+	case cuckooRedisMembershipFilter:
+		return f.CuckooFilterRedis
+	default:
+		return filter
+	}
+}
+
+// UnwrapFrequencySketch returns the *CountMinSketch or *CountMinSketchRedis adapted by
+// _sketch_, or _sketch_ itself if it isn't an adapter
+func UnwrapFrequencySketch(sketch FrequencySketch) any {
+	if s, ok := sketch.(cmsFrequencySketch); ok {
+		return s.CountMinSketch
+	}
+	return sketch
+}
+
+// UnwrapCardinalitySketch returns the *HyperLogLog, *HyperLogLogRedis, *LinearCounting or
+// *KMinValues adapted by _sketch_, or _sketch_ itself if it isn't an adapter
+func UnwrapCardinalitySketch(sketch CardinalitySketch) any {
+	switch s := sketch.(type) {
+	case hllCardinalitySketch:
+		return s.HyperLogLog
+	case hllRedisCardinalitySketch:
+		return s.HyperLogLogRedis
+	case linearCountingCardinalitySketch:
+		return s.LinearCounting
+	case kMinValuesCardinalitySketch:
+		return s.KMinValues
+	default:
+		return sketch
+	}
+}
+
+type bloomMembershipFilter struct{ *BloomFilter }
+
+func (f bloomMembershipFilter) Insert(data []byte) error {
+	f.BloomFilter.Insert(data)
+	return nil
+}
+
+func (f bloomMembershipFilter) Lookup(data []byte) (bool, error) {
+	return f.BloomFilter.Lookup(data), nil
+}
+
+type cuckooMembershipFilter struct{ *CuckooFilter }
+
+func (f cuckooMembershipFilter) Insert(data []byte) error {
+	_, err := f.CuckooFilter.InsertWithStats(data, false, 0)
+	return err
+}
+
+func (f cuckooMembershipFilter) Lookup(data []byte) (bool, error) {
+	return f.CuckooFilter.Lookup(data), nil
+}
+
+type cuckooRedisMembershipFilter struct{ *CuckooFilterRedis }
+
+func (f cuckooRedisMembershipFilter) Insert(data []byte) error {
+	_, err := f.CuckooFilterRedis.InsertWithStats(data, false, 0)
+	return err
+}
+
+type cmsFrequencySketch struct{ *CountMinSketch }
+
+func (s cmsFrequencySketch) Update(data []byte, count uint64) error {
+	s.CountMinSketch.Update(data, count)
+	return nil
+}
+
+func (s cmsFrequencySketch) Count(data []byte) (uint64, error) {
+	return s.CountMinSketch.Count(data), nil
+}
+
+func (s cmsFrequencySketch) TotalCount() (uint64, error) {
+	return s.CountMinSketch.TotalCount(), nil
+}
+
+type hllCardinalitySketch struct{ *HyperLogLog }
+
+func (s hllCardinalitySketch) Update(data []byte) error {
+	s.HyperLogLog.Update(data)
+	return nil
+}
+
+func (s hllCardinalitySketch) Count() (uint64, error) {
+	return s.HyperLogLog.Count(true, true), nil
+}
+
+type hllRedisCardinalitySketch struct{ *HyperLogLogRedis }
+
+func (s hllRedisCardinalitySketch) Count() (uint64, error) {
+	return s.HyperLogLogRedis.Count(true, true)
+}
+
+type linearCountingCardinalitySketch struct{ *LinearCounting }
+
+func (s linearCountingCardinalitySketch) Update(data []byte) error {
+	s.LinearCounting.Update(data)
+	return nil
+}
+
+func (s linearCountingCardinalitySketch) Count() (uint64, error) {
+	return s.LinearCounting.Count(), nil
+}
+
+type kMinValuesCardinalitySketch struct{ *KMinValues }
+
+func (s kMinValuesCardinalitySketch) Update(data []byte) error {
+	s.KMinValues.Update(data)
+	return nil
+}
+
+func (s kMinValuesCardinalitySketch) Count() (uint64, error) {
+	return s.KMinValues.Count(), nil
+}
+
+var _ FrequencySketch = (*CountMinSketchRedis)(nil)
@@ -0,0 +1,92 @@
+package gostatix
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestNewMembershipFilter(t *testing.T) {
+	initMockRedis()
+	for _, backend := range []Backend{MemoryBackend, RedisBackend} {
+		for _, kind := range []FilterKind{BloomFilterKind, CuckooFilterKind} {
+			filter, err := NewMembershipFilter(MembershipFilterConfig{Backend: backend, Kind: kind, NumItems: 1000, ErrorRate: 0.001})
+			if err != nil {
+				t.Fatalf("filter creation shouldn't error out for kind %d on %v, error: %v", kind, backend, err)
+			}
+			if err := filter.Insert([]byte("foo")); err != nil {
+				t.Errorf("insert shouldn't error out for kind %d on %v, error: %v", kind, backend, err)
+			}
+			if ok, _ := filter.Lookup([]byte("foo")); !ok {
+				t.Errorf("foo should be found for kind %d on %v", kind, backend)
+			}
+			if ok, _ := filter.Lookup([]byte("bar")); ok {
+				t.Errorf("bar shouldn't be found for kind %d on %v", kind, backend)
+			}
+			filter.Close()
+		}
+	}
+	filter, _ := NewMembershipFilter(MembershipFilterConfig{Backend: RedisBackend, Kind: CuckooFilterKind, NumItems: 1000, ErrorRate: 0.001})
+	if _, ok := UnwrapMembershipFilter(filter).(*CuckooFilterRedis); !ok {
+		t.Errorf("unwrapped filter should be a *CuckooFilterRedis, got %T", UnwrapMembershipFilter(filter))
+	}
+	_, err := NewMembershipFilter(MembershipFilterConfig{Backend: Backend(5), NumItems: 1000, ErrorRate: 0.001})
+	if err == nil {
+		t.Errorf("filter creation should error out for an unknown backend")
+	}
+}
+
+func TestNewFrequencySketch(t *testing.T) {
+	initMockRedis()
+	for _, backend := range []Backend{MemoryBackend, RedisBackend} {
+		sketch, err := NewFrequencySketch(FrequencySketchConfig{Backend: backend, ErrorRate: 0.001, Delta: 0.01})
+		if err != nil {
+			t.Fatalf("sketch creation shouldn't error out on %v, error: %v", backend, err)
+		}
+		sketch.Update([]byte("foo"), 3)
+		sketch.Update([]byte("bar"), 1)
+		if count, _ := sketch.Count([]byte("foo")); count != 3 {
+			t.Errorf("count of foo should be 3 on %v, got %d", backend, count)
+		}
+		if total, _ := sketch.TotalCount(); total != 4 {
+			t.Errorf("total count should be 4 on %v, got %d", backend, total)
+		}
+		sketch.Close()
+	}
+}
+
+func TestNewCardinalitySketch(t *testing.T) {
+	initMockRedis()
+	configs := []CardinalitySketchConfig{
+		{MemoryBackend, HyperLogLogKind, 1024},
+		{RedisBackend, HyperLogLogKind, 1024},
+		{MemoryBackend, LinearCountingKind, 10000},
+		{MemoryBackend, KMinValuesKind, 1024},
+	}
+	h, _ := NewHyperLogLog(1024)
+	for i := 0; i < 2000; i++ {
+		h.Update([]byte(strconv.Itoa(i % 1000)))
+	}
+	for _, config := range configs {
+		sketch, err := NewCardinalitySketch(config)
+		if err != nil {
+			t.Fatalf("sketch creation shouldn't error out for %+v, error: %v", config, err)
+		}
+		for i := 0; i < 2000; i++ {
+			sketch.Update([]byte(strconv.Itoa(i % 1000)))
+		}
+		count, _ := sketch.Count()
+		if config.Kind == HyperLogLogKind {
+			if count != h.Count(true, true) {
+				t.Errorf("count should match the one of a hyperloglog for %+v; got %d, expected %d", config, count, h.Count(true, true))
+			}
+		} else if math.Abs(float64(count)-1000) > 100 {
+			t.Errorf("too much variance in calculated distinct values for %+v; got %d, exact %d", config, count, 1000)
+		}
+		sketch.Close()
+	}
+	_, err := NewCardinalitySketch(CardinalitySketchConfig{RedisBackend, KMinValuesKind, 128})
+	if err == nil {
+		t.Errorf("k-minimum values shouldn't be supported by the redis backend")
+	}
+}
@@ -0,0 +1,304 @@
+/*
+Implements the periodic snapshotting of in-memory data structures to a sink, like a file,
+a Redis key or any io.Writer, and their recovery from the last snapshot at restart.
+*/
+package gostatix
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Snapshottable is implemented by the in-memory data structures which can be snapshotted
+// by a Snapshotter, like BloomFilter, CountMinSketch, HyperLogLog and TopK
+type Snapshottable interface {
+	Export() ([]byte, error)
+	Import(data []byte) error
+}
+
+var (
+	_ Snapshottable = (*BloomFilter)(nil)
+	_ Snapshottable = (*CuckooFilter)(nil)
+	_ Snapshottable = (*CountMinSketch)(nil)
+	_ Snapshottable = (*HyperLogLog)(nil)
+	_ Snapshottable = (*RollingHyperLogLog)(nil)
+	_ Snapshottable = (*TopK)(nil)
+	_ Snapshottable = (*LinearCounting)(nil)
+	_ Snapshottable = (*KMinValues)(nil)
+	_ Snapshottable = (*SpectralBloomFilter)(nil)
+	_ Snapshottable = (*AgePartitionedBloomFilter)(nil)
+	_ Snapshottable = (*IBLT)(nil)
+	_ Snapshottable = (*ExponentialHistogram)(nil)
+)
+
+// SnapshotSink stores the last snapshot of a data structure
+type SnapshotSink interface {
+	// Save replaces the last snapshot with _data_. The replacement should be atomic, so
+	// that a crash while saving leaves the previous snapshot in place.
+	Save(data []byte) error
+	// Load returns the last snapshot, or nil if there's none
+	Load() ([]byte, error)
+}
+
+// FileSnapshotSink saves the snapshots in the file at _path_. A snapshot is written to a
+// temporary file in the same directory, synced and renamed over the previous one.
+type FileSnapshotSink struct {
+	path string
+}
+
+// NewFileSnapshotSink creates a FileSnapshotSink saving the snapshots at _path_
+func NewFileSnapshotSink(path string) *FileSnapshotSink {
+	return &FileSnapshotSink{path}
+}
+
+// Save atomically replaces the snapshot in the file with _data_
+func (sink *FileSnapshotSink) Save(data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(sink.path), filepath.Base(sink.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("gostatix: error while creating snapshot file, error: %v", err)
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), sink.path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("gostatix: error while writing snapshot file %s, error: %v", sink.path, err)
+	}
+	return nil
+}
+
+// Load returns the snapshot in the file, or nil if the file doesn't exist
+func (sink *FileSnapshotSink) Load() ([]byte, error) {
+	data, err := os.ReadFile(sink.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while reading snapshot file %s, error: %v", sink.path, err)
+	}
+	return data, nil
+}
+
+// RedisSnapshotSink saves the snapshots as the string value of a Redis key, which is
+// replaced atomically by SET
+type RedisSnapshotSink struct {
+	key string
+	ttl time.Duration
+}
+
+// NewRedisSnapshotSink creates a RedisSnapshotSink saving the snapshots at _key_. If _ttl_
+// is greater than 0, the key expires if no snapshot is saved for _ttl_.
+func NewRedisSnapshotSink(key string, ttl time.Duration) *RedisSnapshotSink {
+	return &RedisSnapshotSink{key, ttl}
+}
+
+// Save replaces the snapshot at the Redis key with _data_
+func (sink *RedisSnapshotSink) Save(data []byte) error {
+	err := getRedisClient().Set(context.Background(), sink.key, data, sink.ttl).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while saving snapshot in redis, error: %v", err)
+	}
+	return nil
+}
+
+// Load returns the snapshot at the Redis key, or nil if the key doesn't exist
+func (sink *RedisSnapshotSink) Load() ([]byte, error) {
+	data, err := getRedisClient().Get(context.Background(), sink.key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while loading snapshot from redis, error: %v", err)
+	}
+	return data, nil
+}
+
+// WriterSnapshotSink saves the snapshots to the writers returned by a function, e.g. an
+// uploader to an object store. The snapshot is only complete once the writer is closed
+// without error, so the writer should replace the previous snapshot atomically on Close.
+type WriterSnapshotSink struct {
+	create func() (io.WriteCloser, error)
+	open   func() (io.ReadCloser, error)
+}
+
+// NewWriterSnapshotSink creates a WriterSnapshotSink writing each snapshot to a writer
+// returned by _create_ and reading the last one from a reader returned by _open_, which
+// returns a nil reader if there's no snapshot. _open_ is optional, can be nil, in which
+// case Load errors out.
+func NewWriterSnapshotSink(create func() (io.WriteCloser, error), open func() (io.ReadCloser, error)) *WriterSnapshotSink {
+	return &WriterSnapshotSink{create, open}
+}
+
+// Save writes _data_ to a new writer and closes it
+func (sink *WriterSnapshotSink) Save(data []byte) error {
+	writer, err := sink.create()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while creating snapshot writer, error: %v", err)
+	}
+	_, err = writer.Write(data)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("gostatix: error while writing snapshot, error: %v", err)
+	}
+	return nil
+}
+
+// Load reads the last snapshot from a new reader
+func (sink *WriterSnapshotSink) Load() ([]byte, error) {
+	if sink.open == nil {
+		return nil, fmt.Errorf("gostatix: snapshot sink can't be read from")
+	}
+	reader, err := sink.open()
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while opening snapshot reader, error: %v", err)
+	}
+	if reader == nil {
+		return nil, nil
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while reading snapshot, error: %v", err)
+	}
+	return data, nil
+}
+
+// Snapshotter periodically exports a data structure to a SnapshotSink in the background.
+// Snapshots identical to the last one saved are skipped. Close stops the snapshots after
+// taking a last one.
+type Snapshotter struct {
+	structure Snapshottable
+	sink      SnapshotSink
+	onError   func(error)
+	lastSum   uint32
+	lastSaved time.Time
+	saved     bool
+	closed    bool
+	lock      sync.Mutex
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewSnapshotter creates a Snapshotter saving _structure_ to _sink_ every _interval_.
+// _onError_ (optional, can be nil) is called with the errors of the background snapshots.
+func NewSnapshotter(structure Snapshottable, sink SnapshotSink, interval time.Duration, onError func(error)) (*Snapshotter, error) {
+	if structure == nil || sink == nil {
+		return nil, fmt.Errorf("gostatix: snapshotter needs a data structure and a sink")
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("gostatix: snapshot interval should be greater than 0")
+	}
+	s := &Snapshotter{structure: structure, sink: sink, onError: onError, done: make(chan struct{})}
+	s.wg.Add(1)
+	go s.snapshotPeriodically(interval)
+	return s, nil
+}
+
+// Restore imports the last snapshot of _sink_ into _structure_, e.g. at the start of a
+// service before creating its Snapshotter. It returns false if the sink has no snapshot.
+func Restore(structure Snapshottable, sink SnapshotSink) (bool, error) {
+	data, err := sink.Load()
+	if err != nil {
+		return false, err
+	}
+	if data == nil {
+		return false, nil
+	}
+	err = structure.Import(data)
+	if err != nil {
+		return false, fmt.Errorf("gostatix: error while restoring snapshot, error: %v", err)
+	}
+	return true, nil
+}
+
+func (s *Snapshotter) snapshotPeriodically(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := s.Snapshot()
+			if err != nil && s.onError != nil {
+				s.onError(err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Snapshot exports the data structure and saves it to the sink right away, unless it's
+// identical to the last snapshot saved
+func (s *Snapshotter) Snapshot() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("gostatix: snapshotter is already closed")
+	}
+	return s.snapshot()
+}
+
+func (s *Snapshotter) snapshot() error {
+	data, err := s.structure.Export()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while exporting snapshot, error: %v", err)
+	}
+	sum := crc32.ChecksumIEEE(data)
+	if s.saved && sum == s.lastSum {
+		return nil
+	}
+	err = s.sink.Save(data)
+	if err != nil {
+		return err
+	}
+	s.saved = true
+	s.lastSum = sum
+	s.lastSaved = time.Now()
+	return nil
+}
+
+// LastSaved returns the time at which the last snapshot was saved, zero if none was
+func (s *Snapshotter) LastSaved() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.lastSaved
+}
+
+// Close stops the background snapshots and takes a last snapshot, whose error is
+// returned. It's safe to call Close multiple times.
+func (s *Snapshotter) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.lock.Unlock()
+	s.wg.Wait()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.snapshot()
+}
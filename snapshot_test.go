@@ -0,0 +1,109 @@
+package gostatix
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotterFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cms.snapshot")
+	sink := NewFileSnapshotSink(path)
+	if data, err := sink.Load(); data != nil || err != nil {
+		t.Fatalf("load of a missing snapshot should return nil, got %v, error: %v", data, err)
+	}
+	sketch, _ := NewCountMinSketch(3, 50)
+	s, err := NewSnapshotter(sketch, sink, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("creation shouldn't error out, error: %v", err)
+	}
+	sketch.UpdateString("foo", 3)
+	if err := s.Snapshot(); err != nil {
+		t.Fatalf("snapshot shouldn't error out, error: %v", err)
+	}
+	saved := s.LastSaved()
+	if saved.IsZero() {
+		t.Errorf("last saved time should be set after a snapshot")
+	}
+	s.Snapshot()
+	if s.LastSaved() != saved {
+		t.Errorf("identical snapshots should be skipped")
+	}
+	sketch.UpdateString("bar", 2)
+	if err := s.Close(); err != nil {
+		t.Fatalf("close shouldn't error out, error: %v", err)
+	}
+	if s.Snapshot() == nil {
+		t.Errorf("snapshot after close should error out")
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("temporary snapshot files should be removed, got %d files", len(entries))
+	}
+
+	restored, _ := NewCountMinSketch(1, 1)
+	ok, err := Restore(restored, sink)
+	if !ok || err != nil {
+		t.Fatalf("restore should find the snapshot, error: %v", err)
+	}
+	if equal, _ := restored.Equals(sketch); !equal {
+		t.Errorf("restored sketch should hold the last snapshot taken on close")
+	}
+}
+
+func TestSnapshotterPeriodic(t *testing.T) {
+	initMockRedis()
+	sink := NewRedisSnapshotSink("snapshot:hll", 0)
+	h, _ := NewHyperLogLog(64)
+	h.UpdateString("foo")
+	s, _ := NewSnapshotter(h, sink, time.Millisecond, func(err error) {
+		t.Errorf("background snapshot shouldn't error out, error: %v", err)
+	})
+	defer s.Close()
+	deadline := time.Now().Add(time.Second)
+	for s.LastSaved().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	restored, _ := NewHyperLogLog(16)
+	if ok, err := Restore(restored, sink); !ok || err != nil {
+		t.Fatalf("restore should find the background snapshot, error: %v", err)
+	}
+	if equal, _ := restored.Equals(h); !equal {
+		t.Errorf("restored hyperloglog should be the snapshotted one")
+	}
+	if ok, _ := Restore(restored, NewRedisSnapshotSink("snapshot:missing", 0)); ok {
+		t.Errorf("restore from a missing key shouldn't find a snapshot")
+	}
+	if _, err := NewSnapshotter(h, sink, 0, nil); err == nil {
+		t.Errorf("creation with a zero interval should error out")
+	}
+}
+
+func TestWriterSnapshotSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewWriterSnapshotSink(func() (io.WriteCloser, error) {
+		buf.Reset()
+		return nopWriteCloser{&buf}, nil
+	}, func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	filter, _ := NewMemBloomFilterWithParameters(100, 0.01)
+	filter.InsertString("foo")
+	s, _ := NewSnapshotter(filter, sink, time.Hour, nil)
+	if err := s.Close(); err != nil {
+		t.Fatalf("close shouldn't error out, error: %v", err)
+	}
+	restored, _ := NewMemBloomFilterWithParameters(10, 0.1)
+	if ok, err := Restore(restored, sink); !ok || err != nil {
+		t.Fatalf("restore should find the snapshot, error: %v", err)
+	}
+	if !restored.LookupString("foo") {
+		t.Errorf("restored filter should hold the snapshotted elements")
+	}
+	if _, err := NewWriterSnapshotSink(sink.create, nil).Load(); err == nil {
+		t.Errorf("load without an open function should error out")
+	}
+}
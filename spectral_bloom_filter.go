@@ -0,0 +1,500 @@
+/*
+Implements the spectral Bloom filter, a Bloom filter storing a counter per position.
+
+Spectral Bloom filter: A Bloom filter whose bits are replaced by counters, so that it answers
+frequency queries besides membership queries. The frequency of an element is estimated by
+the minimum of its counters (minimum selection), which is never lower than its true
+frequency. Refer: https://theory.stanford.edu/~matias/papers/sbf-sigmod-03.pdf
+*/
+package gostatix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// spectralAddScript increments the counters at the positions ARGV[2], ARGV[3]... of the hash
+// at KEYS[1] by ARGV[1], saturating them at the maximum uint32
+var spectralAddScript = redis.NewScript(`
+	local count = tonumber(ARGV[1])
+	for i=2, #ARGV do
+		local value = redis.call('HINCRBY', KEYS[1], ARGV[i], count)
+		if value > 4294967295 then
+			redis.call('HSET', KEYS[1], ARGV[i], 4294967295)
+		end
+	end
+	return true
+`)
+
+// spectralRemoveScript decrements the counters at the positions ARGV[2], ARGV[3]... of the
+// hash at KEYS[1] by ARGV[1], or by their minimum if it's smaller so that no counter goes
+// below zero, and returns the minimum of the counters after the decrement
+var spectralRemoveScript = redis.NewScript(`
+	local count = tonumber(ARGV[1])
+	local estimate = nil
+	for i=2, #ARGV do
+		local value = tonumber(redis.call('HGET', KEYS[1], ARGV[i]) or 0)
+		if estimate == nil or value < estimate then
+			estimate = value
+		end
+	end
+	if count > estimate then
+		count = estimate
+	end
+	if count > 0 then
+		for i=2, #ARGV do
+			if redis.call('HINCRBY', KEYS[1], ARGV[i], -count) == 0 then
+				redis.call('HDEL', KEYS[1], ARGV[i])
+			end
+		end
+	end
+	return estimate - count
+`)
+
+// SpectralBloomFilter is a Bloom filter storing a counter per position, in memory or in Redis
+// _size_ is the number of counters
+// _numHashes_ is the number of hash functions, i.e. of counters per element
+// _counters_ holds the counters, which are 32 bit and saturate at math.MaxUint32
+// _metadataKey_ is the Redis key to the metadata of a Redis backed filter, empty otherwise
+type SpectralBloomFilter struct {
+	size        uint
+	numHashes   uint
+	counters    spectralCounters
+	metadataKey string
+	lock        sync.RWMutex
+}
+
+// spectralCounters is the storage of the counters of a SpectralBloomFilter. The positions
+// passed are distinct.
+type spectralCounters interface {
+	add(positions []uint, count uint64) error
+	remove(positions []uint, count uint64) (uint64, error)
+	min(positions []uint) (uint64, error)
+	values(size uint) ([]uint32, error)
+	setValues(values []uint32) error
+	reset() error
+}
+
+type spectralBloomFilterJSON struct {
+	Size      uint     `json:"m"`
+	NumHashes uint     `json:"k"`
+	Counters  []uint32 `json:"c"`
+}
+
+// NewSpectralBloomFilter creates an in-memory SpectralBloomFilter sized like a BloomFilter
+// for _numItems_ distinct elements and a false positive rate of _errorRate_
+func NewSpectralBloomFilter(numItems uint, errorRate float64) (*SpectralBloomFilter, error) {
+	params := BloomFilterParams{numItems, errorRate}
+	err := params.Validate()
+	if err != nil {
+		return nil, err
+	}
+	size := params.Size()
+	counters := spectralCountersMem(make([]uint32, size))
+	return &SpectralBloomFilter{size: size, numHashes: params.NumHashes(), counters: &counters}, nil
+}
+
+// NewRedisSpectralBloomFilter creates a Redis backed SpectralBloomFilter sized like a
+// BloomFilter for _numItems_ distinct elements and a false positive rate of _errorRate_.
+// The counters are stored in a Redis hash, in which only the counters greater than zero are
+// present.
+// _opts_ can name the Redis keys of the filter with WithName and WithKeyPrefix
+func NewRedisSpectralBloomFilter(numItems uint, errorRate float64, opts ...Option) (*SpectralBloomFilter, error) {
+	params := BloomFilterParams{numItems, errorRate}
+	err := params.Validate()
+	if err != nil {
+		return nil, err
+	}
+	o, err := redisOptions("spectral bloom filter", opts)
+	if err != nil {
+		return nil, err
+	}
+	metadataKey, err := o.metadataKey()
+	if err != nil {
+		return nil, err
+	}
+	key, err := o.dataKey(":counters")
+	if err != nil {
+		return nil, err
+	}
+	filter := &SpectralBloomFilter{
+		size:        params.Size(),
+		numHashes:   params.NumHashes(),
+		counters:    &spectralCountersRedis{key},
+		metadataKey: metadataKey,
+	}
+	metadata := make(map[string]interface{})
+	metadata["size"] = filter.size
+	metadata["numHashes"] = filter.numHashes
+	metadata["key"] = key
+	err = saveMetadataContext(o.ctx, metadataKey, "spectral", metadata)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while creating spectral bloom filter redis, error: %v", err)
+	}
+	return filter, nil
+}
+
+// NewRedisSpectralBloomFilterFromKey is used to create a Redis backed SpectralBloomFilter
+// from the _metadataKey_ (the Redis key used to store the metadata about the filter) passed
+func NewRedisSpectralBloomFilterFromKey(metadataKey string) (*SpectralBloomFilter, error) {
+	metadata, err := loadMetadata(metadataKey, "spectral")
+	if err != nil {
+		return nil, err
+	}
+	size, err := metadata.uint("size")
+	if err != nil {
+		return nil, err
+	}
+	numHashes, err := metadata.uint("numHashes")
+	if err != nil {
+		return nil, err
+	}
+	key, err := metadata.string("key")
+	if err != nil {
+		return nil, err
+	}
+	err = checkBloomFilterParams(size, numHashes)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: invalid spectral bloom filter metadata at key %s, error: %v", metadataKey, err)
+	}
+	return &SpectralBloomFilter{
+		size:        uint(size),
+		numHashes:   uint(numHashes),
+		counters:    &spectralCountersRedis{key},
+		metadataKey: metadataKey,
+	}, nil
+}
+
+// Size returns the number of counters of the filter
+func (filter *SpectralBloomFilter) Size() uint {
+	return filter.size
+}
+
+// NumHashes returns the number of hash functions of the filter
+func (filter *SpectralBloomFilter) NumHashes() uint {
+	return filter.numHashes
+}
+
+// MetadataKey returns the Redis key to the metadata of a Redis backed filter, it's empty for
+// an in-memory filter
+func (filter *SpectralBloomFilter) MetadataKey() string {
+	return filter.metadataKey
+}
+
+// Insert increments the counters of _data_ by _count_
+func (filter *SpectralBloomFilter) Insert(data []byte, count uint64) error {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	return filter.counters.add(filter.positions(data), count)
+}
+
+// InsertString increments the counters of _data_ (string) by _count_
+func (filter *SpectralBloomFilter) InsertString(data string, count uint64) error {
+	return filter.Insert([]byte(data), count)
+}
+
+// Count returns the estimated number of times _data_ was inserted, the minimum of its
+// counters. It's never lower than the true count, except for counters which saturated.
+func (filter *SpectralBloomFilter) Count(data []byte) (uint64, error) {
+	filter.lock.RLock()
+	defer filter.lock.RUnlock()
+
+	return filter.counters.min(filter.positions(data))
+}
+
+// CountString returns the estimated number of times _data_ (string) was inserted
+func (filter *SpectralBloomFilter) CountString(data string) (uint64, error) {
+	return filter.Count([]byte(data))
+}
+
+// Lookup returns true if _data_ may have been inserted, like a Bloom filter
+func (filter *SpectralBloomFilter) Lookup(data []byte) (bool, error) {
+	return filter.SeenAtLeast(data, 1)
+}
+
+// LookupString returns true if _data_ (string) may have been inserted
+func (filter *SpectralBloomFilter) LookupString(data string) (bool, error) {
+	return filter.Lookup([]byte(data))
+}
+
+// SeenAtLeast returns true if _data_ may have been inserted at least _n_ times. A false
+// answer is always right, a true answer is wrong with about the false positive rate of the
+// filter.
+func (filter *SpectralBloomFilter) SeenAtLeast(data []byte, n uint64) (bool, error) {
+	count, err := filter.Count(data)
+	if err != nil {
+		return false, err
+	}
+	return count >= n, nil
+}
+
+// Remove decrements the counters of _data_ by _count_, or by its estimated count if it's
+// smaller so that no counter goes below zero. It returns the estimated count of _data_
+// after the decrement. Only elements which were inserted should be removed, otherwise the
+// counts of other elements may be lowered below their true counts.
+func (filter *SpectralBloomFilter) Remove(data []byte, count uint64) (uint64, error) {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	return filter.counters.remove(filter.positions(data), count)
+}
+
+// RemoveString decrements the counters of _data_ (string) by _count_
+func (filter *SpectralBloomFilter) RemoveString(data string, count uint64) (uint64, error) {
+	return filter.Remove([]byte(data), count)
+}
+
+// Reset sets all the counters of the filter to zero
+func (filter *SpectralBloomFilter) Reset() error {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	return filter.counters.reset()
+}
+
+// Export JSON marshals the SpectralBloomFilter and returns a byte slice containing the data
+func (filter *SpectralBloomFilter) Export() ([]byte, error) {
+	filter.lock.RLock()
+	defer filter.lock.RUnlock()
+
+	counters, err := filter.counters.values(filter.size)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(spectralBloomFilterJSON{filter.size, filter.numHashes, counters})
+}
+
+// Import JSON unmarshals the _data_ into the SpectralBloomFilter. The counters of a Redis
+// backed filter are replaced in Redis and its metadata is updated.
+func (filter *SpectralBloomFilter) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
+	var f spectralBloomFilterJSON
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return err
+	}
+	err = checkBloomFilterParams(uint64(f.Size), uint64(f.NumHashes))
+	if err != nil {
+		return err
+	}
+	if uint(len(f.Counters)) != f.Size {
+		return fmt.Errorf("gostatix: invalid spectral bloom filter snapshot, %d counters found instead of %d", len(f.Counters), f.Size)
+	}
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	if filter.counters == nil {
+		filter.counters = new(spectralCountersMem)
+	}
+	err = filter.counters.setValues(f.Counters)
+	if err != nil {
+		return err
+	}
+	filter.size = f.Size
+	filter.numHashes = f.NumHashes
+	if filter.metadataKey != "" {
+		metadata := make(map[string]interface{})
+		metadata["size"] = filter.size
+		metadata["numHashes"] = filter.numHashes
+		metadata["key"] = filter.counters.(*spectralCountersRedis).key
+		err = saveMetadata(filter.metadataKey, "spectral", metadata)
+		if err != nil {
+			return fmt.Errorf("gostatix: error while saving metadata in redis, error: %v", err)
+		}
+	}
+	return nil
+}
+
+// positions returns the distinct positions of the counters of _data_
+func (filter *SpectralBloomFilter) positions(data []byte) []uint {
+	hashes := getHashes(data)
+	positions := make([]uint, 0, filter.numHashes)
+	for i := uint(0); i < filter.numHashes; i++ {
+		position := doubleHashIndex(hashes, i, filter.size)
+		duplicate := false
+		for _, p := range positions {
+			if p == position {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			positions = append(positions, position)
+		}
+	}
+	return positions
+}
+
+// spectralCountersMem holds the counters of an in-memory SpectralBloomFilter
+type spectralCountersMem []uint32
+
+func (counters *spectralCountersMem) add(positions []uint, count uint64) error {
+	for _, p := range positions {
+		value := uint64((*counters)[p]) + count
+		if value > math.MaxUint32 || value < count {
+			value = math.MaxUint32
+		}
+		(*counters)[p] = uint32(value)
+	}
+	return nil
+}
+
+func (counters *spectralCountersMem) remove(positions []uint, count uint64) (uint64, error) {
+	estimate, _ := counters.min(positions)
+	if count > estimate {
+		count = estimate
+	}
+	for _, p := range positions {
+		(*counters)[p] -= uint32(count)
+	}
+	return estimate - count, nil
+}
+
+func (counters *spectralCountersMem) min(positions []uint) (uint64, error) {
+	min := uint32(math.MaxUint32)
+	for _, p := range positions {
+		if (*counters)[p] < min {
+			min = (*counters)[p]
+		}
+	}
+	return uint64(min), nil
+}
+
+func (counters *spectralCountersMem) values(_ uint) ([]uint32, error) {
+	return append([]uint32{}, *counters...), nil
+}
+
+func (counters *spectralCountersMem) setValues(values []uint32) error {
+	*counters = values
+	return nil
+}
+
+func (counters *spectralCountersMem) reset() error {
+	for i := range *counters {
+		(*counters)[i] = 0
+	}
+	return nil
+}
+
+// spectralCountersRedis holds the counters of a Redis backed SpectralBloomFilter in the hash
+// at _key_, whose fields are the positions of the counters greater than zero
+type spectralCountersRedis struct {
+	key string
+}
+
+// spectralSetChunkSize is the number of counters written by a single HSET on import
+const spectralSetChunkSize = 1000
+
+func positionArgs(count uint64, positions []uint) []interface{} {
+	args := make([]interface{}, 0, len(positions)+1)
+	args = append(args, count)
+	for _, p := range positions {
+		args = append(args, p)
+	}
+	return args
+}
+
+func (counters *spectralCountersRedis) add(positions []uint, count uint64) error {
+	err := spectralAddScript.Run(context.Background(), getRedisClient(), []string{counters.key}, positionArgs(count, positions)...).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while incrementing counters in redis, error: %v", err)
+	}
+	return nil
+}
+
+func (counters *spectralCountersRedis) remove(positions []uint, count uint64) (uint64, error) {
+	estimate, err := spectralRemoveScript.Run(context.Background(), getRedisClient(), []string{counters.key}, positionArgs(count, positions)...).Uint64()
+	if err != nil {
+		return 0, fmt.Errorf("gostatix: error while decrementing counters in redis, error: %v", err)
+	}
+	return estimate, nil
+}
+
+func (counters *spectralCountersRedis) min(positions []uint) (uint64, error) {
+	fields := make([]string, len(positions))
+	for i, p := range positions {
+		fields[i] = strconv.FormatUint(uint64(p), 10)
+	}
+	values, err := getRedisClient().HMGet(context.Background(), counters.key, fields...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("gostatix: error while fetching counters from redis, error: %v", err)
+	}
+	var min uint64 = math.MaxUint32
+	for _, value := range values {
+		if value == nil {
+			return 0, nil
+		}
+		counter, err := strconv.ParseUint(value.(string), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("gostatix: invalid counter %v in redis at key %s", value, counters.key)
+		}
+		if counter < min {
+			min = counter
+		}
+	}
+	return min, nil
+}
+
+func (counters *spectralCountersRedis) values(size uint) ([]uint32, error) {
+	fields, err := getRedisClient().HGetAll(context.Background(), counters.key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while fetching counters from redis, error: %v", err)
+	}
+	values := make([]uint32, size)
+	for field, value := range fields {
+		position, err := strconv.ParseUint(field, 10, 64)
+		if err != nil || position >= uint64(size) {
+			return nil, fmt.Errorf("gostatix: invalid counter position %s in redis at key %s", field, counters.key)
+		}
+		counter, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("gostatix: invalid counter %s in redis at key %s", value, counters.key)
+		}
+		values[position] = uint32(counter)
+	}
+	return values, nil
+}
+
+func (counters *spectralCountersRedis) setValues(values []uint32) error {
+	ctx := context.Background()
+	_, err := getRedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, counters.key)
+		fields := make([]interface{}, 0, 2*spectralSetChunkSize)
+		for position, value := range values {
+			if value == 0 {
+				continue
+			}
+			fields = append(fields, position, value)
+			if len(fields) == cap(fields) {
+				pipe.HSet(ctx, counters.key, fields...)
+				fields = fields[:0]
+			}
+		}
+		if len(fields) > 0 {
+			pipe.HSet(ctx, counters.key, fields...)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("gostatix: error while writing counters to redis, error: %v", err)
+	}
+	return nil
+}
+
+func (counters *spectralCountersRedis) reset() error {
+	err := getRedisClient().Del(context.Background(), counters.key).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while resetting counters in redis, error: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package gostatix
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSpectralBloomFilter(t *testing.T) {
+	initMockRedis()
+	memFilter, _ := NewSpectralBloomFilter(1000, 0.01)
+	redisFilter, err := NewRedisSpectralBloomFilter(1000, 0.01)
+	if err != nil {
+		t.Fatalf("redis filter creation shouldn't error out, error: %v", err)
+	}
+	for _, filter := range []*SpectralBloomFilter{memFilter, redisFilter} {
+		for i := 0; i < 100; i++ {
+			filter.InsertString(strconv.Itoa(i), uint64(i%5+1))
+		}
+		for i := 0; i < 100; i++ {
+			count, err := filter.CountString(strconv.Itoa(i))
+			if err != nil {
+				t.Fatalf("count shouldn't error out, error: %v", err)
+			}
+			if count < uint64(i%5+1) {
+				t.Errorf("count of %d should be at least %d, got %d", i, i%5+1, count)
+			}
+		}
+		if ok, _ := filter.SeenAtLeast([]byte("4"), 5); !ok {
+			t.Errorf("4 should be seen at least 5 times")
+		}
+		if ok, _ := filter.LookupString("absent"); ok {
+			t.Errorf("absent shouldn't be found in the filter")
+		}
+		count, _ := filter.RemoveString("4", 2)
+		if count < 3 {
+			t.Errorf("count of 4 should be at least 3 after removing it twice, got %d", count)
+		}
+		if count, _ = filter.RemoveString("0", 10); count != 0 {
+			t.Errorf("count of 0 shouldn't go below 0, got %d", count)
+		}
+	}
+
+	data, err := redisFilter.Export()
+	if err != nil {
+		t.Fatalf("export shouldn't error out, error: %v", err)
+	}
+	imported, _ := NewSpectralBloomFilter(10, 0.1)
+	err = imported.Import(data)
+	if err != nil {
+		t.Fatalf("import shouldn't error out, error: %v", err)
+	}
+	memData, _ := memFilter.Export()
+	importedData, _ := imported.Export()
+	if string(memData) != string(importedData) {
+		t.Errorf("in-memory and redis filters with the same elements should export the same counters")
+	}
+
+	loaded, err := NewRedisSpectralBloomFilterFromKey(redisFilter.MetadataKey())
+	if err != nil {
+		t.Fatalf("filter loading shouldn't error out, error: %v", err)
+	}
+	other, _ := NewRedisSpectralBloomFilter(10, 0.1)
+	err = other.Import(memData)
+	if err != nil {
+		t.Fatalf("import in redis shouldn't error out, error: %v", err)
+	}
+	for _, key := range []string{"1", "2", "3"} {
+		expected, _ := loaded.CountString(key)
+		count, _ := other.CountString(key)
+		if count != expected {
+			t.Errorf("count of %s in the imported filter should be %d, got %d", key, expected, count)
+		}
+	}
+	loaded.Reset()
+	if ok, _ := loaded.LookupString("1"); ok {
+		t.Errorf("1 shouldn't be found after reset")
+	}
+}
+
+func TestSpectralBloomFilterSaturation(t *testing.T) {
+	filter, _ := NewSpectralBloomFilter(100, 0.01)
+	filter.InsertString("foo", 1<<40)
+	filter.InsertString("foo", 1)
+	count, _ := filter.CountString("foo")
+	if count != 1<<32-1 {
+		t.Errorf("counters should saturate at the maximum uint32, got %d", count)
+	}
+}
@@ -0,0 +1,339 @@
+/*
+Implements a uniform summary of the data structures, for debug endpoints and monitoring.
+
+Stats: built from the description of a data structure along with its memory usage and the
+time of its last update, rendered as JSON or as Prometheus exposition text by
+WriteStatsJSON and WriteStatsPrometheus.
+*/
+package gostatix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Stats summarizes a data structure, as returned by the Stats method of every data
+// structure
+// _Type_ and _Backend_ are the ones of its Description
+// _ItemsEstimate_ is the estimated number of elements inserted: the number of elements of
+// a cuckoo filter, the estimated number of distinct elements of a bloom filter or a
+// cardinality sketch, over the window of an age-partitioned bloom filter, the total count of a count-min sketch or top-k, the number of events
+// of an exponential histogram and the number of sampled elements of a reservoir sampler
+// _ErrorRate_ is the estimated error of its Description
+// _MemoryUsage_ is the number of bytes used in-process or in Redis, as reported by the
+// MemoryUsage method. It's 0 for the data structures which don't report it.
+// _LastUpdated_ is the time of the last update made through this instance of a bloom
+// filter, cuckoo filter, count-min sketch, hyperloglog or top-k. It's zero if it hasn't
+// been updated since it was created or loaded, and for the other data structures.
+type Stats struct {
+	Type          string
+	Backend       Backend
+	ItemsEstimate uint64
+	ErrorRate     float64
+	MemoryUsage   uint64
+	LastUpdated   time.Time
+}
+
+// StatsReporter is implemented by all the data structures
+type StatsReporter interface {
+	Stats() (Stats, error)
+}
+
+var (
+	_ StatsReporter = (*BloomFilter)(nil)
+	_ StatsReporter = (*CuckooFilter)(nil)
+	_ StatsReporter = (*CuckooFilterRedis)(nil)
+	_ StatsReporter = (*CuckooFilterKV)(nil)
+	_ StatsReporter = (*CountMinSketch)(nil)
+	_ StatsReporter = (*CountMinSketchRedis)(nil)
+	_ StatsReporter = (*CountMinSketchKV)(nil)
+	_ StatsReporter = (*HyperLogLog)(nil)
+	_ StatsReporter = (*HyperLogLogRedis)(nil)
+	_ StatsReporter = (*RollingHyperLogLog)(nil)
+	_ StatsReporter = (*TopK)(nil)
+	_ StatsReporter = (*TopKRedis)(nil)
+	_ StatsReporter = (*LinearCounting)(nil)
+	_ StatsReporter = (*KMinValues)(nil)
+	_ StatsReporter = (*SpectralBloomFilter)(nil)
+	_ StatsReporter = (*AgePartitionedBloomFilter)(nil)
+	_ StatsReporter = (*IBLT)(nil)
+	_ StatsReporter = (*ExponentialHistogram)(nil)
+	_ StatsReporter = (*ReservoirSampler[string])(nil)
+	_ StatsReporter = (*ReservoirSamplerRedis)(nil)
+)
+
+// updateClock records the time of the last update of a data structure
+type updateClock struct {
+	nanos atomic.Int64
+}
+
+// touch records the current time as the time of the last update
+func (c *updateClock) touch() {
+	c.nanos.Store(time.Now().UnixNano())
+}
+
+// get returns the time of the last update, zero if there was none
+func (c *updateClock) get() time.Time {
+	nanos := c.nanos.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// describeStats returns the Stats of _describer_ using _memoryUsage_ and _lastUpdated_
+func describeStats(describer Describer, memoryUsage uint64, lastUpdated time.Time) (Stats, error) {
+	d, err := describer.Describe()
+	if err != nil {
+		return Stats{}, err
+	}
+	return Stats{
+		Type:          d.Type,
+		Backend:       d.Backend,
+		ItemsEstimate: d.Count,
+		ErrorRate:     d.ErrorRate,
+		MemoryUsage:   memoryUsage,
+		LastUpdated:   lastUpdated,
+	}, nil
+}
+
+// bloomItemsEstimate returns the estimated number of distinct elements inserted in a bloom
+// filter of _size_ bits and _numHashes_ hashes with _bitsSet_ bits set (Swamidass & Baldi).
+// It returns _size_ if all the bits are set.
+func bloomItemsEstimate(bitsSet, size, numHashes uint64) uint64 {
+	if bitsSet >= size {
+		return size
+	}
+	return uint64(math.Round(-float64(size) / float64(numHashes) * math.Log(1-float64(bitsSet)/float64(size))))
+}
+
+// Stats returns the stats of the BloomFilter
+func (bloomFilter *BloomFilter) Stats() (Stats, error) {
+	memoryUsage, err := bloomFilter.MemoryUsage()
+	if err != nil {
+		return Stats{}, err
+	}
+	stats, err := describeStats(bloomFilter, memoryUsage, bloomFilter.updated.get())
+	if err != nil {
+		return Stats{}, err
+	}
+	stats.ItemsEstimate = bloomItemsEstimate(stats.ItemsEstimate, uint64(bloomFilter.size), uint64(bloomFilter.numHashes))
+	return stats, nil
+}
+
+// Stats returns the stats of the CuckooFilter
+func (cuckooFilter *CuckooFilter) Stats() (Stats, error) {
+	return describeStats(cuckooFilter, cuckooFilter.MemoryUsage(), cuckooFilter.updated.get())
+}
+
+// Stats returns the stats of the CuckooFilterRedis
+func (cuckooFilter *CuckooFilterRedis) Stats() (Stats, error) {
+	memoryUsage, err := cuckooFilter.MemoryUsage()
+	if err != nil {
+		return Stats{}, err
+	}
+	return describeStats(cuckooFilter, memoryUsage, cuckooFilter.updated.get())
+}
+
+// Stats returns the stats of the CuckooFilterKV. Its memory usage isn't reported.
+func (cuckooFilter *CuckooFilterKV) Stats() (Stats, error) {
+	return describeStats(cuckooFilter, 0, time.Time{})
+}
+
+// Stats returns the stats of the CountMinSketch
+func (cms *CountMinSketch) Stats() (Stats, error) {
+	return describeStats(cms, cms.MemoryUsage(), cms.updated.get())
+}
+
+// Stats returns the stats of the CountMinSketchRedis
+func (cms *CountMinSketchRedis) Stats() (Stats, error) {
+	memoryUsage, err := cms.MemoryUsage()
+	if err != nil {
+		return Stats{}, err
+	}
+	return describeStats(cms, memoryUsage, cms.updated.get())
+}
+
+// Stats returns the stats of the CountMinSketchKV. Its memory usage isn't reported.
+func (cms *CountMinSketchKV) Stats() (Stats, error) {
+	return describeStats(cms, 0, time.Time{})
+}
+
+// Stats returns the stats of the HyperLogLog
+func (h *HyperLogLog) Stats() (Stats, error) {
+	return describeStats(h, h.MemoryUsage(), h.updated.get())
+}
+
+// Stats returns the stats of the HyperLogLogRedis
+func (h *HyperLogLogRedis) Stats() (Stats, error) {
+	memoryUsage, err := h.MemoryUsage()
+	if err != nil {
+		return Stats{}, err
+	}
+	return describeStats(h, memoryUsage, h.updated.get())
+}
+
+// Stats returns the stats of the RollingHyperLogLog
+func (h *RollingHyperLogLog) Stats() (Stats, error) {
+	return describeStats(h, h.MemoryUsage(), time.Time{})
+}
+
+// Stats returns the stats of the TopK
+func (t *TopK) Stats() (Stats, error) {
+	return describeStats(t, t.MemoryUsage(), t.updated.get())
+}
+
+// Stats returns the stats of the TopKRedis
+func (t *TopKRedis) Stats() (Stats, error) {
+	memoryUsage, err := t.MemoryUsage()
+	if err != nil {
+		return Stats{}, err
+	}
+	return describeStats(t, memoryUsage, t.updated.get())
+}
+
+// Stats returns the stats of the LinearCounting
+func (l *LinearCounting) Stats() (Stats, error) {
+	return describeStats(l, l.MemoryUsage(), time.Time{})
+}
+
+// Stats returns the stats of the KMinValues
+func (h *KMinValues) Stats() (Stats, error) {
+	return describeStats(h, h.MemoryUsage(), time.Time{})
+}
+
+// Stats returns the stats of the SpectralBloomFilter. Its memory usage isn't reported.
+func (filter *SpectralBloomFilter) Stats() (Stats, error) {
+	stats, err := describeStats(filter, 0, time.Time{})
+	if err != nil {
+		return Stats{}, err
+	}
+	stats.ItemsEstimate = bloomItemsEstimate(stats.ItemsEstimate, uint64(filter.size), uint64(filter.numHashes))
+	return stats, nil
+}
+
+// Stats returns the stats of the AgePartitionedBloomFilter
+func (filter *AgePartitionedBloomFilter) Stats() (Stats, error) {
+	memoryUsage, err := filter.MemoryUsage()
+	if err != nil {
+		return Stats{}, err
+	}
+	counts, err := filter.sliceCounts()
+	if err != nil {
+		return Stats{}, err
+	}
+	stats, err := describeStats(filter, memoryUsage, time.Time{})
+	if err != nil {
+		return Stats{}, err
+	}
+	// every element sets a bit in _numHashes_ slices, each slice is a bloom filter of a hash
+	var items uint64
+	for _, count := range counts {
+		items += bloomItemsEstimate(count, uint64(filter.sliceSize), 1)
+	}
+	stats.ItemsEstimate = items / uint64(filter.numHashes)
+	return stats, nil
+}
+
+// Stats returns the stats of the IBLT
+func (t *IBLT) Stats() (Stats, error) {
+	return describeStats(t, t.MemoryUsage(), time.Time{})
+}
+
+// Stats returns the stats of the ExponentialHistogram
+func (h *ExponentialHistogram) Stats() (Stats, error) {
+	return describeStats(h, h.MemoryUsage(), time.Time{})
+}
+
+// Stats returns the stats of the ReservoirSampler. Its memory usage isn't reported.
+func (r *ReservoirSampler[T]) Stats() (Stats, error) {
+	return describeStats(r, 0, time.Time{})
+}
+
+// Stats returns the stats of the ReservoirSamplerRedis. Its memory usage isn't reported.
+func (r *ReservoirSamplerRedis) Stats() (Stats, error) {
+	return describeStats(r, 0, time.Time{})
+}
+
+// statsJSON is the JSON form of Stats. The time of the last update is omitted if it's
+// unknown.
+type statsJSON struct {
+	Type          string     `json:"type"`
+	Backend       string     `json:"backend"`
+	ItemsEstimate uint64     `json:"itemsEstimate"`
+	ErrorRate     float64    `json:"errorRate"`
+	MemoryUsage   uint64     `json:"memoryUsage"`
+	LastUpdated   *time.Time `json:"lastUpdated,omitempty"`
+}
+
+// WriteStatsJSON writes _stats_ to _w_ as a JSON object mapping the names of the data
+// structures to their stats
+func WriteStatsJSON(w io.Writer, stats map[string]Stats) error {
+	values := make(map[string]statsJSON, len(stats))
+	for name, s := range stats {
+		value := statsJSON{s.Type, s.Backend.String(), s.ItemsEstimate, s.ErrorRate, s.MemoryUsage, nil}
+		if !s.LastUpdated.IsZero() {
+			lastUpdated := s.LastUpdated.UTC()
+			value.LastUpdated = &lastUpdated
+		}
+		values[name] = value
+	}
+	return json.NewEncoder(w).Encode(values)
+}
+
+// statsMetric is a gauge of the Prometheus exposition of Stats
+type statsMetric struct {
+	name  string
+	help  string
+	value func(s Stats) (float64, bool)
+}
+
+var statsMetrics = []statsMetric{
+	{"gostatix_items_estimate", "Estimated number of elements inserted in the data structure.",
+		func(s Stats) (float64, bool) { return float64(s.ItemsEstimate), true }},
+	{"gostatix_error_rate", "Estimated error of the data structure.",
+		func(s Stats) (float64, bool) { return s.ErrorRate, true }},
+	{"gostatix_memory_bytes", "Number of bytes used by the data structure.",
+		func(s Stats) (float64, bool) { return float64(s.MemoryUsage), true }},
+	{"gostatix_last_updated_timestamp_seconds", "Time of the last update of the data structure.",
+		func(s Stats) (float64, bool) {
+			return float64(s.LastUpdated.UnixNano()) / 1e9, !s.LastUpdated.IsZero()
+		}},
+}
+
+// prometheusLabelEscaper escapes the label values of the Prometheus exposition format
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteStatsPrometheus writes _stats_ to _w_ in the Prometheus text exposition format, as
+// one gauge per field labeled by the name, type and backend of the data structures. The
+// time of the last update is skipped if it's unknown.
+func WriteStatsPrometheus(w io.Writer, stats map[string]Stats) error {
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, metric := range statsMetrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", metric.name, metric.help, metric.name)
+		for _, name := range names {
+			s := stats[name]
+			value, ok := metric.value(s)
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(&b, "%s{name=\"%s\",type=\"%s\",backend=\"%s\"} %s\n", metric.name,
+				prometheusLabelEscaper.Replace(name), prometheusLabelEscaper.Replace(s.Type),
+				s.Backend, strconv.FormatFloat(value, 'g', -1, 64))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,111 @@
+package gostatix
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	filter, _ := NewMemBloomFilterWithParameters(10000, 0.01)
+	stats, err := filter.Stats()
+	if err != nil {
+		t.Fatalf("stats shouldn't error out, error: %v", err)
+	}
+	if stats.Type != "bloom" || stats.ItemsEstimate != 0 || !stats.LastUpdated.IsZero() || stats.MemoryUsage == 0 {
+		t.Errorf("unexpected stats of an empty bloom filter, got %+v", stats)
+	}
+	before := time.Now()
+	for i := 0; i < 1000; i++ {
+		filter.InsertString(strconv.Itoa(i))
+	}
+	stats, _ = filter.Stats()
+	if math.Abs(float64(stats.ItemsEstimate)-1000) > 50 {
+		t.Errorf("items estimate of the bloom filter should be close to 1000, got %d", stats.ItemsEstimate)
+	}
+	if stats.LastUpdated.Before(before) || stats.ErrorRate <= 0 {
+		t.Errorf("unexpected stats of the bloom filter, got %+v", stats)
+	}
+
+	sketch, _ := NewCountMinSketch(4, 100)
+	sketch.UpdateString("foo", 5)
+	cuckoo, _ := NewCuckooFilter(128, 4, 8)
+	cuckoo.InsertString("foo", false)
+	cuckoo.InsertString("bar", false)
+	topk, _ := NewTopK(3, 0.01, 0.99)
+	topk.InsertString("foo", 3)
+	kmv, _ := NewKMinValues(16)
+
+	cases := []struct {
+		reporter StatsReporter
+		typ      string
+		backend  Backend
+		items    uint64
+		updated  bool
+	}{
+		{sketch, "cms", MemoryBackend, 5, true},
+		{cuckoo, "cuckoo", MemoryBackend, 2, true},
+		{topk, "topk", MemoryBackend, 3, true},
+		{kmv, "kmv", MemoryBackend, 0, false},
+	}
+	for _, c := range cases {
+		stats, err := c.reporter.Stats()
+		if err != nil {
+			t.Fatalf("stats of %s shouldn't error out, error: %v", c.typ, err)
+		}
+		if stats.Type != c.typ || stats.Backend != c.backend || stats.ItemsEstimate != c.items || stats.LastUpdated.IsZero() == c.updated {
+			t.Errorf("unexpected stats of %s, got %+v", c.typ, stats)
+		}
+	}
+	lastUpdated := cuckoo.updated.get()
+	cuckoo.RemoveString("baz")
+	if !cuckoo.updated.get().Equal(lastUpdated) {
+		t.Errorf("removing a missing element shouldn't update the cuckoo filter")
+	}
+}
+
+func TestWriteStats(t *testing.T) {
+	stats := map[string]Stats{
+		"users": {Type: "bloom", Backend: RedisBackend, ItemsEstimate: 42, ErrorRate: 0.01, MemoryUsage: 1024, LastUpdated: time.Unix(1700000000, 500000000)},
+		`a"b`:   {Type: "hll", Backend: MemoryBackend, ItemsEstimate: 7, ErrorRate: 0.1, MemoryUsage: 64},
+	}
+	var b bytes.Buffer
+	err := WriteStatsJSON(&b, stats)
+	if err != nil {
+		t.Fatalf("json rendering shouldn't error out, error: %v", err)
+	}
+	var decoded map[string]map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatalf("rendered stats should be valid json, error: %v", err)
+	}
+	if decoded["users"]["backend"] != "redis" || decoded["users"]["itemsEstimate"] != float64(42) || decoded["users"]["lastUpdated"] != "2023-11-14T22:13:20.5Z" {
+		t.Errorf("unexpected json stats of users, got %v", decoded["users"])
+	}
+	if _, ok := decoded[`a"b`]["lastUpdated"]; ok {
+		t.Errorf("unknown last update time shouldn't be rendered")
+	}
+
+	b.Reset()
+	err = WriteStatsPrometheus(&b, stats)
+	if err != nil {
+		t.Fatalf("prometheus rendering shouldn't error out, error: %v", err)
+	}
+	text := b.String()
+	for _, line := range []string{
+		"# TYPE gostatix_items_estimate gauge",
+		`gostatix_items_estimate{name="a\"b",type="hll",backend="memory"} 7`,
+		`gostatix_memory_bytes{name="users",type="bloom",backend="redis"} 1024`,
+		`gostatix_last_updated_timestamp_seconds{name="users",type="bloom",backend="redis"} 1.7000000005e+09`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("prometheus stats should contain %q, got\n%s", line, text)
+		}
+	}
+	if strings.Contains(text, `gostatix_last_updated_timestamp_seconds{name="a\"b"`) {
+		t.Errorf("unknown last update time shouldn't be rendered")
+	}
+}
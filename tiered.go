@@ -0,0 +1,408 @@
+/*
+Implements tiered filters, which write to both a local in-memory filter and a Redis backed
+one, and serve the lookups from memory.
+
+The Redis backed filter holds the state shared by all the processes. The local filter is
+a copy of it which is periodically replaced by a fresh copy from Redis (reconciliation),
+so that the writes of the other processes show up locally after at most one interval,
+without a Redis round trip on the read path. The local writes made while a copy is fetched
+are applied to it before the swap, so that they're never lost locally.
+
+With EnablePubSub, every write is also published on a Redis Pub/Sub channel of the Redis
+backed filter, and the writes of the other processes are applied to the local copy as they
+come, so that it's updated incrementally between the syncs. Pub/Sub doesn't queue messages,
+so the writes published while a process is disconnected only show up at its next sync.
+*/
+package gostatix
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kwertop/gostatix/internal/util"
+	"github.com/redis/go-redis/v9"
+)
+
+// tieredChannelPrefix prefixes the metadata key of a Redis backed filter in the name of the
+// Pub/Sub channel of its tiered filters
+const tieredChannelPrefix = "gostatix:sync:"
+
+// tieredIDLength is the length of the random id of a tiered filter, which prefixes the
+// messages it publishes so that it can skip them when they're received
+const tieredIDLength = 16
+
+// tieredOp is a write to the local filter of a tier, kept while the tier is synced
+type tieredOp struct {
+	op   byte
+	data []byte
+}
+
+// tier keeps the local in-memory copy _local_ of a Redis backed filter
+// _fetch_ returns a fresh copy of the Redis backed filter
+// _pending_ holds the local writes made while a copy is fetched, if _syncing_
+// _channel_ is the Pub/Sub channel of the Redis backed filter, on which the writes are
+// published if _pubSub_ is set, prefixed by _id_
+type tier[F walFilter] struct {
+	local     F
+	fetch     func() (F, error)
+	syncing   bool
+	pending   []tieredOp
+	lock      sync.RWMutex
+	syncLock  sync.Mutex
+	onError   func(error)
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	channel   string
+	id        string
+	pubSub    *redis.PubSub
+	pubLock   sync.RWMutex
+}
+
+// start fetches the first copy of the filter and, if _interval_ is greater than 0, syncs
+// it every _interval_ in the background
+func (t *tier[F]) start(interval time.Duration) error {
+	local, err := t.fetch()
+	if err != nil {
+		return err
+	}
+	t.local = local
+	t.done = make(chan struct{})
+	if interval > 0 {
+		t.wg.Add(1)
+		go t.syncPeriodically(interval)
+	}
+	return nil
+}
+
+func (t *tier[F]) syncPeriodically(interval time.Duration) {
+	defer t.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := t.Sync()
+			if err != nil && t.onError != nil {
+				t.onError(err)
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// current returns the local filter
+func (t *tier[F]) current() F {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.local
+}
+
+// applyLocal applies the write _op_ on _data_ to the local filter and returns its result
+func (t *tier[F]) applyLocal(op byte, data []byte) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.syncing {
+		t.pending = append(t.pending, tieredOp{op, append([]byte(nil), data...)})
+	}
+	ok, _ := t.local.apply(op, data)
+	return ok
+}
+
+// EnablePubSub publishes the writes of the tiered filter on the Pub/Sub channel of the
+// Redis backed filter and applies the writes published by the other tiered filters of the
+// same Redis backed filter to the local copy as they're received. The subscription is
+// confirmed before EnablePubSub returns, so no write published afterwards is missed.
+func (t *tier[F]) EnablePubSub() error {
+	t.pubLock.Lock()
+	defer t.pubLock.Unlock()
+
+	if t.pubSub != nil {
+		return nil
+	}
+	select {
+	case <-t.done:
+		return fmt.Errorf("gostatix: tiered filter is already closed")
+	default:
+	}
+	ctx := context.Background()
+	pubSub := getRedisClient().Subscribe(ctx, t.channel)
+	_, err := pubSub.Receive(ctx)
+	if err != nil {
+		pubSub.Close()
+		return fmt.Errorf("gostatix: error while subscribing to %s, error: %v", t.channel, err)
+	}
+	t.id = util.GenerateRandomString(tieredIDLength)
+	t.pubSub = pubSub
+	t.wg.Add(1)
+	go t.receive(pubSub.Channel())
+	return nil
+}
+
+// receive applies the writes of the other tiered filters received on _messages_
+func (t *tier[F]) receive(messages <-chan *redis.Message) {
+	defer t.wg.Done()
+	for message := range messages {
+		payload := message.Payload
+		if len(payload) <= tieredIDLength || strings.HasPrefix(payload, t.id) {
+			continue
+		}
+		op := payload[tieredIDLength]
+		if op < walInsert || op > walRemove {
+			if t.onError != nil {
+				t.onError(fmt.Errorf("gostatix: unknown operation %d received on %s", op, t.channel))
+			}
+			continue
+		}
+		t.applyLocal(op, []byte(payload[tieredIDLength+1:]))
+	}
+}
+
+// publish publishes the write _op_ on _data_ if Pub/Sub is enabled
+func (t *tier[F]) publish(op byte, data []byte) error {
+	t.pubLock.RLock()
+	defer t.pubLock.RUnlock()
+
+	if t.pubSub == nil {
+		return nil
+	}
+	message := make([]byte, 0, tieredIDLength+1+len(data))
+	message = append(message, t.id...)
+	message = append(message, op)
+	message = append(message, data...)
+	err := getRedisClient().Publish(context.Background(), t.channel, message).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while publishing to %s, error: %v", t.channel, err)
+	}
+	return nil
+}
+
+// write applies the write _op_ on _data_ to the local filter and publishes it
+func (t *tier[F]) write(op byte, data []byte) error {
+	t.applyLocal(op, data)
+	return t.publish(op, data)
+}
+
+// Sync replaces the local filter with a fresh copy of the Redis backed filter right away,
+// e.g. to see the writes of the other processes before the next periodic sync
+func (t *tier[F]) Sync() error {
+	t.syncLock.Lock()
+	defer t.syncLock.Unlock()
+
+	t.lock.Lock()
+	t.syncing = true
+	t.pending = nil
+	t.lock.Unlock()
+
+	fresh, err := t.fetch()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.syncing = false
+	pending := t.pending
+	t.pending = nil
+	if err != nil {
+		return fmt.Errorf("gostatix: error while syncing tiered filter from redis, error: %v", err)
+	}
+	for _, write := range pending {
+		fresh.apply(write.op, write.data)
+	}
+	t.local = fresh
+	return nil
+}
+
+// Close stops the periodic syncs and the Pub/Sub subscription. The Redis backed filter is
+// left open. It's safe to call Close multiple times.
+func (t *tier[F]) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		t.pubLock.Lock()
+		close(t.done)
+		if t.pubSub != nil {
+			err = t.pubSub.Close()
+			t.pubSub = nil
+		}
+		t.pubLock.Unlock()
+	})
+	t.wg.Wait()
+	return err
+}
+
+// TieredBloomFilter writes to both a Redis backed BloomFilter and a local in-memory copy
+// of it, and looks up the elements in the local copy
+type TieredBloomFilter struct {
+	tier[*BloomFilter]
+	remote *BloomFilter
+}
+
+// NewTieredBloomFilter creates a TieredBloomFilter over the Redis backed _remote_ filter,
+// whose local copy is synced from Redis every _interval_ (never if 0).
+// _onError_ (optional, can be nil) is called with the errors of the background syncs.
+func NewTieredBloomFilter(remote *BloomFilter, interval time.Duration, onError func(error)) (*TieredBloomFilter, error) {
+	if isBitSetInProcess(remote.filter) {
+		return nil, fmt.Errorf("gostatix: tiered bloom filter needs a redis backed bloom filter")
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("gostatix: sync interval of tiered filter can't be negative")
+	}
+	t := &TieredBloomFilter{remote: remote}
+	t.onError = onError
+	t.channel = tieredChannelPrefix + remote.metadataKey
+	t.fetch = func() (*BloomFilter, error) {
+		var stream bytes.Buffer
+		_, err := remote.WriteTo(&stream)
+		if err != nil {
+			return nil, err
+		}
+		local := &BloomFilter{}
+		_, err = local.ReadFrom(&stream)
+		return local, err
+	}
+	err := t.start(interval)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Remote returns the Redis backed BloomFilter
+func (t *TieredBloomFilter) Remote() *BloomFilter {
+	return t.remote
+}
+
+// Insert inserts _data_ in the Redis backed filter, then in the local copy, and publishes
+// the insert if Pub/Sub is enabled. The local copy isn't updated if the Redis insert fails.
+func (t *TieredBloomFilter) Insert(data []byte) error {
+	remote := t.remote
+	hashes := remote.getHashes(data)
+	indexes := make([]uint, remote.numHashes)
+	for i := uint(0); i < remote.numHashes; i++ {
+		indexes[i] = remote.getIndex(hashes, i)
+	}
+	_, err := remote.filter.insertMulti(indexes)
+	if err != nil {
+		return fmt.Errorf("gostatix: error while inserting in bloom filter, error: %v", err)
+	}
+	remote.notifyFillWatchers(1)
+	return t.write(walInsert, data)
+}
+
+// InsertString inserts _data_ in the Redis backed filter, then in the local copy
+func (t *TieredBloomFilter) InsertString(data string) error {
+	return t.Insert([]byte(data))
+}
+
+// Lookup returns true if _data_ may be in the local copy of the filter. The elements
+// inserted by other processes are only found after the next sync.
+func (t *TieredBloomFilter) Lookup(data []byte) bool {
+	return t.current().Lookup(data)
+}
+
+// LookupString returns true if _data_ may be in the local copy of the filter
+func (t *TieredBloomFilter) LookupString(data string) bool {
+	return t.Lookup([]byte(data))
+}
+
+// LookupThrough looks up _data_ in the local copy and, if it isn't found, in the Redis
+// backed filter. An element found in Redis is inserted in the local copy, so that it's
+// found locally from then on.
+func (t *TieredBloomFilter) LookupThrough(data []byte) bool {
+	if t.Lookup(data) {
+		return true
+	}
+	if !t.remote.Lookup(data) {
+		return false
+	}
+	t.applyLocal(walInsert, data)
+	return true
+}
+
+// TieredCuckooFilter writes to both a CuckooFilterRedis and a local in-memory copy of it,
+// and looks up the elements in the local copy. As the evictions of the inserts are random,
+// the buckets of the local copy may differ from the Redis ones until the next sync.
+type TieredCuckooFilter struct {
+	tier[*CuckooFilter]
+	remote *CuckooFilterRedis
+}
+
+// NewTieredCuckooFilter creates a TieredCuckooFilter over the _remote_ filter, whose local
+// copy is synced from Redis every _interval_ (never if 0).
+// _onError_ (optional, can be nil) is called with the errors of the background syncs.
+func NewTieredCuckooFilter(remote *CuckooFilterRedis, interval time.Duration, onError func(error)) (*TieredCuckooFilter, error) {
+	if interval < 0 {
+		return nil, fmt.Errorf("gostatix: sync interval of tiered filter can't be negative")
+	}
+	t := &TieredCuckooFilter{remote: remote}
+	t.onError = onError
+	t.channel = tieredChannelPrefix + remote.metadataKey
+	t.fetch = func() (*CuckooFilter, error) {
+		data, err := remote.Export()
+		if err != nil {
+			return nil, err
+		}
+		local, err := NewCuckooFilterWithRetries(remote.size, remote.bucketSize, remote.fingerPrintLength, remote.retries)
+		if err != nil {
+			return nil, err
+		}
+		return local, local.Import(data)
+	}
+	err := t.start(interval)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Remote returns the CuckooFilterRedis
+func (t *TieredCuckooFilter) Remote() *CuckooFilterRedis {
+	return t.remote
+}
+
+// Insert inserts _data_ in the Redis backed filter, then in the local copy, and publishes
+// the insert if Pub/Sub is enabled. It errors out if the Redis backed filter is full, in
+// which case the local copy isn't updated.
+func (t *TieredCuckooFilter) Insert(data []byte, destructive bool) error {
+	_, err := t.remote.InsertWithStats(data, destructive, 0)
+	if err != nil {
+		return err
+	}
+	op := byte(walInsert)
+	if destructive {
+		op = walInsertDestructive
+	}
+	return t.write(op, data)
+}
+
+// Remove removes _data_ from the Redis backed filter, then from the local copy, publishes
+// the removal if Pub/Sub is enabled and returns true if _data_ was found in Redis
+func (t *TieredCuckooFilter) Remove(data []byte) (bool, error) {
+	removed, err := t.remote.Remove(data)
+	if err != nil || !removed {
+		return false, err
+	}
+	return true, t.write(walRemove, data)
+}
+
+// Lookup returns true if _data_ may be in the local copy of the filter. The elements
+// inserted or removed by other processes are only seen after the next sync.
+func (t *TieredCuckooFilter) Lookup(data []byte) bool {
+	return t.current().Lookup(data)
+}
+
+// LookupThrough looks up _data_ in the local copy and, if it isn't found, in the Redis
+// backed filter. The local copy isn't updated, as the fingerprint found in Redis may
+// be in a bucket which differs locally.
+func (t *TieredCuckooFilter) LookupThrough(data []byte) (bool, error) {
+	if t.Lookup(data) {
+		return true, nil
+	}
+	return t.remote.Lookup(data)
+}
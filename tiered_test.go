@@ -0,0 +1,189 @@
+package gostatix
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTieredBloomFilter(t *testing.T) {
+	initMockRedis()
+	remote, _ := NewRedisBloomFilterWithParameters(1000, 0.01)
+	remote.InsertString("before")
+	tiered, err := NewTieredBloomFilter(remote, 0, nil)
+	if err != nil {
+		t.Fatalf("creation shouldn't error out, error: %v", err)
+	}
+	defer tiered.Close()
+	if !tiered.LookupString("before") {
+		t.Errorf("elements inserted before the creation should be found locally")
+	}
+	for i := 0; i < 100; i++ {
+		if err := tiered.InsertString(strconv.Itoa(i)); err != nil {
+			t.Fatalf("insert shouldn't error out, error: %v", err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if !tiered.LookupString(strconv.Itoa(i)) || !remote.LookupString(strconv.Itoa(i)) {
+			t.Errorf("%d should be found locally and in redis", i)
+		}
+	}
+
+	// another process inserts in redis
+	other, _ := NewRedisBloomFilterFromKey(remote.GetMetadataKey())
+	other.InsertString("other")
+	if tiered.LookupString("other") {
+		t.Errorf("elements inserted by other processes shouldn't be found locally before a sync")
+	}
+	if !tiered.LookupThrough([]byte("other")) || !tiered.LookupString("other") {
+		t.Errorf("elements found through redis should be cached locally")
+	}
+	other.InsertString("synced")
+	if err := tiered.Sync(); err != nil {
+		t.Fatalf("sync shouldn't error out, error: %v", err)
+	}
+	if !tiered.LookupString("synced") || !tiered.LookupString("42") {
+		t.Errorf("local copy should hold the elements of redis after a sync")
+	}
+
+	mem, _ := NewMemBloomFilterWithParameters(100, 0.01)
+	if _, err := NewTieredBloomFilter(mem, 0, nil); err == nil {
+		t.Errorf("tiered filter over an in-memory filter should error out")
+	}
+}
+
+func TestTieredBloomFilterPeriodicSync(t *testing.T) {
+	initMockRedis()
+	remote, _ := NewRedisBloomFilterWithParameters(1000, 0.01)
+	tiered, _ := NewTieredBloomFilter(remote, time.Millisecond, func(err error) {
+		t.Errorf("background sync shouldn't error out, error: %v", err)
+	})
+	defer tiered.Close()
+	for i := 0; i < 50; i++ {
+		tiered.InsertString(strconv.Itoa(i))
+	}
+	remote.InsertString("remote")
+	deadline := time.Now().Add(time.Second)
+	for !tiered.LookupString("remote") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !tiered.LookupString("remote") {
+		t.Errorf("local copy should be synced in the background")
+	}
+	for i := 0; i < 50; i++ {
+		if !tiered.LookupString(strconv.Itoa(i)) {
+			t.Errorf("%d inserted locally shouldn't be lost by the syncs", i)
+		}
+	}
+}
+
+func TestTieredCuckooFilter(t *testing.T) {
+	initMockRedis()
+	remote, _ := NewCuckooFilterRedis(100, 4, 8)
+	remote.InsertString("before", false)
+	tiered, err := NewTieredCuckooFilter(remote, 0, nil)
+	if err != nil {
+		t.Fatalf("creation shouldn't error out, error: %v", err)
+	}
+	defer tiered.Close()
+	if !tiered.Lookup([]byte("before")) {
+		t.Errorf("elements inserted before the creation should be found locally")
+	}
+	tiered.Insert([]byte("foo"), false)
+	if ok, _ := remote.LookupString("foo"); !ok || !tiered.Lookup([]byte("foo")) {
+		t.Errorf("inserted element should be found locally and in redis")
+	}
+	removed, err := tiered.Remove([]byte("foo"))
+	if !removed || err != nil {
+		t.Fatalf("removal should succeed, error: %v", err)
+	}
+	if ok, _ := remote.LookupString("foo"); ok || tiered.Lookup([]byte("foo")) {
+		t.Errorf("removed element shouldn't be found locally or in redis")
+	}
+	remote.InsertString("other", false)
+	if ok, _ := tiered.LookupThrough([]byte("other")); !ok {
+		t.Errorf("elements inserted in redis should be found through it")
+	}
+	tiered.Sync()
+	if !tiered.Lookup([]byte("other")) || tiered.current().Length() != 2 {
+		t.Errorf("local copy should be the redis filter after a sync, got length %d", tiered.current().Length())
+	}
+}
+
+// waitFor polls _cond_ until it's true or a second has passed
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+func TestTieredBloomFilterPubSub(t *testing.T) {
+	initMockRedis()
+	remote, _ := NewRedisBloomFilterWithParameters(1000, 0.01)
+	first, _ := NewTieredBloomFilter(remote, 0, nil)
+	defer first.Close()
+	other, _ := NewRedisBloomFilterFromKey(remote.GetMetadataKey())
+	second, _ := NewTieredBloomFilter(other, 0, nil)
+	defer second.Close()
+	if err := first.EnablePubSub(); err != nil {
+		t.Fatalf("enabling pub/sub shouldn't error out, error: %v", err)
+	}
+	if err := second.EnablePubSub(); err != nil {
+		t.Fatalf("enabling pub/sub shouldn't error out, error: %v", err)
+	}
+	if err := first.EnablePubSub(); err != nil {
+		t.Errorf("enabling pub/sub twice shouldn't error out, error: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := first.InsertString(strconv.Itoa(i)); err != nil {
+			t.Fatalf("insert shouldn't error out, error: %v", err)
+		}
+	}
+	second.InsertString("second")
+	if !waitFor(func() bool { return second.LookupString("49") && first.LookupString("second") }) {
+		t.Fatalf("published inserts should be applied to the local copies without a sync")
+	}
+	for i := 0; i < 50; i++ {
+		if !second.LookupString(strconv.Itoa(i)) {
+			t.Errorf("%d should be found in the local copy of the other process", i)
+		}
+	}
+
+	second.Close()
+	second.Close()
+	first.InsertString("after")
+	if err := second.EnablePubSub(); err == nil {
+		t.Errorf("enabling pub/sub after close should error out")
+	}
+}
+
+func TestTieredCuckooFilterPubSub(t *testing.T) {
+	initMockRedis()
+	remote, _ := NewCuckooFilterRedis(100, 4, 8)
+	first, _ := NewTieredCuckooFilter(remote, 0, nil)
+	defer first.Close()
+	other, _ := NewCuckooFilterRedisFromKey(remote.MetadataKey())
+	second, _ := NewTieredCuckooFilter(other, 0, nil)
+	defer second.Close()
+	first.EnablePubSub()
+	second.EnablePubSub()
+	first.Insert([]byte("a"), false)
+	first.Insert([]byte("b"), false)
+	if !waitFor(func() bool { return second.Lookup([]byte("a")) && second.Lookup([]byte("b")) }) {
+		t.Fatalf("published inserts should be applied to the local copies without a sync")
+	}
+	if removed, err := second.Remove([]byte("a")); !removed || err != nil {
+		t.Fatalf("remove should succeed, removed: %v, error: %v", removed, err)
+	}
+	if !waitFor(func() bool { return !first.Lookup([]byte("a")) }) {
+		t.Errorf("published removals should be applied to the local copies without a sync")
+	}
+	if !first.Lookup([]byte("b")) {
+		t.Errorf("b should still be found locally")
+	}
+}
@@ -15,8 +15,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"sort"
-	"strings"
+	"sync"
+	"unsafe"
 )
 
 type heapElement struct {
@@ -65,12 +65,17 @@ func (h minHeap) IndexOf(element string) int {
 // _accuracy_ is the delta in the error rate
 // _sketch_ is the in-memory count-min sketch used to keep the estimated track of counts
 // _heap_ is a min heap
+// _lock_ is used to synchronize concurrent read/writes of the heap and the sketch
+// _updated_ records the time of the last update
 type TopK struct {
 	k         uint
 	errorRate float64
 	accuracy  float64
 	sketch    *CountMinSketch
 	heap      minHeap
+	lock      sync.RWMutex
+	resources resources
+	updated   updateClock
 }
 
 // TopKElement is the struct used to return the results of the TopK
@@ -79,14 +84,30 @@ type TopKElement struct {
 	count   uint64
 }
 
+// Element returns the value of the TopKElement
+func (e TopKElement) Element() string {
+	return e.element
+}
+
+// Count returns the estimated count of the TopKElement
+func (e TopKElement) Count() uint64 {
+	return e.count
+}
+
 // NewTopK creates new TopK
 // _k_ is the number of top elements to track
 // _errorRate_ is the acceptable error rate in topk estimation
 // _accuracy_ is the delta in the error rate
-func NewTopK(k uint, errorRate, accuracy float64) *TopK {
-	sketch, _ := NewCountMinSketchFromEstimates(errorRate, accuracy)
-	heap := &minHeap{}
-	return &TopK{k, errorRate, accuracy, sketch, *heap}
+func NewTopK(k uint, errorRate, accuracy float64) (*TopK, error) {
+	err := TopKParams{k, errorRate, accuracy}.Validate()
+	if err != nil {
+		return nil, err
+	}
+	sketch, err := NewCountMinSketchFromEstimates(errorRate, accuracy)
+	if err != nil {
+		return nil, err
+	}
+	return &TopK{k: k, errorRate: errorRate, accuracy: accuracy, sketch: sketch}, nil
 }
 
 // Insert puts the _data_ (byte slice) in the TopK data structure with _count_
@@ -97,9 +118,27 @@ func (t *TopK) Insert(data []byte, count uint64) {
 	if count <= 0 {
 		panic("count must be greater than zero")
 	}
-	sketch := t.sketch
-	sketch.Update(data, count)
-	frequency := sketch.Count(data)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.updated.touch()
+
+	t.push(element, t.sketch.updateAndCount(data, count))
+}
+
+// insertHashes puts the elements _data_ whose hashes (see getHashes) are _hashes_ in the
+// TopK data structure with a count of 1 each under a single lock, without hashing them again
+func (t *TopK) insertHashes(data [][]byte, hashes [][4]uint64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.updated.touch()
+
+	for i, hash := range hashes {
+		t.push(string(data[i]), t.sketch.updateAndCountHashes(hash[0], hash[1], 1))
+	}
+}
+
+// push records the estimated _frequency_ of _element_ in the heap if it's among the top k
+func (t *TopK) push(element string, frequency uint64) {
 	if uint(len(t.heap)) < t.k || frequency >= t.heap[0].frequency {
 		index := t.heap.IndexOf(element)
 		if index > -1 {
@@ -112,24 +151,77 @@ func (t *TopK) Insert(data []byte, count uint64) {
 	}
 }
 
+// InsertString puts the _data_ (string) in the TopK data structure with _count_
+func (t *TopK) InsertString(data string, count uint64) {
+	t.Insert([]byte(data), count)
+}
+
+// InsertUint64 puts the _data_ (unsigned integer) in the TopK data structure with _count_.
+// The integer is stored as its 8 byte big endian encoding, which is the element reported by Values.
+func (t *TopK) InsertUint64(data uint64, count uint64) {
+	t.Insert(uint64Bytes(data), count)
+}
+
+// InsertKey puts the _data_ (Key) in the TopK data structure with _count_
+func (t *TopK) InsertKey(data Key, count uint64) {
+	t.Insert(data.KeyBytes(), count)
+}
+
+// Decrement decreases the count of the _data_ (byte slice) in the TopK data structure by
+// _count_, it's used to apply corrections such as deduplicated or retracted events.
+// The estimated count doesn't go below zero. The element is evicted from the heap if its
+// estimated count drops to zero or below the current minimum of a full heap, else its
+// count in the heap is adjusted.
+func (t *TopK) Decrement(data []byte, count uint64) {
+	if count <= 0 {
+		panic("count must be greater than zero")
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.updated.touch()
+
+	t.adjust(string(data), t.sketch.decrement(data, count))
+}
+
+// Remove deletes the _data_ (byte slice) from the TopK data structure by decrementing
+// it by its estimated count and evicting it from the heap
+func (t *TopK) Remove(data []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.updated.touch()
+
+	count := t.sketch.Count(data)
+	if count > 0 {
+		t.sketch.decrement(data, count)
+	}
+	t.adjust(string(data), 0)
+}
+
+// adjust updates the heap entry of the _element_ after a decrement to its new estimated
+// _frequency_, evicting it if the frequency is zero or below the minimum of a full heap
+func (t *TopK) adjust(element string, frequency uint64) {
+	index := t.heap.IndexOf(element)
+	if index <= -1 {
+		return
+	}
+	if frequency == 0 || (uint(len(t.heap)) >= t.k && frequency < t.heap[0].frequency) {
+		heap.Remove(&t.heap, index)
+		return
+	}
+	t.heap[index].frequency = frequency
+	heap.Fix(&t.heap, index)
+}
+
 // Values returns the top _k_ elements in the TopK data structure
 func (t *TopK) Values() []TopKElement {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
 	var results []TopKElement
 	for i := len(t.heap) - 1; i >= 0; i-- {
 		results = append(results, TopKElement{t.heap[i].value, t.heap[i].frequency})
 	}
-	sort.Slice(results, func(i, j int) bool {
-		if results[i].count == results[j].count {
-			c := strings.Compare(results[i].element, results[j].element)
-			if c == -1 {
-				return true
-			}
-			if c == 1 {
-				return false
-			}
-		}
-		return results[i].count > results[j].count
-	})
+	sortTopKElements(results)
 	return results
 }
 
@@ -149,8 +241,65 @@ type topKJSON struct {
 	HeapKey   string             `json:"hk"`
 }
 
+// validate checks the parameters, sketch and heap of the decoded snapshot
+func (topk *topKJSON) validate() error {
+	err := checkTopKParams(uint64(topk.K))
+	if err != nil {
+		return err
+	}
+	if uint(len(topk.Heap)) > topk.K {
+		return fmt.Errorf("gostatix: invalid top-k snapshot, heap of %d elements is larger than k %d", len(topk.Heap), topk.K)
+	}
+	return topk.Sketch.validate()
+}
+
+// checkTopKParams validates the _k_ of a decoded snapshot
+func checkTopKParams(k uint64) error {
+	if k == 0 {
+		return fmt.Errorf("gostatix: invalid top-k snapshot, k should be greater than 0")
+	}
+	return checkSnapshotSize("top-k heap", k, stringSize+8)
+}
+
+// Reset removes all the elements of the TopK, zeroing its sketch and emptying its heap
+func (t *TopK) Reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.updated.touch()
+
+	t.sketch.Reset()
+	t.heap = t.heap[:0]
+}
+
+// MemoryUsage returns the estimated number of bytes used in-process by the TopK, its
+// count-min sketch and the tracked elements
+func (t *TopK) MemoryUsage() uint64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	bytes := uint64(unsafe.Sizeof(*t)) + t.sketch.MemoryUsage() + uint64(cap(t.heap))*uint64(unsafe.Sizeof(heapElement{}))
+	for i := range t.heap {
+		bytes += uint64(len(t.heap[i].value))
+	}
+	return bytes
+}
+
+// Close releases the resources attached to the TopK and its count-min sketch.
+// It's safe to call Close multiple times.
+func (t *TopK) Close() error {
+	err := t.resources.close()
+	sketchErr := t.sketch.Close()
+	if err != nil {
+		return err
+	}
+	return sketchErr
+}
+
 // Export JSON marshals the TopK and returns a byte slice containing the data
 func (t *TopK) Export() ([]byte, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
 	var sketch countMinSketchJSON
 	sketch.AllSum = t.sketch.allSum
 	sketch.Columns = t.sketch.columns
@@ -165,11 +314,23 @@ func (t *TopK) Export() ([]byte, error) {
 
 // Import JSON unmarshals the _data_ into the TopK
 func (t *TopK) Import(data []byte) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
 	var topk topKJSON
-	err := json.Unmarshal(data, &topk)
+	err = json.Unmarshal(data, &topk)
 	if err != nil {
 		return fmt.Errorf("gostatix: error while unmarshalling data, error %v", err)
 	}
+	err = topk.validate()
+	if err != nil {
+		return err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.updated.touch()
+
 	t.k = topk.K
 	t.accuracy = topk.Accuracy
 	t.errorRate = topk.ErrorRate
@@ -188,32 +349,62 @@ func (t *TopK) Import(data []byte) error {
 	return nil
 }
 
-// Equals checks if two TopK structures are equal
+// Equals checks if two TopK structures are equal. TopKs with different parameters
+// aren't equal.
 func (t *TopK) Equals(u *TopK) (bool, error) {
-	if t.k != u.k {
-		return false, fmt.Errorf("parameter k are not equal, %d and %d", t.k, u.k)
-	}
-	if t.accuracy != u.accuracy {
-		return false, fmt.Errorf("parameter accuracy are not equal, %f and %f", t.accuracy, u.accuracy)
+	comparison, err := t.Compare(u)
+	return comparison.Equal(), err
+}
+
+// Compare compares two TopK structures and returns why they aren't equal, if they aren't
+func (t *TopK) Compare(u *TopK) (Comparison, error) {
+	comparison := compareTopKParams(TopKParams{t.k, t.errorRate, t.accuracy}, TopKParams{u.k, u.errorRate, u.accuracy})
+	if !comparison.Equal() || t == u {
+		return comparison, nil
 	}
-	if t.errorRate != u.errorRate {
-		return false, fmt.Errorf("parameter errorRate are not equal, %f and %f", t.errorRate, u.errorRate)
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if ok, _ := t.sketch.Equals(u.sketch); !ok {
+		return contentMismatch("sketches"), nil
 	}
-	if !t.sketch.Equals(u.sketch) {
-		return false, fmt.Errorf("sketches aren't equal")
+	if len(t.heap) != len(u.heap) {
+		return contentMismatch("heaps"), nil
 	}
 	for i := range t.heap {
 		if t.heap[i] != u.heap[i] {
-			return false, fmt.Errorf("heaps aren't equal")
+			return contentMismatch("heaps"), nil
 		}
 	}
-	return true, nil
+	return equalComparison, nil
+}
+
+// compareTopKParams compares the parameters _t_ and _u_ of two TopK structures
+func compareTopKParams(t, u TopKParams) Comparison {
+	if t.K != u.K {
+		return parameterMismatch("k", t.K, u.K)
+	}
+	if t.Accuracy != u.Accuracy {
+		return parameterMismatch("accuracy", t.Accuracy, u.Accuracy)
+	}
+	if t.ErrorRate != u.ErrorRate {
+		return parameterMismatch("errorRate", t.ErrorRate, u.ErrorRate)
+	}
+	return equalComparison
 }
 
 // WriteTo writes the TopK onto the specified _stream_ and returns the
 // number of bytes written.
 // It can be used to write to disk (using a file stream) or to network.
+// The stream ends with the checksum of the TopK, verified by ReadFrom.
 func (t *TopK) WriteTo(stream io.Writer) (int64, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	checksum := newChecksumWriter(stream)
+	stream = checksum
 	err := binary.Write(stream, binary.BigEndian, uint64(t.k))
 	if err != nil {
 		return 0, err
@@ -226,13 +417,18 @@ func (t *TopK) WriteTo(stream io.Writer) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	numBytesSketch, err := t.sketch.WriteTo(stream)
+	numBytesSketch, err := t.sketch.writeTo(stream)
 	if err != nil {
 		return 0, err
 	}
 	numBytesHeap := int64(0)
 	for i := uint(0); i < t.k; i++ {
-		element := t.heap[i]
+		// a heap holding fewer than _k_ elements is padded with empty
+		// elements of zero frequency which are skipped by ReadFrom
+		var element heapElement
+		if i < uint(len(t.heap)) {
+			element = t.heap[i]
+		}
 		err := binary.Write(stream, binary.BigEndian, uint64(len(element.value)))
 		if err != nil {
 			return 0, err
@@ -247,16 +443,29 @@ func (t *TopK) WriteTo(stream io.Writer) (int64, error) {
 		}
 		numBytesHeap += int64(bytesStr + 2*binary.Size(uint64(0)))
 	}
-	return numBytesSketch + numBytesHeap + int64(3*binary.Size(uint64(0))), nil
+	err = checksum.writeTrailer()
+	if err != nil {
+		return 0, err
+	}
+	return numBytesSketch + numBytesHeap + int64(3*binary.Size(uint64(0))+checksumTrailerSize), nil
 }
 
 // ReadFrom reads the TopK from the specified _stream_ and returns the
 // number of bytes read.
 // It can be used to read from disk (using a file stream) or from network.
+// A stream cut short or which doesn't match its checksum errors out with an
+// ErrCorruptSnapshot and leaves the TopK unchanged.
 func (t *TopK) ReadFrom(stream io.Reader) (int64, error) {
+	decompressed, err := decompressStream(stream)
+	if err != nil {
+		return 0, err
+	}
+	defer decompressed.Close()
+	checksum := newChecksumReader(decompressed)
+	stream = checksum
 	var k uint64
 	var errorRate, accuracy float64
-	err := binary.Read(stream, binary.BigEndian, &k)
+	err = binary.Read(stream, binary.BigEndian, &k)
 	if err != nil {
 		return 0, err
 	}
@@ -268,8 +477,12 @@ func (t *TopK) ReadFrom(stream io.Reader) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
+	err = checkTopKParams(k)
+	if err != nil {
+		return 0, err
+	}
 	sketch, _ := NewCountMinSketch(1, 1)
-	numBytesSketch, err := sketch.ReadFrom(stream)
+	numBytesSketch, err := sketch.readFrom(stream, nil)
 	if err != nil {
 		return 0, err
 	}
@@ -281,8 +494,7 @@ func (t *TopK) ReadFrom(stream io.Reader) (int64, error) {
 		if err != nil {
 			return 0, err
 		}
-		b := make([]byte, strLen)
-		_, err = io.ReadFull(stream, b)
+		b, err := readBytes(stream, strLen)
 		if err != nil {
 			return 0, err
 		}
@@ -290,12 +502,24 @@ func (t *TopK) ReadFrom(stream io.Reader) (int64, error) {
 		if err != nil {
 			return 0, err
 		}
+		numBytesHeap += int64(len(b) + 2*binary.Size(uint64(0)))
+		if frequency == 0 {
+			continue
+		}
 		*heap = append(*heap, heapElement{value: string(b), frequency: frequency})
 	}
+	trailer, err := checksum.verify()
+	if err != nil {
+		return 0, err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.updated.touch()
+
 	t.k = uint(k)
 	t.accuracy = accuracy
 	t.errorRate = errorRate
 	t.heap = *heap
 	t.sketch = sketch
-	return numBytesSketch + numBytesHeap + int64(3*binary.Size(uint64(0))), nil
+	return numBytesSketch + numBytesHeap + int64(3*binary.Size(uint64(0))) + trailer, nil
 }
@@ -0,0 +1,118 @@
+package gostatix
+
+import (
+	"sort"
+)
+
+// TopKChange is the change of the count of an element which is in two top-K snapshots
+// _Element_ is the element
+// _Before_ and _After_ are its counts in the first and the second snapshot
+type TopKChange struct {
+	Element string
+	Before  uint64
+	After   uint64
+}
+
+// Delta returns the count of the element in the second snapshot minus the one in the first
+func (c TopKChange) Delta() int64 {
+	return int64(c.After) - int64(c.Before)
+}
+
+// TopKDiff holds the changes between two top-K snapshots, e.g. the top talkers of two
+// time windows.
+// _Entered_ holds the elements of the second snapshot which aren't in the first one, with
+// their counts in the second one, by decreasing count
+// _Exited_ holds the elements of the first snapshot which aren't in the second one, with
+// their counts in the first one, by decreasing count
+// _Changed_ holds the elements of both snapshots whose count changed, by decreasing
+// absolute delta
+type TopKDiff struct {
+	Entered []TopKElement
+	Exited  []TopKElement
+	Changed []TopKChange
+}
+
+// Empty returns true if the two snapshots hold the same elements with the same counts
+func (d TopKDiff) Empty() bool {
+	return len(d.Entered) == 0 && len(d.Exited) == 0 && len(d.Changed) == 0
+}
+
+// DiffTopKElements returns the changes from the top-K snapshot _before_ to the snapshot
+// _after_, as returned by the Values methods of TopK and TopKRedis, so that snapshots of
+// both can be compared
+func DiffTopKElements(before, after []TopKElement) TopKDiff {
+	var diff TopKDiff
+	beforeCounts := make(map[string]uint64, len(before))
+	for _, e := range before {
+		beforeCounts[e.element] = e.count
+	}
+	afterCounts := make(map[string]uint64, len(after))
+	for _, e := range after {
+		afterCounts[e.element] = e.count
+		count, ok := beforeCounts[e.element]
+		if !ok {
+			diff.Entered = append(diff.Entered, e)
+		} else if count != e.count {
+			diff.Changed = append(diff.Changed, TopKChange{e.element, count, e.count})
+		}
+	}
+	for _, e := range before {
+		if _, ok := afterCounts[e.element]; !ok {
+			diff.Exited = append(diff.Exited, e)
+		}
+	}
+	sortTopKElements(diff.Entered)
+	sortTopKElements(diff.Exited)
+	sortTopKChanges(diff.Changed)
+	return diff
+}
+
+// sortTopKChanges sorts _changes_ by decreasing absolute delta, then by element
+func sortTopKChanges(changes []TopKChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := absDelta(changes[i]), absDelta(changes[j])
+		if a == b {
+			return changes[i].Element < changes[j].Element
+		}
+		return a > b
+	})
+}
+
+// absDelta returns the absolute change of the count of _c_
+func absDelta(c TopKChange) uint64 {
+	if c.After > c.Before {
+		return c.After - c.Before
+	}
+	return c.Before - c.After
+}
+
+// sortTopKElements sorts _elements_ by decreasing count, then by element
+func sortTopKElements(elements []TopKElement) {
+	sort.Slice(elements, func(i, j int) bool {
+		if elements[i].count == elements[j].count {
+			return elements[i].element < elements[j].element
+		}
+		return elements[i].count > elements[j].count
+	})
+}
+
+// Diff returns the changes from the top elements of the TopK to the ones of _other_, e.g.
+// from the TopK of the previous time window to the one of the current window. The TopKs
+// don't need to have the same parameters.
+func (t *TopK) Diff(other *TopK) TopKDiff {
+	return DiffTopKElements(t.Values(), other.Values())
+}
+
+// Diff returns the changes from the top elements of the TopKRedis to the ones of _other_,
+// like TopK.Diff
+func (t *TopKRedis) Diff(other *TopKRedis) (TopKDiff, error) {
+	before, err := t.Values()
+	if err != nil {
+		return TopKDiff{}, err
+	}
+	after, err := other.Values()
+	if err != nil {
+		return TopKDiff{}, err
+	}
+	return DiffTopKElements(before, after), nil
+}
@@ -0,0 +1,59 @@
+package gostatix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTopKDiff(t *testing.T) {
+	previous, _ := NewTopK(3, 0.001, 0.999)
+	current, _ := NewTopK(3, 0.001, 0.999)
+	for element, count := range map[string]uint64{"a": 10, "b": 8, "c": 5} {
+		previous.InsertString(element, count)
+	}
+	for element, count := range map[string]uint64{"a": 10, "b": 2, "d": 7} {
+		current.InsertString(element, count)
+	}
+	diff := previous.Diff(current)
+	if !reflect.DeepEqual(diff.Entered, []TopKElement{{"d", 7}}) {
+		t.Errorf("d should have entered the top elements, got %v", diff.Entered)
+	}
+	if !reflect.DeepEqual(diff.Exited, []TopKElement{{"c", 5}}) {
+		t.Errorf("c should have exited the top elements, got %v", diff.Exited)
+	}
+	if !reflect.DeepEqual(diff.Changed, []TopKChange{{"b", 8, 2}}) || diff.Changed[0].Delta() != -6 {
+		t.Errorf("count of b should have changed by -6, got %v", diff.Changed)
+	}
+	if diff.Empty() || !previous.Diff(previous).Empty() {
+		t.Errorf("only the diff of equal snapshots should be empty")
+	}
+
+	changes := DiffTopKElements([]TopKElement{{"x", 1}, {"y", 10}}, []TopKElement{{"x", 5}, {"y", 12}})
+	if len(changes.Changed) != 2 || changes.Changed[0].Element != "x" {
+		t.Errorf("changes should be sorted by decreasing absolute delta, got %v", changes.Changed)
+	}
+}
+
+func TestTopKRedisDiff(t *testing.T) {
+	initMockRedis()
+	previous, _ := NewTopKRedis(3, 0.001, 0.999)
+	current, _ := NewTopKRedis(3, 0.001, 0.999)
+	previous.InsertString("a", 10)
+	previous.InsertString("c", 5)
+	current.InsertString("a", 12)
+	current.InsertString("d", 7)
+	diff, err := previous.Diff(current)
+	if err != nil {
+		t.Fatalf("diff shouldn't error out, error: %v", err)
+	}
+	if len(diff.Entered) != 1 || len(diff.Exited) != 1 || !reflect.DeepEqual(diff.Changed, []TopKChange{{"a", 10, 12}}) {
+		t.Errorf("diff should report d entering, c exiting and a changing, got %+v", diff)
+	}
+	local, _ := NewTopK(3, 0.001, 0.999)
+	local.InsertString("a", 12)
+	local.InsertString("d", 7)
+	values, _ := current.Values()
+	if !DiffTopKElements(values, local.Values()).Empty() {
+		t.Errorf("snapshots of a TopKRedis and a TopK with the same counts should have no diff")
+	}
+}
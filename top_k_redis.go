@@ -13,11 +13,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"sort"
+	"math"
 	"strconv"
-	"strings"
 
-	"github.com/kwertop/gostatix/internal/util"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -28,6 +26,7 @@ import (
 // _sketch_ is the redis backed count-min sketch used to keep the estimated track of counts
 // _heapKey_ is a key to Redis sorted set
 // _metadataKey_ is used to store the additional information about TopKRedis
+// _updated_ records the time of the last update made through the top-k
 type TopKRedis struct {
 	k           uint
 	errorRate   float64
@@ -35,40 +34,122 @@ type TopKRedis struct {
 	sketch      *CountMinSketchRedis
 	heapKey     string
 	metadataKey string
+	resources   resources
+	updated     updateClock
 }
 
 // NewTopKRedis creates new TopKRedis
 // _k_ is the number of top elements to track
 // _errorRate_ is the acceptable error rate in topk estimation
 // _accuracy_ is the delta in the error rate
-func NewTopKRedis(k uint, errorRate, accuracy float64) *TopKRedis {
-	sketch, _ := NewCountMinSketchRedisFromEstimates(errorRate, accuracy)
-	heapKey := util.GenerateRandomString(16)
-	metadataKey := util.GenerateRandomString(16)
+// _opts_ can name the Redis keys of the TopKRedis with WithName and WithKeyPrefix, its
+// sketch is named after it
+func NewTopKRedis(k uint, errorRate, accuracy float64, opts ...Option) (*TopKRedis, error) {
+	err := TopKParams{k, errorRate, accuracy}.Validate()
+	if err != nil {
+		return nil, err
+	}
+	o, err := redisOptions("topk", opts)
+	if err != nil {
+		return nil, err
+	}
+	metadataKey, err := o.metadataKey()
+	if err != nil {
+		return nil, err
+	}
+	heapKey, err := o.dataKey(":heap")
+	if err != nil {
+		return nil, err
+	}
+	sketch, err := NewCountMinSketchRedisFromEstimates(errorRate, accuracy, o.keyOptions(":cms")...)
+	if err != nil {
+		return nil, err
+	}
 	metadata := make(map[string]interface{})
 	metadata["k"] = k
 	metadata["heapKey"] = heapKey
 	metadata["errorRate"] = errorRate
 	metadata["accuracy"] = accuracy
 	metadata["sketchKey"] = sketch.MetadataKey()
-	err := getRedisClient().HSet(context.Background(), metadataKey, metadata).Err()
+	err = saveMetadata(metadataKey, "topk", metadata)
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("gostatix: error while creating topk redis, error: %v", err)
 	}
-	return &TopKRedis{k, errorRate, accuracy, sketch, heapKey, metadataKey}
+	return &TopKRedis{k, errorRate, accuracy, sketch, heapKey, metadataKey, resources{}, updateClock{}}, nil
 }
 
 // NewTopKRedisFromKey is used to create a new Redis backed TopKRedis from the
 // _metadataKey_ (the Redis key used to store the metadata about the TopK) passed.
 // For this to work, value should be present in Redis at _heapKey_
-func NewTopKRedisFromKey(metadataKey string) *TopKRedis {
-	values, _ := getRedisClient().HGetAll(context.Background(), metadataKey).Result()
-	k, _ := strconv.ParseUint(values["k"], 10, 32)
-	errorRate, _ := strconv.ParseFloat(values["errorRate"], 64)
-	accuracy, _ := strconv.ParseFloat(values["accuracy"], 64)
-	sketch, _ := NewCountMinSketchRedisFromKey(values["sketchKey"])
-	heapKey := values["heapKey"]
-	return &TopKRedis{uint(k), errorRate, accuracy, sketch, heapKey, metadataKey}
+func NewTopKRedisFromKey(metadataKey string) (*TopKRedis, error) {
+	metadata, err := loadMetadata(metadataKey, "topk")
+	if err != nil {
+		return nil, err
+	}
+	k, err := metadata.uint("k")
+	if err != nil {
+		return nil, err
+	}
+	errorRate, err := metadata.float("errorRate")
+	if err != nil {
+		return nil, err
+	}
+	accuracy, err := metadata.float("accuracy")
+	if err != nil {
+		return nil, err
+	}
+	heapKey, err := metadata.string("heapKey")
+	if err != nil {
+		return nil, err
+	}
+	sketchKey, err := metadata.string("sketchKey")
+	if err != nil {
+		return nil, err
+	}
+	if k > math.MaxUint32 {
+		return nil, fmt.Errorf("gostatix: invalid topk metadata at key %s, k %d is too large", metadataKey, k)
+	}
+	err = TopKParams{uint(k), errorRate, accuracy}.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: invalid topk metadata at key %s, error: %v", metadataKey, err)
+	}
+	sketch, err := NewCountMinSketchRedisFromKey(sketchKey)
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while loading count min sketch of topk at key %s, error: %v", metadataKey, err)
+	}
+	return &TopKRedis{uint(k), errorRate, accuracy, sketch, heapKey, metadataKey, resources{}, updateClock{}}, nil
+}
+
+// NewOrOpenTopKRedis creates a TopKRedis named _name_ (see WithName) with _k_, _errorRate_
+// and _accuracy_, or opens it like NewTopKRedisFromKey if it already exists, in which case
+// it must have been created with the same parameters
+func NewOrOpenTopKRedis(name string, k uint, errorRate, accuracy float64, opts ...Option) (*TopKRedis, error) {
+	opts, err := namedOptions(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	o, err := redisOptions("topk", opts)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := o.nameExists()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return NewTopKRedis(k, errorRate, accuracy, opts...)
+	}
+	metadataKey := o.keyPrefix + o.name
+	topk, err := NewTopKRedisFromKey(metadataKey)
+	if err != nil {
+		return nil, err
+	}
+	err = checkOpenedParams("topk", metadataKey,
+		[]interface{}{topk.k, topk.errorRate, topk.accuracy}, []interface{}{k, errorRate, accuracy})
+	if err != nil {
+		return nil, err
+	}
+	return topk, nil
 }
 
 // MetadataKey returns the metadataKey
@@ -84,47 +165,62 @@ func (t *TopKRedis) Insert(data []byte, count uint64) error {
 	if count <= 0 {
 		panic("count must be greater than zero")
 	}
-	t.sketch.Update(data, count)
-	frequency, err := t.sketch.Count(data)
+	frequency, err := t.sketch.updateAndCount(data, count)
 	if err != nil {
 		return err
 	}
-	heapLength, err := getRedisClient().ZCard(context.Background(), t.heapKey).Uint64()
+	t.updated.touch()
+	return t.updateHeap(element, frequency)
+}
+
+// InsertString puts the _data_ (string) in the TopKRedis data structure with _count_
+func (t *TopKRedis) InsertString(data string, count uint64) error {
+	return t.Insert([]byte(data), count)
+}
+
+// InsertUint64 puts the _data_ (unsigned integer) in the TopKRedis data structure with _count_.
+// The integer is stored as its 8 byte big endian encoding, which is the element reported by Values.
+func (t *TopKRedis) InsertUint64(data uint64, count uint64) error {
+	return t.Insert(uint64Bytes(data), count)
+}
+
+// InsertKey puts the _data_ (Key) in the TopKRedis data structure with _count_
+func (t *TopKRedis) InsertKey(data Key, count uint64) error {
+	return t.Insert(data.KeyBytes(), count)
+}
+
+// Decrement decreases the count of the _data_ (byte slice) in the TopKRedis data structure
+// by _count_, it's used to apply corrections such as deduplicated or retracted events.
+// The estimated count doesn't go below zero. The element is evicted from the heap if its
+// estimated count drops to zero or below the current minimum of a full heap, else its
+// score in the heap is adjusted.
+func (t *TopKRedis) Decrement(data []byte, count uint64) error {
+	if count <= 0 {
+		panic("count must be greater than zero")
+	}
+	frequency, err := t.sketch.decrement(data, count)
 	if err != nil {
 		return err
 	}
-	minElement, err := getRedisClient().ZRangeWithScores(context.Background(), t.heapKey, 0, 0).Result()
+	t.updated.touch()
+	return t.adjustHeap(string(data), frequency)
+}
+
+// Remove deletes the _data_ (byte slice) from the TopKRedis data structure by decrementing
+// it by its estimated count and evicting it from the heap
+func (t *TopKRedis) Remove(data []byte) error {
+	count, err := t.sketch.Count(data)
 	if err != nil {
 		return err
 	}
-	if heapLength < uint64(t.k) || (len(minElement) > 0 && frequency >= uint64(minElement[0].Score)) {
-		index := getRedisClient().ZScore(context.Background(), t.heapKey, element).Val()
-		if index > 0 {
-			err := getRedisClient().ZRem(context.Background(), t.heapKey, element).Err()
-			if err != nil {
-				return err
-			}
-		}
-		err = getRedisClient().ZAdd(
-			context.Background(),
-			t.heapKey,
-			redis.Z{Score: float64(frequency), Member: element},
-		).Err()
+	if count > 0 {
+		_, err = t.sketch.decrement(data, count)
 		if err != nil {
 			return err
 		}
-		heapLength, err = getRedisClient().ZCard(context.Background(), t.heapKey).Uint64()
-		if err != nil {
-			return err
-		}
-		if heapLength > uint64(t.k) {
-			err := getRedisClient().ZPopMin(context.Background(), t.heapKey).Err()
-			if err != nil {
-				return err
-			}
-		}
 	}
-	return nil
+	t.updated.touch()
+	return t.adjustHeap(string(data), 0)
 }
 
 // Values returns the top _k_ elements in the TopKRedis data structure
@@ -137,37 +233,63 @@ func (t *TopKRedis) Values() ([]TopKElement, error) {
 	for i := len(elements) - 1; i >= 0; i-- {
 		results = append(results, TopKElement{elements[i].Member.(string), uint64(elements[i].Score)})
 	}
-	sort.Slice(results, func(i, j int) bool {
-		if results[i].count == results[j].count {
-			c := strings.Compare(results[i].element, results[j].element)
-			if c == -1 {
-				return true
-			}
-			if c == 1 {
-				return false
-			}
-		}
-		return results[i].count > results[j].count
-	})
+	sortTopKElements(results)
 	return results, nil
 }
 
-// Equals checks if two TopKRedis structures are equal
+// Equals checks if two TopKRedis structures are equal. TopKs with different parameters
+// aren't equal.
 func (t *TopKRedis) Equals(u *TopKRedis) (bool, error) {
-	if t.k != u.k {
-		return false, fmt.Errorf("parameter k are not equal, %d and %d", t.k, u.k)
+	comparison, err := t.Compare(u)
+	return comparison.Equal(), err
+}
+
+// Compare compares two TopKRedis structures and returns why they aren't equal, if they aren't
+func (t *TopKRedis) Compare(u *TopKRedis) (Comparison, error) {
+	comparison := compareTopKParams(TopKParams{t.k, t.errorRate, t.accuracy}, TopKParams{u.k, u.errorRate, u.accuracy})
+	if !comparison.Equal() {
+		return comparison, nil
+	}
+	ok, err := t.sketch.Equals(u.sketch)
+	if err != nil {
+		return Comparison{}, err
+	}
+	if !ok {
+		return contentMismatch("sketches"), nil
 	}
-	if t.accuracy != u.accuracy {
-		return false, fmt.Errorf("parameter accuracy are not equal, %f and %f", t.accuracy, u.accuracy)
+	ok, err = t.compareHeaps(u.heapKey)
+	if err != nil {
+		return Comparison{}, err
 	}
-	if t.errorRate != u.errorRate {
-		return false, fmt.Errorf("parameter errorRate are not equal, %f and %f", t.errorRate, u.errorRate)
+	return compareResult(ok, "heaps"), nil
+}
+
+// Reset removes all the elements of the TopKRedis, zeroing its sketch and deleting its heap
+// in a single Lua script. Its parameters, Redis keys and metadata are kept.
+func (t *TopKRedis) Reset() error {
+	err := t.sketch.reset(t.heapKey)
+	if err != nil {
+		return err
 	}
+	t.updated.touch()
+	return nil
+}
+
+// MemoryUsage returns the estimated number of bytes used in Redis by the TopKRedis, as
+// reported by MEMORY USAGE for its heap, its metadata and its count-min sketch
+func (t *TopKRedis) MemoryUsage() (uint64, error) {
+	return redisMemoryUsage(append(t.sketch.redisKeys(), t.heapKey, t.metadataKey))
+}
 
-	if ok, _ := t.sketch.Equals(u.sketch); !ok {
-		return false, fmt.Errorf("sketches aren't equal")
+// Close releases the resources attached to the TopKRedis and its count-min sketch.
+// The data of the TopKRedis is kept in Redis. It's safe to call Close multiple times.
+func (t *TopKRedis) Close() error {
+	err := t.resources.close()
+	sketchErr := t.sketch.Close()
+	if err != nil {
+		return err
 	}
-	return t.compareHeaps(u.heapKey)
+	return sketchErr
 }
 
 // Export JSON marshals the TopKRedis and returns a byte slice containing the data
@@ -182,10 +304,12 @@ func (t *TopKRedis) Export() ([]byte, error) {
 		return nil, fmt.Errorf("gostatix: error fetching heap from redis, error: %v", err)
 	}
 	var sketch countMinSketchJSON
-	sketch.AllSum = t.sketch.allSum
+	sketch.Matrix, sketch.AllSum, err = t.sketch.getSnapshot()
+	if err != nil {
+		return nil, err
+	}
 	sketch.Columns = t.sketch.columns
 	sketch.Rows = t.sketch.rows
-	sketch.Matrix, _ = t.sketch.getMatrix()
 	sketch.Key = t.sketch.key
 	var heap []heapElementJSON
 	for i := range result {
@@ -196,19 +320,30 @@ func (t *TopKRedis) Export() ([]byte, error) {
 
 // Import JSON unmarshals the _data_ into the TopKRedis
 func (t *TopKRedis) Import(data []byte, withNewKey bool) error {
+	data, err := decompressSnapshot(data)
+	if err != nil {
+		return err
+	}
 	var topk topKJSON
-	err := json.Unmarshal(data, &topk)
+	err = json.Unmarshal(data, &topk)
 	if err != nil {
 		return fmt.Errorf("gostatix: error while unmarshalling data, error %v", err)
 	}
+	err = topk.validate()
+	if err != nil {
+		return err
+	}
+	heapKey := topk.HeapKey
+	if withNewKey {
+		heapKey, err = newRedisKey(context.Background(), "")
+		if err != nil {
+			return err
+		}
+	}
 	t.k = topk.K
 	t.accuracy = topk.Accuracy
 	t.errorRate = topk.ErrorRate
-	if withNewKey {
-		t.heapKey = util.GenerateRandomString(16)
-	} else {
-		t.heapKey = topk.HeapKey
-	}
+	t.heapKey = heapKey
 	frequencyMap := make(map[string]uint)
 	for i := range topk.Heap {
 		frequencyMap[topk.Heap[i].Value]++
@@ -221,12 +356,27 @@ func (t *TopKRedis) Import(data []byte, withNewKey bool) error {
 	if err != nil {
 		return fmt.Errorf("gostatix: error while unmarshalling data, error %v", err)
 	}
-	sketch.allSum = topk.Sketch.AllSum
+	err = getRedisClient().HSet(context.Background(), sketch.metadataKey, "allSum", topk.Sketch.AllSum).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while unmarshalling data, error %v", err)
+	}
 	sketch.setMatrix(topk.Sketch.Matrix)
 	t.sketch = sketch
+	t.updated.touch()
 	return nil
 }
 
+var topKImportScript = redis.NewScript(`
+	local key = KEYS[1]
+	local vals2 = redis.pcall('ZRANGE', key, 0, -1)
+	for i=1, #ARGV, 2 do
+		local element = ARGV[i]
+		local score = ARGV[i+1]
+		redis.call('ZADD', key, score, element)
+	end
+	return true
+`)
+
 func (t *TopKRedis) importHeap(key string, frequencyMap map[string]uint) error {
 	args := make([]interface{}, 2*len(frequencyMap))
 	i := 0
@@ -235,17 +385,7 @@ func (t *TopKRedis) importHeap(key string, frequencyMap map[string]uint) error {
 		args[i+1] = interface{}(val)
 		i = i + 2
 	}
-	importHeapScript := redis.NewScript(`
-		local key = KEYS[1]
-		local vals2 = redis.pcall('ZRANGE', key, 0, -1)
-		for i=1, #ARGV, 2 do
-			local element = ARGV[i]
-			local score = ARGV[i+1]
-			redis.call('ZADD', key, score, element)
-		end
-		return true
-	`)
-	_, err := importHeapScript.Run(
+	_, err := topKImportScript.Run(
 		context.Background(),
 		getRedisClient(),
 		[]string{t.heapKey},
@@ -257,26 +397,195 @@ func (t *TopKRedis) importHeap(key string, frequencyMap map[string]uint) error {
 	return nil
 }
 
-func (t *TopKRedis) compareHeaps(key string) (bool, error) {
-	equals := redis.NewScript(`
-		local key1 = KEYS[1]
-		local key2 = KEYS[2]
-		local size = ARGV[1]
-		local vals1 = redis.pcall('ZRANGE', key1, 0, -1)
-		local vals2 = redis.pcall('ZRANGE', key2, 0, -1)
-		for i=1, tonumber(size) do
-			if tonumber(vals1[i]) ~= tonumber(vals2[i]) then
-				return false
+var topKUpdateScript = redis.NewScript(`
+	local heapKey = KEYS[1]
+	local element = ARGV[1]
+	local frequency = tonumber(ARGV[2])
+	local k = tonumber(ARGV[3])
+	local heapLength = redis.call('ZCARD', heapKey)
+	if heapLength >= k then
+		local minElement = redis.call('ZRANGE', heapKey, 0, 0, 'WITHSCORES')
+		if #minElement == 0 or frequency < tonumber(minElement[2]) then
+			return 0
+		end
+	end
+	redis.call('ZADD', heapKey, 'GT', frequency, element)
+	if redis.call('ZCARD', heapKey) > k then
+		redis.call('ZPOPMIN', heapKey)
+	end
+	return 1
+`)
+
+// updateHeap adds the _element_ with its estimated _frequency_ to the sorted set at
+// _heapKey_ if the heap isn't full yet or if the _frequency_ is at least the current
+// minimum. The heap is then trimmed back to _k_ elements. The comparison and the writes
+// happen in a single Lua script so that the heap stays consistent under concurrent writers.
+// ZADD GT is used so that a writer with a stale estimate can't lower an element's score.
+func (t *TopKRedis) updateHeap(element string, frequency uint64) error {
+	err := topKUpdateScript.Run(
+		context.Background(),
+		getRedisClient(),
+		[]string{t.heapKey},
+		element,
+		frequency,
+		t.k,
+	).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while updating heap %s, error: %v", t.heapKey, err)
+	}
+	return nil
+}
+
+var topKAdjustScript = redis.NewScript(`
+	local heapKey = KEYS[1]
+	local element = ARGV[1]
+	local frequency = tonumber(ARGV[2])
+	local k = tonumber(ARGV[3])
+	if not redis.call('ZSCORE', heapKey, element) then
+		return 0
+	end
+	local minElement = redis.call('ZRANGE', heapKey, 0, 0, 'WITHSCORES')
+	if frequency == 0 or (redis.call('ZCARD', heapKey) >= k and frequency < tonumber(minElement[2])) then
+		redis.call('ZREM', heapKey, element)
+	else
+		redis.call('ZADD', heapKey, 'XX', frequency, element)
+	end
+	return 1
+`)
+
+// adjustHeap updates the score of the _element_ in the sorted set at _heapKey_ after a
+// decrement to its new estimated _frequency_, removing it if the frequency is zero or
+// below the minimum of a full heap. Elements not in the heap are left out.
+func (t *TopKRedis) adjustHeap(element string, frequency uint64) error {
+	err := topKAdjustScript.Run(
+		context.Background(),
+		getRedisClient(),
+		[]string{t.heapKey},
+		element,
+		frequency,
+		t.k,
+	).Err()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while adjusting heap %s, error: %v", t.heapKey, err)
+	}
+	return nil
+}
+
+// topKVerifyScript compares the score of each element ARGV[4...] of the heap at KEYS[1] with
+// its estimated count in the sketch at ARGV[1] of ARGV[2] rows, the columns of each element
+// following it in ARGV. If ARGV[3] is 1, the scores which differ are set to the estimates and
+// the elements estimated at zero are removed. It returns the element, the score and the
+// estimate of each discrepancy, skipping the elements which left the heap meanwhile.
+var topKVerifyScript = redis.NewScript(`
+	local cmsKey = ARGV[1]
+	local rows = tonumber(ARGV[2])
+	local repair = ARGV[3] == '1'
+	local discrepancies = {}
+	for i=4, #ARGV, rows+1 do
+		local score = redis.call('ZSCORE', KEYS[1], ARGV[i])
+		if score then
+			score = tonumber(score)
+			local estimate = 0
+			for r=1, rows do
+				local val = tonumber(redis.call('LINDEX', cmsKey .. tostring(r-1), tonumber(ARGV[i+r]))) or 0
+				if val < estimate or r == 1 then
+					estimate = val
+				end
+			end
+			if score ~= estimate then
+				table.insert(discrepancies, ARGV[i])
+				table.insert(discrepancies, tostring(score))
+				table.insert(discrepancies, tostring(estimate))
+				if repair and estimate == 0 then
+					redis.call('ZREM', KEYS[1], ARGV[i])
+				elseif repair then
+					redis.call('ZADD', KEYS[1], estimate, ARGV[i])
+				end
 			end
 		end
-		return true
-	`)
-	ok, err := equals.Run(
+	end
+	return discrepancies
+`)
+
+// Verify compares the score of each element of the heap of the TopKRedis with its estimated
+// count in the sketch and returns the discrepancies, the score as _Before_ and the estimate
+// as _After_, by decreasing absolute delta. The sketch and the heap are written one after
+// the other by the inserts, so a partial failure leaves a score out of sync with the sketch.
+// A score also falls behind its estimate when inserts of colliding elements raise it.
+func (t *TopKRedis) Verify() ([]TopKChange, error) {
+	return t.verify(false)
+}
+
+// Repair sets the score of each element of the heap of the TopKRedis to its estimated count
+// in the sketch, removing the elements estimated at zero, and returns the discrepancies it
+// fixed like Verify. Elements which aren't in the heap aren't added back.
+func (t *TopKRedis) Repair() ([]TopKChange, error) {
+	changes, err := t.verify(true)
+	if err == nil && len(changes) > 0 {
+		t.updated.touch()
+	}
+	return changes, err
+}
+
+// verify runs topKVerifyScript on the elements of the heap, repairing it if _repair_ is true
+func (t *TopKRedis) verify(repair bool) ([]TopKChange, error) {
+	ctx := context.Background()
+	elements, err := getRedisClient().ZRange(ctx, t.heapKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("gostatix: error while reading heap %s, error: %v", t.heapKey, err)
+	}
+	args := []interface{}{t.sketch.key, t.sketch.rows, repair}
+	for _, element := range elements {
+		args = append(args, element)
+		for _, column := range t.sketch.getPositions([]byte(element)) {
+			args = append(args, strconv.FormatUint(uint64(column), 10))
+		}
+	}
+	result, err := topKVerifyScript.Run(ctx, getRedisClient(), []string{t.heapKey}, args...).StringSlice()
+	if err != nil || len(result)%3 != 0 {
+		return nil, fmt.Errorf("gostatix: error while verifying heap %s, error: %v", t.heapKey, err)
+	}
+	changes := make([]TopKChange, 0, len(result)/3)
+	for i := 0; i < len(result); i += 3 {
+		score, err := strconv.ParseFloat(result[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("gostatix: invalid score %s of %s in heap %s", result[i+1], result[i], t.heapKey)
+		}
+		estimate, err := strconv.ParseFloat(result[i+2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("gostatix: invalid estimate %s of %s in sketch %s", result[i+2], result[i], t.sketch.key)
+		}
+		changes = append(changes, TopKChange{result[i], uint64(score), uint64(estimate)})
+	}
+	sortTopKChanges(changes)
+	return changes, nil
+}
+
+var topKEqualsScript = redis.NewScript(`
+	local key1 = KEYS[1]
+	local key2 = KEYS[2]
+	local size = ARGV[1]
+	local vals1 = redis.pcall('ZRANGE', key1, 0, -1)
+	local vals2 = redis.pcall('ZRANGE', key2, 0, -1)
+	for i=1, tonumber(size) do
+		if tonumber(vals1[i]) ~= tonumber(vals2[i]) then
+			return false
+		end
+	end
+	return true
+`)
+
+func (t *TopKRedis) compareHeaps(key string) (bool, error) {
+	ok, err := topKEqualsScript.Run(
 		context.Background(),
 		getRedisClient(),
 		[]string{t.heapKey, key},
 		t.k,
 	).Bool()
+	if err == redis.Nil {
+		// lua false is returned as a nil reply
+		return false, nil
+	}
 	if err != nil {
 		return false, fmt.Errorf("gostatix: error while comparing heaps %s with %s, error: %v", t.heapKey, key, err)
 	}
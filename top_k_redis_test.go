@@ -1,11 +1,15 @@
 package gostatix
 
 import (
+	"context"
 	"math/rand"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+
+	"github.com/redis/go-redis/v9"
 )
 
 func TestTopKRedisBasic(t *testing.T) {
@@ -13,7 +17,7 @@ func TestTopKRedisBasic(t *testing.T) {
 	k := uint(5)
 	errorRate := 0.001
 	delta := 0.999
-	topkSingleEntry := NewTopKRedis(k, errorRate, delta)
+	topkSingleEntry, _ := NewTopKRedis(k, errorRate, delta)
 
 	frequencyMap := make(map[string]int)
 
@@ -22,7 +26,7 @@ func TestTopKRedisBasic(t *testing.T) {
 		frequencyMap[items[i]]++
 	}
 
-	topkBatchEntry := NewTopKRedis(k, errorRate, delta)
+	topkBatchEntry, _ := NewTopKRedis(k, errorRate, delta)
 	for key, val := range frequencyMap {
 		topkBatchEntry.Insert([]byte(key), uint64(val))
 	}
@@ -45,7 +49,7 @@ func TestTopRedisKDifferentKs(t *testing.T) {
 	initMockRedis()
 	errorRate := 0.001
 	delta := 0.999
-	topk := NewTopKRedis(11, errorRate, delta)
+	topk, _ := NewTopKRedis(11, errorRate, delta)
 
 	frequencyMap := make(map[string]int)
 
@@ -65,7 +69,7 @@ func TestTopRedisKDifferentKs(t *testing.T) {
 		}
 	}
 
-	topk = NewTopKRedis(6, errorRate, delta)
+	topk, _ = NewTopKRedis(6, errorRate, delta)
 	for i := range items {
 		topk.Insert([]byte(items[i]), 1)
 	}
@@ -87,12 +91,12 @@ func TestTopKRedisEquals(t *testing.T) {
 	errorRate := 0.001
 	delta := 0.999
 
-	k := NewTopKRedis(10, errorRate, delta)
+	k, _ := NewTopKRedis(10, errorRate, delta)
 	for i := 0; i < 10; i++ {
 		k.Insert([]byte(items[i]), 1)
 	}
 
-	l := NewTopKRedis(10, errorRate, delta)
+	l, _ := NewTopKRedis(10, errorRate, delta)
 	for i := 0; i < 10; i++ {
 		l.Insert([]byte(items[i]), 1)
 	}
@@ -107,12 +111,12 @@ func TestTopKRedisImportKey(t *testing.T) {
 	errorRate := 0.001
 	delta := 0.999
 
-	k := NewTopKRedis(10, errorRate, delta)
+	k, _ := NewTopKRedis(10, errorRate, delta)
 	for i := 0; i < 10; i++ {
 		k.Insert([]byte(items[i]), 1)
 	}
 
-	l := NewTopKRedisFromKey(k.MetadataKey())
+	l, _ := NewTopKRedisFromKey(k.MetadataKey())
 
 	if ok, _ := l.Equals(k); !ok {
 		t.Errorf("topk k and l should be equal")
@@ -124,19 +128,19 @@ func TestTopKRedisImportExport(t *testing.T) {
 	errorRate := 0.1
 	delta := 0.9
 
-	k := NewTopKRedis(5, errorRate, delta)
+	k, _ := NewTopKRedis(5, errorRate, delta)
 	for i := 0; i < 10; i++ {
 		k.Insert([]byte(items[i]), 1)
 	}
 
-	l := NewTopKRedis(5, errorRate, delta)
+	l, _ := NewTopKRedis(5, errorRate, delta)
 	for i := 0; i < 10; i++ {
 		l.Insert([]byte(items[i]), 1)
 	}
 
 	s, _ := k.Export()
 
-	m := NewTopKRedis(10, errorRate, delta)
+	m, _ := NewTopKRedis(10, errorRate, delta)
 	m.Import(s, true)
 
 	if ok, _ := m.Equals(k); !ok {
@@ -144,11 +148,46 @@ func TestTopKRedisImportExport(t *testing.T) {
 	}
 }
 
+func TestTopKRedisConcurrentInsert(t *testing.T) {
+	initMockRedis()
+	k := uint(5)
+	topk, _ := NewTopKRedis(k, 0.001, 0.999)
+
+	frequencyMap := make(map[string]int)
+	for i := range items {
+		frequencyMap[items[i]]++
+	}
+
+	var wg sync.WaitGroup
+	workers := 4
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			worker, _ := NewTopKRedisFromKey(topk.MetadataKey())
+			for i := w; i < len(items); i += workers {
+				worker.Insert([]byte(items[i]), 1)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	values, _ := topk.Values()
+	if uint(len(values)) != k {
+		t.Errorf("heap should have %d elements, found %d", k, len(values))
+	}
+	for i := range values {
+		if values[i].count != uint64(frequencyMap[values[i].element]) {
+			t.Errorf("frequency doesn't match for %s. Instead found %d and %d", values[i].element, values[i].count, frequencyMap[values[i].element])
+		}
+	}
+}
+
 func BenchmarkTopKRedisInsert100X1M(b *testing.B) {
 	b.StopTimer()
 	connOpts, _ := ParseRedisURI("redis://127.0.0.1:6379")
 	MakeRedisClient(*connOpts)
-	topk := NewTopKRedis(100, 0.001, 0.999)
+	topk, _ := NewTopKRedis(100, 0.001, 0.999)
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
 		topk.Insert([]byte(strconv.FormatUint(rand.Uint64(), 10)), 1)
@@ -159,7 +198,7 @@ func BenchmarkTopKRedisValues100X1M(b *testing.B) {
 	b.StopTimer()
 	connOpts, _ := ParseRedisURI("redis://127.0.0.1:6379")
 	MakeRedisClient(*connOpts)
-	topk := NewTopKRedis(100, 0.001, 0.999)
+	topk, _ := NewTopKRedis(100, 0.001, 0.999)
 	for i := 0; i < 1000000; i++ {
 		topk.Insert([]byte(strconv.FormatUint(rand.Uint64(), 10)), 1)
 	}
@@ -173,7 +212,7 @@ func BenchmarkTopKRedisValues10kX1M(b *testing.B) {
 	b.StopTimer()
 	connOpts, _ := ParseRedisURI("redis://127.0.0.1:6379")
 	MakeRedisClient(*connOpts)
-	topk := NewTopKRedis(1000, 0.0001, 0.9999)
+	topk, _ := NewTopKRedis(1000, 0.0001, 0.9999)
 	for i := 0; i < 100000; i++ {
 		topk.Insert([]byte(strconv.FormatUint(rand.Uint64(), 10)), 1)
 	}
@@ -182,3 +221,163 @@ func BenchmarkTopKRedisValues10kX1M(b *testing.B) {
 		topk.Values()
 	}
 }
+
+func TestTopKRedisDecrementRemove(t *testing.T) {
+	initMockRedis()
+	topk, _ := NewTopKRedis(3, 0.001, 0.999)
+	topk.InsertString("apple", 10)
+	topk.InsertString("banana", 7)
+	topk.InsertString("carrot", 5)
+	topk.InsertString("grape", 2)
+
+	topk.Decrement([]byte("apple"), 4)
+	expected := []TopKElement{{"banana", 7}, {"apple", 6}, {"carrot", 5}}
+	if values, _ := topk.Values(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("apple should be adjusted to 6, got %v", values)
+	}
+	topk.Decrement([]byte("carrot"), 4)
+	expected = []TopKElement{{"banana", 7}, {"apple", 6}}
+	if values, _ := topk.Values(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("carrot should be evicted below the minimum, got %v", values)
+	}
+	topk.InsertString("grape", 1)
+	err := topk.Remove([]byte("banana"))
+	if err != nil {
+		t.Fatalf("remove shouldn't error out, error: %v", err)
+	}
+	if count, _ := topk.sketch.CountString("banana"); count != 0 {
+		t.Error("banana should be removed from the sketch")
+	}
+	topk.Decrement([]byte("grape"), 10)
+	if count, _ := topk.sketch.CountString("grape"); count != 0 {
+		t.Error("count of grape shouldn't go below zero")
+	}
+	expected = []TopKElement{{"apple", 6}}
+	if values, _ := topk.Values(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("only apple should be left, got %v", values)
+	}
+	if total, _ := topk.sketch.TotalCount(); total != 7 {
+		t.Errorf("total count should be 7, got %d", total)
+	}
+}
+
+func TestTopKRedisReset(t *testing.T) {
+	initMockRedis()
+	topk, _ := NewTopKRedis(3, 0.001, 0.999)
+	topk.InsertString("apple", 5)
+	err := topk.Reset()
+	if err != nil {
+		t.Fatalf("reset shouldn't error out, error: %v", err)
+	}
+	if values, _ := topk.Values(); len(values) != 0 {
+		t.Errorf("topk should be empty after reset, got %v", values)
+	}
+	topk.InsertString("banana", 2)
+	if values, _ := topk.Values(); len(values) != 1 || values[0].Count() != 2 {
+		t.Errorf("banana should be inserted after reset, got %v", values)
+	}
+}
+
+func TestTopKRedisMatchesInMemory(t *testing.T) {
+	initMockRedis()
+	// a coarse sketch overestimates the counts, the heaps hold the estimates
+	memTopK, _ := NewTopK(10, 0.05, 0.9)
+	redisTopK, _ := NewTopKRedis(10, 0.05, 0.9)
+	random := rand.New(rand.NewSource(7))
+	counts := make(map[string]uint64)
+	for i := 0; i < 2000; i++ {
+		element := "item" + strconv.Itoa(int(random.ExpFloat64()*20))
+		count := uint64(1 + random.Intn(3))
+		counts[element] += count
+		memTopK.InsertString(element, count)
+		err := redisTopK.InsertString(element, count)
+		if err != nil {
+			t.Fatalf("insert shouldn't error out, error: %v", err)
+		}
+	}
+	memValues := memTopK.Values()
+	redisValues, err := redisTopK.Values()
+	if err != nil {
+		t.Fatalf("values shouldn't error out, error: %v", err)
+	}
+	if !reflect.DeepEqual(memValues, redisValues) {
+		t.Errorf("in-memory and redis topk should hold the same elements, got %v and %v", memValues, redisValues)
+	}
+	// the score is the estimate at the last insert of the element, which later colliding
+	// inserts can raise
+	overestimated := false
+	for _, value := range redisValues {
+		estimate, _ := redisTopK.sketch.CountString(value.Element())
+		if value.Count() < counts[value.Element()] || value.Count() > estimate {
+			t.Errorf("score of %s should be between its count %d and its estimate %d, got %d", value.Element(), counts[value.Element()], estimate, value.Count())
+		}
+		overestimated = overestimated || value.Count() > counts[value.Element()]
+	}
+	if !overestimated {
+		t.Errorf("scores of a coarse sketch should include overestimated counts")
+	}
+}
+
+func TestTopKRedisReadYourWrites(t *testing.T) {
+	initMockRedis()
+	topk, _ := NewTopKRedis(3, 0.001, 0.999)
+	counts := make(map[string]uint64)
+	for i, element := range []string{"apple", "banana", "apple", "cherry", "banana", "apple"} {
+		counts[element] += uint64(i + 1)
+		err := topk.InsertString(element, uint64(i+1))
+		if err != nil {
+			t.Fatalf("insert shouldn't error out, error: %v", err)
+		}
+		values, _ := topk.Values()
+		found := false
+		for _, value := range values {
+			if value.Element() == element {
+				found = value.Count() == counts[element]
+			}
+		}
+		if !found {
+			t.Errorf("%s should be read with count %d right after its insert, got %v", element, counts[element], values)
+		}
+	}
+	loaded, err := NewTopKRedisFromKey(topk.MetadataKey())
+	if err != nil {
+		t.Fatalf("topk loading shouldn't error out, error: %v", err)
+	}
+	loaded.InsertString("date", 20)
+	if values, _ := topk.Values(); len(values) != 3 || values[0].Element() != "date" {
+		t.Errorf("insert through another handle should be read, got %v", values)
+	}
+}
+
+func TestTopKRedisRepair(t *testing.T) {
+	initMockRedis()
+	topk, _ := NewTopKRedis(3, 0.001, 0.999)
+	topk.InsertString("apple", 5)
+	topk.InsertString("banana", 3)
+	topk.InsertString("cherry", 2)
+	if changes, err := topk.Verify(); err != nil || len(changes) != 0 {
+		t.Fatalf("heap in sync with the sketch shouldn't have discrepancies, got %v, error: %v", changes, err)
+	}
+	// a partial insert updating the sketch only and a stale score written by hand
+	topk.sketch.UpdateString("banana", 4)
+	getRedisClient().ZAdd(context.Background(), topk.heapKey, redis.Z{Score: 9, Member: "apple"})
+	topk.sketch.decrement([]byte("cherry"), 2)
+	expected := []TopKChange{{"apple", 9, 5}, {"banana", 3, 7}, {"cherry", 2, 0}}
+	changes, err := topk.Verify()
+	if err != nil || !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("discrepancies should be %v, got %v, error: %v", expected, changes, err)
+	}
+	if values, _ := topk.Values(); values[0].Element() != "apple" || values[0].Count() != 9 {
+		t.Errorf("verify shouldn't change the heap, got %v", values)
+	}
+	changes, err = topk.Repair()
+	if err != nil || !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("repaired discrepancies should be %v, got %v, error: %v", expected, changes, err)
+	}
+	if values, _ := topk.Values(); !reflect.DeepEqual(values, []TopKElement{{"banana", 7}, {"apple", 5}}) {
+		t.Errorf("heap should be rescored from the sketch, got %v", values)
+	}
+	if changes, err := topk.Verify(); err != nil || len(changes) != 0 {
+		t.Errorf("repaired heap shouldn't have discrepancies, got %v, error: %v", changes, err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -61,7 +62,7 @@ func TestTopKBasic(t *testing.T) {
 	k := uint(11)
 	errorRate := 0.001
 	delta := 0.999
-	topkSingleEntry := NewTopK(k, errorRate, delta)
+	topkSingleEntry, _ := NewTopK(k, errorRate, delta)
 
 	frequencyMap := make(map[string]int)
 
@@ -70,7 +71,7 @@ func TestTopKBasic(t *testing.T) {
 		frequencyMap[items[i]]++
 	}
 
-	topkBatchEntry := NewTopK(k, errorRate, delta)
+	topkBatchEntry, _ := NewTopK(k, errorRate, delta)
 	for key, val := range frequencyMap {
 		topkBatchEntry.Insert([]byte(key), uint64(val))
 	}
@@ -92,7 +93,7 @@ func TestTopKBasic(t *testing.T) {
 func TestTopKDifferentKs(t *testing.T) {
 	errorRate := 0.001
 	delta := 0.999
-	topk := NewTopK(15, errorRate, delta)
+	topk, _ := NewTopK(15, errorRate, delta)
 
 	frequencyMap := make(map[string]int)
 
@@ -112,7 +113,7 @@ func TestTopKDifferentKs(t *testing.T) {
 		}
 	}
 
-	topk = NewTopK(3, errorRate, delta)
+	topk, _ = NewTopK(3, errorRate, delta)
 	for i := range items {
 		topk.Insert([]byte(items[i]), 1)
 	}
@@ -133,12 +134,12 @@ func TestTopKEquals(t *testing.T) {
 	errorRate := 0.001
 	delta := 0.999
 
-	k := NewTopK(10, errorRate, delta)
+	k, _ := NewTopK(10, errorRate, delta)
 	for i := 0; i < 10; i++ {
 		k.Insert([]byte(items[i]), 1)
 	}
 
-	l := NewTopK(10, errorRate, delta)
+	l, _ := NewTopK(10, errorRate, delta)
 	for i := 0; i < 10; i++ {
 		l.Insert([]byte(items[i]), 1)
 	}
@@ -152,12 +153,12 @@ func TestTopKImportExport(t *testing.T) {
 	errorRate := 0.001
 	delta := 0.999
 
-	k := NewTopK(5, errorRate, delta)
+	k, _ := NewTopK(5, errorRate, delta)
 	for i := 0; i < 10; i++ {
 		k.Insert([]byte(items[i]), 1)
 	}
 
-	l := NewTopK(5, errorRate, delta)
+	l, _ := NewTopK(5, errorRate, delta)
 	for i := 0; i < 10; i++ {
 		l.Insert([]byte(items[i]), 1)
 	}
@@ -169,7 +170,7 @@ func TestTopKImportExport(t *testing.T) {
 		t.Errorf("topk l and k should be equal")
 	}
 
-	m := NewTopK(10, errorRate, delta)
+	m, _ := NewTopK(10, errorRate, delta)
 	m.Import(s)
 
 	if ok, _ := m.Equals(k); !ok {
@@ -181,7 +182,7 @@ func TestTopKBinaryReadWrite(t *testing.T) {
 	errorRate := 0.001
 	delta := 0.999
 
-	k := NewTopK(5, errorRate, delta)
+	k, _ := NewTopK(5, errorRate, delta)
 	for i := 0; i < 10; i++ {
 		k.Insert([]byte(items[i]), 1)
 	}
@@ -203,9 +204,31 @@ func TestTopKBinaryReadWrite(t *testing.T) {
 	}
 }
 
+func TestTopKBinaryReadWritePartialHeap(t *testing.T) {
+	k, _ := NewTopK(10, 0.001, 0.999)
+	k.Insert([]byte("foo"), 3)
+	k.Insert([]byte("bar"), 1)
+
+	var buff bytes.Buffer
+	_, err := k.WriteTo(&buff)
+	if err != nil {
+		t.Error("should not error out writing to buffer")
+	}
+
+	l := &TopK{}
+	_, err = l.ReadFrom(&buff)
+	if err != nil {
+		t.Error("should not error out reading from buffer")
+	}
+
+	if !reflect.DeepEqual(k.Values(), l.Values()) {
+		t.Errorf("values should be equal, found %v and %v", k.Values(), l.Values())
+	}
+}
+
 func BenchmarkTopKInsert100X1M(b *testing.B) {
 	b.StopTimer()
-	topk := NewTopK(100, 0.001, 0.999)
+	topk, _ := NewTopK(100, 0.001, 0.999)
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
 		topk.Insert([]byte(strconv.FormatUint(rand.Uint64(), 10)), 1)
@@ -214,7 +237,7 @@ func BenchmarkTopKInsert100X1M(b *testing.B) {
 
 func BenchmarkTopKValues100X1M(b *testing.B) {
 	b.StopTimer()
-	topk := NewTopK(100, 0.001, 0.999)
+	topk, _ := NewTopK(100, 0.001, 0.999)
 	for i := 0; i < 1000000; i++ {
 		topk.Insert([]byte(strconv.FormatUint(rand.Uint64(), 10)), 1)
 	}
@@ -226,7 +249,7 @@ func BenchmarkTopKValues100X1M(b *testing.B) {
 
 func BenchmarkTopKValues10kX1M(b *testing.B) {
 	b.StopTimer()
-	topk := NewTopK(10000, 0.0001, 0.9999)
+	topk, _ := NewTopK(10000, 0.0001, 0.9999)
 	for i := 0; i < 10000000; i++ {
 		topk.Insert([]byte(strconv.FormatUint(rand.Uint64(), 10)), 1)
 	}
@@ -235,3 +258,88 @@ func BenchmarkTopKValues10kX1M(b *testing.B) {
 		topk.Values()
 	}
 }
+
+func TestTopKDecrementRemove(t *testing.T) {
+	topk, _ := NewTopK(3, 0.001, 0.999)
+	topk.InsertString("apple", 10)
+	topk.InsertString("banana", 7)
+	topk.InsertString("carrot", 5)
+	topk.InsertString("grape", 2)
+
+	topk.Decrement([]byte("apple"), 4)
+	expected := []TopKElement{{"banana", 7}, {"apple", 6}, {"carrot", 5}}
+	if values := topk.Values(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("apple should be adjusted to 6, got %v", values)
+	}
+	topk.Decrement([]byte("carrot"), 4)
+	expected = []TopKElement{{"banana", 7}, {"apple", 6}}
+	if values := topk.Values(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("carrot should be evicted below the minimum, got %v", values)
+	}
+	topk.InsertString("grape", 1)
+	topk.Remove([]byte("banana"))
+	if topk.sketch.CountString("banana") != 0 {
+		t.Error("banana should be removed from the sketch")
+	}
+	topk.Decrement([]byte("grape"), 10)
+	if topk.sketch.CountString("grape") != 0 {
+		t.Error("count of grape shouldn't go below zero")
+	}
+	expected = []TopKElement{{"apple", 6}}
+	if values := topk.Values(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("only apple should be left, got %v", values)
+	}
+}
+
+func TestTopKReset(t *testing.T) {
+	topk, _ := NewTopK(3, 0.001, 0.999)
+	topk.InsertString("apple", 5)
+	topk.Reset()
+	if len(topk.Values()) != 0 || topk.sketch.CountString("apple") != 0 {
+		t.Error("topk should be empty after reset")
+	}
+	topk.InsertString("banana", 2)
+	if values := topk.Values(); len(values) != 1 || values[0].Count() != 2 {
+		t.Errorf("banana should be inserted after reset, got %v", values)
+	}
+}
+
+func TestTopKConcurrentInsertAndRead(t *testing.T) {
+	k := uint(5)
+	topk, _ := NewTopK(k, 0.001, 0.999)
+
+	frequencyMap := make(map[string]int)
+	for i := range items {
+		frequencyMap[items[i]]++
+	}
+
+	var wg sync.WaitGroup
+	workers := 4
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(items); i += workers {
+				topk.Insert([]byte(items[i]), 1)
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			var buf bytes.Buffer
+			topk.Values()
+			topk.Export()
+			topk.WriteTo(&buf)
+		}()
+	}
+	wg.Wait()
+
+	values := topk.Values()
+	if uint(len(values)) != k {
+		t.Errorf("heap should have %d elements, found %d", k, len(values))
+	}
+	for i := range values {
+		if values[i].count != uint64(frequencyMap[values[i].element]) {
+			t.Errorf("frequency doesn't match for %s. Instead found %d and %d", values[i].element, values[i].count, frequencyMap[values[i].element])
+		}
+	}
+}
@@ -0,0 +1,347 @@
+/*
+Implements the write-ahead logging of the inserts and removals of in-memory filters, which
+makes them durable without the latency of a Redis backend.
+
+Every operation is appended to an io.Writer before being applied to the filter. After a
+crash, the filter is rebuilt by importing its last snapshot and replaying the operations
+logged after it. Compaction saves a snapshot of the filter and starts the log over, so the
+log doesn't grow without bounds.
+
+Each record of the log holds its sequence number (uvarint), the operation (1 byte), the
+length of the element (uvarint), the element, and the CRC-32 (IEEE, 4 bytes big endian)
+of all the preceding bytes of the record. A snapshot holds the sequence number of the last
+operation applied to the filter (8 bytes big endian) followed by the export of the filter,
+so that the records of an old log replayed after a snapshot are skipped.
+*/
+package gostatix
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// operations logged in a write-ahead log
+const (
+	walInsert            = 1
+	walInsertDestructive = 2
+	walRemove            = 3
+)
+
+// walFilter is implemented by the filters which can be write-ahead logged
+type walFilter interface {
+	Snapshottable
+	// apply applies the logged operation _op_ on _data_ to the filter and returns its result
+	apply(op byte, data []byte) (bool, error)
+}
+
+// writeAheadLog appends the operations on a filter to _writer_ and applies them
+// _seq_ is the sequence number of the last operation logged
+// _lock_ serializes the operations, so that they're logged in the order they're applied
+type writeAheadLog struct {
+	filter walFilter
+	writer io.Writer
+	seq    uint64
+	buf    []byte
+	lock   sync.Mutex
+}
+
+// log appends the operation _op_ on _data_ to the log, applies it to the filter and returns
+// its result. The operation isn't applied if it can't be logged.
+func (wal *writeAheadLog) log(op byte, data []byte) (bool, error) {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	buf := binary.AppendUvarint(wal.buf[:0], wal.seq+1)
+	buf = append(buf, op)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	buf = append(buf, data...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+	wal.buf = buf
+	_, err := wal.writer.Write(buf)
+	if err != nil {
+		return false, fmt.Errorf("gostatix: error while writing to write-ahead log, error: %v", err)
+	}
+	wal.seq++
+	return wal.filter.apply(op, data)
+}
+
+// Sync commits the log to stable storage if its writer has a Sync method, like an *os.File
+func (wal *writeAheadLog) Sync() error {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	if syncer, ok := wal.writer.(interface{ Sync() error }); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+// Compact saves a snapshot of the filter to _sink_ and starts the log over in _next_, so
+// that the operations logged so far can be discarded. If _next_ is nil, the current writer
+// is truncated instead, which then has to implement Truncate(size int64) error like an
+// *os.File opened with os.O_APPEND. If the process crashes before the log is started over,
+// replaying the old log after the snapshot skips the operations it already holds.
+func (wal *writeAheadLog) Compact(sink SnapshotSink, next io.Writer) error {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	truncater, ok := wal.writer.(interface{ Truncate(size int64) error })
+	if next == nil && !ok {
+		return fmt.Errorf("gostatix: write-ahead log can't be truncated, a next writer is needed")
+	}
+	data, err := wal.filter.Export()
+	if err != nil {
+		return fmt.Errorf("gostatix: error while exporting snapshot, error: %v", err)
+	}
+	snapshot := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(data)), wal.seq)
+	err = sink.Save(append(snapshot, data...))
+	if err != nil {
+		return err
+	}
+	if next != nil {
+		wal.writer = next
+		return nil
+	}
+	err = truncater.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("gostatix: error while truncating write-ahead log, error: %v", err)
+	}
+	return nil
+}
+
+// Recover rebuilds the filter from the last snapshot saved by Compact in _sink_, if any,
+// and the operations logged after it in _logs_, read in order, e.g. the current log and the
+// ones not discarded after a compaction. It returns the number of operations replayed. A
+// record cut short at the end of a log, as written during a crash, is ignored. Logging
+// continues after the last sequence number recovered.
+func (wal *writeAheadLog) Recover(sink SnapshotSink, logs ...io.Reader) (uint64, error) {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	var seq uint64
+	if sink != nil {
+		snapshot, err := sink.Load()
+		if err != nil {
+			return 0, err
+		}
+		if snapshot != nil {
+			if len(snapshot) < 8 {
+				return 0, fmt.Errorf("gostatix: invalid write-ahead log snapshot of %d bytes", len(snapshot))
+			}
+			err = wal.filter.Import(snapshot[8:])
+			if err != nil {
+				return 0, fmt.Errorf("gostatix: error while importing write-ahead log snapshot, error: %v", err)
+			}
+			seq = binary.BigEndian.Uint64(snapshot)
+		}
+	}
+	var replayed uint64
+	for _, log := range logs {
+		err := readWALRecords(log, func(recordSeq uint64, op byte, data []byte) error {
+			if recordSeq <= seq {
+				return nil
+			}
+			seq = recordSeq
+			replayed++
+			_, err := wal.filter.apply(op, data)
+			return err
+		})
+		if err != nil {
+			return replayed, err
+		}
+	}
+	wal.seq = seq
+	return replayed, nil
+}
+
+// readWALRecords reads the records of the log _stream_ and calls _fn_ with each of them
+func readWALRecords(stream io.Reader, fn func(seq uint64, op byte, data []byte) error) error {
+	reader := bufio.NewReader(stream)
+	var record []byte
+	for {
+		record = record[:0]
+		seq, err := readWALUvarint(reader, &record)
+		if err == io.EOF && len(record) == 0 {
+			return nil
+		}
+		if err != nil {
+			return walReadError(err)
+		}
+		op, err := reader.ReadByte()
+		if err != nil {
+			return walReadError(err)
+		}
+		record = append(record, op)
+		length, err := readWALUvarint(reader, &record)
+		if err != nil {
+			return walReadError(err)
+		}
+		err = checkSnapshotSize("write-ahead log record", length, 1)
+		if err != nil {
+			return err
+		}
+		start := len(record)
+		record = append(record, make([]byte, length+4)...)
+		_, err = io.ReadFull(reader, record[start:])
+		if err != nil {
+			return walReadError(err)
+		}
+		sumAt := len(record) - 4
+		if binary.BigEndian.Uint32(record[sumAt:]) != crc32.ChecksumIEEE(record[:sumAt]) {
+			return fmt.Errorf("gostatix: write-ahead log record %d doesn't match its checksum", seq)
+		}
+		if op < walInsert || op > walRemove {
+			return fmt.Errorf("gostatix: unknown operation %d in write-ahead log record %d", op, seq)
+		}
+		err = fn(seq, op, record[start:sumAt])
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readWALUvarint reads a uvarint from _reader_ and appends its bytes to _record_
+func readWALUvarint(reader *bufio.Reader, record *[]byte) (uint64, error) {
+	var value uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		*record = append(*record, b)
+		value |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("gostatix: invalid varint in write-ahead log")
+}
+
+// walReadError ignores a record cut short at the end of a log and wraps the other errors
+func walReadError(err error) error {
+	if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil
+	}
+	return fmt.Errorf("gostatix: error while reading write-ahead log, error: %v", err)
+}
+
+// BloomFilterWAL is an in-memory BloomFilter whose inserts are logged to an io.Writer
+// before being applied. The filter can be read directly, but inserting in it directly
+// bypasses the log.
+type BloomFilterWAL struct {
+	writeAheadLog
+	bloomFilter *BloomFilter
+}
+
+// WithWAL returns a BloomFilterWAL logging the inserts in the in-memory bloom filter to
+// _writer_. It errors out for a Redis backed bloom filter.
+func (bloomFilter *BloomFilter) WithWAL(writer io.Writer) (*BloomFilterWAL, error) {
+	if !isBitSetInProcess(bloomFilter.filter) {
+		return nil, fmt.Errorf("gostatix: write-ahead log is only supported for in-memory bloom filter")
+	}
+	if writer == nil {
+		return nil, fmt.Errorf("gostatix: writer of write-ahead log can't be nil")
+	}
+	wal := &BloomFilterWAL{bloomFilter: bloomFilter}
+	wal.filter = bloomFilter
+	wal.writer = writer
+	return wal, nil
+}
+
+// Filter returns the logged BloomFilter
+func (wal *BloomFilterWAL) Filter() *BloomFilter {
+	return wal.bloomFilter
+}
+
+// Insert logs the insert of _data_ and inserts it in the bloom filter
+func (wal *BloomFilterWAL) Insert(data []byte) error {
+	_, err := wal.log(walInsert, data)
+	return err
+}
+
+// InsertString logs the insert of _data_ and inserts it in the bloom filter
+func (wal *BloomFilterWAL) InsertString(data string) error {
+	return wal.Insert([]byte(data))
+}
+
+// Lookup returns true if _data_ may be in the bloom filter
+func (wal *BloomFilterWAL) Lookup(data []byte) bool {
+	return wal.bloomFilter.Lookup(data)
+}
+
+// LookupString returns true if _data_ may be in the bloom filter
+func (wal *BloomFilterWAL) LookupString(data string) bool {
+	return wal.bloomFilter.LookupString(data)
+}
+
+func (bloomFilter *BloomFilter) apply(op byte, data []byte) (bool, error) {
+	if op != walInsert {
+		return false, fmt.Errorf("gostatix: operation %d can't be applied to a bloom filter", op)
+	}
+	bloomFilter.Insert(data)
+	return true, nil
+}
+
+// CuckooFilterWAL is an in-memory CuckooFilter whose inserts and removals are logged to an
+// io.Writer before being applied. The filter can be read directly, but updating it directly
+// bypasses the log. The evictions of destructive inserts are random, so a replayed
+// destructive insert may evict a different fingerprint.
+type CuckooFilterWAL struct {
+	writeAheadLog
+	cuckooFilter *CuckooFilter
+}
+
+// WithWAL returns a CuckooFilterWAL logging the inserts and removals of the cuckoo filter
+// to _writer_
+func (cuckooFilter *CuckooFilter) WithWAL(writer io.Writer) (*CuckooFilterWAL, error) {
+	if writer == nil {
+		return nil, fmt.Errorf("gostatix: writer of write-ahead log can't be nil")
+	}
+	wal := &CuckooFilterWAL{cuckooFilter: cuckooFilter}
+	wal.filter = cuckooFilter
+	wal.writer = writer
+	return wal, nil
+}
+
+// Filter returns the logged CuckooFilter
+func (wal *CuckooFilterWAL) Filter() *CuckooFilter {
+	return wal.cuckooFilter
+}
+
+// Insert logs the insert of _data_ and inserts it in the cuckoo filter. It returns false
+// if the filter is full.
+func (wal *CuckooFilterWAL) Insert(data []byte, destructive bool) (bool, error) {
+	op := byte(walInsert)
+	if destructive {
+		op = walInsertDestructive
+	}
+	return wal.log(op, data)
+}
+
+// Remove logs the removal of _data_ and removes it from the cuckoo filter. It returns false
+// if _data_ wasn't found, like CuckooFilter.Remove.
+func (wal *CuckooFilterWAL) Remove(data []byte) (bool, error) {
+	return wal.log(walRemove, data)
+}
+
+// Lookup returns true if _data_ may be in the cuckoo filter
+func (wal *CuckooFilterWAL) Lookup(data []byte) bool {
+	return wal.cuckooFilter.Lookup(data)
+}
+
+func (cuckooFilter *CuckooFilter) apply(op byte, data []byte) (bool, error) {
+	switch op {
+	case walInsert, walInsertDestructive:
+		// a full filter fails the insert the same way when it's replayed
+		_, err := cuckooFilter.InsertWithStats(data, op == walInsertDestructive, 0)
+		return err == nil, nil
+	case walRemove:
+		return cuckooFilter.Remove(data), nil
+	}
+	return false, fmt.Errorf("gostatix: operation %d can't be applied to a cuckoo filter", op)
+}
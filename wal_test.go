@@ -0,0 +1,136 @@
+package gostatix
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestBloomFilterWALRecover(t *testing.T) {
+	var log bytes.Buffer
+	filter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	wal, err := filter.WithWAL(&log)
+	if err != nil {
+		t.Fatalf("write-ahead log creation shouldn't error out, error: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := wal.InsertString(strconv.Itoa(i)); err != nil {
+			t.Fatalf("insert shouldn't error out, error: %v", err)
+		}
+	}
+	if !wal.LookupString("42") || !filter.LookupString("42") {
+		t.Errorf("logged inserts should be applied to the filter")
+	}
+
+	// the last record is cut short by a crash
+	data := log.Bytes()[:log.Len()-3]
+	recovered, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	recoveredWAL, _ := recovered.WithWAL(&bytes.Buffer{})
+	replayed, err := recoveredWAL.Recover(nil, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("recover shouldn't error out, error: %v", err)
+	}
+	if replayed != 99 {
+		t.Errorf("all the complete records should be replayed, got %d", replayed)
+	}
+	for i := 0; i < 99; i++ {
+		if !recovered.LookupString(strconv.Itoa(i)) {
+			t.Errorf("%d should be found in the recovered filter", i)
+		}
+	}
+
+	data = append([]byte(nil), log.Bytes()...)
+	// the element of the second record is corrupted
+	data[11] ^= 0xff
+	corrupt, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	corruptWAL, _ := corrupt.WithWAL(&bytes.Buffer{})
+	if _, err := corruptWAL.Recover(nil, bytes.NewReader(data)); err == nil {
+		t.Errorf("recover of a corrupt record should error out")
+	}
+
+	initMockRedis()
+	redisFilter, _ := NewRedisBloomFilterWithParameters(100, 0.01)
+	if _, err := redisFilter.WithWAL(&log); err == nil {
+		t.Errorf("write-ahead log of a redis backed filter should error out")
+	}
+}
+
+func TestBloomFilterWALCompact(t *testing.T) {
+	dir := t.TempDir()
+	file, err := os.OpenFile(filepath.Join(dir, "wal"), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("log file creation shouldn't error out, error: %v", err)
+	}
+	defer file.Close()
+	sink := NewFileSnapshotSink(filepath.Join(dir, "snapshot"))
+	filter, _ := NewMemBloomFilterWithParameters(1000, 0.01)
+	wal, _ := filter.WithWAL(file)
+	for i := 0; i < 50; i++ {
+		wal.InsertString(strconv.Itoa(i))
+	}
+	oldLog, _ := os.ReadFile(file.Name())
+	if err := wal.Compact(sink, nil); err != nil {
+		t.Fatalf("compaction shouldn't error out, error: %v", err)
+	}
+	if info, _ := file.Stat(); info.Size() != 0 {
+		t.Errorf("log should be truncated by the compaction, got %d bytes", info.Size())
+	}
+	for i := 50; i < 60; i++ {
+		wal.InsertString(strconv.Itoa(i))
+	}
+	wal.Sync()
+	newLog, _ := os.ReadFile(file.Name())
+
+	// the old log is still around after a crash during the compaction
+	recovered, _ := NewMemBloomFilterWithParameters(10, 0.1)
+	recoveredWAL, _ := recovered.WithWAL(&bytes.Buffer{})
+	replayed, err := recoveredWAL.Recover(sink, bytes.NewReader(oldLog), bytes.NewReader(newLog))
+	if err != nil {
+		t.Fatalf("recover shouldn't error out, error: %v", err)
+	}
+	if replayed != 10 {
+		t.Errorf("only the records after the snapshot should be replayed, got %d", replayed)
+	}
+	if equal, _ := recovered.Equals(filter); !equal {
+		t.Errorf("recovered filter should be equal to the logged one")
+	}
+	if recoveredWAL.seq != 60 {
+		t.Errorf("logging should continue after the recovered sequence number, got %d", recoveredWAL.seq)
+	}
+	if err := recoveredWAL.Compact(sink, nil); err == nil {
+		t.Errorf("compaction of a log which can't be truncated should error out")
+	}
+}
+
+func TestCuckooFilterWAL(t *testing.T) {
+	var log bytes.Buffer
+	filter, _ := NewCuckooFilter(100, 4, 8)
+	wal, _ := filter.WithWAL(&log)
+	for i := 0; i < 20; i++ {
+		wal.Insert([]byte(strconv.Itoa(i)), false)
+	}
+	removed, err := wal.Remove([]byte("3"))
+	if !removed || err != nil {
+		t.Fatalf("removal should succeed, error: %v", err)
+	}
+	if removed, _ := wal.Remove([]byte("missing")); removed {
+		t.Errorf("removal of a missing element should return false")
+	}
+	full, _ := NewCuckooFilterWithRetries(1, 1, 8, 1)
+	fullWAL, _ := full.WithWAL(&bytes.Buffer{})
+	fullWAL.Insert([]byte("foo"), false)
+	if inserted, err := fullWAL.Insert([]byte("bar"), false); inserted || err != nil {
+		t.Errorf("insert in a full filter should return false, error: %v", err)
+	}
+	recovered, _ := NewCuckooFilter(100, 4, 8)
+	recoveredWAL, _ := recovered.WithWAL(&bytes.Buffer{})
+	replayed, err := recoveredWAL.Recover(nil, &log)
+	if err != nil || replayed != 22 {
+		t.Fatalf("all the records should be replayed, got %d, error: %v", replayed, err)
+	}
+	if recovered.Length() != 19 || recovered.LookupString("3") || !recovered.LookupString("4") {
+		t.Errorf("recovered filter should have the inserts and removals replayed, got length %d", recovered.Length())
+	}
+}